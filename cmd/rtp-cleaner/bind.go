@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"rtp-stream-cleaner/internal/config"
+)
+
+// rtpBindIPs returns the addresses the A and B leg sockets bind to.
+// RTP_BIND_IP applies to both legs unless RTP_BIND_IP_A or RTP_BIND_IP_B
+// override it; an empty value means 0.0.0.0. Every address other than
+// 0.0.0.0 must be assigned to a local interface.
+func rtpBindIPs(cfg config.Config, interfaceAddrs func() ([]net.Addr, error)) (net.IP, net.IP, error) {
+	base, err := parseBindIP("rtp_bind_ip", cfg.RTPBindIP, net.IPv4zero, interfaceAddrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := parseBindIP("rtp_bind_ip_a", cfg.RTPBindIPA, base, interfaceAddrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := parseBindIP("rtp_bind_ip_b", cfg.RTPBindIPB, base, interfaceAddrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+func parseBindIP(name, value string, fallback net.IP, interfaceAddrs func() ([]net.Addr, error)) (net.IP, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	ip := net.ParseIP(value).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%s %q is not an IPv4 address", name, value)
+	}
+	if ip.IsUnspecified() {
+		return ip, nil
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("list interface addresses for %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %s is not assigned to a local interface", name, value)
+}
+
+// localIPs lists the addresses other than loopback and the bind addresses
+// under which the media sockets are reached: PUBLIC_IP, INTERNAL_IP and the
+// interface addresses. When the interfaces cannot be listed the configured
+// addresses are returned with the error.
+func localIPs(cfg config.Config, interfaceAddrs func() ([]net.Addr, error)) ([]net.IP, error) {
+	var ips []net.IP
+	for _, value := range []string{cfg.PublicIP, cfg.InternalIP} {
+		if ip := net.ParseIP(value); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return ips, fmt.Errorf("list interface addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		if prefix, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, prefix.IP)
+		}
+	}
+	return ips, nil
+}
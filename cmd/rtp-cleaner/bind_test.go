@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"rtp-stream-cleaner/internal/config"
+)
+
+func fakeInterfaceAddrs() ([]net.Addr, error) {
+	return []net.Addr{
+		&net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.IPv4(10, 10, 0, 5), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.IPv4(192, 168, 7, 2), Mask: net.CIDRMask(24, 32)},
+	}, nil
+}
+
+// TestRTPBindIPs verifies the RTP_BIND_IP defaults and per-leg overrides and
+// that addresses not assigned to this host are rejected at startup, so that
+// RTP can never silently leave through another interface. Inputs: empty,
+// shared and per-leg configurations against fake interface addresses. The
+// expected output is 0.0.0.0 by default, the shared address on both legs,
+// the overrides per leg, and errors naming the offending setting otherwise.
+func TestRTPBindIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantA   string
+		wantB   string
+		wantErr string
+	}{
+		{"default", config.Config{}, "0.0.0.0", "0.0.0.0", ""},
+		{"explicit any", config.Config{RTPBindIP: "0.0.0.0"}, "0.0.0.0", "0.0.0.0", ""},
+		{"shared", config.Config{RTPBindIP: "10.10.0.5"}, "10.10.0.5", "10.10.0.5", ""},
+		{"per leg", config.Config{RTPBindIP: "10.10.0.5", RTPBindIPB: "192.168.7.2"}, "10.10.0.5", "192.168.7.2", ""},
+		{"a only", config.Config{RTPBindIPA: "192.168.7.2"}, "192.168.7.2", "0.0.0.0", ""},
+		{"not local", config.Config{RTPBindIP: "10.10.0.9"}, "", "", "rtp_bind_ip 10.10.0.9 is not assigned to a local interface"},
+		{"invalid", config.Config{RTPBindIPB: "media-vlan"}, "", "", "rtp_bind_ip_b \"media-vlan\" is not an IPv4 address"},
+		{"ipv6", config.Config{RTPBindIPA: "::1"}, "", "", "rtp_bind_ip_a \"::1\" is not an IPv4 address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b, err := rtpBindIPs(tt.cfg, fakeInterfaceAddrs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if a.String() != tt.wantA || b.String() != tt.wantB {
+				t.Fatalf("expected %s/%s, got %s/%s", tt.wantA, tt.wantB, a, b)
+			}
+		})
+	}
+}
+
+// TestLocalIPs verifies the addresses the hairpin check treats as this host,
+// so that a destination on PUBLIC_IP or a local interface is caught as well
+// as loopback. Inputs: a configuration with PUBLIC_IP and INTERNAL_IP against
+// fake interface addresses, then against a failing interface listing. The
+// expected output is both configured addresses followed by the interface
+// ones, then only the configured addresses with an error.
+func TestLocalIPs(t *testing.T) {
+	cfg := config.Config{PublicIP: "203.0.113.7", InternalIP: "10.10.0.5"}
+	ips, err := localIPs(cfg, fakeInterfaceAddrs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"203.0.113.7", "10.10.0.5", "127.0.0.1", "10.10.0.5", "192.168.7.2"}
+	if len(ips) != len(want) {
+		t.Fatalf("expected %v, got %v", want, ips)
+	}
+	for i, ip := range ips {
+		if ip.String() != want[i] {
+			t.Fatalf("expected %v, got %v", want, ips)
+		}
+	}
+
+	ips, err = localIPs(cfg, func() ([]net.Addr, error) { return nil, errors.New("no netlink") })
+	if err == nil || len(ips) != 2 {
+		t.Fatalf("expected the configured addresses and an error, got %v and %v", ips, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseClockRates parses RTP_CLOCK_RATES, a comma-separated list of
+// payload-type:rate pairs such as "96:90000,111:48000".
+func parseClockRates(value string) (map[uint8]uint32, error) {
+	rates := make(map[uint8]uint32)
+	for _, entry := range splitList(value) {
+		ptValue, rateValue, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("rtp_clock_rates entry %q is not pt:rate", entry)
+		}
+		pt, err := strconv.ParseUint(strings.TrimSpace(ptValue), 10, 8)
+		if err != nil || pt > 127 {
+			return nil, fmt.Errorf("rtp_clock_rates entry %q has an invalid payload type", entry)
+		}
+		rate, err := strconv.ParseUint(strings.TrimSpace(rateValue), 10, 32)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("rtp_clock_rates entry %q has an invalid clock rate", entry)
+		}
+		rates[uint8(pt)] = uint32(rate)
+	}
+	return rates, nil
+}
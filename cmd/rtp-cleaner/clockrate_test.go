@@ -0,0 +1,45 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+// TestParseClockRates verifies the RTP_CLOCK_RATES syntax, so that a typo
+// fails startup instead of silently leaving a payload type without jitter.
+// Inputs: empty, valid and malformed values. The expected output is the
+// parsed rates, tolerating blanks, or an error naming the bad entry.
+func TestParseClockRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[uint8]uint32
+		wantErr string
+	}{
+		{"empty", "", map[uint8]uint32{}, ""},
+		{"pairs", "96:90000, 111 : 48000,", map[uint8]uint32{96: 90000, 111: 48000}, ""},
+		{"override", "8:16000", map[uint8]uint32{8: 16000}, ""},
+		{"no colon", "96=90000", nil, `entry "96=90000" is not pt:rate`},
+		{"pt range", "128:8000", nil, `entry "128:8000" has an invalid payload type`},
+		{"zero rate", "96:0", nil, `entry "96:0" has an invalid clock rate`},
+		{"bad rate", "96:fast", nil, `entry "96:fast" has an invalid clock rate`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClockRates(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
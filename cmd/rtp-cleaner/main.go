@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"rtp-stream-cleaner/internal/api"
+	"rtp-stream-cleaner/internal/buildinfo"
 	"rtp-stream-cleaner/internal/config"
 	"rtp-stream-cleaner/internal/logging"
 	"rtp-stream-cleaner/internal/session"
@@ -21,6 +29,14 @@ func main() {
 	logging.Configure(logging.Config{Level: cfg.LogLevel, Format: cfg.LogFormat})
 	logger := logging.L()
 
+	info := buildinfo.Get()
+	logger.Info("rtp-cleaner "+info.String(),
+		"version", info.Version,
+		"commit", info.Commit,
+		"build_date", info.Date,
+		"go_version", info.GoVersion,
+	)
+
 	if cfg.PublicIP != "" {
 		logger.Info("public_ip configured", "public_ip", cfg.PublicIP)
 	}
@@ -31,38 +47,192 @@ func main() {
 		logger.Warn("service_password is empty; API access is denied until it is configured")
 	}
 
+	tlsConfig, certs, err := newAPITLSConfig(cfg)
+	if err != nil {
+		logger.Error("failed to init api tls", "error", err)
+		os.Exit(1)
+	}
+
+	bindIPA, bindIPB, err := rtpBindIPs(cfg, net.InterfaceAddrs)
+	if err != nil {
+		logger.Error("invalid rtp bind address", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("rtp bind addresses configured", "a_leg", bindIPA.String(), "b_leg", bindIPB.String())
+	clockRates, err := parseClockRates(cfg.RTPClockRates)
+	if err != nil {
+		logger.Error("invalid rtp clock rates", "error", err)
+		os.Exit(1)
+	}
+	if cfg.DTMFPayloadType < 0 || cfg.DTMFPayloadType > 127 {
+		logger.Error("invalid dtmf payload type", "dtmf_payload_type", cfg.DTMFPayloadType)
+		os.Exit(1)
+	}
+	if cfg.RTPDSCP < 0 || cfg.RTPDSCP > session.MaxDSCP || cfg.RTPTTL < 0 || cfg.RTPTTL > session.MaxTTL {
+		logger.Error("invalid rtp socket marking", "rtp_dscp", cfg.RTPDSCP, "rtp_ttl", cfg.RTPTTL)
+		os.Exit(1)
+	}
+	if cfg.KeepaliveIntervalSec < 0 || (cfg.KeepaliveFormat != "" && cfg.KeepaliveFormat != session.KeepaliveEmpty && cfg.KeepaliveFormat != session.KeepaliveRTPNOP) {
+		logger.Error("invalid keepalive", "keepalive_interval_sec", cfg.KeepaliveIntervalSec, "keepalive_format", cfg.KeepaliveFormat)
+		os.Exit(1)
+	}
+	if cfg.UDPRcvBufBytes < 0 || cfg.UDPSndBufBytes < 0 {
+		logger.Error("invalid udp socket buffers", "udp_rcvbuf_bytes", cfg.UDPRcvBufBytes, "udp_sndbuf_bytes", cfg.UDPSndBufBytes)
+		os.Exit(1)
+	}
+	if cfg.MaxPacketSize != 0 && (cfg.MaxPacketSize < 12 || cfg.MaxPacketSize > session.MaxPacketSizeLimit) {
+		logger.Error("invalid max packet size", "max_packet_size", cfg.MaxPacketSize)
+		os.Exit(1)
+	}
+	if cfg.AudioTSDiscontinuityMS < 0 {
+		logger.Error("invalid audio ts discontinuity threshold", "audio_ts_discontinuity_ms", cfg.AudioTSDiscontinuityMS)
+		os.Exit(1)
+	}
+
 	allocator, err := session.NewPortAllocator(cfg.RTPPortMin, cfg.RTPPortMax)
 	if err != nil {
 		logger.Error("failed to init port allocator", "error", err)
 		os.Exit(1)
 	}
+	allocator.SetReuseCooldown(time.Duration(cfg.PortReuseCooldownSec) * time.Second)
 	manager := session.NewManager(
 		allocator,
 		time.Duration(cfg.PeerLearningWindowSec)*time.Second,
 		time.Duration(cfg.MaxFrameWaitMS)*time.Millisecond,
 		time.Duration(cfg.IdleTimeoutSec)*time.Second,
 		cfg.VideoInjectCachedSPSPPS,
+		session.VideoFixConfig{
+			BypassErrorThreshold:    cfg.VideoFixBypassErrorThreshold,
+			BypassWindow:            time.Duration(cfg.VideoFixBypassWindowSec) * time.Second,
+			BypassCooldown:          time.Duration(cfg.VideoFixBypassCooldownSec) * time.Second,
+			MaxFrameBufferPackets:   cfg.MaxFrameBufferPackets,
+			MaxFrameBufferBytes:     cfg.MaxFrameBufferBytes,
+			KeyframeRequestRTCPPort: cfg.KeyframeRequestRTCPPort,
+			KeyframeRequestFIR:      cfg.KeyframeRequestFIR,
+		},
 		session.ProxyLogConfig{
 			StatsInterval:      time.Duration(cfg.StatsLogIntervalSec) * time.Second,
 			PacketLog:          cfg.PacketLog,
 			PacketLogSampleN:   uint64(cfg.PacketLogSampleN),
 			PacketLogOnAnomaly: cfg.PacketLogOnAnomaly,
+			MetadataKeys:       splitList(cfg.LogMetadataKeys),
 		},
+		cfg.CaptureDir,
 	)
+	manager.SetMaxSessions(cfg.MaxSessions)
+	manager.SetMaxSessionsPerCall(cfg.MaxSessionsPerCall)
+	manager.SetPortPoolLowWater(cfg.PortPoolLowWater)
+	manager.SetMaxSessionLifetime(time.Duration(cfg.MaxSessionLifetimeSec) * time.Second)
+	manager.SetBindIPs(bindIPA, bindIPB)
+	ips, err := localIPs(cfg, net.InterfaceAddrs)
+	if err != nil {
+		logger.Warn("hairpin check limited to configured addresses", "error", err)
+	}
+	manager.SetLocalIPs(ips)
+	manager.SetSocketMarking(session.SocketMarking{DSCP: cfg.RTPDSCP, TTL: cfg.RTPTTL})
+	manager.SetSocketBuffers(session.SocketBuffers{Read: cfg.UDPRcvBufBytes, Write: cfg.UDPSndBufBytes})
+	manager.SetMaxPacketSize(cfg.MaxPacketSize)
+	manager.SetClockRates(clockRates)
+	manager.SetDTMFPayloadType(uint8(cfg.DTMFPayloadType))
+	manager.SetTSDiscontinuityThreshold(time.Duration(cfg.AudioTSDiscontinuityMS) * time.Millisecond)
+	manager.SetDropNonRTP(cfg.DropNonRTP)
+	manager.SetPeerRelearnAfter(time.Duration(cfg.PeerRelearnAfterSec) * time.Second)
+	manager.SetPeerSwitchPackets(cfg.PeerSwitchPackets)
+	manager.SetBLegStrictPort(cfg.BLegStrictPort)
+	manager.SetKeepalive(session.KeepaliveConfig{
+		Interval: time.Duration(cfg.KeepaliveIntervalSec) * time.Second,
+		Format:   cfg.KeepaliveFormat,
+	})
+	if cfg.StateDir != "" {
+		restored, err := manager.EnableStatePersistence(cfg.StateDir)
+		if err != nil {
+			logger.Error("failed to init session state", "state_dir", cfg.StateDir, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("session state restored", "state_dir", cfg.StateDir, "sessions", restored)
+	}
+	manager.StartDestRefresh(time.Duration(cfg.DestDNSTTLSec) * time.Second)
+	manager.StartMediaIdleCheck(time.Duration(cfg.MediaIdleTimeoutSec) * time.Second)
+	manager.StartKernelDropCheck()
 	handler := api.NewHandler(cfg, manager)
 
 	mux := http.NewServeMux()
 	handler.Register(mux)
 
+	// Request contexts are cancelled as soon as shutdown starts so that
+	// long-lived /v1/events streams do not hold the grace period open.
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 	server := &http.Server{
 		Addr:              cfg.APIListenAddr,
 		Handler:           mux,
 		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return requestCtx },
+		TLSConfig:         tlsConfig,
 	}
+	server.RegisterOnShutdown(cancelRequests)
 
-	logger.Info("starting http server", "addr", cfg.APIListenAddr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Error("server failed", "error", err)
-		os.Exit(1)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if certs != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				if err := certs.reload(); err != nil {
+					logger.Error("api tls reload failed; keeping previous certificate", "error", err)
+					continue
+				}
+				logger.Info("api tls certificate reloaded", "cert_file", cfg.APITLSCertFile)
+			}
+		}()
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			logger.Info("starting https server", "addr", cfg.APIListenAddr, "tls", true,
+				"client_auth", tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		logger.Info("starting http server", "addr", cfg.APIListenAddr, "tls", false)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server failed", "error", err)
+			manager.Close()
+			manager.StopAllSessions()
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		stop()
+		manager.BeginShutdown()
+		grace := time.Duration(cfg.ShutdownGraceSec) * time.Second
+		logger.Info("shutdown started", "grace", grace)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("http server shutdown incomplete", "error", err)
+		}
+		cancel()
+	}
+	manager.Close()
+	stopped := manager.StopAllSessions()
+	logger.Info("shutdown complete", "sessions_stopped", stopped)
+}
+
+// splitList parses a comma-separated config value, ignoring blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"rtp-stream-cleaner/internal/config"
+)
+
+// certReloader serves the API certificate and swaps it in place on reload so
+// rotated certificates are picked up without restarting active sessions.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload keeps the previous certificate when the new pair cannot be loaded.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load api tls key pair %s/%s: %w", r.certFile, r.keyFile, err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// newAPITLSConfig returns nil when TLS is not configured. A partial
+// configuration or an unreadable file is an error so that the service never
+// silently falls back to cleartext.
+func newAPITLSConfig(cfg config.Config) (*tls.Config, *certReloader, error) {
+	if cfg.APITLSCertFile == "" && cfg.APITLSKeyFile == "" {
+		if cfg.APITLSClientCAFile != "" {
+			return nil, nil, errors.New("api_tls_client_ca_file requires api_tls_cert_file and api_tls_key_file")
+		}
+		return nil, nil, nil
+	}
+	if cfg.APITLSCertFile == "" || cfg.APITLSKeyFile == "" {
+		return nil, nil, errors.New("api_tls_cert_file and api_tls_key_file must be set together")
+	}
+	reloader, err := newCertReloader(cfg.APITLSCertFile, cfg.APITLSKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if cfg.APITLSClientCAFile != "" {
+		data, err := os.ReadFile(cfg.APITLSClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read api tls client ca %s: %w", cfg.APITLSClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("parse api tls client ca %s: no certificates found", cfg.APITLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, reloader, nil
+}
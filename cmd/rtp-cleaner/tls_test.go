@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/config"
+)
+
+// writeSelfSignedPair writes a throwaway certificate and key into dir and
+// returns their paths.
+func writeSelfSignedPair(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certPath, keyPath
+}
+
+// TestNewAPITLSConfig verifies that TLS stays off when unconfigured, that a
+// valid pair with a client CA enables mutual TLS, and that partial or
+// unreadable configuration fails instead of falling back to cleartext. This
+// matters because the access token must never cross the network unencrypted
+// once the operator asked for TLS.
+func TestNewAPITLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeSelfSignedPair(t, dir, "api")
+	caPath, _ := writeSelfSignedPair(t, dir, "clients")
+
+	tlsConfig, certs, err := newAPITLSConfig(config.Config{})
+	if tlsConfig != nil || certs != nil || err != nil {
+		t.Fatalf("expected tls disabled, got config=%v err=%v", tlsConfig, err)
+	}
+
+	tlsConfig, certs, err = newAPITLSConfig(config.Config{
+		APITLSCertFile:     certPath,
+		APITLSKeyFile:      keyPath,
+		APITLSClientCAFile: caPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Fatalf("expected mutual tls, got client_auth=%v", tlsConfig.ClientAuth)
+	}
+	if cert, _ := tlsConfig.GetCertificate(nil); cert == nil {
+		t.Fatal("expected certificate to be served")
+	}
+	if err := os.WriteFile(certPath, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("corrupt cert: %v", err)
+	}
+	if err := certs.reload(); err == nil {
+		t.Fatal("expected reload of corrupt certificate to fail")
+	}
+	if cert, _ := tlsConfig.GetCertificate(nil); cert == nil {
+		t.Fatal("expected previous certificate to be kept after failed reload")
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"cert only", config.Config{APITLSCertFile: certPath}, "must be set together"},
+		{"client ca only", config.Config{APITLSClientCAFile: caPath}, "requires api_tls_cert_file"},
+		{"unreadable key", config.Config{APITLSCertFile: certPath, APITLSKeyFile: filepath.Join(dir, "missing.key")}, "load api tls key pair"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newAPITLSConfig(tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"syscall"
 	"time"
 
+	"rtp-stream-cleaner/internal/buildinfo"
 	"rtp-stream-cleaner/internal/logging"
 	"rtp-stream-cleaner/internal/pcapio"
 	"rtp-stream-cleaner/internal/rtpfix"
@@ -48,6 +49,8 @@ type stats struct {
 	recvBytes     int64
 	parseErrors   int64
 	sendErrors    int64
+	sentRTCPPkts  int64
+	recvRTCPPkts  int64
 }
 
 type config struct {
@@ -64,6 +67,10 @@ type config struct {
 	duration    time.Duration
 	verbose     bool
 	listSources bool
+	version     bool
+	// rtcp binds the port after each media port, sends one sender report
+	// per replayed stream from it and counts the RTCP received there.
+	rtcp bool
 }
 
 func main() {
@@ -72,6 +79,10 @@ func main() {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
+	if cfg.version {
+		fmt.Println("rtppeer " + buildinfo.Get().String())
+		return
+	}
 	if err := run(cfg); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -90,15 +101,20 @@ func parseFlags(args []string) (config, error) {
 	flags.StringVar(&cfg.sendPCAP, "send-pcap", "", "PCAP file to replay")
 	flags.StringVar(&cfg.recvPCAP, "recv-pcap", "", "PCAP file to write")
 	flags.BoolVar(&cfg.listSources, "list-sources", false, "List RTP SSRCs and payload types in send-pcap and exit")
+	flags.BoolVar(&cfg.rtcp, "rtcp", false, "Send an RTCP sender report per stream and receive RTCP on port+1")
 	pacingRaw := flags.String("pacing", "capture", "Pacing mode: capture, fast, fixed:<ms>")
 	audioSSRC := flags.String("audio-ssrc", "", "Audio RTP SSRC (hex or decimal)")
 	videoSSRC := flags.String("video-ssrc", "", "Video RTP SSRC (hex or decimal)")
 	var durationSec int
 	flags.IntVar(&durationSec, "duration", 0, "Duration in seconds to run")
 	flags.BoolVar(&cfg.verbose, "verbose", false, "Verbose logging")
+	flags.BoolVar(&cfg.version, "version", false, "Print version and exit")
 	if err := flags.Parse(args); err != nil {
 		return cfg, err
 	}
+	if cfg.version {
+		return cfg, nil
+	}
 	if cfg.listSources {
 		if cfg.sendPCAP == "" {
 			return cfg, errors.New("send-pcap is required when list-sources is set")
@@ -199,6 +215,20 @@ func run(cfg config) error {
 	}
 	defer videoConn.Close()
 
+	var audioRTCPConn, videoRTCPConn *net.UDPConn
+	if cfg.rtcp {
+		audioRTCPConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: bindIP, Port: cfg.audioPort + 1})
+		if err != nil {
+			return fmt.Errorf("listen audio rtcp: %w", err)
+		}
+		defer audioRTCPConn.Close()
+		videoRTCPConn, err = net.ListenUDP("udp", &net.UDPAddr{IP: bindIP, Port: cfg.videoPort + 1})
+		if err != nil {
+			return fmt.Errorf("listen video rtcp: %w", err)
+		}
+		defer videoRTCPConn.Close()
+	}
+
 	var recvWriter *pcapio.Writer
 	if cfg.recvPCAP != "" {
 		writer, err := pcapio.NewWriter(cfg.recvPCAP)
@@ -226,13 +256,19 @@ func run(cfg config) error {
 		go recvLoop(ctx, "audio", audioConn, recvWriter, cfg.verbose, logger, &stats, &wg)
 		go recvLoop(ctx, "video", videoConn, recvWriter, cfg.verbose, logger, &stats, &wg)
 	}
+	if cfg.rtcp {
+		// RTCP is counted but kept out of the recv pcap, which holds RTP only.
+		wg.Add(2)
+		go recvLoop(ctx, "rtcp", audioRTCPConn, nil, cfg.verbose, logger, &stats, &wg)
+		go recvLoop(ctx, "rtcp", videoRTCPConn, nil, cfg.verbose, logger, &stats, &wg)
+	}
 
 	sendDone := make(chan error, 1)
 	if cfg.sendPCAP != "" {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			sendDone <- sendLoop(ctx, cfg, audioConn, videoConn, logger, &stats)
+			sendDone <- sendLoop(ctx, cfg, audioConn, videoConn, audioRTCPConn, videoRTCPConn, logger, &stats)
 		}()
 	}
 
@@ -276,11 +312,16 @@ func recvLoop(ctx context.Context, label string, conn *net.UDPConn, writer *pcap
 			logger.Error("recv failed", "label", label, "error", err)
 			continue
 		}
-		atomic.AddInt64(&stats.recvBytes, int64(n))
-		if label == "audio" {
+		switch label {
+		case "audio":
 			atomic.AddInt64(&stats.recvAudioPkts, 1)
-		} else {
+		case "video":
 			atomic.AddInt64(&stats.recvVideoPkts, 1)
+		default:
+			atomic.AddInt64(&stats.recvRTCPPkts, 1)
+		}
+		if label != "rtcp" {
+			atomic.AddInt64(&stats.recvBytes, int64(n))
 		}
 		payload := make([]byte, n)
 		copy(payload, buf[:n])
@@ -296,7 +337,10 @@ func recvLoop(ctx context.Context, label string, conn *net.UDPConn, writer *pcap
 	}
 }
 
-func sendLoop(ctx context.Context, cfg config, audioConn, videoConn *net.UDPConn, logger *slog.Logger, stats *stats) error {
+// sendLoop replays the pcap streams of the configured SSRCs. With RTCP
+// sockets, the first packet of each stream is followed by a sender report to
+// the port after its destination.
+func sendLoop(ctx context.Context, cfg config, audioConn, videoConn, audioRTCPConn, videoRTCPConn *net.UDPConn, logger *slog.Logger, stats *stats) error {
 	audioAddr, err := net.ResolveUDPAddr("udp", cfg.audioTo)
 	if err != nil {
 		return fmt.Errorf("resolve audio-to: %w", err)
@@ -312,6 +356,7 @@ func sendLoop(ctx context.Context, cfg config, audioConn, videoConn *net.UDPConn
 	defer reader.Close()
 
 	var prevTS time.Time
+	reported := make(map[string]bool)
 	for {
 		select {
 		case <-ctx.Done():
@@ -325,7 +370,7 @@ func sendLoop(ctx context.Context, cfg config, audioConn, videoConn *net.UDPConn
 			}
 			return err
 		}
-		udpPayload, err := extractUDPPayload(packet.Data, reader.LinkType())
+		udpPayload, err := pcapio.ExtractUDPPayload(packet.Data, reader.LinkType())
 		if err != nil {
 			atomic.AddInt64(&stats.parseErrors, 1)
 			continue
@@ -338,15 +383,17 @@ func sendLoop(ctx context.Context, cfg config, audioConn, videoConn *net.UDPConn
 			atomic.AddInt64(&stats.parseErrors, 1)
 			continue
 		}
-		var conn *net.UDPConn
+		var conn, rtcpConn *net.UDPConn
 		var addr *net.UDPAddr
 		var label string
 		if rtpPacket.SSRC == cfg.audioSSRC {
 			conn = audioConn
+			rtcpConn = audioRTCPConn
 			addr = audioAddr
 			label = "audio"
 		} else if rtpPacket.SSRC == cfg.videoSSRC {
 			conn = videoConn
+			rtcpConn = videoRTCPConn
 			addr = videoAddr
 			label = "video"
 		} else {
@@ -371,10 +418,42 @@ func sendLoop(ctx context.Context, cfg config, audioConn, videoConn *net.UDPConn
 		if cfg.verbose {
 			logger.Info("sent packet", "label", label, "bytes", len(udpPayload), "addr", addr.String())
 		}
+		if rtcpConn != nil && !reported[label] {
+			reported[label] = true
+			report := senderReport(rtpPacket.SSRC, time.Now(), rtpPacket.TS, 1, uint32(len(udpPayload)-rtpPacket.HeaderSize))
+			rtcpAddr := &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1}
+			if _, err := rtcpConn.WriteToUDP(report, rtcpAddr); err != nil {
+				atomic.AddInt64(&stats.sendErrors, 1)
+				if cfg.verbose {
+					logger.Error("rtcp send failed", "label", label, "error", err)
+				}
+			} else {
+				atomic.AddInt64(&stats.sentRTCPPkts, 1)
+			}
+		}
 	}
 	return nil
 }
 
+// ntpEpochOffset is the number of seconds from 1900, the NTP epoch, to 1970.
+const ntpEpochOffset = 2208988800
+
+// senderReport builds a minimal RTCP sender report without report blocks
+// (RFC 3550 section 6.4.1).
+func senderReport(ssrc uint32, now time.Time, rtpTimestamp, packetCount, octetCount uint32) []byte {
+	report := make([]byte, 28)
+	report[0] = 0x80
+	report[1] = 200
+	binary.BigEndian.PutUint16(report[2:4], uint16(len(report)/4-1))
+	binary.BigEndian.PutUint32(report[4:8], ssrc)
+	binary.BigEndian.PutUint32(report[8:12], uint32(now.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(report[12:16], uint32((uint64(now.Nanosecond())<<32)/uint64(time.Second)))
+	binary.BigEndian.PutUint32(report[16:20], rtpTimestamp)
+	binary.BigEndian.PutUint32(report[20:24], packetCount)
+	binary.BigEndian.PutUint32(report[24:28], octetCount)
+	return report
+}
+
 func applyPacing(cfg pacingConfig, ts time.Time, prevTS *time.Time) error {
 	switch cfg.mode {
 	case pacingFast:
@@ -406,6 +485,8 @@ func printSummary(stats *stats) {
 	fmt.Printf("sent_video_pkts=%d\n", atomic.LoadInt64(&stats.sentVideoPkts))
 	fmt.Printf("recv_audio_pkts=%d\n", atomic.LoadInt64(&stats.recvAudioPkts))
 	fmt.Printf("recv_video_pkts=%d\n", atomic.LoadInt64(&stats.recvVideoPkts))
+	fmt.Printf("sent_rtcp_pkts=%d\n", atomic.LoadInt64(&stats.sentRTCPPkts))
+	fmt.Printf("recv_rtcp_pkts=%d\n", atomic.LoadInt64(&stats.recvRTCPPkts))
 	fmt.Printf("bytes_sent=%d\n", atomic.LoadInt64(&stats.sentBytes))
 	fmt.Printf("bytes_recv=%d\n", atomic.LoadInt64(&stats.recvBytes))
 	fmt.Printf("errors=%d\n", atomic.LoadInt64(&stats.parseErrors)+atomic.LoadInt64(&stats.sendErrors))
@@ -418,12 +499,21 @@ func listSources(pcapPath string) error {
 	}
 	defer reader.Close()
 
+	// Payloads are counted as both H264 and H265; a source that sends H265
+	// fragmentation units (type 49) is reported with the H265 breakdown. The
+	// last H264 SPS that parses gives the picture size, profile and level;
+	// FU-A fragments are reassembled so that a fragmented SPS counts too, and
+	// its size is reported. H264 payloads behind an Annex B start code are
+	// counted, and classified by the NAL unit after it.
 	type sourceStats struct {
-		packets int
-		sps     int
-		pps     int
-		idr     int
-		nonIDR  int
+		packets       int
+		h264          nalCounts
+		h265          nalCounts
+		h265FU        bool
+		sps           *rtpfix.SPSInfo
+		fu            rtpfix.FUAssembler
+		fragmentedSPS int
+		startCodes    int
 	}
 	sources := make(map[uint32]map[uint8]*sourceStats)
 	for {
@@ -434,7 +524,7 @@ func listSources(pcapPath string) error {
 			}
 			return err
 		}
-		udpPayload, err := extractUDPPayload(packet.Data, reader.LinkType())
+		udpPayload, err := pcapio.ExtractUDPPayload(packet.Data, reader.LinkType())
 		if err != nil || len(udpPayload) == 0 {
 			continue
 		}
@@ -455,24 +545,34 @@ func listSources(pcapPath string) error {
 		stats.packets++
 		if rtpPacket.HeaderSize < len(udpPayload) {
 			rtpPayload := udpPayload[rtpPacket.HeaderSize:]
-			if info, ok := rtpfix.ParseH264(rtpPayload); ok {
-				if info.IsFU && !info.FUStart {
+			units := [][]byte{rtpPayload}
+			if aggregated, ok := rtpfix.SplitSTAPA(rtpPayload); ok {
+				units = aggregated
+			}
+			for _, unit := range units {
+				info, ok := rtpfix.ParseH264(unit)
+				if !ok {
 					continue
 				}
-				if info.IsSPS {
-					stats.sps++
+				stats.h264.add(info)
+				if info.HasStartCode {
+					stats.startCodes++
 				}
-				if info.IsPPS {
-					stats.pps++
+				if sps, ok := rtpfix.ParseSPS(unit[info.NALOffset:]); ok {
+					stats.sps = &sps
 				}
-				if info.IsSlice {
-					if info.IsIDR {
-						stats.idr++
-					} else {
-						stats.nonIDR++
-					}
+			}
+			if len(rtpPayload) > 0 && rtpPayload[0]&0x1f == 28 {
+				nal, _ := stats.fu.Push(rtpPayload)
+				if sps, ok := rtpfix.ParseSPS(nal); ok {
+					stats.sps = &sps
+					stats.fragmentedSPS = len(nal)
 				}
 			}
+			if info, ok := rtpfix.ParseH265(rtpPayload); ok {
+				stats.h265.add(info)
+				stats.h265FU = stats.h265FU || info.IsFU
+			}
 		}
 	}
 
@@ -490,87 +590,74 @@ func listSources(pcapPath string) error {
 		sort.Ints(payloadList)
 		for _, pt := range payloadList {
 			stats := payloadTypes[uint8(pt)]
-			fmt.Printf(
-				"ssrc=0x%08x payload_type=%d packets=%d sps=%d pps=%d idr=%d non_idr=%d\n",
+			counts := stats.h264
+			if stats.h265FU {
+				counts = stats.h265
+			}
+			line := fmt.Sprintf(
+				"ssrc=0x%08x payload_type=%d packets=%d sps=%d pps=%d idr=%d non_idr=%d sei=%d aud=%d filler=%d",
 				ssrc,
 				pt,
 				stats.packets,
-				stats.sps,
-				stats.pps,
-				stats.idr,
-				stats.nonIDR,
+				counts.sps,
+				counts.pps,
+				counts.idr,
+				counts.nonIDR,
+				counts.sei,
+				counts.aud,
+				counts.filler,
 			)
+			if stats.startCodes > 0 {
+				line += fmt.Sprintf(" start_codes=%d", stats.startCodes)
+			}
+			if stats.h265FU {
+				line += fmt.Sprintf(" codec=h265 vps=%d", counts.vps)
+			} else if stats.sps != nil {
+				line += fmt.Sprintf(" width=%d height=%d profile=%q level=%s", stats.sps.Width, stats.sps.Height, stats.sps.Profile(), stats.sps.Level())
+				if stats.fragmentedSPS > 0 {
+					line += fmt.Sprintf(" fragmented_sps_bytes=%d", stats.fragmentedSPS)
+				}
+			}
+			fmt.Println(line)
 		}
 	}
 	return nil
 }
 
-func extractUDPPayload(frame []byte, linkType uint32) ([]byte, error) {
-	var etherType uint16
-	offset := 0
-	switch linkType {
-	case 1:
-		if len(frame) < 14 {
-			return nil, fmt.Errorf("frame too short")
-		}
-		etherType = binary.BigEndian.Uint16(frame[12:14])
-		offset = 14
-	case 113:
-		if len(frame) < 16 {
-			return nil, fmt.Errorf("frame too short")
-		}
-		etherType = binary.BigEndian.Uint16(frame[14:16])
-		offset = 16
-	case 276:
-		if len(frame) < 20 {
-			return nil, fmt.Errorf("frame too short")
-		}
-		etherType = binary.BigEndian.Uint16(frame[0:2])
-		offset = 20
-	default:
-		return nil, fmt.Errorf("unsupported linktype: %d", linkType)
-	}
-	if etherType == 0x8100 {
-		if len(frame) < offset+4 {
-			return nil, fmt.Errorf("frame too short for vlan")
-		}
-		etherType = binary.BigEndian.Uint16(frame[offset+2 : offset+4])
-		offset += 4
-	}
-	if etherType != 0x0800 {
-		return nil, fmt.Errorf("unsupported ethertype: 0x%x", etherType)
-	}
-	if len(frame) < offset+20 {
-		return nil, fmt.Errorf("ipv4 header truncated")
-	}
-	ihl := int(frame[offset] & 0x0f)
-	if ihl < 5 {
-		return nil, fmt.Errorf("invalid ihl")
-	}
-	ipHeaderLen := ihl * 4
-	if len(frame) < offset+ipHeaderLen {
-		return nil, fmt.Errorf("ipv4 header truncated")
-	}
-	if frame[offset+9] != 17 {
-		return nil, fmt.Errorf("not udp")
-	}
-	frag := binary.BigEndian.Uint16(frame[offset+6 : offset+8])
-	if frag&0x1fff != 0 {
-		return nil, fmt.Errorf("fragmented packet")
-	}
-	udpStart := offset + ipHeaderLen
-	if len(frame) < udpStart+8 {
-		return nil, fmt.Errorf("udp header truncated")
-	}
-	udpLen := int(binary.BigEndian.Uint16(frame[udpStart+4 : udpStart+6]))
-	if udpLen < 8 {
-		return nil, fmt.Errorf("invalid udp length")
-	}
-	payloadLen := udpLen - 8
-	if len(frame) < udpStart+8+payloadLen {
-		return nil, fmt.Errorf("udp payload truncated")
+// nalCounts is the NAL unit breakdown of one source for list-sources. A
+// fragmented NAL unit counts once, on its first fragment, and the H264 units
+// of a STAP-A each count on their own.
+type nalCounts struct {
+	vps    int
+	sps    int
+	pps    int
+	idr    int
+	nonIDR int
+	sei    int
+	aud    int
+	filler int
+}
+
+func (c *nalCounts) add(info rtpfix.H264Info) {
+	if info.IsFU && !info.FUStart {
+		return
+	}
+	switch {
+	case info.IsVPS:
+		c.vps++
+	case info.IsSPS:
+		c.sps++
+	case info.IsPPS:
+		c.pps++
+	case info.IsSEI:
+		c.sei++
+	case info.IsAUD:
+		c.aud++
+	case info.IsFiller:
+		c.filler++
+	case info.IsSlice && info.IsIDR:
+		c.idr++
+	case info.IsSlice:
+		c.nonIDR++
 	}
-	payload := make([]byte, payloadLen)
-	copy(payload, frame[udpStart+8:udpStart+8+payloadLen])
-	return payload, nil
 }
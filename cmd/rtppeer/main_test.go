@@ -3,11 +3,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/pcapio"
 )
 
 func TestListSourcesNormalPCAP(t *testing.T) {
@@ -61,3 +66,213 @@ func TestListSourcesNormalPCAP(t *testing.T) {
 		}
 	}
 }
+
+// TestListSourcesH265 verifies the HEVC breakdown of list-sources. This
+// matters because H265 parameter sets and IRAP pictures read as unrelated H264
+// NAL types, so an HEVC capture reported no SPS, PPS or IDR at all. Input: a
+// PCAP of one source sending a VPS, SPS and PPS, an IDR_W_RADL in a type-49
+// FU start, middle and end, and a TRAIL_R slice. The expected output is the
+// line for that source with codec=h265, one VPS, SPS, PPS and IDR and one
+// non-IDR slice, the FU counted once.
+func TestListSourcesH265(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{32 << 1, 0x01, 0x0c},
+		{33 << 1, 0x01, 0x01},
+		{34 << 1, 0x01, 0xc1},
+		{49 << 1, 0x01, 0x80 | 19, 0xaa},
+		{49 << 1, 0x01, 19, 0xbb},
+		{49 << 1, 0x01, 0x40 | 19, 0xcc},
+		{1 << 1, 0x01, 0xdd},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=7 sps=1 pps=1 idr=1 non_idr=1 sei=0 aud=0 filler=0 codec=h265 vps=1\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesSTAPA verifies that list-sources counts the NAL units inside
+// H264 STAP-A packets, where some encoders put their SPS, PPS and IDR start.
+// Input: a PCAP of one source sending a STAP-A of SPS, PPS and IDR, then a
+// non-IDR slice. The expected output is one SPS, PPS, IDR and non-IDR slice.
+func TestListSourcesSTAPA(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce, 0x00, 0x02, 0x65, 0x88},
+		{0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=2 sps=1 pps=1 idr=1 non_idr=1 sei=0 aud=0 filler=0\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesSPS verifies that list-sources prints the picture size,
+// profile and level of the SPS a source sends. This matters because support
+// asks what resolution a doorphone sends and had to open the capture in
+// Wireshark to tell. Input: a PCAP of one source sending the 640x360
+// constrained baseline SPS of a doorphone, then a non-IDR slice. The expected
+// output is the line for that source ending with its size, profile and level.
+func TestListSourcesSPS(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40},
+		{0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=2 sps=1 pps=0 idr=0 non_idr=1 sei=0 aud=0 filler=0 width=640 height=360 profile=\"constrained baseline\" level=3.1\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesFragmentedSPS verifies that list-sources reassembles an SPS
+// sent in FU-A fragments and reports its size. This matters because encoders
+// with large VUI fragment their SPS, which list-sources then could not decode.
+// Input: a PCAP of one source sending the x264 1080p SPS in three FU-A
+// fragments, a fragment continuing nothing, and a non-IDR slice. The expected
+// output is one SPS counted, the 1920x1080 size, profile and level, and the
+// 27 bytes of the reassembled SPS.
+func TestListSourcesFragmentedSPS(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{0x7c, 0x87, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27},
+		{0x7c, 0x07, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00},
+		{0x7c, 0x47, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58},
+		{0x7c, 0x47, 0x00},
+		{0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=5 sps=1 pps=0 idr=0 non_idr=1 sei=0 aud=0 filler=0 width=1920 height=1080 profile=\"high\" level=4.0 fragmented_sps_bytes=27\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesStartCodes verifies that list-sources counts the payloads
+// of a source prefixed with an Annex B start code and still classifies them.
+// This matters because support needs to tell which doorphones send start
+// codes, to create their sessions with strip_annexb. Input: a PCAP of one
+// source sending the 640x360 doorphone SPS after a 4-byte start code, a PPS
+// after a 3-byte one, an IDR after a 4-byte one and an unprefixed non-IDR
+// slice. The expected output is the SPS, PPS, IDR and non-IDR counted, three
+// start codes, and the size, profile and level of the prefixed SPS.
+func TestListSourcesStartCodes(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{0x00, 0x00, 0x00, 0x01, 0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40},
+		{0x00, 0x00, 0x01, 0x68, 0xce, 0x3c, 0x80},
+		{0x00, 0x00, 0x00, 0x01, 0x65, 0x88},
+		{0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=4 sps=1 pps=1 idr=1 non_idr=1 sei=0 aud=0 filler=0 start_codes=3 width=640 height=360 profile=\"constrained baseline\" level=3.1\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesIPv6 verifies that list-sources reads a capture of a
+// session over IPv6. This matters because replaying or inspecting a v6
+// capture failed with an unsupported ethertype. Input: a PCAP of one source
+// sending the 640x360 doorphone SPS and a non-IDR slice from 2001:db8::10 to
+// 2001:db8::20. The expected output is the same line as over IPv4.
+func TestListSourcesIPv6(t *testing.T) {
+	output := listSyntheticSourcesBetween(t, net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20"), [][]byte{
+		{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40},
+		{0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=2 sps=1 pps=0 idr=0 non_idr=1 sei=0 aud=0 filler=0 width=640 height=360 profile=\"constrained baseline\" level=3.1\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// TestListSourcesNonVCL verifies that list-sources counts the SEI, access
+// unit delimiters and filler data of a source in their own columns. This
+// matters because they used to land in no column at all, which hid encoders
+// padding their streams or sending a delimiter per frame. Input: a PCAP of one
+// source sending an AUD, an SEI, an IDR, filler data, an end of sequence and
+// a STAP-A of an AUD and a non-IDR slice. The expected output is two AUDs, one
+// SEI, one filler, one IDR and one non-IDR slice, the end of sequence in no
+// column.
+func TestListSourcesNonVCL(t *testing.T) {
+	output := listSyntheticSources(t, [][]byte{
+		{0x09, 0xf0},
+		{0x06, 0x05, 0x01},
+		{0x65, 0x88},
+		{0x0c, 0xff, 0xff, 0x80},
+		{0x0a},
+		{0x78, 0x00, 0x02, 0x09, 0x30, 0x00, 0x02, 0x41, 0x9a},
+	})
+
+	want := "ssrc=0x0000beef payload_type=96 packets=6 sps=0 pps=0 idr=1 non_idr=1 sei=1 aud=2 filler=1\n"
+	if output != want {
+		t.Fatalf("expected %q, got %q", want, output)
+	}
+}
+
+// listSyntheticSources writes payloads as RTP packets of one source to a
+// PCAP and returns the list-sources output for it.
+func listSyntheticSources(t *testing.T, payloads [][]byte) string {
+	t.Helper()
+	return listSyntheticSourcesBetween(t, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), payloads)
+}
+
+// listSyntheticSourcesBetween is listSyntheticSources with the packets sent
+// from srcIP to dstIP.
+func listSyntheticSourcesBetween(t *testing.T, srcIP, dstIP net.IP, payloads [][]byte) string {
+	t.Helper()
+	pcapPath := filepath.Join(t.TempDir(), "sources.pcap")
+	writer, err := pcapio.NewWriter(pcapPath)
+	if err != nil {
+		t.Fatalf("create pcap: %v", err)
+	}
+	for i, payload := range payloads {
+		packet := make([]byte, 12, 12+len(payload))
+		packet[0] = 0x80
+		packet[1] = 96
+		binary.BigEndian.PutUint16(packet[2:4], uint16(i))
+		binary.BigEndian.PutUint32(packet[8:12], 0x0000beef)
+		if err := writer.WritePacket(time.Unix(1700000000, 0), srcIP, dstIP, 5000, 6000, append(packet, payload...)); err != nil {
+			t.Fatalf("write packet: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close pcap: %v", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe stdout: %v", err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	err = listSources(pcapPath)
+	_ = w.Close()
+	os.Stdout = origStdout
+	if err != nil {
+		t.Fatalf("listSources: %v", err)
+	}
+	var output bytes.Buffer
+	if _, err := output.ReadFrom(r); err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	return output.String()
+}
+
+func TestSenderReport(t *testing.T) {
+	now := time.Unix(1700000000, 500_000_000)
+	report := senderReport(0x220a3aad, now, 160, 1, 172)
+
+	if len(report) != 28 || report[0] != 0x80 || report[1] != 200 || binary.BigEndian.Uint16(report[2:4]) != 6 {
+		t.Fatalf("unexpected header % x", report)
+	}
+	if ssrc := binary.BigEndian.Uint32(report[4:8]); ssrc != 0x220a3aad {
+		t.Fatalf("unexpected ssrc 0x%08x", ssrc)
+	}
+	if sec, frac := binary.BigEndian.Uint32(report[8:12]), binary.BigEndian.Uint32(report[12:16]); sec != 1700000000+ntpEpochOffset || frac != 1<<31 {
+		t.Fatalf("unexpected ntp timestamp %d.%d", sec, frac)
+	}
+	if ts, pkts, octets := binary.BigEndian.Uint32(report[16:20]), binary.BigEndian.Uint32(report[20:24]), binary.BigEndian.Uint32(report[24:28]); ts != 160 || pkts != 1 || octets != 172 {
+		t.Fatalf("unexpected sender info ts=%d pkts=%d octets=%d", ts, pkts, octets)
+	}
+}
@@ -1,3 +1,7 @@
 module rtp-stream-cleaner
 
-go 1.25
+go 1.25.0
+
+require golang.org/x/net v0.58.0
+
+require golang.org/x/sys v0.47.0 // indirect
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds an incoming X-Request-ID that is echoed and logged.
+const maxRequestIDLen = 128
+
+// accessLogMode selects which API requests are written to the access log.
+type accessLogMode int
+
+const (
+	accessLogAll accessLogMode = iota
+	accessLogErrors
+	accessLogOff
+)
+
+// parseAccessLogMode maps ACCESS_LOG to a mode. Unknown values log everything.
+func parseAccessLogMode(value string) accessLogMode {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "errors":
+		return accessLogErrors
+	case "off", "false", "none":
+		return accessLogOff
+	default:
+		return accessLogAll
+	}
+}
+
+func (r role) String() string {
+	switch r {
+	case roleAdmin:
+		return "admin"
+	case roleReadonly:
+		return "readonly"
+	default:
+		return "none"
+	}
+}
+
+// statusRecorder captures the status and size of a response for the access
+// log. It keeps http.Flusher working for the event stream.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// withAccessLog assigns a request ID, returned in X-Request-ID, and logs one
+// entry per request once the handler has finished.
+func (h *Handler) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := incomingRequestID(r.Header.Get(requestIDHeader))
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		h.logAccess(r, recorder, requestID, time.Since(start))
+	})
+}
+
+func (h *Handler) logAccess(r *http.Request, recorder *statusRecorder, requestID string, latency time.Duration) {
+	if h.accessLogMode == accessLogOff {
+		return
+	}
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if h.accessLogMode == accessLogErrors && status < http.StatusBadRequest {
+		return
+	}
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	case isProbePath(r.URL.Path):
+		// Load balancer probes only show up with LOG_LEVEL=debug.
+		level = slog.LevelDebug
+	}
+	attrs := []any{
+		"request_id", requestID,
+		"method", r.Method,
+		"path", redactedRequestURI(r.URL),
+		"status", status,
+		"latency", latency,
+		"bytes", recorder.bytes,
+		"remote_addr", r.RemoteAddr,
+		"role", h.resolveRole(accessToken(r)).String(),
+	}
+	if id := r.PathValue("id"); id != "" {
+		attrs = append(attrs, "session_id", id)
+	}
+	logger := h.accessLogger
+	if logger == nil {
+		logger = logging.L()
+	}
+	logger.Log(r.Context(), level, "api.access", attrs...)
+}
+
+func isProbePath(path string) bool {
+	return path == "/v1/health" || path == "/v1/ready"
+}
+
+// redactedRequestURI returns the path and query with the access_token value
+// masked.
+func redactedRequestURI(u *url.URL) string {
+	query := u.Query()
+	if _, ok := query["access_token"]; !ok {
+		return u.RequestURI()
+	}
+	query.Set("access_token", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
+
+// incomingRequestID accepts a client supplied request ID when it is short and
+// printable, so that it is safe to echo and log.
+func incomingRequestID(value string) string {
+	if value == "" || len(value) > maxRequestIDLen {
+		return ""
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] <= ' ' || value[i] > '~' {
+			return ""
+		}
+	}
+	return value
+}
+
+func newRequestID() string {
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rtp-stream-cleaner/internal/config"
+	"rtp-stream-cleaner/internal/session"
+)
+
+func newAccessLogHandler(manager SessionManager, mode string, out *bytes.Buffer) *Handler {
+	handler := NewHandler(config.Config{PublicIP: "203.0.113.1", ServicePassword: "test-password", AccessLog: mode}, manager)
+	handler.accessLogger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return handler
+}
+
+func decodeAccessLog(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// TestAccessLog_LogsRequestWithRedactedToken verifies the per-request entry:
+// method, path, status, session_id and role are logged, the access_token
+// query value never appears, and the generated request ID is returned in
+// X-Request-ID. This matters because access logs are shipped to shared log
+// storage where a leaked token grants full API access. Inputs: GET of a
+// session authenticated by query token. The expected output is one api.access
+// entry at info level with the redacted path and the response header value.
+func TestAccessLog_LogsRequestWithRedactedToken(t *testing.T) {
+	var out bytes.Buffer
+	handler := newAccessLogHandler(&mockManager{getResult: &session.Session{ID: "sess-log"}}, "", &out)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/sess-log", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	requestID := recorder.Header().Get(requestIDHeader)
+	if requestID == "" {
+		t.Fatalf("expected %s response header", requestIDHeader)
+	}
+	if strings.Contains(out.String(), "test-password") {
+		t.Fatalf("access token leaked into log: %s", out.String())
+	}
+	entries := decodeAccessLog(t, &out)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 access log entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry["msg"] != "api.access" || entry["level"] != "INFO" {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+	if entry["method"] != "GET" || entry["path"] != "/v1/session/sess-log?access_token=REDACTED" {
+		t.Fatalf("unexpected method/path %v %v", entry["method"], entry["path"])
+	}
+	if entry["status"] != float64(http.StatusOK) || entry["session_id"] != "sess-log" || entry["role"] != "admin" {
+		t.Fatalf("unexpected status/session/role in %v", entry)
+	}
+	if entry["request_id"] != requestID {
+		t.Fatalf("expected request_id %q, got %v", requestID, entry["request_id"])
+	}
+}
+
+// TestAccessLog_HonorsIncomingRequestID verifies that a caller supplied
+// X-Request-ID is echoed and logged so that controller and rtp-cleaner logs
+// can be joined, while an unprintable one is replaced. Inputs: a health
+// request with a valid ID and one with a control character. The expected
+// output is the same ID echoed for the first and a generated one for the
+// second.
+func TestAccessLog_HonorsIncomingRequestID(t *testing.T) {
+	var out bytes.Buffer
+	handler := newAccessLogHandler(&mockManager{}, "", &out)
+	mux := http.NewServeMux()
+	handler.Register(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	req.Header.Set("Authorization", "Bearer test-password")
+	req.Header.Set(requestIDHeader, "kamailio-42")
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	if got := recorder.Header().Get(requestIDHeader); got != "kamailio-42" {
+		t.Fatalf("expected echoed request id, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	req.Header.Set("Authorization", "Bearer test-password")
+	req.Header.Set(requestIDHeader, "bad\x01id")
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	if got := recorder.Header().Get(requestIDHeader); got == "" || got == "bad\x01id" {
+		t.Fatalf("expected generated request id, got %q", got)
+	}
+
+	entries := decodeAccessLog(t, &out)
+	if len(entries) != 2 || entries[0]["request_id"] != "kamailio-42" {
+		t.Fatalf("expected logged request id kamailio-42, got %v", entries)
+	}
+	if entries[0]["level"] != "DEBUG" {
+		t.Fatalf("expected health probe at debug level, got %v", entries[0]["level"])
+	}
+}
+
+// TestAccessLog_Modes verifies ACCESS_LOG filtering. Inputs: a successful and
+// an unauthorized request per mode. The expected output is both entries for
+// all, only the 401 for errors, and nothing for off; X-Request-ID is set in
+// every mode.
+func TestAccessLog_Modes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected []float64
+	}{
+		{"all", []float64{http.StatusOK, http.StatusUnauthorized}},
+		{"errors", []float64{http.StatusUnauthorized}},
+		{"off", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var out bytes.Buffer
+			handler := newAccessLogHandler(&mockManager{}, tt.mode, &out)
+
+			ok := performRequest(handler, http.MethodGet, "/v1/health", nil)
+			denied := performRequestWithToken(handler, http.MethodGet, "/v1/health", "wrong", nil)
+			if ok.Header().Get(requestIDHeader) == "" || denied.Header().Get(requestIDHeader) == "" {
+				t.Fatalf("expected %s on every response", requestIDHeader)
+			}
+
+			entries := decodeAccessLog(t, &out)
+			if len(entries) != len(tt.expected) {
+				t.Fatalf("expected %d entries, got %d: %v", len(tt.expected), len(entries), entries)
+			}
+			for i, status := range tt.expected {
+				if entries[i]["status"] != status {
+					t.Fatalf("entry %d: expected status %v, got %v", i, status, entries[i]["status"])
+				}
+			}
+		})
+	}
+}
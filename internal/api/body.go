@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxRequestBodyBytes applies when MAX_REQUEST_BODY_BYTES is not positive.
+const defaultMaxRequestBodyBytes = 64 << 10
+
+// bodyError is a rejected request body together with the status and message
+// returned to the client.
+type bodyError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *bodyError) Error() string {
+	return e.err.Error()
+}
+
+// decodeJSONBody decodes the request body into dst. Bodies larger than the
+// configured limit are rejected with 413 and non-JSON content types with 415.
+// A missing Content-Type is accepted for older clients. In strict mode unknown
+// fields are rejected with 400 instead of being ignored.
+func (h *Handler) decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) *bodyError {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			return &bodyError{
+				status:  http.StatusUnsupportedMediaType,
+				message: "content type must be application/json",
+				err:     fmt.Errorf("unsupported content type %q", contentType),
+			}
+		}
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxRequestBodyBytes))
+	if h.strictJSON {
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(dst)
+	if err == nil {
+		return nil
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &bodyError{
+			status:  http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit),
+			err:     err,
+		}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return &bodyError{
+			status:  http.StatusBadRequest,
+			message: "unknown field " + field,
+			err:     err,
+		}
+	}
+	return &bodyError{status: http.StatusBadRequest, message: "invalid json body", err: err}
+}
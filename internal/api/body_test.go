@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rtp-stream-cleaner/internal/config"
+	"rtp-stream-cleaner/internal/session"
+)
+
+// performJSONRequest sends body with the given Content-Type to handler using
+// the admin token.
+func performJSONRequest(handler *Handler, method, path, contentType, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	handler.Register(mux)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-password")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// TestAPI_JSONBody_TooLarge_413 verifies that a body over the configured
+// limit is rejected with 413 before it reaches the manager. This matters
+// because unbounded bodies let one client tie up memory. Inputs: a 64-byte
+// limit and a create request with a long call_id. The expected output is
+// HTTP 413 and no create call.
+func TestAPI_JSONBody_TooLarge_413(t *testing.T) {
+	manager := &mockManager{}
+	handler := NewHandler(config.Config{PublicIP: "203.0.113.1", ServicePassword: "test-password", MaxRequestBodyBytes: 64}, manager)
+
+	body := `{"call_id":"` + strings.Repeat("x", 128) + `","from_tag":"f","to_tag":"t"}`
+	recorder := performJSONRequest(handler, http.MethodPost, "/v1/session", "application/json", body)
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+	if manager.createWithOptionsCalls != 0 {
+		t.Fatalf("expected no session to be created")
+	}
+}
+
+func TestAPI_JSONBody_ContentType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		status      int
+	}{
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+	}
+	for _, tc := range cases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-json"}}
+			handler := newTestHandler(manager)
+
+			recorder := performJSONRequest(handler, http.MethodPost, "/v1/session/sess-ct/update", tc.contentType, `{}`)
+
+			if recorder.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, recorder.Code)
+			}
+		})
+	}
+}
+
+// TestAPI_JSONBody_StrictUnknownField verifies that STRICT_JSON turns a
+// misspelled field into a 400 naming the field, while the default mode keeps
+// ignoring it for compatibility. Inputs: an update with rtp_engine_dest in
+// place of rtpengine_dest.
+func TestAPI_JSONBody_StrictUnknownField(t *testing.T) {
+	body := `{"audio":{"rtp_engine_dest":"10.0.0.5:40100"}}`
+
+	lenient := newTestHandler(&mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-json"}})
+	if recorder := performJSONRequest(lenient, http.MethodPost, "/v1/session/sess-strict/update", "application/json", body); recorder.Code != http.StatusOK {
+		t.Fatalf("expected lenient status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-json"}}
+	strict := NewHandler(config.Config{PublicIP: "203.0.113.1", ServicePassword: "test-password", StrictJSON: true}, manager)
+	recorder := performJSONRequest(strict, http.MethodPost, "/v1/session/sess-strict/update", "application/json", body)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected strict status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if !bytes.Contains(recorder.Body.Bytes(), []byte(`rtp_engine_dest`)) {
+		t.Fatalf("expected error to name the unknown field, got %s", recorder.Body.String())
+	}
+	if manager.updateCalls != 0 {
+		t.Fatalf("expected UpdateDest not to be called")
+	}
+}
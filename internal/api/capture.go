@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"rtp-stream-cleaner/internal/logging"
+	"rtp-stream-cleaner/internal/session"
+)
+
+type captureRequest struct {
+	Enable   bool     `json:"enable"`
+	Legs     []string `json:"legs"`
+	MaxBytes int64    `json:"max_bytes"`
+}
+
+type captureResponse struct {
+	Active       bool     `json:"active"`
+	Path         string   `json:"path"`
+	Legs         []string `json:"legs"`
+	MaxBytes     int64    `json:"max_bytes"`
+	BytesWritten uint64   `json:"bytes_written"`
+	Packets      uint64   `json:"packets"`
+	Dropped      uint64   `json:"dropped"`
+}
+
+// newCaptureResponse returns nil for sessions that were never captured so the
+// capture object is omitted from GET responses.
+func newCaptureResponse(found *session.Session) *captureResponse {
+	state, ok := found.CaptureState()
+	if !ok {
+		return nil
+	}
+	legs := make([]string, 0, len(state.Legs))
+	for _, leg := range state.Legs {
+		legs = append(legs, string(leg))
+	}
+	return &captureResponse{
+		Active:       state.Active,
+		Path:         state.Path,
+		Legs:         legs,
+		MaxBytes:     state.MaxBytes,
+		BytesWritten: state.BytesWritten,
+		Packets:      state.Packets,
+		Dropped:      state.Dropped,
+	}
+}
+
+// handleSessionCapture starts or stops the PCAP capture of a session and
+// returns the session document with the resulting capture status.
+func (h *Handler) handleSessionCapture(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req captureRequest
+	if err := h.decodeJSONBody(w, r, &req); err != nil {
+		logging.WithSessionID(id).Warn("session.capture failed", "error", err)
+		writeJSON(w, err.status, errorResponse{Error: err.message})
+		return
+	}
+	var (
+		found *session.Session
+		ok    bool
+		err   error
+	)
+	if req.Enable {
+		if req.MaxBytes < 0 {
+			logging.WithSessionID(id).Warn("session.capture failed", "error", "max_bytes must be >= 0")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "max_bytes must be >= 0"})
+			return
+		}
+		legs, parseErr := session.ParseCaptureLegs(req.Legs)
+		if parseErr != nil {
+			logging.WithSessionID(id).Warn("session.capture failed", "error", parseErr, "field", "legs")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: parseErr.Error()})
+			return
+		}
+		found, ok, err = h.manager.StartCapture(id, session.CaptureOptions{Legs: legs, MaxBytes: req.MaxBytes})
+	} else {
+		found, ok, err = h.manager.StopCapture(id)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, session.ErrCaptureDisabled):
+			status = http.StatusBadRequest
+		case errors.Is(err, session.ErrCaptureActive):
+			status = http.StatusConflict
+		}
+		logging.WithSessionID(id).Warn("session.capture failed", "error", err)
+		writeJSON(w, status, errorResponse{Error: err.Error()})
+		return
+	}
+	if !ok {
+		logging.WithSessionID(id).Warn("session.capture failed", "error", "session not found")
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, newGetSessionResponse(h.publicIP, h.internalIP, found))
+}
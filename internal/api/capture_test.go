@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_SessionCapture_StartForwardsOptions verifies that enabling capture
+// passes the parsed legs and size limit to the manager and answers with the
+// session document. Inputs: enable=true with two legs and max_bytes. The
+// expected output is HTTP 200 and one StartCapture call carrying exactly those
+// options.
+func TestAPI_SessionCapture_StartForwardsOptions(t *testing.T) {
+	manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-cap"}}
+	handler := newTestHandler(manager)
+
+	body := `{"enable":true,"legs":["a_in","b_out"],"max_bytes":1048576}`
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-cap/capture", bytes.NewBufferString(body))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if manager.captureStartCalls != 1 || manager.captureStopCalls != 0 {
+		t.Fatalf("expected one StartCapture call, got start=%d stop=%d", manager.captureStartCalls, manager.captureStopCalls)
+	}
+	want := session.CaptureOptions{Legs: []session.CaptureLeg{session.CaptureLegAIn, session.CaptureLegBOut}, MaxBytes: 1048576}
+	if !reflect.DeepEqual(manager.captureOptions, want) {
+		t.Fatalf("unexpected capture options: %+v", manager.captureOptions)
+	}
+}
+
+func TestAPI_SessionCapture_DisableStops(t *testing.T) {
+	manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-cap"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-cap/capture", bytes.NewBufferString(`{"enable":false}`))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if manager.captureStopCalls != 1 || manager.captureStartCalls != 0 {
+		t.Fatalf("expected one StopCapture call, got start=%d stop=%d", manager.captureStartCalls, manager.captureStopCalls)
+	}
+}
+
+// TestAPI_SessionCapture_Errors verifies the status mapping for rejected
+// capture requests: malformed input and a missing CAPTURE_DIR are client
+// errors, an already running capture conflicts and an unknown id is 404.
+func TestAPI_SessionCapture_Errors(t *testing.T) {
+	cases := []struct {
+		name        string
+		body        string
+		manager     *mockManager
+		status      int
+		expectCalls int
+	}{
+		{"invalid-leg", `{"enable":true,"legs":["c_in"]}`, &mockManager{updateOK: true}, http.StatusBadRequest, 0},
+		{"negative-max-bytes", `{"enable":true,"max_bytes":-1}`, &mockManager{updateOK: true}, http.StatusBadRequest, 0},
+		{"capture-disabled", `{"enable":true}`, &mockManager{captureErr: session.ErrCaptureDisabled}, http.StatusBadRequest, 1},
+		{"already-active", `{"enable":true}`, &mockManager{updateOK: true, captureErr: session.ErrCaptureActive}, http.StatusConflict, 1},
+		{"unknown-session", `{"enable":true}`, &mockManager{updateOK: false}, http.StatusNotFound, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newTestHandler(tc.manager)
+
+			recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-cap/capture", bytes.NewBufferString(tc.body))
+
+			if recorder.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, recorder.Code)
+			}
+			if tc.manager.captureStartCalls != tc.expectCalls {
+				t.Fatalf("expected %d StartCapture calls, got %d", tc.expectCalls, tc.manager.captureStartCalls)
+			}
+		})
+	}
+}
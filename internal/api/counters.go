@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+const lastActivityField = "last_activity"
+
+// counterField is a single named counter of the compact session view. The
+// names match the keys of the full GET /v1/session/{id} response.
+type counterField struct {
+	name  string
+	value uint64
+}
+
+func sessionCounterFields(found *session.Session) []counterField {
+	audioCounters := found.AudioCountersSnapshot()
+	videoCounters := found.VideoCountersSnapshot()
+	return []counterField{
+		{"audio_a_in_pkts", audioCounters.AInPkts},
+		{"audio_a_in_bytes", audioCounters.AInBytes},
+		{"audio_b_out_pkts", audioCounters.BOutPkts},
+		{"audio_b_out_bytes", audioCounters.BOutBytes},
+		{"audio_b_in_pkts", audioCounters.BInPkts},
+		{"audio_b_in_bytes", audioCounters.BInBytes},
+		{"audio_a_out_pkts", audioCounters.AOutPkts},
+		{"audio_a_out_bytes", audioCounters.AOutBytes},
+		{"audio_drops", audioCounters.Drops},
+		{"audio_drops_no_dest", audioCounters.DropsNoDest},
+		{"audio_drops_no_peer", audioCounters.DropsNoPeer},
+		{"audio_drops_write_error", audioCounters.DropsWriteError},
+		{"audio_drops_peer_rejected", audioCounters.DropsPeerRejected},
+		{"audio_foreign_ssrc_drops", audioCounters.DropsForeignSSRC},
+		{"audio_a_non_rtp_drops", audioCounters.DropsNonRTPA},
+		{"audio_b_non_rtp_drops", audioCounters.DropsNonRTPB},
+		{"audio_truncated_drops", audioCounters.DropsTruncated},
+		{"audio_b_leg_source_mismatch", audioCounters.DropsBSource},
+		{"audio_ssrc_changes", audioCounters.SSRCChanges},
+		{"audio_peer_relearns", audioCounters.PeerRelearns},
+		{"audio_peer_switches_rejected", audioCounters.PeerSwitchesRejected},
+		{"audio_source_rejected", audioCounters.SourceRejected},
+		{"audio_a_seq_gaps", audioCounters.ASeq.Gaps},
+		{"audio_a_reordered", audioCounters.ASeq.Reordered},
+		{"audio_a_duplicates", audioCounters.ASeq.Duplicates},
+		{"audio_dtmf_events", audioCounters.DTMF.Events},
+		{"audio_ts_discontinuities", audioCounters.TSDiscontinuities},
+		{"audio_ignored_disabled", audioCounters.IgnoredDisabled},
+		{"audio_activity_suppressed", audioCounters.ActivitySuppressed},
+		{"audio_keepalives_sent", audioCounters.KeepalivesSent},
+		{"audio_rtcp_a_in_pkts", audioCounters.RTCP.AInPkts},
+		{"audio_rtcp_a_in_bytes", audioCounters.RTCP.AInBytes},
+		{"audio_rtcp_b_in_pkts", audioCounters.RTCP.BInPkts},
+		{"audio_rtcp_b_in_bytes", audioCounters.RTCP.BInBytes},
+		{"audio_rtcp_drops", audioCounters.RTCP.Drops},
+		{"audio_mux_rtcp_pkts", audioCounters.RTCP.MuxPkts},
+		{"video_a_in_pkts", videoCounters.AInPkts},
+		{"video_a_in_bytes", videoCounters.AInBytes},
+		{"video_b_out_pkts", videoCounters.BOutPkts},
+		{"video_b_out_bytes", videoCounters.BOutBytes},
+		{"video_b_in_pkts", videoCounters.BInPkts},
+		{"video_b_in_bytes", videoCounters.BInBytes},
+		{"video_a_out_pkts", videoCounters.AOutPkts},
+		{"video_a_out_bytes", videoCounters.AOutBytes},
+		{"video_drops", videoCounters.Drops},
+		{"video_drops_no_dest", videoCounters.DropsNoDest},
+		{"video_drops_no_peer", videoCounters.DropsNoPeer},
+		{"video_drops_write_error", videoCounters.DropsWriteError},
+		{"video_drops_peer_rejected", videoCounters.DropsPeerRejected},
+		{"video_foreign_ssrc_drops", videoCounters.DropsForeignSSRC},
+		{"video_rate_limited_drops", videoCounters.DropsRateLimited},
+		{"video_a_non_rtp_drops", videoCounters.DropsNonRTPA},
+		{"video_b_non_rtp_drops", videoCounters.DropsNonRTPB},
+		{"video_truncated_drops", videoCounters.DropsTruncated},
+		{"video_b_leg_source_mismatch", videoCounters.DropsBSource},
+		{"video_ssrc_changes", videoCounters.SSRCChanges},
+		{"video_peer_relearns", videoCounters.PeerRelearns},
+		{"video_peer_switches_rejected", videoCounters.PeerSwitchesRejected},
+		{"video_source_rejected", videoCounters.SourceRejected},
+		{"video_a_seq_gaps", videoCounters.ASeq.Gaps},
+		{"video_a_reordered", videoCounters.ASeq.Reordered},
+		{"video_a_duplicates", videoCounters.ASeq.Duplicates},
+		{"video_ignored_disabled", videoCounters.IgnoredDisabled},
+		{"video_activity_suppressed", videoCounters.ActivitySuppressed},
+		{"video_keepalives_sent", videoCounters.KeepalivesSent},
+		{"video_frames_started", videoCounters.VideoFramesStarted},
+		{"video_frames_ended", videoCounters.VideoFramesEnded},
+		{"video_frames_flushed", videoCounters.VideoFramesFlushed},
+		{"video_frames_reordered", videoCounters.VideoFramesReordered},
+		{"video_frames_dropped_incomplete", videoCounters.VideoFramesDropped},
+		{"video_pkts_dropped_incomplete", videoCounters.VideoPktsDropped},
+		{"video_frames_with_loss", videoCounters.VideoFramesWithLoss},
+		{"video_sei_dropped", videoCounters.VideoSEIDropped},
+		{"video_forced_flushes", videoCounters.VideoForcedFlushes},
+		{"video_injected_vps", videoCounters.VideoInjectedVPS},
+		{"video_injected_sps", videoCounters.VideoInjectedSPS},
+		{"video_injected_pps", videoCounters.VideoInjectedPPS},
+		{"video_sps_changes", videoCounters.VideoSPSChanges},
+		{"video_pps_changes", videoCounters.VideoPPSChanges},
+		{"video_seq_delta_current", videoCounters.VideoSeqDelta},
+		{"video_injection_retries", videoCounters.VideoInjectionRetries},
+		{"video_injection_failures", videoCounters.VideoInjectionFailures},
+		{"video_keyframe_requests", videoCounters.VideoKeyframeRequests},
+		{"video_pli_sent", videoCounters.VideoPLISent},
+		{"video_fir_sent", videoCounters.VideoFIRSent},
+		{"video_frame_buffer_overflows", videoCounters.VideoBufferOverflows},
+		{"video_rtcp_a_in_pkts", videoCounters.RTCP.AInPkts},
+		{"video_rtcp_a_in_bytes", videoCounters.RTCP.AInBytes},
+		{"video_rtcp_b_in_pkts", videoCounters.RTCP.BInPkts},
+		{"video_rtcp_b_in_bytes", videoCounters.RTCP.BInBytes},
+		{"video_rtcp_drops", videoCounters.RTCP.Drops},
+		{"video_mux_rtcp_pkts", videoCounters.RTCP.MuxPkts},
+		{"kernel_rcv_drops", found.KernelRcvDrops()},
+	}
+}
+
+// parseCounterFieldSelection turns the comma-separated fields query value into
+// a lookup set. A nil set means every field is selected.
+func parseCounterFieldSelection(raw string, fields []counterField) (map[string]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(fields)+1)
+	for _, field := range fields {
+		known[field.name] = true
+	}
+	known[lastActivityField] = true
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+// appendCountersJSON encodes the compact counters view without reflection so
+// that high-frequency polling stays cheap.
+func appendCountersJSON(dst []byte, fields []counterField, lastActivity string, selected map[string]bool) []byte {
+	dst = append(dst, '{')
+	first := true
+	for _, field := range fields {
+		if selected != nil && !selected[field.name] {
+			continue
+		}
+		if !first {
+			dst = append(dst, ',')
+		}
+		first = false
+		dst = append(dst, '"')
+		dst = append(dst, field.name...)
+		dst = append(dst, '"', ':')
+		dst = strconv.AppendUint(dst, field.value, 10)
+	}
+	if selected == nil || selected[lastActivityField] {
+		if !first {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, `"`+lastActivityField+`":`...)
+		dst = strconv.AppendQuote(dst, lastActivity)
+	}
+	dst = append(dst, '}', '\n')
+	return dst
+}
+
+func (h *Handler) handleSessionCountersByID(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	found, ok := h.manager.Get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+		return
+	}
+	fields := sessionCounterFields(found)
+	selected, err := parseCounterFieldSelection(r.URL.Query().Get("fields"), fields)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	body := appendCountersJSON(make([]byte, 0, 1024), fields, formatTime(found.LastActivityTime()), selected)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
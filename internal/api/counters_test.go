@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_SessionCounters_ReturnsOnlyCounters verifies that the compact view
+// carries the numeric counters and last_activity but none of the session
+// configuration. This matters because pollers use it purely for liveness and
+// must not pay for ports, destinations and identifiers. Inputs: GET on the
+// counters route for a known session. The expected output is HTTP 200 with
+// counter keys present and no id/audio/video keys. A regression would leak the
+// full session document into the compact view.
+func TestAPI_SessionCounters_ReturnsOnlyCounters(t *testing.T) {
+	manager := &mockManager{getResult: &session.Session{
+		ID:    "sess-counters",
+		Audio: session.Media{APort: 15000, BPort: 15001},
+		Video: session.Media{APort: 15002, BPort: 15003},
+	}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/sess-counters/counters", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	for _, key := range []string{"audio_a_in_pkts", "video_b_out_pkts", "video_seq_delta_current", "last_activity"} {
+		if _, ok := body[key]; !ok {
+			t.Fatalf("expected key %q in counters response: %v", key, body)
+		}
+	}
+	for _, key := range []string{"id", "call_id", "audio", "video", "public_ip", "state"} {
+		if _, ok := body[key]; ok {
+			t.Fatalf("unexpected key %q in counters response: %v", key, body)
+		}
+	}
+}
+
+func TestAPI_SessionCounters_FieldsSubset(t *testing.T) {
+	manager := &mockManager{getResult: &session.Session{ID: "sess-fields"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/sess-fields/counters?fields=audio_a_in_pkts,video_b_out_pkts", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if len(body) != 2 {
+		t.Fatalf("expected exactly 2 fields, got %v", body)
+	}
+	if _, ok := body["audio_a_in_pkts"]; !ok {
+		t.Fatalf("expected audio_a_in_pkts in response: %v", body)
+	}
+	if _, ok := body["video_b_out_pkts"]; !ok {
+		t.Fatalf("expected video_b_out_pkts in response: %v", body)
+	}
+}
+
+func TestAPI_SessionCounters_UnknownField_400(t *testing.T) {
+	manager := &mockManager{getResult: &session.Session{ID: "sess-bad-field"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/sess-bad-field/counters?fields=public_ip", nil)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestAPI_SessionCounters_UnknownID_404(t *testing.T) {
+	manager := &mockManager{}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/unknown/counters", nil)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
+
+func BenchmarkSessionResponseEncode_Full(b *testing.B) {
+	found := &session.Session{ID: "sess-bench", CallID: "call-bench", FromTag: "from", ToTag: "to"}
+	encoder := json.NewEncoder(io.Discard)
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = encoder.Encode(newGetSessionResponse("203.0.113.1", "10.0.0.1", found))
+	}
+}
+
+func BenchmarkSessionResponseEncode_Counters(b *testing.B) {
+	found := &session.Session{ID: "sess-bench", CallID: "call-bench", FromTag: "from", ToTag: "to"}
+	buffer := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for b.Loop() {
+		buffer = appendCountersJSON(buffer[:0], sessionCounterFields(found), formatTime(found.LastActivityTime()), nil)
+	}
+}
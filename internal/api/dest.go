@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// errorCodeDestUnresolvable marks an rtpengine_dest whose hostname did not
+// resolve.
+const errorCodeDestUnresolvable = "dest_unresolvable"
+
+var errDestUnresolvable = errors.New("host does not resolve")
+
+// destination is a parsed rtpengine_dest. host is set when the destination
+// was given as a hostname and addr holds its resolved address.
+type destination struct {
+	addr *net.UDPAddr
+	host string
+}
+
+// parseDest accepts ip:port or host:port. Hostnames are resolved to an IPv4
+// address because the media sockets are bound to IPv4 addresses; port 0
+// disables media and is not resolved.
+func (h *Handler) parseDest(raw string) (destination, error) {
+	host, port, err := net.SplitHostPort(raw)
+	if err != nil || host == "" {
+		return destination{}, fmt.Errorf("must be in host:port format with port 0..65535 (0 disables media)")
+	}
+	portValue, err := strconv.Atoi(port)
+	if err != nil || portValue < 0 || portValue > 65535 {
+		return destination{}, fmt.Errorf("must be in host:port format with port 0..65535 (0 disables media)")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return destination{addr: &net.UDPAddr{IP: ip, Port: portValue}}, nil
+	}
+	if portValue == 0 {
+		return destination{addr: &net.UDPAddr{Port: 0}}, nil
+	}
+	resolved, err := h.resolveUDPAddr("udp4", raw)
+	if err != nil {
+		return destination{}, fmt.Errorf("%w: %s: %v", errDestUnresolvable, host, err)
+	}
+	return destination{addr: resolved, host: host}, nil
+}
+
+// destErrorCode returns the error code for a parseDest failure.
+func destErrorCode(err error) string {
+	if errors.Is(err, errDestUnresolvable) {
+		return errorCodeDestUnresolvable
+	}
+	return ""
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// stubResolver resolves media-1.internal and fails every other name.
+func stubResolver(calls *[]string) func(network, address string) (*net.UDPAddr, error) {
+	return func(network, address string) (*net.UDPAddr, error) {
+		*calls = append(*calls, network+" "+address)
+		if address == "media-1.internal:40100" {
+			return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 20), Port: 40100}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+// TestAPI_CreateSession_ResolvesHostnameDest verifies that a hostname
+// rtpengine_dest is resolved at create time and that both the address and the
+// hostname reach the manager, which needs the name for re-resolution. Inputs:
+// audio dest media-1.internal:40100 and an IP literal video dest. The expected
+// output is HTTP 200, one udp4 lookup and CreateWithOptions carrying the
+// resolved audio address with its host and the video literal without one.
+func TestAPI_CreateSession_ResolvesHostnameDest(t *testing.T) {
+	manager := &mockManager{createWithOptionsResult: &session.Session{ID: "sess-dns"}}
+	handler := newTestHandler(manager)
+	var lookups []string
+	handler.resolveUDPAddr = stubResolver(&lookups)
+
+	body := `{"call_id":"c","from_tag":"f","to_tag":"t","audio":{"rtpengine_dest":"media-1.internal:40100"},"video":{"rtpengine_dest":"192.0.2.11:40102"}}`
+	recorder := performRequest(handler, http.MethodPost, "/v1/session", bytes.NewBufferString(body))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if len(lookups) != 1 || lookups[0] != "udp4 media-1.internal:40100" {
+		t.Fatalf("expected one udp4 lookup, got %v", lookups)
+	}
+	opts := manager.createWithOptionsInput
+	if opts.InitialAudioDest.String() != "192.0.2.20:40100" || opts.InitialAudioDestHost != "media-1.internal" {
+		t.Fatalf("unexpected audio dest %v host %q", opts.InitialAudioDest, opts.InitialAudioDestHost)
+	}
+	if opts.InitialVideoDest.String() != "192.0.2.11:40102" || opts.InitialVideoDestHost != "" {
+		t.Fatalf("unexpected video dest %v host %q", opts.InitialVideoDest, opts.InitialVideoDestHost)
+	}
+}
+
+// TestAPI_CreateSession_UnresolvableDest verifies that a hostname that does
+// not resolve is reported in fields with code dest_unresolvable, so that it
+// can be told apart from a malformed value. Inputs: audio dest with an
+// unknown name and a malformed video dest. The expected output is HTTP 400
+// with two field errors, only the first carrying the code.
+func TestAPI_CreateSession_UnresolvableDest(t *testing.T) {
+	manager := &mockManager{}
+	handler := newTestHandler(manager)
+	var lookups []string
+	handler.resolveUDPAddr = stubResolver(&lookups)
+
+	body := `{"call_id":"c","from_tag":"f","to_tag":"t","audio":{"rtpengine_dest":"media-9.internal:40100"},"video":{"rtpengine_dest":"media-1.internal"}}`
+	recorder := performRequest(handler, http.MethodPost, "/v1/session", bytes.NewBufferString(body))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if len(resp.Fields) != 2 {
+		t.Fatalf("expected 2 field errors, got %v", resp.Fields)
+	}
+	if resp.Fields[0].Field != "audio.rtpengine_dest" || resp.Fields[0].Code != errorCodeDestUnresolvable {
+		t.Fatalf("unexpected audio field error %+v", resp.Fields[0])
+	}
+	if resp.Fields[1].Field != "video.rtpengine_dest" || resp.Fields[1].Code != "" {
+		t.Fatalf("unexpected video field error %+v", resp.Fields[1])
+	}
+	if manager.createWithOptionsCalls != 0 {
+		t.Fatalf("expected no create call")
+	}
+}
+
+// TestAPI_UpdateSession_HostnameDest verifies hostname handling on update: a
+// resolvable name is passed to UpdateDest with its host, an unresolvable one
+// fails with 400 and code dest_unresolvable, and port 0 disables media
+// without a lookup. Inputs: three updates against one session. The expected
+// output is one UpdateDest call with host media-1.internal, then a 400 with
+// the code, then an UpdateDest with port 0 and no further lookup.
+func TestAPI_UpdateSession_HostnameDest(t *testing.T) {
+	manager := &mockManager{updateOK: true}
+	manager.getResult = &session.Session{ID: "sess-dns"}
+	manager.updateResult = manager.getResult
+	handler := newTestHandler(manager)
+	var lookups []string
+	handler.resolveUDPAddr = stubResolver(&lookups)
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-dns/update", bytes.NewBufferString(`{"video":{"rtpengine_dest":"media-1.internal:40100"}}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if manager.updateInput.videoDest.String() != "192.0.2.20:40100" || manager.updateInput.videoHost != "media-1.internal" {
+		t.Fatalf("unexpected video update %v host %q", manager.updateInput.videoDest, manager.updateInput.videoHost)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session/sess-dns/update", bytes.NewBufferString(`{"video":{"rtpengine_dest":"media-9.internal:40100"}}`))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if resp.Code != errorCodeDestUnresolvable {
+		t.Fatalf("expected code %q, got %q", errorCodeDestUnresolvable, resp.Code)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session/sess-dns/update", bytes.NewBufferString(`{"video":{"rtpengine_dest":"media-9.internal:0"}}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if manager.updateInput.videoDest == nil || manager.updateInput.videoDest.Port != 0 || manager.updateInput.videoHost != "" {
+		t.Fatalf("unexpected port 0 update %v host %q", manager.updateInput.videoDest, manager.updateInput.videoHost)
+	}
+	if manager.updateCalls != 2 || len(lookups) != 2 {
+		t.Fatalf("expected 2 updates and 2 lookups, got %d and %v", manager.updateCalls, lookups)
+	}
+}
+
+// TestMediaStateResponse_ReportsDestHost verifies that GET shows both the
+// configured hostname and the address currently in use. Inputs: media state
+// with a resolved destination and its host. The expected output is
+// rtpengine_dest with the address and rtpengine_dest_host with the name.
+func TestMediaStateResponse_ReportsDestHost(t *testing.T) {
+	resp := newMediaStateResponse(session.Media{
+		RTPEngineDest:     &net.UDPAddr{IP: net.IPv4(192, 0, 2, 20), Port: 40100},
+		RTPEngineDestHost: "media-1.internal",
+		Enabled:           true,
+	})
+	if resp.RTPEngineDest != "192.0.2.20:40100" || resp.RTPEngineDestHost != "media-1.internal" {
+		t.Fatalf("unexpected dest %q host %q", resp.RTPEngineDest, resp.RTPEngineDestHost)
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"rtp-stream-cleaner/internal/logging"
+	"rtp-stream-cleaner/internal/session"
+)
+
+const (
+	eventsSubscriberBuffer = 64
+	eventSessionSnapshot   = "session.snapshot"
+)
+
+// sessionEventPayload is the data of one SSE message. Session mirrors the
+// GET /v1/session/{id} response.
+type sessionEventPayload struct {
+	SessionID string             `json:"session_id"`
+	Time      string             `json:"time"`
+	Session   getSessionResponse `json:"session"`
+}
+
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming unsupported"})
+		return
+	}
+	events, unsubscribe := h.manager.Subscribe(eventsSubscriberBuffer)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var snapshots <-chan time.Time
+	if h.eventsSnapshotInterval > 0 {
+		ticker := time.NewTicker(h.eventsSnapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				logging.L().Warn("events.stream closed", "reason", "evicted")
+				return
+			}
+			if err := h.writeSessionEvent(w, string(event.Type), event.Session, event.Time); err != nil {
+				return
+			}
+		case now := <-snapshots:
+			for _, found := range h.manager.List() {
+				if err := h.writeSessionEvent(w, eventSessionSnapshot, found, now); err != nil {
+					return
+				}
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) writeSessionEvent(w http.ResponseWriter, eventType string, found *session.Session, at time.Time) error {
+	data, err := json.Marshal(sessionEventPayload{
+		SessionID: found.ID,
+		Time:      formatTime(at),
+		Session:   newGetSessionResponse(h.publicIP, h.internalIP, found),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
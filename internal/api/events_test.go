@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// startEventStream opens GET /v1/events against handler and returns a reader
+// over the response body together with a func that disconnects the client.
+func startEventStream(t *testing.T, handler *Handler) (*bufio.Reader, func()) {
+	t.Helper()
+	mux := http.NewServeMux()
+	handler.Register(mux)
+	server := httptest.NewServer(mux)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v1/events?access_token=test-password", nil)
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	return bufio.NewReader(resp.Body), func() {
+		cancel()
+		_ = resp.Body.Close()
+		server.Close()
+	}
+}
+
+// readSSEMessage reads one SSE message and returns its event name and data.
+func readSSEMessage(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var eventType, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			return eventType, data
+		}
+		if value, ok := strings.CutPrefix(line, "event: "); ok {
+			eventType = value
+		}
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data = value
+		}
+	}
+}
+
+// TestAPI_Events_StreamsManagerEvents verifies that a manager event is written
+// to the SSE stream with its type as the event name and a payload mirroring the
+// GET response. This matters because dashboards decode the payload with the
+// same schema as GET /v1/session/{id}. Inputs: one session.created event pushed
+// through the mocked subscription. The expected output is a session.created
+// message whose data carries the session id at the top level and inside the
+// embedded session document.
+func TestAPI_Events_StreamsManagerEvents(t *testing.T) {
+	manager := &mockManager{events: make(chan session.Event, 1)}
+	handler := newTestHandler(manager)
+	reader, disconnect := startEventStream(t, handler)
+	defer disconnect()
+
+	manager.events <- session.Event{Type: session.EventSessionCreated, Session: &session.Session{ID: "sess-ev"}, Time: time.Now()}
+
+	eventType, data := readSSEMessage(t, reader)
+	if eventType != string(session.EventSessionCreated) {
+		t.Fatalf("unexpected event type %q", eventType)
+	}
+	var payload struct {
+		SessionID string         `json:"session_id"`
+		Session   map[string]any `json:"session"`
+	}
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if payload.SessionID != "sess-ev" || payload.Session["id"] != "sess-ev" {
+		t.Fatalf("unexpected payload: %s", data)
+	}
+	if _, ok := payload.Session["video_b_out_pkts"]; !ok {
+		t.Fatalf("expected counters in session payload: %s", data)
+	}
+}
+
+func TestAPI_Events_PeriodicSnapshots(t *testing.T) {
+	manager := &mockManager{listResult: []*session.Session{{ID: "sess-snap"}}}
+	handler := newTestHandler(manager)
+	handler.eventsSnapshotInterval = 10 * time.Millisecond
+	reader, disconnect := startEventStream(t, handler)
+	defer disconnect()
+
+	eventType, data := readSSEMessage(t, reader)
+	if eventType != eventSessionSnapshot {
+		t.Fatalf("unexpected event type %q", eventType)
+	}
+	if !strings.Contains(data, `"session_id":"sess-snap"`) {
+		t.Fatalf("unexpected snapshot payload: %s", data)
+	}
+}
+
+// TestAPI_Events_EndsWhenSubscriptionClosed verifies that the stream ends
+// once the manager closes the subscription, which is how a slow consumer is
+// evicted, and that the handler unsubscribes on the way out.
+func TestAPI_Events_EndsWhenSubscriptionClosed(t *testing.T) {
+	manager := &mockManager{events: make(chan session.Event), unsubscribed: make(chan struct{})}
+	handler := newTestHandler(manager)
+	reader, disconnect := startEventStream(t, handler)
+	defer disconnect()
+
+	close(manager.events)
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Fatalf("expected stream to end after eviction")
+	}
+	select {
+	case <-manager.unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected handler to unsubscribe")
+	}
+}
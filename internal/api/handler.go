@@ -1,12 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"rtp-stream-cleaner/internal/config"
@@ -15,18 +19,62 @@ import (
 )
 
 type SessionManager interface {
-	Create(callID, fromTag, toTag string, videoFix bool) (*session.Session, error)
-	CreateWithInitialDest(callID, fromTag, toTag string, videoFix bool, initialAudioDest, initialVideoDest *net.UDPAddr) (*session.Session, error)
+	CreateWithOptions(callID, fromTag, toTag string, videoFix bool, opts session.CreateOptions) (*session.Session, error)
 	Get(id string) (*session.Session, bool)
-	UpdateRTPDest(id string, audioDest, videoDest *net.UDPAddr) (*session.Session, bool)
-	Delete(id string) bool
+	UpdateDest(id string, update session.DestUpdate) (*session.Session, bool, error)
+	RearmVideoFix(id string) (*session.Session, bool)
+	ResetSSRCLock(id string, audio, video bool) (*session.Session, bool)
+	UpdateSource(id string, update session.SourceUpdate) (*session.Session, bool)
+	UpdateMetadata(id string, patch map[string]*string, replace bool) (*session.Session, bool, error)
+	StartCapture(id string, opts session.CaptureOptions) (*session.Session, bool, error)
+	StopCapture(id string) (*session.Session, bool, error)
+	SetLogLevel(id string, level *slog.Level) (*session.Session, bool)
+	RequestKeyframe(id string, sendPLI bool) (*session.Session, bool, error)
+	DeleteWithReason(id, reason string) (*session.Session, bool)
+	DeleteAll() []*session.Session
+	DeleteByCallID(callID string) []*session.Session
+	List() []*session.Session
+	PoolStats() session.PoolStats
+	Stats() session.Stats
+	MaxSessions() int
+	ShuttingDown() bool
+	Subscribe(buffer int) (<-chan session.Event, func())
 }
 
 type Handler struct {
-	manager         SessionManager
-	publicIP        string
-	internalIP      string
-	servicePassword string
+	manager                 SessionManager
+	publicIP                string
+	internalIP              string
+	servicePassword         string
+	servicePasswordReadonly string
+	eventsSnapshotInterval  time.Duration
+	maxRequestBodyBytes     int64
+	strictJSON              bool
+	rejectDuplicateSessions bool
+	accessLogMode           accessLogMode
+	accessLogger            *slog.Logger
+	resolveUDPAddr          func(network, address string) (*net.UDPAddr, error)
+	startedAt               time.Time
+}
+
+// role is the access level resolved from the request credential.
+type role int
+
+const (
+	roleNone role = iota
+	roleReadonly
+	roleAdmin
+)
+
+type roleContextKey struct{}
+
+// roleFromContext returns the role attached by the auth middleware.
+func roleFromContext(ctx context.Context) role {
+	resolved, ok := ctx.Value(roleContextKey{}).(role)
+	if !ok {
+		return roleNone
+	}
+	return resolved
 }
 
 func NewHandler(cfg config.Config, manager SessionManager) *Handler {
@@ -34,186 +82,639 @@ func NewHandler(cfg config.Config, manager SessionManager) *Handler {
 	if internalIP == "" {
 		internalIP = cfg.PublicIP
 	}
+	maxRequestBodyBytes := int64(cfg.MaxRequestBodyBytes)
+	if maxRequestBodyBytes <= 0 {
+		maxRequestBodyBytes = defaultMaxRequestBodyBytes
+	}
 	return &Handler{
-		manager:         manager,
-		publicIP:        cfg.PublicIP,
-		internalIP:      internalIP,
-		servicePassword: cfg.ServicePassword,
+		manager:                 manager,
+		publicIP:                cfg.PublicIP,
+		internalIP:              internalIP,
+		servicePassword:         cfg.ServicePassword,
+		servicePasswordReadonly: cfg.ServicePasswordReadonly,
+		eventsSnapshotInterval:  time.Duration(cfg.EventsSnapshotIntervalSec) * time.Second,
+		maxRequestBodyBytes:     maxRequestBodyBytes,
+		strictJSON:              cfg.StrictJSON,
+		rejectDuplicateSessions: cfg.RejectDuplicateSessions,
+		accessLogMode:           parseAccessLogMode(cfg.AccessLog),
+		resolveUDPAddr:          net.ResolveUDPAddr,
+		startedAt:               time.Now(),
 	}
 }
 
 func (h *Handler) Register(mux *http.ServeMux) {
-	mux.Handle("GET /v1/health", h.withAccessTokenAuth(http.HandlerFunc(h.handleHealth)))
-	mux.Handle("POST /v1/session", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionCreate)))
-	mux.Handle("GET /v1/session/{id}", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionGetByID)))
-	mux.Handle("DELETE /v1/session/{id}", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionDeleteByID)))
-	mux.Handle("POST /v1/session/{id}/update", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionUpdateByID)))
-	mux.Handle("POST /v1/session/{id}/delete", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionDeleteByID)))
+	h.handle(mux, "GET /v1/openapi.json", http.HandlerFunc(h.handleOpenAPI))
+	h.handle(mux, "GET /v1/health", h.withAccessTokenAuth(http.HandlerFunc(h.handleHealth)))
+	h.handle(mux, "HEAD /v1/health", h.withAccessTokenAuth(headOnly(http.HandlerFunc(h.handleHealth))))
+	h.handle(mux, "GET /v1/version", h.withAccessTokenAuth(http.HandlerFunc(h.handleVersion)))
+	h.handle(mux, "GET /v1/stats", h.withAccessTokenAuth(http.HandlerFunc(h.handleStats)))
+	h.handle(mux, "GET /v1/ready", h.withAccessTokenAuth(http.HandlerFunc(h.handleReady)))
+	h.handle(mux, "GET /v1/events", h.withAccessTokenAuth(http.HandlerFunc(h.handleEvents)))
+	h.handle(mux, "POST /v1/session", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionCreate))))
+	h.handle(mux, "GET /v1/session/{id}", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionGetByID)))
+	h.handle(mux, "HEAD /v1/session/{id}", h.withAccessTokenAuth(headOnly(http.HandlerFunc(h.handleSessionGetByID))))
+	h.handle(mux, "GET /v1/session/{id}/counters", h.withAccessTokenAuth(http.HandlerFunc(h.handleSessionCountersByID)))
+	h.handle(mux, "DELETE /v1/session/{id}", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionDeleteByID))))
+	h.handle(mux, "POST /v1/session/{id}/update", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionUpdateByID))))
+	h.handle(mux, "POST /v1/session/{id}/capture", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionCapture))))
+	h.handle(mux, "POST /v1/session/{id}/loglevel", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionLogLevel))))
+	h.handle(mux, "POST /v1/session/{id}/request-keyframe", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionRequestKeyframe))))
+	h.handle(mux, "POST /v1/session/{id}/delete", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionDeleteByID))))
+	h.handle(mux, "DELETE /v1/sessions", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionsDelete))))
+	h.handle(mux, "POST /v1/sessions/delete", h.withAccessTokenAuth(requireAdmin(http.HandlerFunc(h.handleSessionsDelete))))
+}
+
+// handle registers a route behind the access log middleware.
+func (h *Handler) handle(mux *http.ServeMux, pattern string, handler http.Handler) {
+	mux.Handle(pattern, h.withAccessLog(handler))
 }
 
 func (h *Handler) withAccessTokenAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.URL.Query().Get("access_token")
-		if token == "" || token != h.servicePassword {
+		resolved := h.resolveRole(accessToken(r))
+		if resolved == roleNone {
 			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleContextKey{}, resolved)))
+	})
+}
+
+func (h *Handler) resolveRole(token string) role {
+	if token == "" {
+		return roleNone
+	}
+	if token == h.servicePassword {
+		return roleAdmin
+	}
+	if h.servicePasswordReadonly != "" && token == h.servicePasswordReadonly {
+		return roleReadonly
+	}
+	return roleNone
+}
+
+// requireAdmin rejects requests whose resolved role cannot modify sessions.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if roleFromContext(r.Context()) != roleAdmin {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "forbidden"})
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// accessToken returns the credential presented by the client. Headers take
+// precedence over the legacy access_token query parameter so that a wrong
+// header is never masked by a correct query value.
+func accessToken(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	if token := r.Header.Get("X-Access-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("access_token")
+}
+
 type createSessionRequest struct {
 	CallID  string `json:"call_id"`
 	FromTag string `json:"from_tag"`
 	ToTag   string `json:"to_tag"`
 	Audio   struct {
-		Enable        bool    `json:"enable"`
-		RTPEngineDest *string `json:"rtpengine_dest"`
+		Enable                *bool   `json:"enable"`
+		RTPEngineDest         *string `json:"rtpengine_dest"`
+		PeerLearningWindowSec *int    `json:"peer_learning_window_sec"`
+		APort                 *int    `json:"a_port"`
+		BPort                 *int    `json:"b_port"`
+		RewriteSSRC           *bool   `json:"rewrite_ssrc"`
+		OutputSSRC            *int64  `json:"output_ssrc"`
+		Source                *string `json:"source"`
 	} `json:"audio"`
 	Video struct {
-		Enable        bool    `json:"enable"`
-		Fix           *bool   `json:"fix"`
-		RTPEngineDest *string `json:"rtpengine_dest"`
+		Enable                *bool   `json:"enable"`
+		Fix                   *bool   `json:"fix"`
+		RTPEngineDest         *string `json:"rtpengine_dest"`
+		PeerLearningWindowSec *int    `json:"peer_learning_window_sec"`
+		APort                 *int    `json:"a_port"`
+		BPort                 *int    `json:"b_port"`
+		MaxFrameWaitMS        *int    `json:"max_frame_wait_ms"`
+		IncompleteFrames      *string `json:"incomplete_frames"`
+		Codec                 *string `json:"codec"`
+		DropSEI               *bool   `json:"drop_sei"`
+		StripAnnexB           *bool   `json:"strip_annexb"`
+		TimestampMode         *string `json:"timestamp_mode"`
+		MaxKbps               *int    `json:"max_kbps"`
+		RewriteSSRC           *bool   `json:"rewrite_ssrc"`
+		OutputSSRC            *int64  `json:"output_ssrc"`
+		Source                *string `json:"source"`
 	} `json:"video"`
+	Metadata              map[string]string `json:"metadata"`
+	RejectDuplicate       *bool             `json:"reject_duplicate"`
+	AdvertiseIP           string            `json:"advertise_ip"`
+	LogLevel              string            `json:"log_level"`
+	MaxLifetimeSec        *int              `json:"max_lifetime_sec"`
+	PeerLearningWindowSec *int              `json:"peer_learning_window_sec"`
+	LockSSRC              *bool             `json:"lock_ssrc"`
+	DSCP                  *int              `json:"dscp"`
+	TTL                   *int              `json:"ttl"`
+	AllowHairpin          bool              `json:"allow_hairpin"`
 }
 
 type updateSessionRequest struct {
-	Audio *updateMediaRequest `json:"audio"`
-	Video *updateMediaRequest `json:"video"`
+	Audio           *updateMediaRequest `json:"audio"`
+	Video           *updateMediaRequest `json:"video"`
+	Metadata        map[string]*string  `json:"metadata"`
+	ReplaceMetadata bool                `json:"replace_metadata"`
+	AllowHairpin    bool                `json:"allow_hairpin"`
 }
 
 type updateMediaRequest struct {
-	RTPEngineDest *string `json:"rtpengine_dest"`
+	RTPEngineDest optionalAddr `json:"rtpengine_dest"`
+	Source        optionalAddr `json:"source"`
+	RearmFix      bool         `json:"rearm_fix"`
+	ResetSSRCLock bool         `json:"reset_ssrc_lock"`
 }
 
-type portResponse struct {
-	APort int `json:"a_port"`
-	BPort int `json:"b_port"`
+// optionalAddr tells an absent rtpengine_dest or source, which leaves it
+// unchanged, from an explicit null, which clears it.
+type optionalAddr struct {
+	Set   bool
+	Value *string
+}
+
+func (d *optionalAddr) UnmarshalJSON(data []byte) error {
+	d.Set = true
+	d.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	d.Value = &value
+	return nil
+}
+
+// cleared reports whether the request explicitly set the address to null.
+func (d optionalAddr) cleared() bool {
+	return d.Set && d.Value == nil
 }
 
 type mediaStateResponse struct {
-	APort          int    `json:"a_port"`
-	BPort          int    `json:"b_port"`
-	RTPEngineDest  string `json:"rtpengine_dest"`
-	Enabled        bool   `json:"enabled"`
-	DisabledReason string `json:"disabled_reason,omitempty"`
+	APort                   int    `json:"a_port"`
+	BPort                   int    `json:"b_port"`
+	RTPEngineDest           string `json:"rtpengine_dest"`
+	RTPEngineDestHost       string `json:"rtpengine_dest_host,omitempty"`
+	Enabled                 bool   `json:"enabled"`
+	DisabledReason          string `json:"disabled_reason,omitempty"`
+	PeerLearningWindowSec   int    `json:"peer_learning_window_sec"`
+	PeerLearningRemainingMS int64  `json:"peer_learning_remaining_ms"`
+	DoorphonePeer           string `json:"doorphone_peer"`
+	DoorphonePeerLearnedAt  string `json:"doorphone_peer_learned_at"`
+	DoorphonePeerRelearned  bool   `json:"doorphone_peer_relearned"`
+	LastActivity            string `json:"last_activity"`
+	// LockedSSRC is set once a session with lock_ssrc latched the media.
+	LockedSSRC *uint32 `json:"locked_ssrc,omitempty"`
+	// OutputSSRC is set when the media rewrites its SSRC toward rtpengine.
+	OutputSSRC *uint32 `json:"output_ssrc,omitempty"`
+	// Source is set when the media only accepts A-leg packets from it, and
+	// SourceMatches then tells whether the last A-leg sender did.
+	Source        string `json:"source,omitempty"`
+	SourceMatches *bool  `json:"source_matches,omitempty"`
 }
 
 type createSessionResponse struct {
-	ID         string       `json:"id"`
-	PublicIP   string       `json:"public_ip"`
-	InternalIP string       `json:"internal_ip"`
-	Audio      portResponse `json:"audio"`
-	Video      portResponse `json:"video"`
+	ID         string             `json:"id"`
+	PublicIP   string             `json:"public_ip"`
+	InternalIP string             `json:"internal_ip"`
+	Audio      mediaStateResponse `json:"audio"`
+	Video      mediaStateResponse `json:"video"`
 }
 
 type getSessionResponse struct {
-	ID                 string             `json:"id"`
-	CallID             string             `json:"call_id"`
-	FromTag            string             `json:"from_tag"`
-	ToTag              string             `json:"to_tag"`
-	PublicIP           string             `json:"public_ip"`
-	InternalIP         string             `json:"internal_ip"`
-	Audio              mediaStateResponse `json:"audio"`
-	Video              mediaStateResponse `json:"video"`
-	AudioAInPkts       uint64             `json:"audio_a_in_pkts"`
-	AudioAInBytes      uint64             `json:"audio_a_in_bytes"`
-	AudioBOutPkts      uint64             `json:"audio_b_out_pkts"`
-	AudioBOutBytes     uint64             `json:"audio_b_out_bytes"`
-	AudioBInPkts       uint64             `json:"audio_b_in_pkts"`
-	AudioBInBytes      uint64             `json:"audio_b_in_bytes"`
-	AudioAOutPkts      uint64             `json:"audio_a_out_pkts"`
-	AudioAOutBytes     uint64             `json:"audio_a_out_bytes"`
-	VideoAInPkts       uint64             `json:"video_a_in_pkts"`
-	VideoAInBytes      uint64             `json:"video_a_in_bytes"`
-	VideoBOutPkts      uint64             `json:"video_b_out_pkts"`
-	VideoBOutBytes     uint64             `json:"video_b_out_bytes"`
-	VideoBInPkts       uint64             `json:"video_b_in_pkts"`
-	VideoBInBytes      uint64             `json:"video_b_in_bytes"`
-	VideoAOutPkts      uint64             `json:"video_a_out_pkts"`
-	VideoAOutBytes     uint64             `json:"video_a_out_bytes"`
-	VideoFramesStarted uint64             `json:"video_frames_started"`
-	VideoFramesEnded   uint64             `json:"video_frames_ended"`
-	VideoFramesFlushed uint64             `json:"video_frames_flushed"`
-	VideoForcedFlushes uint64             `json:"video_forced_flushes"`
-	VideoInjectedSPS   uint64             `json:"video_injected_sps"`
-	VideoInjectedPPS   uint64             `json:"video_injected_pps"`
-	VideoSeqDelta      uint64             `json:"video_seq_delta_current"`
-	LastActivity       string             `json:"last_activity"`
-	State              string             `json:"state"`
+	ID                        string                 `json:"id"`
+	CallID                    string                 `json:"call_id"`
+	FromTag                   string                 `json:"from_tag"`
+	ToTag                     string                 `json:"to_tag"`
+	PublicIP                  string                 `json:"public_ip"`
+	AdvertiseIP               string                 `json:"advertise_ip,omitempty"`
+	InternalIP                string                 `json:"internal_ip"`
+	CreatedAt                 string                 `json:"created_at"`
+	DurationSec               int64                  `json:"duration_sec"`
+	ExpiresAt                 string                 `json:"expires_at,omitempty"`
+	Restored                  bool                   `json:"restored"`
+	VideoFixEnabled           bool                   `json:"video_fix_enabled"`
+	VideoInjectSPSPPS         bool                   `json:"video_inject_sps_pps"`
+	PeerLearningWindowSec     int                    `json:"peer_learning_window_sec"`
+	MaxFrameWaitMS            int64                  `json:"max_frame_wait_ms"`
+	IncompleteFrames          string                 `json:"incomplete_frames"`
+	VideoCodec                string                 `json:"video_codec"`
+	VideoDropSEI              bool                   `json:"video_drop_sei"`
+	VideoStripAnnexB          bool                   `json:"video_strip_annexb"`
+	VideoTimestampMode        string                 `json:"video_timestamp_mode"`
+	VideoMaxKbps              int                    `json:"video_max_kbps"`
+	LockSSRC                  bool                   `json:"lock_ssrc"`
+	DSCP                      int                    `json:"dscp"`
+	TTL                       int                    `json:"ttl"`
+	Audio                     mediaStateResponse     `json:"audio"`
+	Video                     mediaStateResponse     `json:"video"`
+	AudioAInPkts              uint64                 `json:"audio_a_in_pkts"`
+	AudioAInBytes             uint64                 `json:"audio_a_in_bytes"`
+	AudioBOutPkts             uint64                 `json:"audio_b_out_pkts"`
+	AudioBOutBytes            uint64                 `json:"audio_b_out_bytes"`
+	AudioBInPkts              uint64                 `json:"audio_b_in_pkts"`
+	AudioBInBytes             uint64                 `json:"audio_b_in_bytes"`
+	AudioAOutPkts             uint64                 `json:"audio_a_out_pkts"`
+	AudioAOutBytes            uint64                 `json:"audio_a_out_bytes"`
+	AudioDrops                uint64                 `json:"audio_drops"`
+	AudioDropsNoDest          uint64                 `json:"audio_drops_no_dest"`
+	AudioDropsNoPeer          uint64                 `json:"audio_drops_no_peer"`
+	AudioDropsWriteError      uint64                 `json:"audio_drops_write_error"`
+	AudioDropsPeerRejected    uint64                 `json:"audio_drops_peer_rejected"`
+	AudioForeignSSRCDrops     uint64                 `json:"audio_foreign_ssrc_drops"`
+	AudioANonRTPDrops         uint64                 `json:"audio_a_non_rtp_drops"`
+	AudioBNonRTPDrops         uint64                 `json:"audio_b_non_rtp_drops"`
+	AudioTruncatedDrops       uint64                 `json:"audio_truncated_drops"`
+	AudioBSourceMismatch      uint64                 `json:"audio_b_leg_source_mismatch"`
+	AudioSSRCChanges          uint64                 `json:"audio_ssrc_changes"`
+	AudioPeerRelearns         uint64                 `json:"audio_peer_relearns"`
+	AudioPeerSwitchesRejected uint64                 `json:"audio_peer_switches_rejected"`
+	AudioSourceRejected       uint64                 `json:"audio_source_rejected"`
+	AudioASeqGaps             uint64                 `json:"audio_a_seq_gaps"`
+	AudioAReordered           uint64                 `json:"audio_a_reordered"`
+	AudioADuplicates          uint64                 `json:"audio_a_duplicates"`
+	AudioAJitterMS            float64                `json:"audio_a_jitter_ms"`
+	AudioAJitterMaxMS         float64                `json:"audio_a_jitter_max_ms"`
+	AudioDTMFEvents           uint64                 `json:"audio_dtmf_events"`
+	AudioLastDTMF             []dtmfDigitResponse    `json:"audio_last_dtmf"`
+	AudioTSDiscontinuities    uint64                 `json:"audio_ts_discontinuities"`
+	AudioIgnoredDisabled      uint64                 `json:"audio_ignored_disabled"`
+	AudioActivitySuppressed   uint64                 `json:"audio_activity_suppressed"`
+	AudioKeepalivesSent       uint64                 `json:"audio_keepalives_sent"`
+	AudioRTCPAInPkts          uint64                 `json:"audio_rtcp_a_in_pkts"`
+	AudioRTCPAInBytes         uint64                 `json:"audio_rtcp_a_in_bytes"`
+	AudioRTCPBInPkts          uint64                 `json:"audio_rtcp_b_in_pkts"`
+	AudioRTCPBInBytes         uint64                 `json:"audio_rtcp_b_in_bytes"`
+	AudioRTCPDrops            uint64                 `json:"audio_rtcp_drops"`
+	AudioMuxRTCPPkts          uint64                 `json:"audio_mux_rtcp_pkts"`
+	VideoAInPkts              uint64                 `json:"video_a_in_pkts"`
+	VideoAInBytes             uint64                 `json:"video_a_in_bytes"`
+	VideoBOutPkts             uint64                 `json:"video_b_out_pkts"`
+	VideoBOutBytes            uint64                 `json:"video_b_out_bytes"`
+	VideoBInPkts              uint64                 `json:"video_b_in_pkts"`
+	VideoBInBytes             uint64                 `json:"video_b_in_bytes"`
+	VideoAOutPkts             uint64                 `json:"video_a_out_pkts"`
+	VideoAOutBytes            uint64                 `json:"video_a_out_bytes"`
+	VideoDrops                uint64                 `json:"video_drops"`
+	VideoDropsNoDest          uint64                 `json:"video_drops_no_dest"`
+	VideoDropsNoPeer          uint64                 `json:"video_drops_no_peer"`
+	VideoDropsWriteError      uint64                 `json:"video_drops_write_error"`
+	VideoDropsPeerRejected    uint64                 `json:"video_drops_peer_rejected"`
+	VideoForeignSSRCDrops     uint64                 `json:"video_foreign_ssrc_drops"`
+	VideoRateLimitedDrops     uint64                 `json:"video_rate_limited_drops"`
+	VideoANonRTPDrops         uint64                 `json:"video_a_non_rtp_drops"`
+	VideoBNonRTPDrops         uint64                 `json:"video_b_non_rtp_drops"`
+	VideoTruncatedDrops       uint64                 `json:"video_truncated_drops"`
+	VideoBSourceMismatch      uint64                 `json:"video_b_leg_source_mismatch"`
+	VideoSSRCChanges          uint64                 `json:"video_ssrc_changes"`
+	VideoPeerRelearns         uint64                 `json:"video_peer_relearns"`
+	VideoPeerSwitchesRejected uint64                 `json:"video_peer_switches_rejected"`
+	VideoSourceRejected       uint64                 `json:"video_source_rejected"`
+	VideoASeqGaps             uint64                 `json:"video_a_seq_gaps"`
+	VideoAReordered           uint64                 `json:"video_a_reordered"`
+	VideoADuplicates          uint64                 `json:"video_a_duplicates"`
+	VideoAJitterMS            float64                `json:"video_a_jitter_ms"`
+	VideoAJitterMaxMS         float64                `json:"video_a_jitter_max_ms"`
+	VideoBitrateBps           uint64                 `json:"video_bitrate_bps"`
+	VideoFPS                  float64                `json:"video_fps"`
+	VideoLastIDRAgeSec        *float64               `json:"video_last_idr_age_sec,omitempty"`
+	VideoIgnoredDisabled      uint64                 `json:"video_ignored_disabled"`
+	VideoActivitySuppressed   uint64                 `json:"video_activity_suppressed"`
+	VideoKeepalivesSent       uint64                 `json:"video_keepalives_sent"`
+	VideoFramesStarted        uint64                 `json:"video_frames_started"`
+	VideoFramesEnded          uint64                 `json:"video_frames_ended"`
+	VideoFramesFlushed        uint64                 `json:"video_frames_flushed"`
+	VideoFramesReordered      uint64                 `json:"video_frames_reordered"`
+	VideoFramesDropped        uint64                 `json:"video_frames_dropped_incomplete"`
+	VideoPktsDropped          uint64                 `json:"video_pkts_dropped_incomplete"`
+	VideoFramesWithLoss       uint64                 `json:"video_frames_with_loss"`
+	VideoSEIDropped           uint64                 `json:"video_sei_dropped"`
+	VideoForcedFlushes        uint64                 `json:"video_forced_flushes"`
+	VideoInjectedVPS          uint64                 `json:"video_injected_vps"`
+	VideoInjectedSPS          uint64                 `json:"video_injected_sps"`
+	VideoInjectedPPS          uint64                 `json:"video_injected_pps"`
+	VideoSPSChanges           uint64                 `json:"video_sps_changes"`
+	VideoPPSChanges           uint64                 `json:"video_pps_changes"`
+	VideoSeqDelta             uint64                 `json:"video_seq_delta_current"`
+	VideoInjectionRetries     uint64                 `json:"video_injection_retries"`
+	VideoInjectionFailures    uint64                 `json:"video_injection_failures"`
+	VideoKeyframeRequests     uint64                 `json:"video_keyframe_requests"`
+	VideoPLISent              uint64                 `json:"video_pli_sent"`
+	VideoFIRSent              uint64                 `json:"video_fir_sent"`
+	VideoBufferOverflows      uint64                 `json:"video_frame_buffer_overflows"`
+	VideoRTCPAInPkts          uint64                 `json:"video_rtcp_a_in_pkts"`
+	VideoRTCPAInBytes         uint64                 `json:"video_rtcp_a_in_bytes"`
+	VideoRTCPBInPkts          uint64                 `json:"video_rtcp_b_in_pkts"`
+	VideoRTCPBInBytes         uint64                 `json:"video_rtcp_b_in_bytes"`
+	VideoRTCPDrops            uint64                 `json:"video_rtcp_drops"`
+	VideoMuxRTCPPkts          uint64                 `json:"video_mux_rtcp_pkts"`
+	KernelRcvDrops            uint64                 `json:"kernel_rcv_drops"`
+	VideoFixBypassed          bool                   `json:"video_fix_bypassed"`
+	VideoFixBypassReason      string                 `json:"video_fix_bypass_reason,omitempty"`
+	VideoBufferPackets        int                    `json:"video_frame_buffer_packets"`
+	VideoBufferAgeMS          int64                  `json:"video_frame_buffer_age_ms"`
+	VideoCachedSPS            bool                   `json:"video_has_cached_sps"`
+	VideoCachedPPS            bool                   `json:"video_has_cached_pps"`
+	VideoPendingSPS           bool                   `json:"video_has_pending_sps"`
+	VideoPendingPPS           bool                   `json:"video_has_pending_pps"`
+	VideoWidth                int                    `json:"video_width,omitempty"`
+	VideoHeight               int                    `json:"video_height,omitempty"`
+	VideoProfile              string                 `json:"video_profile,omitempty"`
+	VideoLevel                string                 `json:"video_level,omitempty"`
+	Metadata                  map[string]string      `json:"metadata,omitempty"`
+	LogLevel                  string                 `json:"log_level,omitempty"`
+	Capture                   *captureResponse       `json:"capture,omitempty"`
+	Events                    []historyEntryResponse `json:"events,omitempty"`
+	LastActivity              string                 `json:"last_activity"`
+	State                     string                 `json:"state"`
+}
+
+type bulkDeleteResponse struct {
+	Deleted int `json:"deleted"`
 }
 
 type errorResponse struct {
-	Error string `json:"error"`
+	Error      string       `json:"error"`
+	Code       string       `json:"code,omitempty"`
+	SessionID  string       `json:"session_id,omitempty"`
+	SessionIDs []string     `json:"session_ids,omitempty"`
+	Fields     []fieldError `json:"fields,omitempty"`
 }
 
+// errorCodeMediaNotEnabled rejects a destination for media that was disabled
+// at create time.
+const errorCodeMediaNotEnabled = "media_not_enabled"
+
+// errorCodeSessionLimitReached rejects a create while MAX_SESSIONS sessions
+// are live.
+const errorCodeSessionLimitReached = "session_limit_reached"
+
+// errorCodeCallSessionLimitReached rejects a create while
+// MAX_SESSIONS_PER_CALL sessions serve the call_id.
+const errorCodeCallSessionLimitReached = "call_session_limit_reached"
+
+// errorCodeHairpinDest rejects an rtpengine_dest on a local address and a
+// media port of this process, which would loop.
+const errorCodeHairpinDest = "hairpin_dest"
+
+// errorCodePortUnavailable rejects a create whose pinned port is outside the
+// pool or already taken.
+const errorCodePortUnavailable = "port_unavailable"
+
 func newCreateSessionResponse(publicIP, internalIP string, created *session.Session) createSessionResponse {
 	mediaAudio := created.AudioState()
 	mediaVideo := created.VideoState()
 	return createSessionResponse{
 		ID:         created.ID,
-		PublicIP:   publicIP,
+		PublicIP:   advertisedIP(publicIP, created),
 		InternalIP: internalIP,
-		Audio:      portResponse{APort: mediaAudio.APort, BPort: mediaAudio.BPort},
-		Video:      portResponse{APort: mediaVideo.APort, BPort: mediaVideo.BPort},
+		Audio:      newMediaStateResponse(mediaAudio),
+		Video:      newMediaStateResponse(mediaVideo),
 	}
 }
 
+// advertisedIP returns the public IP reported for a session.
+func advertisedIP(publicIP string, found *session.Session) string {
+	if found.AdvertiseIP != "" {
+		return found.AdvertiseIP
+	}
+	return publicIP
+}
+
 func newMediaStateResponse(media session.Media) mediaStateResponse {
 	return mediaStateResponse{
-		APort:          media.APort,
-		BPort:          media.BPort,
-		RTPEngineDest:  formatDest(media.RTPEngineDest),
-		Enabled:        media.Enabled,
-		DisabledReason: media.DisabledReason,
+		APort:                   media.APort,
+		BPort:                   media.BPort,
+		RTPEngineDest:           formatDest(media.RTPEngineDest),
+		RTPEngineDestHost:       media.RTPEngineDestHost,
+		Enabled:                 media.Enabled,
+		DisabledReason:          media.DisabledReason,
+		PeerLearningWindowSec:   int(media.PeerLearningWindow / time.Second),
+		PeerLearningRemainingMS: media.PeerLearningRemaining.Milliseconds(),
+		DoorphonePeer:           formatDest(media.DoorphonePeer),
+		DoorphonePeerLearnedAt:  formatTime(media.DoorphonePeerLearnedAt),
+		DoorphonePeerRelearned:  media.DoorphonePeerRelearned,
+		LastActivity:            formatTime(media.LastActivity),
+		LockedSSRC:              lockedSSRC(media),
+		OutputSSRC:              outputSSRC(media),
+		Source:                  session.FormatSource(media.Source),
+		SourceMatches:           sourceMatches(media),
 	}
 }
 
+func sourceMatches(media session.Media) *bool {
+	if media.Source == nil {
+		return nil
+	}
+	return &media.SourceMatches
+}
+
+func lockedSSRC(media session.Media) *uint32 {
+	if !media.SSRCLocked {
+		return nil
+	}
+	return &media.LockedSSRC
+}
+
+func outputSSRC(media session.Media) *uint32 {
+	if !media.RewriteSSRC {
+		return nil
+	}
+	return &media.OutputSSRC
+}
+
+type dtmfDigitResponse struct {
+	Digit      string `json:"digit"`
+	Time       string `json:"time"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+func newDTMFResponse(digits []session.DTMFDigit) []dtmfDigitResponse {
+	resp := make([]dtmfDigitResponse, 0, len(digits))
+	for _, digit := range digits {
+		resp = append(resp, dtmfDigitResponse{
+			Digit:      digit.Digit,
+			Time:       formatTime(digit.Time),
+			DurationMS: digit.Duration.Milliseconds(),
+		})
+	}
+	return resp
+}
+
 func newGetSessionResponse(publicIP, internalIP string, found *session.Session) getSessionResponse {
 	audioCounters := found.AudioCountersSnapshot()
 	videoCounters := found.VideoCountersSnapshot()
 	audioMedia := found.AudioState()
 	videoMedia := found.VideoState()
+	fixBypassed, fixBypassReason := found.VideoFixBypass()
+	videoBuffer := found.VideoBufferState()
+	var videoProfile, videoLevel string
+	sps, hasSPS := found.VideoSPS()
+	if hasSPS {
+		videoProfile, videoLevel = sps.Profile(), sps.Level()
+	}
 	return getSessionResponse{
-		ID:                 found.ID,
-		CallID:             found.CallID,
-		FromTag:            found.FromTag,
-		ToTag:              found.ToTag,
-		PublicIP:           publicIP,
-		InternalIP:         internalIP,
-		AudioAInPkts:       audioCounters.AInPkts,
-		AudioAInBytes:      audioCounters.AInBytes,
-		AudioBOutPkts:      audioCounters.BOutPkts,
-		AudioBOutBytes:     audioCounters.BOutBytes,
-		AudioBInPkts:       audioCounters.BInPkts,
-		AudioBInBytes:      audioCounters.BInBytes,
-		AudioAOutPkts:      audioCounters.AOutPkts,
-		AudioAOutBytes:     audioCounters.AOutBytes,
-		VideoAInPkts:       videoCounters.AInPkts,
-		VideoAInBytes:      videoCounters.AInBytes,
-		VideoBOutPkts:      videoCounters.BOutPkts,
-		VideoBOutBytes:     videoCounters.BOutBytes,
-		VideoBInPkts:       videoCounters.BInPkts,
-		VideoBInBytes:      videoCounters.BInBytes,
-		VideoAOutPkts:      videoCounters.AOutPkts,
-		VideoAOutBytes:     videoCounters.AOutBytes,
-		VideoFramesStarted: videoCounters.VideoFramesStarted,
-		VideoFramesEnded:   videoCounters.VideoFramesEnded,
-		VideoFramesFlushed: videoCounters.VideoFramesFlushed,
-		VideoForcedFlushes: videoCounters.VideoForcedFlushes,
-		VideoInjectedSPS:   videoCounters.VideoInjectedSPS,
-		VideoInjectedPPS:   videoCounters.VideoInjectedPPS,
-		VideoSeqDelta:      videoCounters.VideoSeqDelta,
-		LastActivity:       formatTime(found.LastActivityTime()),
-		State:              found.StateString(),
-		Audio:              newMediaStateResponse(audioMedia),
-		Video:              newMediaStateResponse(videoMedia),
-	}
-}
-
-func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ok"))
+		ID:                        found.ID,
+		CallID:                    found.CallID,
+		FromTag:                   found.FromTag,
+		ToTag:                     found.ToTag,
+		PublicIP:                  advertisedIP(publicIP, found),
+		AdvertiseIP:               found.AdvertiseIP,
+		InternalIP:                internalIP,
+		CreatedAt:                 formatTime(found.CreatedAt),
+		DurationSec:               sessionDurationSec(found.CreatedAt),
+		ExpiresAt:                 formatTime(found.ExpiresAt),
+		Restored:                  found.Restored,
+		VideoFixEnabled:           found.Settings.VideoFix,
+		VideoInjectSPSPPS:         found.Settings.VideoInjectSPSPPS,
+		PeerLearningWindowSec:     int(found.Settings.PeerLearningWindow / time.Second),
+		MaxFrameWaitMS:            found.Settings.MaxFrameWait.Milliseconds(),
+		IncompleteFrames:          formatIncompleteFrames(found.Settings.DropIncompleteFrames),
+		VideoCodec:                found.Settings.VideoCodec,
+		VideoDropSEI:              found.Settings.DropSEI,
+		VideoStripAnnexB:          found.Settings.StripAnnexB,
+		VideoTimestampMode:        found.Settings.VideoTimestampMode,
+		VideoMaxKbps:              found.Settings.VideoMaxKbps,
+		DSCP:                      found.Settings.Marking.DSCP,
+		TTL:                       found.Settings.Marking.TTL,
+		LockSSRC:                  found.Settings.LockSSRC,
+		AudioAInPkts:              audioCounters.AInPkts,
+		AudioAInBytes:             audioCounters.AInBytes,
+		AudioBOutPkts:             audioCounters.BOutPkts,
+		AudioBOutBytes:            audioCounters.BOutBytes,
+		AudioBInPkts:              audioCounters.BInPkts,
+		AudioBInBytes:             audioCounters.BInBytes,
+		AudioAOutPkts:             audioCounters.AOutPkts,
+		AudioAOutBytes:            audioCounters.AOutBytes,
+		AudioDrops:                audioCounters.Drops,
+		AudioDropsNoDest:          audioCounters.DropsNoDest,
+		AudioDropsNoPeer:          audioCounters.DropsNoPeer,
+		AudioDropsWriteError:      audioCounters.DropsWriteError,
+		AudioDropsPeerRejected:    audioCounters.DropsPeerRejected,
+		AudioForeignSSRCDrops:     audioCounters.DropsForeignSSRC,
+		AudioANonRTPDrops:         audioCounters.DropsNonRTPA,
+		AudioBNonRTPDrops:         audioCounters.DropsNonRTPB,
+		AudioTruncatedDrops:       audioCounters.DropsTruncated,
+		AudioBSourceMismatch:      audioCounters.DropsBSource,
+		AudioSSRCChanges:          audioCounters.SSRCChanges,
+		AudioPeerRelearns:         audioCounters.PeerRelearns,
+		AudioPeerSwitchesRejected: audioCounters.PeerSwitchesRejected,
+		AudioSourceRejected:       audioCounters.SourceRejected,
+		AudioASeqGaps:             audioCounters.ASeq.Gaps,
+		AudioAReordered:           audioCounters.ASeq.Reordered,
+		AudioADuplicates:          audioCounters.ASeq.Duplicates,
+		AudioAJitterMS:            audioCounters.AJitter.CurrentMS,
+		AudioAJitterMaxMS:         audioCounters.AJitter.MaxMS,
+		AudioDTMFEvents:           audioCounters.DTMF.Events,
+		AudioLastDTMF:             newDTMFResponse(audioCounters.DTMF.Last),
+		AudioTSDiscontinuities:    audioCounters.TSDiscontinuities,
+		AudioIgnoredDisabled:      audioCounters.IgnoredDisabled,
+		AudioActivitySuppressed:   audioCounters.ActivitySuppressed,
+		AudioKeepalivesSent:       audioCounters.KeepalivesSent,
+		AudioRTCPAInPkts:          audioCounters.RTCP.AInPkts,
+		AudioRTCPAInBytes:         audioCounters.RTCP.AInBytes,
+		AudioRTCPBInPkts:          audioCounters.RTCP.BInPkts,
+		AudioRTCPBInBytes:         audioCounters.RTCP.BInBytes,
+		AudioRTCPDrops:            audioCounters.RTCP.Drops,
+		AudioMuxRTCPPkts:          audioCounters.RTCP.MuxPkts,
+		VideoAInPkts:              videoCounters.AInPkts,
+		VideoAInBytes:             videoCounters.AInBytes,
+		VideoBOutPkts:             videoCounters.BOutPkts,
+		VideoBOutBytes:            videoCounters.BOutBytes,
+		VideoBInPkts:              videoCounters.BInPkts,
+		VideoBInBytes:             videoCounters.BInBytes,
+		VideoAOutPkts:             videoCounters.AOutPkts,
+		VideoAOutBytes:            videoCounters.AOutBytes,
+		VideoDrops:                videoCounters.Drops,
+		VideoDropsNoDest:          videoCounters.DropsNoDest,
+		VideoDropsNoPeer:          videoCounters.DropsNoPeer,
+		VideoDropsWriteError:      videoCounters.DropsWriteError,
+		VideoDropsPeerRejected:    videoCounters.DropsPeerRejected,
+		VideoForeignSSRCDrops:     videoCounters.DropsForeignSSRC,
+		VideoRateLimitedDrops:     videoCounters.DropsRateLimited,
+		VideoANonRTPDrops:         videoCounters.DropsNonRTPA,
+		VideoBNonRTPDrops:         videoCounters.DropsNonRTPB,
+		VideoTruncatedDrops:       videoCounters.DropsTruncated,
+		VideoBSourceMismatch:      videoCounters.DropsBSource,
+		VideoSSRCChanges:          videoCounters.SSRCChanges,
+		VideoPeerRelearns:         videoCounters.PeerRelearns,
+		VideoPeerSwitchesRejected: videoCounters.PeerSwitchesRejected,
+		VideoSourceRejected:       videoCounters.SourceRejected,
+		VideoASeqGaps:             videoCounters.ASeq.Gaps,
+		VideoAReordered:           videoCounters.ASeq.Reordered,
+		VideoADuplicates:          videoCounters.ASeq.Duplicates,
+		VideoAJitterMS:            videoCounters.AJitter.CurrentMS,
+		VideoAJitterMaxMS:         videoCounters.AJitter.MaxMS,
+		VideoBitrateBps:           videoCounters.Rate.BitrateBps,
+		VideoFPS:                  videoCounters.Rate.FPS,
+		VideoLastIDRAgeSec:        lastIDRAge(videoCounters.Rate.LastIDR),
+		VideoIgnoredDisabled:      videoCounters.IgnoredDisabled,
+		VideoActivitySuppressed:   videoCounters.ActivitySuppressed,
+		VideoKeepalivesSent:       videoCounters.KeepalivesSent,
+		VideoFramesStarted:        videoCounters.VideoFramesStarted,
+		VideoFramesEnded:          videoCounters.VideoFramesEnded,
+		VideoFramesFlushed:        videoCounters.VideoFramesFlushed,
+		VideoFramesReordered:      videoCounters.VideoFramesReordered,
+		VideoFramesDropped:        videoCounters.VideoFramesDropped,
+		VideoPktsDropped:          videoCounters.VideoPktsDropped,
+		VideoFramesWithLoss:       videoCounters.VideoFramesWithLoss,
+		VideoSEIDropped:           videoCounters.VideoSEIDropped,
+		VideoForcedFlushes:        videoCounters.VideoForcedFlushes,
+		VideoInjectedVPS:          videoCounters.VideoInjectedVPS,
+		VideoInjectedSPS:          videoCounters.VideoInjectedSPS,
+		VideoInjectedPPS:          videoCounters.VideoInjectedPPS,
+		VideoSPSChanges:           videoCounters.VideoSPSChanges,
+		VideoPPSChanges:           videoCounters.VideoPPSChanges,
+		VideoSeqDelta:             videoCounters.VideoSeqDelta,
+		VideoInjectionRetries:     videoCounters.VideoInjectionRetries,
+		VideoInjectionFailures:    videoCounters.VideoInjectionFailures,
+		VideoKeyframeRequests:     videoCounters.VideoKeyframeRequests,
+		VideoPLISent:              videoCounters.VideoPLISent,
+		VideoFIRSent:              videoCounters.VideoFIRSent,
+		VideoBufferOverflows:      videoCounters.VideoBufferOverflows,
+		VideoRTCPAInPkts:          videoCounters.RTCP.AInPkts,
+		VideoRTCPAInBytes:         videoCounters.RTCP.AInBytes,
+		VideoRTCPBInPkts:          videoCounters.RTCP.BInPkts,
+		VideoRTCPBInBytes:         videoCounters.RTCP.BInBytes,
+		VideoRTCPDrops:            videoCounters.RTCP.Drops,
+		VideoMuxRTCPPkts:          videoCounters.RTCP.MuxPkts,
+		KernelRcvDrops:            found.KernelRcvDrops(),
+		VideoFixBypassed:          fixBypassed,
+		VideoFixBypassReason:      fixBypassReason,
+		VideoBufferPackets:        videoBuffer.FramePackets,
+		VideoBufferAgeMS:          videoBuffer.FrameAge.Milliseconds(),
+		VideoCachedSPS:            videoBuffer.HasCachedSPS,
+		VideoCachedPPS:            videoBuffer.HasCachedPPS,
+		VideoPendingSPS:           videoBuffer.HasPendingSPS,
+		VideoPendingPPS:           videoBuffer.HasPendingPPS,
+		VideoWidth:                sps.Width,
+		VideoHeight:               sps.Height,
+		VideoProfile:              videoProfile,
+		VideoLevel:                videoLevel,
+		Metadata:                  found.Metadata(),
+		LogLevel:                  found.LogLevel(),
+		Capture:                   newCaptureResponse(found),
+		LastActivity:              formatTime(found.LastActivityTime()),
+		State:                     found.StateString(),
+		Audio:                     newMediaStateResponse(audioMedia),
+		Video:                     newMediaStateResponse(videoMedia),
+	}
 }
 
 func (h *Handler) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
@@ -223,49 +724,217 @@ func (h *Handler) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req createSessionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := h.decodeJSONBody(w, r, &req); err != nil {
 		logging.L().Warn("session.create failed", "error", err)
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid json body"})
+		writeJSON(w, err.status, errorResponse{Error: err.message})
 		return
 	}
-	if req.CallID == "" || req.FromTag == "" || req.ToTag == "" {
-		logging.L().Warn("session.create failed", "error", "call_id, from_tag, and to_tag are required")
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "call_id, from_tag, and to_tag are required"})
-		return
+	var problems fieldErrors
+	if req.CallID == "" {
+		problems.add("call_id", "is required")
+	}
+	if req.FromTag == "" {
+		problems.add("from_tag", "is required")
+	}
+	if req.ToTag == "" {
+		problems.add("to_tag", "is required")
 	}
 	// Default to true when omitted to preserve legacy behavior (video fix enabled).
 	videoFix := true
 	if req.Video.Fix != nil {
 		videoFix = *req.Video.Fix
 	}
-	var audioDest *net.UDPAddr
+	// Media is enabled when enable is omitted, matching sessions created
+	// before the flag was honored.
+	audioEnabled := req.Audio.Enable == nil || *req.Audio.Enable
+	videoEnabled := req.Video.Enable == nil || *req.Video.Enable
+	if !audioEnabled && !videoEnabled {
+		problems.add("enable", "audio or video must be enabled")
+	}
+	var audioDest destination
 	if req.Audio.RTPEngineDest != nil {
-		parsed, err := parseDest(*req.Audio.RTPEngineDest)
+		parsed, err := h.parseDest(*req.Audio.RTPEngineDest)
 		if err != nil {
-			logging.L().Warn("session.create failed", "error", err, "field", "audio.rtpengine_dest")
-			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("audio rtpengine_dest %s", err)})
-			return
+			problems.addError("audio.rtpengine_dest", err)
 		}
 		audioDest = parsed
 	}
-	var videoDest *net.UDPAddr
+	var videoDest destination
 	if req.Video.RTPEngineDest != nil {
-		parsed, err := parseDest(*req.Video.RTPEngineDest)
+		parsed, err := h.parseDest(*req.Video.RTPEngineDest)
 		if err != nil {
-			logging.L().Warn("session.create failed", "error", err, "field", "video.rtpengine_dest")
-			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("video rtpengine_dest %s", err)})
-			return
+			problems.addError("video.rtpengine_dest", err)
 		}
 		videoDest = parsed
 	}
-	var (
-		created *session.Session
-		err     error
-	)
-	if audioDest != nil || videoDest != nil {
-		created, err = h.manager.CreateWithInitialDest(req.CallID, req.FromTag, req.ToTag, videoFix, audioDest, videoDest)
-	} else {
-		created, err = h.manager.Create(req.CallID, req.FromTag, req.ToTag, videoFix)
+	sessionWindow, err := parseDurationRange(req.PeerLearningWindowSec, time.Second, 0, maxPeerLearningWindowSec)
+	if err != nil {
+		problems.add("peer_learning_window_sec", err.Error())
+	}
+	audioWindow, err := parseDurationRange(req.Audio.PeerLearningWindowSec, time.Second, 0, maxPeerLearningWindowSec)
+	if err != nil {
+		problems.add("audio.peer_learning_window_sec", err.Error())
+	}
+	videoWindow, err := parseDurationRange(req.Video.PeerLearningWindowSec, time.Second, 0, maxPeerLearningWindowSec)
+	if err != nil {
+		problems.add("video.peer_learning_window_sec", err.Error())
+	}
+	maxFrameWait, err := parseDurationRange(req.Video.MaxFrameWaitMS, time.Millisecond, 1, maxFrameWaitMS)
+	if err != nil {
+		problems.add("video.max_frame_wait_ms", err.Error())
+	}
+	dropIncompleteFrames, err := parseIncompleteFrames(req.Video.IncompleteFrames)
+	if err != nil {
+		problems.add("video.incomplete_frames", err.Error())
+	}
+	videoCodec, err := parseVideoCodec(req.Video.Codec)
+	if err != nil {
+		problems.add("video.codec", err.Error())
+	}
+	videoTimestampMode, err := parseVideoTimestampMode(req.Video.TimestampMode)
+	if err != nil {
+		problems.add("video.timestamp_mode", err.Error())
+	}
+	videoMaxKbps, err := parseMaxKbps(req.Video.MaxKbps)
+	if err != nil {
+		problems.add("video.max_kbps", err.Error())
+	}
+	var audioSource, videoSource *net.UDPAddr
+	if req.Audio.Source != nil {
+		if audioSource, err = session.ParseSource(*req.Audio.Source); err != nil {
+			problems.add("audio.source", err.Error())
+		}
+	}
+	if req.Video.Source != nil {
+		if videoSource, err = session.ParseSource(*req.Video.Source); err != nil {
+			problems.add("video.source", err.Error())
+		}
+	}
+	maxLifetime, err := parseDurationSec(req.MaxLifetimeSec)
+	if err != nil {
+		problems.add("max_lifetime_sec", err.Error())
+	}
+	dscp, err := parseIntRange(req.DSCP, 0, session.MaxDSCP)
+	if err != nil {
+		problems.add("dscp", err.Error())
+	}
+	ttl, err := parseIntRange(req.TTL, 0, session.MaxTTL)
+	if err != nil {
+		problems.add("ttl", err.Error())
+	}
+	var pinned session.LegPorts
+	for _, leg := range []struct {
+		field   string
+		port    *int
+		enabled bool
+		target  *int
+	}{
+		{"audio.a_port", req.Audio.APort, audioEnabled, &pinned.AudioA},
+		{"audio.b_port", req.Audio.BPort, audioEnabled, &pinned.AudioB},
+		{"video.a_port", req.Video.APort, videoEnabled, &pinned.VideoA},
+		{"video.b_port", req.Video.BPort, videoEnabled, &pinned.VideoB},
+	} {
+		port, err := parsePinnedPort(leg.port, leg.enabled)
+		if err != nil {
+			problems.add(leg.field, err.Error())
+		}
+		*leg.target = port
+	}
+	audioRewrite, audioSSRC, err := parseSSRCRewrite(req.Audio.RewriteSSRC, req.Audio.OutputSSRC)
+	if err != nil {
+		problems.add("audio.output_ssrc", err.Error())
+	}
+	videoRewrite, videoSSRC, err := parseSSRCRewrite(req.Video.RewriteSSRC, req.Video.OutputSSRC)
+	if err != nil {
+		problems.add("video.output_ssrc", err.Error())
+	}
+	if err := session.ValidateMetadata(req.Metadata); err != nil {
+		problems.add("metadata", err.Error())
+	}
+	var logLevel *slog.Level
+	if req.LogLevel != "" {
+		parsed, err := logging.ParseLevel(req.LogLevel)
+		if err != nil {
+			problems.add("log_level", logLevelHint)
+		}
+		logLevel = &parsed
+	}
+	if req.AdvertiseIP != "" && net.ParseIP(req.AdvertiseIP) == nil {
+		problems.add("advertise_ip", "must be an IP address")
+	}
+	if len(problems) > 0 {
+		logging.L().Warn("session.create failed", "error", problems.Error(), "fields", problems.names())
+		writeJSON(w, http.StatusBadRequest, problems.response())
+		return
+	}
+	lockSSRC := req.LockSSRC != nil && *req.LockSSRC
+	dropSEI := req.Video.DropSEI != nil && *req.Video.DropSEI
+	stripAnnexB := req.Video.StripAnnexB != nil && *req.Video.StripAnnexB
+	rejectDuplicate := h.rejectDuplicateSessions
+	if req.RejectDuplicate != nil {
+		rejectDuplicate = *req.RejectDuplicate
+	}
+	created, err := h.manager.CreateWithOptions(req.CallID, req.FromTag, req.ToTag, videoFix, session.CreateOptions{
+		DisableAudio:            !audioEnabled,
+		DisableVideo:            !videoEnabled,
+		InitialAudioDest:        audioDest.addr,
+		InitialVideoDest:        videoDest.addr,
+		InitialAudioDestHost:    audioDest.host,
+		InitialVideoDestHost:    videoDest.host,
+		AudioPeerLearningWindow: audioWindow,
+		VideoPeerLearningWindow: videoWindow,
+		Metadata:                req.Metadata,
+		LogLevel:                logLevel,
+		RejectDuplicate:         rejectDuplicate,
+		AdvertiseIP:             req.AdvertiseIP,
+		MaxLifetime:             maxLifetime,
+		Ports:                   pinned,
+		PeerLearningWindow:      sessionWindow,
+		MaxFrameWait:            maxFrameWait,
+		DropIncompleteFrames:    dropIncompleteFrames,
+		VideoCodec:              videoCodec,
+		DropSEI:                 dropSEI,
+		StripAnnexB:             stripAnnexB,
+		VideoTimestampMode:      videoTimestampMode,
+		VideoMaxKbps:            videoMaxKbps,
+		LockSSRC:                lockSSRC,
+		RewriteAudioSSRC:        audioRewrite,
+		RewriteVideoSSRC:        videoRewrite,
+		AudioOutputSSRC:         audioSSRC,
+		VideoOutputSSRC:         videoSSRC,
+		AudioSource:             audioSource,
+		VideoSource:             videoSource,
+		DSCP:                    dscp,
+		TTL:                     ttl,
+		AllowHairpin:            req.AllowHairpin,
+	})
+	var duplicate *session.DuplicateSessionError
+	if errors.As(err, &duplicate) {
+		logging.WithSessionID(duplicate.SessionID).Warn("session.create rejected", "error", err, "call_id", req.CallID, "from_tag", req.FromTag, "to_tag", req.ToTag)
+		writeJSON(w, http.StatusConflict, errorResponse{Error: session.ErrDuplicateSession.Error(), SessionID: duplicate.SessionID})
+		return
+	}
+	var callLimit *session.CallSessionLimitError
+	if errors.As(err, &callLimit) {
+		logging.L().Warn("session.create rejected", "error", err, "call_id", req.CallID, "from_tag", req.FromTag, "to_tag", req.ToTag, "sessions", len(callLimit.SessionIDs))
+		writeJSON(w, http.StatusConflict, errorResponse{Error: session.ErrCallSessionLimitReached.Error(), Code: errorCodeCallSessionLimitReached, SessionIDs: callLimit.SessionIDs})
+		return
+	}
+	var unavailable *session.PortUnavailableError
+	if errors.As(err, &unavailable) {
+		logging.L().Warn("session.create rejected", "error", err, "call_id", req.CallID, "from_tag", req.FromTag, "to_tag", req.ToTag, "port", unavailable.Port)
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Code: errorCodePortUnavailable})
+		return
+	}
+	if errors.Is(err, session.ErrHairpinDest) {
+		logging.L().Warn("session.create rejected", "error", err, "call_id", req.CallID, "from_tag", req.FromTag, "to_tag", req.ToTag)
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), Code: errorCodeHairpinDest})
+		return
+	}
+	if errors.Is(err, session.ErrSessionLimitReached) {
+		logging.L().Warn("session.create rejected", "error", err, "call_id", req.CallID, "from_tag", req.FromTag, "to_tag", req.ToTag, "max_sessions", h.manager.MaxSessions())
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error(), Code: errorCodeSessionLimitReached})
+		return
 	}
 	if err != nil {
 		status := http.StatusInternalServerError
@@ -277,7 +946,7 @@ func (h *Handler) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resp := newCreateSessionResponse(h.publicIP, h.internalIP, created)
-	logging.WithSessionID(created.ID).Info(
+	created.Logger().Info(
 		"session.create",
 		"call_id",
 		created.CallID,
@@ -286,9 +955,9 @@ func (h *Handler) handleSessionCreate(w http.ResponseWriter, r *http.Request) {
 		"to_tag",
 		created.ToTag,
 		"audio_enabled",
-		req.Audio.Enable,
+		audioEnabled,
 		"video_enabled",
-		req.Video.Enable,
+		videoEnabled,
 		"video_fix",
 		videoFix,
 		"audio_a_port",
@@ -330,98 +999,372 @@ func (h *Handler) handleSessionDeleteByID(w http.ResponseWriter, r *http.Request
 	h.handleSessionDelete(w, r, id)
 }
 
+func (h *Handler) handleSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	var deleted []*session.Session
+	if callID := r.URL.Query().Get("call_id"); callID != "" {
+		deleted = h.manager.DeleteByCallID(callID)
+	} else {
+		deleted = h.manager.DeleteAll()
+	}
+	writeJSON(w, http.StatusOK, bulkDeleteResponse{Deleted: len(deleted)})
+}
+
 func (h *Handler) handleSessionGet(w http.ResponseWriter, r *http.Request, id string) {
+	include, err := parseInclude(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
 	found, ok := h.manager.Get(id)
 	if !ok {
 		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
 		return
 	}
 	resp := newGetSessionResponse(h.publicIP, h.internalIP, found)
+	if include[includeEvents] {
+		resp.Events = newHistoryResponse(found.History())
+	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
 func (h *Handler) handleSessionUpdate(w http.ResponseWriter, r *http.Request, id string) {
 	var req updateSessionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := h.decodeJSONBody(w, r, &req); err != nil {
 		logging.WithSessionID(id).Warn("session.update failed", "error", err)
-		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid json body"})
+		writeJSON(w, err.status, errorResponse{Error: err.message})
 		return
 	}
-	var audioDest *net.UDPAddr
-	if req.Audio != nil && req.Audio.RTPEngineDest != nil {
-		parsed, err := parseDest(*req.Audio.RTPEngineDest)
+	var update session.DestUpdate
+	if req.Audio != nil && req.Audio.RTPEngineDest.Value != nil {
+		parsed, err := h.parseDest(*req.Audio.RTPEngineDest.Value)
 		if err != nil {
 			logging.WithSessionID(id).Warn("session.update failed", "error", err, "field", "audio.rtpengine_dest")
-			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("audio rtpengine_dest %s", err)})
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("audio rtpengine_dest %s", err), Code: destErrorCode(err)})
 			return
 		}
-		audioDest = parsed
+		update.Audio, update.AudioHost = parsed.addr, parsed.host
 	}
-	var videoDest *net.UDPAddr
-	if req.Video != nil && req.Video.RTPEngineDest != nil {
-		parsed, err := parseDest(*req.Video.RTPEngineDest)
+	if req.Video != nil && req.Video.RTPEngineDest.Value != nil {
+		parsed, err := h.parseDest(*req.Video.RTPEngineDest.Value)
 		if err != nil {
 			logging.WithSessionID(id).Warn("session.update failed", "error", err, "field", "video.rtpengine_dest")
-			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("video rtpengine_dest %s", err)})
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("video rtpengine_dest %s", err), Code: destErrorCode(err)})
 			return
 		}
-		videoDest = parsed
+		update.Video, update.VideoHost = parsed.addr, parsed.host
 	}
-	updated, ok := h.manager.UpdateRTPDest(id, audioDest, videoDest)
+	update.AllowHairpin = req.AllowHairpin
+	update.ClearAudio = req.Audio != nil && req.Audio.RTPEngineDest.cleared()
+	update.ClearVideo = req.Video != nil && req.Video.RTPEngineDest.cleared()
+	var sources session.SourceUpdate
+	if req.Audio != nil && req.Audio.Source.Value != nil {
+		parsed, err := session.ParseSource(*req.Audio.Source.Value)
+		if err != nil {
+			logging.WithSessionID(id).Warn("session.update failed", "error", err, "field", "audio.source")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("audio source %s", err)})
+			return
+		}
+		sources.Audio = parsed
+	}
+	if req.Video != nil && req.Video.Source.Value != nil {
+		parsed, err := session.ParseSource(*req.Video.Source.Value)
+		if err != nil {
+			logging.WithSessionID(id).Warn("session.update failed", "error", err, "field", "video.source")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("video source %s", err)})
+			return
+		}
+		sources.Video = parsed
+	}
+	sources.ClearAudio = req.Audio != nil && req.Audio.Source.cleared()
+	sources.ClearVideo = req.Video != nil && req.Video.Source.cleared()
+	audioSourceSet := sources.Audio != nil || sources.ClearAudio
+	videoSourceSet := sources.Video != nil || sources.ClearVideo
+	// AudioState and VideoState read the reason from the atomics, so this
+	// check does not race with a concurrent update of the same session.
+	if current, ok := h.manager.Get(id); ok {
+		if (update.Audio != nil || update.ClearAudio || audioSourceSet) && current.AudioState().DisabledReason == session.DisabledReasonNotRequested {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "audio not enabled", "field", "audio.rtpengine_dest")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "audio was not enabled at session creation", Code: errorCodeMediaNotEnabled})
+			return
+		}
+		if (update.Video != nil || update.ClearVideo || videoSourceSet) && current.VideoState().DisabledReason == session.DisabledReasonNotRequested {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "video not enabled", "field", "video.rtpengine_dest")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "video was not enabled at session creation", Code: errorCodeMediaNotEnabled})
+			return
+		}
+	}
+	if req.Metadata != nil || req.ReplaceMetadata {
+		_, found, err := h.manager.UpdateMetadata(id, req.Metadata, req.ReplaceMetadata)
+		if !found {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "session not found")
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+			return
+		}
+		if err != nil {
+			logging.WithSessionID(id).Warn("session.update failed", "error", err, "field", "metadata")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+	}
+	updated, ok, err := h.manager.UpdateDest(id, update)
 	if !ok {
 		logging.WithSessionID(id).Warn("session.update failed", "error", "session not found")
 		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
 		return
 	}
+	if err != nil {
+		logging.WithSessionID(id).Warn("session.update failed", "error", err)
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), Code: errorCodeHairpinDest})
+		return
+	}
+	if audioSourceSet || videoSourceSet {
+		if updated, ok = h.manager.UpdateSource(id, sources); !ok {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "session not found")
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+			return
+		}
+	}
+	rearmFix := req.Video != nil && req.Video.RearmFix
+	if rearmFix {
+		if updated, ok = h.manager.RearmVideoFix(id); !ok {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "session not found")
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+			return
+		}
+	}
+	// The latch is cleared after the destination update so that a stream
+	// switch announced together with a new destination starts clean.
+	resetAudioLock := req.Audio != nil && req.Audio.ResetSSRCLock
+	resetVideoLock := req.Video != nil && req.Video.ResetSSRCLock
+	if resetAudioLock || resetVideoLock {
+		if updated, ok = h.manager.ResetSSRCLock(id, resetAudioLock, resetVideoLock); !ok {
+			logging.WithSessionID(id).Warn("session.update failed", "error", "session not found")
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+			return
+		}
+	}
 	resp := newGetSessionResponse(h.publicIP, h.internalIP, updated)
 	logAttrs := []any{}
-	if audioDest != nil {
-		logAttrs = append(logAttrs, "audio_dest", audioDest.String())
+	if update.Audio != nil {
+		logAttrs = append(logAttrs, "audio_dest", update.Audio.String())
+	} else if update.ClearAudio {
+		logAttrs = append(logAttrs, "audio_dest", "cleared")
 	}
-	if videoDest != nil {
-		logAttrs = append(logAttrs, "video_dest", videoDest.String())
+	if update.Video != nil {
+		logAttrs = append(logAttrs, "video_dest", update.Video.String())
+	} else if update.ClearVideo {
+		logAttrs = append(logAttrs, "video_dest", "cleared")
 	}
-	logging.WithSessionID(id).Info("session.update", logAttrs...)
+	if sources.Audio != nil {
+		logAttrs = append(logAttrs, "audio_source", session.FormatSource(sources.Audio))
+	} else if sources.ClearAudio {
+		logAttrs = append(logAttrs, "audio_source", "cleared")
+	}
+	if sources.Video != nil {
+		logAttrs = append(logAttrs, "video_source", session.FormatSource(sources.Video))
+	} else if sources.ClearVideo {
+		logAttrs = append(logAttrs, "video_source", "cleared")
+	}
+	if rearmFix {
+		logAttrs = append(logAttrs, "video_rearm_fix", true)
+	}
+	if resetAudioLock {
+		logAttrs = append(logAttrs, "audio_reset_ssrc_lock", true)
+	}
+	if resetVideoLock {
+		logAttrs = append(logAttrs, "video_reset_ssrc_lock", true)
+	}
+	if req.Metadata != nil || req.ReplaceMetadata {
+		logAttrs = append(logAttrs, "metadata_keys", len(updated.Metadata()))
+	}
+	updated.Logger().Info("session.update", logAttrs...)
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// handleSessionDelete returns the final session state, in the GET shape, so
+// that CDR collection does not have to race a GET before deleting. ?quiet=1
+// keeps the legacy empty body.
 func (h *Handler) handleSessionDelete(w http.ResponseWriter, r *http.Request, id string) {
-	var duration time.Duration
-	if found, ok := h.manager.Get(id); ok && !found.CreatedAt.IsZero() {
-		duration = time.Since(found.CreatedAt)
-	}
-	if deleted := h.manager.Delete(id); !deleted {
+	deleted, ok := h.manager.DeleteWithReason(id, "api")
+	if !ok {
 		logging.WithSessionID(id).Warn("session.delete failed", "error", "session not found")
 		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
 		return
 	}
 	logAttrs := []any{"reason", "api"}
-	if duration > 0 {
-		logAttrs = append(logAttrs, "duration", duration)
+	if !deleted.CreatedAt.IsZero() {
+		logAttrs = append(logAttrs, "duration", time.Since(deleted.CreatedAt))
 	}
 	logging.WithSessionID(id).Info("session.delete", logAttrs...)
-	w.WriteHeader(http.StatusOK)
+	if quiet := r.URL.Query().Get("quiet"); quiet == "1" || quiet == "true" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, http.StatusOK, newGetSessionResponse(h.publicIP, h.internalIP, deleted))
 }
 
+// writeJSON writes value with an exact Content-Length. Responses describe live
+// session state, so intermediaries must not cache them.
 func writeJSON(w http.ResponseWriter, status int, value any) {
+	body, _ := json.Marshal(value)
+	body = append(body, '\n')
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(value)
+	_, _ = w.Write(body)
 }
 
-func parseDest(raw string) (*net.UDPAddr, error) {
-	host, port, err := net.SplitHostPort(raw)
-	if err != nil {
-		return nil, fmt.Errorf("must be in ip:port format with port 0..65535 (0 disables media)")
+// Upper bounds of the per-session overrides; larger values are typos
+// rather than doorphone quirks.
+const (
+	maxPeerLearningWindowSec = 300
+	maxFrameWaitMS           = 5000
+	maxVideoKbps             = 1000000
+)
+
+// parseDurationRange converts an optional count of unit that must lie within
+// [min, max].
+func parseDurationRange(value *int, unit time.Duration, min, max int) (*time.Duration, error) {
+	if value == nil {
+		return nil, nil
 	}
-	if net.ParseIP(host) == nil {
-		return nil, fmt.Errorf("must be in ip:port format with port 0..65535 (0 disables media)")
+	if *value < min || *value > max {
+		return nil, fmt.Errorf("must be between %d and %d", min, max)
 	}
-	portValue, err := strconv.Atoi(port)
-	if err != nil || portValue < 0 || portValue > 65535 {
-		return nil, fmt.Errorf("must be in ip:port format with port 0..65535 (0 disables media)")
+	duration := time.Duration(*value) * unit
+	return &duration, nil
+}
+
+// parseIntRange validates an optional value that must lie within [min, max].
+func parseIntRange(value *int, min, max int) (*int, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if *value < min || *value > max {
+		return nil, fmt.Errorf("must be between %d and %d", min, max)
+	}
+	return value, nil
+}
+
+// parseMaxKbps validates an optional bandwidth limit; 0 or omitted means
+// unlimited.
+func parseMaxKbps(kbps *int) (int, error) {
+	if kbps == nil {
+		return 0, nil
+	}
+	if *kbps < 0 || *kbps > maxVideoKbps {
+		return 0, fmt.Errorf("must be between 0 and %d", maxVideoKbps)
+	}
+	return *kbps, nil
+}
+
+// parseDurationSec converts an optional non-negative number of seconds.
+func parseDurationSec(sec *int) (*time.Duration, error) {
+	if sec == nil {
+		return nil, nil
+	}
+	if *sec < 0 {
+		return nil, fmt.Errorf("must be >= 0")
+	}
+	window := time.Duration(*sec) * time.Second
+	return &window, nil
+}
+
+// parsePinnedPort converts an optional pinned RTP port; 0 means allocate. The
+// port must be even since the odd port after it is taken for RTCP.
+func parsePinnedPort(port *int, enabled bool) (int, error) {
+	switch {
+	case port == nil:
+		return 0, nil
+	case !enabled:
+		return 0, fmt.Errorf("requires the media to be enabled")
+	case *port <= 0 || *port >= 65535 || *port%2 != 0:
+		return 0, fmt.Errorf("must be an even port between 2 and 65534")
 	}
-	return &net.UDPAddr{IP: net.ParseIP(host), Port: portValue}, nil
+	return *port, nil
+}
+
+// parseSSRCRewrite reads the SSRC rewrite of a media. output_ssrc is only
+// accepted together with rewrite_ssrc; without it the SSRC is picked at
+// random.
+func parseSSRCRewrite(rewrite *bool, ssrc *int64) (bool, *uint32, error) {
+	enabled := rewrite != nil && *rewrite
+	switch {
+	case ssrc == nil:
+		return enabled, nil, nil
+	case !enabled:
+		return false, nil, fmt.Errorf("requires rewrite_ssrc")
+	case *ssrc < 0 || *ssrc > math.MaxUint32:
+		return false, nil, fmt.Errorf("must be between 0 and %d", uint32(math.MaxUint32))
+	}
+	value := uint32(*ssrc)
+	return true, &value, nil
+}
+
+// Values of video.incomplete_frames.
+const (
+	incompleteFramesFlush = "flush"
+	incompleteFramesDrop  = "drop"
+)
+
+// parseIncompleteFrames reads video.incomplete_frames: "flush", the default,
+// or "drop".
+func parseIncompleteFrames(value *string) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+	switch *value {
+	case incompleteFramesFlush:
+		return false, nil
+	case incompleteFramesDrop:
+		return true, nil
+	}
+	return false, fmt.Errorf("must be %s or %s", incompleteFramesFlush, incompleteFramesDrop)
+}
+
+// parseVideoCodec reads video.codec, "h264" or "h265". An absent codec
+// returns "", which the session takes for H264.
+func parseVideoCodec(value *string) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	switch *value {
+	case session.VideoCodecH264, session.VideoCodecH265:
+		return *value, nil
+	}
+	return "", fmt.Errorf("must be %s or %s", session.VideoCodecH264, session.VideoCodecH265)
+}
+
+// parseVideoTimestampMode reads video.timestamp_mode, "rewrite" or
+// "passthrough". An absent mode returns "", which the session takes for
+// rewrite.
+func parseVideoTimestampMode(value *string) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	switch *value {
+	case session.VideoTimestampRewrite, session.VideoTimestampPassthrough:
+		return *value, nil
+	}
+	return "", fmt.Errorf("must be %s or %s", session.VideoTimestampRewrite, session.VideoTimestampPassthrough)
+}
+
+// lastIDRAge returns the seconds since lastIDR, to a tenth, or nil before the
+// first IDR.
+func lastIDRAge(lastIDR time.Time) *float64 {
+	if lastIDR.IsZero() {
+		return nil
+	}
+	age := math.Round(time.Since(lastIDR).Seconds()*10) / 10
+	return &age
+}
+
+func formatIncompleteFrames(drop bool) string {
+	if drop {
+		return incompleteFramesDrop
+	}
+	return incompleteFramesFlush
 }
 
 func formatDest(addr *net.UDPAddr) string {
@@ -431,6 +1374,14 @@ func formatDest(addr *net.UDPAddr) string {
 	return addr.String()
 }
 
+// sessionDurationSec is the whole seconds since createdAt, 0 when unknown.
+func sessionDurationSec(createdAt time.Time) int64 {
+	if createdAt.IsZero() {
+		return 0
+	}
+	return int64(time.Since(createdAt) / time.Second)
+}
+
 func formatTime(value time.Time) string {
 	if value.IsZero() {
 		return ""
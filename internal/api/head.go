@@ -0,0 +1,19 @@
+package api
+
+import "net/http"
+
+// headOnly serves HEAD with the GET handler while discarding the body. The
+// handlers set Content-Length, so it still reports the size of the GET body.
+func headOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(bodylessWriter{w}, r)
+	})
+}
+
+type bodylessWriter struct {
+	http.ResponseWriter
+}
+
+func (w bodylessWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_HeadHealthAndSession verifies HEAD on /v1/health and
+// /v1/session/{id}. This matters because load balancers probe with HEAD and
+// mark the backend down on anything but 200. Inputs: HEAD and GET of health,
+// HEAD of a known and an unknown session. The expected output is 200 with an
+// empty body and the Content-Length of the matching GET, and 404 for the
+// unknown session.
+func TestAPI_HeadHealthAndSession(t *testing.T) {
+	handler := newTestHandler(&mockManager{getResult: &session.Session{ID: "sess-head"}})
+
+	get := performRequest(handler, http.MethodGet, "/v1/health", nil)
+	head := performRequest(handler, http.MethodHead, "/v1/health", nil)
+	if head.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Fatalf("expected empty body, got %q", head.Body.String())
+	}
+	if want := strconv.Itoa(get.Body.Len()); head.Header().Get("Content-Length") != want {
+		t.Fatalf("expected Content-Length %s, got %q", want, head.Header().Get("Content-Length"))
+	}
+
+	head = performRequest(handler, http.MethodHead, "/v1/session/sess-head", nil)
+	if head.Code != http.StatusOK || head.Body.Len() != 0 {
+		t.Fatalf("expected 200 with empty body, got %d %q", head.Code, head.Body.String())
+	}
+	head = performRequest(handler, http.MethodHead, "/v1/session/unknown", nil)
+	if head.Code != http.StatusNotFound || head.Body.Len() != 0 {
+		t.Fatalf("expected 404 with empty body, got %d %q", head.Code, head.Body.String())
+	}
+}
+
+// TestAPI_JSONResponsesAreNotCached verifies that JSON responses, including
+// errors, carry Cache-Control: no-store and an exact Content-Length, and that
+// the plain-text health body does too. Inputs: a session GET, an unauthorized
+// request and a text/plain health probe. The expected output is no-store on
+// all three and a Content-Length equal to the body size.
+func TestAPI_JSONResponsesAreNotCached(t *testing.T) {
+	handler := newTestHandler(&mockManager{getResult: &session.Session{ID: "sess-cache"}})
+	mux := http.NewServeMux()
+	handler.Register(mux)
+
+	plain := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+	plain.Header.Set("Authorization", "Bearer test-password")
+	plain.Header.Set("Accept", "text/plain")
+	plainRecorder := httptest.NewRecorder()
+	mux.ServeHTTP(plainRecorder, plain)
+
+	recorders := map[string]*httptest.ResponseRecorder{
+		"session":      performRequest(handler, http.MethodGet, "/v1/session/sess-cache", nil),
+		"unauthorized": performRequestWithToken(handler, http.MethodGet, "/v1/session/sess-cache", "wrong", nil),
+		"plain health": plainRecorder,
+	}
+	for name, recorder := range recorders {
+		if got := recorder.Header().Get("Cache-Control"); got != "no-store" {
+			t.Fatalf("%s: expected Cache-Control no-store, got %q", name, got)
+		}
+		if want := strconv.Itoa(recorder.Body.Len()); recorder.Header().Get("Content-Length") != want {
+			t.Fatalf("%s: expected Content-Length %s, got %q", name, want, recorder.Header().Get("Content-Length"))
+		}
+	}
+	if plainRecorder.Body.String() != "ok" {
+		t.Fatalf("expected plain ok body, got %q", plainRecorder.Body.String())
+	}
+}
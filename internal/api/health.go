@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"rtp-stream-cleaner/internal/buildinfo"
+	"rtp-stream-cleaner/internal/session"
+)
+
+// readyMinFreePorts is the number of ports one audio+video session allocates:
+// an RTP/RTCP pair for each of its four legs.
+const readyMinFreePorts = 8
+
+type readyResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	StartedAt string `json:"started_at"`
+}
+
+type healthResponse struct {
+	Status             string                   `json:"status"`
+	UptimeSec          int64                    `json:"uptime_sec"`
+	ActiveSessions     int                      `json:"active_sessions"`
+	MaxSessions        int                      `json:"max_sessions"`
+	PortPool           portPoolResponse         `json:"port_pool"`
+	PublicIPConfigured bool                     `json:"public_ip_configured"`
+	Sessions           []sessionSummaryResponse `json:"sessions,omitempty"`
+}
+
+type portPoolResponse struct {
+	Size           int    `json:"size"`
+	Free           int    `json:"free"`
+	InUse          int    `json:"in_use"`
+	Quarantined    int    `json:"quarantined"`
+	CoolingDown    int    `json:"cooling_down"`
+	BindConflicts  uint64 `json:"bind_conflicts"`
+	ExhaustedTotal uint64 `json:"exhausted_total"`
+}
+
+func newPortPoolResponse(pool session.PoolStats) portPoolResponse {
+	return portPoolResponse{
+		Size:           pool.Size,
+		Free:           pool.Free,
+		InUse:          pool.InUse,
+		Quarantined:    pool.Quarantined,
+		CoolingDown:    pool.CoolingDown,
+		BindConflicts:  pool.BindConflicts,
+		ExhaustedTotal: pool.Exhausted,
+	}
+}
+
+type sessionSummaryResponse struct {
+	ID           string `json:"id"`
+	CallID       string `json:"call_id"`
+	State        string `json:"state"`
+	AudioEnabled bool   `json:"audio_enabled"`
+	VideoEnabled bool   `json:"video_enabled"`
+	LastActivity string `json:"last_activity"`
+}
+
+// handleHealth reports service diagnostics as JSON. Probes that ask for
+// text/plain keep getting the legacy "ok" body.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		body := []byte("ok")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+		return
+	}
+	sessions := h.manager.List()
+	pool := h.manager.PoolStats()
+	resp := healthResponse{
+		Status:             "ok",
+		UptimeSec:          int64(time.Since(h.startedAt) / time.Second),
+		ActiveSessions:     len(sessions),
+		MaxSessions:        h.manager.MaxSessions(),
+		PortPool:           newPortPoolResponse(pool),
+		PublicIPConfigured: h.publicIP != "",
+	}
+	if verbose := r.URL.Query().Get("verbose"); verbose == "1" || verbose == "true" {
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+		resp.Sessions = make([]sessionSummaryResponse, 0, len(sessions))
+		for _, found := range sessions {
+			resp.Sessions = append(resp.Sessions, sessionSummaryResponse{
+				ID:           found.ID,
+				CallID:       found.CallID,
+				State:        found.StateString(),
+				AudioEnabled: found.AudioState().Enabled,
+				VideoEnabled: found.VideoState().Enabled,
+				LastActivity: formatTime(found.LastActivityTime()),
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleReady reports whether the service can take new sessions. Unlike
+// /v1/health it fails while the manager drains on shutdown.
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	reason := ""
+	switch {
+	case h.manager.ShuttingDown():
+		reason = "shutting_down"
+	case h.publicIP == "":
+		reason = "public_ip_not_configured"
+	case h.manager.PoolStats().Free < readyMinFreePorts:
+		reason = "port_pool_exhausted"
+	case h.sessionLimitReached():
+		reason = "session_limit_reached"
+	}
+	if reason != "" {
+		writeJSON(w, http.StatusServiceUnavailable, readyResponse{Status: "not_ready", Reason: reason})
+		return
+	}
+	writeJSON(w, http.StatusOK, readyResponse{Status: "ready"})
+}
+
+func (h *Handler) sessionLimitReached() bool {
+	limit := h.manager.MaxSessions()
+	return limit > 0 && len(h.manager.List()) >= limit
+}
+
+// handleVersion reports which build is running.
+func (h *Handler) handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildinfo.Get()
+	writeJSON(w, http.StatusOK, versionResponse{
+		Version:   info.Version,
+		Commit:    info.Commit,
+		BuildDate: info.Date,
+		GoVersion: info.GoVersion,
+		StartedAt: formatTime(info.StartedAt),
+	})
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"rtp-stream-cleaner/internal/buildinfo"
+	"rtp-stream-cleaner/internal/config"
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_Health_ReportsDiagnostics verifies that the default health response
+// is JSON with the session count and limit, port pool usage and PUBLIC_IP
+// status. This matters because a plain "ok" hid port pool exhaustion from
+// operators. Inputs: a manager with two sessions, MAX_SESSIONS 50 and a
+// 100-port pool with 8 ports in use, one quarantined and 3 bind conflicts. The
+// expected output is HTTP 200 with those numbers and no per-session list.
+func TestAPI_Health_ReportsDiagnostics(t *testing.T) {
+	manager := &mockManager{
+		listResult:  []*session.Session{{ID: "sess-1"}, {ID: "sess-2"}},
+		poolStats:   session.PoolStats{Size: 100, Free: 91, InUse: 8, Quarantined: 1, BindConflicts: 3},
+		maxSessions: 50,
+	}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/health", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var body healthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if body.Status != "ok" || body.ActiveSessions != 2 || body.MaxSessions != 50 || !body.PublicIPConfigured {
+		t.Fatalf("unexpected health body: %+v", body)
+	}
+	if body.PortPool != (portPoolResponse{Size: 100, Free: 91, InUse: 8, Quarantined: 1, BindConflicts: 3}) {
+		t.Fatalf("unexpected port pool: %+v", body.PortPool)
+	}
+	if body.Sessions != nil {
+		t.Fatalf("expected no session summaries without verbose, got %+v", body.Sessions)
+	}
+}
+
+func TestAPI_Health_VerboseListsSessions(t *testing.T) {
+	manager := &mockManager{listResult: []*session.Session{{ID: "sess-b", CallID: "call-b"}, {ID: "sess-a", CallID: "call-a"}}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/health?verbose=1", nil)
+
+	var body healthResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if len(body.Sessions) != 2 || body.Sessions[0].ID != "sess-a" || body.Sessions[1].CallID != "call-b" {
+		t.Fatalf("unexpected session summaries: %+v", body.Sessions)
+	}
+}
+
+// TestAPI_Health_PlainTextForLegacyProbes verifies that clients asking for
+// text/plain still get the literal "ok" body existing probes match on.
+func TestAPI_Health_PlainTextForLegacyProbes(t *testing.T) {
+	handler := newTestHandler(&mockManager{})
+	mux := http.NewServeMux()
+	handler.Register(mux)
+	req := httptest.NewRequest(http.MethodGet, "/v1/health?access_token=test-password", nil)
+	req.Header.Set("Accept", "text/plain")
+	recorder := httptest.NewRecorder()
+
+	mux.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder.Body.String() != "ok" {
+		t.Fatalf("expected plain ok body, got %q", recorder.Body.String())
+	}
+}
+
+// TestAPI_Ready_Conditions verifies each readiness condition in isolation.
+// This matters because load balancers route new session creates by this probe
+// and must stop as soon as any condition fails. Inputs: a ready baseline, then
+// a draining manager, an exhausted pool, a missing PUBLIC_IP and a reached
+// session limit. The expected
+// output is 200 for the baseline and 503 with the matching reason otherwise.
+func TestAPI_Ready_Conditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		manager    *mockManager
+		publicIP   string
+		wantStatus int
+		wantReason string
+	}{
+		{"ready", &mockManager{poolStats: session.PoolStats{Size: 10, Free: 8}}, "203.0.113.1", http.StatusOK, ""},
+		{"shutting down", &mockManager{poolStats: session.PoolStats{Size: 10, Free: 10}, shuttingDown: true}, "203.0.113.1", http.StatusServiceUnavailable, "shutting_down"},
+		{"pool exhausted", &mockManager{poolStats: session.PoolStats{Size: 10, Free: 7, InUse: 3}}, "203.0.113.1", http.StatusServiceUnavailable, "port_pool_exhausted"},
+		{"no public ip", &mockManager{poolStats: session.PoolStats{Size: 10, Free: 10}}, "", http.StatusServiceUnavailable, "public_ip_not_configured"},
+		{"session limit", &mockManager{poolStats: session.PoolStats{Size: 10, Free: 10}, maxSessions: 1, listResult: []*session.Session{{ID: "sess-1"}}}, "203.0.113.1", http.StatusServiceUnavailable, "session_limit_reached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(config.Config{PublicIP: tt.publicIP, ServicePassword: "test-password"}, tt.manager)
+
+			recorder := performRequest(handler, http.MethodGet, "/v1/ready", nil)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, recorder.Code)
+			}
+			var body readyResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("unexpected decode error: %v", err)
+			}
+			if body.Reason != tt.wantReason {
+				t.Fatalf("expected reason %q, got %q", tt.wantReason, body.Reason)
+			}
+		})
+	}
+}
+
+// TestAPI_Version_ReportsBuild verifies that /v1/version returns the values
+// injected into the buildinfo package, so that field triage can tell which
+// build is running. Inputs: Version and Commit overridden as -ldflags would.
+// The expected output is HTTP 200 with those values, the Go runtime version
+// and a start time, and HTTP 401 with a wrong token.
+func TestAPI_Version_ReportsBuild(t *testing.T) {
+	origVersion, origCommit := buildinfo.Version, buildinfo.Commit
+	buildinfo.Version, buildinfo.Commit = "1.2.3", "abc1234"
+	t.Cleanup(func() { buildinfo.Version, buildinfo.Commit = origVersion, origCommit })
+	handler := newTestHandler(&mockManager{})
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/version", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var body versionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if body.Version != "1.2.3" || body.Commit != "abc1234" {
+		t.Fatalf("unexpected version body: %+v", body)
+	}
+	if body.GoVersion != runtime.Version() || body.BuildDate == "" || body.StartedAt == "" {
+		t.Fatalf("missing runtime fields: %+v", body)
+	}
+
+	recorder = performRequestWithToken(handler, http.MethodGet, "/v1/version", "wrong", nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d with wrong token, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// includeEvents is the GET include value that adds the session history.
+const includeEvents = "events"
+
+type historyEntryResponse struct {
+	Time   string `json:"time"`
+	Type   string `json:"type"`
+	Media  string `json:"media,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func newHistoryResponse(entries []session.HistoryEntry) []historyEntryResponse {
+	resp := make([]historyEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		resp = append(resp, historyEntryResponse{
+			Time:   formatTime(entry.Time),
+			Type:   entry.Type,
+			Media:  entry.Media,
+			Detail: entry.Detail,
+		})
+	}
+	return resp
+}
+
+// parseInclude reads the comma separated include query parameter of GET.
+func parseInclude(r *http.Request) (map[string]bool, error) {
+	include := make(map[string]bool)
+	raw := r.URL.Query().Get("include")
+	if raw == "" {
+		return include, nil
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name != includeEvents {
+			return nil, fmt.Errorf("unknown include %q", name)
+		}
+		include[name] = true
+	}
+	return include, nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_GetSession_IncludeEvents verifies the include parameter of GET:
+// events are only returned when asked for, and an unknown include value is
+// rejected rather than silently ignored. Inputs: GET without include, with
+// include=events and with include=bogus. The expected output is no events
+// key, an events array, and HTTP 400.
+func TestAPI_GetSession_IncludeEvents(t *testing.T) {
+	manager := &mockManager{getResult: &session.Session{ID: "sess-history"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/session/sess-history", nil)
+	var plain map[string]any
+	if err := json.NewDecoder(recorder.Body).Decode(&plain); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if _, ok := plain["events"]; ok {
+		t.Fatalf("expected no events without include, got %v", plain["events"])
+	}
+
+	recorder = performRequest(handler, http.MethodGet, "/v1/session/sess-history?include=events", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	recorder = performRequest(handler, http.MethodGet, "/v1/session/sess-history?include=bogus", nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestNewHistoryResponse verifies the mapping of history entries to the API
+// form. Inputs: one session-wide and one media entry. The expected output is
+// RFC 3339 UTC times and media/detail carried over unchanged.
+func TestNewHistoryResponse(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	resp := newHistoryResponse([]session.HistoryEntry{
+		{Time: at, Type: session.HistoryCreated},
+		{Time: at, Type: session.HistoryPeerLearned, Media: "video", Detail: "192.0.2.1:5000"},
+	})
+	if len(resp) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(resp))
+	}
+	if resp[0].Time != "2024-01-01T11:00:00Z" || resp[0].Type != "created" || resp[0].Media != "" {
+		t.Fatalf("unexpected first entry %+v", resp[0])
+	}
+	if resp[1].Media != "video" || resp[1].Detail != "192.0.2.1:5000" {
+		t.Fatalf("unexpected second entry %+v", resp[1])
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"rtp-stream-cleaner/internal/logging"
+	"rtp-stream-cleaner/internal/session"
+)
+
+const (
+	errorCodeVideoFixDisabled = "video_fix_disabled"
+	errorCodeVideoPeerUnknown = "video_peer_unknown"
+)
+
+type keyframeRequest struct {
+	SendPLI bool `json:"send_pli"`
+}
+
+// handleSessionRequestKeyframe re-arms cached SPS/PPS injection before the
+// next IDR and optionally sends an RTCP PLI to the doorphone. The body is
+// optional.
+func (h *Handler) handleSessionRequestKeyframe(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req keyframeRequest
+	if err := h.decodeJSONBody(w, r, &req); err != nil && !errors.Is(err.err, io.EOF) {
+		logging.WithSessionID(id).Warn("session.request_keyframe failed", "error", err)
+		writeJSON(w, err.status, errorResponse{Error: err.message})
+		return
+	}
+	found, ok, err := h.manager.RequestKeyframe(id, req.SendPLI)
+	if err != nil {
+		status := http.StatusInternalServerError
+		code := ""
+		switch {
+		case errors.Is(err, session.ErrVideoNotEnabled):
+			status, code = http.StatusConflict, errorCodeMediaNotEnabled
+		case errors.Is(err, session.ErrVideoFixDisabled):
+			status, code = http.StatusConflict, errorCodeVideoFixDisabled
+		case errors.Is(err, session.ErrVideoPeerUnknown):
+			status, code = http.StatusConflict, errorCodeVideoPeerUnknown
+		}
+		logging.WithSessionID(id).Warn("session.request_keyframe failed", "error", err)
+		writeJSON(w, status, errorResponse{Error: err.Error(), Code: code})
+		return
+	}
+	if !ok {
+		logging.WithSessionID(id).Warn("session.request_keyframe failed", "error", "session not found")
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, newGetSessionResponse(h.publicIP, h.internalIP, found))
+}
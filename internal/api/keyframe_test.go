@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_RequestKeyframe_ForwardsSendPLI verifies that the body is optional
+// and send_pli reaches the manager. Inputs: a request without a body and one
+// with send_pli=true. The expected output is HTTP 200 for both with
+// sendPLI=false and then true.
+func TestAPI_RequestKeyframe_ForwardsSendPLI(t *testing.T) {
+	manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-kf"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-kf/request-keyframe", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if manager.keyframeCalls != 1 || manager.keyframeSendPLI {
+		t.Fatalf("expected one call without PLI, got calls=%d sendPLI=%v", manager.keyframeCalls, manager.keyframeSendPLI)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session/sess-kf/request-keyframe", bytes.NewBufferString(`{"send_pli":true}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if manager.keyframeCalls != 2 || !manager.keyframeSendPLI {
+		t.Fatalf("expected second call with PLI, got calls=%d sendPLI=%v", manager.keyframeCalls, manager.keyframeSendPLI)
+	}
+}
+
+// TestAPI_RequestKeyframe_Errors verifies the status and code for each
+// manager refusal, so controllers can tell a misconfigured session from one
+// that simply has not received video yet. Inputs: each sentinel error and a
+// missing session. The expected output is 409 with the matching code, or 404.
+func TestAPI_RequestKeyframe_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		ok         bool
+		wantStatus int
+		wantCode   string
+	}{
+		{"video disabled", session.ErrVideoNotEnabled, true, http.StatusConflict, errorCodeMediaNotEnabled},
+		{"fix off", session.ErrVideoFixDisabled, true, http.StatusConflict, errorCodeVideoFixDisabled},
+		{"no peer", session.ErrVideoPeerUnknown, true, http.StatusConflict, errorCodeVideoPeerUnknown},
+		{"not found", nil, false, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &mockManager{updateOK: tt.ok, updateResult: &session.Session{ID: "sess-kf"}, keyframeErr: tt.err}
+			handler := newTestHandler(manager)
+
+			recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-kf/request-keyframe", bytes.NewBufferString(`{"send_pli":true}`))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, recorder.Code)
+			}
+			var resp errorResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+				t.Fatalf("unexpected decode error: %v", err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Fatalf("expected code %q, got %q", tt.wantCode, resp.Code)
+			}
+		})
+	}
+}
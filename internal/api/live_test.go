@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// newLiveTestHandler serves the API from a real session manager with real
+// sockets on loopback, for behaviour the mock cannot reproduce.
+func newLiveTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	allocator, err := session.NewPortAllocator(37000, 37099)
+	if err != nil {
+		t.Fatalf("unexpected allocator error: %v", err)
+	}
+	manager := session.NewManager(allocator, time.Second, 150*time.Millisecond, 0, false, session.VideoFixConfig{}, session.ProxyLogConfig{}, "")
+	t.Cleanup(manager.Close)
+	return newTestHandler(manager)
+}
+
+func createLiveSession(t *testing.T, handler *Handler, body string) createSessionResponse {
+	t.Helper()
+	recorder := performRequest(handler, http.MethodPost, "/v1/session", bytes.NewBufferString(body))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("create: expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	var created createSessionResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&created); err != nil {
+		t.Fatalf("create: unexpected decode error: %v", err)
+	}
+	return created
+}
+
+func rtpPacket(seq uint16) []byte {
+	packet := make([]byte, 12+160)
+	packet[0] = 0x80
+	binary.BigEndian.PutUint16(packet[2:4], seq)
+	binary.BigEndian.PutUint32(packet[4:8], uint32(seq)*160)
+	binary.BigEndian.PutUint32(packet[8:12], 0x1234)
+	return packet
+}
+
+// TestAPI_LiveManager_CountersRoundTrip verifies with a real manager that GET
+// and update report the live counters, state and last_activity of a session
+// that forwards traffic, not zero values. This matters because the mock
+// cannot populate the session's atomic state, so handler tests alone would
+// not notice the handler reading stale fields. Inputs: an audio-only session
+// forwarding to a loopback receiver and three RTP packets sent to its A port.
+// The expected output is non-zero a_in and b_out counters, state active and a
+// last_activity in both the GET and the update responses.
+func TestAPI_LiveManager_CountersRoundTrip(t *testing.T) {
+	handler := newLiveTestHandler(t)
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen receiver: %v", err)
+	}
+	defer receiver.Close()
+
+	created := createLiveSession(t, handler, fmt.Sprintf(`{"call_id":"c","from_tag":"f","to_tag":"t","audio":{"rtpengine_dest":%q},"video":{"enable":false}}`, receiver.LocalAddr().String()))
+
+	sender, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: created.Audio.APort})
+	if err != nil {
+		t.Fatalf("dial a leg: %v", err)
+	}
+	defer sender.Close()
+	for seq := uint16(1); seq <= 3; seq++ {
+		if _, err := sender.Write(rtpPacket(seq)); err != nil {
+			t.Fatalf("send rtp: %v", err)
+		}
+	}
+
+	var got getSessionResponse
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		recorder := performRequest(handler, http.MethodGet, "/v1/session/"+created.ID, nil)
+		if err := json.NewDecoder(recorder.Body).Decode(&got); err != nil {
+			t.Fatalf("get: unexpected decode error: %v", err)
+		}
+		if got.AudioAInPkts == 3 && got.AudioBOutPkts == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 3 packets in and out, got a_in=%d b_out=%d", got.AudioAInPkts, got.AudioBOutPkts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got.State != "active" || got.LastActivity == "" || got.AudioAInBytes == 0 || got.Audio.LastActivity == "" {
+		t.Fatalf("unexpected state %q last_activity %q a_in_bytes %d audio last_activity %q", got.State, got.LastActivity, got.AudioAInBytes, got.Audio.LastActivity)
+	}
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/"+created.ID+"/update", bytes.NewBufferString(fmt.Sprintf(`{"audio":{"rtpengine_dest":%q}}`, receiver.LocalAddr().String())))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("update: expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var updated getSessionResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&updated); err != nil {
+		t.Fatalf("update: unexpected decode error: %v", err)
+	}
+	if updated.AudioAInPkts != 3 || updated.State != "active" || updated.LastActivity == "" {
+		t.Fatalf("unexpected update response a_in=%d state=%q last_activity=%q", updated.AudioAInPkts, updated.State, updated.LastActivity)
+	}
+}
+
+// TestAPI_UpdateSession_MediaNotRequested_400 verifies that a destination for
+// media disabled at create time is rejected with the media_not_enabled code.
+// This matters because such media has no sockets, so accepting the dest would
+// silently drop the traffic. Inputs: a session created without video and an
+// update carrying a video rtpengine_dest. The expected output is HTTP 400 with
+// the code, video still unset, and an accepted audio update.
+func TestAPI_UpdateSession_MediaNotRequested_400(t *testing.T) {
+	handler := newLiveTestHandler(t)
+	created := createLiveSession(t, handler, `{"call_id":"c","from_tag":"f","to_tag":"t","video":{"enable":false}}`)
+	path := "/v1/session/" + created.ID + "/update"
+
+	recorder := performRequest(handler, http.MethodPost, path, bytes.NewBufferString(`{"video":{"rtpengine_dest":"192.0.2.12:9002"}}`))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if resp.Code != errorCodeMediaNotEnabled {
+		t.Fatalf("expected code %q, got %q", errorCodeMediaNotEnabled, resp.Code)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, path, bytes.NewBufferString(`{"audio":{"rtpengine_dest":"192.0.2.11:9000"}}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected audio update status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var updated getSessionResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&updated); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if updated.Video.RTPEngineDest != "" || updated.Audio.RTPEngineDest != "192.0.2.11:9000" {
+		t.Fatalf("unexpected dests audio=%q video=%q", updated.Audio.RTPEngineDest, updated.Video.RTPEngineDest)
+	}
+}
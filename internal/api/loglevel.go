@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+// logLevelHint lists the accepted level names for error messages.
+const logLevelHint = "must be one of debug, info, warn, error"
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// handleSessionLogLevel overrides LOG_LEVEL for one session. An empty level
+// returns the session to the global level.
+func (h *Handler) handleSessionLogLevel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req logLevelRequest
+	if err := h.decodeJSONBody(w, r, &req); err != nil {
+		logging.WithSessionID(id).Warn("session.loglevel failed", "error", err)
+		writeJSON(w, err.status, errorResponse{Error: err.message})
+		return
+	}
+	var level *slog.Level
+	if req.Level != "" {
+		parsed, err := logging.ParseLevel(req.Level)
+		if err != nil {
+			logging.WithSessionID(id).Warn("session.loglevel failed", "error", err, "field", "level")
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("level %s", logLevelHint)})
+			return
+		}
+		level = &parsed
+	}
+	found, ok := h.manager.SetLogLevel(id, level)
+	if !ok {
+		logging.WithSessionID(id).Warn("session.loglevel failed", "error", "session not found")
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "session not found"})
+		return
+	}
+	found.Logger().Info("session.loglevel", "level", found.LogLevel())
+	writeJSON(w, http.StatusOK, newGetSessionResponse(h.publicIP, h.internalIP, found))
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_SessionLogLevel verifies the loglevel endpoint: a known level is
+// passed to the manager, an empty level clears the override and an unknown
+// one is rejected. Inputs: "debug", "" and "verbose". The expected output is
+// HTTP 200 with level debug, HTTP 200 with nil, and HTTP 400 without a
+// manager call.
+func TestAPI_SessionLogLevel(t *testing.T) {
+	manager := &mockManager{updateOK: true, updateResult: &session.Session{ID: "sess-level"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session/sess-level/loglevel", bytes.NewBufferString(`{"level":"debug"}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if manager.logLevel == nil || *manager.logLevel != slog.LevelDebug {
+		t.Fatalf("expected debug level, got %v", manager.logLevel)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session/sess-level/loglevel", bytes.NewBufferString(`{"level":""}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if manager.logLevel != nil {
+		t.Fatalf("expected override to be cleared, got %v", *manager.logLevel)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session/sess-level/loglevel", bytes.NewBufferString(`{"level":"verbose"}`))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if manager.logLevelCalls != 2 {
+		t.Fatalf("expected 2 SetLogLevel calls, got %d", manager.logLevelCalls)
+	}
+}
+
+// TestAPI_CreateSession_LogLevel verifies that log_level on create reaches the
+// manager through CreateOptions and that unknown names are rejected. Inputs:
+// log_level "warn" and "loud". The expected output is a CreateWithOptions call
+// with LevelWarn, then HTTP 400 without another call.
+func TestAPI_CreateSession_LogLevel(t *testing.T) {
+	manager := &mockManager{createWithOptionsResult: &session.Session{ID: "sess-level"}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodPost, "/v1/session", bytes.NewBufferString(`{"call_id":"c","from_tag":"f","to_tag":"t","log_level":"warn"}`))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	if level := manager.createWithOptionsInput.LogLevel; level == nil || *level != slog.LevelWarn {
+		t.Fatalf("expected warn level in create options, got %v", level)
+	}
+
+	recorder = performRequest(handler, http.MethodPost, "/v1/session", bytes.NewBufferString(`{"call_id":"c","from_tag":"f","to_tag":"t","log_level":"loud"}`))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if manager.createWithOptionsCalls != 1 {
+		t.Fatalf("expected 1 CreateWithOptions call, got %d", manager.createWithOptionsCalls)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDocument is maintained by hand next to the handlers. TestOpenAPI_*
+// keeps it in sync with the request and response structs.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPIDocument)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+type openAPISpec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPISpec {
+	t.Helper()
+	var spec openAPISpec
+	if err := json.Unmarshal(openAPIDocument, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return spec
+}
+
+// jsonFieldNames returns the JSON keys encoded for a struct type.
+func jsonFieldNames(typ reflect.Type) []string {
+	var names []string
+	for i := 0; i < typ.NumField(); i++ {
+		tag := typ.Field(i).Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestOpenAPI_SchemasMatchStructs verifies that every request and response
+// schema lists exactly the JSON fields of the struct it documents. This
+// matters because integrators generate clients from the document, so a field
+// added to a struct but not to the spec silently disappears for them. Inputs:
+// the embedded document and the structs via reflection. The expected output is
+// an identical, sorted field list per schema; a mismatch names the schema and
+// both lists.
+func TestOpenAPI_SchemasMatchStructs(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	var create createSessionRequest
+	tests := map[string]reflect.Type{
+		"CreateSessionRequest":  reflect.TypeOf(createSessionRequest{}),
+		"UpdateSessionRequest":  reflect.TypeOf(updateSessionRequest{}),
+		"MediaUpdateRequest":    reflect.TypeOf(updateMediaRequest{}),
+		"CreateSessionResponse": reflect.TypeOf(createSessionResponse{}),
+		"SessionResponse":       reflect.TypeOf(getSessionResponse{}),
+		"MediaState":            reflect.TypeOf(mediaStateResponse{}),
+		"BulkDeleteResponse":    reflect.TypeOf(bulkDeleteResponse{}),
+		"HealthResponse":        reflect.TypeOf(healthResponse{}),
+		"PortPool":              reflect.TypeOf(portPoolResponse{}),
+		"StatsResponse":         reflect.TypeOf(statsResponse{}),
+		"ReadyResponse":         reflect.TypeOf(readyResponse{}),
+		"VersionResponse":       reflect.TypeOf(versionResponse{}),
+		"SessionEvent":          reflect.TypeOf(sessionEventPayload{}),
+		"ErrorResponse":         reflect.TypeOf(errorResponse{}),
+		"CaptureRequest":        reflect.TypeOf(captureRequest{}),
+		"CaptureState":          reflect.TypeOf(captureResponse{}),
+		"SessionHistoryEntry":   reflect.TypeOf(historyEntryResponse{}),
+		"KeyframeRequest":       reflect.TypeOf(keyframeRequest{}),
+		"LogLevelRequest":       reflect.TypeOf(logLevelRequest{}),
+	}
+	for name, typ := range tests {
+		assertSchemaFields(t, spec, name, jsonFieldNames(typ))
+	}
+
+	// Audio and video share one create schema; it must cover the union.
+	mediaFields := map[string]bool{}
+	for _, typ := range []reflect.Type{reflect.TypeOf(create.Audio), reflect.TypeOf(create.Video)} {
+		for _, field := range jsonFieldNames(typ) {
+			mediaFields[field] = true
+		}
+	}
+	var union []string
+	for field := range mediaFields {
+		union = append(union, field)
+	}
+	sort.Strings(union)
+	assertSchemaFields(t, spec, "MediaCreateRequest", union)
+}
+
+func TestOpenAPI_CountersMatchEmittedFields(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	var want []string
+	for _, field := range sessionCounterFields(&session.Session{}) {
+		want = append(want, field.name)
+	}
+	want = append(want, lastActivityField)
+	sort.Strings(want)
+	assertSchemaFields(t, spec, "CountersResponse", want)
+}
+
+func assertSchemaFields(t *testing.T, spec openAPISpec, name string, want []string) {
+	t.Helper()
+	schema, ok := spec.Components.Schemas[name]
+	if !ok {
+		t.Fatalf("schema %s missing from openapi.json", name)
+	}
+	got := make([]string, 0, len(schema.Properties))
+	for property := range schema.Properties {
+		got = append(got, property)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("schema %s out of sync:\n spec:   %v\n struct: %v", name, got, want)
+	}
+}
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	routes := []string{
+		"GET /v1/health",
+		"HEAD /v1/health",
+		"GET /v1/ready",
+		"GET /v1/stats",
+		"GET /v1/version",
+		"GET /v1/events",
+		"GET /v1/openapi.json",
+		"POST /v1/session",
+		"GET /v1/session/{id}",
+		"HEAD /v1/session/{id}",
+		"DELETE /v1/session/{id}",
+		"GET /v1/session/{id}/counters",
+		"POST /v1/session/{id}/update",
+		"POST /v1/session/{id}/capture",
+		"POST /v1/session/{id}/request-keyframe",
+		"POST /v1/session/{id}/loglevel",
+		"POST /v1/session/{id}/delete",
+		"DELETE /v1/sessions",
+		"POST /v1/sessions/delete",
+	}
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+			t.Fatalf("route %s missing from openapi.json", route)
+		}
+	}
+}
+
+// TestAPI_OpenAPI_ServedWithoutAuth verifies that the document is reachable
+// without a token, so integrators can fetch it before they have credentials.
+func TestAPI_OpenAPI_ServedWithoutAuth(t *testing.T) {
+	handler := newTestHandler(&mockManager{})
+	mux := http.NewServeMux()
+	handler.Register(mux)
+	recorder := httptest.NewRecorder()
+
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+	if !json.Valid(recorder.Body.Bytes()) {
+		t.Fatalf("expected a JSON document")
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import "net/http"
+
+type statsResponse struct {
+	SessionsCreated        uint64           `json:"sessions_created"`
+	SessionsDeleted        uint64           `json:"sessions_deleted"`
+	SessionsIdleReaped     uint64           `json:"sessions_idle_reaped"`
+	ActiveSessions         int              `json:"active_sessions"`
+	CreatesRejectedNoPorts uint64           `json:"creates_rejected_no_ports"`
+	BytesAToB              uint64           `json:"bytes_a_to_b"`
+	BytesBToA              uint64           `json:"bytes_b_to_a"`
+	PortPool               portPoolResponse `json:"port_pool"`
+}
+
+// handleStats reports manager-wide totals since start for dashboards.
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := h.manager.Stats()
+	writeJSON(w, http.StatusOK, statsResponse{
+		SessionsCreated:        stats.SessionsCreated,
+		SessionsDeleted:        stats.SessionsDeleted,
+		SessionsIdleReaped:     stats.SessionsIdleReaped,
+		ActiveSessions:         stats.ActiveSessions,
+		CreatesRejectedNoPorts: stats.CreatesRejectedNoPorts,
+		BytesAToB:              stats.BytesAToB,
+		BytesBToA:              stats.BytesBToA,
+		PortPool:               newPortPoolResponse(stats.PortPool),
+	})
+}
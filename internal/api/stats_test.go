@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"rtp-stream-cleaner/internal/session"
+)
+
+// TestAPI_Stats_ReportsManagerTotals verifies that GET /v1/stats returns the
+// manager totals and pool usage under their documented names. This matters
+// because dashboards graph these keys. Inputs: a manager reporting fixed
+// totals. The expected output is the same numbers in the JSON body.
+func TestAPI_Stats_ReportsManagerTotals(t *testing.T) {
+	manager := &mockManager{stats: session.Stats{
+		SessionsCreated:        12,
+		SessionsDeleted:        7,
+		SessionsIdleReaped:     2,
+		ActiveSessions:         3,
+		CreatesRejectedNoPorts: 1,
+		BytesAToB:              4000,
+		BytesBToA:              3000,
+		PortPool:               session.PoolStats{Size: 100, Free: 76, InUse: 24},
+	}}
+	handler := newTestHandler(manager)
+
+	recorder := performRequest(handler, http.MethodGet, "/v1/stats", nil)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var body statsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	want := statsResponse{
+		SessionsCreated:        12,
+		SessionsDeleted:        7,
+		SessionsIdleReaped:     2,
+		ActiveSessions:         3,
+		CreatesRejectedNoPorts: 1,
+		BytesAToB:              4000,
+		BytesBToA:              3000,
+		PortPool:               portPoolResponse{Size: 100, Free: 76, InUse: 24},
+	}
+	if body != want {
+		t.Fatalf("unexpected stats %+v", body)
+	}
+}
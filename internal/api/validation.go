@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"strings"
+)
+
+// errorCodeValidationFailed marks a 400 whose fields list every invalid
+// request field.
+const errorCodeValidationFailed = "validation_failed"
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// fieldErrors collects validation problems so that a client can fix all of
+// them before resubmitting.
+type fieldErrors []fieldError
+
+func (e *fieldErrors) add(field, message string) {
+	*e = append(*e, fieldError{Field: field, Message: message})
+}
+
+// addError records err for field, keeping a distinct code for failures that
+// are not plain format errors.
+func (e *fieldErrors) addError(field string, err error) {
+	problem := fieldError{Field: field, Message: err.Error()}
+	if errors.Is(err, errDestUnresolvable) {
+		problem.Code = errorCodeDestUnresolvable
+	}
+	*e = append(*e, problem)
+}
+
+func (e fieldErrors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, problem := range e {
+		parts = append(parts, problem.Field+" "+problem.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e fieldErrors) names() []string {
+	names := make([]string, 0, len(e))
+	for _, problem := range e {
+		names = append(names, problem.Field)
+	}
+	return names
+}
+
+func (e fieldErrors) response() errorResponse {
+	return errorResponse{Error: e.Error(), Code: errorCodeValidationFailed, Fields: e}
+}
@@ -0,0 +1,67 @@
+// Package buildinfo holds version information shared by the rtp-cleaner and
+// rtppeer binaries. Version, Commit and Date are set at link time:
+//
+//	go build -ldflags "-X rtp-stream-cleaner/internal/buildinfo.Version=1.4.0 \
+//	  -X rtp-stream-cleaner/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X rtp-stream-cleaner/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+var startedAt = time.Now()
+
+// Info describes the running build.
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+	StartedAt time.Time
+}
+
+// Get returns the build information. When Commit or Date were not injected
+// it falls back to the VCS stamp embedded by the Go toolchain.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		StartedAt: startedAt,
+	}
+	if info.Commit == "" || info.Date == "" {
+		if build, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range build.Settings {
+				switch {
+				case setting.Key == "vcs.revision" && info.Commit == "":
+					info.Commit = setting.Value
+				case setting.Key == "vcs.time" && info.Date == "":
+					info.Date = setting.Value
+				}
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String formats the information for --version output and the startup log.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", i.Version, i.Commit, i.Date, i.GoVersion)
+}
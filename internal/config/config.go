@@ -11,22 +11,66 @@ import (
 const FileName = "config.json"
 
 type Config struct {
-	APIListenAddr           string `json:"api_listen_addr"`
-	ServicePassword         string `json:"service_password"`
-	PublicIP                string `json:"public_ip"`
-	InternalIP              string `json:"internal_ip"`
-	RTPPortMin              int    `json:"rtp_port_min"`
-	RTPPortMax              int    `json:"rtp_port_max"`
-	PeerLearningWindowSec   int    `json:"peer_learning_window_sec"`
-	MaxFrameWaitMS          int    `json:"max_frame_wait_ms"`
-	IdleTimeoutSec          int    `json:"idle_timeout_sec"`
-	VideoInjectCachedSPSPPS bool   `json:"video_inject_cached_sps_pps"`
-	StatsLogIntervalSec     int    `json:"stats_log_interval_sec"`
-	PacketLog               bool   `json:"packet_log"`
-	PacketLogSampleN        int    `json:"packet_log_sample_n"`
-	PacketLogOnAnomaly      bool   `json:"packet_log_on_anomaly"`
-	LogLevel                string `json:"log_level"`
-	LogFormat               string `json:"log_format"`
+	APIListenAddr                string `json:"api_listen_addr"`
+	ServicePassword              string `json:"service_password"`
+	ServicePasswordReadonly      string `json:"service_password_readonly"`
+	PublicIP                     string `json:"public_ip"`
+	InternalIP                   string `json:"internal_ip"`
+	RTPPortMin                   int    `json:"rtp_port_min"`
+	RTPPortMax                   int    `json:"rtp_port_max"`
+	PeerLearningWindowSec        int    `json:"peer_learning_window_sec"`
+	MaxFrameWaitMS               int    `json:"max_frame_wait_ms"`
+	IdleTimeoutSec               int    `json:"idle_timeout_sec"`
+	VideoInjectCachedSPSPPS      bool   `json:"video_inject_cached_sps_pps"`
+	VideoFixBypassErrorThreshold int    `json:"video_fix_bypass_error_threshold"`
+	VideoFixBypassWindowSec      int    `json:"video_fix_bypass_window_sec"`
+	VideoFixBypassCooldownSec    int    `json:"video_fix_bypass_cooldown_sec"`
+	StatsLogIntervalSec          int    `json:"stats_log_interval_sec"`
+	EventsSnapshotIntervalSec    int    `json:"events_snapshot_interval_sec"`
+	ShutdownGraceSec             int    `json:"shutdown_grace_sec"`
+	PacketLog                    bool   `json:"packet_log"`
+	PacketLogSampleN             int    `json:"packet_log_sample_n"`
+	PacketLogOnAnomaly           bool   `json:"packet_log_on_anomaly"`
+	LogLevel                     string `json:"log_level"`
+	LogFormat                    string `json:"log_format"`
+	LogMetadataKeys              string `json:"log_metadata_keys"`
+	CaptureDir                   string `json:"capture_dir"`
+	MaxRequestBodyBytes          int    `json:"max_request_body_bytes"`
+	StrictJSON                   bool   `json:"strict_json"`
+	APITLSCertFile               string `json:"api_tls_cert_file"`
+	APITLSKeyFile                string `json:"api_tls_key_file"`
+	APITLSClientCAFile           string `json:"api_tls_client_ca_file"`
+	RejectDuplicateSessions      bool   `json:"reject_duplicate_sessions"`
+	AccessLog                    string `json:"access_log"`
+	DestDNSTTLSec                int    `json:"dest_dns_ttl_sec"`
+	MaxSessions                  int    `json:"max_sessions"`
+	RTPBindIP                    string `json:"rtp_bind_ip"`
+	RTPBindIPA                   string `json:"rtp_bind_ip_a"`
+	RTPBindIPB                   string `json:"rtp_bind_ip_b"`
+	MediaIdleTimeoutSec          int    `json:"media_idle_timeout_sec"`
+	StateDir                     string `json:"state_dir"`
+	PortReuseCooldownSec         int    `json:"port_reuse_cooldown_sec"`
+	MaxSessionLifetimeSec        int    `json:"max_session_lifetime_sec"`
+	MaxSessionsPerCall           int    `json:"max_sessions_per_call"`
+	PortPoolLowWater             int    `json:"port_pool_low_water"`
+	RTPClockRates                string `json:"rtp_clock_rates"`
+	DTMFPayloadType              int    `json:"dtmf_payload_type"`
+	DropNonRTP                   bool   `json:"drop_non_rtp"`
+	PeerRelearnAfterSec          int    `json:"peer_relearn_after_sec"`
+	BLegStrictPort               bool   `json:"b_leg_strict_port"`
+	MaxFrameBufferPackets        int    `json:"max_frame_buffer_packets"`
+	MaxFrameBufferBytes          int    `json:"max_frame_buffer_bytes"`
+	KeyframeRequestRTCPPort      bool   `json:"keyframe_request_rtcp_port"`
+	KeyframeRequestFIR           bool   `json:"keyframe_request_fir"`
+	PeerSwitchPackets            int    `json:"peer_switch_packets"`
+	RTPDSCP                      int    `json:"rtp_dscp"`
+	RTPTTL                       int    `json:"rtp_ttl"`
+	KeepaliveIntervalSec         int    `json:"keepalive_interval_sec"`
+	KeepaliveFormat              string `json:"keepalive_format"`
+	UDPRcvBufBytes               int    `json:"udp_rcvbuf_bytes"`
+	UDPSndBufBytes               int    `json:"udp_sndbuf_bytes"`
+	MaxPacketSize                int    `json:"max_packet_size"`
+	AudioTSDiscontinuityMS       int    `json:"audio_ts_discontinuity_ms"`
 }
 
 var resolveExecutableDir = func() (string, error) {
@@ -77,22 +121,66 @@ func loadFromFile(path string) (Config, error) {
 func loadFromEnv() Config {
 	packetLog := getEnvBool("PACKET_LOG", false)
 	return Config{
-		APIListenAddr:           getEnv("API_LISTEN_ADDR", "0.0.0.0:8080"),
-		ServicePassword:         os.Getenv("SERVICE_PASSWORD"),
-		PublicIP:                os.Getenv("PUBLIC_IP"),
-		InternalIP:              os.Getenv("INTERNAL_IP"),
-		RTPPortMin:              getEnvInt("RTP_PORT_MIN", 30000),
-		RTPPortMax:              getEnvInt("RTP_PORT_MAX", 40000),
-		PeerLearningWindowSec:   getEnvInt("PEER_LEARNING_WINDOW_SEC", 10),
-		MaxFrameWaitMS:          getEnvInt("MAX_FRAME_WAIT_MS", 120),
-		IdleTimeoutSec:          getEnvInt("IDLE_TIMEOUT_SEC", 60),
-		VideoInjectCachedSPSPPS: getEnvBool("VIDEO_INJECT_CACHED_SPS_PPS", false),
-		StatsLogIntervalSec:     getEnvInt("STATS_LOG_INTERVAL_SEC", 5),
-		PacketLog:               packetLog,
-		PacketLogSampleN:        getEnvInt("PACKET_LOG_SAMPLE_N", 0),
-		PacketLogOnAnomaly:      getEnvBool("PACKET_LOG_ON_ANOMALY", packetLog),
-		LogLevel:                getEnv("LOG_LEVEL", "info"),
-		LogFormat:               getEnv("LOG_FORMAT", "json"),
+		APIListenAddr:                getEnv("API_LISTEN_ADDR", "0.0.0.0:8080"),
+		ServicePassword:              os.Getenv("SERVICE_PASSWORD"),
+		ServicePasswordReadonly:      os.Getenv("SERVICE_PASSWORD_READONLY"),
+		PublicIP:                     os.Getenv("PUBLIC_IP"),
+		InternalIP:                   os.Getenv("INTERNAL_IP"),
+		RTPPortMin:                   getEnvInt("RTP_PORT_MIN", 30000),
+		RTPPortMax:                   getEnvInt("RTP_PORT_MAX", 40000),
+		PeerLearningWindowSec:        getEnvInt("PEER_LEARNING_WINDOW_SEC", 10),
+		MaxFrameWaitMS:               getEnvInt("MAX_FRAME_WAIT_MS", 120),
+		IdleTimeoutSec:               getEnvInt("IDLE_TIMEOUT_SEC", 60),
+		VideoInjectCachedSPSPPS:      getEnvBool("VIDEO_INJECT_CACHED_SPS_PPS", false),
+		VideoFixBypassErrorThreshold: getEnvInt("VIDEO_FIX_BYPASS_ERROR_THRESHOLD", 0),
+		VideoFixBypassWindowSec:      getEnvInt("VIDEO_FIX_BYPASS_WINDOW_SEC", 10),
+		VideoFixBypassCooldownSec:    getEnvInt("VIDEO_FIX_BYPASS_COOLDOWN_SEC", 0),
+		StatsLogIntervalSec:          getEnvInt("STATS_LOG_INTERVAL_SEC", 5),
+		EventsSnapshotIntervalSec:    getEnvInt("EVENTS_SNAPSHOT_INTERVAL_SEC", 5),
+		ShutdownGraceSec:             getEnvInt("SHUTDOWN_GRACE_SEC", 10),
+		PacketLog:                    packetLog,
+		PacketLogSampleN:             getEnvInt("PACKET_LOG_SAMPLE_N", 0),
+		PacketLogOnAnomaly:           getEnvBool("PACKET_LOG_ON_ANOMALY", packetLog),
+		LogLevel:                     getEnv("LOG_LEVEL", "info"),
+		LogFormat:                    getEnv("LOG_FORMAT", "json"),
+		LogMetadataKeys:              os.Getenv("LOG_METADATA_KEYS"),
+		CaptureDir:                   os.Getenv("CAPTURE_DIR"),
+		MaxRequestBodyBytes:          getEnvInt("MAX_REQUEST_BODY_BYTES", 65536),
+		StrictJSON:                   getEnvBool("STRICT_JSON", false),
+		APITLSCertFile:               os.Getenv("API_TLS_CERT_FILE"),
+		APITLSKeyFile:                os.Getenv("API_TLS_KEY_FILE"),
+		APITLSClientCAFile:           os.Getenv("API_TLS_CLIENT_CA_FILE"),
+		RejectDuplicateSessions:      getEnvBool("REJECT_DUPLICATE_SESSIONS", false),
+		AccessLog:                    getEnv("ACCESS_LOG", "all"),
+		DestDNSTTLSec:                getEnvInt("DEST_DNS_TTL_SEC", 0),
+		MaxSessions:                  getEnvInt("MAX_SESSIONS", 0),
+		RTPBindIP:                    getEnv("RTP_BIND_IP", "0.0.0.0"),
+		RTPBindIPA:                   getEnv("RTP_BIND_IP_A", ""),
+		RTPBindIPB:                   getEnv("RTP_BIND_IP_B", ""),
+		MediaIdleTimeoutSec:          getEnvInt("MEDIA_IDLE_TIMEOUT_SEC", 0),
+		StateDir:                     os.Getenv("STATE_DIR"),
+		PortReuseCooldownSec:         getEnvInt("PORT_REUSE_COOLDOWN_SEC", 5),
+		MaxSessionLifetimeSec:        getEnvInt("MAX_SESSION_LIFETIME_SEC", 0),
+		MaxSessionsPerCall:           getEnvInt("MAX_SESSIONS_PER_CALL", 0),
+		PortPoolLowWater:             getEnvInt("PORT_POOL_LOW_WATER", 8),
+		RTPClockRates:                getEnv("RTP_CLOCK_RATES", ""),
+		DTMFPayloadType:              getEnvInt("DTMF_PAYLOAD_TYPE", 101),
+		DropNonRTP:                   getEnvBool("DROP_NON_RTP", true),
+		PeerRelearnAfterSec:          getEnvInt("PEER_RELEARN_AFTER_SEC", 5),
+		BLegStrictPort:               getEnvBool("B_LEG_STRICT_PORT", true),
+		MaxFrameBufferPackets:        getEnvInt("MAX_FRAME_BUFFER_PACKETS", 1000),
+		MaxFrameBufferBytes:          getEnvInt("MAX_FRAME_BUFFER_BYTES", 1048576),
+		KeyframeRequestRTCPPort:      getEnvBool("KEYFRAME_REQUEST_RTCP_PORT", false),
+		KeyframeRequestFIR:           getEnvBool("KEYFRAME_REQUEST_FIR", false),
+		PeerSwitchPackets:            getEnvInt("PEER_SWITCH_PACKETS", 3),
+		RTPDSCP:                      getEnvInt("RTP_DSCP", 0),
+		RTPTTL:                       getEnvInt("RTP_TTL", 0),
+		KeepaliveIntervalSec:         getEnvInt("KEEPALIVE_INTERVAL_SEC", 0),
+		KeepaliveFormat:              getEnv("KEEPALIVE_FORMAT", "empty"),
+		UDPRcvBufBytes:               getEnvInt("UDP_RCVBUF_BYTES", 0),
+		UDPSndBufBytes:               getEnvInt("UDP_SNDBUF_BYTES", 0),
+		MaxPacketSize:                getEnvInt("MAX_PACKET_SIZE", 9216),
+		AudioTSDiscontinuityMS:       getEnvInt("AUDIO_TS_DISCONTINUITY_MS", 2000),
 	}
 }
 
@@ -14,6 +14,7 @@ func TestLoad_FileWinsOverEnv(t *testing.T) {
 	configJSON := `{
 		"api_listen_addr": "127.0.0.1:9999",
 		"service_password": "from-file-password",
+		"service_password_readonly": "from-file-readonly",
 		"public_ip": "198.51.100.10",
 		"internal_ip": "10.10.0.5",
 		"rtp_port_min": 21000,
@@ -22,34 +23,121 @@ func TestLoad_FileWinsOverEnv(t *testing.T) {
 		"max_frame_wait_ms": 240,
 		"idle_timeout_sec": 70,
 		"video_inject_cached_sps_pps": true,
+		"video_fix_bypass_error_threshold": 50,
+		"video_fix_bypass_window_sec": 15,
+		"video_fix_bypass_cooldown_sec": 120,
 		"stats_log_interval_sec": 8,
+		"events_snapshot_interval_sec": 3,
+		"shutdown_grace_sec": 25,
 		"packet_log": true,
 		"packet_log_sample_n": 13,
 		"packet_log_on_anomaly": false,
 		"log_level": "debug",
-		"log_format": "text"
+		"log_format": "text",
+		"log_metadata_keys": "tenant,device_id",
+		"capture_dir": "/var/lib/rtp-cleaner/captures",
+		"max_request_body_bytes": 4096,
+		"strict_json": true,
+		"api_tls_cert_file": "/etc/rtp-cleaner/api.crt",
+		"api_tls_key_file": "/etc/rtp-cleaner/api.key",
+		"api_tls_client_ca_file": "/etc/rtp-cleaner/clients.crt",
+		"reject_duplicate_sessions": true,
+		"access_log": "errors",
+		"dest_dns_ttl_sec": 30,
+		"max_sessions": 200,
+		"rtp_bind_ip": "10.10.0.5",
+		"rtp_bind_ip_a": "10.10.0.6",
+		"rtp_bind_ip_b": "10.10.0.7",
+		"media_idle_timeout_sec": 20,
+		"state_dir": "/var/lib/rtp-cleaner/state",
+		"port_reuse_cooldown_sec": 3,
+		"max_session_lifetime_sec": 86400,
+		"max_sessions_per_call": 4,
+		"port_pool_low_water": 16,
+		"rtp_clock_rates": "96:90000,111:48000",
+		"dtmf_payload_type": 96,
+		"drop_non_rtp": false,
+		"peer_relearn_after_sec": 7,
+		"b_leg_strict_port": false,
+		"max_frame_buffer_packets": 500,
+		"max_frame_buffer_bytes": 500000,
+		"keyframe_request_rtcp_port": true,
+		"keyframe_request_fir": true,
+		"peer_switch_packets": 5,
+		"rtp_dscp": 46,
+		"rtp_ttl": 32,
+		"keepalive_interval_sec": 15,
+		"keepalive_format": "rtp_nop",
+		"udp_rcvbuf_bytes": 4194304,
+		"udp_sndbuf_bytes": 1048576,
+		"max_packet_size": 65535,
+		"audio_ts_discontinuity_ms": 5000
 	}`
 	if err := os.WriteFile(filepath.Join(tempDir, FileName), []byte(configJSON), 0o644); err != nil {
 		t.Fatalf("write config file: %v", err)
 	}
 
 	setAllEnv(t, map[string]string{
-		"API_LISTEN_ADDR":             "0.0.0.0:8081",
-		"SERVICE_PASSWORD":            "from-env-password",
-		"PUBLIC_IP":                   "203.0.113.50",
-		"INTERNAL_IP":                 "10.0.0.1",
-		"RTP_PORT_MIN":                "30000",
-		"RTP_PORT_MAX":                "40000",
-		"PEER_LEARNING_WINDOW_SEC":    "10",
-		"MAX_FRAME_WAIT_MS":           "120",
-		"IDLE_TIMEOUT_SEC":            "60",
-		"VIDEO_INJECT_CACHED_SPS_PPS": "false",
-		"STATS_LOG_INTERVAL_SEC":      "5",
-		"PACKET_LOG":                  "false",
-		"PACKET_LOG_SAMPLE_N":         "0",
-		"PACKET_LOG_ON_ANOMALY":       "true",
-		"LOG_LEVEL":                   "error",
-		"LOG_FORMAT":                  "json",
+		"API_LISTEN_ADDR":                  "0.0.0.0:8081",
+		"SERVICE_PASSWORD":                 "from-env-password",
+		"SERVICE_PASSWORD_READONLY":        "from-env-readonly",
+		"PUBLIC_IP":                        "203.0.113.50",
+		"INTERNAL_IP":                      "10.0.0.1",
+		"RTP_PORT_MIN":                     "30000",
+		"RTP_PORT_MAX":                     "40000",
+		"PEER_LEARNING_WINDOW_SEC":         "10",
+		"MAX_FRAME_WAIT_MS":                "120",
+		"IDLE_TIMEOUT_SEC":                 "60",
+		"VIDEO_INJECT_CACHED_SPS_PPS":      "false",
+		"VIDEO_FIX_BYPASS_ERROR_THRESHOLD": "5",
+		"VIDEO_FIX_BYPASS_WINDOW_SEC":      "10",
+		"VIDEO_FIX_BYPASS_COOLDOWN_SEC":    "0",
+		"STATS_LOG_INTERVAL_SEC":           "5",
+		"EVENTS_SNAPSHOT_INTERVAL_SEC":     "5",
+		"SHUTDOWN_GRACE_SEC":               "10",
+		"PACKET_LOG":                       "false",
+		"PACKET_LOG_SAMPLE_N":              "0",
+		"PACKET_LOG_ON_ANOMALY":            "true",
+		"LOG_LEVEL":                        "error",
+		"LOG_FORMAT":                       "json",
+		"LOG_METADATA_KEYS":                "from-env",
+		"CAPTURE_DIR":                      "/from-env",
+		"MAX_REQUEST_BODY_BYTES":           "1",
+		"STRICT_JSON":                      "false",
+		"API_TLS_CERT_FILE":                "/from-env.crt",
+		"API_TLS_KEY_FILE":                 "/from-env.key",
+		"API_TLS_CLIENT_CA_FILE":           "/from-env-ca.crt",
+		"REJECT_DUPLICATE_SESSIONS":        "false",
+		"ACCESS_LOG":                       "all",
+		"DEST_DNS_TTL_SEC":                 "45",
+		"MAX_SESSIONS":                     "150",
+		"RTP_BIND_IP":                      "10.20.0.5",
+		"RTP_BIND_IP_A":                    "10.20.0.6",
+		"RTP_BIND_IP_B":                    "10.20.0.7",
+		"MEDIA_IDLE_TIMEOUT_SEC":           "15",
+		"STATE_DIR":                        "/from-env",
+		"PORT_REUSE_COOLDOWN_SEC":          "8",
+		"MAX_SESSION_LIFETIME_SEC":         "3600",
+		"MAX_SESSIONS_PER_CALL":            "3",
+		"PORT_POOL_LOW_WATER":              "12",
+		"RTP_CLOCK_RATES":                  "97:90000",
+		"DTMF_PAYLOAD_TYPE":                "97",
+		"DROP_NON_RTP":                     "true",
+		"PEER_RELEARN_AFTER_SEC":           "8",
+		"B_LEG_STRICT_PORT":                "true",
+		"MAX_FRAME_BUFFER_PACKETS":         "600",
+		"MAX_FRAME_BUFFER_BYTES":           "600000",
+		"KEYFRAME_REQUEST_RTCP_PORT":       "false",
+		"KEYFRAME_REQUEST_FIR":             "false",
+		"PEER_SWITCH_PACKETS":              "4",
+		"RTP_DSCP":                         "34",
+		"RTP_TTL":                          "16",
+		"KEEPALIVE_INTERVAL_SEC":           "10",
+		"KEEPALIVE_FORMAT":                 "empty",
+		"UDP_RCVBUF_BYTES":                 "1048576",
+		"UDP_SNDBUF_BYTES":                 "524288",
+		"MAX_PACKET_SIZE":                  "1500",
+		"AUDIO_TS_DISCONTINUITY_MS":        "1000",
 	})
 
 	cfg, err := Load()
@@ -59,6 +147,7 @@ func TestLoad_FileWinsOverEnv(t *testing.T) {
 
 	if cfg.APIListenAddr != "127.0.0.1:9999" ||
 		cfg.ServicePassword != "from-file-password" ||
+		cfg.ServicePasswordReadonly != "from-file-readonly" ||
 		cfg.PublicIP != "198.51.100.10" ||
 		cfg.InternalIP != "10.10.0.5" ||
 		cfg.RTPPortMin != 21000 ||
@@ -67,12 +156,55 @@ func TestLoad_FileWinsOverEnv(t *testing.T) {
 		cfg.MaxFrameWaitMS != 240 ||
 		cfg.IdleTimeoutSec != 70 ||
 		!cfg.VideoInjectCachedSPSPPS ||
+		cfg.VideoFixBypassErrorThreshold != 50 ||
+		cfg.VideoFixBypassWindowSec != 15 ||
+		cfg.VideoFixBypassCooldownSec != 120 ||
 		cfg.StatsLogIntervalSec != 8 ||
+		cfg.EventsSnapshotIntervalSec != 3 ||
+		cfg.ShutdownGraceSec != 25 ||
 		!cfg.PacketLog ||
 		cfg.PacketLogSampleN != 13 ||
 		cfg.PacketLogOnAnomaly ||
 		cfg.LogLevel != "debug" ||
-		cfg.LogFormat != "text" {
+		cfg.LogFormat != "text" ||
+		cfg.LogMetadataKeys != "tenant,device_id" ||
+		cfg.CaptureDir != "/var/lib/rtp-cleaner/captures" ||
+		cfg.MaxRequestBodyBytes != 4096 ||
+		!cfg.StrictJSON ||
+		cfg.APITLSCertFile != "/etc/rtp-cleaner/api.crt" ||
+		cfg.APITLSKeyFile != "/etc/rtp-cleaner/api.key" ||
+		cfg.APITLSClientCAFile != "/etc/rtp-cleaner/clients.crt" ||
+		!cfg.RejectDuplicateSessions ||
+		cfg.AccessLog != "errors" ||
+		cfg.DestDNSTTLSec != 30 ||
+		cfg.MaxSessions != 200 ||
+		cfg.RTPBindIP != "10.10.0.5" ||
+		cfg.RTPBindIPA != "10.10.0.6" ||
+		cfg.RTPBindIPB != "10.10.0.7" ||
+		cfg.MediaIdleTimeoutSec != 20 ||
+		cfg.StateDir != "/var/lib/rtp-cleaner/state" ||
+		cfg.PortReuseCooldownSec != 3 ||
+		cfg.MaxSessionLifetimeSec != 86400 ||
+		cfg.MaxSessionsPerCall != 4 ||
+		cfg.PortPoolLowWater != 16 ||
+		cfg.RTPClockRates != "96:90000,111:48000" ||
+		cfg.DTMFPayloadType != 96 ||
+		cfg.DropNonRTP ||
+		cfg.PeerRelearnAfterSec != 7 ||
+		cfg.BLegStrictPort ||
+		cfg.MaxFrameBufferPackets != 500 ||
+		cfg.MaxFrameBufferBytes != 500000 ||
+		!cfg.KeyframeRequestRTCPPort ||
+		!cfg.KeyframeRequestFIR ||
+		cfg.PeerSwitchPackets != 5 ||
+		cfg.RTPDSCP != 46 ||
+		cfg.RTPTTL != 32 ||
+		cfg.KeepaliveIntervalSec != 15 ||
+		cfg.KeepaliveFormat != "rtp_nop" ||
+		cfg.UDPRcvBufBytes != 4194304 ||
+		cfg.UDPSndBufBytes != 1048576 ||
+		cfg.MaxPacketSize != 65535 ||
+		cfg.AudioTSDiscontinuityMS != 5000 {
 		t.Fatalf("expected file config values, got %+v", cfg)
 	}
 }
@@ -82,22 +214,66 @@ func TestLoad_EnvFallbackWhenFileAbsent(t *testing.T) {
 	withExecutableDir(t, tempDir)
 
 	setAllEnv(t, map[string]string{
-		"API_LISTEN_ADDR":             "0.0.0.0:7070",
-		"SERVICE_PASSWORD":            "env-password",
-		"PUBLIC_IP":                   "203.0.113.42",
-		"INTERNAL_IP":                 "10.20.30.40",
-		"RTP_PORT_MIN":                "31000",
-		"RTP_PORT_MAX":                "32000",
-		"PEER_LEARNING_WINDOW_SEC":    "12",
-		"MAX_FRAME_WAIT_MS":           "180",
-		"IDLE_TIMEOUT_SEC":            "65",
-		"VIDEO_INJECT_CACHED_SPS_PPS": "true",
-		"STATS_LOG_INTERVAL_SEC":      "9",
-		"PACKET_LOG":                  "true",
-		"PACKET_LOG_SAMPLE_N":         "4",
-		"PACKET_LOG_ON_ANOMALY":       "false",
-		"LOG_LEVEL":                   "warn",
-		"LOG_FORMAT":                  "text",
+		"API_LISTEN_ADDR":                  "0.0.0.0:7070",
+		"SERVICE_PASSWORD":                 "env-password",
+		"SERVICE_PASSWORD_READONLY":        "env-readonly",
+		"PUBLIC_IP":                        "203.0.113.42",
+		"INTERNAL_IP":                      "10.20.30.40",
+		"RTP_PORT_MIN":                     "31000",
+		"RTP_PORT_MAX":                     "32000",
+		"PEER_LEARNING_WINDOW_SEC":         "12",
+		"MAX_FRAME_WAIT_MS":                "180",
+		"IDLE_TIMEOUT_SEC":                 "65",
+		"VIDEO_INJECT_CACHED_SPS_PPS":      "true",
+		"VIDEO_FIX_BYPASS_ERROR_THRESHOLD": "20",
+		"VIDEO_FIX_BYPASS_WINDOW_SEC":      "4",
+		"VIDEO_FIX_BYPASS_COOLDOWN_SEC":    "30",
+		"STATS_LOG_INTERVAL_SEC":           "9",
+		"EVENTS_SNAPSHOT_INTERVAL_SEC":     "2",
+		"SHUTDOWN_GRACE_SEC":               "7",
+		"PACKET_LOG":                       "true",
+		"PACKET_LOG_SAMPLE_N":              "4",
+		"PACKET_LOG_ON_ANOMALY":            "false",
+		"LOG_LEVEL":                        "warn",
+		"LOG_FORMAT":                       "text",
+		"LOG_METADATA_KEYS":                "building",
+		"CAPTURE_DIR":                      "/tmp/captures",
+		"MAX_REQUEST_BODY_BYTES":           "131072",
+		"STRICT_JSON":                      "true",
+		"API_TLS_CERT_FILE":                "/tls/api.crt",
+		"API_TLS_KEY_FILE":                 "/tls/api.key",
+		"API_TLS_CLIENT_CA_FILE":           "/tls/ca.crt",
+		"REJECT_DUPLICATE_SESSIONS":        "true",
+		"ACCESS_LOG":                       "off",
+		"DEST_DNS_TTL_SEC":                 "45",
+		"MAX_SESSIONS":                     "250",
+		"RTP_BIND_IP":                      "10.30.0.5",
+		"RTP_BIND_IP_A":                    "10.30.0.6",
+		"RTP_BIND_IP_B":                    "10.30.0.7",
+		"MEDIA_IDLE_TIMEOUT_SEC":           "25",
+		"STATE_DIR":                        "/tmp/state",
+		"PORT_REUSE_COOLDOWN_SEC":          "10",
+		"MAX_SESSION_LIFETIME_SEC":         "7200",
+		"MAX_SESSIONS_PER_CALL":            "2",
+		"PORT_POOL_LOW_WATER":              "24",
+		"RTP_CLOCK_RATES":                  "101:8000",
+		"DTMF_PAYLOAD_TYPE":                "100",
+		"DROP_NON_RTP":                     "false",
+		"PEER_RELEARN_AFTER_SEC":           "9",
+		"B_LEG_STRICT_PORT":                "false",
+		"MAX_FRAME_BUFFER_PACKETS":         "700",
+		"MAX_FRAME_BUFFER_BYTES":           "700000",
+		"KEYFRAME_REQUEST_RTCP_PORT":       "true",
+		"KEYFRAME_REQUEST_FIR":             "true",
+		"PEER_SWITCH_PACKETS":              "2",
+		"RTP_DSCP":                         "26",
+		"RTP_TTL":                          "48",
+		"KEEPALIVE_INTERVAL_SEC":           "20",
+		"KEEPALIVE_FORMAT":                 "rtp_nop",
+		"UDP_RCVBUF_BYTES":                 "2097152",
+		"UDP_SNDBUF_BYTES":                 "262144",
+		"MAX_PACKET_SIZE":                  "4096",
+		"AUDIO_TS_DISCONTINUITY_MS":        "3000",
 	})
 
 	cfg, err := Load()
@@ -107,6 +283,7 @@ func TestLoad_EnvFallbackWhenFileAbsent(t *testing.T) {
 
 	if cfg.APIListenAddr != "0.0.0.0:7070" ||
 		cfg.ServicePassword != "env-password" ||
+		cfg.ServicePasswordReadonly != "env-readonly" ||
 		cfg.PublicIP != "203.0.113.42" ||
 		cfg.InternalIP != "10.20.30.40" ||
 		cfg.RTPPortMin != 31000 ||
@@ -115,12 +292,55 @@ func TestLoad_EnvFallbackWhenFileAbsent(t *testing.T) {
 		cfg.MaxFrameWaitMS != 180 ||
 		cfg.IdleTimeoutSec != 65 ||
 		!cfg.VideoInjectCachedSPSPPS ||
+		cfg.VideoFixBypassErrorThreshold != 20 ||
+		cfg.VideoFixBypassWindowSec != 4 ||
+		cfg.VideoFixBypassCooldownSec != 30 ||
 		cfg.StatsLogIntervalSec != 9 ||
+		cfg.EventsSnapshotIntervalSec != 2 ||
+		cfg.ShutdownGraceSec != 7 ||
 		!cfg.PacketLog ||
 		cfg.PacketLogSampleN != 4 ||
 		cfg.PacketLogOnAnomaly ||
 		cfg.LogLevel != "warn" ||
-		cfg.LogFormat != "text" {
+		cfg.LogFormat != "text" ||
+		cfg.LogMetadataKeys != "building" ||
+		cfg.CaptureDir != "/tmp/captures" ||
+		cfg.MaxRequestBodyBytes != 131072 ||
+		!cfg.StrictJSON ||
+		cfg.APITLSCertFile != "/tls/api.crt" ||
+		cfg.APITLSKeyFile != "/tls/api.key" ||
+		cfg.APITLSClientCAFile != "/tls/ca.crt" ||
+		!cfg.RejectDuplicateSessions ||
+		cfg.AccessLog != "off" ||
+		cfg.DestDNSTTLSec != 45 ||
+		cfg.MaxSessions != 250 ||
+		cfg.RTPBindIP != "10.30.0.5" ||
+		cfg.RTPBindIPA != "10.30.0.6" ||
+		cfg.RTPBindIPB != "10.30.0.7" ||
+		cfg.MediaIdleTimeoutSec != 25 ||
+		cfg.StateDir != "/tmp/state" ||
+		cfg.PortReuseCooldownSec != 10 ||
+		cfg.MaxSessionLifetimeSec != 7200 ||
+		cfg.MaxSessionsPerCall != 2 ||
+		cfg.PortPoolLowWater != 24 ||
+		cfg.RTPClockRates != "101:8000" ||
+		cfg.DTMFPayloadType != 100 ||
+		cfg.DropNonRTP ||
+		cfg.PeerRelearnAfterSec != 9 ||
+		cfg.BLegStrictPort ||
+		cfg.MaxFrameBufferPackets != 700 ||
+		cfg.MaxFrameBufferBytes != 700000 ||
+		!cfg.KeyframeRequestRTCPPort ||
+		!cfg.KeyframeRequestFIR ||
+		cfg.PeerSwitchPackets != 2 ||
+		cfg.RTPDSCP != 26 ||
+		cfg.RTPTTL != 48 ||
+		cfg.KeepaliveIntervalSec != 20 ||
+		cfg.KeepaliveFormat != "rtp_nop" ||
+		cfg.UDPRcvBufBytes != 2097152 ||
+		cfg.UDPSndBufBytes != 262144 ||
+		cfg.MaxPacketSize != 4096 ||
+		cfg.AudioTSDiscontinuityMS != 3000 {
 		t.Fatalf("expected env config values, got %+v", cfg)
 	}
 }
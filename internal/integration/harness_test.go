@@ -55,8 +55,9 @@ type createSessionRequest struct {
 		Enable bool `json:"enable"`
 	} `json:"audio"`
 	Video struct {
-		Enable bool  `json:"enable"`
-		Fix    *bool `json:"fix,omitempty"`
+		Enable        bool    `json:"enable"`
+		Fix           *bool   `json:"fix,omitempty"`
+		RTPEngineDest *string `json:"rtpengine_dest,omitempty"`
 	} `json:"video"`
 }
 
@@ -70,11 +71,11 @@ type updateMediaRequest struct {
 }
 
 type createSessionResponse struct {
-	ID         string       `json:"id"`
-	PublicIP   string       `json:"public_ip"`
-	InternalIP string       `json:"internal_ip"`
-	Audio      portResponse `json:"audio"`
-	Video      portResponse `json:"video"`
+	ID         string             `json:"id"`
+	PublicIP   string             `json:"public_ip"`
+	InternalIP string             `json:"internal_ip"`
+	Audio      mediaStateResponse `json:"audio"`
+	Video      mediaStateResponse `json:"video"`
 }
 
 type sessionStateResponse struct {
@@ -101,22 +102,35 @@ type sessionStateResponse struct {
 	VideoInjectedSPS     uint64             `json:"video_injected_sps"`
 	VideoInjectedPPS     uint64             `json:"video_injected_pps"`
 	VideoSeqDeltaCurrent uint64             `json:"video_seq_delta_current"`
+	AudioDrops           uint64             `json:"audio_drops"`
+	AudioIgnoredDisabled uint64             `json:"audio_ignored_disabled"`
+	VideoDrops           uint64             `json:"video_drops"`
+	VideoIgnoredDisabled uint64             `json:"video_ignored_disabled"`
+	AudioRTCPAInPkts     uint64             `json:"audio_rtcp_a_in_pkts"`
+	AudioRTCPBInPkts     uint64             `json:"audio_rtcp_b_in_pkts"`
+	AudioRTCPDrops       uint64             `json:"audio_rtcp_drops"`
+	Events               []historyEntry     `json:"events"`
 	State                string             `json:"state"`
 }
 
-type portResponse struct {
-	APort int `json:"a_port"`
-	BPort int `json:"b_port"`
+type historyEntry struct {
+	Time   string `json:"time"`
+	Type   string `json:"type"`
+	Media  string `json:"media"`
+	Detail string `json:"detail"`
 }
 
 type mediaStateResponse struct {
-	APort         int    `json:"a_port"`
-	BPort         int    `json:"b_port"`
-	RTPEngineDest string `json:"rtpengine_dest"`
+	APort          int    `json:"a_port"`
+	BPort          int    `json:"b_port"`
+	RTPEngineDest  string `json:"rtpengine_dest"`
+	Enabled        bool   `json:"enabled"`
+	DisabledReason string `json:"disabled_reason"`
 }
 
 type errorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code"`
 }
 
 type rtpCleanerInstance struct {
@@ -137,6 +151,9 @@ type rtpPeerSendConfig struct {
 	Pacing    string
 	Duration  time.Duration
 	Timeout   time.Duration
+	// RTCP makes the sender bind port+1 of each media port and send a sender
+	// report per stream to port+1 of its destination.
+	RTCP bool
 }
 
 type rtpPeerRecvConfig struct {
@@ -175,6 +192,37 @@ func freeUDPPort(t *testing.T) int {
 	return addr.Port
 }
 
+// listenUDPPair binds an RTP socket on a free even port and the RTCP socket
+// on the port after it.
+func listenUDPPair(t *testing.T) (rtp, rtcp *net.UDPConn) {
+	t.Helper()
+	for attempt := 0; attempt < 20; attempt++ {
+		port := freeUDPPort(t) &^ 1
+		rtp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			continue
+		}
+		rtcp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1})
+		if err != nil {
+			_ = rtp.Close()
+			continue
+		}
+		return rtp, rtcp
+	}
+	t.Fatalf("no free udp port pair")
+	return nil, nil
+}
+
+// freeUDPPortPair returns an even port that is free together with the port
+// after it.
+func freeUDPPortPair(t *testing.T) int {
+	t.Helper()
+	rtp, rtcp := listenUDPPair(t)
+	defer rtcp.Close()
+	defer rtp.Close()
+	return rtp.LocalAddr().(*net.UDPAddr).Port
+}
+
 func startRtpCleaner(t *testing.T, env map[string]string) (*rtpCleanerInstance, func()) {
 	t.Helper()
 	binary := buildRtpCleaner(t)
@@ -257,6 +305,16 @@ func getSession(t *testing.T, client *http.Client, baseURL, id string) (sessionS
 	return resp, status, err
 }
 
+// getSessionCounters fetches the compact counters view. Its keys are a subset
+// of the full session document, so it decodes into sessionStateResponse with
+// the non-counter fields left empty.
+func getSessionCounters(t *testing.T, client *http.Client, baseURL, id string) (sessionStateResponse, int, error) {
+	t.Helper()
+	var resp sessionStateResponse
+	status, err := doJSONRequest(client, http.MethodGet, withAccessToken(baseURL+"/v1/session/"+id+"/counters"), nil, &resp)
+	return resp, status, err
+}
+
 func deleteSession(t *testing.T, client *http.Client, baseURL, id string) (int, error) {
 	t.Helper()
 	return doJSONRequest(client, http.MethodDelete, withAccessToken(baseURL+"/v1/session/"+id), nil, nil)
@@ -342,6 +400,9 @@ func rtpPeerSendPCAP(t *testing.T, cfg rtpPeerSendConfig) error {
 	if cfg.Pacing != "" {
 		args = append(args, "--pacing", cfg.Pacing)
 	}
+	if cfg.RTCP {
+		args = append(args, "--rtcp")
+	}
 	if cfg.Duration > 0 {
 		args = append(args, "--duration", fmt.Sprintf("%d", int(cfg.Duration.Seconds())))
 	}
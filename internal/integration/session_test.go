@@ -2,9 +2,12 @@ package integration_test
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,7 +31,7 @@ func waitForSessionCondition(t *testing.T, client *http.Client, baseURL, id stri
 	t.Helper()
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
-		resp, status, err := getSession(t, client, baseURL, id)
+		resp, status, err := getSessionCounters(t, client, baseURL, id)
 		if err != nil {
 			return resp, err
 		}
@@ -311,7 +314,7 @@ func TestIntegrationA5DeleteActiveStopsTraffic(t *testing.T) {
 		AudioPort: freeUDPPort(t),
 		VideoPort: freeUDPPort(t),
 		AudioTo:   fmt.Sprintf("127.0.0.1:%d", createResp.Audio.APort),
-		VideoTo:   fmt.Sprintf("127.0.0.1:%d", createResp.Video.APort),
+		VideoTo:   fmt.Sprintf("127.0.0.1:%d", freeUDPPort(t)),
 		AudioSSRC: normalAudioSSRC,
 		VideoSSRC: normalVideoSSRC,
 		SendPCAP:  filepath.Join(repoRoot(t), "testdata", "normal.pcap"),
@@ -365,6 +368,134 @@ func TestIntegrationA5DeleteActiveStopsTraffic(t *testing.T) {
 	}
 }
 
+// TestIntegrationA6CreateReportsMediaState verifies that the create response
+// carries the same per-media state as GET, so a controller does not need a
+// follow-up request to learn that a leg starts disabled. No media is sent. We
+// create a session with video rtpengine_dest 0.0.0.0:0 (SDP port 0) and expect
+// the create response to report audio enabled without a destination and video
+// disabled with reason rtpengine_port_0, matching the GET response field by
+// field. Env used: PUBLIC_IP/INTERNAL_IP=127.0.0.1, PEER_LEARNING_WINDOW_SEC=1,
+// IDLE_TIMEOUT_SEC=10, MAX_FRAME_WAIT_MS=150, RTP_PORT_MIN/MAX. We avoid flakes
+// by polling /v1/health and comparing deterministic API responses only.
+func TestIntegrationA6CreateReportsMediaState(t *testing.T) {
+	instance, cleanup := startRtpCleaner(t, baseEnv("10"))
+	t.Cleanup(cleanup)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	if err := waitForHealth(instance.BaseURL, 2*time.Second); err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+
+	videoDest := "0.0.0.0:0"
+	var createReq createSessionRequest
+	createReq.CallID = "call-a6"
+	createReq.FromTag = "from-a6"
+	createReq.ToTag = "to-a6"
+	createReq.Audio.Enable = true
+	createReq.Video.Enable = true
+	createReq.Video.RTPEngineDest = &videoDest
+	createResp, err := createSession(t, client, instance.BaseURL, createReq)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if !createResp.Audio.Enabled || createResp.Audio.RTPEngineDest != "" {
+		t.Fatalf("create session: expected audio enabled without dest, got %+v", createResp.Audio)
+	}
+	if createResp.Video.Enabled || createResp.Video.DisabledReason != "rtpengine_port_0" {
+		t.Fatalf("create session: expected video disabled by port 0, got %+v", createResp.Video)
+	}
+
+	gotSession, status, err := getSession(t, client, instance.BaseURL, createResp.ID)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("get session: expected 200, got %d", status)
+	}
+	if gotSession.Audio != createResp.Audio {
+		t.Fatalf("audio state differs: create %+v, get %+v", createResp.Audio, gotSession.Audio)
+	}
+	if gotSession.Video != createResp.Video {
+		t.Fatalf("video state differs: create %+v, get %+v", createResp.Video, gotSession.Video)
+	}
+}
+
+// TestIntegrationA7SessionHistory verifies the event history returned by
+// GET ?include=events. Topology: a session with audio only; a plain UDP socket
+// sends one datagram to the audio A-leg port after the rtpengine destination
+// is set, which latches the doorphone peer. We expect created, media_disabled
+// for the video that was not requested, then dest_set, first_packet and
+// peer_learned for audio in that order, and no events key without include. Env used: PUBLIC_IP/INTERNAL_IP=127.0.0.1,
+// PEER_LEARNING_WINDOW_SEC=1, IDLE_TIMEOUT_SEC=10, MAX_FRAME_WAIT_MS=150,
+// RTP_PORT_MIN/MAX. Flake avoidance: we poll GET until the packet entries show
+// up instead of sleeping.
+func TestIntegrationA7SessionHistory(t *testing.T) {
+	instance, cleanup := startRtpCleaner(t, baseEnv("10"))
+	t.Cleanup(cleanup)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	if err := waitForHealth(instance.BaseURL, 2*time.Second); err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+
+	var createReq createSessionRequest
+	createReq.CallID = "call-a7"
+	createReq.FromTag = "from-a7"
+	createReq.ToTag = "to-a7"
+	createReq.Audio.Enable = true
+	createReq.Video.Enable = false
+	createResp, err := createSession(t, client, instance.BaseURL, createReq)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	audioDest := fmt.Sprintf("127.0.0.1:%d", freeUDPPort(t))
+	if _, status, err := updateSession(t, client, instance.BaseURL, createResp.ID, updateSessionRequest{Audio: &updateMediaRequest{RTPEngineDest: &audioDest}}); err != nil || status != http.StatusOK {
+		t.Fatalf("update session: status %d, err %v", status, err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: createResp.Audio.APort})
+	if err != nil {
+		t.Fatalf("dial audio a leg: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{0x80, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1}); err != nil {
+		t.Fatalf("send packet: %v", err)
+	}
+
+	expected := []string{"created", "media_disabled", "dest_set", "first_packet", "peer_learned"}
+	var got []string
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		var resp sessionStateResponse
+		status, err := doJSONRequest(client, http.MethodGet, withAccessToken(instance.BaseURL+"/v1/session/"+createResp.ID+"?include=events"), nil, &resp)
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("get session with events: status %d, err %v", status, err)
+		}
+		got = got[:0]
+		for _, entry := range resp.Events {
+			if entry.Time == "" {
+				t.Fatalf("history entry without time: %+v", entry)
+			}
+			got = append(got, entry.Type)
+		}
+		if len(got) == len(expected) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected history %v, got %v", expected, got)
+	}
+
+	plain, status, err := getSession(t, client, instance.BaseURL, createResp.ID)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("get session: status %d, err %v", status, err)
+	}
+	if plain.Events != nil {
+		t.Fatalf("expected no events without include, got %v", plain.Events)
+	}
+}
+
 // TestIntegrationB1IdleAutoDelete validates idle cleanup by ensuring a session
 // with no traffic is removed after IDLE_TIMEOUT_SEC. Topology would normally use
 // A-leg/B-leg ports, but we intentionally send no PCAP/SSRCs to keep counters at
@@ -458,7 +589,7 @@ func TestIntegrationB2ActiveSessionNotDeleted(t *testing.T) {
 			AudioPort: freeUDPPort(t),
 			VideoPort: freeUDPPort(t),
 			AudioTo:   fmt.Sprintf("127.0.0.1:%d", createResp.Audio.APort),
-			VideoTo:   fmt.Sprintf("127.0.0.1:%d", createResp.Video.APort),
+			VideoTo:   fmt.Sprintf("127.0.0.1:%d", freeUDPPort(t)),
 			AudioSSRC: normalAudioSSRC,
 			VideoSSRC: normalVideoSSRC,
 			SendPCAP:  filepath.Join(repoRoot(t), "testdata", "normal.pcap"),
@@ -496,11 +627,13 @@ func TestIntegrationB2ActiveSessionNotDeleted(t *testing.T) {
 // TestIntegrationC1AudioOnlyProxy validates audio-only proxying. Topology: rtppeer
 // sender replays testdata/normal.pcap (audio SSRC 0xedcc15a7 and video SSRC
 // 0x259989ef), but we route the video leg to an unused UDP sink so rtp-cleaner
-// only sees audio on its A-leg. rtp-cleaner is configured with audio enabled and
-// video disabled (no video dest update), so only audio should be forwarded to the
-// B-leg receiver. We capture recv.pcap and list sources to ensure only the audio
-// SSRC appears. Counters: audio_a_in_pkts/audio_b_out_pkts should increase, while
-// all video counters remain zero because no video packets reach the cleaner. Env
+// only sees audio on its A-leg. The session is created with video.enable=false, so
+// the create response reports zero video ports, GET reports video disabled with
+// reason not_requested, and a video dest update is rejected with 400
+// media_not_enabled. Only audio should be forwarded to the B-leg receiver. We
+// capture recv.pcap and list sources to ensure only the audio SSRC appears.
+// Counters: audio_a_in_pkts/audio_b_out_pkts should increase, while all video
+// counters remain zero because no video packets reach the cleaner. Env
 // used: PUBLIC_IP/INTERNAL_IP=127.0.0.1, PEER_LEARNING_WINDOW_SEC=1,
 // IDLE_TIMEOUT_SEC=10, MAX_FRAME_WAIT_MS=150, RTP_PORT_MIN/MAX. Flake avoidance:
 // poll API counters for audio activity instead of sleeping and assert video
@@ -524,6 +657,9 @@ func TestIntegrationC1AudioOnlyProxy(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create session: %v", err)
 	}
+	if createResp.Video.APort != 0 || createResp.Video.BPort != 0 {
+		t.Fatalf("create session: expected zero video ports, got %+v", createResp.Video)
+	}
 
 	recvPort := freeUDPPort(t)
 	recvVideoPort := freeUDPPort(t)
@@ -550,6 +686,18 @@ func TestIntegrationC1AudioOnlyProxy(t *testing.T) {
 		t.Fatalf("update session audio: expected 200, got %d", status)
 	}
 
+	videoDest := fmt.Sprintf("127.0.0.1:%d", freeUDPPort(t))
+	var rejected errorResponse
+	status, err = doJSONRequest(client, http.MethodPost, withAccessToken(instance.BaseURL+"/v1/session/"+createResp.ID+"/update"), updateSessionRequest{
+		Video: &updateMediaRequest{RTPEngineDest: &videoDest},
+	}, &rejected)
+	if err != nil {
+		t.Fatalf("update session video: %v", err)
+	}
+	if status != http.StatusBadRequest || rejected.Code != "media_not_enabled" {
+		t.Fatalf("update session video: expected 400 media_not_enabled, got %d %q", status, rejected.Code)
+	}
+
 	videoSinkPort := freeUDPPort(t)
 	sendErr := rtpPeerSendPCAP(t, rtpPeerSendConfig{
 		AudioPort: freeUDPPort(t),
@@ -575,6 +723,13 @@ func TestIntegrationC1AudioOnlyProxy(t *testing.T) {
 	if finalState.VideoAInPkts != 0 || finalState.VideoBOutPkts != 0 || finalState.VideoBInPkts != 0 || finalState.VideoAOutPkts != 0 {
 		t.Fatalf("expected video counters to remain zero, got %+v", finalState)
 	}
+	gotSession, status, err := getSession(t, client, instance.BaseURL, createResp.ID)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("get session: status %d, err %v", status, err)
+	}
+	if gotSession.Video.Enabled || gotSession.Video.DisabledReason != "not_requested" || gotSession.Video.APort != 0 {
+		t.Fatalf("expected video disabled as not_requested, got %+v", gotSession.Video)
+	}
 
 	if err := <-recvErr; err != nil {
 		t.Fatalf("rtppeer recv: %v", err)
@@ -592,6 +747,100 @@ func TestIntegrationC1AudioOnlyProxy(t *testing.T) {
 	}
 }
 
+// TestIntegrationC2RTCPForwarding validates that RTCP is relayed on the port
+// after each RTP leg. Topology: rtppeer replays the audio of
+// testdata/normal.pcap to the audio A port with --rtcp, so it sends one sender
+// report from its audio port+1 to A port+1; the test plays rtpengine with an
+// RTP/RTCP socket pair set as the audio destination. The sender report must
+// arrive unchanged on the rtpengine RTCP socket, and a receiver report sent
+// back to B port+1 must be relayed to the learned doorphone RTCP source.
+// Counters: audio_rtcp_a_in_pkts and audio_rtcp_b_in_pkts count one report
+// each way and audio_rtcp_drops stays zero, while audio_a_in_pkts counts RTP
+// only. Env used: baseEnv with IDLE_TIMEOUT_SEC=10. Flake avoidance: all
+// port pairs are probed before use and counters are polled instead of
+// sleeping.
+func TestIntegrationC2RTCPForwarding(t *testing.T) {
+	instance, cleanup := startRtpCleaner(t, baseEnv("10"))
+	t.Cleanup(cleanup)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	if err := waitForHealth(instance.BaseURL, 2*time.Second); err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+
+	var createReq createSessionRequest
+	createReq.CallID = "call-c2"
+	createReq.FromTag = "from-c2"
+	createReq.ToTag = "to-c2"
+	createReq.Audio.Enable = true
+	createResp, err := createSession(t, client, instance.BaseURL, createReq)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	engineRTP, engineRTCP := listenUDPPair(t)
+	defer engineRTP.Close()
+	defer engineRTCP.Close()
+	audioDest := engineRTP.LocalAddr().String()
+	if _, status, err := updateSession(t, client, instance.BaseURL, createResp.ID, updateSessionRequest{
+		Audio: &updateMediaRequest{RTPEngineDest: &audioDest},
+	}); err != nil || status != http.StatusOK {
+		t.Fatalf("update session audio: status %d, err %v", status, err)
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- rtpPeerSendPCAP(t, rtpPeerSendConfig{
+			AudioPort: freeUDPPortPair(t),
+			VideoPort: freeUDPPortPair(t),
+			AudioTo:   fmt.Sprintf("127.0.0.1:%d", createResp.Audio.APort),
+			VideoTo:   fmt.Sprintf("127.0.0.1:%d", freeUDPPortPair(t)),
+			AudioSSRC: normalAudioSSRC,
+			VideoSSRC: normalVideoSSRC,
+			SendPCAP:  filepath.Join(repoRoot(t), "testdata", "normal.pcap"),
+			Duration:  3 * time.Second,
+			Timeout:   10 * time.Second,
+			RTCP:      true,
+		})
+	}()
+
+	buffer := make([]byte, 2048)
+	_ = engineRTCP.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := engineRTCP.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("read sender report: %v", err)
+	}
+	if n != 28 || buffer[1] != 200 || binary.BigEndian.Uint32(buffer[4:8]) != normalAudioSSRC {
+		t.Fatalf("expected an audio sender report, got % x", buffer[:n])
+	}
+
+	receiverReport := []byte{0x80, 201, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04}
+	bRTCP := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: createResp.Audio.BPort + 1}
+	if _, err := engineRTCP.WriteToUDP(receiverReport, bRTCP); err != nil {
+		t.Fatalf("send receiver report: %v", err)
+	}
+
+	state, err := waitForSessionCondition(t, client, instance.BaseURL, createResp.ID, 3*time.Second, func(resp sessionStateResponse) bool {
+		return resp.AudioRTCPAInPkts == 1 && resp.AudioRTCPBInPkts == 1
+	})
+	if err != nil {
+		t.Fatalf("wait for rtcp counters: %v", err)
+	}
+	if state.AudioRTCPDrops != 0 {
+		t.Fatalf("expected no rtcp drops, got %+v", state)
+	}
+	if err := <-sendErr; err != nil {
+		t.Fatalf("rtppeer send: %v", err)
+	}
+	final, _, err := getSession(t, client, instance.BaseURL, createResp.ID)
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	if final.AudioAInPkts == 0 || final.AudioRTCPAInPkts != 1 {
+		t.Fatalf("expected rtp and rtcp to be counted apart, got %+v", final)
+	}
+}
+
 func stringPtr(value string) *string {
 	return &value
 }
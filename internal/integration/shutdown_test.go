@@ -0,0 +1,76 @@
+package integration_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestIntegrationShutdownOnSIGTERM validates the graceful shutdown path. A
+// single audio+video session is created (control plane only, no PCAP), then
+// the process receives SIGTERM. We assert that it exits with code 0 within the
+// configured SHUTDOWN_GRACE_SEC plus a small margin, and that both proxies of
+// the session logged their final stats line, which only happens when sessions
+// are stopped explicitly instead of being torn down by the OS. Env used:
+// baseEnv with IDLE_TIMEOUT_SEC=10 and SHUTDOWN_GRACE_SEC=2. We avoid flakes
+// by waiting on the process instead of sleeping and by parsing the JSON log
+// output after exit, when the output buffer is no longer written.
+func TestIntegrationShutdownOnSIGTERM(t *testing.T) {
+	env := baseEnv("10")
+	env["SHUTDOWN_GRACE_SEC"] = "2"
+	instance, cleanup := startRtpCleaner(t, env)
+	t.Cleanup(cleanup)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	var createReq createSessionRequest
+	createReq.CallID = "call-shutdown"
+	createReq.FromTag = "from-shutdown"
+	createReq.ToTag = "to-shutdown"
+	createReq.Audio.Enable = true
+	createReq.Video.Enable = true
+	createResp, err := createSession(t, client, instance.BaseURL, createReq)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+
+	if err := instance.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("send SIGTERM: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- instance.cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected clean exit, got %v\n%s", err, instance.output.String())
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatalf("rtp-cleaner did not exit within grace period\n%s", instance.output.String())
+	}
+
+	finalStats := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(instance.output.String()))
+	for scanner.Scan() {
+		var entry struct {
+			Msg       string `json:"msg"`
+			SessionID string `json:"session_id"`
+			Final     bool   `json:"final"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if entry.SessionID == createResp.ID && entry.Final {
+			finalStats[entry.Msg] = true
+		}
+	}
+	for _, msg := range []string{"audio.proxy.stats", "video.proxy.stats"} {
+		if !finalStats[msg] {
+			t.Fatalf("expected final %s for session %s\n%s", msg, createResp.ID, instance.output.String())
+		}
+	}
+}
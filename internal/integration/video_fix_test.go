@@ -22,7 +22,10 @@ type videoFixRun struct {
 	finalState sessionStateResponse
 }
 
-const maxVideoFixPacketsRaw = 400
+const (
+	maxVideoFixPacketsRaw = 400
+	videoFixMaxFrameWait  = 150 * time.Millisecond
+)
 
 type videoFixOptions struct {
 	pacing       string
@@ -46,7 +49,7 @@ func videoFixEnv() map[string]string {
 	env["PUBLIC_IP"] = "127.0.0.1"
 	env["INTERNAL_IP"] = "127.0.0.1"
 	env["PEER_LEARNING_WINDOW_SEC"] = "1"
-	env["MAX_FRAME_WAIT_MS"] = "150"
+	env["MAX_FRAME_WAIT_MS"] = fmt.Sprint(videoFixMaxFrameWait.Milliseconds())
 	env["IDLE_TIMEOUT_SEC"] = "10"
 	env["RTP_PORT_MIN"] = "35000"
 	env["RTP_PORT_MAX"] = "35050"
@@ -68,6 +71,16 @@ func findSourceStats(t *testing.T, stats []rtpPeerSourceStats, ssrc uint32) rtpP
 	return rtpPeerSourceStats{}
 }
 
+// assertNoDrops checks that a healthy run neither dropped nor ignored packets
+// on either media.
+func assertNoDrops(t *testing.T, state sessionStateResponse) {
+	t.Helper()
+	if state.AudioDrops != 0 || state.AudioIgnoredDisabled != 0 || state.VideoDrops != 0 || state.VideoIgnoredDisabled != 0 {
+		t.Fatalf("expected no drops on the happy path, got audio_drops=%d audio_ignored_disabled=%d video_drops=%d video_ignored_disabled=%d",
+			state.AudioDrops, state.AudioIgnoredDisabled, state.VideoDrops, state.VideoIgnoredDisabled)
+	}
+}
+
 func trimPCAP(t *testing.T, sourcePath string, maxPackets int) string {
 	t.Helper()
 	reader, err := pcapio.OpenReader(sourcePath)
@@ -208,6 +221,47 @@ func trimPCAPWithGap(t *testing.T, sourcePath string, maxPackets int, gap time.D
 	return destPath
 }
 
+// longestArrivalGap returns the longest time between two consecutive RTP
+// packets of ssrc in a pcap written by rtppeer recv, whose timestamps are
+// arrival times.
+func longestArrivalGap(t *testing.T, pcapPath string, ssrc uint32) time.Duration {
+	t.Helper()
+	reader, err := pcapio.OpenReader(pcapPath)
+	if err != nil {
+		t.Fatalf("open pcap reader: %v", err)
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			t.Fatalf("close pcap reader: %v", closeErr)
+		}
+	}()
+
+	var longest time.Duration
+	var last time.Time
+	for {
+		packet, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("read pcap packet: %v", err)
+		}
+		payload, ok := rtpPayloadFromFrame(packet.Data, reader.LinkType())
+		if !ok {
+			continue
+		}
+		header, ok := rtpfix.ParseRTPHeader(payload)
+		if !ok || header.SSRC != ssrc {
+			continue
+		}
+		if !last.IsZero() && packet.Timestamp.Sub(last) > longest {
+			longest = packet.Timestamp.Sub(last)
+		}
+		last = packet.Timestamp
+	}
+	return longest
+}
+
 func frameStartEndForSSRC(packet []byte, ssrc uint32, linkType uint32) (bool, bool) {
 	payload, ok := rtpPayloadFromFrame(packet, linkType)
 	if !ok {
@@ -231,46 +285,8 @@ func frameStartEndForSSRC(packet []byte, ssrc uint32, linkType uint32) (bool, bo
 }
 
 func rtpPayloadFromFrame(packet []byte, linkType uint32) ([]byte, bool) {
-	var ipOffset int
-	switch linkType {
-	case 1:
-		if len(packet) < 14+20+8 {
-			return nil, false
-		}
-		ethType := binary.BigEndian.Uint16(packet[12:14])
-		if ethType != 0x0800 {
-			return nil, false
-		}
-		ipOffset = 14
-	case 113:
-		if len(packet) < 16+20+8 {
-			return nil, false
-		}
-		proto := binary.BigEndian.Uint16(packet[14:16])
-		if proto != 0x0800 {
-			return nil, false
-		}
-		ipOffset = 16
-	default:
-		return nil, false
-	}
-	ipHeader := packet[ipOffset:]
-	if len(ipHeader) < 20 {
-		return nil, false
-	}
-	ihl := int(ipHeader[0]&0x0f) * 4
-	if len(ipHeader) < ihl+8 {
-		return nil, false
-	}
-	if ipHeader[9] != 17 {
-		return nil, false
-	}
-	udpStart := ipOffset + ihl
-	payloadStart := udpStart + 8
-	if payloadStart > len(packet) {
-		return nil, false
-	}
-	return packet[payloadStart:], true
+	payload, err := pcapio.ExtractUDPPayload(packet, linkType)
+	return payload, err == nil
 }
 
 func runVideoFixScenario(
@@ -421,6 +437,7 @@ func TestIntegrationD1VideoFixRawNormal(t *testing.T) {
 	if run.finalState.VideoSeqDeltaCurrent != 0 {
 		t.Fatalf("expected raw mode to keep seq delta at zero, got %d", run.finalState.VideoSeqDeltaCurrent)
 	}
+	assertNoDrops(t, run.finalState)
 
 	outputStats, err := rtpPeerListSources(t, run.recvPCAP)
 	if err != nil {
@@ -483,6 +500,7 @@ func TestIntegrationD2VideoFixRawProblem(t *testing.T) {
 	if run.finalState.VideoSeqDeltaCurrent != 0 {
 		t.Fatalf("expected raw mode to keep seq delta at zero, got %d", run.finalState.VideoSeqDeltaCurrent)
 	}
+	assertNoDrops(t, run.finalState)
 
 	outputStats, err := rtpPeerListSources(t, run.recvPCAP)
 	if err != nil {
@@ -538,10 +556,15 @@ func TestIntegrationE1VideoFixNormal(t *testing.T) {
 // mode tests we also use rtppeer --list-sources to assert no mutation by comparing
 // output and input packet/SPS/PPS/IDR/Non-IDR counts. Flake control: bounded
 // rtppeer send/recv durations, deadline-based polling, and localhost-only ports
-// avoid unbounded sleeps and reduce timing variance.
+// avoid unbounded sleeps and reduce timing variance. The doorphone also pauses
+// for a second right after a frame start; the incomplete frame must reach the
+// receiver about maxFrameWait into that pause instead of with the first packet
+// after it, so the longest silence seen on the receiver's video is the pause
+// shortened by about maxFrameWait.
 func TestIntegrationE2VideoFixProblem(t *testing.T) {
 	pcapPath := filepath.Join(repoRoot(t), "testdata", "problem.pcap")
-	trimmedPCAP := trimPCAPWithGap(t, pcapPath, maxVideoFixPacketsRaw, 200*time.Millisecond, problemVideoSSRC)
+	pause := time.Second
+	trimmedPCAP := trimPCAPWithGap(t, pcapPath, maxVideoFixPacketsRaw, pause, problemVideoSSRC)
 	run := runVideoFixScenario(t, true, trimmedPCAP, problemAudioSSRC, problemVideoSSRC, defaultVideoFixOptions(), func(resp sessionStateResponse) bool {
 		return resp.VideoAInPkts > 0 && resp.VideoBOutPkts > 0 && resp.VideoFramesFlushed > 0
 	})
@@ -565,4 +588,10 @@ func TestIntegrationE2VideoFixProblem(t *testing.T) {
 	if finalState.VideoFramesFlushed <= finalState.VideoFramesEnded {
 		t.Logf("frames flushed (%d) did not exceed frames ended (%d), which is acceptable but unexpected", finalState.VideoFramesFlushed, finalState.VideoFramesEnded)
 	}
+	// The stalled frame reaches the receiver before the pause ends, so what
+	// the receiver sees of the pause is shorter by the flush delay.
+	silence := longestArrivalGap(t, run.recvPCAP, problemVideoSSRC)
+	if flushDelay := pause - silence; flushDelay < videoFixMaxFrameWait-50*time.Millisecond || flushDelay > videoFixMaxFrameWait+100*time.Millisecond {
+		t.Fatalf("expected the stalled frame flushed about %s into the %s pause, but the receiver saw %s of silence", videoFixMaxFrameWait, pause, silence)
+	}
 }
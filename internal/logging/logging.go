@@ -1,10 +1,13 @@
 package logging
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -60,6 +63,84 @@ func initLoggerLocked(cfg Config) {
 	slog.SetDefault(logger)
 }
 
+// ParseLevel parses a level name as accepted by LOG_LEVEL, rejecting unknown
+// names instead of falling back to info.
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+}
+
+// LevelName returns the lowercase name used by LOG_LEVEL.
+func LevelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
+
+// LevelOverride replaces the global level for the loggers wrapped with
+// WithLevelOverride. Changes apply to those loggers immediately.
+type LevelOverride struct {
+	level slog.LevelVar
+	set   atomic.Bool
+}
+
+// Set makes the wrapped loggers log at level regardless of LOG_LEVEL.
+func (o *LevelOverride) Set(level slog.Level) {
+	o.level.Set(level)
+	o.set.Store(true)
+}
+
+// Clear returns the wrapped loggers to the global level.
+func (o *LevelOverride) Clear() {
+	o.set.Store(false)
+}
+
+// Level returns the override and whether one is set.
+func (o *LevelOverride) Level() (slog.Level, bool) {
+	if !o.set.Load() {
+		return 0, false
+	}
+	return o.level.Level(), true
+}
+
+// WithLevelOverride returns a logger that consults override before the level
+// of the underlying handler.
+func WithLevelOverride(logger *slog.Logger, override *LevelOverride) *slog.Logger {
+	return slog.New(&overrideHandler{inner: logger.Handler(), override: override})
+}
+
+type overrideHandler struct {
+	inner    slog.Handler
+	override *LevelOverride
+}
+
+func (h *overrideHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if override, ok := h.override.Level(); ok {
+		return level >= override
+	}
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *overrideHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *overrideHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &overrideHandler{inner: h.inner.WithAttrs(attrs), override: h.override}
+}
+
+func (h *overrideHandler) WithGroup(name string) slog.Handler {
+	return &overrideHandler{inner: h.inner.WithGroup(name), override: h.override}
+}
+
 func parseLevel(value string) slog.Level {
 	switch strings.ToLower(strings.TrimSpace(value)) {
 	case "debug":
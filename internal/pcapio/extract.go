@@ -0,0 +1,127 @@
+package pcapio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ExtractUDPPayload returns a copy of the UDP payload of a captured frame of
+// the given link type: Ethernet (1), Linux cooked capture (113) or its v2
+// (276), optionally 802.1Q tagged, carrying IPv4 or IPv6. IPv6 extension
+// headers ahead of UDP are skipped. Fragments past the first fail.
+func ExtractUDPPayload(frame []byte, linkType uint32) ([]byte, error) {
+	var etherType uint16
+	offset := 0
+	switch linkType {
+	case 1:
+		if len(frame) < 14 {
+			return nil, fmt.Errorf("frame too short")
+		}
+		etherType = binary.BigEndian.Uint16(frame[12:14])
+		offset = 14
+	case 113:
+		if len(frame) < 16 {
+			return nil, fmt.Errorf("frame too short")
+		}
+		etherType = binary.BigEndian.Uint16(frame[14:16])
+		offset = 16
+	case 276:
+		if len(frame) < 20 {
+			return nil, fmt.Errorf("frame too short")
+		}
+		etherType = binary.BigEndian.Uint16(frame[0:2])
+		offset = 20
+	default:
+		return nil, fmt.Errorf("unsupported linktype: %d", linkType)
+	}
+	if etherType == 0x8100 {
+		if len(frame) < offset+4 {
+			return nil, fmt.Errorf("frame too short for vlan")
+		}
+		etherType = binary.BigEndian.Uint16(frame[offset+2 : offset+4])
+		offset += 4
+	}
+	var udpStart int
+	var err error
+	switch etherType {
+	case 0x0800:
+		udpStart, err = ipv4UDPStart(frame, offset)
+	case 0x86dd:
+		udpStart, err = ipv6UDPStart(frame, offset)
+	default:
+		return nil, fmt.Errorf("unsupported ethertype: 0x%x", etherType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < udpStart+8 {
+		return nil, fmt.Errorf("udp header truncated")
+	}
+	udpLen := int(binary.BigEndian.Uint16(frame[udpStart+4 : udpStart+6]))
+	if udpLen < 8 {
+		return nil, fmt.Errorf("invalid udp length")
+	}
+	payloadLen := udpLen - 8
+	if len(frame) < udpStart+8+payloadLen {
+		return nil, fmt.Errorf("udp payload truncated")
+	}
+	payload := make([]byte, payloadLen)
+	copy(payload, frame[udpStart+8:udpStart+8+payloadLen])
+	return payload, nil
+}
+
+// ipv4UDPStart returns where the UDP header starts after the IPv4 header at
+// offset.
+func ipv4UDPStart(frame []byte, offset int) (int, error) {
+	if len(frame) < offset+20 {
+		return 0, fmt.Errorf("ipv4 header truncated")
+	}
+	ihl := int(frame[offset] & 0x0f)
+	if ihl < 5 {
+		return 0, fmt.Errorf("invalid ihl")
+	}
+	ipHeaderLen := ihl * 4
+	if len(frame) < offset+ipHeaderLen {
+		return 0, fmt.Errorf("ipv4 header truncated")
+	}
+	if frame[offset+9] != 17 {
+		return 0, fmt.Errorf("not udp")
+	}
+	frag := binary.BigEndian.Uint16(frame[offset+6 : offset+8])
+	if frag&0x1fff != 0 {
+		return 0, fmt.Errorf("fragmented packet")
+	}
+	return offset + ipHeaderLen, nil
+}
+
+// ipv6UDPStart returns where the UDP header starts after the IPv6 header at
+// offset and the extension headers chained to it (RFC 8200 4).
+func ipv6UDPStart(frame []byte, offset int) (int, error) {
+	if len(frame) < offset+40 {
+		return 0, fmt.Errorf("ipv6 header truncated")
+	}
+	next := frame[offset+6]
+	pos := offset + 40
+	for next != 17 {
+		if len(frame) < pos+8 {
+			return 0, fmt.Errorf("ipv6 extension header truncated")
+		}
+		var size int
+		switch next {
+		case 0, 43, 60: // hop-by-hop, routing, destination options
+			size = (int(frame[pos+1]) + 1) * 8
+		case 44: // fragment
+			if binary.BigEndian.Uint16(frame[pos+2:pos+4])&0xfff8 != 0 {
+				return 0, fmt.Errorf("fragmented packet")
+			}
+			size = 8
+		case 51: // authentication header
+			size = (int(frame[pos+1]) + 2) * 4
+		default:
+			return 0, fmt.Errorf("not udp")
+		}
+		next = frame[pos]
+		pos += size
+	}
+	return pos, nil
+}
@@ -0,0 +1,185 @@
+package pcapio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// udpChecksumValid reports whether the UDP checksum of frame, an Ethernet
+// frame built by the writer, verifies over the pseudo-header of its IP
+// version.
+func udpChecksumValid(frame []byte) bool {
+	var sum uint32
+	if binary.BigEndian.Uint16(frame[12:14]) == 0x86dd {
+		udp := frame[54:]
+		sum = addWords(sum, frame[22:54])
+		sum += uint32(len(udp)) + 17
+		sum = addWords(sum, udp)
+	} else {
+		udp := frame[34:]
+		sum = addWords(sum, frame[26:34])
+		sum += uint32(len(udp)) + 17
+		sum = addWords(sum, udp)
+	}
+	return foldSum(sum) == 0xffff
+}
+
+// TestWriter_IPv6RoundTrip verifies that packets of an IPv6 session are
+// written over IPv6 and read back. This matters because recording a v6
+// session wrote made-up 192.0.2.x addresses, and replaying a v6 capture
+// failed. Inputs: a packet from 2001:db8::10 to 2001:db8::20, one from an
+// IPv4 address to an IPv6 one, and one between IPv4 addresses, written and
+// read back with ExtractUDPPayload. The expected output is ethertype 0x86dd
+// with the addresses in the IPv6 header, the IPv4 one mapped, for the first
+// two and 0x0800 for the last, every payload back byte for byte, and UDP
+// checksums that verify over the pseudo-header of each IP version.
+func TestWriter_IPv6RoundTrip(t *testing.T) {
+	cases := []struct {
+		name      string
+		src, dst  net.IP
+		etherType uint16
+	}{
+		{"ipv6", net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20"), 0x86dd},
+		{"mixed", net.IPv4(192, 0, 2, 10), net.ParseIP("2001:db8::20"), 0x86dd},
+		{"ipv4", net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 20), 0x0800},
+	}
+	payload := []byte{0x80, 0x60, 0x00, 0x01, 0x00, 0x00, 0x0b, 0xb8, 0x00, 0x00, 0xbe, 0xef, 0x41}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "capture.pcap")
+			writer, err := NewWriter(path)
+			if err != nil {
+				t.Fatalf("create pcap: %v", err)
+			}
+			if err := writer.WritePacket(time.Unix(1700000000, 0), tc.src, tc.dst, 4000, 5000, payload); err != nil {
+				t.Fatalf("write packet: %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("close pcap: %v", err)
+			}
+			reader, err := OpenReader(path)
+			if err != nil {
+				t.Fatalf("open pcap: %v", err)
+			}
+			defer reader.Close()
+			packet, err := reader.Next()
+			if err != nil {
+				t.Fatalf("read packet: %v", err)
+			}
+			frame := packet.Data
+			if etherType := binary.BigEndian.Uint16(frame[12:14]); etherType != tc.etherType {
+				t.Fatalf("expected ethertype %#x, got %#x", tc.etherType, etherType)
+			}
+			if tc.etherType == 0x86dd {
+				if !net.IP(frame[22:38]).Equal(tc.src) || !net.IP(frame[38:54]).Equal(tc.dst) {
+					t.Fatalf("expected %s > %s, got %s > %s", tc.src, tc.dst, net.IP(frame[22:38]), net.IP(frame[38:54]))
+				}
+			}
+			got, err := ExtractUDPPayload(frame, reader.LinkType())
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("expected payload % x, got % x and %v", payload, got, err)
+			}
+			if !udpChecksumValid(frame) {
+				t.Fatalf("expected the UDP checksum of % x to verify", frame)
+			}
+		})
+	}
+}
+
+// ipv6Frame returns an Ethernet frame, optionally 802.1Q tagged, of an IPv6
+// packet from the writer with ext, extension headers ending with UDP as next
+// header, inserted after the IPv6 header, whose next header becomes first.
+func ipv6Frame(t *testing.T, vlan bool, first byte, ext []byte, payload []byte) []byte {
+	t.Helper()
+	frame, err := buildEthernetUDP(net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20"), 4000, 5000, payload)
+	if err != nil {
+		t.Fatalf("build frame: %v", err)
+	}
+	if len(ext) > 0 {
+		frame[20] = first
+		frame = append(frame[:54:54], append(append([]byte(nil), ext...), frame[54:]...)...)
+	}
+	if vlan {
+		tagged := append([]byte(nil), frame[:12]...)
+		tagged = append(tagged, 0x81, 0x00, 0x00, 0x64)
+		frame = append(tagged, frame[12:]...)
+	}
+	return frame
+}
+
+// TestExtractUDPPayload_IPv6 verifies the IPv6 parsing of the extractor.
+// Inputs: IPv6 frames with no extension header; with a hop-by-hop header
+// followed by destination options; with a first fragment header; 802.1Q
+// tagged; with a later fragment; with an extension header cut short; and
+// carrying TCP (next header 6). The expected output is the UDP payload for
+// the first four and an error for the others.
+func TestExtractUDPPayload_IPv6(t *testing.T) {
+	payload := []byte{0x80, 0x60, 0x00, 0x01}
+	hopByHop := []byte{60, 0, 1, 4, 0, 0, 0, 0}
+	destOpts := []byte{17, 1, 1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	firstFragment := []byte{17, 0, 0x00, 0x01, 0, 0, 0, 7}
+	laterFragment := []byte{17, 0, 0x05, 0x00, 0, 0, 0, 7}
+
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"plain", ipv6Frame(t, false, 0, nil, payload)},
+		{"hop-by-hop and destination options", ipv6Frame(t, false, 0, append(append([]byte(nil), hopByHop...), destOpts...), payload)},
+		{"first fragment", ipv6Frame(t, false, 44, firstFragment, payload)},
+		{"vlan", ipv6Frame(t, true, 0, nil, payload)},
+	} {
+		got, err := ExtractUDPPayload(tc.frame, 1)
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("%s: expected payload % x, got % x and %v", tc.name, payload, got, err)
+		}
+	}
+
+	truncated := ipv6Frame(t, false, 0, nil, payload)[:58]
+	truncated[20] = 60
+	tcp := ipv6Frame(t, false, 0, nil, payload)
+	tcp[20] = 6
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+	}{
+		{"later fragment", ipv6Frame(t, false, 44, laterFragment, payload)},
+		{"truncated extension", truncated},
+		{"tcp", tcp},
+	} {
+		if got, err := ExtractUDPPayload(tc.frame, 1); err == nil {
+			t.Fatalf("%s: expected an error, got % x", tc.name, got)
+		}
+	}
+}
+
+// TestExtractUDPPayload_LinkTypes verifies that the Linux cooked capture
+// link types still carry IPv4 and now IPv6. Inputs: the writer's IPv4 and
+// IPv6 frames with their Ethernet header replaced by an SLL (113) and an
+// SLL2 (276) header. The expected output is the UDP payload of each.
+func TestExtractUDPPayload_LinkTypes(t *testing.T) {
+	payload := []byte{0x80, 0x60, 0x00, 0x01}
+	for _, src := range []net.IP{net.IPv4(192, 0, 2, 10), net.ParseIP("2001:db8::10")} {
+		dst := net.IPv4(192, 0, 2, 20)
+		if src.To4() == nil {
+			dst = net.ParseIP("2001:db8::20")
+		}
+		frame, err := buildEthernetUDP(src, dst, 4000, 5000, payload)
+		if err != nil {
+			t.Fatalf("build frame: %v", err)
+		}
+		etherType, ip := frame[12:14], frame[14:]
+		sll := append(append(make([]byte, 14), etherType...), ip...)
+		sll2 := append(append(append([]byte(nil), etherType...), make([]byte, 18)...), ip...)
+		for linkType, frame := range map[uint32][]byte{113: sll, 276: sll2} {
+			got, err := ExtractUDPPayload(frame, linkType)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("%s over link type %d: expected payload % x, got % x and %v", src, linkType, payload, got, err)
+			}
+		}
+	}
+}
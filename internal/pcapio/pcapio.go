@@ -14,9 +14,13 @@ import (
 const (
 	pcapMagicLittle = 0xa1b2c3d4
 	pcapMagicBig    = 0xd4c3b2a1
-	pcapNgMagic     = 0x0a0d0d0a
-	linkTypeEther   = 1
-	defaultSnap     = 65535
+	// The nanosecond magics of tcpdump --time-stamp-precision=nano, whose
+	// records carry nanoseconds instead of microseconds.
+	pcapMagicNanoLittle = 0xa1b23c4d
+	pcapMagicNanoBig    = 0x4d3cb2a1
+	pcapNgMagic         = 0x0a0d0d0a
+	linkTypeEther       = 1
+	defaultSnap         = 65535
 )
 
 // Packet represents a captured packet.
@@ -30,6 +34,7 @@ type Reader struct {
 	file       *os.File
 	linkType   uint32
 	byteOrder  binary.ByteOrder
+	tsRes      time.Duration
 	isPcapng   bool
 	ngIfaces   map[uint32]ngInterface
 	ngSection  *ngSection
@@ -64,12 +69,16 @@ func OpenReader(path string) (*Reader, error) {
 			return nil, fmt.Errorf("seek pcapng: %w", err)
 		}
 		return &Reader{file: file, isPcapng: true, ngIfaces: make(map[uint32]ngInterface)}, nil
-	case pcapMagicLittle, pcapMagicBig:
+	case pcapMagicLittle, pcapMagicBig, pcapMagicNanoLittle, pcapMagicNanoBig:
 		var bo binary.ByteOrder = binary.BigEndian
-		if magic == pcapMagicBig {
+		if magic == pcapMagicBig || magic == pcapMagicNanoBig {
 			bo = binary.LittleEndian
 		}
-		reader := &Reader{file: file, byteOrder: bo}
+		tsRes := time.Microsecond
+		if magic == pcapMagicNanoLittle || magic == pcapMagicNanoBig {
+			tsRes = time.Nanosecond
+		}
+		reader := &Reader{file: file, byteOrder: bo, tsRes: tsRes}
 		if err := reader.readPcapHeader(); err != nil {
 			_ = file.Close()
 			return nil, err
@@ -120,7 +129,7 @@ func (r *Reader) nextPcap() (Packet, error) {
 		return Packet{}, fmt.Errorf("read pcap record header: %w", err)
 	}
 	tsSec := r.byteOrder.Uint32(hdr[0:4])
-	tsUsec := r.byteOrder.Uint32(hdr[4:8])
+	tsFrac := r.byteOrder.Uint32(hdr[4:8])
 	inclLen := r.byteOrder.Uint32(hdr[8:12])
 	data := make([]byte, inclLen)
 	if _, err := io.ReadFull(r.file, data); err != nil {
@@ -129,7 +138,7 @@ func (r *Reader) nextPcap() (Packet, error) {
 		}
 		return Packet{}, fmt.Errorf("read pcap record data: %w", err)
 	}
-	ts := time.Unix(int64(tsSec), int64(tsUsec)*1000)
+	ts := time.Unix(int64(tsSec), int64(tsFrac)*int64(r.tsRes))
 	return Packet{Timestamp: ts, Data: data}, nil
 }
 
@@ -246,20 +255,32 @@ func parseNgOptions(data []byte, fn func(code uint16, value []byte)) {
 	}
 }
 
-// Writer writes packets into a pcap file with synthetic Ethernet/IPv4/UDP headers.
+// Writer writes packets into a pcap file with synthetic Ethernet/IPv4/UDP
+// headers, or Ethernet/IPv6/UDP when either address is IPv6.
 type Writer struct {
 	file   *os.File
+	nano   bool
 	mu     sync.Mutex
 	closed bool
 }
 
-// NewWriter creates a pcap writer.
+// NewWriter creates a pcap writer with microsecond timestamps.
 func NewWriter(path string) (*Writer, error) {
+	return newWriter(path, false)
+}
+
+// NewWriterNano creates a pcap writer with nanosecond timestamps, which keep
+// the order of packets sent back to back within a microsecond.
+func NewWriterNano(path string) (*Writer, error) {
+	return newWriter(path, true)
+}
+
+func newWriter(path string, nano bool) (*Writer, error) {
 	file, err := os.Create(path)
 	if err != nil {
 		return nil, fmt.Errorf("create pcap: %w", err)
 	}
-	writer := &Writer{file: file}
+	writer := &Writer{file: file, nano: nano}
 	if err := writer.writeHeader(); err != nil {
 		_ = file.Close()
 		return nil, err
@@ -269,7 +290,11 @@ func NewWriter(path string) (*Writer, error) {
 
 func (w *Writer) writeHeader() error {
 	header := make([]byte, 24)
-	binary.LittleEndian.PutUint32(header[0:4], pcapMagicLittle)
+	magic := uint32(pcapMagicLittle)
+	if w.nano {
+		magic = pcapMagicNanoLittle
+	}
+	binary.LittleEndian.PutUint32(header[0:4], magic)
 	binary.LittleEndian.PutUint16(header[4:6], 2)
 	binary.LittleEndian.PutUint16(header[6:8], 4)
 	binary.LittleEndian.PutUint32(header[8:12], 0)
@@ -301,15 +326,18 @@ func (w *Writer) WritePacket(ts time.Time, srcIP, dstIP net.IP, srcPort, dstPort
 	if w.closed {
 		return fmt.Errorf("pcap writer closed")
 	}
-	frame, err := buildEthernetIPv4UDP(srcIP, dstIP, srcPort, dstPort, payload)
+	frame, err := buildEthernetUDP(srcIP, dstIP, srcPort, dstPort, payload)
 	if err != nil {
 		return err
 	}
 	hdr := make([]byte, 16)
 	secs := uint32(ts.Unix())
-	usecs := uint32(ts.Nanosecond() / 1000)
+	frac := uint32(ts.Nanosecond() / 1000)
+	if w.nano {
+		frac = uint32(ts.Nanosecond())
+	}
 	binary.LittleEndian.PutUint32(hdr[0:4], secs)
-	binary.LittleEndian.PutUint32(hdr[4:8], usecs)
+	binary.LittleEndian.PutUint32(hdr[4:8], frac)
 	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(frame)))
 	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(frame)))
 	if _, err := w.file.Write(hdr); err != nil {
@@ -321,6 +349,19 @@ func (w *Writer) WritePacket(ts time.Time, srcIP, dstIP net.IP, srcPort, dstPort
 	return nil
 }
 
+// buildEthernetUDP frames payload over IPv6 when either address is IPv6, the
+// other then written as an IPv4-mapped address, and over IPv4 otherwise.
+func buildEthernetUDP(srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte) ([]byte, error) {
+	if isIPv6(srcIP) || isIPv6(dstIP) {
+		return buildEthernetIPv6UDP(srcIP, dstIP, srcPort, dstPort, payload)
+	}
+	return buildEthernetIPv4UDP(srcIP, dstIP, srcPort, dstPort, payload)
+}
+
+func isIPv6(ip net.IP) bool {
+	return len(ip) == net.IPv6len && ip.To4() == nil
+}
+
 func buildEthernetIPv4UDP(srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte) ([]byte, error) {
 	src4 := srcIP.To4()
 	dst4 := dstIP.To4()
@@ -344,54 +385,91 @@ func buildEthernetIPv4UDP(srcIP, dstIP net.IP, srcPort, dstPort int, payload []b
 	copy(ip[16:20], dst4)
 	binary.BigEndian.PutUint16(ip[10:12], checksum(ip))
 
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:8], ip[12:20])
+	pseudo[9] = 17
+	binary.BigEndian.PutUint16(pseudo[10:12], uint16(8+len(payload)))
+	return appendUDPFrame(eth, ip, pseudo, srcPort, dstPort, payload), nil
+}
+
+func buildEthernetIPv6UDP(srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte) ([]byte, error) {
+	src6 := srcIP.To16()
+	dst6 := dstIP.To16()
+	if src6 == nil {
+		src6 = net.ParseIP("2001:db8::1")
+	}
+	if dst6 == nil {
+		dst6 = net.ParseIP("2001:db8::2")
+	}
+	eth := make([]byte, 14)
+	copy(eth[0:6], []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02})
+	copy(eth[6:12], []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+	binary.BigEndian.PutUint16(eth[12:14], 0x86dd)
+
+	ip := make([]byte, 40)
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(8+len(payload)))
+	ip[6] = 17
+	ip[7] = 64
+	copy(ip[8:24], src6)
+	copy(ip[24:40], dst6)
+
+	// RFC 8200 8.1: addresses, upper-layer length and next header.
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:32], ip[8:40])
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(8+len(payload)))
+	pseudo[39] = 17
+	return appendUDPFrame(eth, ip, pseudo, srcPort, dstPort, payload), nil
+}
+
+// appendUDPFrame builds the UDP header, its checksum computed over pseudo,
+// the pseudo-header of the IP version, and returns the whole frame.
+func appendUDPFrame(eth, ip, pseudo []byte, srcPort, dstPort int, payload []byte) []byte {
 	udp := make([]byte, 8)
 	binary.BigEndian.PutUint16(udp[0:2], uint16(srcPort))
 	binary.BigEndian.PutUint16(udp[2:4], uint16(dstPort))
 	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
-	binary.BigEndian.PutUint16(udp[6:8], udpChecksum(ip, udp, payload))
+	binary.BigEndian.PutUint16(udp[6:8], udpChecksum(pseudo, udp, payload))
 
 	frame := make([]byte, 0, len(eth)+len(ip)+len(udp)+len(payload))
 	frame = append(frame, eth...)
 	frame = append(frame, ip...)
 	frame = append(frame, udp...)
 	frame = append(frame, payload...)
-	return frame, nil
+	return frame
 }
 
 func checksum(data []byte) uint16 {
-	var sum uint32
+	return ^foldSum(addWords(0, data))
+}
+
+// addWords adds data to sum as big-endian 16-bit words, a last odd byte
+// padded with zero.
+func addWords(sum uint32, data []byte) uint32 {
 	for i := 0; i+1 < len(data); i += 2 {
 		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
 	}
 	if len(data)%2 == 1 {
 		sum += uint32(data[len(data)-1]) << 8
 	}
-	for sum > 0xffff {
-		sum = (sum >> 16) + (sum & 0xffff)
-	}
-	return ^uint16(sum)
+	return sum
 }
 
-func udpChecksum(ipHeader []byte, udpHeader []byte, payload []byte) uint16 {
-	pseudo := make([]byte, 12)
-	copy(pseudo[0:4], ipHeader[12:16])
-	copy(pseudo[4:8], ipHeader[16:20])
-	pseudo[8] = 0
-	pseudo[9] = 17
-	binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(udpHeader)+len(payload)))
-
-	sum := uint32(0)
-	sum += uint32(checksum(pseudo))
-	udpCopy := make([]byte, len(udpHeader))
-	copy(udpCopy, udpHeader)
-	udpCopy[6] = 0
-	udpCopy[7] = 0
-	sum += uint32(checksum(udpCopy))
-	sum += uint32(checksum(payload))
+func foldSum(sum uint32) uint16 {
 	for sum > 0xffff {
 		sum = (sum >> 16) + (sum & 0xffff)
 	}
-	cs := ^uint16(sum)
+	return uint16(sum)
+}
+
+// udpChecksum is the one's complement of the sum of pseudo, the UDP header
+// with its checksum field taken as zero, and payload. A zero result is sent
+// as 0xffff, zero meaning no checksum.
+func udpChecksum(pseudo, udpHeader, payload []byte) uint16 {
+	sum := addWords(0, pseudo)
+	sum = addWords(sum, udpHeader[0:6])
+	sum = addWords(sum, payload)
+	cs := ^foldSum(sum)
 	if cs == 0 {
 		return 0xffff
 	}
@@ -0,0 +1,151 @@
+package pcapio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClassicPCAP writes a pcap file with the given magic, in the byte order
+// it implies, holding one Ethernet record of 4 bytes with the given seconds
+// and sub-second field.
+func writeClassicPCAP(t *testing.T, magic uint32, bo binary.ByteOrder, sec, frac uint32) string {
+	t.Helper()
+	var file bytes.Buffer
+	header := make([]byte, 24)
+	bo.PutUint32(header[0:4], magic)
+	bo.PutUint16(header[4:6], 2)
+	bo.PutUint16(header[6:8], 4)
+	bo.PutUint32(header[16:20], defaultSnap)
+	bo.PutUint32(header[20:24], linkTypeEther)
+	file.Write(header)
+	record := make([]byte, 16)
+	bo.PutUint32(record[0:4], sec)
+	bo.PutUint32(record[4:8], frac)
+	bo.PutUint32(record[8:12], 4)
+	bo.PutUint32(record[12:16], 4)
+	file.Write(record)
+	file.Write([]byte{0xde, 0xad, 0xbe, 0xef})
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatalf("write pcap: %v", err)
+	}
+	return path
+}
+
+// TestOpenReader_TimestampPrecision verifies that classic pcap files are read
+// with the precision their magic announces. This matters because captures
+// taken with tcpdump --time-stamp-precision=nano were rejected as an
+// unsupported magic. Inputs: hand-crafted files with the microsecond and the
+// nanosecond magic, each little and big endian, holding one record at
+// 1700000000s plus a sub-second field of 123456 microseconds or 123456789
+// nanoseconds. The expected output is the Ethernet link type, the record
+// data, and the timestamp to the microsecond or to the nanosecond.
+func TestOpenReader_TimestampPrecision(t *testing.T) {
+	cases := []struct {
+		name  string
+		magic uint32
+		bo    binary.ByteOrder
+		frac  uint32
+		want  time.Time
+	}{
+		{"micro little endian", pcapMagicLittle, binary.LittleEndian, 123456, time.Unix(1700000000, 123456000)},
+		{"micro big endian", pcapMagicLittle, binary.BigEndian, 123456, time.Unix(1700000000, 123456000)},
+		{"nano little endian", pcapMagicNanoLittle, binary.LittleEndian, 123456789, time.Unix(1700000000, 123456789)},
+		{"nano big endian", pcapMagicNanoLittle, binary.BigEndian, 123456789, time.Unix(1700000000, 123456789)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, err := OpenReader(writeClassicPCAP(t, tc.magic, tc.bo, 1700000000, tc.frac))
+			if err != nil {
+				t.Fatalf("open pcap: %v", err)
+			}
+			defer reader.Close()
+			if reader.LinkType() != linkTypeEther {
+				t.Fatalf("expected link type %d, got %d", linkTypeEther, reader.LinkType())
+			}
+			packet, err := reader.Next()
+			if err != nil {
+				t.Fatalf("read packet: %v", err)
+			}
+			if !packet.Timestamp.Equal(tc.want) {
+				t.Fatalf("expected timestamp %v, got %v", tc.want, packet.Timestamp)
+			}
+			if !bytes.Equal(packet.Data, []byte{0xde, 0xad, 0xbe, 0xef}) {
+				t.Fatalf("expected the record data, got % x", packet.Data)
+			}
+		})
+	}
+
+	if _, err := OpenReader(writeClassicPCAP(t, 0xa1b2c3d5, binary.LittleEndian, 0, 0)); err == nil {
+		t.Fatalf("expected an unknown magic to be rejected")
+	}
+}
+
+// TestWriter_TimestampPrecision verifies the timestamps the writers keep.
+// This matters because video packets sent back to back within a microsecond
+// lose their order in a microsecond capture. Inputs: two packets 300ns apart
+// written with NewWriterNano and with NewWriter, read back with OpenReader.
+// The expected output is the nanosecond magic on disk and both timestamps
+// exact for NewWriterNano, and both truncated to the same microsecond for
+// NewWriter.
+func TestWriter_TimestampPrecision(t *testing.T) {
+	first := time.Unix(1700000000, 123456100)
+	second := first.Add(300 * time.Nanosecond)
+	for _, nano := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "capture.pcap")
+		newWriter := NewWriter
+		if nano {
+			newWriter = NewWriterNano
+		}
+		writer, err := newWriter(path)
+		if err != nil {
+			t.Fatalf("create pcap: %v", err)
+		}
+		for _, ts := range []time.Time{first, second} {
+			if err := writer.WritePacket(ts, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 4000, 5000, []byte{0x80, 0x60}); err != nil {
+				t.Fatalf("write packet: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("close pcap: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read pcap: %v", err)
+		}
+		wantMagic := uint32(pcapMagicLittle)
+		if nano {
+			wantMagic = pcapMagicNanoLittle
+		}
+		if magic := binary.LittleEndian.Uint32(data[0:4]); magic != wantMagic {
+			t.Fatalf("nano=%t: expected magic %#x, got %#x", nano, wantMagic, magic)
+		}
+
+		reader, err := OpenReader(path)
+		if err != nil {
+			t.Fatalf("open pcap: %v", err)
+		}
+		var got []time.Time
+		for {
+			packet, err := reader.Next()
+			if err != nil {
+				break
+			}
+			got = append(got, packet.Timestamp)
+		}
+		reader.Close()
+		want := []time.Time{first, second}
+		if !nano {
+			want = []time.Time{first.Truncate(time.Microsecond), first.Truncate(time.Microsecond)}
+		}
+		if len(got) != 2 || !got[0].Equal(want[0]) || !got[1].Equal(want[1]) {
+			t.Fatalf("nano=%t: expected timestamps %v, got %v", nano, want, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package rtpfix
+
+import "errors"
+
+// h264TypeFUA is the fragmentation unit of RFC 6184 5.8.
+const h264TypeFUA = 28
+
+var (
+	// ErrFUInvalid is returned for a payload that is not an FU-A, is too
+	// short, or has the reserved bit set or both the start and end bits.
+	ErrFUInvalid = errors.New("invalid fu-a fragment")
+	// ErrFUMissingStart is returned for a fragment that continues no NAL
+	// unit.
+	ErrFUMissingStart = errors.New("fu-a fragment without a start")
+	// ErrFUMissingEnd is returned for a start fragment that arrives before
+	// the NAL unit under way ended.
+	ErrFUMissingEnd = errors.New("fu-a start before the previous end")
+	// ErrFUTypeMismatch is returned for a fragment whose NAL type differs
+	// from the start's.
+	ErrFUTypeMismatch = errors.New("fu-a fragment type mismatch")
+)
+
+// FUAssembler reassembles the FU-A fragments of an H264 RTP stream into whole
+// NAL units. The zero value is ready to use. It keeps one NAL unit under way,
+// so a caller feeding several sources needs one assembler per source.
+type FUAssembler struct {
+	nal     []byte
+	started bool
+}
+
+// Push feeds the next FU-A payload. It returns the NAL unit, header byte
+// rebuilt from the F and NRI bits of the FU indicator and the type of the FU
+// header, once the end fragment arrives, and nil before. On an error the NAL
+// unit under way is dropped, except for ErrFUMissingEnd: the new start
+// fragment then begins the next one.
+func (a *FUAssembler) Push(payload []byte) ([]byte, error) {
+	if len(payload) < 3 || payload[0]&0x1f != h264TypeFUA {
+		a.Reset()
+		return nil, ErrFUInvalid
+	}
+	indicator, header := payload[0], payload[1]
+	start, end := header&0x80 != 0, header&0x40 != 0
+	if header&0x20 != 0 || start && end {
+		a.Reset()
+		return nil, ErrFUInvalid
+	}
+	nalHeader := indicator&0xe0 | header&0x1f
+	var err error
+	if start {
+		if a.started {
+			err = ErrFUMissingEnd
+		}
+		a.nal = append(a.nal[:0], nalHeader)
+		a.started = true
+	} else {
+		if !a.started {
+			return nil, ErrFUMissingStart
+		}
+		if a.nal[0]&0x1f != nalHeader&0x1f {
+			a.Reset()
+			return nil, ErrFUTypeMismatch
+		}
+	}
+	a.nal = append(a.nal, payload[2:]...)
+	if !end {
+		return nil, err
+	}
+	nal := a.nal
+	a.nal = nil
+	a.started = false
+	return nal, nil
+}
+
+// Pending reports whether a NAL unit is under way, its end fragment not
+// received yet.
+func (a *FUAssembler) Pending() bool {
+	return a.started
+}
+
+// Reset drops the NAL unit under way, e.g. after a sequence gap.
+func (a *FUAssembler) Reset() {
+	a.nal = a.nal[:0]
+	a.started = false
+}
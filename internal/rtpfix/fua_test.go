@@ -0,0 +1,151 @@
+package rtpfix
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// fuaFragments splits nal into FU-A payloads of at most size bytes of data.
+func fuaFragments(nal []byte, size int) [][]byte {
+	var fragments [][]byte
+	data := nal[1:]
+	for offset := 0; offset < len(data); offset += size {
+		chunk := data[offset:min(offset+size, len(data))]
+		header := nal[0] & 0x1f
+		if offset == 0 {
+			header |= 0x80
+		}
+		if offset+size >= len(data) {
+			header |= 0x40
+		}
+		fragment := append([]byte{nal[0]&0xe0 | h264TypeFUA, header}, chunk...)
+		fragments = append(fragments, fragment)
+	}
+	return fragments
+}
+
+// TestFUAssembler_Assembles validates reassembling a fragmented NAL unit.
+// This matters because doorphones fragment large parameter sets and IDR
+// slices, which can only be parsed whole. Inputs: the x264 1080p SPS split
+// into 8-byte fragments, then a 3-fragment IDR slice with NRI 3. The expected
+// output is nothing returned before each end fragment, then each NAL unit
+// byte for byte with its header rebuilt, and the SPS still parsing.
+func TestFUAssembler_Assembles(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9, 0x40, 0x78, 0x02, 0x27, 0xe5, 0xc0, 0x44, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xf0, 0x3c, 0x60, 0xc6, 0x58}
+	idr := []byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff, 0xfe, 0xf6, 0xf0, 0xfe}
+	var assembler FUAssembler
+	for _, tc := range []struct {
+		nal  []byte
+		size int
+	}{{nal: sps, size: 8}, {nal: idr, size: 3}} {
+		fragments := fuaFragments(tc.nal, tc.size)
+		for i, fragment := range fragments {
+			nal, err := assembler.Push(fragment)
+			if err != nil {
+				t.Fatalf("expected fragment %d to be accepted, got %v", i, err)
+			}
+			if i < len(fragments)-1 {
+				if nal != nil || !assembler.Pending() {
+					t.Fatalf("expected fragment %d to leave the NAL unit pending", i)
+				}
+				continue
+			}
+			if !bytes.Equal(nal, tc.nal) {
+				t.Fatalf("expected % x, got % x", tc.nal, nal)
+			}
+		}
+		if assembler.Pending() {
+			t.Fatalf("expected nothing pending after the end fragment")
+		}
+	}
+	fragments := fuaFragments(sps, 8)
+	var nal []byte
+	for _, fragment := range fragments {
+		nal, _ = assembler.Push(fragment)
+	}
+	if info, ok := ParseSPS(nal); !ok || info.Width != 1920 || info.Height != 1080 {
+		t.Fatalf("expected the reassembled SPS to parse as 1920x1080, got %+v %v", info, ok)
+	}
+}
+
+// TestFUAssembler_MissingStart validates fragments that continue no NAL unit,
+// as after the start fragment was lost. Inputs: the middle and end fragments
+// of a 3-fragment NAL unit, then the whole of it. The expected output is
+// ErrFUMissingStart for both strays and the NAL unit assembled afterwards.
+func TestFUAssembler_MissingStart(t *testing.T) {
+	nal := []byte{0x65, 1, 2, 3, 4, 5, 6}
+	fragments := fuaFragments(nal, 2)
+	var assembler FUAssembler
+	for _, fragment := range fragments[1:] {
+		if _, err := assembler.Push(fragment); !errors.Is(err, ErrFUMissingStart) {
+			t.Fatalf("expected ErrFUMissingStart, got %v", err)
+		}
+	}
+	var got []byte
+	for _, fragment := range fragments {
+		got, _ = assembler.Push(fragment)
+	}
+	if !bytes.Equal(got, nal) {
+		t.Fatalf("expected % x after the strays, got % x", nal, got)
+	}
+}
+
+// TestFUAssembler_MissingEnd validates a start fragment arriving while a NAL
+// unit is under way, as after its end fragment was lost. Inputs: the start
+// and middle fragments of one NAL unit, then all fragments of another. The
+// expected output is ErrFUMissingEnd on the second start, which begins the
+// second NAL unit, returned whole on its end fragment.
+func TestFUAssembler_MissingEnd(t *testing.T) {
+	first := fuaFragments([]byte{0x65, 1, 2, 3, 4, 5, 6}, 2)
+	second := []byte{0x41, 9, 8, 7, 6}
+	var assembler FUAssembler
+	for _, fragment := range first[:2] {
+		if _, err := assembler.Push(fragment); err != nil {
+			t.Fatalf("expected the first fragments to be accepted, got %v", err)
+		}
+	}
+	fragments := fuaFragments(second, 2)
+	if nal, err := assembler.Push(fragments[0]); !errors.Is(err, ErrFUMissingEnd) || nal != nil {
+		t.Fatalf("expected ErrFUMissingEnd, got %v and % x", err, nal)
+	}
+	nal, err := assembler.Push(fragments[1])
+	if err != nil || !bytes.Equal(nal, second) {
+		t.Fatalf("expected % x, got % x and %v", second, nal, err)
+	}
+}
+
+// TestFUAssembler_Rejects validates inconsistent fragments. Inputs: a middle
+// fragment of another NAL type than its start's, a fragment with the
+// reserved bit set, one with both the start and end bits, one too short to
+// carry data, and a single NAL unit packet. The expected output is
+// ErrFUTypeMismatch for the first, ErrFUInvalid for the others, and nothing
+// left pending after any of them.
+func TestFUAssembler_Rejects(t *testing.T) {
+	var assembler FUAssembler
+	if _, err := assembler.Push([]byte{0x7c, 0x85, 1, 2}); err != nil {
+		t.Fatalf("expected the start fragment to be accepted, got %v", err)
+	}
+	if _, err := assembler.Push([]byte{0x7c, 0x01, 3, 4}); !errors.Is(err, ErrFUTypeMismatch) {
+		t.Fatalf("expected ErrFUTypeMismatch, got %v", err)
+	}
+	if assembler.Pending() {
+		t.Fatalf("expected the mismatched NAL unit to be dropped")
+	}
+	for _, payload := range [][]byte{
+		{0x7c, 0xa5, 1},
+		{0x7c, 0xc5, 1},
+		{0x7c, 0x85},
+		{0x65, 0x88, 0x84},
+	} {
+		if _, err := assembler.Push([]byte{0x7c, 0x85, 1}); err != nil {
+			t.Fatalf("expected the start fragment to be accepted, got %v", err)
+		}
+		if _, err := assembler.Push(payload); !errors.Is(err, ErrFUInvalid) {
+			t.Fatalf("expected % x to be invalid, got %v", payload, err)
+		}
+		if assembler.Pending() {
+			t.Fatalf("expected % x to drop the NAL unit under way", payload)
+		}
+	}
+}
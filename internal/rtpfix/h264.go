@@ -1,22 +1,43 @@
 package rtpfix
 
+// H264Info classifies the NAL unit carried by one RTP payload. ParseH265
+// fills it for HEVC as well: NALType is then the H265 type, IsVPS marks a
+// video parameter set and IsIDR any IRAP picture. For a STAP-A, IsAggregate
+// is set and the other flags report what any of its NAL units carries.
+// IsAUD marks an access unit delimiter and IsFiller filler data; like SEI,
+// they are neither parameter sets nor slices. HasStartCode marks an H264
+// payload wrongly prefixed with an Annex B start code, the NAL unit header
+// then found at NALOffset.
 type H264Info struct {
-	IsSlice bool
-	IsFU    bool
-	FUStart bool
-	FUEnd   bool
-	NALType uint8
-	IsSPS   bool
-	IsPPS   bool
-	IsIDR   bool
+	IsSlice      bool
+	IsFU         bool
+	FUStart      bool
+	FUEnd        bool
+	NALType      uint8
+	IsVPS        bool
+	IsSPS        bool
+	IsPPS        bool
+	IsIDR        bool
+	IsSEI        bool
+	IsAUD        bool
+	IsFiller     bool
+	IsAggregate  bool
+	HasStartCode bool
+	NALOffset    int
 }
 
+// h264TypeSTAPA is the single-time aggregation packet of RFC 6184.
+const h264TypeSTAPA = 24
+
 func parseH264(payload []byte) (H264Info, bool) {
 	if len(payload) == 0 {
 		return H264Info{}, false
 	}
 	first := payload[0]
 	unitType := first & 0x1f
+	if unitType == h264TypeSTAPA {
+		return parseSTAPA(payload)
+	}
 	info := H264Info{}
 	if unitType == 28 {
 		if len(payload) < 2 {
@@ -33,12 +54,145 @@ func parseH264(payload []byte) (H264Info, bool) {
 	info.IsSPS = info.NALType == 7
 	info.IsPPS = info.NALType == 8
 	info.IsIDR = info.NALType == 5
+	info.IsSEI = info.NALType == 6
+	info.IsAUD = info.NALType == 9
+	info.IsFiller = info.NALType == 12
 	info.IsSlice = info.NALType >= 1 && info.NALType <= 5
 	return info, true
 }
 
+// ParseH264 classifies an H264 RTP payload. A payload starting with a 3- or
+// 4-byte Annex B start code, which some doorphones send although RFC 6184
+// has none, is classified by the NAL unit after it.
 func ParseH264(payload []byte) (H264Info, bool) {
-	return parseH264(payload)
+	offset := startCodeLen(payload)
+	info, ok := parseH264(payload[offset:])
+	if ok && offset > 0 {
+		info.HasStartCode = true
+		info.NALOffset = offset
+	}
+	return info, ok
+}
+
+// startCodeLen returns the length of the Annex B start code, 0x000001 or
+// 0x00000001, at the head of payload, or 0 when there is none.
+func startCodeLen(payload []byte) int {
+	switch {
+	case len(payload) >= 4 && payload[0] == 0 && payload[1] == 0 && payload[2] == 0 && payload[3] == 1:
+		return 4
+	case len(payload) >= 3 && payload[0] == 0 && payload[1] == 0 && payload[2] == 1:
+		return 3
+	}
+	return 0
+}
+
+// h264NALTypeNames are the names of the H264 NAL unit types of ITU-T H.264
+// table 7-1 and of the RTP packetizations of RFC 6184.
+var h264NALTypeNames = [32]string{
+	0:  "unspecified",
+	1:  "non_idr_slice",
+	2:  "slice_partition_a",
+	3:  "slice_partition_b",
+	4:  "slice_partition_c",
+	5:  "idr_slice",
+	6:  "sei",
+	7:  "sps",
+	8:  "pps",
+	9:  "aud",
+	10: "end_of_seq",
+	11: "end_of_stream",
+	12: "filler",
+	13: "sps_ext",
+	14: "prefix",
+	15: "subset_sps",
+	16: "dps",
+	17: "reserved",
+	18: "reserved",
+	19: "aux_slice",
+	20: "slice_ext",
+	21: "slice_ext_depth",
+	22: "reserved",
+	23: "reserved",
+	24: "stap_a",
+	25: "stap_b",
+	26: "mtap16",
+	27: "mtap24",
+	28: "fu_a",
+	29: "fu_b",
+	30: "unspecified",
+	31: "unspecified",
+}
+
+// NALTypeName names an H264 NAL unit type for logging, e.g. "sei" for 6. A
+// value past the five bits of the type is "invalid".
+func NALTypeName(nalType uint8) string {
+	if int(nalType) >= len(h264NALTypeNames) {
+		return "invalid"
+	}
+	return h264NALTypeNames[nalType]
+}
+
+// parseSTAPA merges the classification of the NAL units in a STAP-A.
+func parseSTAPA(payload []byte) (H264Info, bool) {
+	units, ok := SplitSTAPA(payload)
+	if !ok {
+		return H264Info{}, false
+	}
+	info := H264Info{NALType: h264TypeSTAPA, IsAggregate: true}
+	for _, unit := range units {
+		unitInfo, ok := parseH264(unit)
+		if !ok {
+			return H264Info{}, false
+		}
+		info.IsSPS = info.IsSPS || unitInfo.IsSPS
+		info.IsPPS = info.IsPPS || unitInfo.IsPPS
+		info.IsIDR = info.IsIDR || unitInfo.IsIDR
+		info.IsSEI = info.IsSEI || unitInfo.IsSEI
+		info.IsAUD = info.IsAUD || unitInfo.IsAUD
+		info.IsFiller = info.IsFiller || unitInfo.IsFiller
+		info.IsSlice = info.IsSlice || unitInfo.IsSlice
+	}
+	return info, true
+}
+
+// SplitSTAPA returns the NAL units of a STAP-A payload (RFC 6184 5.7.1), each
+// preceded there by its 16-bit size. It fails for any other payload, and for
+// an aggregate without units, with a zero size or with a size running past
+// the payload.
+func SplitSTAPA(payload []byte) ([][]byte, bool) {
+	if len(payload) < 1 || payload[0]&0x1f != h264TypeSTAPA {
+		return nil, false
+	}
+	var units [][]byte
+	rest := payload[1:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return nil, false
+		}
+		size := int(rest[0])<<8 | int(rest[1])
+		if size == 0 || size > len(rest)-2 {
+			return nil, false
+		}
+		units = append(units, rest[2:2+size])
+		rest = rest[2+size:]
+	}
+	return units, len(units) > 0
+}
+
+// BuildSTAPA aggregates units into a STAP-A payload. header is the STAP-A
+// NAL header to use, normally that of the aggregate the units came from.
+func BuildSTAPA(header byte, units [][]byte) []byte {
+	size := 1
+	for _, unit := range units {
+		size += 2 + len(unit)
+	}
+	payload := make([]byte, 1, size)
+	payload[0] = header&^0x1f | h264TypeSTAPA
+	for _, unit := range units {
+		payload = append(payload, byte(len(unit)>>8), byte(len(unit)))
+		payload = append(payload, unit...)
+	}
+	return payload
 }
 
 func isFrameStart(info H264Info) bool {
@@ -27,8 +27,9 @@ func buildRTPPacket(marker bool, payloadType uint8, seq uint16, ts uint32, ssrc
 // TestH264NALTypeParsing_SPS_PPS_IDR_NonIDR validates the H264 NAL unit type
 // decoding rule that rtp-cleaner uses to identify parameter sets and slice
 // frames for buffering and injection. Each synthetic payload is a one-byte NAL
-// header with the target type: SPS (7), PPS (8), IDR slice (5), and non-IDR
-// slice (1). The expected outputs are deterministic because ParseH264 only
+// header with the target type: SPS (7), PPS (8), IDR slice (5), non-IDR
+// slice (1), SEI (6), AUD (9), filler (12) and end of sequence (10), the last
+// carrying no flag. The expected outputs are deterministic because ParseH264 only
 // inspects the low 5 bits of the first byte, so no start codes or extra data
 // are needed. The test guards against misclassification that would either skip
 // needed SPS/PPS caching or mis-handle slice frames during frame assembly.
@@ -40,6 +41,9 @@ func TestH264NALTypeParsing_SPS_PPS_IDR_NonIDR(t *testing.T) {
 		wantSPS   bool
 		wantPPS   bool
 		wantIDR   bool
+		wantSEI   bool
+		wantAUD   bool
+		wantFill  bool
 		wantSlice bool
 	}{
 		{
@@ -69,6 +73,29 @@ func TestH264NALTypeParsing_SPS_PPS_IDR_NonIDR(t *testing.T) {
 			wantType:  1,
 			wantSlice: true,
 		},
+		{
+			name:     "sei",
+			payload:  []byte{0x06},
+			wantType: 6,
+			wantSEI:  true,
+		},
+		{
+			name:     "aud",
+			payload:  []byte{0x09},
+			wantType: 9,
+			wantAUD:  true,
+		},
+		{
+			name:     "filler",
+			payload:  []byte{0x0c},
+			wantType: 12,
+			wantFill: true,
+		},
+		{
+			name:     "end of sequence",
+			payload:  []byte{0x0a},
+			wantType: 10,
+		},
 	}
 
 	for _, tc := range cases {
@@ -88,12 +115,49 @@ func TestH264NALTypeParsing_SPS_PPS_IDR_NonIDR(t *testing.T) {
 		if info.IsIDR != tc.wantIDR {
 			t.Fatalf("%s: unexpected IDR flag: got=%v want=%v", tc.name, info.IsIDR, tc.wantIDR)
 		}
+		if info.IsSEI != tc.wantSEI {
+			t.Fatalf("%s: unexpected SEI flag: got=%v want=%v", tc.name, info.IsSEI, tc.wantSEI)
+		}
+		if info.IsAUD != tc.wantAUD {
+			t.Fatalf("%s: unexpected AUD flag: got=%v want=%v", tc.name, info.IsAUD, tc.wantAUD)
+		}
+		if info.IsFiller != tc.wantFill {
+			t.Fatalf("%s: unexpected filler flag: got=%v want=%v", tc.name, info.IsFiller, tc.wantFill)
+		}
 		if info.IsSlice != tc.wantSlice {
 			t.Fatalf("%s: unexpected slice flag: got=%v want=%v", tc.name, info.IsSlice, tc.wantSlice)
 		}
 	}
 }
 
+// TestNALTypeName validates the H264 NAL unit type names used in logs.
+// Inputs: every type from the slices to the FU-A, a reserved and an
+// unspecified type, and a value past five bits. The expected output is the
+// name of each, "reserved", "unspecified" and "invalid".
+func TestNALTypeName(t *testing.T) {
+	want := map[uint8]string{
+		1:  "non_idr_slice",
+		5:  "idr_slice",
+		6:  "sei",
+		7:  "sps",
+		8:  "pps",
+		9:  "aud",
+		10: "end_of_seq",
+		11: "end_of_stream",
+		12: "filler",
+		17: "reserved",
+		24: "stap_a",
+		28: "fu_a",
+		31: "unspecified",
+		32: "invalid",
+	}
+	for nalType, name := range want {
+		if got := NALTypeName(nalType); got != name {
+			t.Fatalf("expected type %d named %q, got %q", nalType, name, got)
+		}
+	}
+}
+
 // TestFrameBoundaryDetection_ByMarkerOrTimestamp demonstrates the frame boundary
 // rules used by the current H264 parser: slice boundaries are detected only by
 // NAL unit structure (single NAL or FU-A start/end), not by RTP marker bits or
@@ -149,3 +213,124 @@ func TestFrameBoundaryDetection_ByMarkerOrTimestamp(t *testing.T) {
 		t.Fatalf("unexpected single NAL boundaries: start=%v end=%v", IsFrameStart(singleInfo), IsFrameEnd(singleInfo))
 	}
 }
+
+// stapA builds a STAP-A payload carrying units, each behind its 16-bit size.
+func stapA(units ...[]byte) []byte {
+	payload := []byte{0x78}
+	for _, unit := range units {
+		payload = append(payload, byte(len(unit)>>8), byte(len(unit)))
+		payload = append(payload, unit...)
+	}
+	return payload
+}
+
+// TestParseH264_STAPA validates the STAP-A handling that lets the fixer see
+// parameter sets and frame starts that encoders aggregate into one packet.
+// Inputs: aggregates of SPS+PPS+IDR, of SPS+PPS only, of SEI+IDR and of a
+// single non-IDR slice, plus malformed ones: a size running past the payload, a lone size
+// byte, a zero size and no units at all. The expected output is IsAggregate
+// with the union of the contained SPS, PPS, IDR, SEI and slice flags and a frame
+// start and end for aggregates with a slice, the units split back out, and a
+// parse failure for every malformed aggregate.
+func TestParseH264_STAPA(t *testing.T) {
+	sps, pps, idr := []byte{0x67, 0x42, 0x00}, []byte{0x68, 0xce}, []byte{0x65, 0x88, 0x84}
+	cases := []struct {
+		name  string
+		data  []byte
+		ok    bool
+		want  H264Info
+		units int
+	}{
+		{"sps pps idr", stapA(sps, pps, idr), true, H264Info{NALType: 24, IsAggregate: true, IsSPS: true, IsPPS: true, IsIDR: true, IsSlice: true}, 3},
+		{"sps pps", stapA(sps, pps), true, H264Info{NALType: 24, IsAggregate: true, IsSPS: true, IsPPS: true}, 2},
+		{"sei idr", stapA([]byte{0x06, 0x05}, idr), true, H264Info{NALType: 24, IsAggregate: true, IsSEI: true, IsIDR: true, IsSlice: true}, 2},
+		{"non-idr", stapA([]byte{0x41, 0x9a}), true, H264Info{NALType: 24, IsAggregate: true, IsSlice: true}, 1},
+		{"truncated size", append(stapA(sps), 0x00, 0x05, 0x68), false, H264Info{}, 0},
+		{"lone size byte", append(stapA(sps), 0x00), false, H264Info{}, 0},
+		{"zero size", []byte{0x78, 0x00, 0x00}, false, H264Info{}, 0},
+		{"empty", []byte{0x78}, false, H264Info{}, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, ok := ParseH264(tc.data)
+			if ok != tc.ok || info != tc.want {
+				t.Fatalf("expected ok=%t %+v, got ok=%t %+v", tc.ok, tc.want, ok, info)
+			}
+			units, _ := SplitSTAPA(tc.data)
+			if len(units) != tc.units {
+				t.Fatalf("expected %d units, got %d", tc.units, len(units))
+			}
+			if ok && (IsFrameStart(info) != info.IsSlice || IsFrameEnd(info) != info.IsSlice) {
+				t.Fatalf("unexpected frame boundaries for %+v", info)
+			}
+		})
+	}
+	if units, ok := SplitSTAPA(stapA(sps, pps, idr)); !ok || string(units[2]) != string(idr) {
+		t.Fatalf("expected the IDR unit split out, got %x", units)
+	}
+	if _, ok := SplitSTAPA(sps); ok {
+		t.Fatal("expected a single NAL unit not to split")
+	}
+}
+
+// TestBuildSTAPA verifies that units aggregated again, e.g. after SEI units
+// were stripped, form a STAP-A that splits back into them. Inputs: an SPS
+// and an IDR with the NAL header of an aggregate with NRI 3. The expected
+// output is the payload of stapA for the same units, keeping the NRI bits.
+func TestBuildSTAPA(t *testing.T) {
+	sps, idr := []byte{0x67, 0x42, 0x00}, []byte{0x65, 0x88}
+	payload := BuildSTAPA(0x78, [][]byte{sps, idr})
+	if string(payload) != string(stapA(sps, idr)) {
+		t.Fatalf("expected %x, got %x", stapA(sps, idr), payload)
+	}
+	units, ok := SplitSTAPA(payload)
+	if !ok || len(units) != 2 || string(units[0]) != string(sps) || string(units[1]) != string(idr) {
+		t.Fatalf("expected the units back, got %x", units)
+	}
+}
+
+// TestParseH264_StartCode validates payloads prefixed with an Annex B start
+// code. This matters because some doorphones send them, and the fixer read
+// the leading zero byte as a type 0 NAL unit, leaving the stream broken.
+// Inputs: an SPS and an IDR slice after a 4-byte start code, a PPS and a
+// non-IDR slice after a 3-byte one, an FU-A start after a 4-byte one, and
+// the same SPS without prefix, then a start code alone and payloads starting
+// 0x0001 and 0x000002. The expected output is each NAL unit classified by its
+// own header with HasStartCode and NALOffset 4 or 3, no flag without prefix
+// nor for the near misses, a failure for the lone start code, and the SPS
+// still decoding from NALOffset.
+func TestParseH264_StartCode(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40}
+	cases := []struct {
+		name string
+		data []byte
+		want H264Info
+	}{
+		{"4-byte sps", append([]byte{0, 0, 0, 1}, sps...), H264Info{NALType: 7, IsSPS: true, HasStartCode: true, NALOffset: 4}},
+		{"4-byte idr", []byte{0, 0, 0, 1, 0x65, 0x88, 0x84}, H264Info{NALType: 5, IsIDR: true, IsSlice: true, HasStartCode: true, NALOffset: 4}},
+		{"3-byte pps", []byte{0, 0, 1, 0x68, 0xce, 0x3c, 0x80}, H264Info{NALType: 8, IsPPS: true, HasStartCode: true, NALOffset: 3}},
+		{"3-byte non-idr", []byte{0, 0, 1, 0x41, 0x9a}, H264Info{NALType: 1, IsSlice: true, HasStartCode: true, NALOffset: 3}},
+		{"4-byte fu-a", []byte{0, 0, 0, 1, 0x7c, 0x85, 0x88}, H264Info{NALType: 5, IsFU: true, FUStart: true, IsIDR: true, IsSlice: true, HasStartCode: true, NALOffset: 4}},
+		{"no prefix", sps, H264Info{NALType: 7, IsSPS: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, ok := ParseH264(tc.data)
+			if !ok || info != tc.want {
+				t.Fatalf("expected %+v, got ok=%t %+v", tc.want, ok, info)
+			}
+		})
+	}
+	if info, ok := ParseH264([]byte{0, 0, 0, 1}); ok {
+		t.Fatalf("expected a start code alone to fail, got %+v", info)
+	}
+	for _, payload := range [][]byte{{0, 1, 0x65}, {0x00, 0x00, 0x02, 0x65}} {
+		if info, _ := ParseH264(payload); info.HasStartCode {
+			t.Fatalf("expected % x not to be taken for a start code", payload)
+		}
+	}
+	info, _ := ParseH264(cases[0].data)
+	if parsed, ok := ParseSPS(cases[0].data[info.NALOffset:]); !ok || parsed.Width != 640 || parsed.Height != 360 {
+		t.Fatalf("expected the prefixed SPS to decode as 640x360, got %+v %v", parsed, ok)
+	}
+}
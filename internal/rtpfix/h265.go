@@ -0,0 +1,53 @@
+package rtpfix
+
+// H265 NAL unit types of RFC 7798 and ITU-T H.265 used by the fixer.
+const (
+	h265TypeBLAWLP    = 16
+	h265TypeCRA       = 21
+	h265TypeMaxVCL    = 31
+	h265TypeVPS       = 32
+	h265TypeSPS       = 33
+	h265TypePPS       = 34
+	h265TypeAUD       = 35
+	h265TypeFiller    = 38
+	h265TypePrefixSEI = 39
+	h265TypeSuffixSEI = 40
+	h265TypeFU        = 49
+	h265NALHeaderSize = 2
+)
+
+// ParseH265 classifies an RTP payload of RFC 7798. The NAL type sits in bits
+// 1-6 of the two-byte NAL unit header; a fragmentation unit (type 49) carries
+// the type of the fragmented NAL unit and its start and end flags in the FU
+// header after it. Slices are the VCL types 0-31, the IRAP pictures (BLA,
+// IDR and CRA, types 16-21) count as IDR, prefix and suffix SEI (39 and 40)
+// as SEI, and the access unit delimiter (35) and filler data (38) as AUD and
+// filler.
+func ParseH265(payload []byte) (H264Info, bool) {
+	if len(payload) < h265NALHeaderSize {
+		return H264Info{}, false
+	}
+	unitType := (payload[0] >> 1) & 0x3f
+	info := H264Info{}
+	if unitType == h265TypeFU {
+		if len(payload) < h265NALHeaderSize+1 {
+			return H264Info{}, false
+		}
+		fuHeader := payload[h265NALHeaderSize]
+		info.IsFU = true
+		info.FUStart = fuHeader&0x80 != 0
+		info.FUEnd = fuHeader&0x40 != 0
+		info.NALType = fuHeader & 0x3f
+	} else {
+		info.NALType = unitType
+	}
+	info.IsVPS = info.NALType == h265TypeVPS
+	info.IsSPS = info.NALType == h265TypeSPS
+	info.IsPPS = info.NALType == h265TypePPS
+	info.IsIDR = info.NALType >= h265TypeBLAWLP && info.NALType <= h265TypeCRA
+	info.IsSEI = info.NALType == h265TypePrefixSEI || info.NALType == h265TypeSuffixSEI
+	info.IsAUD = info.NALType == h265TypeAUD
+	info.IsFiller = info.NALType == h265TypeFiller
+	info.IsSlice = info.NALType <= h265TypeMaxVCL
+	return info, true
+}
@@ -0,0 +1,56 @@
+package rtpfix
+
+import "testing"
+
+// h265NAL returns the two-byte H265 NAL unit header of unitType.
+func h265NAL(unitType uint8) []byte {
+	return []byte{unitType << 1, 0x01}
+}
+
+// TestParseH265 validates the RFC 7798 classification the fixer relies on for
+// HEVC doorphones. Inputs: single NAL units of type VPS (32), SPS (33), PPS
+// (34), IDR_W_RADL (19), CRA (21), TRAIL_R (1), prefix SEI (39), AUD (35) and
+// filler data (38), an aggregation packet (48), FU start, middle and end
+// fragments of an IDR (type 49 with an FU header), and truncated payloads. The
+// expected output is the type, the parameter set, IDR, SEI, AUD, filler and
+// slice flags and the FU start/end bits for each, and a parse failure for an
+// empty payload, a lone header byte and an FU without its FU header.
+func TestParseH265(t *testing.T) {
+	fu := func(fuHeader byte) []byte {
+		return append(h265NAL(h265TypeFU), fuHeader)
+	}
+	cases := []struct {
+		name string
+		data []byte
+		ok   bool
+		want H264Info
+	}{
+		{"vps", h265NAL(32), true, H264Info{NALType: 32, IsVPS: true}},
+		{"sps", h265NAL(33), true, H264Info{NALType: 33, IsSPS: true}},
+		{"pps", h265NAL(34), true, H264Info{NALType: 34, IsPPS: true}},
+		{"idr", h265NAL(19), true, H264Info{NALType: 19, IsIDR: true, IsSlice: true}},
+		{"cra", h265NAL(21), true, H264Info{NALType: 21, IsIDR: true, IsSlice: true}},
+		{"trail", h265NAL(1), true, H264Info{NALType: 1, IsSlice: true}},
+		{"prefix sei", h265NAL(39), true, H264Info{NALType: 39, IsSEI: true}},
+		{"aud", h265NAL(35), true, H264Info{NALType: 35, IsAUD: true}},
+		{"filler", h265NAL(38), true, H264Info{NALType: 38, IsFiller: true}},
+		{"aggregation", h265NAL(48), true, H264Info{NALType: 48}},
+		{"fu start", fu(0x80 | 19), true, H264Info{NALType: 19, IsFU: true, FUStart: true, IsIDR: true, IsSlice: true}},
+		{"fu middle", fu(19), true, H264Info{NALType: 19, IsFU: true, IsIDR: true, IsSlice: true}},
+		{"fu end", fu(0x40 | 19), true, H264Info{NALType: 19, IsFU: true, FUEnd: true, IsIDR: true, IsSlice: true}},
+		{"empty", nil, false, H264Info{}},
+		{"short header", []byte{19 << 1}, false, H264Info{}},
+		{"short fu", h265NAL(h265TypeFU), false, H264Info{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, ok := ParseH265(tc.data)
+			if ok != tc.ok || info != tc.want {
+				t.Fatalf("expected ok=%t %+v, got ok=%t %+v", tc.ok, tc.want, ok, info)
+			}
+			if ok && info.IsSlice && IsFrameStart(info) != (!info.IsFU || info.FUStart) {
+				t.Fatalf("unexpected frame start %t for %+v", IsFrameStart(info), info)
+			}
+		})
+	}
+}
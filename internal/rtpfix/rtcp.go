@@ -0,0 +1,40 @@
+package rtpfix
+
+import "encoding/binary"
+
+// PLILen and FIRLen are the sizes of the payload-specific feedback messages
+// built here: a Picture Loss Indication (RFC 4585 6.3.1) and a Full Intra
+// Request with one entry (RFC 5104 4.3.1).
+const (
+	PLILen = 12
+	FIRLen = 20
+)
+
+// rtcpPSFB is the RTCP packet type of payload-specific feedback.
+const rtcpPSFB = 206
+
+// BuildPLI encodes an RTCP PLI from senderSSRC asking the sender of
+// mediaSSRC for a keyframe.
+func BuildPLI(senderSSRC, mediaSSRC uint32) []byte {
+	packet := make([]byte, PLILen)
+	packet[0] = 0x80 | 1 // V=2, FMT=1 (PLI)
+	packet[1] = rtcpPSFB
+	binary.BigEndian.PutUint16(packet[2:4], PLILen/4-1)
+	binary.BigEndian.PutUint32(packet[4:8], senderSSRC)
+	binary.BigEndian.PutUint32(packet[8:12], mediaSSRC)
+	return packet
+}
+
+// BuildFIR encodes an RTCP FIR from senderSSRC asking the sender of
+// mediaSSRC for a keyframe. The media source field stays 0 and the target
+// goes in the FCI entry; seqNr must change with every new request.
+func BuildFIR(senderSSRC, mediaSSRC uint32, seqNr uint8) []byte {
+	packet := make([]byte, FIRLen)
+	packet[0] = 0x80 | 4 // V=2, FMT=4 (FIR)
+	packet[1] = rtcpPSFB
+	binary.BigEndian.PutUint16(packet[2:4], FIRLen/4-1)
+	binary.BigEndian.PutUint32(packet[4:8], senderSSRC)
+	binary.BigEndian.PutUint32(packet[12:16], mediaSSRC)
+	packet[16] = seqNr
+	return packet
+}
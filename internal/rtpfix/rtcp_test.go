@@ -0,0 +1,39 @@
+package rtpfix
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestBuildPLI checks the PLI bytes against the RFC 4585 6.1 common feedback
+// header: V=2 with FMT=1, PT 206 (PSFB), a length of 2 words after the first,
+// then the sender and media source SSRCs. Inputs: sender 0x01020304 and media
+// source 0x11223344. The expected output is exactly those 12 bytes.
+func TestBuildPLI(t *testing.T) {
+	want := []byte{
+		0x81, 206, 0x00, 0x02,
+		0x01, 0x02, 0x03, 0x04,
+		0x11, 0x22, 0x33, 0x44,
+	}
+	if got := BuildPLI(0x01020304, 0x11223344); !bytes.Equal(got, want) {
+		t.Fatalf("expected PLI % x, got % x", want, got)
+	}
+}
+
+// TestBuildFIR checks the FIR bytes against RFC 5104 4.3.1: FMT=4, PT 206, a
+// length of 4 words, the media source SSRC left 0, and one FCI entry with the
+// target SSRC, the command sequence number and 3 reserved bytes. Inputs:
+// sender 0x01020304, target 0x11223344 and sequence number 7. The expected
+// output is exactly those 20 bytes.
+func TestBuildFIR(t *testing.T) {
+	want := []byte{
+		0x84, 206, 0x00, 0x04,
+		0x01, 0x02, 0x03, 0x04,
+		0x00, 0x00, 0x00, 0x00,
+		0x11, 0x22, 0x33, 0x44,
+		0x07, 0x00, 0x00, 0x00,
+	}
+	if got := BuildFIR(0x01020304, 0x11223344, 7); !bytes.Equal(got, want) {
+		t.Fatalf("expected FIR % x, got % x", want, got)
+	}
+}
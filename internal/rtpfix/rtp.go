@@ -9,6 +9,24 @@ type RTPHeader struct {
 	SSRC      uint32
 	Marker    bool
 	HeaderLen int
+	// CSRCCount and Extension are the CC field and the X bit; the CSRC list
+	// and the header extension take up the header beyond its first 12 bytes.
+	CSRCCount int
+	Extension bool
+	// ExtensionProfile is the 16-bit profile of the header extension, e.g.
+	// OneByteExtensionProfile, and Extensions the extension words after its
+	// length, sliced from the packet. Both are zero without the X bit.
+	ExtensionProfile uint16
+	Extensions       []byte
+	// Padding is the P bit. PaddingLen is then the number of padding bytes
+	// at the end of the packet, the last one included.
+	Padding    bool
+	PaddingLen int
+}
+
+// Payload returns the payload of packet, header and padding excluded.
+func (h RTPHeader) Payload(packet []byte) []byte {
+	return packet[h.HeaderLen : len(packet)-h.PaddingLen]
 }
 
 func parseRTPHeader(packet []byte) (RTPHeader, bool) {
@@ -25,26 +43,94 @@ func parseRTPHeader(packet []byte) (RTPHeader, bool) {
 	if len(packet) < headerLen {
 		return RTPHeader{}, false
 	}
+	var extProfile uint16
+	var extensions []byte
 	if hasExtension {
 		if len(packet) < headerLen+4 {
 			return RTPHeader{}, false
 		}
+		extProfile = binary.BigEndian.Uint16(packet[headerLen : headerLen+2])
 		extLenWords := int(binary.BigEndian.Uint16(packet[headerLen+2 : headerLen+4]))
-		headerLen += 4 + extLenWords*4
+		extStart := headerLen + 4
+		headerLen = extStart + extLenWords*4
 		if len(packet) < headerLen {
 			return RTPHeader{}, false
 		}
+		extensions = packet[extStart:headerLen]
+	}
+	padding := packet[0]&0x20 != 0
+	paddingLen := 0
+	if padding {
+		paddingLen = int(packet[len(packet)-1])
+		if paddingLen == 0 || len(packet) < headerLen+paddingLen {
+			return RTPHeader{}, false
+		}
 	}
 	return RTPHeader{
-		PT:        packet[1] & 0x7f,
-		Seq:       binary.BigEndian.Uint16(packet[2:4]),
-		TS:        binary.BigEndian.Uint32(packet[4:8]),
-		SSRC:      binary.BigEndian.Uint32(packet[8:12]),
-		Marker:    packet[1]&0x80 != 0,
-		HeaderLen: headerLen,
+		PT:               packet[1] & 0x7f,
+		Seq:              binary.BigEndian.Uint16(packet[2:4]),
+		TS:               binary.BigEndian.Uint32(packet[4:8]),
+		SSRC:             binary.BigEndian.Uint32(packet[8:12]),
+		Marker:           packet[1]&0x80 != 0,
+		HeaderLen:        headerLen,
+		CSRCCount:        cc,
+		Extension:        hasExtension,
+		Padding:          padding,
+		PaddingLen:       paddingLen,
+		ExtensionProfile: extProfile,
+		Extensions:       extensions,
 	}, true
 }
 
 func ParseRTPHeader(packet []byte) (RTPHeader, bool) {
 	return parseRTPHeader(packet)
 }
+
+// Header extension profiles of RFC 8285. The low 4 bits of the two-byte
+// profile are application bits.
+const (
+	OneByteExtensionProfile = 0xbede
+	TwoByteExtensionProfile = 0x1000
+)
+
+// ForEachOneByteExtension calls fn with the ID and data of each element of
+// ext, the Extensions of a header whose ExtensionProfile is
+// OneByteExtensionProfile (RFC 8285 4.2). Padding bytes are skipped. It stops
+// at ID 15, which ends the elements, and at an element running past ext.
+// data is sliced from ext.
+func ForEachOneByteExtension(ext []byte, fn func(id uint8, data []byte)) {
+	for i := 0; i < len(ext); {
+		id, length := ext[i]>>4, int(ext[i]&0x0f)+1
+		if id == 0 {
+			i++
+			continue
+		}
+		if id == 15 || i+1+length > len(ext) {
+			return
+		}
+		fn(id, ext[i+1:i+1+length])
+		i += 1 + length
+	}
+}
+
+// BuildPacket assembles an RTP packet from header and payload. ext is the
+// CSRC list followed by the header extension, as found between the first 12
+// bytes of a packet and header.HeaderLen, and must match header.CSRCCount
+// and header.Extension. The packet carries no padding.
+func BuildPacket(header RTPHeader, ext []byte, payload []byte) []byte {
+	packet := make([]byte, 12+len(ext)+len(payload))
+	packet[0] = 0x80 | byte(header.CSRCCount&0x0f)
+	if header.Extension {
+		packet[0] |= 0x10
+	}
+	packet[1] = header.PT & 0x7f
+	if header.Marker {
+		packet[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(packet[2:4], header.Seq)
+	binary.BigEndian.PutUint32(packet[4:8], header.TS)
+	binary.BigEndian.PutUint32(packet[8:12], header.SSRC)
+	copy(packet[12:], ext)
+	copy(packet[12+len(ext):], payload)
+	return packet
+}
@@ -0,0 +1,194 @@
+package rtpfix
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestParseRTPHeader_Padding verifies that the P bit is honoured. This
+// matters because padding bytes used to be taken for payload, so H264
+// parameter sets were cached with garbage at their end. Inputs: an SPS padded
+// with 3 bytes, a packet of padding only, and packets whose padding count is
+// 0 or longer than the payload. The expected output is the padding length
+// and the payload without padding for the first two, and a parse failure for
+// the others.
+func TestParseRTPHeader_Padding(t *testing.T) {
+	padded := func(payload []byte, padding ...byte) []byte {
+		packet := buildRTPPacket(false, 96, 1, 1000, 0x01020304, append(payload, padding...))
+		packet[0] |= 0x20
+		return packet
+	}
+	tests := []struct {
+		name        string
+		packet      []byte
+		wantOK      bool
+		wantPadding int
+		wantPayload []byte
+	}{
+		{"padded sps", padded([]byte{0x67, 0x42}, 0x00, 0x00, 0x03), true, 3, []byte{0x67, 0x42}},
+		{"padding only", padded(nil, 0x00, 0x02), true, 2, []byte{}},
+		{"zero count", padded([]byte{0x67, 0x42}, 0x00), false, 0, nil},
+		{"count too long", padded([]byte{0x67}, 0x05), false, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, ok := ParseRTPHeader(tt.packet)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok=%t, got %t", tt.wantOK, ok)
+			}
+			if !ok {
+				return
+			}
+			if !header.Padding || header.PaddingLen != tt.wantPadding {
+				t.Fatalf("expected %d padding bytes, got padding=%t len=%d", tt.wantPadding, header.Padding, header.PaddingLen)
+			}
+			if payload := header.Payload(tt.packet); !bytes.Equal(payload, tt.wantPayload) {
+				t.Fatalf("expected payload %x, got %x", tt.wantPayload, payload)
+			}
+		})
+	}
+}
+
+// TestBuildPacket verifies that BuildPacket lays out what ParseRTPHeader
+// reads. Inputs: a header with marker, two CSRCs and a one-word header
+// extension, and a plain header without either. The expected output is a
+// packet that parses back to the same fields and carries the CSRC list,
+// extension and payload byte for byte.
+func TestBuildPacket(t *testing.T) {
+	ext := []byte{
+		0x00, 0x00, 0x00, 0x0a, // CSRC 10
+		0x00, 0x00, 0x00, 0x0b, // CSRC 11
+		0xbe, 0xde, 0x00, 0x01, // one-byte header extension, 1 word
+		0x32, 0x01, 0x02, 0x03, // abs-send-time
+	}
+	tests := []struct {
+		name   string
+		header RTPHeader
+		ext    []byte
+	}{
+		{"csrc and extension", RTPHeader{PT: 96, Seq: 65535, TS: 90000, SSRC: 0x01020304, Marker: true, CSRCCount: 2, Extension: true}, ext},
+		{"plain", RTPHeader{PT: 111, Seq: 7, TS: 160, SSRC: 0x0a0b0c0d}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := []byte{0x67, 0x42, 0x00}
+			packet := BuildPacket(tt.header, tt.ext, payload)
+			header, ok := ParseRTPHeader(packet)
+			if !ok {
+				t.Fatalf("expected built packet %x to parse", packet)
+			}
+			want := tt.header
+			want.HeaderLen = 12 + len(tt.ext)
+			if tt.header.Extension {
+				want.ExtensionProfile = OneByteExtensionProfile
+				want.Extensions = tt.ext[4*tt.header.CSRCCount+4:]
+			}
+			if !reflect.DeepEqual(header, want) {
+				t.Fatalf("expected header %+v, got %+v", want, header)
+			}
+			if !bytes.Equal(packet[12:header.HeaderLen], tt.ext) || !bytes.Equal(header.Payload(packet), payload) {
+				t.Fatalf("expected ext %x and payload %x, got %x", tt.ext, payload, packet[12:])
+			}
+		})
+	}
+}
+
+// withExtension returns an RTP packet with one CSRC, the header extension of
+// the given profile and words, and a one-byte payload.
+func withExtension(profile uint16, words []byte) []byte {
+	ext := []byte{0x00, 0x00, 0x00, 0x0a, byte(profile >> 8), byte(profile), byte(len(words) / 4 >> 8), byte(len(words) / 4)}
+	header := RTPHeader{PT: 96, Seq: 1, TS: 3000, SSRC: 0x01020304, CSRCCount: 1, Extension: true}
+	return BuildPacket(header, append(ext, words...), []byte{0x41})
+}
+
+// extensionElement is one element reported by ForEachOneByteExtension.
+type extensionElement struct {
+	id   uint8
+	data string
+}
+
+func oneByteElements(ext []byte) []extensionElement {
+	var elements []extensionElement
+	ForEachOneByteExtension(ext, func(id uint8, data []byte) {
+		elements = append(elements, extensionElement{id, string(data)})
+	})
+	return elements
+}
+
+// TestParseRTPHeader_Extensions verifies that the header extension is exposed
+// after the CSRC list. This matters because timing features will read
+// abs-send-time and transport-cc from the RFC 8285 elements WebRTC gateways
+// add. Inputs: a one-byte extension with abs-send-time (ID 3), a padding byte
+// and transport-cc (ID 5), and a two-byte extension with application bits 2
+// carrying one 2-byte element. The expected output is each profile, the
+// extension words byte for byte, the payload after them, and the two
+// one-byte elements with their data.
+func TestParseRTPHeader_Extensions(t *testing.T) {
+	oneByte := []byte{0x32, 0x01, 0x02, 0x03, 0x00, 0x51, 0x12, 0x34}
+	packet := withExtension(OneByteExtensionProfile, oneByte)
+	header, ok := ParseRTPHeader(packet)
+	if !ok || header.ExtensionProfile != OneByteExtensionProfile || !bytes.Equal(header.Extensions, oneByte) {
+		t.Fatalf("expected the one-byte extension % x, got ok=%t %+v", oneByte, ok, header)
+	}
+	if payload := header.Payload(packet); !bytes.Equal(payload, []byte{0x41}) {
+		t.Fatalf("expected the payload after the extension, got % x", payload)
+	}
+	want := []extensionElement{{3, "\x01\x02\x03"}, {5, "\x12\x34"}}
+	if got := oneByteElements(header.Extensions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected elements %v, got %v", want, got)
+	}
+
+	twoByte := []byte{0x01, 0x02, 0xaa, 0xbb}
+	header, ok = ParseRTPHeader(withExtension(TwoByteExtensionProfile|2, twoByte))
+	if !ok || header.ExtensionProfile != TwoByteExtensionProfile|2 || !bytes.Equal(header.Extensions, twoByte) {
+		t.Fatalf("expected the two-byte extension % x, got ok=%t %+v", twoByte, ok, header)
+	}
+	if header.ExtensionProfile&0xfff0 != TwoByteExtensionProfile {
+		t.Fatalf("expected the two-byte profile without its application bits, got %#x", header.ExtensionProfile)
+	}
+}
+
+// TestParseRTPHeader_MalformedExtensions verifies lengths that do not add
+// up. Inputs: an extension whose length runs past the packet, an X bit with
+// the extension header cut short, and one-byte elements where the second
+// runs past the extension words or is preceded by the reserved ID 15. The
+// expected output is a parse failure for the first two, and only the
+// elements before the bad one reported for the others.
+func TestParseRTPHeader_MalformedExtensions(t *testing.T) {
+	long := withExtension(OneByteExtensionProfile, []byte{0x10, 0xaa, 0x00, 0x00})
+	long[19] = 3
+	if _, ok := ParseRTPHeader(long); ok {
+		t.Fatalf("expected an extension running past the packet to fail")
+	}
+	short := buildRTPPacket(false, 96, 1, 3000, 0x01020304, []byte{0xbe, 0xde})
+	short[0] |= 0x10
+	if _, ok := ParseRTPHeader(short); ok {
+		t.Fatalf("expected a cut extension header to fail")
+	}
+
+	want := []extensionElement{{1, "\xaa"}}
+	for _, ext := range [][]byte{
+		{0x10, 0xaa, 0x23, 0x01},
+		{0x10, 0xaa, 0xf0, 0x00, 0x20, 0xbb, 0x00, 0x00},
+	} {
+		if got := oneByteElements(ext); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected % x to give %v, got %v", ext, want, got)
+		}
+	}
+}
+
+// TestParseRTPHeader_Allocations verifies that parsing allocates nothing, the
+// extension words being sliced from the packet, as ParseRTPHeader runs for
+// every packet relayed. Inputs: a packet without extension and one with a
+// one-byte extension. The expected output is zero allocations for each.
+func TestParseRTPHeader_Allocations(t *testing.T) {
+	for _, packet := range [][]byte{
+		buildRTPPacket(true, 96, 1, 3000, 0x01020304, []byte{0x41, 0x9a}),
+		withExtension(OneByteExtensionProfile, []byte{0x32, 0x01, 0x02, 0x03}),
+	} {
+		if allocs := testing.AllocsPerRun(100, func() { ParseRTPHeader(packet) }); allocs != 0 {
+			t.Fatalf("expected no allocation, got %v", allocs)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package rtpfix
+
+// SeqDiff returns a minus b for RTP sequence numbers, modulo 2^16: positive
+// when a comes after b, negative when it comes before. It holds across the
+// wrap from 65535 to 0 as long as a and b are fewer than 32768 apart.
+func SeqDiff(a, b uint16) int {
+	return int(int16(a - b))
+}
+
+// SeqLess reports whether sequence number a comes before b, modulo 2^16.
+func SeqLess(a, b uint16) bool {
+	return SeqDiff(a, b) < 0
+}
+
+// Constants of RFC 3550 appendix A.1.
+const (
+	// SeqMinSequential is the number of packets in sequence that validate a
+	// new source.
+	SeqMinSequential = 2
+	// SeqMaxDropout is the largest forward jump taken as loss.
+	SeqMaxDropout = 3000
+	// SeqMaxMisorder bounds backward jumps: a packet fewer than this many
+	// behind is late, one further behind is treated like a jump beyond
+	// SeqMaxDropout.
+	SeqMaxMisorder = 100
+)
+
+// SeqResult tells how a sequence number fed to a SeqTracker relates to the
+// ones before it.
+type SeqResult int
+
+const (
+	// SeqNext is the next sequence number expected, or a source's first.
+	SeqNext SeqResult = iota
+	// SeqGap is ahead of the next expected one; the packets in between are
+	// missing so far.
+	SeqGap
+	// SeqLate is at or behind the highest one seen: reordered or duplicated.
+	SeqLate
+	// SeqJump is SeqMaxDropout or more ahead, or SeqMaxMisorder or more
+	// behind. The packet is not counted until the next one confirms the jump.
+	SeqJump
+	// SeqRestart follows a SeqJump in sequence: the sender restarted and
+	// the statistics start over from it.
+	SeqRestart
+	// SeqProbation breaks the run of a source not validated yet, whose
+	// probation starts over.
+	SeqProbation
+)
+
+// SeqStats are the reception statistics of a SeqTracker since the source was
+// validated or last restarted.
+type SeqStats struct {
+	// ExtendedMax is the highest sequence number received, extended by
+	// Cycles, the count of wraps from 65535 to 0, times 2^16.
+	ExtendedMax uint32
+	Cycles      uint32
+	// Received counts the packets accepted, late and duplicated ones
+	// included, and Expected the span from the first to the highest.
+	Received uint64
+	Expected uint64
+	// Lost is Expected minus Received, which duplicates can turn negative.
+	Lost int64
+	// Reordered counts the late and duplicated packets, Restarts the jumps
+	// confirmed since the tracker started.
+	Reordered uint64
+	Restarts  uint64
+}
+
+// SeqTracker follows the sequence numbers of one RTP source after RFC 3550
+// appendix A.1: a source is validated once SeqMinSequential packets arrive
+// in sequence, wraps are counted into an extended sequence number, and a
+// large jump restarts the statistics once a second packet confirms it. The
+// zero value is ready to use.
+type SeqTracker struct {
+	started   bool
+	probation int
+	maxSeq    uint16
+	cycles    uint32
+	baseSeq   uint32
+	// badSeq is the sequence number that would confirm a jump; above 2^16
+	// when none is pending.
+	badSeq    uint32
+	received  uint64
+	reordered uint64
+	restarts  uint64
+}
+
+// init starts the statistics over from seq (init_seq of RFC 3550).
+func (t *SeqTracker) init(seq uint16) {
+	t.baseSeq = uint32(seq)
+	t.maxSeq = seq
+	t.badSeq = 1 << 16
+	t.cycles = 0
+	t.received = 0
+	t.reordered = 0
+}
+
+// Update feeds the sequence number of the next packet received (update_seq of
+// RFC 3550) and tells how it relates to the ones before.
+func (t *SeqTracker) Update(seq uint16) SeqResult {
+	if !t.started {
+		t.started = true
+		t.init(seq)
+		t.maxSeq = seq - 1
+		t.probation = SeqMinSequential
+	}
+	delta := seq - t.maxSeq
+	if t.probation > 0 {
+		if delta != 1 {
+			t.probation = SeqMinSequential - 1
+			t.maxSeq = seq
+			return SeqProbation
+		}
+		t.probation--
+		t.maxSeq = seq
+		if t.probation == 0 {
+			t.init(seq)
+			t.received++
+		}
+		return SeqNext
+	}
+	result := SeqNext
+	switch {
+	case delta == 0:
+		t.reordered++
+		result = SeqLate
+	case delta < SeqMaxDropout:
+		if seq < t.maxSeq {
+			t.cycles += 1 << 16
+		}
+		t.maxSeq = seq
+		if delta > 1 {
+			result = SeqGap
+		}
+	case delta <= 1<<16-SeqMaxMisorder:
+		if uint32(seq) != t.badSeq {
+			t.badSeq = uint32(seq + 1)
+			return SeqJump
+		}
+		t.init(seq)
+		t.restarts++
+		result = SeqRestart
+	default:
+		t.reordered++
+		result = SeqLate
+	}
+	t.received++
+	return result
+}
+
+// Stats returns the statistics so far.
+func (t *SeqTracker) Stats() SeqStats {
+	if !t.started || t.probation > 0 {
+		return SeqStats{Restarts: t.restarts}
+	}
+	extendedMax := t.cycles + uint32(t.maxSeq)
+	expected := uint64(extendedMax-t.baseSeq) + 1
+	return SeqStats{
+		ExtendedMax: extendedMax,
+		Cycles:      t.cycles >> 16,
+		Received:    t.received,
+		Expected:    expected,
+		Lost:        int64(expected) - int64(t.received),
+		Reordered:   t.reordered,
+		Restarts:    t.restarts,
+	}
+}
@@ -0,0 +1,173 @@
+package rtpfix
+
+import "testing"
+
+// TestSeqDiffAndLess validates sequence number ordering modulo 2^16. This
+// matters because loss counting, frame reordering and output numbering all
+// compare sequence numbers, and plain integer comparison breaks where 65535
+// wraps to 0. Inputs: every pair a few steps around the wrap, around 0 and
+// around the middle of the range, plus pairs 32767 apart. The expected output
+// is the signed distance of each pair and SeqLess agreeing with its sign.
+func TestSeqDiffAndLess(t *testing.T) {
+	for _, base := range []uint16{65530, 0, 32765} {
+		for i := 0; i < 12; i++ {
+			for j := 0; j < 12; j++ {
+				a, b := base+uint16(i), base+uint16(j)
+				if got := SeqDiff(a, b); got != i-j {
+					t.Fatalf("expected SeqDiff(%d, %d) = %d, got %d", a, b, i-j, got)
+				}
+				if got := SeqLess(a, b); got != (i < j) {
+					t.Fatalf("expected SeqLess(%d, %d) = %v, got %v", a, b, i < j, got)
+				}
+			}
+		}
+	}
+	if SeqDiff(32767, 0) != 32767 || SeqDiff(0, 32767) != -32767 {
+		t.Fatalf("expected pairs 32767 apart to keep their order")
+	}
+}
+
+// feedSeqs feeds seqs to tracker and returns the result of each.
+func feedSeqs(tracker *SeqTracker, seqs ...uint16) []SeqResult {
+	results := make([]SeqResult, len(seqs))
+	for i, seq := range seqs {
+		results[i] = tracker.Update(seq)
+	}
+	return results
+}
+
+func equalResults(got, want []SeqResult) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestSeqTracker_WrapAround validates that a stream crossing 65535 to 0 keeps
+// counting without loss. Inputs: every sequence number through the wrap up
+// to 100, started at each of the 39 offsets before 65535, so that the source
+// is validated before the wrap. The expected output is every packet in order,
+// one cycle, the extended highest number past 2^16, and as many packets
+// received as expected after the first one, which only opens the probation.
+func TestSeqTracker_WrapAround(t *testing.T) {
+	for start := 65496; start < 65535; start++ {
+		var tracker SeqTracker
+		count := 65536 + 101 - start
+		for i := 0; i < count; i++ {
+			if result := tracker.Update(uint16(start + i)); result != SeqNext {
+				t.Fatalf("start %d: expected packet %d in order, got %d", start, i, result)
+			}
+		}
+		stats := tracker.Stats()
+		if stats.Cycles != 1 || stats.ExtendedMax != 65536+100 {
+			t.Fatalf("start %d: expected one cycle up to %d, got %+v", start, 65536+100, stats)
+		}
+		if stats.Received != uint64(count-1) || stats.Expected != uint64(count-1) || stats.Lost != 0 || stats.Reordered != 0 {
+			t.Fatalf("start %d: expected %d received and none lost, got %+v", start, count-1, stats)
+		}
+	}
+}
+
+// TestSeqTracker_LossAndReorder validates gaps, late and duplicated packets
+// around the wrap. Inputs: 65533 and 65534 to validate the source, then 0
+// (skipping 65535), 65535 late, 1, 1 again, 4 (skipping 2 and 3) and 2 late.
+// The expected output is the result of each, one cycle, 7 packets expected
+// from 65534 to 4, 7 received of which 3 late or duplicated, and 0 lost as the
+// duplicate makes up for the packet 3 that never came.
+func TestSeqTracker_LossAndReorder(t *testing.T) {
+	var tracker SeqTracker
+	got := feedSeqs(&tracker, 65533, 65534, 0, 65535, 1, 1, 4, 2)
+	want := []SeqResult{SeqNext, SeqNext, SeqGap, SeqLate, SeqNext, SeqLate, SeqGap, SeqLate}
+	if !equalResults(got, want) {
+		t.Fatalf("expected results %v, got %v", want, got)
+	}
+	stats := tracker.Stats()
+	want2 := SeqStats{ExtendedMax: 65536 + 4, Cycles: 1, Received: 7, Expected: 7, Lost: 0, Reordered: 3}
+	if stats != want2 {
+		t.Fatalf("expected %+v, got %+v", want2, stats)
+	}
+}
+
+// TestSeqTracker_Restart validates restart detection. This matters because a
+// doorphone rebooting mid-call starts over from a random sequence number,
+// which must not count as tens of thousands of lost packets. Inputs: a
+// validated stream up to 105, a single stray packet 20000, the stream carrying
+// on at 106, then 40000 and 40001 for a restart, and a backward jump of 1000
+// confirmed the same way. The expected output is the stray packet reported as
+// a jump and ignored, the restart confirmed by its second packet, statistics
+// starting over from it, and two restarts in all.
+func TestSeqTracker_Restart(t *testing.T) {
+	var tracker SeqTracker
+	feedSeqs(&tracker, 100, 101, 102, 103, 104, 105)
+	got := feedSeqs(&tracker, 20000, 106, 40000, 40001, 40002)
+	want := []SeqResult{SeqJump, SeqNext, SeqJump, SeqRestart, SeqNext}
+	if !equalResults(got, want) {
+		t.Fatalf("expected results %v, got %v", want, got)
+	}
+	stats := tracker.Stats()
+	if stats.Restarts != 1 || stats.Received != 2 || stats.Expected != 2 || stats.ExtendedMax != 40002 {
+		t.Fatalf("expected statistics starting over at 40001, got %+v", stats)
+	}
+
+	got = feedSeqs(&tracker, 39003, 39004)
+	if !equalResults(got, []SeqResult{SeqJump, SeqRestart}) {
+		t.Fatalf("expected a backward jump confirmed as a restart, got %v", got)
+	}
+	if stats = tracker.Stats(); stats.Restarts != 2 || stats.Received != 1 || stats.Lost != 0 {
+		t.Fatalf("expected a second restart, got %+v", stats)
+	}
+}
+
+// TestSeqTracker_Boundaries validates the edges of RFC 3550's dropout and
+// misorder limits. Inputs: from a validated stream at 1000, a jump of
+// SeqMaxDropout-1, and from another, a jump of exactly SeqMaxDropout, a packet
+// SeqMaxMisorder-1 behind and one SeqMaxMisorder behind. The expected output
+// is a gap, a jump, a late packet and a jump.
+func TestSeqTracker_Boundaries(t *testing.T) {
+	var gap SeqTracker
+	feedSeqs(&gap, 999, 1000)
+	if result := gap.Update(1000 + SeqMaxDropout - 1); result != SeqGap {
+		t.Fatalf("expected a jump of %d to be a gap, got %d", SeqMaxDropout-1, result)
+	}
+	if stats := gap.Stats(); stats.Lost != SeqMaxDropout-2 {
+		t.Fatalf("expected %d lost, got %+v", SeqMaxDropout-2, stats)
+	}
+
+	var edges SeqTracker
+	feedSeqs(&edges, 999, 1000)
+	got := feedSeqs(&edges, 1000+SeqMaxDropout, 1000-SeqMaxMisorder+1, 1000-SeqMaxMisorder)
+	if !equalResults(got, []SeqResult{SeqJump, SeqLate, SeqJump}) {
+		t.Fatalf("expected jump, late, jump at the limits, got %v", got)
+	}
+}
+
+// TestSeqTracker_Probation validates source validation. Inputs: 10, 20 and 21,
+// where 20 breaks the run; then the zero value's statistics. The expected
+// output is 20 restarting the probation, nothing counted before 21 validates
+// the source, and zero statistics before any packet.
+func TestSeqTracker_Probation(t *testing.T) {
+	var tracker SeqTracker
+	if stats := tracker.Stats(); stats != (SeqStats{}) {
+		t.Fatalf("expected zero statistics, got %+v", stats)
+	}
+	if result := tracker.Update(10); result != SeqNext {
+		t.Fatalf("expected the first packet in order, got %d", result)
+	}
+	if result := tracker.Update(20); result != SeqProbation {
+		t.Fatalf("expected 20 to restart the probation, got %d", result)
+	}
+	if stats := tracker.Stats(); stats.Received != 0 {
+		t.Fatalf("expected nothing counted in probation, got %+v", stats)
+	}
+	if result := tracker.Update(21); result != SeqNext {
+		t.Fatalf("expected 21 to validate the source, got %d", result)
+	}
+	if stats := tracker.Stats(); stats.Received != 1 || stats.Expected != 1 || stats.ExtendedMax != 21 {
+		t.Fatalf("expected the statistics to start at 21, got %+v", stats)
+	}
+}
@@ -0,0 +1,231 @@
+package rtpfix
+
+import "strconv"
+
+// SPSInfo is what an H264 sequence parameter set tells about the pictures
+// that follow it: the displayed size, after frame cropping, and the profile
+// and level the encoder claims.
+type SPSInfo struct {
+	Width          int
+	Height         int
+	ProfileIDC     uint8
+	ConstraintSets uint8
+	LevelIDC       uint8
+}
+
+// Profile names the profile of profile_idc, "constrained baseline" when
+// constraint_set1 narrows baseline, or returns the number for profiles
+// doorphones do not send.
+func (s SPSInfo) Profile() string {
+	switch s.ProfileIDC {
+	case 66:
+		if s.ConstraintSets&0x40 != 0 {
+			return "constrained baseline"
+		}
+		return "baseline"
+	case 77:
+		return "main"
+	case 88:
+		return "extended"
+	case 100:
+		return "high"
+	case 110:
+		return "high 10"
+	case 122:
+		return "high 4:2:2"
+	case 244:
+		return "high 4:4:4"
+	}
+	return strconv.Itoa(int(s.ProfileIDC))
+}
+
+// Level formats level_idc as the level number, e.g. 31 as "3.1". Level 1b
+// is level_idc 9, or 11 with constraint_set3 in the baseline and main
+// profiles.
+func (s SPSInfo) Level() string {
+	if s.LevelIDC == 9 || s.LevelIDC == 11 && s.ConstraintSets&0x10 != 0 && (s.ProfileIDC == 66 || s.ProfileIDC == 77) {
+		return "1b"
+	}
+	return strconv.Itoa(int(s.LevelIDC/10)) + "." + strconv.Itoa(int(s.LevelIDC%10))
+}
+
+// ParseSPS decodes an H264 SPS NAL unit, header included, up to its frame
+// cropping (ITU-T H.264 7.3.2.1.1); the VUI that may follow is not read. It
+// fails for any other NAL unit and for an SPS cut short.
+func ParseSPS(nal []byte) (SPSInfo, bool) {
+	if len(nal) < 4 || nal[0]&0x1f != 7 {
+		return SPSInfo{}, false
+	}
+	r := bitReader{data: unescapeRBSP(nal[1:])}
+	info := SPSInfo{
+		ProfileIDC:     uint8(r.bits(8)),
+		ConstraintSets: uint8(r.bits(8)),
+		LevelIDC:       uint8(r.bits(8)),
+	}
+	r.ue() // seq_parameter_set_id
+	chromaFormat := uint32(1)
+	separateColourPlanes := false
+	switch info.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			separateColourPlanes = r.bit()
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if !r.bit() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				r.skipScalingList(size)
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := uint32(0); i < cycle && !r.failed; i++ {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMBs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMBsOnly := r.bit()
+	if !frameMBsOnly {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if r.bit() {
+		cropLeft, cropRight = int(r.ue()), int(r.ue())
+		cropTop, cropBottom = int(r.ue()), int(r.ue())
+	}
+	if r.failed {
+		return SPSInfo{}, false
+	}
+
+	fieldFactor := 2
+	if frameMBsOnly {
+		fieldFactor = 1
+	}
+	// CropUnitX and CropUnitY of equations 7-19 to 7-22.
+	cropUnitX, cropUnitY := 1, fieldFactor
+	if !separateColourPlanes && chromaFormat != 0 {
+		subWidth, subHeight := 2, 2
+		if chromaFormat == 2 {
+			subHeight = 1
+		}
+		if chromaFormat == 3 {
+			subWidth, subHeight = 1, 1
+		}
+		cropUnitX, cropUnitY = subWidth, subHeight*fieldFactor
+	}
+	info.Width = widthMBs*16 - cropUnitX*(cropLeft+cropRight)
+	info.Height = fieldFactor*heightMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+	if info.Width <= 0 || info.Height <= 0 {
+		return SPSInfo{}, false
+	}
+	return info, true
+}
+
+// unescapeRBSP drops the emulation prevention bytes, the 0x03 after two zero
+// bytes, from a NAL unit payload.
+func unescapeRBSP(payload []byte) []byte {
+	out := make([]byte, 0, len(payload))
+	zeros := 0
+	for _, b := range payload {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// bitReader reads the bits of an RBSP most significant first. Reading past
+// the end sets failed and returns zeros.
+type bitReader struct {
+	data   []byte
+	pos    int
+	failed bool
+}
+
+func (r *bitReader) bit() bool {
+	if r.pos >= len(r.data)*8 {
+		r.failed = true
+		return false
+	}
+	set := r.data[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return set
+}
+
+func (r *bitReader) bits(n int) uint32 {
+	var value uint32
+	for i := 0; i < n; i++ {
+		value <<= 1
+		if r.bit() {
+			value |= 1
+		}
+	}
+	return value
+}
+
+// ue reads an unsigned exp-Golomb code.
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for !r.bit() {
+		if r.failed || zeros == 31 {
+			r.failed = true
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se reads a signed exp-Golomb code.
+func (r *bitReader) se() int32 {
+	code := r.ue()
+	if code%2 == 1 {
+		return int32(code/2 + 1)
+	}
+	return -int32(code / 2)
+}
+
+// skipScalingList reads past a scaling_list of size entries (7.3.2.1.1.1).
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && !r.failed; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
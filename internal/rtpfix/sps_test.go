@@ -0,0 +1,79 @@
+package rtpfix
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestParseSPS validates the resolution, profile and level decoded from known
+// H264 SPS NAL units. This matters because support reads them from GET to
+// tell what a doorphone sends without a capture. Inputs: a baseline 640x480
+// SPS; the constrained baseline 640x360 and 1280x720 SPS of the doorphones in
+// testdata/normal.pcap; an x264 High 1920x1080 SPS, coded as 1088 lines with
+// 8 cropped at the bottom and carrying emulation prevention bytes; and an
+// x264 High 1280x720 SPS. The expected output is each displayed size with
+// its profile name and level.
+func TestParseSPS(t *testing.T) {
+	cases := []struct {
+		name    string
+		sps     string
+		width   int
+		height  int
+		profile string
+		level   string
+	}{
+		{name: "baseline 640x480", sps: "6742c01eda0280f640", width: 640, height: 480, profile: "constrained baseline", level: "3.0"},
+		{name: "doorphone 640x360", sps: "6742c01f8c680a02ff9601e1108d40", width: 640, height: 360, profile: "constrained baseline", level: "3.1"},
+		{name: "doorphone 1280x720", sps: "6742c0208c6805005ba01e1108d4", width: 1280, height: 720, profile: "constrained baseline", level: "3.2"},
+		{name: "high 1920x1080 cropped", sps: "67640028acd940780227e5c044000003000400000300f03c60c658", width: 1920, height: 1080, profile: "high", level: "4.0"},
+		{name: "high 1280x720", sps: "6764001facd9405005bb011000000300100000030320f1831960", width: 1280, height: 720, profile: "high", level: "3.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			nal, err := hex.DecodeString(tc.sps)
+			if err != nil {
+				t.Fatalf("decode hex: %v", err)
+			}
+			info, ok := ParseSPS(nal)
+			if !ok {
+				t.Fatalf("expected the SPS to parse")
+			}
+			if info.Width != tc.width || info.Height != tc.height {
+				t.Fatalf("expected %dx%d, got %dx%d", tc.width, tc.height, info.Width, info.Height)
+			}
+			if info.Profile() != tc.profile || info.Level() != tc.level {
+				t.Fatalf("expected profile %q level %q, got %q %q", tc.profile, tc.level, info.Profile(), info.Level())
+			}
+		})
+	}
+}
+
+// TestParseSPS_Rejects validates that ParseSPS fails instead of reporting a
+// made-up size. Inputs: a PPS, an empty payload and the 1080p SPS cut before
+// its picture size. The expected output is a failure for each.
+func TestParseSPS_Rejects(t *testing.T) {
+	for _, payload := range [][]byte{
+		{0x68, 0xee, 0x3c, 0x80},
+		{},
+		{0x67, 0x64, 0x00, 0x28, 0xac, 0xd9},
+	} {
+		if info, ok := ParseSPS(payload); ok {
+			t.Fatalf("expected % x to fail, got %+v", payload, info)
+		}
+	}
+}
+
+// TestSPSInfoLevel validates the level names that are not plain level_idc
+// divided by ten. Inputs: level_idc 11 with and without constraint_set3 in
+// baseline, and level_idc 9. The expected output is 1b, 1.1 and 1b.
+func TestSPSInfoLevel(t *testing.T) {
+	if got := (SPSInfo{ProfileIDC: 66, ConstraintSets: 0x10, LevelIDC: 11}).Level(); got != "1b" {
+		t.Fatalf("expected 1b, got %q", got)
+	}
+	if got := (SPSInfo{ProfileIDC: 66, LevelIDC: 11}).Level(); got != "1.1" {
+		t.Fatalf("expected 1.1, got %q", got)
+	}
+	if got := (SPSInfo{ProfileIDC: 100, LevelIDC: 9}).Level(); got != "1b" {
+		t.Fatalf("expected 1b, got %q", got)
+	}
+}
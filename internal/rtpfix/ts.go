@@ -0,0 +1,38 @@
+package rtpfix
+
+import "time"
+
+// TSAdd returns ts advanced by d at clockRate ticks per second, rounded to
+// the nearest tick. A negative d moves ts back; both wrap modulo 2^32.
+func TSAdd(ts uint32, d time.Duration, clockRate int) uint32 {
+	rate := time.Duration(clockRate)
+	// Whole seconds and the remainder apart, so that long durations at
+	// high clock rates do not overflow.
+	ticks := d / time.Second * rate
+	rem := d % time.Second * rate
+	if rem < 0 {
+		rem -= time.Second / 2
+	} else {
+		rem += time.Second / 2
+	}
+	ticks += rem / time.Second
+	return ts + uint32(int64(ticks))
+}
+
+// TSDiff returns a minus b for RTP timestamps, modulo 2^32: positive when a
+// comes after b, negative when it comes before. It holds across the wrap from
+// 0xFFFFFFFF to 0 as long as a and b are fewer than 2^31 ticks apart.
+func TSDiff(a, b uint32) int64 {
+	return int64(int32(a - b))
+}
+
+// TSToDuration converts a difference of diff ticks at clockRate ticks per
+// second to a duration, truncated toward zero. It returns 0 for a clock rate
+// that is not positive.
+func TSToDuration(diff int64, clockRate int) time.Duration {
+	if clockRate <= 0 {
+		return 0
+	}
+	rate := int64(clockRate)
+	return time.Duration(diff/rate)*time.Second + time.Duration(diff%rate)*time.Second/time.Duration(rate)
+}
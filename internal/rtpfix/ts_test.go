@@ -0,0 +1,94 @@
+package rtpfix
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTSAdd validates advancing an RTP timestamp by a duration. This matters
+// because the video fixer stamps each frame from the time elapsed since the
+// previous one, and a timestamp that stops at 0xFFFFFFFF or drifts through
+// rounding makes players stall or speed up. Inputs: 90 kHz and 8 kHz steps,
+// steps that need rounding, steps crossing 0xFFFFFFFF either way, and a day
+// long duration. The expected output is each advanced timestamp, wrapped
+// modulo 2^32.
+func TestTSAdd(t *testing.T) {
+	cases := []struct {
+		name string
+		ts   uint32
+		d    time.Duration
+		rate int
+		want uint32
+	}{
+		{name: "90kHz frame", ts: 1000, d: 40 * time.Millisecond, rate: 90000, want: 4600},
+		{name: "8kHz packet", ts: 1000, d: 20 * time.Millisecond, rate: 8000, want: 1160},
+		{name: "zero", ts: 1000, d: 0, rate: 90000, want: 1000},
+		{name: "rounds down", ts: 0, d: 5 * time.Microsecond, rate: 90000, want: 0},
+		{name: "rounds to nearest", ts: 0, d: 33333333 * time.Nanosecond, rate: 90000, want: 3000},
+		{name: "rounds up", ts: 0, d: 6 * time.Microsecond, rate: 90000, want: 1},
+		{name: "wraps forward", ts: 0xFFFFFF00, d: 40 * time.Millisecond, rate: 90000, want: 0x00000D10},
+		{name: "wraps back", ts: 0x00000060, d: -20 * time.Millisecond, rate: 8000, want: 0xFFFFFFC0},
+		{name: "back rounds away", ts: 100, d: -6 * time.Microsecond, rate: 90000, want: 99},
+		{name: "one day", ts: 0, d: 24 * time.Hour, rate: 90000, want: uint32(uint64(24*3600*90000) % (1 << 32))},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := TSAdd(tc.ts, tc.d, tc.rate); got != tc.want {
+				t.Fatalf("expected TSAdd(%#x, %v, %d) = %#x, got %#x", tc.ts, tc.d, tc.rate, tc.want, got)
+			}
+		})
+	}
+}
+
+// TestTSDiff validates the signed distance between two RTP timestamps.
+// Inputs: pairs in order, reversed, equal, straddling 0xFFFFFFFF either way,
+// and 2^31-1 apart. The expected output is each signed difference.
+func TestTSDiff(t *testing.T) {
+	cases := []struct {
+		a, b uint32
+		want int64
+	}{
+		{a: 1160, b: 1000, want: 160},
+		{a: 1000, b: 1160, want: -160},
+		{a: 5, b: 5, want: 0},
+		{a: 0x00000060, b: 0xFFFFFF00, want: 0x160},
+		{a: 0xFFFFFF00, b: 0x00000060, want: -0x160},
+		{a: 0, b: 0xFFFFFFFF, want: 1},
+		{a: 0xFFFFFFFF, b: 0, want: -1},
+		{a: 0x7FFFFFFF, b: 0, want: 0x7FFFFFFF},
+		{a: 0, b: 0x7FFFFFFF, want: -0x7FFFFFFF},
+	}
+	for _, tc := range cases {
+		if got := TSDiff(tc.a, tc.b); got != tc.want {
+			t.Fatalf("expected TSDiff(%#x, %#x) = %d, got %d", tc.a, tc.b, tc.want, got)
+		}
+	}
+}
+
+// TestTSToDuration validates converting timestamp differences to durations.
+// Inputs: 90 kHz and 8 kHz differences, negative ones, one that does not
+// divide evenly, the largest 32-bit difference, and a zero clock rate. The
+// expected output is each duration truncated toward zero, and 0 for the zero
+// rate.
+func TestTSToDuration(t *testing.T) {
+	cases := []struct {
+		diff int64
+		rate int
+		want time.Duration
+	}{
+		{diff: 3600, rate: 90000, want: 40 * time.Millisecond},
+		{diff: 160, rate: 8000, want: 20 * time.Millisecond},
+		{diff: -160, rate: 8000, want: -20 * time.Millisecond},
+		{diff: -24000, rate: 8000, want: -3 * time.Second},
+		{diff: 1, rate: 90000, want: 11111 * time.Nanosecond},
+		{diff: -1, rate: 90000, want: -11111 * time.Nanosecond},
+		{diff: 0x7FFFFFFF, rate: 90000, want: time.Duration(0x7FFFFFFF) * time.Second / 90000},
+		{diff: TSDiff(0x00000060, 0xFFFFFF00), rate: 8000, want: 44 * time.Millisecond},
+		{diff: 160, rate: 0, want: 0},
+	}
+	for _, tc := range cases {
+		if got := TSToDuration(tc.diff, tc.rate); got != tc.want {
+			t.Fatalf("expected TSToDuration(%d, %d) = %v, got %v", tc.diff, tc.rate, tc.want, got)
+		}
+	}
+}
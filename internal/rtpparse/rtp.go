@@ -50,3 +50,16 @@ func Parse(payload []byte) (Packet, error) {
 		HeaderSize:  headerSize,
 	}, nil
 }
+
+// IsRTCP reports whether payload is RTCP multiplexed on an RTP port (RFC
+// 5761): version 2 with a packet type of 192-223, which reads as RTP payload
+// type 64-95 once the marker bit is masked off. Those payload types are never
+// assigned to RTP for this reason, so dynamic RTP types from 96 are not
+// affected.
+func IsRTCP(payload []byte) bool {
+	if len(payload) < 4 || payload[0]>>6 != 2 {
+		return false
+	}
+	payloadType := payload[1] & 0x7f
+	return payloadType >= 64 && payloadType <= 95
+}
@@ -102,3 +102,45 @@ func TestRTPParse_MarkerBitAndPTExtraction(t *testing.T) {
 		t.Fatalf("unexpected payload type: got=%d want=35", parsed.PayloadType)
 	}
 }
+
+// TestIsRTCP_ClassifiesMuxedPackets validates the RTCP-mux demultiplexer the
+// proxies use to keep RTCP out of RTP analysis. The rule is that version 2
+// packets whose second byte is an RTCP packet type (192-223, i.e. payload type
+// 64-95 after masking the marker bit) are RTCP, while RTP is not, whatever
+// its marker bit. The synthetic inputs are minimal SR, RR, SDES and BYE
+// packets, RTP with payload types 0, 96 and 127 with and without marker, an
+// SR with version 1 and a 3-byte fragment. The expected outputs are true for
+// the four RTCP packets only, guarding against RTCP reaching the H264 fix
+// logic or RTP being forwarded as RTCP.
+func TestIsRTCP_ClassifiesMuxedPackets(t *testing.T) {
+	rtcp := map[string][]byte{
+		"sr":   {0x80, 200, 0x00, 0x06, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		"rr":   {0x80, 201, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04},
+		"sdes": {0x81, 202, 0x00, 0x03, 0x01, 0x02, 0x03, 0x04, 0x01, 0x02, 'a', 'b', 0x00, 0x00, 0x00, 0x00},
+		"bye":  {0x81, 203, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04},
+	}
+	for name, packet := range rtcp {
+		if !IsRTCP(packet) {
+			t.Fatalf("expected %s to be classified as RTCP", name)
+		}
+	}
+
+	for _, payloadType := range []uint8{0, 96, 127} {
+		for _, marker := range []bool{false, true} {
+			packet := buildRTPPacket(marker, payloadType, 1, 160, 0x0a0b0c0d, []byte{0x65})
+			if IsRTCP(packet) {
+				t.Fatalf("expected RTP with payload type %d marker=%v not to be RTCP", payloadType, marker)
+			}
+			if parsed, err := Parse(packet); err != nil || parsed.PayloadType != payloadType {
+				t.Fatalf("expected RTP to parse unchanged, got %+v err=%v", parsed, err)
+			}
+		}
+	}
+
+	if IsRTCP([]byte{0x40, 200, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04}) {
+		t.Fatalf("expected version 1 not to be RTCP")
+	}
+	if IsRTCP([]byte{0x80, 200, 0x00}) {
+		t.Fatalf("expected a truncated header not to be RTCP")
+	}
+}
@@ -5,16 +5,40 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 )
 
 var ErrNoPortsAvailable = errors.New("no available ports")
 
+// allocationPolicy decides where the search for free ports starts.
+type allocationPolicy int
+
+const (
+	// policyRotating continues after the last allocated port and wraps
+	// around, so a released port is reused as late as possible.
+	policyRotating allocationPolicy = iota
+	// policyLowest always hands out the lowest free ports.
+	policyLowest
+)
+
 type PortAllocator struct {
 	mu        sync.Mutex
 	min       int
 	max       int
 	available []int
 	inUse     map[int]bool
+	// quarantined ports stay out of the pool until the given time.
+	quarantined map[int]time.Time
+	// coolingDown holds released ports until the given time so that late
+	// packets of the old session cannot reach a new one.
+	coolingDown map[int]time.Time
+	cooldown    time.Duration
+	policy      allocationPolicy
+	// cursor is the port the next rotating search starts at.
+	cursor int
+	now    func() time.Time
+	// exhausted counts allocations that failed with ErrNoPortsAvailable.
+	exhausted uint64
 }
 
 func NewPortAllocator(minPort, maxPort int) (*PortAllocator, error) {
@@ -29,31 +53,171 @@ func NewPortAllocator(minPort, maxPort int) (*PortAllocator, error) {
 		available = append(available, port)
 	}
 	return &PortAllocator{
-		min:       minPort,
-		max:       maxPort,
-		available: available,
-		inUse:     make(map[int]bool),
+		min:         minPort,
+		max:         maxPort,
+		available:   available,
+		inUse:       make(map[int]bool),
+		quarantined: make(map[int]time.Time),
+		coolingDown: make(map[int]time.Time),
+		cursor:      minPort,
+		now:         time.Now,
 	}, nil
 }
 
+// SetReuseCooldown keeps released ports out of allocations for d. 0 returns
+// them to the pool immediately.
+func (p *PortAllocator) SetReuseCooldown(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cooldown = d
+}
+
+// searchStartLocked returns the index in p.available where the search for
+// free ports begins. p.mu must be held.
+func (p *PortAllocator) searchStartLocked() int {
+	if p.policy == policyLowest {
+		return 0
+	}
+	start := sort.SearchInts(p.available, p.cursor)
+	if start == len(p.available) {
+		return 0
+	}
+	return start
+}
+
+// takeLocked moves ports from the pool to the in-use set and advances the
+// cursor past the last of them. p.mu must be held.
+func (p *PortAllocator) takeLocked(ports []int) {
+	taken := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		taken[port] = true
+		p.inUse[port] = true
+	}
+	remaining := make([]int, 0, len(p.available))
+	for _, port := range p.available {
+		if !taken[port] {
+			remaining = append(remaining, port)
+		}
+	}
+	p.available = remaining
+	p.cursor = ports[len(ports)-1] + 1
+}
+
+// Allocate reserves count ports, starting the search where the allocation
+// policy says and wrapping around the range.
 func (p *PortAllocator) Allocate(count int) ([]int, error) {
 	if count <= 0 {
 		return nil, fmt.Errorf("invalid port request size %d", count)
 	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.liftHoldsLocked()
 	if count > len(p.available) {
+		p.exhausted++
 		return nil, ErrNoPortsAvailable
 	}
-	ports := append([]int(nil), p.available[:count]...)
-	p.available = append([]int(nil), p.available[count:]...)
-	for _, port := range ports {
-		p.inUse[port] = true
+	start := p.searchStartLocked()
+	ports := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		ports = append(ports, p.available[(start+i)%len(p.available)])
 	}
+	p.takeLocked(ports)
 	return ports, nil
 }
 
+// AllocatePairs reserves count RTP/RTCP port pairs, each an even port and the
+// odd port after it, and returns them flattened in allocation order. The
+// search starts where the allocation policy says and wraps around the range.
+// It fails with ErrNoPortsAvailable when fewer aligned pairs are free, even if
+// enough single ports are.
+func (p *PortAllocator) AllocatePairs(count int) ([]int, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("invalid port pair request size %d", count)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.liftHoldsLocked()
+	start := p.searchStartLocked()
+	ports := make([]int, 0, count*2)
+	for n := 0; n < len(p.available) && len(ports) < count*2; n++ {
+		i := (start + n) % len(p.available)
+		port := p.available[i]
+		if port%2 != 0 || i+1 >= len(p.available) || p.available[i+1] != port+1 {
+			continue
+		}
+		ports = append(ports, port, port+1)
+		// The odd port was consumed with its pair.
+		n++
+	}
+	if len(ports) < count*2 {
+		p.exhausted++
+		return nil, ErrNoPortsAvailable
+	}
+	p.takeLocked(ports)
+	return ports, nil
+}
+
+// ErrPortUnavailable is matched by PortUnavailableError.
+var ErrPortUnavailable = errors.New("port is not available")
+
+// PortUnavailableError names the requested port that could not be claimed.
+type PortUnavailableError struct {
+	Port   int
+	Reason string
+}
+
+func (e *PortUnavailableError) Error() string {
+	return fmt.Sprintf("port %d is not available: %s", e.Port, e.Reason)
+}
+
+func (e *PortUnavailableError) Unwrap() error {
+	return ErrPortUnavailable
+}
+
+// Claim takes exactly the given ports out of the pool, for callers that pinned
+// ports and for sessions re-created after a restart. It fails with a
+// PortUnavailableError and claims nothing when one of them is outside the
+// range, requested twice, in use or held back.
+func (p *PortAllocator) Claim(ports []int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.liftHoldsLocked()
+	free := make(map[int]bool, len(p.available))
+	for _, port := range p.available {
+		free[port] = true
+	}
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		switch {
+		case port < p.min || port > p.max:
+			return &PortUnavailableError{Port: port, Reason: fmt.Sprintf("outside the range %d-%d", p.min, p.max)}
+		case seen[port]:
+			return &PortUnavailableError{Port: port, Reason: "requested twice"}
+		case p.inUse[port]:
+			return &PortUnavailableError{Port: port, Reason: "in use"}
+		case !free[port]:
+			return &PortUnavailableError{Port: port, Reason: "held back after a recent release or bind conflict"}
+		}
+		seen[port] = true
+	}
+	if len(ports) > 0 {
+		p.takeLocked(ports)
+	}
+	return nil
+}
+
+// Release returns ports to the pool once the reuse cooldown has passed.
 func (p *PortAllocator) Release(ports []int) {
+	p.release(ports, p.cooldown)
+}
+
+// unclaim returns ports that were never bound straight to the pool, so that a
+// create which failed before binding can be retried with the same ports.
+func (p *PortAllocator) unclaim(ports []int) {
+	p.release(ports, 0)
+}
+
+func (p *PortAllocator) release(ports []int, cooldown time.Duration) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for _, port := range ports {
@@ -64,7 +228,115 @@ func (p *PortAllocator) Release(ports []int) {
 		if port < p.min || port > p.max {
 			continue
 		}
+		if p.heldLocked(port) {
+			continue
+		}
+		if cooldown > 0 {
+			p.coolingDown[port] = p.now().Add(cooldown)
+			continue
+		}
 		p.available = append(p.available, port)
 	}
 	sort.Ints(p.available)
 }
+
+// Quarantine keeps port out of allocations until the given time, typically
+// because another process holds it. A port in use is quarantined once it is
+// released.
+func (p *PortAllocator) Quarantine(port int, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if port < p.min || port > p.max {
+		return
+	}
+	p.quarantined[port] = until
+	for i, free := range p.available {
+		if free == port {
+			p.available = append(p.available[:i], p.available[i+1:]...)
+			break
+		}
+	}
+}
+
+// heldLocked reports whether port is quarantined or cooling down. p.mu must
+// be held.
+func (p *PortAllocator) heldLocked(port int) bool {
+	_, quarantined := p.quarantined[port]
+	_, cooling := p.coolingDown[port]
+	return quarantined || cooling
+}
+
+// liftHoldsLocked returns ports whose quarantine or cooldown expired to the
+// pool. p.mu must be held.
+func (p *PortAllocator) liftHoldsLocked() {
+	if len(p.quarantined) == 0 && len(p.coolingDown) == 0 {
+		return
+	}
+	now := p.now()
+	lifted := false
+	for _, holds := range []map[int]time.Time{p.quarantined, p.coolingDown} {
+		for port, until := range holds {
+			if now.Before(until) {
+				continue
+			}
+			delete(holds, port)
+			if !p.inUse[port] && !p.heldLocked(port) {
+				p.available = append(p.available, port)
+				lifted = true
+			}
+		}
+	}
+	if lifted {
+		sort.Ints(p.available)
+	}
+}
+
+// Free returns the number of ports that can be allocated right now.
+func (p *PortAllocator) Free() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.liftHoldsLocked()
+	return len(p.available)
+}
+
+// InUse returns the number of ports held by sessions.
+func (p *PortAllocator) InUse() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.inUse)
+}
+
+// Allocated reports whether port is held by a session.
+func (p *PortAllocator) Allocated(port int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inUse[port]
+}
+
+// PoolStats describes port pool usage. CoolingDown counts released ports
+// waiting out the reuse cooldown; BindConflicts counts binds that failed
+// because another process held the port; Exhausted counts allocations that
+// found too few free ports.
+type PoolStats struct {
+	Size          int
+	Free          int
+	InUse         int
+	Quarantined   int
+	CoolingDown   int
+	BindConflicts uint64
+	Exhausted     uint64
+}
+
+func (p *PortAllocator) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.liftHoldsLocked()
+	return PoolStats{
+		Size:        p.max - p.min + 1,
+		Free:        len(p.available),
+		InUse:       len(p.inUse),
+		Quarantined: len(p.quarantined),
+		CoolingDown: len(p.coolingDown),
+		Exhausted:   p.exhausted,
+	}
+}
@@ -1,6 +1,11 @@
 package session
 
-import "testing"
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
 
 // TestPortAllocator_AllocFreeReuse verifies that releasing a previously allocated
 // port makes it eligible for reuse and that reuse follows the allocator's
@@ -8,10 +13,10 @@ import "testing"
 // reuse for stable resource accounting and avoiding leaks. Preconditions: a
 // small contiguous range with no concurrent allocations. Inputs: allocate one
 // port from a 3-port range, release that exact port, then allocate one port
-// again. Edge case: reuse happens after a release and sorted availability. The
-// expected output is that the second allocation returns the same port because
-// Release inserts and sorts the available list, making the smallest port
-// deterministic. This is stable because the allocator uses a deterministic slice
+// again under the lowest-first policy. Edge case: reuse happens after a release
+// and sorted availability. The expected output is that the second allocation
+// returns the same port because Release inserts and sorts the available list,
+// making the smallest port deterministic. This is stable because the allocator uses a deterministic slice
 // and sort without randomness. Flakiness is avoided by using no goroutines,
 // sleeps, or time-based logic. A regression would manifest as a different port
 // being returned after release or the allocator failing to reuse a released port.
@@ -20,6 +25,7 @@ func TestPortAllocator_AllocFreeReuse(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	allocator.policy = policyLowest
 	ports, err := allocator.Allocate(1)
 	if err != nil {
 		t.Fatalf("unexpected alloc error: %v", err)
@@ -89,3 +95,243 @@ func TestPortAllocator_NoDuplicatesWhileAllocated(t *testing.T) {
 		seen[port] = true
 	}
 }
+
+// TestPortAllocator_StatsTracksUsage verifies that Stats reports the pool size
+// and the free/in-use split across allocate and release. This matters because
+// the health endpoint exposes these numbers to spot pool exhaustion before
+// creates start failing. Inputs: a ten-port range, an allocation of four ports
+// and a release of two. The expected output is 10/6/4 after allocation and
+// 10/8/2 after the release.
+func TestPortAllocator_StatsTracksUsage(t *testing.T) {
+	allocator, err := NewPortAllocator(13000, 13009)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ports, err := allocator.Allocate(4)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	if stats := allocator.Stats(); stats != (PoolStats{Size: 10, Free: 6, InUse: 4}) {
+		t.Fatalf("unexpected stats after allocate: %+v", stats)
+	}
+	allocator.Release(ports[:2])
+	if stats := allocator.Stats(); stats != (PoolStats{Size: 10, Free: 8, InUse: 2}) {
+		t.Fatalf("unexpected stats after release: %+v", stats)
+	}
+}
+
+// TestPortAllocator_AllocatePairsAligned verifies that pairs are an even RTP
+// port followed by its odd RTCP port, also when the range starts on an odd
+// port, and that released pairs are handed out again under the lowest-first
+// policy. Inputs: the range 15001-15009 and two pair allocations around a
+// release. The expected output is 15002/15003 and 15004/15005, then the same
+// pairs again.
+func TestPortAllocator_AllocatePairsAligned(t *testing.T) {
+	allocator, err := NewPortAllocator(15001, 15009)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allocator.policy = policyLowest
+	ports, err := allocator.AllocatePairs(2)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	want := []int{15002, 15003, 15004, 15005}
+	if !slices.Equal(ports, want) {
+		t.Fatalf("expected pairs %v, got %v", want, ports)
+	}
+	allocator.Release(ports)
+	again, err := allocator.AllocatePairs(2)
+	if err != nil {
+		t.Fatalf("unexpected second alloc error: %v", err)
+	}
+	if !slices.Equal(again, want) {
+		t.Fatalf("expected released pairs %v, got %v", want, again)
+	}
+}
+
+// TestPortAllocator_AllocatePairsFragmented verifies that free single ports
+// do not count as pairs. This matters because after churn the pool can hold
+// plenty of free ports none of which form an aligned pair, and handing out
+// unaligned ports breaks the RTP/RTCP convention downstream. Inputs: a
+// ten-port range where every even port is taken, then one freed even port
+// whose odd neighbour is also free. The expected output is
+// ErrNoPortsAvailable with five free ports and no change to the pool, then
+// exactly one pair.
+func TestPortAllocator_AllocatePairsFragmented(t *testing.T) {
+	allocator, err := NewPortAllocator(16000, 16009)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all, err := allocator.Allocate(10)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	allocator.Release([]int{16001, 16003, 16005, 16007, 16009})
+
+	if _, err := allocator.AllocatePairs(1); err != ErrNoPortsAvailable {
+		t.Fatalf("expected ErrNoPortsAvailable, got %v", err)
+	}
+	if stats := allocator.Stats(); stats.Free != 5 || stats.InUse != 5 {
+		t.Fatalf("expected failed pair request to leave the pool unchanged, got %+v", stats)
+	}
+
+	allocator.Release([]int{all[4]})
+	ports, err := allocator.AllocatePairs(1)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	if !slices.Equal(ports, []int{16004, 16005}) {
+		t.Fatalf("expected pair 16004/16005, got %v", ports)
+	}
+	if _, err := allocator.AllocatePairs(1); err != ErrNoPortsAvailable {
+		t.Fatalf("expected ErrNoPortsAvailable for a second pair, got %v", err)
+	}
+}
+
+// TestPortAllocator_QuarantineUntilExpiry verifies that a quarantined port is
+// skipped by allocations, is not returned to the pool by Release, and comes
+// back once the quarantine expires. Inputs: a two-port range, port 17000 in
+// use and quarantined for a minute, then released. The expected output is no
+// pair before expiry and the pair 17000/17001 after it.
+func TestPortAllocator_QuarantineUntilExpiry(t *testing.T) {
+	allocator, err := NewPortAllocator(17000, 17001)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	allocator.now = func() time.Time { return now }
+
+	ports, err := allocator.AllocatePairs(1)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	allocator.Quarantine(17000, now.Add(time.Minute))
+	allocator.Release(ports)
+	if stats := allocator.Stats(); stats.Free != 1 || stats.Quarantined != 1 {
+		t.Fatalf("unexpected stats while quarantined: %+v", stats)
+	}
+	if _, err := allocator.AllocatePairs(1); err != ErrNoPortsAvailable {
+		t.Fatalf("expected ErrNoPortsAvailable while quarantined, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	ports, err = allocator.AllocatePairs(1)
+	if err != nil {
+		t.Fatalf("unexpected alloc error after expiry: %v", err)
+	}
+	if !slices.Equal(ports, []int{17000, 17001}) {
+		t.Fatalf("expected pair 17000/17001, got %v", ports)
+	}
+}
+
+// TestPortAllocator_Claim verifies that exact ports can be claimed and that
+// a request with any unavailable port claims nothing and names that port.
+// This matters because a controller that pinned ports in SDP must learn which
+// one to change. Inputs: a four-port range, a claim of 18002/18003, then one
+// of 18000/18003, one outside the range and one naming a port twice. The
+// expected output is the first claim applied, the others rejected naming
+// 18003, 18004 and 18000, and 18000 still free.
+func TestPortAllocator_Claim(t *testing.T) {
+	allocator, err := NewPortAllocator(18000, 18003)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := allocator.Claim([]int{18002, 18003}); err != nil {
+		t.Fatalf("unexpected claim error: %v", err)
+	}
+	for _, tt := range []struct {
+		ports []int
+		port  int
+	}{
+		{[]int{18000, 18003}, 18003},
+		{[]int{18004}, 18004},
+		{[]int{18000, 18000}, 18000},
+	} {
+		var unavailable *PortUnavailableError
+		if err := allocator.Claim(tt.ports); !errors.As(err, &unavailable) || unavailable.Port != tt.port {
+			t.Fatalf("claim %v: expected port %d to be reported, got %v", tt.ports, tt.port, err)
+		}
+	}
+	if stats := allocator.Stats(); stats.Free != 2 || stats.InUse != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	ports, err := allocator.AllocatePairs(1)
+	if err != nil || !slices.Equal(ports, []int{18000, 18001}) {
+		t.Fatalf("expected pair 18000/18001, got %v err=%v", ports, err)
+	}
+}
+
+// TestPortAllocator_RotatesAndCoolsDown verifies that a just-released port is
+// not handed out again while late packets of its old session may still
+// arrive: allocation continues after the last allocated pair, and a released
+// pair is held for the reuse cooldown even when it is the only free one.
+// Inputs: a six-port range with a 5s cooldown, three pair allocations with a
+// release of the first pair before the third. The expected output is
+// 19000/19001, 19002/19003, 19004/19005 in turn, no pair until the cooldown
+// elapses and then 19000/19001.
+func TestPortAllocator_RotatesAndCoolsDown(t *testing.T) {
+	allocator, err := NewPortAllocator(19000, 19005)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	allocator.now = func() time.Time { return now }
+	allocator.SetReuseCooldown(5 * time.Second)
+
+	first, err := allocator.AllocatePairs(1)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	if _, err := allocator.AllocatePairs(1); err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	allocator.Release(first)
+	third, err := allocator.AllocatePairs(1)
+	if err != nil || !slices.Equal(third, []int{19004, 19005}) {
+		t.Fatalf("expected pair 19004/19005, got %v err=%v", third, err)
+	}
+	if stats := allocator.Stats(); stats.Free != 0 || stats.CoolingDown != 2 {
+		t.Fatalf("unexpected stats while cooling down: %+v", stats)
+	}
+
+	now = now.Add(5*time.Second - time.Millisecond)
+	if ports, err := allocator.AllocatePairs(1); err != ErrNoPortsAvailable {
+		t.Fatalf("expected ErrNoPortsAvailable before the cooldown elapsed, got %v err=%v", ports, err)
+	}
+	now = now.Add(time.Millisecond)
+	reused, err := allocator.AllocatePairs(1)
+	if err != nil || !slices.Equal(reused, first) {
+		t.Fatalf("expected pair %v after the cooldown, got %v err=%v", first, reused, err)
+	}
+}
+
+// TestPortAllocator_RotatingSkipsRecentlyFreed verifies that without a
+// cooldown the rotating policy still prefers ports that were free longest.
+// Inputs: a six-port range, two pair allocations, a release of the first pair
+// and another allocation. The expected output is 19104/19105 rather than the
+// just-released 19100/19101.
+func TestPortAllocator_RotatingSkipsRecentlyFreed(t *testing.T) {
+	allocator, err := NewPortAllocator(19100, 19105)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first, err := allocator.AllocatePairs(1)
+	if err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	if _, err := allocator.AllocatePairs(1); err != nil {
+		t.Fatalf("unexpected alloc error: %v", err)
+	}
+	allocator.Release(first)
+
+	next, err := allocator.AllocatePairs(1)
+	if err != nil || !slices.Equal(next, []int{19104, 19105}) {
+		t.Fatalf("expected pair 19104/19105, got %v err=%v", next, err)
+	}
+	wrapped, err := allocator.AllocatePairs(1)
+	if err != nil || !slices.Equal(wrapped, first) {
+		t.Fatalf("expected the search to wrap to %v, got %v err=%v", first, wrapped, err)
+	}
+}
@@ -9,34 +9,56 @@ import (
 	"sync/atomic"
 	"time"
 
-	"rtp-stream-cleaner/internal/logging"
 	"rtp-stream-cleaner/internal/rtpfix"
+	"rtp-stream-cleaner/internal/rtpparse"
 )
 
-const udpReadBufferSize = 2048
-
 type audioCounters struct {
-	aInPkts         atomic.Uint64
-	aInBytes        atomic.Uint64
-	bOutPkts        atomic.Uint64
-	bOutBytes       atomic.Uint64
-	bInPkts         atomic.Uint64
-	bInBytes        atomic.Uint64
-	aOutPkts        atomic.Uint64
-	aOutBytes       atomic.Uint64
-	drops           atomic.Uint64
-	ignoredDisabled atomic.Uint64
+	aInPkts              atomic.Uint64
+	aInBytes             atomic.Uint64
+	bOutPkts             atomic.Uint64
+	bOutBytes            atomic.Uint64
+	bInPkts              atomic.Uint64
+	bInBytes             atomic.Uint64
+	aOutPkts             atomic.Uint64
+	aOutBytes            atomic.Uint64
+	ignoredDisabled      atomic.Uint64
+	keepalivesSent       atomic.Uint64
+	activitySuppressed   atomic.Uint64
+	ssrcChanges          atomic.Uint64
+	peerRelearns         atomic.Uint64
+	peerSwitchesRejected atomic.Uint64
+	sourceRejected       atomic.Uint64
+	tsDiscontinuities    atomic.Uint64
+	aSeq                 seqCounters
+	aJitter              jitterStats
+	dtmf                 dtmfStats
+	rtcp                 rtcpCounters
+	dropCounters
 }
 
 type AudioCounters struct {
-	AInPkts   uint64
-	AInBytes  uint64
-	BOutPkts  uint64
-	BOutBytes uint64
-	BInPkts   uint64
-	BInBytes  uint64
-	AOutPkts  uint64
-	AOutBytes uint64
+	AInPkts              uint64
+	AInBytes             uint64
+	BOutPkts             uint64
+	BOutBytes            uint64
+	BInPkts              uint64
+	BInBytes             uint64
+	AOutPkts             uint64
+	AOutBytes            uint64
+	IgnoredDisabled      uint64
+	KeepalivesSent       uint64
+	ActivitySuppressed   uint64
+	SSRCChanges          uint64
+	PeerRelearns         uint64
+	PeerSwitchesRejected uint64
+	SourceRejected       uint64
+	TSDiscontinuities    uint64
+	ASeq                 SeqCounters
+	AJitter              JitterStats
+	DTMF                 DTMFStats
+	RTCP                 RTCPCounters
+	DropCounters
 }
 
 type audioProxy struct {
@@ -55,7 +77,12 @@ type audioProxy struct {
 	peerMu              sync.RWMutex
 	doorphonePeer       *net.UDPAddr
 	doorphoneLearnedAt  time.Time
+	doorphoneRelearnAt  time.Time
+	doorphoneLastSeen   time.Time
+	peerCandidate       peerCandidate
+	lastSender          *net.UDPAddr
 	lastMissingDestNsec atomic.Int64
+	hairpin             hairpinGuard
 }
 
 func newAudioProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) *audioProxy {
@@ -69,7 +96,7 @@ func newAudioProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWind
 		packetLog:          logConfig.PacketLog,
 		packetLogSampleN:   logConfig.PacketLogSampleN,
 		packetLogOnAnomaly: logConfig.PacketLogOnAnomaly,
-		logger:             logging.WithSessionID(session.ID),
+		logger:             session.Logger(),
 		ctx:                ctx,
 		cancel:             cancel,
 	}
@@ -92,6 +119,13 @@ func (p *audioProxy) start() {
 			p.logStatsLoop()
 		}()
 	}
+	if p.session.keepalive.Interval > 0 {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.session.keepaliveLoop(p.ctx, "audio", p.bConn, &p.session.audioCounters.bOutPkts, &p.session.audioCounters.keepalivesSent)
+		}()
+	}
 }
 
 func (p *audioProxy) stop() {
@@ -101,13 +135,18 @@ func (p *audioProxy) stop() {
 	p.wg.Wait()
 	_ = p.aConn.Close()
 	_ = p.bConn.Close()
+	p.logStats(true)
 }
 
 func (p *audioProxy) loopAIn() {
-	buffer := make([]byte, udpReadBufferSize)
+	buffer := make([]byte, p.session.readBufferSize())
 	var packetCount uint64
-	var lastSeq uint16
-	var hasLastSeq bool
+	var seqs rtpfix.SeqTracker
+	seqTracker := newSeqTracker(&p.session.audioCounters.aSeq)
+	jitterTracker := newJitterTracker(&p.session.audioCounters.aJitter, p.session.clockRates, 0)
+	dtmfDetector := newDTMFDetector(&p.session.audioCounters.dtmf, p.session.dtmfPT, p.session.clockRates)
+	tsDetector := newTSDiscontinuityDetector(&p.session.audioCounters.tsDiscontinuities, p.session.clockRates, p.session.tsJumpThreshold)
+	reader := newPacketReader(p.aConn, len(buffer))
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -115,7 +154,7 @@ func (p *audioProxy) loopAIn() {
 		default:
 		}
 		_ = p.aConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		n, addr, err := p.aConn.ReadFromUDP(buffer)
+		n, addr, err := reader.ReadFromUDP(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
@@ -126,39 +165,76 @@ func (p *audioProxy) loopAIn() {
 			p.logger.Error("audio a leg read failed", "error", err)
 			continue
 		}
-		p.session.markActivity(time.Now())
-		p.session.audioCounters.aInPkts.Add(1)
+		if p.session.dropTruncated("audio", "a", &p.session.audioCounters.dropCounters, n, addr) {
+			continue
+		}
+		if p.session.dropNonRTP("audio", "a", &p.session.audioCounters.dropCounters, buffer[:n], addr) {
+			continue
+		}
+		arrival := time.Now()
+		if p.session.refreshesActivity(&p.session.audioSSRCLock, buffer[:n]) {
+			p.session.markMediaActivity(&p.session.audioActivity, "audio", arrival)
+		} else {
+			p.session.audioCounters.activitySuppressed.Add(1)
+		}
+		if p.session.audioCounters.aInPkts.Add(1) == 1 {
+			p.session.recordHistory(time.Now(), HistoryFirstPacket, "audio", addr.String())
+		}
 		p.session.audioCounters.aInBytes.Add(uint64(n))
+		p.session.capturePacket(CaptureLegAIn, p.aConn, addr, buffer[:n])
 		if !p.session.audioEnabled.Load() {
 			p.session.audioCounters.ignoredDisabled.Add(1)
 			continue
 		}
-		p.logPacketIfNeeded(buffer[:n], n, "a->b", &packetCount, &lastSeq, &hasLastSeq)
+		if p.session.looped(&p.hairpin, buffer[:n], addr, arrival) {
+			p.session.audioCounters.ignoredDisabled.Add(1)
+			p.session.disableHairpin("audio", &p.session.audioEnabled, &p.session.audioDisabledReason, addr, arrival)
+			continue
+		}
+		// Muxed RTCP is relayed like RTP but kept out of the sequence tracking.
+		if rtpparse.IsRTCP(buffer[:n]) {
+			p.session.audioCounters.rtcp.muxPkts.Add(1)
+		} else {
+			p.logPacketIfNeeded(buffer[:n], n, "a->b", &packetCount, &seqs)
+			seqTracker.track(buffer[:n])
+			jitterTracker.track(buffer[:n], arrival)
+			dtmfDetector.track(buffer[:n], arrival)
+			if jumped, previous := tsDetector.track(buffer[:n], arrival); jumped {
+				p.logTSDiscontinuity(buffer[:n], previous)
+			}
+			if !p.session.admitSSRC(&p.session.audioSSRCLock, buffer[:n]) {
+				p.session.audioCounters.drop(dropForeignSSRC)
+				continue
+			}
+		}
 		if !p.updateDoorphonePeer(addr) {
-			p.session.audioCounters.drops.Add(1)
+			p.session.audioCounters.drop(dropPeerRejected)
 			continue
 		}
 		dest := p.session.audioDest.Load()
 		if dest == nil {
 			p.logMissingDest()
-			p.session.audioCounters.drops.Add(1)
+			p.session.audioCounters.drop(dropNoDest)
 			continue
 		}
+		p.session.audioSSRCRewrite.rewrite(buffer[:n])
 		if _, err := p.bConn.WriteToUDP(buffer[:n], dest); err != nil {
 			p.logger.Error("audio b leg write failed", "error", err)
-			p.session.audioCounters.drops.Add(1)
+			p.session.audioCounters.drop(dropWriteError)
 			continue
 		}
 		p.session.audioCounters.bOutPkts.Add(1)
 		p.session.audioCounters.bOutBytes.Add(uint64(n))
+		p.session.capturePacket(CaptureLegBOut, p.bConn, dest, buffer[:n])
+		p.hairpin.sent(buffer[:n], arrival)
 	}
 }
 
 func (p *audioProxy) loopBIn() {
-	buffer := make([]byte, udpReadBufferSize)
+	buffer := make([]byte, p.session.readBufferSize())
 	var packetCount uint64
-	var lastSeq uint16
-	var hasLastSeq bool
+	var seqs rtpfix.SeqTracker
+	reader := newPacketReader(p.bConn, len(buffer))
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -166,7 +242,7 @@ func (p *audioProxy) loopBIn() {
 		default:
 		}
 		_ = p.bConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		n, addr, err := p.bConn.ReadFromUDP(buffer)
+		n, addr, err := reader.ReadFromUDP(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
@@ -177,31 +253,51 @@ func (p *audioProxy) loopBIn() {
 			p.logger.Error("audio b leg read failed", "error", err)
 			continue
 		}
-		p.session.markActivity(time.Now())
+		if p.session.dropTruncated("audio", "b", &p.session.audioCounters.dropCounters, n, addr) {
+			continue
+		}
+		if p.session.dropNonRTP("audio", "b", &p.session.audioCounters.dropCounters, buffer[:n], addr) {
+			continue
+		}
+		if p.session.refreshesActivity(nil, buffer[:n]) {
+			p.session.markMediaActivity(&p.session.audioActivity, "audio", time.Now())
+		} else {
+			p.session.audioCounters.activitySuppressed.Add(1)
+		}
 		if !p.session.audioEnabled.Load() {
 			p.session.audioCounters.ignoredDisabled.Add(1)
 			continue
 		}
 		dest := p.session.audioDest.Load()
-		if dest == nil || !dest.IP.Equal(addr.IP) {
-			p.session.audioCounters.drops.Add(1)
+		if dest == nil {
+			p.session.audioCounters.drop(dropNoDest)
+			continue
+		}
+		if !p.session.fromRTPEngine(addr, dest.IP, dest.Port) {
+			p.session.audioCounters.drop(dropBSourceMismatch)
 			continue
 		}
 		p.session.audioCounters.bInPkts.Add(1)
 		p.session.audioCounters.bInBytes.Add(uint64(n))
-		p.logPacketIfNeeded(buffer[:n], n, "b->a", &packetCount, &lastSeq, &hasLastSeq)
+		p.session.capturePacket(CaptureLegBIn, p.bConn, addr, buffer[:n])
+		if rtpparse.IsRTCP(buffer[:n]) {
+			p.session.audioCounters.rtcp.muxPkts.Add(1)
+		} else {
+			p.logPacketIfNeeded(buffer[:n], n, "b->a", &packetCount, &seqs)
+		}
 		peer := p.getDoorphonePeer()
 		if peer == nil {
-			p.session.audioCounters.drops.Add(1)
+			p.session.audioCounters.drop(dropNoPeer)
 			continue
 		}
 		if _, err := p.aConn.WriteToUDP(buffer[:n], peer); err != nil {
 			p.logger.Error("audio a leg write failed", "error", err)
-			p.session.audioCounters.drops.Add(1)
+			p.session.audioCounters.drop(dropWriteError)
 			continue
 		}
 		p.session.audioCounters.aOutPkts.Add(1)
 		p.session.audioCounters.aOutBytes.Add(uint64(n))
+		p.session.capturePacket(CaptureLegAOut, p.aConn, peer, buffer[:n])
 	}
 }
 
@@ -212,19 +308,64 @@ func (p *audioProxy) updateDoorphonePeer(addr *net.UDPAddr) bool {
 	p.peerMu.Lock()
 	defer p.peerMu.Unlock()
 	now := time.Now()
-	if p.doorphonePeer == nil {
-		p.doorphonePeer = cloneUDPAddr(addr)
+	if !sameUDPAddr(p.lastSender, addr) {
+		p.lastSender = cloneUDPAddr(addr)
+	}
+	if source := p.session.audioSource.Load(); source != nil {
+		return p.admitPinnedSource(source, addr, now)
+	}
+	decision := decidePeer(p.doorphonePeer, addr, p.doorphoneLearnedAt, p.doorphoneLastSeen, now, p.peerLearningWindow, p.session.relearnAfter)
+	switch decision {
+	case peerAccept:
+		p.peerCandidate.reset()
+	case peerChange, peerRelearn:
+		if !p.peerCandidate.observe(addr, now, p.session.peerSwitchPackets) {
+			p.session.audioCounters.peerSwitchesRejected.Add(1)
+			return false
+		}
+	}
+	switch decision {
+	case peerReject:
+		return false
+	case peerLearn:
 		p.doorphoneLearnedAt = now
-		return true
+		p.session.recordHistory(now, HistoryPeerLearned, "audio", addr.String())
+	case peerChange:
+		p.doorphoneRelearnAt = now
+		p.session.recordHistory(now, HistoryPeerChanged, "audio", addr.String())
+	case peerRelearn:
+		p.doorphoneRelearnAt = now
+		p.session.audioCounters.peerRelearns.Add(1)
+		p.session.recordHistory(now, HistoryPeerRelearned, "audio", addr.String())
+		p.logger.Info("audio.peer.relearned",
+			"old_peer", p.doorphonePeer.String(),
+			"new_peer", addr.String(),
+			"silent_ms", now.Sub(p.doorphoneLastSeen).Milliseconds(),
+		)
+	}
+	if decision != peerAccept {
+		p.doorphonePeer = cloneUDPAddr(addr)
+	}
+	p.doorphoneLastSeen = now
+	return true
+}
+
+// admitPinnedSource takes the place of peer learning while the media has a
+// pinned source. Packets from elsewhere are rejected, and the peer follows
+// the sender so that B-leg packets return to the port it sends from.
+func (p *audioProxy) admitPinnedSource(source, addr *net.UDPAddr, now time.Time) bool {
+	if !sourceMatches(source, addr) {
+		p.session.audioCounters.sourceRejected.Add(1)
+		return false
 	}
-	if p.doorphonePeer.IP.Equal(addr.IP) && p.doorphonePeer.Port == addr.Port {
-		return true
+	if p.doorphonePeer == nil {
+		p.doorphoneLearnedAt = now
 	}
-	if now.Sub(p.doorphoneLearnedAt) <= p.peerLearningWindow {
+	if !sameUDPAddr(p.doorphonePeer, addr) {
 		p.doorphonePeer = cloneUDPAddr(addr)
-		return true
 	}
-	return false
+	p.doorphoneLastSeen = now
+	return true
 }
 
 func (p *audioProxy) getDoorphonePeer() *net.UDPAddr {
@@ -233,6 +374,17 @@ func (p *audioProxy) getDoorphonePeer() *net.UDPAddr {
 	return cloneUDPAddr(p.doorphonePeer)
 }
 
+func (p *audioProxy) doorphonePeerState() doorphonePeerState {
+	p.peerMu.RLock()
+	defer p.peerMu.RUnlock()
+	return doorphonePeerState{
+		peer:        cloneUDPAddr(p.doorphonePeer),
+		learnedAt:   p.doorphoneLearnedAt,
+		relearnedAt: p.doorphoneRelearnAt,
+		lastSender:  cloneUDPAddr(p.lastSender),
+	}
+}
+
 func (p *audioProxy) logMissingDest() {
 	now := time.Now().UnixNano()
 	last := p.lastMissingDestNsec.Load()
@@ -252,7 +404,6 @@ func (p *audioProxy) logStatsLoop() {
 		case <-ticker.C:
 			p.logStats(false)
 		case <-p.ctx.Done():
-			p.logStats(true)
 			return
 		}
 	}
@@ -266,6 +417,11 @@ func (p *audioProxy) logStats(final bool) {
 	bytesOut := counters.aOutBytes.Load() + counters.bOutBytes.Load()
 	drops := counters.drops.Load()
 	ignoredDisabled := counters.ignoredDisabled.Load()
+	activitySuppressed := counters.activitySuppressed.Load()
+	aSeq := counters.aSeq.snapshot()
+	aJitter := counters.aJitter.snapshot()
+	dtmfEvents := counters.dtmf.events.Load()
+	tsDiscontinuities := counters.tsDiscontinuities.Load()
 	enabled := p.session.audioEnabled.Load()
 	disabledReason := loadAtomicString(&p.session.audioDisabledReason)
 	if enabled {
@@ -279,6 +435,14 @@ func (p *audioProxy) logStats(final bool) {
 			"bytes_out", bytesOut,
 			"drops", drops,
 			"ignored_disabled", ignoredDisabled,
+			"activity_suppressed", activitySuppressed,
+			"a_seq_gaps", aSeq.Gaps,
+			"a_reordered", aSeq.Reordered,
+			"a_duplicates", aSeq.Duplicates,
+			"a_jitter_ms", aJitter.CurrentMS,
+			"a_jitter_max_ms", aJitter.MaxMS,
+			"dtmf_events", dtmfEvents,
+			"ts_discontinuities", tsDiscontinuities,
 			"enabled", enabled,
 			"disabled_reason", disabledReason,
 			"final", true,
@@ -292,12 +456,20 @@ func (p *audioProxy) logStats(final bool) {
 		"bytes_out", bytesOut,
 		"drops", drops,
 		"ignored_disabled", ignoredDisabled,
+		"activity_suppressed", activitySuppressed,
+		"a_seq_gaps", aSeq.Gaps,
+		"a_reordered", aSeq.Reordered,
+		"a_duplicates", aSeq.Duplicates,
+		"a_jitter_ms", aJitter.CurrentMS,
+		"a_jitter_max_ms", aJitter.MaxMS,
+		"dtmf_events", dtmfEvents,
+		"ts_discontinuities", tsDiscontinuities,
 		"enabled", enabled,
 		"disabled_reason", disabledReason,
 	)
 }
 
-func (p *audioProxy) logPacketIfNeeded(packet []byte, size int, direction string, packetCount *uint64, lastSeq *uint16, hasLastSeq *bool) {
+func (p *audioProxy) logPacketIfNeeded(packet []byte, size int, direction string, packetCount *uint64, seqs *rtpfix.SeqTracker) {
 	if !p.packetLog {
 		return
 	}
@@ -307,19 +479,7 @@ func (p *audioProxy) logPacketIfNeeded(packet []byte, size int, direction string
 		return
 	}
 	header, ok := rtpfix.ParseRTPHeader(packet)
-	anomaly := false
-	if !ok {
-		anomaly = true
-	} else {
-		if *hasLastSeq {
-			expected := *lastSeq + 1
-			if header.Seq != expected {
-				anomaly = true
-			}
-		}
-		*lastSeq = header.Seq
-		*hasLastSeq = true
-	}
+	anomaly := !ok || seqs.Update(header.Seq) != rtpfix.SeqNext
 	if anomaly && p.packetLogOnAnomaly {
 		p.logPacket("audio.proxy.packet.anomaly", direction, header, size)
 		return
@@ -329,6 +489,24 @@ func (p *audioProxy) logPacketIfNeeded(packet []byte, size int, direction string
 	}
 }
 
+// logTSDiscontinuity logs the timestamps on both sides of a jump of the A-leg
+// RTP timestamp when packet anomaly logging is on.
+func (p *audioProxy) logTSDiscontinuity(packet []byte, previous uint32) {
+	if !p.packetLog || !p.packetLogOnAnomaly {
+		return
+	}
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok {
+		return
+	}
+	p.logger.Debug("audio.proxy.ts_discontinuity",
+		"seq", header.Seq,
+		"ssrc", header.SSRC,
+		"ts_before", previous,
+		"ts_after", header.TS,
+	)
+}
+
 func (p *audioProxy) logPacket(msg, direction string, header rtpfix.RTPHeader, size int) {
 	p.logger.Debug(msg,
 		"direction", direction,
@@ -346,13 +524,26 @@ func snapshotAudioCounters(counters *audioCounters) AudioCounters {
 		return AudioCounters{}
 	}
 	return AudioCounters{
-		AInPkts:   counters.aInPkts.Load(),
-		AInBytes:  counters.aInBytes.Load(),
-		BOutPkts:  counters.bOutPkts.Load(),
-		BOutBytes: counters.bOutBytes.Load(),
-		BInPkts:   counters.bInPkts.Load(),
-		BInBytes:  counters.bInBytes.Load(),
-		AOutPkts:  counters.aOutPkts.Load(),
-		AOutBytes: counters.aOutBytes.Load(),
+		AInPkts:              counters.aInPkts.Load(),
+		AInBytes:             counters.aInBytes.Load(),
+		BOutPkts:             counters.bOutPkts.Load(),
+		BOutBytes:            counters.bOutBytes.Load(),
+		BInPkts:              counters.bInPkts.Load(),
+		BInBytes:             counters.bInBytes.Load(),
+		AOutPkts:             counters.aOutPkts.Load(),
+		AOutBytes:            counters.aOutBytes.Load(),
+		IgnoredDisabled:      counters.ignoredDisabled.Load(),
+		KeepalivesSent:       counters.keepalivesSent.Load(),
+		ActivitySuppressed:   counters.activitySuppressed.Load(),
+		SSRCChanges:          counters.ssrcChanges.Load(),
+		PeerRelearns:         counters.peerRelearns.Load(),
+		PeerSwitchesRejected: counters.peerSwitchesRejected.Load(),
+		SourceRejected:       counters.sourceRejected.Load(),
+		TSDiscontinuities:    counters.tsDiscontinuities.Load(),
+		ASeq:                 counters.aSeq.snapshot(),
+		AJitter:              counters.aJitter.snapshot(),
+		DTMF:                 counters.dtmf.snapshot(),
+		RTCP:                 counters.rtcp.snapshot(),
+		DropCounters:         counters.dropCounters.snapshot(),
 	}
 }
@@ -0,0 +1,192 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// bindConflictRetries bounds how often a create picks fresh ports after
+	// another process turned out to hold one of the allocated ports.
+	bindConflictRetries = 3
+	// bindConflictCooldown keeps a conflicting port out of the pool so that
+	// creates do not keep tripping over it.
+	bindConflictCooldown = time.Minute
+)
+
+// legSockets are the bound RTP and RTCP sockets of a new session; media that
+// was not requested has none.
+type legSockets struct {
+	audioA, audioB, videoA, videoB                 *net.UDPConn
+	audioRTCPA, audioRTCPB, videoRTCPA, videoRTCPB *net.UDPConn
+}
+
+func (s legSockets) close() {
+	for _, conn := range []*net.UDPConn{s.audioA, s.audioB, s.videoA, s.videoB, s.audioRTCPA, s.audioRTCPB, s.videoRTCPA, s.videoRTCPB} {
+		closeConn(conn)
+	}
+}
+
+// assignPorts hands the allocated pairs to the legs in order: audio A, audio
+// B, video A, video B. Each leg takes the even port of its pair.
+func (s *Session) assignPorts(ports []int, audio, video bool) {
+	next := ports
+	if audio {
+		s.Audio.APort, s.Audio.BPort = next[0], next[2]
+		next = next[4:]
+	}
+	if video {
+		s.Video.APort, s.Video.BPort = next[0], next[2]
+	}
+}
+
+// allocatePorts takes the ports of the requested legs in the order
+// assignPorts expects. Every leg gets an even RTP port with the odd port
+// after it reserved for RTCP, as downstream equipment expects. Pinned legs
+// claim their pair as given and the others are allocated; if either step
+// fails, the claimed ports go straight back to the pool.
+func (m *Manager) allocatePorts(opts CreateOptions) ([]int, error) {
+	var legs []int
+	if !opts.DisableAudio {
+		legs = append(legs, opts.Ports.AudioA, opts.Ports.AudioB)
+	}
+	if !opts.DisableVideo {
+		legs = append(legs, opts.Ports.VideoA, opts.Ports.VideoB)
+	}
+	var pinned []int
+	free := 0
+	for _, port := range legs {
+		if port == 0 {
+			free++
+			continue
+		}
+		pinned = append(pinned, port, port+1)
+	}
+	if err := m.allocator.Claim(pinned); err != nil {
+		return nil, err
+	}
+	var allocated []int
+	if free > 0 {
+		var err error
+		if allocated, err = m.allocator.AllocatePairs(free); err != nil {
+			m.allocator.unclaim(pinned)
+			return nil, err
+		}
+	}
+	ports := make([]int, 0, len(legs)*2)
+	for _, port := range legs {
+		if port == 0 {
+			ports, allocated = append(ports, allocated[:2]...), allocated[2:]
+			continue
+		}
+		ports = append(ports, port, port+1)
+	}
+	return ports, nil
+}
+
+// bindLegs binds the RTP and RTCP sockets of the session's assigned ports. A port that
+// another process already holds is quarantined in the allocator and the
+// session moves to freshly allocated pairs, up to bindConflictRetries times.
+// A session with pinned ports is not moved and fails with a
+// PortUnavailableError instead. On failure every port of the session is
+// released.
+func (m *Manager) bindLegs(session *Session, audio, video, pinned bool) (legSockets, error) {
+	for attempt := 0; ; attempt++ {
+		sockets, conflictPort, err := m.listenLegs(session, audio, video)
+		if err == nil {
+			return sockets, nil
+		}
+		ports := session.ports()
+		if conflictPort != 0 {
+			m.bindConflicts.Add(1)
+			m.allocator.Quarantine(conflictPort, m.now().Add(bindConflictCooldown))
+		}
+		m.allocator.Release(ports)
+		if conflictPort != 0 && pinned {
+			return legSockets{}, &PortUnavailableError{Port: conflictPort, Reason: "bound by another process"}
+		}
+		if conflictPort == 0 || attempt >= bindConflictRetries {
+			return legSockets{}, err
+		}
+		session.Logger().Warn("session.port bind conflict", "port", conflictPort, "attempt", attempt+1, "error", err)
+		next, err := m.allocator.AllocatePairs(len(ports) / 2)
+		if err != nil {
+			return legSockets{}, err
+		}
+		session.assignPorts(next, audio, video)
+	}
+}
+
+// listenLegs binds the RTP socket of each leg and the RTCP socket on the
+// port after it, marks and sizes them. When a port is taken by another process it is returned as
+// conflictPort so that the caller can retry.
+func (m *Manager) listenLegs(session *Session, audio, video bool) (sockets legSockets, conflictPort int, err error) {
+	type leg struct {
+		media string
+		name  string
+		ip    net.IP
+		port  int
+		conn  **net.UDPConn
+	}
+	ipA, ipB := bindIP(m.bindIPA), bindIP(m.bindIPB)
+	var legs []leg
+	if audio {
+		legs = append(legs,
+			leg{"audio", "a", ipA, session.Audio.APort, &sockets.audioA}, leg{"audio", "a rtcp", ipA, session.Audio.APort + 1, &sockets.audioRTCPA},
+			leg{"audio", "b", ipB, session.Audio.BPort, &sockets.audioB}, leg{"audio", "b rtcp", ipB, session.Audio.BPort + 1, &sockets.audioRTCPB})
+	}
+	if video {
+		legs = append(legs,
+			leg{"video", "a", ipA, session.Video.APort, &sockets.videoA}, leg{"video", "a rtcp", ipA, session.Video.APort + 1, &sockets.videoRTCPA},
+			leg{"video", "b", ipB, session.Video.BPort, &sockets.videoB}, leg{"video", "b rtcp", ipB, session.Video.BPort + 1, &sockets.videoRTCPB})
+	}
+	var markErr, sizeErr error
+	session.socketInodes = session.socketInodes[:0]
+	for _, l := range legs {
+		conn, err := m.listenUDP("udp", &net.UDPAddr{IP: l.ip, Port: l.port})
+		if err != nil {
+			sockets.close()
+			if errors.Is(err, syscall.EADDRINUSE) {
+				conflictPort = l.port
+			}
+			return legSockets{}, conflictPort, fmt.Errorf("%s %s socket: %w", l.media, l.name, err)
+		}
+		*l.conn = conn
+		if err := m.markSocket(conn, session.Settings.Marking); err != nil && markErr == nil {
+			markErr = fmt.Errorf("%s %s socket: %w", l.media, l.name, err)
+		}
+		effective, err := sizeSocket(conn, m.socketBuffers)
+		if err != nil && sizeErr == nil {
+			sizeErr = fmt.Errorf("%s %s socket: %w", l.media, l.name, err)
+		}
+		if err == nil && m.socketBuffers != (SocketBuffers{}) {
+			// The kernel may clamp the sizes; they are the same for every
+			// socket, so the first one tells.
+			m.socketBuffersLogged.Do(func() {
+				m.logger().Info("session.socket buffers", "rcvbuf", m.socketBuffers.Read, "effective_rcvbuf", effective.Read, "sndbuf", m.socketBuffers.Write, "effective_sndbuf", effective.Write)
+			})
+		}
+		if inode := connInode(conn); inode != 0 {
+			session.socketInodes = append(session.socketInodes, inode)
+		}
+	}
+	// Marking is best effort: some containers forbid it, and unmarked media
+	// beats no media.
+	if markErr != nil {
+		session.Logger().Warn("session.socket marking failed", "dscp", session.Settings.Marking.DSCP, "ttl", session.Settings.Marking.TTL, "error", markErr)
+	}
+	if sizeErr != nil {
+		session.Logger().Warn("session.socket buffers failed", "rcvbuf", m.socketBuffers.Read, "sndbuf", m.socketBuffers.Write, "error", sizeErr)
+	}
+	return sockets, 0, nil
+}
+
+func bindIP(ip net.IP) net.IP {
+	if ip == nil {
+		return net.IPv4zero
+	}
+	return ip
+}
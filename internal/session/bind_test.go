@@ -0,0 +1,197 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func addrInUse() error {
+	return &net.OpError{Op: "listen", Net: "udp", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+}
+
+// TestManager_Create_RetriesOnBindConflict verifies that a port held by
+// another process does not fail the create: the port is quarantined, the
+// session moves to fresh pairs and the conflict is counted. Inputs: a
+// listenUDP that reports EADDRINUSE for 14000. The expected output is a
+// session whose audio A port is not 14000, one bind conflict, one quarantined
+// port and only the new session's ports in use.
+func TestManager_Create_RetriesOnBindConflict(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.allocator.now = manager.now
+	manager.listenUDP = func(_ string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		if laddr.Port == 14000 {
+			return nil, addrInUse()
+		}
+		return nil, nil
+	}
+
+	created, err := manager.Create("call-bind", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if created.Audio.APort == 14000 || created.Audio.APort%2 != 0 {
+		t.Fatalf("expected an even audio port other than 14000, got %d", created.Audio.APort)
+	}
+	stats := manager.PoolStats()
+	if stats.BindConflicts != 1 || stats.Quarantined != 1 || stats.InUse != 8 {
+		t.Fatalf("unexpected pool stats %+v", stats)
+	}
+}
+
+// TestManager_Create_BindConflictGivesUp verifies that the retries are
+// bounded and that nothing leaks when every bind conflicts. Inputs: a
+// listenUDP that always reports EADDRINUSE. The expected output is an
+// EADDRINUSE error after 1+bindConflictRetries attempts, that many conflicts
+// and quarantined ports, and no ports left in use.
+func TestManager_Create_BindConflictGivesUp(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.allocator.now = manager.now
+	manager.listenUDP = func(string, *net.UDPAddr) (*net.UDPConn, error) {
+		return nil, addrInUse()
+	}
+
+	if _, err := manager.Create("call-bind", "from", "to", true, true, false); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("expected EADDRINUSE, got %v", err)
+	}
+	stats := manager.PoolStats()
+	if stats.BindConflicts != bindConflictRetries+1 || stats.Quarantined != bindConflictRetries+1 || stats.InUse != 0 {
+		t.Fatalf("unexpected pool stats %+v", stats)
+	}
+	if len(manager.List()) != 0 {
+		t.Fatalf("expected no session")
+	}
+}
+
+// TestManager_Create_OtherBindErrorNotRetried verifies that only EADDRINUSE
+// triggers a retry. Inputs: a listenUDP failing with EACCES. The expected
+// output is the error on the first attempt, no conflicts and a free pool.
+func TestManager_Create_OtherBindErrorNotRetried(t *testing.T) {
+	manager := newTestManager(t, 0)
+	calls := 0
+	manager.listenUDP = func(string, *net.UDPAddr) (*net.UDPConn, error) {
+		calls++
+		return nil, &net.OpError{Op: "listen", Net: "udp", Err: os.NewSyscallError("bind", syscall.EACCES)}
+	}
+
+	if _, err := manager.Create("call-bind", "from", "to", true, false, false); !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("expected EACCES, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one bind attempt, got %d", calls)
+	}
+	if stats := manager.PoolStats(); stats.BindConflicts != 0 || stats.InUse != 0 {
+		t.Fatalf("unexpected pool stats %+v", stats)
+	}
+}
+
+// TestManager_Create_BindsConfiguredAddresses verifies that the A and B leg
+// sockets bind to the addresses set with SetBindIPs, and to 0.0.0.0 when
+// none is set. Inputs: an A leg address, no B leg address and an
+// audio+video create. The expected output is both A sockets on 10.10.0.5 and
+// both B sockets on 0.0.0.0.
+func TestManager_Create_BindsConfiguredAddresses(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.SetBindIPs(net.IPv4(10, 10, 0, 5), nil)
+	bound := make(map[int]string)
+	manager.listenUDP = func(_ string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		bound[laddr.Port] = laddr.IP.String()
+		return nil, nil
+	}
+
+	created, err := manager.Create("call-bind-ip", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	for _, port := range []int{created.Audio.APort, created.Video.APort} {
+		if bound[port] != "10.10.0.5" {
+			t.Fatalf("expected A leg port %d on 10.10.0.5, got %q", port, bound[port])
+		}
+	}
+	for _, port := range []int{created.Audio.BPort, created.Video.BPort} {
+		if bound[port] != "0.0.0.0" {
+			t.Fatalf("expected B leg port %d on 0.0.0.0, got %q", port, bound[port])
+		}
+	}
+}
+
+// TestManager_Create_PinnedPorts verifies caller-pinned ports: pinned legs
+// get exactly the requested pairs while the others are allocated, a port that
+// is out of range or already taken fails the create without leaking ports,
+// and a deleted session's pinned ports can be pinned again. Inputs: an
+// audio+video create pinning audio to 14020/14022, creates pinning the
+// already allocated video A port, 13998 and the held 14020, and a repeat of
+// the first pin after delete. The expected output is the pinned audio ports,
+// PortUnavailableError naming 14020, the video port and 13998 with 8 ports
+// still in use, and a second session on 14020.
+func TestManager_Create_PinnedPorts(t *testing.T) {
+	manager := newTestManager(t, 0)
+	pinAudio := CreateOptions{Ports: LegPorts{AudioA: 14020, AudioB: 14022}}
+
+	created, err := manager.CreateWithOptions("call-pin", "from", "to", false, pinAudio)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if created.Audio.APort != 14020 || created.Audio.BPort != 14022 {
+		t.Fatalf("expected audio on 14020/14022, got %d/%d", created.Audio.APort, created.Audio.BPort)
+	}
+	if created.Video.APort%2 != 0 || created.Video.APort == 14020 || created.Video.APort == 14022 {
+		t.Fatalf("expected allocated even video ports, got %d/%d", created.Video.APort, created.Video.BPort)
+	}
+
+	for _, conflict := range []struct {
+		opts CreateOptions
+		port int
+	}{
+		{CreateOptions{DisableVideo: true, Ports: LegPorts{AudioA: created.Video.APort}}, created.Video.APort},
+		{CreateOptions{DisableVideo: true, Ports: LegPorts{AudioA: 13998}}, 13998},
+		{CreateOptions{DisableAudio: true, Ports: LegPorts{VideoB: 14020}}, 14020},
+	} {
+		_, err := manager.CreateWithOptions("call-conflict", "from", "to", false, conflict.opts)
+		var unavailable *PortUnavailableError
+		if !errors.As(err, &unavailable) || unavailable.Port != conflict.port {
+			t.Fatalf("expected port %d to be unavailable, got %v", conflict.port, err)
+		}
+	}
+	if stats := manager.PoolStats(); stats.InUse != 8 {
+		t.Fatalf("expected only the first session's 8 ports in use, got %+v", stats)
+	}
+
+	if !manager.Delete(created.ID) {
+		t.Fatalf("expected delete to succeed")
+	}
+	again, err := manager.CreateWithOptions("call-pin-again", "from", "to", false, pinAudio)
+	if err != nil {
+		t.Fatalf("expected released pinned ports to be reusable, got %v", err)
+	}
+	if again.Audio.APort != 14020 {
+		t.Fatalf("expected audio on 14020, got %d", again.Audio.APort)
+	}
+}
+
+// TestManager_Create_PinnedPortBoundElsewhere verifies that a pinned port
+// held by another process fails the create instead of moving the session to
+// other ports. Inputs: a listenUDP that reports EADDRINUSE for 14020 and an
+// audio-only create pinning 14020. The expected output is a
+// PortUnavailableError naming 14020 after a single conflict and no ports in
+// use.
+func TestManager_Create_PinnedPortBoundElsewhere(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.listenUDP = func(_ string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		if laddr.Port == 14020 {
+			return nil, addrInUse()
+		}
+		return nil, nil
+	}
+
+	_, err := manager.CreateWithOptions("call-pin", "from", "to", false, CreateOptions{DisableVideo: true, Ports: LegPorts{AudioA: 14020}})
+	var unavailable *PortUnavailableError
+	if !errors.As(err, &unavailable) || unavailable.Port != 14020 {
+		t.Fatalf("expected port 14020 to be unavailable, got %v", err)
+	}
+	if stats := manager.PoolStats(); stats.BindConflicts != 1 || stats.InUse != 0 {
+		t.Fatalf("unexpected pool stats %+v", stats)
+	}
+}
@@ -0,0 +1,323 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/pcapio"
+)
+
+// CaptureLeg names one packet direction of a session proxy.
+type CaptureLeg string
+
+const (
+	CaptureLegAIn  CaptureLeg = "a_in"
+	CaptureLegAOut CaptureLeg = "a_out"
+	CaptureLegBIn  CaptureLeg = "b_in"
+	CaptureLegBOut CaptureLeg = "b_out"
+)
+
+// captureBufferSize bounds how many packets may wait for the capture writer
+// before further packets are dropped from the capture.
+const captureBufferSize = 1024
+
+// pcapFileHeaderSize and pcapRecordOverhead mirror the pcap global header and
+// the per-packet record header plus synthetic Ethernet, IPv4 and UDP headers
+// written by pcapio.Writer, so max_bytes bounds the real file size. Records
+// with an IPv6 address carry an IPv6 header instead.
+const (
+	pcapFileHeaderSize     = 24
+	pcapRecordOverhead     = 16 + 14 + 20 + 8
+	pcapRecordOverheadIPv6 = 16 + 14 + 40 + 8
+)
+
+var (
+	ErrCaptureDisabled   = errors.New("capture directory is not configured")
+	ErrCaptureActive     = errors.New("capture already active")
+	ErrInvalidCaptureLeg = errors.New("invalid capture leg")
+)
+
+// CaptureOptions selects what a session capture records. Empty Legs captures
+// every leg; a zero MaxBytes leaves the file size unbounded.
+type CaptureOptions struct {
+	Legs     []CaptureLeg
+	MaxBytes int64
+}
+
+// CaptureState describes the current or most recent capture of a session.
+type CaptureState struct {
+	Active       bool
+	Path         string
+	Legs         []CaptureLeg
+	MaxBytes     int64
+	BytesWritten uint64
+	Packets      uint64
+	Dropped      uint64
+}
+
+type captureRecord struct {
+	ts      time.Time
+	src     *net.UDPAddr
+	dst     *net.UDPAddr
+	payload []byte
+}
+
+type sessionCapture struct {
+	path     string
+	legs     []CaptureLeg
+	legMask  uint8
+	maxBytes int64
+	writer   *pcapio.Writer
+	records  chan captureRecord
+	done     chan struct{}
+	written  atomic.Uint64
+	packets  atomic.Uint64
+	dropped  atomic.Uint64
+	mu       sync.RWMutex
+	stopped  bool
+}
+
+// ParseCaptureLegs validates leg names. An empty list selects every leg.
+func ParseCaptureLegs(names []string) ([]CaptureLeg, error) {
+	if len(names) == 0 {
+		return allCaptureLegs(), nil
+	}
+	legs := make([]CaptureLeg, 0, len(names))
+	for _, name := range names {
+		leg := CaptureLeg(name)
+		if captureLegBit(leg) == 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCaptureLeg, name)
+		}
+		legs = append(legs, leg)
+	}
+	return legs, nil
+}
+
+func captureLegBit(leg CaptureLeg) uint8 {
+	switch leg {
+	case CaptureLegAIn:
+		return 1 << 0
+	case CaptureLegAOut:
+		return 1 << 1
+	case CaptureLegBIn:
+		return 1 << 2
+	case CaptureLegBOut:
+		return 1 << 3
+	default:
+		return 0
+	}
+}
+
+func newSessionCapture(path string, legs []CaptureLeg, maxBytes int64) (*sessionCapture, error) {
+	writer, err := pcapio.NewWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	capture := &sessionCapture{
+		path:     path,
+		legs:     legs,
+		maxBytes: maxBytes,
+		writer:   writer,
+		records:  make(chan captureRecord, captureBufferSize),
+		done:     make(chan struct{}),
+	}
+	for _, leg := range legs {
+		capture.legMask |= captureLegBit(leg)
+	}
+	capture.written.Store(pcapFileHeaderSize)
+	go capture.run()
+	return capture, nil
+}
+
+// tee queues a copy of payload for the writer. It never blocks: when the
+// buffer is full the packet is counted as dropped.
+func (c *sessionCapture) tee(leg CaptureLeg, src, dst *net.UDPAddr, payload []byte) {
+	if c.legMask&captureLegBit(leg) == 0 {
+		return
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stopped {
+		return
+	}
+	record := captureRecord{ts: time.Now(), src: src, dst: dst, payload: append([]byte(nil), payload...)}
+	select {
+	case c.records <- record:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+func (c *sessionCapture) run() {
+	defer close(c.done)
+	for record := range c.records {
+		overhead := pcapRecordOverhead
+		if udpAddrIP(record.src).To4() == nil || udpAddrIP(record.dst).To4() == nil {
+			overhead = pcapRecordOverheadIPv6
+		}
+		size := uint64(overhead + len(record.payload))
+		if c.maxBytes > 0 && c.written.Load()+size > uint64(c.maxBytes) {
+			c.dropped.Add(1)
+			continue
+		}
+		if err := c.writer.WritePacket(record.ts, udpAddrIP(record.src), udpAddrIP(record.dst), udpAddrPort(record.src), udpAddrPort(record.dst), record.payload); err != nil {
+			c.dropped.Add(1)
+			continue
+		}
+		c.written.Add(size)
+		c.packets.Add(1)
+	}
+}
+
+// stop flushes queued packets and closes the file. It is safe to call twice.
+func (c *sessionCapture) stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	close(c.records)
+	c.mu.Unlock()
+	<-c.done
+	return c.writer.Close()
+}
+
+func (c *sessionCapture) state() CaptureState {
+	c.mu.RLock()
+	active := !c.stopped
+	c.mu.RUnlock()
+	return CaptureState{
+		Active:       active,
+		Path:         c.path,
+		Legs:         append([]CaptureLeg(nil), c.legs...),
+		MaxBytes:     c.maxBytes,
+		BytesWritten: c.written.Load(),
+		Packets:      c.packets.Load(),
+		Dropped:      c.dropped.Load(),
+	}
+}
+
+// capturePacket tees a packet seen on conn into the active capture, if any.
+// remote is the source of inbound legs and the destination of outbound legs.
+func (s *Session) capturePacket(leg CaptureLeg, conn *net.UDPConn, remote *net.UDPAddr, payload []byte) {
+	capture := s.capture.Load()
+	if capture == nil {
+		return
+	}
+	var local *net.UDPAddr
+	if conn != nil {
+		local, _ = conn.LocalAddr().(*net.UDPAddr)
+	}
+	if leg == CaptureLegAIn || leg == CaptureLegBIn {
+		capture.tee(leg, remote, local, payload)
+		return
+	}
+	capture.tee(leg, local, remote, payload)
+}
+
+// CaptureState reports the current or most recent capture. ok is false when
+// the session has never been captured.
+func (s *Session) CaptureState() (CaptureState, bool) {
+	if s == nil {
+		return CaptureState{}, false
+	}
+	if capture := s.capture.Load(); capture != nil {
+		return capture.state(), true
+	}
+	if last := s.lastCapture.Load(); last != nil {
+		return last.state(), true
+	}
+	return CaptureState{}, false
+}
+
+func (s *Session) stopCapture() error {
+	capture := s.capture.Swap(nil)
+	if capture == nil {
+		return nil
+	}
+	s.lastCapture.Store(capture)
+	return capture.stop()
+}
+
+// StartCapture begins writing the selected legs of a session into a pcap
+// file under the configured capture directory.
+func (m *Manager) StartCapture(id string, opts CaptureOptions) (*Session, bool, error) {
+	if m.captureDir == "" {
+		return nil, false, ErrCaptureDisabled
+	}
+	legs := opts.Legs
+	if len(legs) == 0 {
+		legs = allCaptureLegs()
+	}
+	for _, leg := range legs {
+		if captureLegBit(leg) == 0 {
+			return nil, false, fmt.Errorf("%w: %q", ErrInvalidCaptureLeg, leg)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	if session.capture.Load() != nil {
+		return session, true, ErrCaptureActive
+	}
+	if err := os.MkdirAll(m.captureDir, 0o755); err != nil {
+		return session, true, fmt.Errorf("create capture dir: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.pcap", session.ID, m.now().UTC().Format("20060102T150405.000000000Z"))
+	capture, err := newSessionCapture(filepath.Join(m.captureDir, name), legs, opts.MaxBytes)
+	if err != nil {
+		return session, true, err
+	}
+	session.capture.Store(capture)
+	session.recordHistory(m.now(), HistoryCaptureStarted, "", capture.path)
+	session.Logger().Info("session.capture started", "path", capture.path, "legs", legs, "max_bytes", opts.MaxBytes)
+	m.publish(EventSessionUpdated, session)
+	return session, true, nil
+}
+
+// StopCapture finalizes the active capture of a session. Stopping a session
+// without an active capture is a no-op.
+func (m *Manager) StopCapture(id string) (*Session, bool, error) {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if session.capture.Load() == nil {
+		return session, true, nil
+	}
+	err := session.stopCapture()
+	state, _ := session.CaptureState()
+	session.Logger().Info("session.capture stopped", "path", state.Path, "bytes_written", state.BytesWritten, "packets", state.Packets, "dropped", state.Dropped)
+	m.publish(EventSessionUpdated, session)
+	return session, true, err
+}
+
+func allCaptureLegs() []CaptureLeg {
+	return []CaptureLeg{CaptureLegAIn, CaptureLegAOut, CaptureLegBIn, CaptureLegBOut}
+}
+
+func udpAddrIP(addr *net.UDPAddr) net.IP {
+	if addr == nil || addr.IP == nil {
+		return net.IPv4zero
+	}
+	return addr.IP
+}
+
+func udpAddrPort(addr *net.UDPAddr) int {
+	if addr == nil {
+		return 0
+	}
+	return addr.Port
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rtp-stream-cleaner/internal/pcapio"
+)
+
+func readCapturedPackets(t *testing.T, path string) []pcapio.Packet {
+	t.Helper()
+	reader, err := pcapio.OpenReader(path)
+	if err != nil {
+		t.Fatalf("open capture: %v", err)
+	}
+	defer reader.Close()
+	var packets []pcapio.Packet
+	for {
+		packet, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return packets
+		}
+		if err != nil {
+			t.Fatalf("read capture: %v", err)
+		}
+		packets = append(packets, packet)
+	}
+}
+
+// TestManager_CaptureWritesSelectedLegs verifies that a capture records only
+// the requested legs and that stopping it finalizes a readable pcap whose size
+// matches the reported bytes_written. This matters because operators attach
+// these files to bug reports and must be able to trust the status in GET.
+// Inputs: capture of a_in only, one packet teed on a_in and one on b_out. The
+// expected output is a file under the capture dir with exactly one packet and
+// a stopped state with packets=1 and dropped=0.
+func TestManager_CaptureWritesSelectedLegs(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.captureDir = t.TempDir()
+	created, err := manager.Create("call-cap", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, ok, err := manager.StartCapture(created.ID, CaptureOptions{Legs: []CaptureLeg{CaptureLegAIn}}); !ok || err != nil {
+		t.Fatalf("expected capture to start, ok=%v err=%v", ok, err)
+	}
+	if _, _, err := manager.StartCapture(created.ID, CaptureOptions{}); !errors.Is(err, ErrCaptureActive) {
+		t.Fatalf("expected ErrCaptureActive, got %v", err)
+	}
+
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 30000}
+	created.capturePacket(CaptureLegAIn, nil, peer, []byte{0x80, 0x60, 0x00, 0x01})
+	created.capturePacket(CaptureLegBOut, nil, peer, []byte{0x80, 0x60, 0x00, 0x02})
+
+	if _, ok, err := manager.StopCapture(created.ID); !ok || err != nil {
+		t.Fatalf("expected capture to stop, ok=%v err=%v", ok, err)
+	}
+	state, ok := created.CaptureState()
+	if !ok || state.Active {
+		t.Fatalf("expected stopped capture state, got %+v ok=%v", state, ok)
+	}
+	if filepath.Dir(state.Path) != manager.captureDir {
+		t.Fatalf("expected capture under %s, got %s", manager.captureDir, state.Path)
+	}
+	if state.Packets != 1 || state.Dropped != 0 {
+		t.Fatalf("unexpected capture counters: %+v", state)
+	}
+	if packets := readCapturedPackets(t, state.Path); len(packets) != 1 {
+		t.Fatalf("expected 1 captured packet, got %d", len(packets))
+	}
+	info, err := os.Stat(state.Path)
+	if err != nil {
+		t.Fatalf("stat capture: %v", err)
+	}
+	if uint64(info.Size()) != state.BytesWritten {
+		t.Fatalf("expected bytes_written %d to match file size %d", state.BytesWritten, info.Size())
+	}
+}
+
+func TestManager_CaptureStopsAtMaxBytes(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.captureDir = t.TempDir()
+	created, err := manager.Create("call-cap-max", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	payload := make([]byte, 100)
+	maxBytes := int64(pcapFileHeaderSize + 2*(pcapRecordOverhead+len(payload)))
+	if _, _, err := manager.StartCapture(created.ID, CaptureOptions{MaxBytes: maxBytes}); err != nil {
+		t.Fatalf("unexpected capture error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		created.capturePacket(CaptureLegAIn, nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 30000}, payload)
+	}
+	if !manager.Delete(created.ID) {
+		t.Fatalf("expected delete to succeed")
+	}
+
+	state, _ := created.CaptureState()
+	if state.Active || state.Packets != 2 || state.Dropped != 3 || state.BytesWritten != uint64(maxBytes) {
+		t.Fatalf("unexpected capture state after delete: %+v", state)
+	}
+}
+
+func TestManager_CaptureDisabledWithoutDir(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-cap-off", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, _, err := manager.StartCapture(created.ID, CaptureOptions{}); !errors.Is(err, ErrCaptureDisabled) {
+		t.Fatalf("expected ErrCaptureDisabled, got %v", err)
+	}
+}
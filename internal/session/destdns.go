@@ -0,0 +1,84 @@
+package session
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// hostDest ties a media's destination to the hostname it was resolved from.
+type hostDest struct {
+	media string
+	dest  *atomic.Pointer[net.UDPAddr]
+	host  *atomic.Value
+	state *Media
+}
+
+// StartDestRefresh re-resolves hostname destinations of live sessions every
+// interval so that DNS failover takes effect without an update call. A
+// non-positive interval disables it. The loop stops on Close.
+func (m *Manager) StartDestRefresh(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.refreshDests()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) refreshDests() {
+	for _, session := range m.List() {
+		for _, ref := range []hostDest{
+			{media: "audio", dest: &session.audioDest, host: &session.audioDestHost, state: &session.Audio},
+			{media: "video", dest: &session.videoDest, host: &session.videoDestHost, state: &session.Video},
+		} {
+			m.refreshDest(session, ref)
+		}
+	}
+}
+
+// refreshDest resolves one hostname destination outside the manager lock and
+// stores the new address unless the destination was changed meanwhile. A
+// failed lookup keeps the last known address.
+func (m *Manager) refreshDest(session *Session, ref hostDest) {
+	host := loadAtomicString(ref.host)
+	current := ref.dest.Load()
+	if host == "" || current == nil {
+		return
+	}
+	resolved, err := m.resolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(current.Port)))
+	if err != nil {
+		session.Logger().Warn("rtpengine_dest re-resolve failed", "media", ref.media, "host", host, "error", err)
+		return
+	}
+	if resolved.IP.Equal(current.IP) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions[session.ID] != session || ref.dest.Load() != current || loadAtomicString(ref.host) != host {
+		return
+	}
+	if m.isHairpin(resolved) {
+		session.Logger().Warn("rtpengine_dest re-resolve rejected", "media", ref.media, "host", host, "new", resolved.String(), "error", ErrHairpinDest)
+		return
+	}
+	clone := cloneUDPAddr(resolved)
+	ref.state.RTPEngineDest = clone
+	ref.dest.Store(clone)
+	session.recordHistory(m.now(), HistoryDestChanged, ref.media, clone.String())
+	session.Logger().Info("rtpengine_dest re-resolved", "media", ref.media, "host", host, "old", current.String(), "new", clone.String())
+	m.publish(EventSessionUpdated, session)
+}
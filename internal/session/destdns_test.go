@@ -0,0 +1,84 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// TestManagerRefreshDestsFollowsDNS verifies that a hostname destination is
+// re-resolved and the new address is used, while IP literals are left alone
+// and a failed lookup keeps the last known address. This matters because
+// rtpengine failover is done by moving a DNS name mid-call. Inputs: a session
+// with an audio hostname destination and a video IP literal, and a stub
+// resolver that first moves the name and then fails. The expected output is
+// the moved audio address with the hostname kept, an untouched video address
+// and one lookup per refresh.
+func TestManagerRefreshDestsFollowsDNS(t *testing.T) {
+	manager := newTestManager(t, 0)
+	var lookups []string
+	resolved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 20), Port: 40100}
+	var resolveErr error
+	manager.resolveUDPAddr = func(network, address string) (*net.UDPAddr, error) {
+		lookups = append(lookups, network+" "+address)
+		return resolved, resolveErr
+	}
+	created, err := manager.CreateWithOptions("call-dns", "from", "to", false, CreateOptions{
+		InitialAudioDest:     &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40100},
+		InitialAudioDestHost: "media-1.internal",
+		InitialVideoDest:     &net.UDPAddr{IP: net.IPv4(192, 0, 2, 11), Port: 40102},
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+
+	manager.refreshDests()
+	audio := created.AudioState()
+	if audio.RTPEngineDest.String() != "192.0.2.20:40100" || audio.RTPEngineDestHost != "media-1.internal" {
+		t.Fatalf("expected re-resolved audio dest, got %v host %q", audio.RTPEngineDest, audio.RTPEngineDestHost)
+	}
+	if video := created.VideoState(); video.RTPEngineDest.String() != "192.0.2.11:40102" || video.RTPEngineDestHost != "" {
+		t.Fatalf("expected video dest unchanged, got %v host %q", video.RTPEngineDest, video.RTPEngineDestHost)
+	}
+
+	resolveErr = errors.New("no such host")
+	resolved = nil
+	manager.refreshDests()
+	if audio := created.AudioState(); audio.RTPEngineDest.String() != "192.0.2.20:40100" {
+		t.Fatalf("expected last known dest after failed lookup, got %v", audio.RTPEngineDest)
+	}
+	if len(lookups) != 2 || lookups[0] != "udp4 media-1.internal:40100" {
+		t.Fatalf("unexpected lookups %v", lookups)
+	}
+}
+
+// TestManagerUpdateDestReplacesHost verifies that an update with an IP literal
+// or port 0 drops the previous hostname so that it is no longer re-resolved.
+// Inputs: a hostname destination followed by an IP literal update. The
+// expected output is an empty RTPEngineDestHost and no lookup on refresh.
+func TestManagerUpdateDestReplacesHost(t *testing.T) {
+	manager := newTestManager(t, 0)
+	lookups := 0
+	manager.resolveUDPAddr = func(string, string) (*net.UDPAddr, error) {
+		lookups++
+		return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 30), Port: 40100}, nil
+	}
+	created, err := manager.Create("call-dns-2", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	manager.UpdateDest(created.ID, DestUpdate{Audio: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40100}, AudioHost: "media-1.internal"})
+	if host := created.AudioState().RTPEngineDestHost; host != "media-1.internal" {
+		t.Fatalf("expected host to be stored, got %q", host)
+	}
+
+	manager.UpdateDest(created.ID, DestUpdate{Audio: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 40), Port: 40100}})
+	manager.refreshDests()
+	audio := created.AudioState()
+	if audio.RTPEngineDestHost != "" || audio.RTPEngineDest.String() != "192.0.2.40:40100" {
+		t.Fatalf("expected IP literal dest without host, got %v host %q", audio.RTPEngineDest, audio.RTPEngineDestHost)
+	}
+	if lookups != 0 {
+		t.Fatalf("expected no lookups, got %d", lookups)
+	}
+}
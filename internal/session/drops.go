@@ -0,0 +1,109 @@
+package session
+
+import "sync/atomic"
+
+// dropReason classifies why a proxy discarded a packet.
+type dropReason int
+
+const (
+	// dropNoDest: no rtpengine destination is known for the media yet.
+	dropNoDest dropReason = iota
+	// dropNoPeer: a B-leg packet arrived before the doorphone peer was learned.
+	dropNoPeer
+	// dropWriteError: forwarding the packet failed on the socket.
+	dropWriteError
+	// dropPeerRejected: the source did not match the learned doorphone peer
+	// after the learning window.
+	dropPeerRejected
+	// dropForeignSSRC: an A-leg RTP packet carried another SSRC than the one
+	// the media locked onto.
+	dropForeignSSRC
+	// dropNonRTPA, dropNonRTPB: a datagram read on the A or B leg could not be
+	// RTP, e.g. STUN or a port scan, and the non-RTP gate is on.
+	dropNonRTPA
+	dropNonRTPB
+	// dropBSourceMismatch: a B-leg packet did not come from the rtpengine
+	// destination address.
+	dropBSourceMismatch
+	// dropRateLimited: forwarding the packet toward rtpengine would have
+	// exceeded the media's bandwidth limit.
+	dropRateLimited
+	// dropTruncated: the datagram was longer than the maximum packet size and
+	// was cut off by the read.
+	dropTruncated
+)
+
+// dropCounters keeps the total drops of one media alongside the per-reason
+// breakdown exposed through the API.
+type dropCounters struct {
+	drops             atomic.Uint64
+	dropsNoDest       atomic.Uint64
+	dropsNoPeer       atomic.Uint64
+	dropsWriteError   atomic.Uint64
+	dropsPeerRejected atomic.Uint64
+	dropsForeignSSRC  atomic.Uint64
+	dropsNonRTPA      atomic.Uint64
+	dropsNonRTPB      atomic.Uint64
+	dropsBSource      atomic.Uint64
+	dropsRateLimited  atomic.Uint64
+	dropsTruncated    atomic.Uint64
+}
+
+// DropCounters is a snapshot of dropCounters. Drops is the sum of the reasons.
+type DropCounters struct {
+	Drops             uint64
+	DropsNoDest       uint64
+	DropsNoPeer       uint64
+	DropsWriteError   uint64
+	DropsPeerRejected uint64
+	DropsForeignSSRC  uint64
+	DropsNonRTPA      uint64
+	DropsNonRTPB      uint64
+	DropsBSource      uint64
+	DropsRateLimited  uint64
+	DropsTruncated    uint64
+}
+
+// drop counts a dropped packet and returns the drops for its reason so far.
+func (c *dropCounters) drop(reason dropReason) uint64 {
+	c.drops.Add(1)
+	switch reason {
+	case dropNoDest:
+		return c.dropsNoDest.Add(1)
+	case dropNoPeer:
+		return c.dropsNoPeer.Add(1)
+	case dropWriteError:
+		return c.dropsWriteError.Add(1)
+	case dropPeerRejected:
+		return c.dropsPeerRejected.Add(1)
+	case dropForeignSSRC:
+		return c.dropsForeignSSRC.Add(1)
+	case dropNonRTPA:
+		return c.dropsNonRTPA.Add(1)
+	case dropNonRTPB:
+		return c.dropsNonRTPB.Add(1)
+	case dropBSourceMismatch:
+		return c.dropsBSource.Add(1)
+	case dropRateLimited:
+		return c.dropsRateLimited.Add(1)
+	case dropTruncated:
+		return c.dropsTruncated.Add(1)
+	}
+	return 0
+}
+
+func (c *dropCounters) snapshot() DropCounters {
+	return DropCounters{
+		Drops:             c.drops.Load(),
+		DropsNoDest:       c.dropsNoDest.Load(),
+		DropsNoPeer:       c.dropsNoPeer.Load(),
+		DropsWriteError:   c.dropsWriteError.Load(),
+		DropsPeerRejected: c.dropsPeerRejected.Load(),
+		DropsForeignSSRC:  c.dropsForeignSSRC.Load(),
+		DropsNonRTPA:      c.dropsNonRTPA.Load(),
+		DropsNonRTPB:      c.dropsNonRTPB.Load(),
+		DropsBSource:      c.dropsBSource.Load(),
+		DropsRateLimited:  c.dropsRateLimited.Load(),
+		DropsTruncated:    c.dropsTruncated.Load(),
+	}
+}
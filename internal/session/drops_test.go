@@ -0,0 +1,136 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestAudioProxyDropReasons verifies that each way the audio proxy discards a
+// packet is counted under its own reason and in the total. This matters
+// because "audio silently not forwarding" is diagnosed remotely from these
+// counters. Inputs, in order: a B-leg packet before any doorphone packet
+// (no_peer), a doorphone packet that is forwarded, a packet from a second
+// A-leg source after the zero-length learning window (peer_rejected) and a
+// doorphone packet after the destination was cleared (no_dest). The expected
+// output is one drop per reason, three in total, and no write errors.
+func TestAudioProxyDropReasons(t *testing.T) {
+	session := &Session{ID: "S-drops"}
+	session.audioEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	otherConn := mustListenUDP(t)
+	defer otherConn.Close()
+	session.audioDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newAudioProxy(session, aConn, bConn, 0, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	waitForDrops := func(want uint64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for session.AudioCountersSnapshot().Drops < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d drops, got %+v", want, session.AudioCountersSnapshot())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	packet := makeRTPPacket(1, 8000, []byte{0x00})
+
+	if _, err := rtpEngineConn.WriteToUDP(packet, localUDPAddr(bConn)); err != nil {
+		t.Fatalf("send to b-leg failed: %v", err)
+	}
+	waitForDrops(1)
+
+	if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	buffer := make([]byte, 2048)
+	_ = rtpEngineConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := rtpEngineConn.ReadFromUDP(buffer); err != nil {
+		t.Fatalf("expected doorphone packet at rtpengine: %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := otherConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	waitForDrops(2)
+
+	session.audioDest.Store(nil)
+	if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	waitForDrops(3)
+
+	counters := session.AudioCountersSnapshot()
+	want := DropCounters{Drops: 3, DropsNoDest: 1, DropsNoPeer: 1, DropsPeerRejected: 1}
+	if counters.DropCounters != want {
+		t.Fatalf("unexpected drop counters: got=%+v want=%+v", counters.DropCounters, want)
+	}
+	if counters.IgnoredDisabled != 0 {
+		t.Fatalf("unexpected ignored_disabled: %d", counters.IgnoredDisabled)
+	}
+}
+
+// TestAudioProxyBLegSourceMismatch verifies that B-leg packets must come from
+// the rtpengine destination port as well as its IP. This matters because
+// anything else on the rtpengine host could otherwise inject media toward the
+// doorphone. Inputs: a packet from another port on the rtpengine IP followed
+// by one from the destination itself, with the strict check on and off. The
+// expected output is the foreign port counted as b_leg_source_mismatch and
+// not relayed while strict, and both packets relayed otherwise.
+func TestAudioProxyBLegSourceMismatch(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			session := &Session{ID: "S-b-source", bStrictPort: strict}
+			session.audioEnabled.Store(true)
+			aConn := mustListenUDP(t)
+			bConn := mustListenUDP(t)
+			rtpEngineConn := mustListenUDP(t)
+			defer rtpEngineConn.Close()
+			sameHostConn := mustListenUDP(t)
+			defer sameHostConn.Close()
+			doorphoneConn := mustListenUDP(t)
+			defer doorphoneConn.Close()
+			session.audioDest.Store(localUDPAddr(rtpEngineConn))
+
+			proxy := newAudioProxy(session, aConn, bConn, 0, ProxyLogConfig{})
+			proxy.start()
+			defer proxy.stop()
+
+			packet := makeRTPPacket(1, 8000, []byte{0x00})
+			if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+				t.Fatalf("send to a-leg failed: %v", err)
+			}
+			expectOnlyPacket(t, rtpEngineConn, packet)
+
+			injected := makeRTPPacket(2, 8160, []byte{0xff})
+			if _, err := sameHostConn.WriteToUDP(injected, localUDPAddr(bConn)); err != nil {
+				t.Fatalf("send to b-leg failed: %v", err)
+			}
+			if !strict {
+				expectOnlyPacket(t, doorphoneConn, injected)
+			}
+			if _, err := rtpEngineConn.WriteToUDP(packet, localUDPAddr(bConn)); err != nil {
+				t.Fatalf("send to b-leg failed: %v", err)
+			}
+			expectOnlyPacket(t, doorphoneConn, packet)
+
+			var wantDrops uint64
+			if strict {
+				wantDrops = 1
+			}
+			counters := session.AudioCountersSnapshot()
+			if counters.DropsBSource != wantDrops || counters.Drops != wantDrops {
+				t.Fatalf("expected %d b-leg source mismatches, got %+v", wantDrops, counters.DropCounters)
+			}
+		})
+	}
+}
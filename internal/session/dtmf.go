@@ -0,0 +1,129 @@
+package session
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// DefaultDTMFPayloadType is the telephone-event payload type assumed unless
+// another one is configured.
+const DefaultDTMFPayloadType = 101
+
+// dtmfHistorySize bounds the DTMF digits kept per session; older ones are
+// dropped.
+const dtmfHistorySize = 16
+
+// dtmfDigits maps RFC 4733 event codes to the digits they stand for. Other
+// events, such as line tones, are not DTMF and are ignored.
+var dtmfDigits = [...]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "*", "#", "A", "B", "C", "D", "flash"}
+
+// DTMFDigit is one RFC 4733 event received on the audio A leg. Duration is
+// that of the end packet, or of the last packet seen when the end was lost.
+type DTMFDigit struct {
+	Digit    string
+	Time     time.Time
+	Duration time.Duration
+}
+
+type DTMFStats struct {
+	Events uint64
+	Last   []DTMFDigit
+}
+
+// dtmfStats count the DTMF events of a session and keep the last digits in a
+// fixed-size ring, like the session history.
+type dtmfStats struct {
+	events atomic.Uint64
+	mu     sync.Mutex
+	digits [dtmfHistorySize]DTMFDigit
+	next   int
+	count  int
+}
+
+func (s *dtmfStats) add(digit DTMFDigit) {
+	s.events.Add(1)
+	s.mu.Lock()
+	s.digits[s.next] = digit
+	s.next = (s.next + 1) % dtmfHistorySize
+	if s.count < dtmfHistorySize {
+		s.count++
+	}
+	s.mu.Unlock()
+}
+
+// setLastDuration updates the duration of the newest digit as its event goes
+// on.
+func (s *dtmfStats) setLastDuration(duration time.Duration) {
+	s.mu.Lock()
+	if s.count > 0 {
+		s.digits[(s.next-1+dtmfHistorySize)%dtmfHistorySize].Duration = duration
+	}
+	s.mu.Unlock()
+}
+
+// snapshot returns the digits oldest first.
+func (s *dtmfStats) snapshot() DTMFStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := DTMFStats{Events: s.events.Load(), Last: make([]DTMFDigit, 0, s.count)}
+	start := (s.next - s.count + dtmfHistorySize) % dtmfHistorySize
+	for i := 0; i < s.count; i++ {
+		stats.Last = append(stats.Last, s.digits[(start+i)%dtmfHistorySize])
+	}
+	return stats
+}
+
+// dtmfDetector finds the RFC 4733 telephone events among the RTP received on
+// the audio A leg without touching the packets. All packets of one event,
+// including the repeated end packets, carry the event's RTP timestamp, so a
+// new SSRC or timestamp starts a new event. It is only used from the audio
+// A-leg loop.
+type dtmfDetector struct {
+	stats *dtmfStats
+	pt    uint8
+	rate  uint32
+
+	started bool
+	ssrc    uint32
+	ts      uint32
+	ended   bool
+}
+
+// newDTMFDetector reads events of payload type pt. Durations are converted
+// with the clock rate of pt in clockRates, or 8000 Hz, the telephone-event
+// rate used with narrowband audio.
+func newDTMFDetector(stats *dtmfStats, pt uint8, clockRates map[uint8]uint32) *dtmfDetector {
+	rate, ok := clockRates[pt]
+	if !ok || rate == 0 {
+		rate = 8000
+	}
+	return &dtmfDetector{stats: stats, pt: pt, rate: rate}
+}
+
+// track records packet if it is a DTMF event of the detector's payload type.
+func (d *dtmfDetector) track(packet []byte, arrival time.Time) {
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok || header.PT != d.pt {
+		return
+	}
+	payload := header.Payload(packet)
+	if len(payload) < 4 || int(payload[0]) >= len(dtmfDigits) {
+		return
+	}
+	end := payload[1]&0x80 != 0
+	duration := time.Duration(binary.BigEndian.Uint16(payload[2:4])) * time.Second / time.Duration(d.rate)
+	if !d.started || header.SSRC != d.ssrc || header.TS != d.ts {
+		d.started, d.ssrc, d.ts, d.ended = true, header.SSRC, header.TS, end
+		d.stats.add(DTMFDigit{Digit: dtmfDigits[payload[0]], Time: arrival, Duration: duration})
+		return
+	}
+	if d.ended {
+		return
+	}
+	d.ended = end
+	d.stats.setLastDuration(duration)
+}
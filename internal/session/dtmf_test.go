@@ -0,0 +1,102 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func makeDTMFPacket(seq uint16, ts uint32, event byte, end bool, duration uint16) []byte {
+	payload := make([]byte, 4)
+	payload[0] = event
+	payload[1] = 10
+	if end {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:4], duration)
+	packet := makeRTPPacket(seq, ts, payload)
+	packet[1] = DefaultDTMFPayloadType
+	return packet
+}
+
+// TestDTMFDetector_CountsOneEventPerTimestamp verifies that the packets of
+// one RFC 4733 event count as a single DTMF digit. This matters because a
+// key press is sent as a start packet, continuations and three identical end
+// packets, and counting packets would report several presses. Inputs: the
+// start, continuation and end triplet of digit 5 with the end repeated, then
+// a "#" press with a new timestamp. The expected output is one event after
+// the first press with the duration of its end packet (800 units = 100ms at
+// 8 kHz), two after the second, and untouched packet bytes.
+func TestDTMFDetector_CountsOneEventPerTimestamp(t *testing.T) {
+	var stats dtmfStats
+	detector := newDTMFDetector(&stats, DefaultDTMFPayloadType, defaultClockRates)
+	start := time.Unix(1_700_000_000, 0)
+	packets := [][]byte{
+		makeDTMFPacket(1, 8000, 5, false, 160),
+		makeDTMFPacket(2, 8000, 5, false, 480),
+		makeDTMFPacket(3, 8000, 5, true, 800),
+		makeDTMFPacket(4, 8000, 5, true, 800),
+		makeDTMFPacket(5, 8000, 5, true, 800),
+	}
+	for i, packet := range packets {
+		original := bytes.Clone(packet)
+		detector.track(packet, start.Add(time.Duration(i)*20*time.Millisecond))
+		if !bytes.Equal(packet, original) {
+			t.Fatalf("expected packet %d to stay unchanged", i)
+		}
+	}
+	got := stats.snapshot()
+	want := DTMFDigit{Digit: "5", Time: start, Duration: 100 * time.Millisecond}
+	if got.Events != 1 || len(got.Last) != 1 || got.Last[0] != want {
+		t.Fatalf("expected one event %+v, got %+v", want, got)
+	}
+
+	detector.track(makeDTMFPacket(6, 16000, 11, false, 160), start.Add(time.Second))
+	got = stats.snapshot()
+	if got.Events != 2 || len(got.Last) != 2 || got.Last[1].Digit != "#" || got.Last[1].Duration != 20*time.Millisecond {
+		t.Fatalf("expected a second event for #, got %+v", got)
+	}
+}
+
+// TestDTMFDetector_IgnoresOtherPackets verifies that only DTMF events of the
+// configured payload type are counted. Inputs: PCMU audio, a telephone event
+// on another payload type, a non-DTMF tone event and a truncated payload.
+// The expected output is no event at all.
+func TestDTMFDetector_IgnoresOtherPackets(t *testing.T) {
+	var stats dtmfStats
+	detector := newDTMFDetector(&stats, DefaultDTMFPayloadType, defaultClockRates)
+	pcmu := makeRTPPacket(1, 160, make([]byte, 160))
+	pcmu[1] = 0
+	otherPT := makeDTMFPacket(2, 320, 1, false, 160)
+	otherPT[1] = 100
+	tone := makeDTMFPacket(3, 480, 66, false, 160)
+	truncated := makeDTMFPacket(4, 640, 1, false, 160)[:14]
+	for _, packet := range [][]byte{pcmu, otherPT, tone, truncated} {
+		detector.track(packet, time.Now())
+	}
+	if got := stats.snapshot(); got.Events != 0 || len(got.Last) != 0 {
+		t.Fatalf("expected no events, got %+v", got)
+	}
+}
+
+// TestDTMFStats_KeepsLastDigits verifies that the digit ring stays bounded
+// while the event count does not. Inputs: 20 presses. The expected output is
+// 20 events and the last 16 digits, oldest first.
+func TestDTMFStats_KeepsLastDigits(t *testing.T) {
+	var stats dtmfStats
+	detector := newDTMFDetector(&stats, DefaultDTMFPayloadType, defaultClockRates)
+	for i := range 20 {
+		detector.track(makeDTMFPacket(uint16(i), uint32(i)*1600, byte(i%10), true, 800), time.Now())
+	}
+	got := stats.snapshot()
+	if got.Events != 20 || len(got.Last) != dtmfHistorySize {
+		t.Fatalf("expected 20 events and %d digits, got %d and %d", dtmfHistorySize, got.Events, len(got.Last))
+	}
+	for i, digit := range got.Last {
+		if want := fmt.Sprint((i + 4) % 10); digit.Digit != want {
+			t.Fatalf("expected digit %d to be %s, got %s", i, want, digit.Digit)
+		}
+	}
+}
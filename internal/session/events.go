@@ -0,0 +1,75 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+type EventType string
+
+const (
+	EventSessionCreated    EventType = "session.created"
+	EventSessionUpdated    EventType = "session.updated"
+	EventSessionDeleted    EventType = "session.deleted"
+	EventSessionIdleReaped EventType = "session.idle_reaped"
+)
+
+// Event describes a session lifecycle change. Session points at the live
+// session, which stays readable after it has been deleted.
+type Event struct {
+	Type    EventType
+	Session *Session
+	Time    time.Time
+}
+
+// subscriber receives events until it unsubscribes or is evicted. A
+// subscriber that lets its buffer fill up is evicted and its channel closed,
+// so publishing never blocks session operations.
+type subscriber struct {
+	events chan Event
+}
+
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &subscriber{events: make(chan Event, buffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[*subscriber]struct{})
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub.events, func() { b.unsubscribe(sub) }
+}
+
+func (b *eventBus) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
+
+func (b *eventBus) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+			logging.L().Warn("events.subscriber evicted", "reason", "slow_consumer", "buffer", cap(sub.events))
+		}
+	}
+}
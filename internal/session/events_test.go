@@ -0,0 +1,95 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestManager_EventsPublishedForLifecycle verifies that create, update, delete
+// and idle reaping each publish one event carrying the affected session. This
+// matters because the SSE stream is built purely from these events. Inputs: a
+// subscriber registered before the operations. The expected output is one event
+// per operation in order, each pointing at the session that changed.
+func TestManager_EventsPublishedForLifecycle(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+	events, unsubscribe := manager.Subscribe(8)
+	defer unsubscribe()
+
+	deleted, err := manager.Create("call-ev-1", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, ok, _ := manager.UpdateRTPDest(deleted.ID, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 40000}, nil); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+	if !manager.Delete(deleted.ID) {
+		t.Fatalf("expected delete to succeed")
+	}
+	reaped, err := manager.Create("call-ev-2", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	manager.Cleanup(reaped.lastActivity().Add(time.Hour))
+
+	expected := []struct {
+		eventType EventType
+		session   *Session
+	}{
+		{EventSessionCreated, deleted},
+		{EventSessionUpdated, deleted},
+		{EventSessionDeleted, deleted},
+		{EventSessionCreated, reaped},
+		{EventSessionIdleReaped, reaped},
+	}
+	for i, want := range expected {
+		select {
+		case event := <-events:
+			if event.Type != want.eventType || event.Session != want.session {
+				t.Fatalf("event %d: got type=%s session=%p, want type=%s session=%p", i, event.Type, event.Session, want.eventType, want.session)
+			}
+		default:
+			t.Fatalf("event %d: expected %s, got none", i, want.eventType)
+		}
+	}
+}
+
+// TestEventBus_EvictsSlowSubscriber verifies that a subscriber whose buffer is
+// full is dropped instead of blocking the publisher. This matters because
+// events are published from session operations that must never stall on a
+// stalled HTTP client. Inputs: one subscriber with buffer 1 that never reads
+// and one that drains. The expected output is that publishing returns, the
+// slow channel is closed after its buffered event, and the other subscriber
+// still receives every event.
+func TestEventBus_EvictsSlowSubscriber(t *testing.T) {
+	var bus eventBus
+	slow, _ := bus.subscribe(1)
+	fast, unsubscribeFast := bus.subscribe(4)
+	defer unsubscribeFast()
+
+	bus.publish(Event{Type: EventSessionCreated})
+	bus.publish(Event{Type: EventSessionUpdated})
+
+	if event, ok := <-slow; !ok || event.Type != EventSessionCreated {
+		t.Fatalf("expected buffered event before eviction, got %v ok=%v", event.Type, ok)
+	}
+	if _, ok := <-slow; ok {
+		t.Fatalf("expected slow subscriber channel to be closed")
+	}
+	for _, want := range []EventType{EventSessionCreated, EventSessionUpdated} {
+		if event := <-fast; event.Type != want {
+			t.Fatalf("unexpected fast event: got=%s want=%s", event.Type, want)
+		}
+	}
+}
+
+func TestEventBus_UnsubscribeClosesChannelOnce(t *testing.T) {
+	var bus eventBus
+	events, unsubscribe := bus.subscribe(1)
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatalf("expected channel to be closed after unsubscribe")
+	}
+	bus.publish(Event{Type: EventSessionCreated})
+}
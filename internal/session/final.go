@@ -0,0 +1,37 @@
+package session
+
+import "time"
+
+// logFinalSnapshot logs the counters a deleted session ended with, matching
+// what the delete API returns.
+func (s *Session) logFinalSnapshot(reason string, now time.Time) {
+	audio := s.AudioState()
+	video := s.VideoState()
+	audioCounters := s.AudioCountersSnapshot()
+	videoCounters := s.VideoCountersSnapshot()
+	attrs := []any{
+		"reason", reason,
+		"call_id", s.CallID,
+		"state", s.StateString(),
+		"audio_enabled", audio.Enabled,
+		"audio_disabled_reason", audio.DisabledReason,
+		"video_enabled", video.Enabled,
+		"video_disabled_reason", video.DisabledReason,
+		"audio_a_in_pkts", audioCounters.AInPkts,
+		"audio_b_out_pkts", audioCounters.BOutPkts,
+		"audio_b_in_pkts", audioCounters.BInPkts,
+		"audio_a_out_pkts", audioCounters.AOutPkts,
+		"audio_drops", audioCounters.Drops,
+		"video_a_in_pkts", videoCounters.AInPkts,
+		"video_b_out_pkts", videoCounters.BOutPkts,
+		"video_b_in_pkts", videoCounters.BInPkts,
+		"video_a_out_pkts", videoCounters.AOutPkts,
+		"video_drops", videoCounters.Drops,
+		"video_frames_flushed", videoCounters.VideoFramesFlushed,
+		"video_forced_flushes", videoCounters.VideoForcedFlushes,
+	}
+	if !s.CreatedAt.IsZero() {
+		attrs = append(attrs, "duration", now.Sub(s.CreatedAt))
+	}
+	s.Logger().Info("session.final_snapshot", attrs...)
+}
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpparse"
+)
+
+// DisabledReasonHairpinLoop marks media whose own packets came back on its A
+// leg. A new rtpengine destination enables it again.
+const DisabledReasonHairpinLoop = "hairpin_loop"
+
+// ErrHairpinDest is matched by HairpinDestError.
+var ErrHairpinDest = errors.New("rtpengine_dest points at a local media port")
+
+// HairpinDestError rejects a destination that would send a media back into
+// one of the ports of this process and loop.
+type HairpinDestError struct {
+	Media string
+	Dest  *net.UDPAddr
+}
+
+func (e *HairpinDestError) Error() string {
+	return fmt.Sprintf("%s rtpengine_dest %s points at a local media port", e.Media, e.Dest)
+}
+
+func (e *HairpinDestError) Unwrap() error {
+	return ErrHairpinDest
+}
+
+// SetLocalIPs adds the addresses, besides loopback and the bind addresses,
+// under which packets reach this host, such as PUBLIC_IP and the interface
+// addresses. A destination on one of them and an allocated port is a
+// hairpin. It must be called before sessions are created.
+func (m *Manager) SetLocalIPs(ips []net.IP) {
+	m.localIPs = ips
+}
+
+// isLocalIP reports whether ip reaches this host.
+func (m *Manager) isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.Equal(m.bindIPA) || ip.Equal(m.bindIPB) {
+		return true
+	}
+	for _, local := range m.localIPs {
+		if ip.Equal(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHairpin reports whether dest is a local address and a port of the pool
+// in use, or the port before one since RTCP goes to the next port.
+func (m *Manager) isHairpin(dest *net.UDPAddr) bool {
+	if dest == nil || dest.Port == 0 || !m.isLocalIP(dest.IP) {
+		return false
+	}
+	return m.allocator.Allocated(dest.Port) || m.allocator.Allocated(dest.Port+1)
+}
+
+// isLocalMediaPort reports whether addr is one of the media sockets of this
+// process.
+func (m *Manager) isLocalMediaPort(addr *net.UDPAddr) bool {
+	return m.isLocalIP(addr.IP) && m.allocator.Allocated(addr.Port)
+}
+
+// checkHairpin fails with a HairpinDestError for the first destination of
+// update that is a hairpin.
+func (m *Manager) checkHairpin(update DestUpdate) error {
+	if m.isHairpin(update.Audio) {
+		return &HairpinDestError{Media: "audio", Dest: update.Audio}
+	}
+	if m.isHairpin(update.Video) {
+		return &HairpinDestError{Media: "video", Dest: update.Video}
+	}
+	return nil
+}
+
+// hairpinSlots is how many sent packets a hairpinGuard remembers, enough for
+// a flushed video frame to come back while later packets are still sent.
+const hairpinSlots = 64
+
+// hairpinWindow is how soon after being sent a packet that comes back on the
+// A leg counts as looped rather than as a duplicate from the doorphone.
+const hairpinWindow = 50 * time.Millisecond
+
+// hairpinGuard remembers the SSRC and sequence number of the last RTP packets
+// a media sent toward rtpengine so that the A leg recognises them when a
+// destination loops back into this process.
+type hairpinGuard struct {
+	keys     [hairpinSlots]atomic.Uint64
+	sentNsec [hairpinSlots]atomic.Int64
+	next     atomic.Uint32
+}
+
+// hairpinKey packs the SSRC and sequence number of an RTP packet, with a low
+// bit that keeps empty slots from matching. ok is false for anything else.
+func hairpinKey(packet []byte) (key uint64, ok bool) {
+	if len(packet) < 12 || packet[0]>>6 != 2 || rtpparse.IsRTCP(packet) {
+		return 0, false
+	}
+	return uint64(binary.BigEndian.Uint32(packet[8:12]))<<32 | uint64(binary.BigEndian.Uint16(packet[2:4]))<<1 | 1, true
+}
+
+// sent records a packet written toward rtpengine at now.
+func (g *hairpinGuard) sent(packet []byte, now time.Time) {
+	key, ok := hairpinKey(packet)
+	if !ok {
+		return
+	}
+	slot := (g.next.Add(1) - 1) % hairpinSlots
+	g.sentNsec[slot].Store(now.UnixNano())
+	g.keys[slot].Store(key)
+}
+
+// looped reports whether a packet from addr arriving at now is one this media
+// sent within hairpinWindow. Only packets from a local media port are looked
+// up, so that duplicates from the doorphone never match.
+func (s *Session) looped(guard *hairpinGuard, packet []byte, addr *net.UDPAddr, now time.Time) bool {
+	if s.isLocalMediaPort == nil || !s.isLocalMediaPort(addr) {
+		return false
+	}
+	key, ok := hairpinKey(packet)
+	if !ok {
+		return false
+	}
+	for i := range guard.keys {
+		if guard.keys[i].Load() == key && now.UnixNano()-guard.sentNsec[i].Load() <= int64(hairpinWindow) {
+			return true
+		}
+	}
+	return false
+}
+
+// disableHairpin stops forwarding media, "audio" or "video", after its own
+// packets came back from addr.
+func (s *Session) disableHairpin(media string, enabled *atomic.Bool, reason *atomic.Value, addr *net.UDPAddr, now time.Time) {
+	if !enabled.CompareAndSwap(true, false) {
+		return
+	}
+	reason.Store(DisabledReasonHairpinLoop)
+	s.recordHistory(now, HistoryMediaDisabled, media, DisabledReasonHairpinLoop)
+	s.Logger().Error("session.hairpin loop detected, media disabled", "media", media, "from", addr.String(), "rtpengine_dest", s.dest(media).String())
+}
+
+// dest returns the rtpengine destination of media, "audio" or "video".
+func (s *Session) dest(media string) *net.UDPAddr {
+	if media == "video" {
+		return s.videoDest.Load()
+	}
+	return s.audioDest.Load()
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestManager_HairpinDest verifies that destinations looping into a media
+// port of this process are rejected. This matters because such a destination
+// once made a session forward its own packets forever. Inputs: an audio-only
+// session, updates to loopback on its A port, to loopback on the port before
+// it, to a remote address on the same port and to loopback with allow_hairpin,
+// then a create whose initial destination is the first session's B port. The
+// expected output is a HairpinDestError for both loopback updates with the
+// destination unchanged, the remote and allowed updates applied, and the
+// create rejected with its ports back in the pool.
+func TestManager_HairpinDest(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-hairpin", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	loopback := net.IPv4(127, 0, 0, 1)
+
+	for _, port := range []int{created.Audio.APort, created.Audio.APort - 1} {
+		_, ok, err := manager.UpdateDest(created.ID, DestUpdate{Audio: &net.UDPAddr{IP: loopback, Port: port}})
+		var hairpin *HairpinDestError
+		if !ok || !errors.As(err, &hairpin) || hairpin.Media != "audio" || !errors.Is(err, ErrHairpinDest) {
+			t.Fatalf("expected a hairpin error for port %d, got ok=%v err=%v", port, ok, err)
+		}
+		if dest := created.AudioState().RTPEngineDest; dest != nil {
+			t.Fatalf("expected the destination unchanged, got %s", dest)
+		}
+	}
+	remote := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: created.Audio.APort}
+	if _, _, err := manager.UpdateDest(created.ID, DestUpdate{Audio: remote}); err != nil {
+		t.Fatalf("expected a remote destination to be accepted, got %v", err)
+	}
+	allowed := &net.UDPAddr{IP: loopback, Port: created.Audio.APort}
+	if _, _, err := manager.UpdateDest(created.ID, DestUpdate{Audio: allowed, AllowHairpin: true}); err != nil {
+		t.Fatalf("expected allow_hairpin to accept the destination, got %v", err)
+	}
+	if dest := created.AudioState().RTPEngineDest; dest.String() != allowed.String() {
+		t.Fatalf("expected destination %s, got %s", allowed, dest)
+	}
+
+	inUse := manager.PoolStats().InUse
+	_, err = manager.CreateWithOptions("call-loop", "from", "to", false, CreateOptions{
+		DisableVideo:     true,
+		InitialAudioDest: &net.UDPAddr{IP: loopback, Port: created.Audio.BPort},
+	})
+	if !errors.Is(err, ErrHairpinDest) {
+		t.Fatalf("expected the create to be rejected as a hairpin, got %v", err)
+	}
+	if got := manager.PoolStats().InUse; got != inUse {
+		t.Fatalf("expected %d ports in use after the rejected create, got %d", inUse, got)
+	}
+}
+
+// TestAudioProxyHairpinLoop verifies the runtime guard against a loop that
+// slipped past the destination check. Inputs: an audio-only session on
+// loopback sockets with real proxies, its destination set with allow_hairpin
+// to its own A port, and one RTP packet from a doorphone socket. The expected
+// output is audio disabled with reason hairpin_loop and a media_disabled
+// history entry after the packet was forwarded once.
+func TestAudioProxyHairpinLoop(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1))
+	manager.listenUDP = net.ListenUDP
+	manager.newAudioProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy {
+		return newAudioProxy(session, aConn, bConn, peerLearningWindow, logConfig)
+	}
+	created, err := manager.Create("call-loop", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	t.Cleanup(func() { manager.StopAllSessions() })
+	aLeg := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: created.Audio.APort}
+	if _, _, err := manager.UpdateDest(created.ID, DestUpdate{Audio: aLeg, AllowHairpin: true}); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+
+	doorphone, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+	defer doorphone.Close()
+	if _, err := doorphone.WriteToUDP(makeRTPPacket(1, 160, []byte{0xd5}), aLeg); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for created.AudioState().DisabledReason != DisabledReasonHairpinLoop {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected audio disabled as a hairpin loop, got %+v", created.AudioState())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if counters := snapshotAudioCounters(&created.audioCounters); counters.BOutPkts != 1 {
+		t.Fatalf("expected the packet forwarded once, got %d", counters.BOutPkts)
+	}
+	if !hasHistory(created, HistoryMediaDisabled, "audio", DisabledReasonHairpinLoop) {
+		t.Fatalf("expected a media_disabled history entry, got %+v", created.History())
+	}
+}
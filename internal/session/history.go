@@ -0,0 +1,94 @@
+package session
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// historySize bounds the per-session history; older entries are dropped.
+const historySize = 32
+
+// History entry types.
+const (
+	HistoryCreated          = "created"
+	HistoryRestored         = "restored"
+	HistoryDestSet          = "dest_set"
+	HistoryDestChanged      = "dest_changed"
+	HistoryDestCleared      = "dest_cleared"
+	HistoryMediaDisabled    = "media_disabled"
+	HistoryMediaResumed     = "media_resumed"
+	HistoryFirstPacket      = "first_packet"
+	HistoryPeerLearned      = "peer_learned"
+	HistoryPeerChanged      = "peer_changed"
+	HistoryPeerRelearned    = "peer_relearned"
+	HistoryFirstForcedFlush = "first_forced_flush"
+	HistorySPSCached        = "sps_cached"
+	HistoryPPSCached        = "pps_cached"
+	HistoryCaptureStarted   = "capture_started"
+)
+
+// HistoryEntry is one significant event in the life of a session. Media is
+// empty for session-wide entries.
+type HistoryEntry struct {
+	Time   time.Time
+	Type   string
+	Media  string
+	Detail string
+}
+
+// history is a fixed-size ring of entries. Appends only take a short
+// uncontended lock and never allocate, so proxies may record from the packet
+// path.
+type history struct {
+	mu      sync.Mutex
+	entries [historySize]HistoryEntry
+	next    int
+	count   int
+}
+
+func (h *history) add(entry HistoryEntry) {
+	h.mu.Lock()
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % historySize
+	if h.count < historySize {
+		h.count++
+	}
+	h.mu.Unlock()
+}
+
+// snapshot returns the entries oldest first.
+func (h *history) snapshot() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]HistoryEntry, 0, h.count)
+	start := (h.next - h.count + historySize) % historySize
+	for i := 0; i < h.count; i++ {
+		entries = append(entries, h.entries[(start+i)%historySize])
+	}
+	return entries
+}
+
+func (s *Session) recordHistory(now time.Time, entryType, media, detail string) {
+	s.history.add(HistoryEntry{Time: now, Type: entryType, Media: media, Detail: detail})
+}
+
+// recordDestHistory records a destination that was set for the first time
+// or moved. Repeating the current destination is not recorded.
+func recordDestHistory(session *Session, now time.Time, media string, previous, next *net.UDPAddr) {
+	switch {
+	case previous == nil:
+		session.recordHistory(now, HistoryDestSet, media, next.String())
+	case !previous.IP.Equal(next.IP) || previous.Port != next.Port:
+		session.recordHistory(now, HistoryDestChanged, media, next.String())
+	}
+}
+
+// History returns the most recent significant events of the session, oldest
+// first.
+func (s *Session) History() []HistoryEntry {
+	if s == nil {
+		return nil
+	}
+	return s.history.snapshot()
+}
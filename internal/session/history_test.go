@@ -0,0 +1,89 @@
+package session
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHistoryDropsOldestEntries verifies that the history ring keeps only the
+// newest historySize entries in order. This matters because a long call must
+// not grow memory without bound. Inputs: historySize+5 entries. The expected
+// output is historySize entries starting with the sixth one.
+func TestHistoryDropsOldestEntries(t *testing.T) {
+	session := &Session{}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < historySize+5; i++ {
+		session.recordHistory(base.Add(time.Duration(i)*time.Second), HistoryPeerChanged, "audio", strconv.Itoa(i))
+	}
+	entries := session.History()
+	if len(entries) != historySize {
+		t.Fatalf("expected %d entries, got %d", historySize, len(entries))
+	}
+	if entries[0].Detail != "5" || entries[historySize-1].Detail != strconv.Itoa(historySize+4) {
+		t.Fatalf("unexpected window %q..%q", entries[0].Detail, entries[historySize-1].Detail)
+	}
+}
+
+// TestManagerRecordsDestHistory verifies the entries written by create and
+// destination updates: creation, the first destination, a move, a repeated
+// destination that is not recorded, port 0 and a clear. Inputs: a session
+// created with an audio destination followed by four updates. The expected
+// output is the listed sequence of entry types with the manager clock.
+func TestManagerRecordsDestHistory(t *testing.T) {
+	manager := newTestManager(t, 0)
+	first := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40100}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 11), Port: 40100}
+	created, err := manager.CreateWithInitialDest("call-history", "from", "to", true, true, true, first, nil)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	manager.UpdateDest(created.ID, DestUpdate{Audio: moved})
+	manager.UpdateDest(created.ID, DestUpdate{Audio: moved})
+	manager.UpdateDest(created.ID, DestUpdate{Video: &net.UDPAddr{IP: net.IPv4zero}})
+	manager.UpdateDest(created.ID, DestUpdate{ClearAudio: true})
+
+	expected := []HistoryEntry{
+		{Type: HistoryCreated},
+		{Type: HistoryDestSet, Media: "audio", Detail: "192.0.2.10:40100"},
+		{Type: HistoryDestChanged, Media: "audio", Detail: "192.0.2.11:40100"},
+		{Type: HistoryMediaDisabled, Media: "video", Detail: "rtpengine_port_0"},
+		{Type: HistoryDestCleared, Media: "audio"},
+	}
+	entries := created.History()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+	}
+	for i, want := range expected {
+		got := entries[i]
+		if got.Type != want.Type || got.Media != want.Media || got.Detail != want.Detail {
+			t.Fatalf("entry %d: expected %+v, got %+v", i, want, got)
+		}
+		if !got.Time.Equal(manager.now()) {
+			t.Fatalf("entry %d: expected manager time, got %v", i, got.Time)
+		}
+	}
+}
+
+// TestAudioProxyRecordsPeerHistory verifies that peer learning and a move
+// inside the learning window are recorded, while a rejected peer is not.
+// Inputs: three source addresses, the last one after the window closed. The
+// expected output is peer_learned followed by peer_changed.
+func TestAudioProxyRecordsPeerHistory(t *testing.T) {
+	session := &Session{}
+	proxy := &audioProxy{session: session, peerLearningWindow: time.Hour}
+	proxy.updateDoorphonePeer(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000})
+	proxy.updateDoorphonePeer(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5002})
+	proxy.peerLearningWindow = 0
+	proxy.doorphoneLearnedAt = time.Now().Add(-time.Second)
+	proxy.updateDoorphonePeer(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 5000})
+
+	entries := session.History()
+	if len(entries) != 2 || entries[0].Type != HistoryPeerLearned || entries[1].Type != HistoryPeerChanged {
+		t.Fatalf("unexpected history %+v", entries)
+	}
+	if entries[1].Detail != "192.0.2.1:5002" || entries[1].Media != "audio" {
+		t.Fatalf("unexpected peer_changed entry %+v", entries[1])
+	}
+}
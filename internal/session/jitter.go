@@ -0,0 +1,111 @@
+package session
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// videoClockRate is the RTP clock of video payload types missing from the
+// configured clock rates.
+const videoClockRate = 90000
+
+// defaultClockRates are the audio payload types whose clock is known without
+// configuration: PCMU and PCMA.
+var defaultClockRates = map[uint8]uint32{0: 8000, 8: 8000}
+
+// jitterStats hold the interarrival jitter of the RTP received on one leg,
+// in milliseconds as float64 bits.
+type jitterStats struct {
+	current atomic.Uint64
+	max     atomic.Uint64
+}
+
+// JitterStats are rounded to the microsecond.
+type JitterStats struct {
+	CurrentMS float64
+	MaxMS     float64
+}
+
+func (s *jitterStats) snapshot() JitterStats {
+	return JitterStats{
+		CurrentMS: roundJitterMS(math.Float64frombits(s.current.Load())),
+		MaxMS:     roundJitterMS(math.Float64frombits(s.max.Load())),
+	}
+}
+
+func roundJitterMS(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// jitterTracker estimates the interarrival jitter of RFC 3550 section 6.4.1
+// for the current SSRC of one leg. A new SSRC or clock rate starts the
+// estimate over; the maximum is kept. It is only used from that leg's read
+// loop.
+type jitterTracker struct {
+	stats       *jitterStats
+	clockRates  map[uint8]uint32
+	defaultRate uint32
+
+	ssrc    uint32
+	rate    uint32
+	started bool
+	// transit is the previous packet's arrival minus its RTP timestamp, in
+	// timestamp units modulo 2^32.
+	transit uint32
+	jitter  float64
+	max     float64
+}
+
+// newJitterTracker uses clockRates first and defaultRate for the payload
+// types it lacks; packets with no known clock rate are skipped.
+func newJitterTracker(stats *jitterStats, clockRates map[uint8]uint32, defaultRate uint32) *jitterTracker {
+	return &jitterTracker{stats: stats, clockRates: clockRates, defaultRate: defaultRate}
+}
+
+// track feeds packet, received at arrival, into the estimate. Packets that do
+// not parse as RTP are ignored.
+func (t *jitterTracker) track(packet []byte, arrival time.Time) {
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok {
+		return
+	}
+	t.observe(header.SSRC, header.PT, header.TS, arrival)
+}
+
+func (t *jitterTracker) observe(ssrc uint32, pt uint8, timestamp uint32, arrival time.Time) {
+	rate, ok := t.clockRates[pt]
+	if !ok {
+		rate = t.defaultRate
+	}
+	if rate == 0 {
+		return
+	}
+	// Arrival is taken modulo 2^32 timestamp units like the RTP timestamp,
+	// so the transit difference below wraps the same way.
+	nsec := arrival.UnixNano()
+	arrivalUnits := uint32(uint64(nsec/int64(time.Second))*uint64(rate) + uint64(nsec%int64(time.Second))*uint64(rate)/uint64(time.Second))
+	transit := arrivalUnits - timestamp
+	if !t.started || ssrc != t.ssrc || rate != t.rate {
+		t.ssrc, t.rate, t.started = ssrc, rate, true
+		t.transit = transit
+		t.jitter = 0
+		t.publish()
+		return
+	}
+	d := math.Abs(float64(int32(transit - t.transit)))
+	t.transit = transit
+	t.jitter += (d - t.jitter) / 16
+	t.publish()
+}
+
+func (t *jitterTracker) publish() {
+	ms := t.jitter * 1000 / float64(t.rate)
+	t.stats.current.Store(math.Float64bits(ms))
+	if ms > t.max {
+		t.max = ms
+		t.stats.max.Store(math.Float64bits(ms))
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+// TestJitterTracker_KnownValues verifies the RFC 3550 interarrival jitter
+// estimate against values worked out by hand. This matters because jitter is
+// the answer to "was the audio jittery", so the estimator must follow the
+// RFC exactly. Inputs: PCMU packets 160 timestamp units apart arriving every
+// 20ms, then alternately 10ms late, so that each transit difference is 80
+// units. The expected output is 0ms while regular, then J=5 units (0.625ms)
+// and J=5+(80-5)/16 units (1.211ms rounded), with the maximum following.
+func TestJitterTracker_KnownValues(t *testing.T) {
+	var stats jitterStats
+	tracker := newJitterTracker(&stats, defaultClockRates, 0)
+	base := time.Unix(1_700_000_000, 0)
+	send := func(i int, late time.Duration) {
+		tracker.observe(1, 0, uint32(1000+160*i), base.Add(time.Duration(i)*20*time.Millisecond+late))
+	}
+
+	for i := range 5 {
+		send(i, 0)
+	}
+	if got := stats.snapshot(); got != (JitterStats{}) {
+		t.Fatalf("expected no jitter for regular arrivals, got %+v", got)
+	}
+	send(5, 10*time.Millisecond)
+	if got := stats.snapshot(); got != (JitterStats{CurrentMS: 0.625, MaxMS: 0.625}) {
+		t.Fatalf("unexpected jitter after one late packet: %+v", got)
+	}
+	send(6, 0)
+	if got := stats.snapshot(); got != (JitterStats{CurrentMS: 1.211, MaxMS: 1.211}) {
+		t.Fatalf("unexpected jitter after two transit changes: %+v", got)
+	}
+	send(7, 0)
+	if got := stats.snapshot(); got.CurrentMS >= 1.211 || got.MaxMS != 1.211 {
+		t.Fatalf("expected jitter to decay below the kept maximum, got %+v", got)
+	}
+}
+
+// TestJitterTracker_ClockRates verifies how the clock rate is chosen and
+// when the estimate starts over. Inputs: a payload type without a known
+// clock rate on audio, video packets on the 90 kHz default with one late
+// frame, and a new SSRC. The expected output is no jitter for the unknown
+// payload type, 3000 units of transit change read as 33.333ms / 16, and a
+// new SSRC resetting the current value while keeping the maximum.
+func TestJitterTracker_ClockRates(t *testing.T) {
+	var audio jitterStats
+	audioTracker := newJitterTracker(&audio, defaultClockRates, 0)
+	base := time.Unix(1_700_000_000, 0)
+	audioTracker.observe(1, 111, 0, base)
+	audioTracker.observe(1, 111, 960, base.Add(50*time.Millisecond))
+	if got := audio.snapshot(); got != (JitterStats{}) {
+		t.Fatalf("expected no jitter without a clock rate, got %+v", got)
+	}
+
+	var video jitterStats
+	videoTracker := newJitterTracker(&video, defaultClockRates, videoClockRate)
+	videoTracker.observe(7, 96, 0, base)
+	videoTracker.observe(7, 96, 3000, base.Add(2*time.Second/30))
+	want := roundJitterMS(3000.0 / 16 * 1000 / 90000)
+	if got := video.snapshot(); got.CurrentMS != want || got.MaxMS != want {
+		t.Fatalf("expected %.3fms, got %+v", want, got)
+	}
+	videoTracker.observe(8, 96, 500000, base.Add(time.Second))
+	if got := video.snapshot(); got.CurrentMS != 0 || got.MaxMS != want {
+		t.Fatalf("expected a new ssrc to reset the current jitter only, got %+v", got)
+	}
+}
+
+// TestManager_SetClockRates verifies that configured clock rates extend and
+// override the defaults without changing them for other managers.
+func TestManager_SetClockRates(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.SetClockRates(map[uint8]uint32{8: 16000, 111: 48000})
+	session, err := manager.Create("call-rates", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if session.clockRates[0] != 8000 || session.clockRates[8] != 16000 || session.clockRates[111] != 48000 {
+		t.Fatalf("unexpected clock rates %v", session.clockRates)
+	}
+	if defaultClockRates[8] != 8000 {
+		t.Fatalf("expected the defaults to stay untouched, got %v", defaultClockRates)
+	}
+}
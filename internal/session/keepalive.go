@@ -0,0 +1,99 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Keepalive formats.
+const (
+	// KeepaliveEmpty sends a single zero byte; zero-length datagrams are
+	// dropped by some NATs.
+	KeepaliveEmpty = "empty"
+	// KeepaliveRTPNOP sends a padding-only RTP packet with an unassigned
+	// payload type, for receivers that log anything that is not RTP.
+	KeepaliveRTPNOP = "rtp_nop"
+)
+
+// keepalivePT is unassigned in RFC 3551, so receivers discard the packet.
+const keepalivePT = 20
+
+// KeepaliveConfig makes each media send a keepalive from its B socket to the
+// rtpengine destination after Interval without sending anything, so that a
+// stateful firewall in front of rtpengine keeps the return path open. A zero
+// Interval disables it.
+type KeepaliveConfig struct {
+	Interval time.Duration
+	// Format is KeepaliveEmpty or KeepaliveRTPNOP.
+	Format string
+}
+
+// SetKeepalive sets the keepalive of every media. It must be called before
+// sessions are created.
+func (m *Manager) SetKeepalive(config KeepaliveConfig) {
+	m.keepalive = config
+}
+
+// keepaliveSender builds the keepalive datagrams of one media.
+type keepaliveSender struct {
+	format string
+	seq    uint16
+	ssrc   uint32
+}
+
+func newKeepaliveSender(format string) *keepaliveSender {
+	return &keepaliveSender{format: format, seq: uint16(rand.Uint32()), ssrc: rand.Uint32()}
+}
+
+// packet returns the next keepalive datagram.
+func (k *keepaliveSender) packet() []byte {
+	if k.format != KeepaliveRTPNOP {
+		return []byte{0}
+	}
+	// A 12-byte header with the padding bit set and 4 bytes of padding, the
+	// last one counting them.
+	packet := make([]byte, 16)
+	packet[0] = 0xa0
+	packet[1] = keepalivePT
+	binary.BigEndian.PutUint16(packet[2:4], k.seq)
+	binary.BigEndian.PutUint32(packet[8:12], k.ssrc)
+	packet[15] = 4
+	k.seq++
+	return packet
+}
+
+// keepaliveLoop sends a keepalive from conn to the destination of media,
+// "audio" or "video", on every tick of the session's keepalive interval in
+// which outPkts, the packets the media sent on its B leg, did not move. sent
+// counts the keepalives, which stay out of the media counters. It returns
+// when ctx is done.
+func (s *Session) keepaliveLoop(ctx context.Context, media string, conn *net.UDPConn, outPkts, sent *atomic.Uint64) {
+	ticker := time.NewTicker(s.keepalive.Interval)
+	defer ticker.Stop()
+	sender := newKeepaliveSender(s.keepalive.Format)
+	lastOut := outPkts.Load()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if out := outPkts.Load(); out != lastOut {
+			lastOut = out
+			continue
+		}
+		dest := s.dest(media)
+		if dest == nil {
+			continue
+		}
+		if _, err := conn.WriteToUDP(sender.packet(), dest); err != nil {
+			s.Logger().Debug("media.keepalive failed", "media", media, "rtpengine_dest", dest.String(), "error", err)
+			continue
+		}
+		sent.Add(1)
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// TestKeepaliveSenderPacket verifies both keepalive formats. This matters
+// because receivers that log warnings on non-RTP need a valid RTP packet that
+// still carries no media. Inputs: an empty sender, an rtp_nop sender asked
+// for two packets and an unknown format. The expected output is a single zero
+// byte for empty and the unknown format, and 16-byte version 2 packets with
+// the padding bit, payload type 20, 4 padding bytes, one SSRC and consecutive
+// sequence numbers for rtp_nop.
+func TestKeepaliveSenderPacket(t *testing.T) {
+	for _, format := range []string{KeepaliveEmpty, ""} {
+		if packet := newKeepaliveSender(format).packet(); !bytes.Equal(packet, []byte{0}) {
+			t.Fatalf("expected a single zero byte for format %q, got %x", format, packet)
+		}
+	}
+	sender := newKeepaliveSender(KeepaliveRTPNOP)
+	first, second := sender.packet(), sender.packet()
+	for _, packet := range [][]byte{first, second} {
+		if len(packet) != 16 || packet[0] != 0xa0 || packet[1] != keepalivePT || packet[15] != 4 {
+			t.Fatalf("expected a padding-only RTP packet, got %x", packet)
+		}
+	}
+	firstHeader, _ := rtpfix.ParseRTPHeader(first)
+	secondHeader, _ := rtpfix.ParseRTPHeader(second)
+	if secondHeader.SSRC != firstHeader.SSRC || secondHeader.Seq != firstHeader.Seq+1 {
+		t.Fatalf("expected one SSRC and consecutive sequence numbers, got %+v and %+v", firstHeader, secondHeader)
+	}
+}
+
+// TestAudioProxyKeepalive verifies that a silent media keeps the rtpengine
+// return path open. Inputs: an audio-only session with a real proxy, a 20ms
+// keepalive interval in the rtp_nop format and its destination set to a local
+// rtpengine socket, with no media flowing. The expected output is rtp_nop
+// datagrams arriving from the B port, counted in KeepalivesSent while
+// BOutPkts stays at zero.
+func TestAudioProxyKeepalive(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1))
+	manager.SetKeepalive(KeepaliveConfig{Interval: 20 * time.Millisecond, Format: KeepaliveRTPNOP})
+	manager.listenUDP = net.ListenUDP
+	manager.newAudioProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy {
+		return newAudioProxy(session, aConn, bConn, peerLearningWindow, logConfig)
+	}
+	rtpengine, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+	defer rtpengine.Close()
+	created, err := manager.CreateWithOptions("call-keepalive", "from", "to", false, CreateOptions{
+		DisableVideo:     true,
+		InitialAudioDest: rtpengine.LocalAddr().(*net.UDPAddr),
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	t.Cleanup(func() { manager.StopAllSessions() })
+
+	buffer := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		_ = rtpengine.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, addr, err := rtpengine.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("expected a keepalive, got %v", err)
+		}
+		if addr.Port != created.Audio.BPort || n != 16 || buffer[1] != keepalivePT {
+			t.Fatalf("expected an rtp_nop keepalive from port %d, got %x from %s", created.Audio.BPort, buffer[:n], addr)
+		}
+	}
+	counters := created.AudioCountersSnapshot()
+	// The second keepalive may arrive before it is counted.
+	if counters.KeepalivesSent < 1 || counters.BOutPkts != 0 {
+		t.Fatalf("expected keepalives counted apart from media, got %d keepalives and %d b_out_pkts", counters.KeepalivesSent, counters.BOutPkts)
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+var (
+	ErrVideoNotEnabled  = errors.New("video is not enabled")
+	ErrVideoFixDisabled = errors.New("video fix mode is off")
+	ErrVideoPeerUnknown = errors.New("video doorphone peer not learned yet")
+)
+
+// minPLIInterval is the least time between two keyframe requests sent to
+// the doorphone; requests in between only re-arm the SPS/PPS injection.
+const minPLIInterval = time.Second
+
+// keyframeRequester is implemented by proxies that can speed up decoder
+// start for a viewer joining mid-call.
+type keyframeRequester interface {
+	requestKeyframe(sendPLI bool) error
+}
+
+// RequestKeyframe arms re-injection of the cached SPS/PPS before the next IDR
+// and, with sendPLI, asks the doorphone for a new keyframe with an RTCP PLI on
+// the video A leg.
+func (m *Manager) RequestKeyframe(id string, sendPLI bool) (*Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	if !session.videoEnabled.Load() {
+		return session, true, ErrVideoNotEnabled
+	}
+	if !session.Settings.VideoFix {
+		return session, true, ErrVideoFixDisabled
+	}
+	requester, ok := session.videoProxy.(keyframeRequester)
+	if !ok {
+		return session, true, ErrVideoFixDisabled
+	}
+	if err := requester.requestKeyframe(sendPLI); err != nil {
+		return session, true, err
+	}
+	session.videoCounters.videoKeyframeRequests.Add(1)
+	m.publish(EventSessionUpdated, session)
+	return session, true, nil
+}
+
+func (p *videoProxy) requestKeyframe(sendPLI bool) error {
+	if sendPLI {
+		if err := p.sendPLI(); err != nil {
+			return err
+		}
+	}
+	p.bufferMu.Lock()
+	p.forceInjectOnIDR = true
+	p.bufferMu.Unlock()
+	p.logger.Info("video keyframe requested", "send_pli", sendPLI)
+	return nil
+}
+
+// peerSSRC returns the SSRC of the last RTP packet accepted from the
+// doorphone.
+func (p *videoProxy) peerSSRC() (uint32, bool) {
+	value := p.lastPeerSSRC.Load()
+	return uint32(value), value != 0
+}
+
+func (p *videoProxy) recordPeerSSRC(ssrc uint32) {
+	// Bit 32 marks the value as set so that SSRC 0 stays distinguishable.
+	p.lastPeerSSRC.Store(1<<32 | uint64(ssrc))
+}
+
+// sendPLI asks the doorphone for a keyframe with an RTCP PLI, followed by a
+// FIR under KeyframeRequestFIR, from the video A socket to its RTP port or
+// the port after it. At most one request goes out per minPLIInterval.
+func (p *videoProxy) sendPLI() error {
+	peer := p.getDoorphonePeer()
+	mediaSSRC, ok := p.peerSSRC()
+	if peer == nil || !ok {
+		return ErrVideoPeerUnknown
+	}
+	p.keyframeMu.Lock()
+	defer p.keyframeMu.Unlock()
+	now := time.Now()
+	if !p.lastPLISent.IsZero() && now.Sub(p.lastPLISent) < minPLIInterval {
+		p.logger.Debug("video pli rate limited", "last_sent", p.lastPLISent)
+		return nil
+	}
+	if p.fixConfig.KeyframeRequestRTCPPort {
+		peer = &net.UDPAddr{IP: peer.IP, Port: peer.Port + 1, Zone: peer.Zone}
+	}
+	senderSSRC := rand.Uint32()
+	if _, err := p.aConn.WriteToUDP(rtpfix.BuildPLI(senderSSRC, mediaSSRC), peer); err != nil {
+		return fmt.Errorf("send pli: %w", err)
+	}
+	p.lastPLISent = now
+	p.session.videoCounters.videoPLISent.Add(1)
+	if p.fixConfig.KeyframeRequestFIR {
+		p.firSeq++
+		if _, err := p.aConn.WriteToUDP(rtpfix.BuildFIR(senderSSRC, mediaSSRC, p.firSeq), peer); err != nil {
+			return fmt.Errorf("send fir: %w", err)
+		}
+		p.session.videoCounters.videoFIRSent.Add(1)
+	}
+	return nil
+}
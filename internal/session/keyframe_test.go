@@ -0,0 +1,223 @@
+package session
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/logging"
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+type keyframeProxy struct {
+	noopProxy
+	calls   int
+	sendPLI bool
+	err     error
+}
+
+func (p *keyframeProxy) requestKeyframe(sendPLI bool) error {
+	p.calls++
+	p.sendPLI = sendPLI
+	return p.err
+}
+
+// TestVideoProxyRequestKeyframeForcesInjection verifies that a keyframe
+// request injects the cached SPS/PPS before the next IDR even when an in-band
+// parameter set is pending, which normally suppresses injection. Inputs:
+// cached SPS/PPS, a pending SPS before each of two IDRs, and a request before
+// the second. The expected output is no injection for the first IDR and one
+// SPS and one PPS injection for the second.
+func TestVideoProxyRequestKeyframeForcesInjection(t *testing.T) {
+	session := &Session{ID: "S-keyframe"}
+	proxy := &videoProxy{
+		session:            session,
+		fixEnabled:         true,
+		injectCachedSPSPPS: true,
+		logger:             logging.WithSessionID(session.ID),
+		writeToDest:        func([]byte, *net.UDPAddr) error { return nil },
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	spsPacket := makeRTPPacket(10, 9000, []byte{0x67})
+	ppsPacket := makeRTPPacket(11, 9000, []byte{0x68})
+	spsInfo, _ := proxy.parseH264Packet(spsPacket)
+	ppsInfo, _ := proxy.parseH264Packet(ppsPacket)
+	proxy.cacheParameterSet(spsInfo.payload)
+	proxy.cacheParameterSet(ppsInfo.payload)
+
+	proxy.storePendingParameterSet(spsPacket)
+	proxy.handleVideoPacket(makeRTPPacket(12, 9000, []byte{0x65}), dest)
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoInjectedSPS != 0 {
+		t.Fatalf("expected no injection with a pending SPS, got %d", counters.VideoInjectedSPS)
+	}
+
+	if err := proxy.requestKeyframe(false); err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	proxy.storePendingParameterSet(makeRTPPacket(13, 12000, []byte{0x67}))
+	proxy.handleVideoPacket(makeRTPPacket(14, 12000, []byte{0x65}), dest)
+
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoInjectedSPS != 1 || counters.VideoInjectedPPS != 1 {
+		t.Fatalf("expected forced injection, got sps=%d pps=%d", counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+	}
+}
+
+// TestVideoProxyRequestKeyframeSendsPLI verifies the RTCP PLI sent to the
+// doorphone: it goes from the video A socket to the learned peer and names the
+// peer's SSRC as media source. Without a learned peer the request fails with
+// ErrVideoPeerUnknown. Inputs: loopback sockets for the A leg and the peer.
+// The expected output is a 12 byte PSFB/FMT=1 packet with media SSRC
+// 0x11223344.
+func TestVideoProxyRequestKeyframeSendsPLI(t *testing.T) {
+	aConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen a leg: %v", err)
+	}
+	defer aConn.Close()
+	peerConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen peer: %v", err)
+	}
+	defer peerConn.Close()
+	session := &Session{ID: "S-pli"}
+	proxy := &videoProxy{session: session, aConn: aConn, fixEnabled: true, logger: logging.WithSessionID(session.ID)}
+
+	if err := proxy.requestKeyframe(true); !errors.Is(err, ErrVideoPeerUnknown) {
+		t.Fatalf("expected ErrVideoPeerUnknown, got %v", err)
+	}
+
+	proxy.updateDoorphonePeer(peerConn.LocalAddr().(*net.UDPAddr))
+	proxy.recordPeerSSRC(0x11223344)
+	if err := proxy.requestKeyframe(true); err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	_ = peerConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 64)
+	n, from, err := peerConn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("read pli: %v", err)
+	}
+	if from.Port != aConn.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("expected PLI from the A leg port, got %v", from)
+	}
+	if n != rtpfix.PLILen || buffer[0] != 0x81 || buffer[1] != 206 || binary.BigEndian.Uint16(buffer[2:4]) != 2 {
+		t.Fatalf("unexpected PLI header % x", buffer[:n])
+	}
+	if mediaSSRC := binary.BigEndian.Uint32(buffer[8:12]); mediaSSRC != 0x11223344 {
+		t.Fatalf("unexpected media SSRC %#x", mediaSSRC)
+	}
+}
+
+// TestVideoProxyKeyframeRequestRateLimit verifies the PLI rate limit and the
+// optional FIR on the RTCP port. This matters because a viewer client
+// retrying its keyframe request would otherwise flood the doorphone with
+// feedback. Inputs: KEYFRAME_REQUEST_RTCP_PORT and KEYFRAME_REQUEST_FIR on,
+// a peer whose RTP port is one below a listening socket, and three requests,
+// the last one after the PLI interval. The expected output is a PLI then a
+// FIR with sequence number 1 for the first request, nothing for the second,
+// a FIR with sequence number 2 for the third, and two PLIs and two FIRs
+// counted.
+func TestVideoProxyKeyframeRequestRateLimit(t *testing.T) {
+	aConn := mustListenUDP(t)
+	defer aConn.Close()
+	rtcpConn := mustListenUDP(t)
+	defer rtcpConn.Close()
+	session := &Session{ID: "S-pli-limit"}
+	proxy := &videoProxy{
+		session:    session,
+		aConn:      aConn,
+		fixEnabled: true,
+		fixConfig:  VideoFixConfig{KeyframeRequestRTCPPort: true, KeyframeRequestFIR: true},
+		logger:     logging.WithSessionID(session.ID),
+	}
+	rtcpAddr := localUDPAddr(rtcpConn)
+	proxy.updateDoorphonePeer(&net.UDPAddr{IP: rtcpAddr.IP, Port: rtcpAddr.Port - 1})
+	proxy.recordPeerSSRC(0x11223344)
+	read := func() []byte {
+		t.Helper()
+		buffer := make([]byte, 64)
+		_ = rtcpConn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := rtcpConn.Read(buffer)
+		if err != nil {
+			t.Fatalf("read rtcp: %v", err)
+		}
+		return buffer[:n]
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := proxy.requestKeyframe(true); err != nil {
+			t.Fatalf("unexpected request error: %v", err)
+		}
+	}
+	if pli := read(); len(pli) != rtpfix.PLILen || pli[0] != 0x81 {
+		t.Fatalf("expected a PLI, got % x", pli)
+	}
+	if fir := read(); len(fir) != rtpfix.FIRLen || fir[0] != 0x84 || fir[16] != 1 {
+		t.Fatalf("expected a FIR with sequence number 1, got % x", fir)
+	}
+	_ = rtcpConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := rtcpConn.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("expected the second request rate limited, got %d bytes", n)
+	}
+
+	proxy.lastPLISent = time.Now().Add(-minPLIInterval)
+	if err := proxy.requestKeyframe(true); err != nil {
+		t.Fatalf("unexpected request error: %v", err)
+	}
+	read()
+	if fir := read(); fir[16] != 2 {
+		t.Fatalf("expected a FIR with sequence number 2, got % x", fir)
+	}
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoPLISent != 2 || counters.VideoFIRSent != 2 {
+		t.Fatalf("expected 2 PLIs and 2 FIRs sent, got %d and %d", counters.VideoPLISent, counters.VideoFIRSent)
+	}
+}
+
+// TestManager_RequestKeyframe verifies the manager preconditions and the
+// video_keyframe_requests counter. Inputs: a session without video fix, one
+// with video not requested, and one with fix on whose proxy accepts the
+// request. The expected output is ErrVideoFixDisabled, ErrVideoNotEnabled, and
+// then one proxy call with sendPLI and a counter of 1.
+func TestManager_RequestKeyframe(t *testing.T) {
+	manager := newTestManager(t, 0)
+	proxy := &keyframeProxy{}
+	manager.newVideoProxy = func(*Session, *net.UDPConn, *net.UDPConn, time.Duration, time.Duration, bool, bool, VideoFixConfig, ProxyLogConfig) sessionProxy {
+		return proxy
+	}
+
+	raw, err := manager.Create("call-kf-raw", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, _, err := manager.RequestKeyframe(raw.ID, false); !errors.Is(err, ErrVideoFixDisabled) {
+		t.Fatalf("expected ErrVideoFixDisabled, got %v", err)
+	}
+	audioOnly, err := manager.Create("call-kf-audio", "from", "to", true, false, true)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, _, err := manager.RequestKeyframe(audioOnly.ID, false); !errors.Is(err, ErrVideoNotEnabled) {
+		t.Fatalf("expected ErrVideoNotEnabled, got %v", err)
+	}
+
+	fixed, err := manager.Create("call-kf", "from", "to", true, true, true)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, ok, err := manager.RequestKeyframe(fixed.ID, true); !ok || err != nil {
+		t.Fatalf("expected request to succeed, got ok=%v err=%v", ok, err)
+	}
+	if proxy.calls != 1 || !proxy.sendPLI {
+		t.Fatalf("expected one proxy call with PLI, got calls=%d sendPLI=%v", proxy.calls, proxy.sendPLI)
+	}
+	if got := fixed.VideoCountersSnapshot().VideoKeyframeRequests; got != 1 {
+		t.Fatalf("expected 1 keyframe request, got %d", got)
+	}
+	if _, ok, _ := manager.RequestKeyframe("missing", false); ok {
+		t.Fatalf("expected missing session to report not found")
+	}
+}
@@ -0,0 +1,35 @@
+package session
+
+import (
+	"log/slog"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+// SetLogLevel overrides LOG_LEVEL for the session's logger, including the
+// proxies that are already running. A nil level returns to the global level.
+func (m *Manager) SetLogLevel(id string, level *slog.Level) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if level == nil {
+		session.logLevel.Clear()
+	} else {
+		session.logLevel.Set(*level)
+	}
+	m.publish(EventSessionUpdated, session)
+	return session, true
+}
+
+// LogLevel returns the session's level override as a LOG_LEVEL name, or ""
+// when the session follows the global level.
+func (s *Session) LogLevel() string {
+	level, ok := s.logLevel.Level()
+	if !ok {
+		return ""
+	}
+	return logging.LevelName(level)
+}
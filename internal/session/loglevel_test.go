@@ -0,0 +1,52 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+// TestManager_SetLogLevel_AppliesToExistingLoggers verifies that the
+// per-session level set at create time and changed later is honored by a
+// logger obtained before the change, as the proxies hold theirs for the whole
+// session. Inputs: create with LogLevel debug, then error, then nil. The
+// expected output is debug enabled, then info disabled, then the global
+// logger's decision, with LogLevel reporting "debug", "error" and "".
+func TestManager_SetLogLevel_AppliesToExistingLoggers(t *testing.T) {
+	manager := newTestManager(t, 0)
+	debug := slog.LevelDebug
+	created, err := manager.CreateWithOptions("call-level", "from", "to", false, CreateOptions{LogLevel: &debug})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	ctx := context.Background()
+	logger := created.Logger()
+	if !logger.Enabled(ctx, slog.LevelDebug) || created.LogLevel() != "debug" {
+		t.Fatalf("expected debug override, got level %q", created.LogLevel())
+	}
+
+	errorLevel := slog.LevelError
+	if _, ok := manager.SetLogLevel(created.ID, &errorLevel); !ok {
+		t.Fatalf("expected session to be found")
+	}
+	if logger.Enabled(ctx, slog.LevelInfo) || created.LogLevel() != "error" {
+		t.Fatalf("expected info to be filtered at error level, got level %q", created.LogLevel())
+	}
+
+	if _, ok := manager.SetLogLevel(created.ID, nil); !ok {
+		t.Fatalf("expected session to be found")
+	}
+	if created.LogLevel() != "" {
+		t.Fatalf("expected no override, got %q", created.LogLevel())
+	}
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		if got, want := logger.Enabled(ctx, level), logging.L().Enabled(ctx, level); got != want {
+			t.Fatalf("level %v: expected global decision %v, got %v", level, want, got)
+		}
+	}
+	if _, ok := manager.SetLogLevel("missing", nil); ok {
+		t.Fatalf("expected missing session to report not found")
+	}
+}
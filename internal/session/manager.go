@@ -3,65 +3,363 @@ package session
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"rtp-stream-cleaner/internal/logging"
+	"rtp-stream-cleaner/internal/rtpfix"
 )
 
 type Media struct {
-	APort          int
-	BPort          int
-	RTPEngineDest  *net.UDPAddr
-	Enabled        bool
-	DisabledReason string
+	APort         int
+	BPort         int
+	RTPEngineDest *net.UDPAddr
+	// RTPEngineDestHost is the hostname RTPEngineDest was resolved from, empty
+	// when the destination was given as an IP literal.
+	RTPEngineDestHost     string
+	Enabled               bool
+	DisabledReason        string
+	PeerLearningWindow    time.Duration
+	PeerLearningRemaining time.Duration
+	// DoorphonePeer is the A-leg source address the proxy latched onto, nil
+	// until the first inbound packet. DoorphonePeerLearnedAt is when the
+	// current address was latched.
+	DoorphonePeer          *net.UDPAddr
+	DoorphonePeerLearnedAt time.Time
+	DoorphonePeerRelearned bool
+	// LastActivity is the last packet on either leg of this media.
+	LastActivity time.Time
+	// LockedSSRC is the A-leg SSRC the media locked onto when the session
+	// locks SSRCs; SSRCLocked is false until the first RTP packet.
+	LockedSSRC uint32
+	SSRCLocked bool
+	// OutputSSRC is the SSRC sent toward the B leg when RewriteSSRC is set.
+	OutputSSRC  uint32
+	RewriteSSRC bool
+	// Source is the pinned A-leg source, nil while the media learns its
+	// peer. SourceMatches reports whether the last A-leg sender matched it.
+	Source        *net.UDPAddr
+	SourceMatches bool
+}
+
+// DisabledReasonNotRequested marks media that was not enabled at create time.
+// Such media has no ports or sockets and cannot be given a destination later.
+const DisabledReasonNotRequested = "not_requested"
+
+// CreateOptions carries optional per-session settings for CreateWithOptions.
+// Nil fields fall back to the manager-wide defaults.
+type CreateOptions struct {
+	// DisableAudio and DisableVideo skip port allocation, socket binding and
+	// the proxy for media the caller did not request.
+	DisableAudio     bool
+	DisableVideo     bool
+	InitialAudioDest *net.UDPAddr
+	InitialVideoDest *net.UDPAddr
+	// InitialAudioDestHost and InitialVideoDestHost name the hostnames the
+	// initial destinations were resolved from.
+	InitialAudioDestHost    string
+	InitialVideoDestHost    string
+	AudioPeerLearningWindow *time.Duration
+	VideoPeerLearningWindow *time.Duration
+	Metadata                map[string]string
+	// LogLevel overrides LOG_LEVEL for this session's logger.
+	LogLevel *slog.Level
+	// AdvertiseIP replaces PUBLIC_IP in what the API reports for the session.
+	AdvertiseIP string
+	// RejectDuplicate fails the create with a DuplicateSessionError when a
+	// live session already exists for the same call_id/from_tag/to_tag.
+	RejectDuplicate bool
+	// MaxLifetime replaces MAX_SESSION_LIFETIME_SEC for this session; 0 means
+	// unlimited.
+	MaxLifetime *time.Duration
+	// Ports pins legs to caller-chosen even ports instead of allocating them.
+	Ports LegPorts
+	// PeerLearningWindow replaces PEER_LEARNING_WINDOW_SEC for the session;
+	// AudioPeerLearningWindow and VideoPeerLearningWindow still win per media.
+	PeerLearningWindow *time.Duration
+	// MaxFrameWait replaces MAX_FRAME_WAIT_MS for the session's video fixer.
+	MaxFrameWait *time.Duration
+	// DropIncompleteFrames makes the video fixer discard a frame that is
+	// still incomplete when it times out or the next frame starts, or that
+	// is missing packets, instead of sending what it has.
+	DropIncompleteFrames bool
+	// VideoCodec is the codec the video fixer parses, VideoCodecH264 when
+	// empty.
+	VideoCodec string
+	// DropSEI makes the video fixer remove SEI NAL units from the stream.
+	DropSEI bool
+	// StripAnnexB makes the video fixer remove the Annex B start code some
+	// doorphones put ahead of the NAL unit in H264 payloads.
+	StripAnnexB bool
+	// VideoTimestampMode is how the video fixer stamps the frames it sends,
+	// VideoTimestampRewrite when empty.
+	VideoTimestampMode string
+	// VideoMaxKbps drops video packets toward rtpengine beyond this many
+	// kilobits per second; 0 means unlimited.
+	VideoMaxKbps int
+	// AudioSource and VideoSource pin the A-leg source of each media, which
+	// then skips peer learning; port 0 accepts any port from the IP.
+	AudioSource *net.UDPAddr
+	VideoSource *net.UDPAddr
+	// LockSSRC makes each media forward only the first SSRC seen on its A
+	// leg and drop other streams from the doorphone as foreign.
+	LockSSRC bool
+	// RewriteAudioSSRC and RewriteVideoSSRC send the media toward the B leg
+	// under one SSRC, AudioOutputSSRC and VideoOutputSSRC when set and a
+	// random one otherwise.
+	RewriteAudioSSRC bool
+	RewriteVideoSSRC bool
+	AudioOutputSSRC  *uint32
+	VideoOutputSSRC  *uint32
+	// AllowHairpin accepts initial destinations on a local media port, for
+	// test rigs.
+	AllowHairpin bool
+	// DSCP and TTL replace RTP_DSCP and RTP_TTL on the session's sockets; 0
+	// keeps the system default.
+	DSCP *int
+	TTL  *int
+}
+
+// LegPorts are the RTP ports of the four legs; 0 leaves a leg to the
+// allocator. Each pinned port takes the odd port after it for RTCP.
+type LegPorts struct {
+	AudioA, AudioB, VideoA, VideoB int
+}
+
+func (p LegPorts) pinned() bool {
+	return p != LegPorts{}
+}
+
+// ErrSessionLimitReached is returned by create when MAX_SESSIONS sessions are
+// live.
+var ErrSessionLimitReached = errors.New("session limit reached")
+
+// ErrDuplicateSession is matched by DuplicateSessionError.
+var ErrDuplicateSession = errors.New("session already exists for call_id, from_tag and to_tag")
+
+// DuplicateSessionError carries the ID of the session that already serves the
+// dialog so that retrying controllers can adopt it.
+type DuplicateSessionError struct {
+	SessionID string
+}
+
+func (e *DuplicateSessionError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrDuplicateSession, e.SessionID)
+}
+
+func (e *DuplicateSessionError) Unwrap() error {
+	return ErrDuplicateSession
+}
+
+// ErrCallSessionLimitReached is matched by CallSessionLimitError.
+var ErrCallSessionLimitReached = errors.New("session limit reached for call_id")
+
+// CallSessionLimitError rejects a create once MAX_SESSIONS_PER_CALL sessions
+// serve the call. SessionIDs lists them, sorted, so that the controller can
+// clean up the ones it leaked.
+type CallSessionLimitError struct {
+	CallID     string
+	SessionIDs []string
+}
+
+func (e *CallSessionLimitError) Error() string {
+	return fmt.Sprintf("%s %s: %s", ErrCallSessionLimitReached, e.CallID, strings.Join(e.SessionIDs, ", "))
+}
+
+func (e *CallSessionLimitError) Unwrap() error {
+	return ErrCallSessionLimitReached
+}
+
+// dialogKey identifies the dialog a session was created for.
+type dialogKey struct {
+	callID  string
+	fromTag string
+	toTag   string
 }
 
 type Session struct {
-	ID                  string
-	CallID              string
-	FromTag             string
-	ToTag               string
-	CreatedAt           time.Time
+	ID        string
+	CallID    string
+	FromTag   string
+	ToTag     string
+	CreatedAt time.Time
+	// Restored marks a session re-created from STATE_DIR after a restart.
+	Restored bool
+	// ExpiresAt is when the reaper removes the session regardless of
+	// activity; zero when its lifetime is unlimited.
+	ExpiresAt time.Time
+	// AdvertiseIP overrides the public IP reported for this session; empty
+	// means PUBLIC_IP. It does not affect socket binding.
+	AdvertiseIP         string
+	Settings            Settings
 	Audio               Media
 	Video               Media
-	LastActivity        time.Time
-	State               string
-	AudioCounters       AudioCounters
-	VideoCounters       VideoCounters
 	audioProxy          sessionProxy
+	audioRTCPProxy      sessionProxy
+	audioActivity       mediaActivity
 	audioCounters       audioCounters
 	audioDest           atomic.Pointer[net.UDPAddr]
+	audioDestHost       atomic.Value
 	audioEnabled        atomic.Bool
 	audioDisabledReason atomic.Value
+	// audioSource pins the A-leg source of the media, nil while it learns
+	// its peer.
+	audioSource         atomic.Pointer[net.UDPAddr]
 	videoProxy          sessionProxy
+	videoRTCPProxy      sessionProxy
+	videoActivity       mediaActivity
 	videoCounters       videoCounters
 	videoDest           atomic.Pointer[net.UDPAddr]
+	videoDestHost       atomic.Value
 	videoEnabled        atomic.Bool
 	videoDisabledReason atomic.Value
-	lastActivityNsec    atomic.Int64
-	state               atomic.Int32
+	// videoSource pins the A-leg source of the media, nil while it learns
+	// its peer.
+	videoSource          atomic.Pointer[net.UDPAddr]
+	videoFixBypassed     atomic.Bool
+	videoFixBypassReason atomic.Value
+	videoSPS             atomic.Pointer[rtpfix.SPSInfo]
+	metadata             atomic.Pointer[map[string]string]
+	capture              atomic.Pointer[sessionCapture]
+	lastCapture          atomic.Pointer[sessionCapture]
+	logMetadataKeys      []string
+	clockRates           map[uint8]uint32
+	dtmfPT               uint8
+	nonRTPGate           bool
+	relearnAfter         time.Duration
+	peerSwitchPackets    int
+	bStrictPort          bool
+	keepalive            KeepaliveConfig
+	maxPacketSize        int
+	tsJumpThreshold      time.Duration
+	// isLocalMediaPort tells packets this process may have sent itself; nil
+	// disables the hairpin guard.
+	isLocalMediaPort func(*net.UDPAddr) bool
+	logLevel         logging.LevelOverride
+	history          history
+	lastActivityNsec atomic.Int64
+	state            atomic.Int32
+	// socketInodes identify the media sockets in /proc/net/udp, whose drops
+	// are summed in kernelRcvDrops.
+	socketInodes   []uint64
+	kernelRcvDrops atomic.Uint64
+	// onActive is called once when the first packet moves the session from
+	// created to active.
+	onActive func(*Session)
+	// audioSSRCLock and videoSSRCLock hold the latched SSRCs under
+	// Settings.LockSSRC.
+	audioSSRCLock ssrcLatch
+	videoSSRCLock ssrcLatch
+	// audioSSRCRewrite and videoSSRCRewrite are nil unless the media rewrites
+	// its SSRC toward the B leg.
+	audioSSRCRewrite *ssrcRewriter
+	videoSSRCRewrite *ssrcRewriter
+}
+
+// Settings is the configuration a session was created with.
+type Settings struct {
+	VideoFix           bool
+	VideoInjectSPSPPS  bool
+	PeerLearningWindow time.Duration
+	MaxFrameWait       time.Duration
+	LockSSRC           bool
+	// DropIncompleteFrames is only set with video fix on.
+	DropIncompleteFrames bool
+	// VideoCodec is VideoCodecH264 or VideoCodecH265.
+	VideoCodec string
+	// DropSEI is only set with video fix on.
+	DropSEI bool
+	// StripAnnexB is only set with video fix on.
+	StripAnnexB bool
+	// VideoTimestampMode is VideoTimestampRewrite or
+	// VideoTimestampPassthrough.
+	VideoTimestampMode string
+	// VideoMaxKbps limits what the video sends toward rtpengine; 0 means
+	// unlimited.
+	VideoMaxKbps int
+	// Marking is set on every media socket of the session.
+	Marking SocketMarking
 }
 
+// Video codecs the fixer can parse.
+const (
+	VideoCodecH264 = "h264"
+	VideoCodecH265 = "h265"
+)
+
+// Timestamp modes of the video fixer. Rewrite stamps each frame it sends from
+// the server clock, 10 to 100ms after the previous one; passthrough keeps the
+// RTP timestamp the doorphone gave the frame's first packet.
+const (
+	VideoTimestampRewrite     = "rewrite"
+	VideoTimestampPassthrough = "passthrough"
+)
+
 type Manager struct {
 	mu                      sync.Mutex
 	sessions                map[string]*Session
+	dialogs                 map[dialogKey]string
 	allocator               *PortAllocator
 	peerLearningWindow      time.Duration
 	maxFrameWait            time.Duration
 	idleTimeout             time.Duration
 	videoInjectCachedSPSPPS bool
+	videoFixConfig          VideoFixConfig
 	proxyLogConfig          ProxyLogConfig
+	captureDir              string
+	maxLifetime             time.Duration
+	maxSessions             int
+	maxSessionsPerCall      int
+	callSessions            map[string]int
+	pendingCreates          int
+	bindIPA                 net.IP
+	bindIPB                 net.IP
+	localIPs                []net.IP
+	bindConflicts           atomic.Uint64
+	clockRates              map[uint8]uint32
+	dtmfPT                  uint8
+	nonRTPGate              bool
+	peerRelearnAfter        time.Duration
+	peerSwitchPackets       int
+	bStrictPort             bool
+	socketMarking           SocketMarking
+	socketBuffers           SocketBuffers
+	socketBuffersLogged     sync.Once
+	maxPacketSize           int
+	tsJumpThreshold         time.Duration
+	keepalive               KeepaliveConfig
+	stats                   managerStats
 	now                     func() time.Time
 	listenUDP               func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)
+	resolveUDPAddr          func(network, address string) (*net.UDPAddr, error)
+	markSocket              func(conn *net.UDPConn, marking SocketMarking) error
 	newAudioProxy           func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy
-	newVideoProxy           func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, logConfig ProxyLogConfig) sessionProxy
+	newVideoProxy           func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig) sessionProxy
+	newRTCPProxy            func(session *Session, media string, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration) sessionProxy
+	events                  eventBus
+	shuttingDown            atomic.Bool
 	stopCh                  chan struct{}
 	stopOnce                sync.Once
 	wg                      sync.WaitGroup
+	// stateFile is the STATE_DIR state file, empty when persistence is off.
+	// stateDirty wakes the persister after a session change.
+	stateFile  string
+	stateDirty chan struct{}
+	observers  observers
+	// poolLowWater is the free port count below which a warning is logged
+	// once; poolLow remembers that the pool is below it.
+	poolLowWater atomic.Int64
+	poolLow      atomic.Bool
+	// logger receives the port pool alerts.
+	logger func() *slog.Logger
 }
 
 type sessionProxy interface {
@@ -69,11 +367,35 @@ type sessionProxy interface {
 	stop()
 }
 
+// peerLearner is implemented by proxies that learn the doorphone peer on leg A.
+type peerLearner interface {
+	doorphonePeerState() doorphonePeerState
+}
+
+// frameBufferReporter is implemented by proxies that buffer video frames.
+type frameBufferReporter interface {
+	frameBufferState(now time.Time) VideoBufferState
+}
+
+// doorphonePeerState is a snapshot of the learned doorphone peer. learnedAt
+// is the first packet and anchors the learning window; relearnedAt is set
+// when the peer moved inside that window.
+type doorphonePeerState struct {
+	peer        *net.UDPAddr
+	learnedAt   time.Time
+	relearnedAt time.Time
+	// lastSender is the source of the last A-leg packet, accepted or not.
+	lastSender *net.UDPAddr
+}
+
 type managerDeps struct {
 	now           func() time.Time
 	listenUDP     func(network string, laddr *net.UDPAddr) (*net.UDPConn, error)
+	resolveUDP    func(network, address string) (*net.UDPAddr, error)
+	markSocket    func(conn *net.UDPConn, marking SocketMarking) error
 	newAudioProxy func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy
-	newVideoProxy func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, logConfig ProxyLogConfig) sessionProxy
+	newVideoProxy func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig) sessionProxy
+	newRTCPProxy  func(session *Session, media string, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration) sessionProxy
 	startReaper   bool
 }
 
@@ -82,149 +404,556 @@ type ProxyLogConfig struct {
 	PacketLog          bool
 	PacketLogSampleN   uint64
 	PacketLogOnAnomaly bool
+	// MetadataKeys selects session metadata labels added to session logs.
+	MetadataKeys []string
 }
 
-func NewManager(allocator *PortAllocator, peerLearningWindow, maxFrameWait, idleTimeout time.Duration, videoInjectCachedSPSPPS bool, logConfig ProxyLogConfig) *Manager {
-	return newManagerWithDeps(allocator, peerLearningWindow, maxFrameWait, idleTimeout, videoInjectCachedSPSPPS, logConfig, managerDeps{startReaper: true})
+// NewManager creates a session manager. An empty captureDir disables
+// per-session packet capture.
+func NewManager(allocator *PortAllocator, peerLearningWindow, maxFrameWait, idleTimeout time.Duration, videoInjectCachedSPSPPS bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig, captureDir string) *Manager {
+	return newManagerWithDeps(allocator, peerLearningWindow, maxFrameWait, idleTimeout, videoInjectCachedSPSPPS, fixConfig, logConfig, captureDir, managerDeps{startReaper: true})
 }
 
-func newManagerWithDeps(allocator *PortAllocator, peerLearningWindow, maxFrameWait, idleTimeout time.Duration, videoInjectCachedSPSPPS bool, logConfig ProxyLogConfig, deps managerDeps) *Manager {
+func newManagerWithDeps(allocator *PortAllocator, peerLearningWindow, maxFrameWait, idleTimeout time.Duration, videoInjectCachedSPSPPS bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig, captureDir string, deps managerDeps) *Manager {
 	if deps.now == nil {
 		deps.now = time.Now
 	}
 	if deps.listenUDP == nil {
 		deps.listenUDP = net.ListenUDP
 	}
+	if deps.resolveUDP == nil {
+		deps.resolveUDP = net.ResolveUDPAddr
+	}
+	if deps.markSocket == nil {
+		deps.markSocket = markSocket
+	}
 	if deps.newAudioProxy == nil {
 		deps.newAudioProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy {
 			return newAudioProxy(session, aConn, bConn, peerLearningWindow, logConfig)
 		}
 	}
 	if deps.newVideoProxy == nil {
-		deps.newVideoProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, logConfig ProxyLogConfig) sessionProxy {
-			return newVideoProxy(session, aConn, bConn, peerLearningWindow, maxFrameWait, videoFix, inject, logConfig)
+		deps.newVideoProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, videoFix bool, inject bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig) sessionProxy {
+			return newVideoProxy(session, aConn, bConn, peerLearningWindow, maxFrameWait, videoFix, inject, fixConfig, logConfig)
+		}
+	}
+	if deps.newRTCPProxy == nil {
+		deps.newRTCPProxy = func(session *Session, media string, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration) sessionProxy {
+			return newRTCPProxy(session, media, aConn, bConn, peerLearningWindow)
 		}
 	}
 	manager := &Manager{
 		sessions:                make(map[string]*Session),
+		dialogs:                 make(map[dialogKey]string),
+		callSessions:            make(map[string]int),
+		logger:                  logging.L,
 		allocator:               allocator,
 		peerLearningWindow:      peerLearningWindow,
 		maxFrameWait:            maxFrameWait,
 		idleTimeout:             idleTimeout,
 		videoInjectCachedSPSPPS: videoInjectCachedSPSPPS,
+		videoFixConfig:          fixConfig,
 		proxyLogConfig:          logConfig,
+		captureDir:              captureDir,
+		clockRates:              defaultClockRates,
+		dtmfPT:                  DefaultDTMFPayloadType,
+		nonRTPGate:              true,
+		peerRelearnAfter:        DefaultPeerRelearnAfter,
+		peerSwitchPackets:       DefaultPeerSwitchPackets,
+		bStrictPort:             true,
+		maxPacketSize:           DefaultMaxPacketSize,
+		tsJumpThreshold:         DefaultTSDiscontinuityThreshold,
 		now:                     deps.now,
 		listenUDP:               deps.listenUDP,
+		resolveUDPAddr:          deps.resolveUDP,
+		markSocket:              deps.markSocket,
 		newAudioProxy:           deps.newAudioProxy,
 		newVideoProxy:           deps.newVideoProxy,
+		newRTCPProxy:            deps.newRTCPProxy,
+		stateDirty:              make(chan struct{}, 1),
 		stopCh:                  make(chan struct{}),
 	}
-	if idleTimeout > 0 && deps.startReaper {
+	if deps.startReaper {
 		manager.wg.Add(1)
 		go manager.reapIdleSessions()
 	}
 	return manager
 }
 
-func (m *Manager) Create(callID, fromTag, toTag string, videoFix bool) (*Session, error) {
-	return m.createWithDest(callID, fromTag, toTag, videoFix, nil, nil)
+func (m *Manager) Create(callID, fromTag, toTag string, audioEnabled, videoEnabled, videoFix bool) (*Session, error) {
+	return m.CreateWithOptions(callID, fromTag, toTag, videoFix, CreateOptions{
+		DisableAudio: !audioEnabled,
+		DisableVideo: !videoEnabled,
+	})
 }
 
-func (m *Manager) CreateWithInitialDest(callID, fromTag, toTag string, videoFix bool, initialAudioDest, initialVideoDest *net.UDPAddr) (*Session, error) {
-	return m.createWithDest(callID, fromTag, toTag, videoFix, initialAudioDest, initialVideoDest)
+func (m *Manager) CreateWithInitialDest(callID, fromTag, toTag string, audioEnabled, videoEnabled, videoFix bool, initialAudioDest, initialVideoDest *net.UDPAddr) (*Session, error) {
+	return m.CreateWithOptions(callID, fromTag, toTag, videoFix, CreateOptions{
+		DisableAudio:     !audioEnabled,
+		DisableVideo:     !videoEnabled,
+		InitialAudioDest: initialAudioDest,
+		InitialVideoDest: initialVideoDest,
+	})
 }
 
-func (m *Manager) createWithDest(callID, fromTag, toTag string, videoFix bool, initialAudioDest, initialVideoDest *net.UDPAddr) (*Session, error) {
-	ports, err := m.allocator.Allocate(4)
+func (m *Manager) CreateWithOptions(callID, fromTag, toTag string, videoFix bool, opts CreateOptions) (*Session, error) {
+	key := dialogKey{callID: callID, fromTag: fromTag, toTag: toTag}
+	if opts.RejectDuplicate {
+		if err := m.checkDuplicate(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.checkCallLimit(callID); err != nil {
+		return nil, err
+	}
+	if err := m.reserveSession(); err != nil {
+		return nil, err
+	}
+	defer m.releaseReservation()
+	ports, err := m.allocatePorts(opts)
 	if err != nil {
+		m.countNoPorts(err)
 		return nil, err
 	}
+	if !opts.AllowHairpin {
+		if err := m.checkHairpin(DestUpdate{Audio: opts.InitialAudioDest, Video: opts.InitialVideoDest}); err != nil {
+			m.allocator.Release(ports)
+			return nil, err
+		}
+	}
+	m.checkPoolLowWater()
+	session := m.newSession(callID, fromTag, toTag, videoFix, opts, m.now())
+	session.assignPorts(ports, !opts.DisableAudio, !opts.DisableVideo)
+
+	sockets, err := m.bindLegs(session, !opts.DisableAudio, !opts.DisableVideo, opts.Ports.pinned())
+	if err != nil {
+		m.countNoPorts(err)
+		session.Logger().Error("session.create failed", "error", err)
+		return nil, err
+	}
+	m.attachProxies(session, sockets, videoFix)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existingID, exists := m.dialogs[key]; exists && opts.RejectDuplicate {
+		// A concurrent create for the same dialog won the race.
+		m.stopSession(session)
+		return nil, &DuplicateSessionError{SessionID: existingID}
+	}
+	if err := m.callLimitLocked(callID); err != nil {
+		// Concurrent creates for the call filled it meanwhile.
+		m.stopSession(session)
+		return nil, err
+	}
+	for {
+		if _, exists := m.sessions[session.ID]; !exists {
+			break
+		}
+		session.ID = m.generateID()
+	}
+	m.sessions[session.ID] = session
+	m.callSessions[callID]++
+	if _, exists := m.dialogs[key]; !exists {
+		m.dialogs[key] = session.ID
+	}
+	// Queued before the proxies start so that OnCreated precedes OnActive.
+	m.observers.notify(notification{kind: observeCreated, session: session})
+	session.startProxies()
+	m.stats.sessionsCreated.Add(1)
+	m.publish(EventSessionCreated, session)
+	return session, nil
+}
+
+// countNoPorts counts a create rejected because the port pool ran dry and
+// logs it at error level, since the controller only sees a 503.
+func (m *Manager) countNoPorts(err error) {
+	if !errors.Is(err, ErrNoPortsAvailable) {
+		return
+	}
+	m.stats.createsRejectedNoPorts.Add(1)
+	stats := m.PoolStats()
+	m.logger().Error("port_pool.exhausted",
+		"size", stats.Size,
+		"free", stats.Free,
+		"in_use", stats.InUse,
+		"quarantined", stats.Quarantined,
+		"cooling_down", stats.CoolingDown,
+		"exhausted_total", stats.Exhausted,
+	)
+}
+
+// checkPoolLowWater warns once when the free ports drop below the low-water
+// mark and notes when they are back above it.
+func (m *Manager) checkPoolLowWater() {
+	lowWater := int(m.poolLowWater.Load())
+	if lowWater <= 0 {
+		return
+	}
+	free := m.allocator.Free()
+	if free >= lowWater {
+		if m.poolLow.CompareAndSwap(true, false) {
+			m.logger().Info("port_pool.recovered", "free", free, "low_water", lowWater)
+		}
+		return
+	}
+	if m.poolLow.CompareAndSwap(false, true) {
+		m.logger().Warn("port_pool.low", "free", free, "in_use", m.allocator.InUse(), "low_water", lowWater)
+	}
+}
+
+// newSession builds a session in the created state from opts. Ports, sockets
+// and proxies are attached by the caller.
+func (m *Manager) newSession(callID, fromTag, toTag string, videoFix bool, opts CreateOptions, createdAt time.Time) *Session {
+	peerLearningWindow := m.peerLearningWindow
+	if opts.PeerLearningWindow != nil {
+		peerLearningWindow = *opts.PeerLearningWindow
+	}
+	audioPeerLearningWindow := peerLearningWindow
+	if opts.AudioPeerLearningWindow != nil {
+		audioPeerLearningWindow = *opts.AudioPeerLearningWindow
+	}
+	videoPeerLearningWindow := peerLearningWindow
+	if opts.VideoPeerLearningWindow != nil {
+		videoPeerLearningWindow = *opts.VideoPeerLearningWindow
+	}
+	maxFrameWait := m.maxFrameWait
+	if opts.MaxFrameWait != nil {
+		maxFrameWait = *opts.MaxFrameWait
+	}
+	videoCodec := opts.VideoCodec
+	if videoCodec == "" {
+		videoCodec = VideoCodecH264
+	}
+	videoTimestampMode := opts.VideoTimestampMode
+	if videoTimestampMode == "" {
+		videoTimestampMode = VideoTimestampRewrite
+	}
+	marking := m.socketMarking
+	if opts.DSCP != nil {
+		marking.DSCP = *opts.DSCP
+	}
+	if opts.TTL != nil {
+		marking.TTL = *opts.TTL
+	}
+	maxLifetime := m.MaxSessionLifetime()
+	if opts.MaxLifetime != nil {
+		maxLifetime = *opts.MaxLifetime
+	}
 	session := &Session{
-		ID:        m.generateID(),
-		CallID:    callID,
-		FromTag:   fromTag,
-		ToTag:     toTag,
-		CreatedAt: m.now(),
+		ID:                m.generateID(),
+		CallID:            callID,
+		FromTag:           fromTag,
+		ToTag:             toTag,
+		CreatedAt:         createdAt,
+		AdvertiseIP:       opts.AdvertiseIP,
+		logMetadataKeys:   m.proxyLogConfig.MetadataKeys,
+		clockRates:        m.clockRates,
+		dtmfPT:            m.dtmfPT,
+		nonRTPGate:        m.nonRTPGate,
+		relearnAfter:      m.peerRelearnAfter,
+		peerSwitchPackets: m.peerSwitchPackets,
+		bStrictPort:       m.bStrictPort,
+		keepalive:         m.keepalive,
+		maxPacketSize:     m.maxPacketSize,
+		tsJumpThreshold:   m.tsJumpThreshold,
+		isLocalMediaPort:  m.isLocalMediaPort,
+		Settings: Settings{
+			VideoFix:             videoFix && !opts.DisableVideo,
+			VideoInjectSPSPPS:    m.videoInjectCachedSPSPPS && videoFix && !opts.DisableVideo,
+			PeerLearningWindow:   peerLearningWindow,
+			MaxFrameWait:         maxFrameWait,
+			LockSSRC:             opts.LockSSRC,
+			DropIncompleteFrames: opts.DropIncompleteFrames && videoFix && !opts.DisableVideo,
+			VideoCodec:           videoCodec,
+			DropSEI:              opts.DropSEI && videoFix && !opts.DisableVideo,
+			StripAnnexB:          opts.StripAnnexB && videoFix && !opts.DisableVideo,
+			VideoTimestampMode:   videoTimestampMode,
+			Marking:              marking,
+		},
 		Audio: Media{
-			APort:          ports[0],
-			BPort:          ports[1],
-			Enabled:        true,
-			DisabledReason: "",
+			Enabled:            true,
+			DisabledReason:     "",
+			PeerLearningWindow: audioPeerLearningWindow,
 		},
 		Video: Media{
-			APort:          ports[2],
-			BPort:          ports[3],
-			Enabled:        true,
-			DisabledReason: "",
+			Enabled:            true,
+			DisabledReason:     "",
+			PeerLearningWindow: videoPeerLearningWindow,
 		},
 	}
+	if maxLifetime > 0 {
+		session.ExpiresAt = createdAt.Add(maxLifetime)
+	}
+	session.onActive = func(session *Session) {
+		m.observers.notify(notification{kind: observeActive, session: session})
+	}
 	session.setState(stateCreated)
-	session.setLastActivity(m.now())
+	session.setLastActivity(createdAt)
 	session.audioDest.Store((*net.UDPAddr)(nil))
 	session.videoDest.Store((*net.UDPAddr)(nil))
 	session.audioEnabled.Store(true)
 	session.videoEnabled.Store(true)
 	session.audioDisabledReason.Store("")
 	session.videoDisabledReason.Store("")
-	applyRTPDest(session, initialAudioDest, initialVideoDest)
+	session.audioDestHost.Store("")
+	session.videoDestHost.Store("")
+	if opts.DisableAudio {
+		session.Audio.Enabled = false
+		session.Audio.DisabledReason = DisabledReasonNotRequested
+		session.audioEnabled.Store(false)
+		session.audioDisabledReason.Store(DisabledReasonNotRequested)
+	}
+	if opts.DisableVideo {
+		session.Video.Enabled = false
+		session.Video.DisabledReason = DisabledReasonNotRequested
+		session.videoEnabled.Store(false)
+		session.videoDisabledReason.Store(DisabledReasonNotRequested)
+	}
+	if opts.AudioSource != nil && !opts.DisableAudio {
+		session.audioSource.Store(cloneUDPAddr(opts.AudioSource))
+	}
+	if opts.VideoSource != nil && !opts.DisableVideo {
+		session.videoSource.Store(cloneUDPAddr(opts.VideoSource))
+	}
+	if !opts.DisableVideo {
+		session.Settings.VideoMaxKbps = opts.VideoMaxKbps
+	}
+	if opts.RewriteAudioSSRC && !opts.DisableAudio {
+		session.audioSSRCRewrite = newSSRCRewriter(opts.AudioOutputSSRC, &session.audioCounters.ssrcChanges)
+	}
+	if opts.RewriteVideoSSRC && !opts.DisableVideo {
+		session.videoSSRCRewrite = newSSRCRewriter(opts.VideoOutputSSRC, &session.videoCounters.ssrcChanges)
+	}
+	session.setMetadata(opts.Metadata)
+	if opts.LogLevel != nil {
+		session.logLevel.Set(*opts.LogLevel)
+	}
+	session.recordHistory(session.CreatedAt, HistoryCreated, "", "")
+	if opts.DisableAudio {
+		session.recordHistory(session.CreatedAt, HistoryMediaDisabled, "audio", DisabledReasonNotRequested)
+	}
+	if opts.DisableVideo {
+		session.recordHistory(session.CreatedAt, HistoryMediaDisabled, "video", DisabledReasonNotRequested)
+	}
+	applyRTPDest(session, DestUpdate{
+		Audio:     opts.InitialAudioDest,
+		Video:     opts.InitialVideoDest,
+		AudioHost: opts.InitialAudioDestHost,
+		VideoHost: opts.InitialVideoDestHost,
+	}, session.CreatedAt)
+	return session
+}
 
-	aConn, err := m.listenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: session.Audio.APort})
-	if err != nil {
-		logging.WithSessionID(session.ID).Error("session.create failed", "error", err)
-		m.allocator.Release(ports)
-		return nil, fmt.Errorf("audio a socket: %w", err)
+// attachProxies creates the RTP and RTCP proxies of the requested media on
+// the bound sockets without starting them.
+func (m *Manager) attachProxies(session *Session, sockets legSockets, videoFix bool) {
+	if session.Audio.APort != 0 {
+		session.audioProxy = m.newAudioProxy(session, sockets.audioA, sockets.audioB, session.Audio.PeerLearningWindow, m.proxyLogConfig)
+		session.audioRTCPProxy = m.newRTCPProxy(session, "audio", sockets.audioRTCPA, sockets.audioRTCPB, session.Audio.PeerLearningWindow)
 	}
-	bConn, err := m.listenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: session.Audio.BPort})
-	if err != nil {
-		logging.WithSessionID(session.ID).Error("session.create failed", "error", err)
-		if aConn != nil {
-			_ = aConn.Close()
-		}
-		m.allocator.Release(ports)
-		return nil, fmt.Errorf("audio b socket: %w", err)
+	if session.Video.APort != 0 {
+		session.videoProxy = m.newVideoProxy(session, sockets.videoA, sockets.videoB, session.Video.PeerLearningWindow, session.Settings.MaxFrameWait, videoFix, m.videoInjectCachedSPSPPS, m.videoFixConfig, m.proxyLogConfig)
+		session.videoRTCPProxy = m.newRTCPProxy(session, "video", sockets.videoRTCPA, sockets.videoRTCPB, session.Video.PeerLearningWindow)
 	}
-	videoAConn, err := m.listenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: session.Video.APort})
-	if err != nil {
-		logging.WithSessionID(session.ID).Error("session.create failed", "error", err)
-		if aConn != nil {
-			_ = aConn.Close()
-		}
-		if bConn != nil {
-			_ = bConn.Close()
+}
+
+// proxies lists the attached proxies of the session.
+func (s *Session) proxies() []sessionProxy {
+	var proxies []sessionProxy
+	for _, proxy := range []sessionProxy{s.audioProxy, s.audioRTCPProxy, s.videoProxy, s.videoRTCPProxy} {
+		if proxy != nil {
+			proxies = append(proxies, proxy)
 		}
-		m.allocator.Release(ports)
-		return nil, fmt.Errorf("video a socket: %w", err)
 	}
-	videoBConn, err := m.listenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: session.Video.BPort})
-	if err != nil {
-		logging.WithSessionID(session.ID).Error("session.create failed", "error", err)
-		if aConn != nil {
-			_ = aConn.Close()
-		}
-		if bConn != nil {
-			_ = bConn.Close()
-		}
-		if videoAConn != nil {
-			_ = videoAConn.Close()
+	return proxies
+}
+
+func (s *Session) startProxies() {
+	for _, proxy := range s.proxies() {
+		proxy.start()
+	}
+}
+
+func closeConn(conn *net.UDPConn) {
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// SetMaxSessions limits the number of concurrent sessions; 0 means
+// unlimited. Creates beyond the limit fail with ErrSessionLimitReached before
+// any port is allocated.
+func (m *Manager) SetMaxSessions(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSessions = limit
+}
+
+// SetMaxSessionsPerCall limits the number of concurrent sessions with the
+// same call_id; 0 means unlimited. Creates beyond the limit fail with a
+// CallSessionLimitError before any port is allocated.
+func (m *Manager) SetMaxSessionsPerCall(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxSessionsPerCall = limit
+}
+
+// SetPortPoolLowWater sets the free port count below which a create logs a
+// port_pool.low warning; 0 disables the warning.
+func (m *Manager) SetPortPoolLowWater(ports int) {
+	m.poolLowWater.Store(int64(ports))
+}
+
+// SetClockRates adds RTP clock rates by payload type to the defaults used for
+// jitter, replacing the default of a payload type it names. It must be called
+// before sessions are created.
+func (m *Manager) SetClockRates(rates map[uint8]uint32) {
+	merged := maps.Clone(defaultClockRates)
+	maps.Copy(merged, rates)
+	m.clockRates = merged
+}
+
+// SetDTMFPayloadType sets the telephone-event payload type counted as DTMF on
+// the audio A leg. It must be called before sessions are created.
+func (m *Manager) SetDTMFPayloadType(pt uint8) {
+	m.dtmfPT = pt
+}
+
+// SetDropNonRTP turns the gate that drops datagrams which cannot be RTP, such
+// as STUN, on both legs of every media on or off; it is on by default. It
+// must be called before sessions are created.
+func (m *Manager) SetDropNonRTP(enabled bool) {
+	m.nonRTPGate = enabled
+}
+
+// SetPeerRelearnAfter sets how long the doorphone peer of a media must be
+// silent before a new source may replace it once the learning window has
+// closed; 0 never replaces it. It must be called before sessions are created.
+func (m *Manager) SetPeerRelearnAfter(silence time.Duration) {
+	m.peerRelearnAfter = silence
+}
+
+// SetPeerSwitchPackets sets how many packets in a row a new source must send
+// before it replaces the learned doorphone peer of a media; 1 or less
+// replaces it on the first packet. It must be called before sessions are
+// created.
+func (m *Manager) SetPeerSwitchPackets(packets int) {
+	m.peerSwitchPackets = packets
+}
+
+// SetBLegStrictPort sets whether B-leg packets must come from the port of the
+// rtpengine destination as well as its IP; it is on by default. Turn it off
+// for rtpengine setups that send from another port than they receive on. It
+// must be called before sessions are created.
+func (m *Manager) SetBLegStrictPort(strict bool) {
+	m.bStrictPort = strict
+}
+
+// SetSocketMarking sets the DSCP and TTL of the media sockets of sessions
+// that do not choose their own. It must be called before sessions are
+// created.
+func (m *Manager) SetSocketMarking(marking SocketMarking) {
+	m.socketMarking = marking
+}
+
+// SetBindIPs sets the local addresses of the A and B leg sockets; nil means
+// 0.0.0.0. It must be called before sessions are created.
+func (m *Manager) SetBindIPs(a, b net.IP) {
+	m.bindIPA = a
+	m.bindIPB = b
+}
+
+// SetMaxSessionLifetime sets the age after which the reaper removes a session
+// regardless of activity, unless the session was created with its own
+// lifetime. 0 means unlimited. It applies to sessions created afterwards.
+func (m *Manager) SetMaxSessionLifetime(lifetime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxLifetime = lifetime
+}
+
+// MaxSessionLifetime returns the default session lifetime, 0 when unlimited.
+func (m *Manager) MaxSessionLifetime() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxLifetime
+}
+
+// MaxSessions returns the configured session limit, 0 when unlimited.
+func (m *Manager) MaxSessions() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxSessions
+}
+
+// reserveSession counts a create in progress against the session limit so
+// that concurrent creates cannot overshoot it while their sockets are bound.
+func (m *Manager) reserveSession() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxSessions > 0 && len(m.sessions)+m.pendingCreates >= m.maxSessions {
+		return ErrSessionLimitReached
+	}
+	m.pendingCreates++
+	return nil
+}
+
+func (m *Manager) releaseReservation() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pendingCreates--
+}
+
+// checkCallLimit rejects a create early, before ports are allocated, when
+// the call already has MAX_SESSIONS_PER_CALL sessions.
+func (m *Manager) checkCallLimit(callID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.callLimitLocked(callID)
+}
+
+func (m *Manager) callLimitLocked(callID string) error {
+	if m.maxSessionsPerCall <= 0 || m.callSessions[callID] < m.maxSessionsPerCall {
+		return nil
+	}
+	var ids []string
+	for id, session := range m.sessions {
+		if session.CallID == callID {
+			ids = append(ids, id)
 		}
-		m.allocator.Release(ports)
-		return nil, fmt.Errorf("video b socket: %w", err)
 	}
-	session.audioProxy = m.newAudioProxy(session, aConn, bConn, m.peerLearningWindow, m.proxyLogConfig)
-	session.videoProxy = m.newVideoProxy(session, videoAConn, videoBConn, m.peerLearningWindow, m.maxFrameWait, videoFix, m.videoInjectCachedSPSPPS, m.proxyLogConfig)
+	sort.Strings(ids)
+	return &CallSessionLimitError{CallID: callID, SessionIDs: ids}
+}
 
+func (m *Manager) checkDuplicate(key dialogKey) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	for {
-		if _, exists := m.sessions[session.ID]; !exists {
-			break
+	if existingID, exists := m.dialogs[key]; exists {
+		return &DuplicateSessionError{SessionID: existingID}
+	}
+	return nil
+}
+
+// removeLocked drops the session from the manager indexes. When several
+// sessions share a dialog the index moves to a remaining one. m.mu must be
+// held.
+func (m *Manager) removeLocked(session *Session) {
+	delete(m.sessions, session.ID)
+	if m.callSessions[session.CallID]--; m.callSessions[session.CallID] <= 0 {
+		delete(m.callSessions, session.CallID)
+	}
+	key := session.dialogKey()
+	if m.dialogs[key] != session.ID {
+		return
+	}
+	delete(m.dialogs, key)
+	for id, other := range m.sessions {
+		if other.dialogKey() == key {
+			m.dialogs[key] = id
+			return
 		}
-		session.ID = m.generateID()
 	}
-	m.sessions[session.ID] = session
-	session.audioProxy.start()
-	session.videoProxy.start()
-	return session, nil
 }
 
 func (m *Manager) Get(id string) (*Session, bool) {
@@ -237,72 +966,264 @@ func (m *Manager) Get(id string) (*Session, bool) {
 	return session, true
 }
 
-func (m *Manager) UpdateRTPDest(id string, audioDest, videoDest *net.UDPAddr) (*Session, bool) {
+// DestUpdate describes an rtpengine destination change. A nil address leaves
+// that media untouched, port 0 disables it, and ClearAudio/ClearVideo return it
+// to the "destination not yet known" state while keeping it enabled.
+type DestUpdate struct {
+	Audio      *net.UDPAddr
+	Video      *net.UDPAddr
+	ClearAudio bool
+	ClearVideo bool
+	// AudioHost and VideoHost are the hostnames the addresses were resolved
+	// from. They are kept for display and periodic re-resolution.
+	AudioHost string
+	VideoHost string
+	// AllowHairpin accepts destinations on a local media port, for test rigs.
+	AllowHairpin bool
+}
+
+func (m *Manager) UpdateRTPDest(id string, audioDest, videoDest *net.UDPAddr) (*Session, bool, error) {
+	return m.UpdateDest(id, DestUpdate{Audio: audioDest, Video: videoDest})
+}
+
+// UpdateDest applies a destination change to the session. A destination that
+// would loop into a local media port fails with a HairpinDestError and
+// nothing is changed.
+func (m *Manager) UpdateDest(id string, update DestUpdate) (*Session, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	session, ok := m.sessions[id]
 	if !ok {
-		return nil, false
+		return nil, false, nil
 	}
-	applyRTPDest(session, audioDest, videoDest)
-	return session, true
+	if !update.AllowHairpin {
+		if err := m.checkHairpin(update); err != nil {
+			return session, true, err
+		}
+	}
+	applyRTPDest(session, update, m.now())
+	m.publish(EventSessionUpdated, session)
+	return session, true, nil
+}
+
+// List returns the currently registered sessions in no particular order.
+func (m *Manager) List() []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// PoolStats reports usage of the RTP port pool and how many binds hit a port
+// held by another process.
+func (m *Manager) PoolStats() PoolStats {
+	stats := m.allocator.Stats()
+	stats.BindConflicts = m.bindConflicts.Load()
+	return stats
+}
+
+// Subscribe registers a listener for session lifecycle events. buffer bounds
+// how many undelivered events the subscriber may lag behind before it is
+// evicted, which closes the returned channel. The returned func unsubscribes.
+func (m *Manager) Subscribe(buffer int) (<-chan Event, func()) {
+	return m.events.subscribe(buffer)
 }
 
-func applyRTPDest(session *Session, audioDest, videoDest *net.UDPAddr) {
+// publish notifies subscribers and, since every session change is published,
+// schedules a rewrite of the state file.
+func (m *Manager) publish(eventType EventType, session *Session) {
+	m.events.publish(Event{Type: eventType, Session: session, Time: m.now()})
+	m.markStateDirty()
+}
+
+// applyRTPDest sets the rtpengine destinations. Media that was not requested
+// at create time has no sockets, so changes for it are ignored.
+func applyRTPDest(session *Session, update DestUpdate, now time.Time) {
 	if session == nil {
 		return
 	}
-	if audioDest != nil {
-		if audioDest.Port == 0 {
+	if loadAtomicString(&session.audioDisabledReason) != DisabledReasonNotRequested {
+		switch {
+		case update.ClearAudio:
+			if session.audioDest.Load() != nil || !session.audioEnabled.Load() {
+				session.recordHistory(now, HistoryDestCleared, "audio", "")
+			}
+			session.Audio.RTPEngineDest = nil
+			session.Audio.RTPEngineDestHost = ""
+			session.Audio.Enabled = true
+			session.Audio.DisabledReason = ""
+			session.audioEnabled.Store(true)
+			session.audioDisabledReason.Store("")
+			session.audioDest.Store((*net.UDPAddr)(nil))
+			session.audioDestHost.Store("")
+		case update.Audio == nil:
+		case update.Audio.Port == 0:
+			if loadAtomicString(&session.audioDisabledReason) != "rtpengine_port_0" {
+				session.recordHistory(now, HistoryMediaDisabled, "audio", "rtpengine_port_0")
+			}
 			session.Audio.RTPEngineDest = nil
+			session.Audio.RTPEngineDestHost = ""
 			session.Audio.Enabled = false
 			session.Audio.DisabledReason = "rtpengine_port_0"
 			session.audioEnabled.Store(false)
 			session.audioDisabledReason.Store("rtpengine_port_0")
 			session.audioDest.Store((*net.UDPAddr)(nil))
-		} else {
-			clone := cloneUDPAddr(audioDest)
+			session.audioDestHost.Store("")
+		default:
+			recordDestHistory(session, now, "audio", session.audioDest.Load(), update.Audio)
+			clone := cloneUDPAddr(update.Audio)
 			session.Audio.RTPEngineDest = clone
+			session.Audio.RTPEngineDestHost = update.AudioHost
 			session.Audio.Enabled = true
 			session.Audio.DisabledReason = ""
 			session.audioEnabled.Store(true)
 			session.audioDisabledReason.Store("")
 			session.audioDest.Store(clone)
+			session.audioDestHost.Store(update.AudioHost)
 		}
 	}
-	if videoDest != nil {
-		if videoDest.Port == 0 {
+	if loadAtomicString(&session.videoDisabledReason) != DisabledReasonNotRequested {
+		switch {
+		case update.ClearVideo:
+			if session.videoDest.Load() != nil || !session.videoEnabled.Load() {
+				session.recordHistory(now, HistoryDestCleared, "video", "")
+			}
 			session.Video.RTPEngineDest = nil
+			session.Video.RTPEngineDestHost = ""
+			session.Video.Enabled = true
+			session.Video.DisabledReason = ""
+			session.videoEnabled.Store(true)
+			session.videoDisabledReason.Store("")
+			session.videoDest.Store((*net.UDPAddr)(nil))
+			session.videoDestHost.Store("")
+		case update.Video == nil:
+		case update.Video.Port == 0:
+			if loadAtomicString(&session.videoDisabledReason) != "rtpengine_port_0" {
+				session.recordHistory(now, HistoryMediaDisabled, "video", "rtpengine_port_0")
+			}
+			session.Video.RTPEngineDest = nil
+			session.Video.RTPEngineDestHost = ""
 			session.Video.Enabled = false
 			session.Video.DisabledReason = "rtpengine_port_0"
 			session.videoEnabled.Store(false)
 			session.videoDisabledReason.Store("rtpengine_port_0")
 			session.videoDest.Store((*net.UDPAddr)(nil))
-		} else {
-			clone := cloneUDPAddr(videoDest)
+			session.videoDestHost.Store("")
+		default:
+			recordDestHistory(session, now, "video", session.videoDest.Load(), update.Video)
+			clone := cloneUDPAddr(update.Video)
 			session.Video.RTPEngineDest = clone
+			session.Video.RTPEngineDestHost = update.VideoHost
 			session.Video.Enabled = true
 			session.Video.DisabledReason = ""
 			session.videoEnabled.Store(true)
 			session.videoDisabledReason.Store("")
 			session.videoDest.Store(clone)
+			session.videoDestHost.Store(update.VideoHost)
 		}
 	}
 }
 
+// RearmVideoFix clears an automatic fix-mode bypass so the video proxy resumes
+// the fix pipeline on the next packet.
+func (m *Manager) RearmVideoFix(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	session.videoFixBypassed.Store(false)
+	m.publish(EventSessionUpdated, session)
+	return session, true
+}
+
 func (m *Manager) Delete(id string) bool {
+	_, ok := m.DeleteWithReason(id, "api")
+	return ok
+}
+
+// DeleteWithReason removes and stops a session and logs its final snapshot
+// with reason. The returned session is stopped, so its counters no longer
+// change and can be reported as the final values.
+func (m *Manager) DeleteWithReason(id, reason string) (*Session, bool) {
 	m.mu.Lock()
 	session, ok := m.sessions[id]
 	if ok {
 		session.setState(stateClosing)
-		delete(m.sessions, id)
+		m.removeLocked(session)
 	}
 	m.mu.Unlock()
 	if !ok {
-		return false
+		return nil, false
 	}
 	m.stopSession(session)
-	return true
+	m.stats.sessionsDeleted.Add(1)
+	session.logFinalSnapshot(reason, m.now())
+	m.publish(EventSessionDeleted, session)
+	m.observers.notify(notification{kind: observeDeleted, session: session, reason: reason})
+	return session, true
+}
+
+// DeleteAll removes every session, stops its proxies and releases its ports.
+// It returns the removed sessions.
+func (m *Manager) DeleteAll() []*Session {
+	return m.deleteMatching(func(*Session) bool { return true }, "bulk_api")
+}
+
+// DeleteByCallID removes every session created for callID.
+func (m *Manager) DeleteByCallID(callID string) []*Session {
+	return m.deleteMatching(func(session *Session) bool { return session.CallID == callID }, "bulk_api")
+}
+
+// BeginShutdown marks the manager as draining so readiness checks fail while
+// existing sessions keep running.
+func (m *Manager) BeginShutdown() {
+	m.shuttingDown.Store(true)
+}
+
+func (m *Manager) ShuttingDown() bool {
+	return m.shuttingDown.Load()
+}
+
+// StopAllSessions stops every session on shutdown. Each proxy logs its final
+// stats while stopping. It returns the number of stopped sessions.
+func (m *Manager) StopAllSessions() int {
+	return len(m.deleteMatching(func(*Session) bool { return true }, "shutdown"))
+}
+
+// deleteMatching removes and stops every session match accepts, logging the
+// deletion and final snapshot of each with reason. It returns the removed
+// sessions.
+func (m *Manager) deleteMatching(match func(*Session) bool, reason string) []*Session {
+	var removed []*Session
+	m.mu.Lock()
+	for _, session := range m.sessions {
+		if !match(session) {
+			continue
+		}
+		session.setState(stateClosing)
+		m.removeLocked(session)
+		removed = append(removed, session)
+	}
+	m.mu.Unlock()
+	m.stats.sessionsDeleted.Add(uint64(len(removed)))
+	now := m.now()
+	for _, session := range removed {
+		m.stopSession(session)
+		logAttrs := []any{"reason", reason, "call_id", session.CallID}
+		if !session.CreatedAt.IsZero() {
+			logAttrs = append(logAttrs, "duration", now.Sub(session.CreatedAt))
+		}
+		session.Logger().Info("session.delete", logAttrs...)
+		session.logFinalSnapshot(reason, now)
+		m.publish(EventSessionDeleted, session)
+		m.observers.notify(notification{kind: observeDeleted, session: session, reason: reason})
+	}
+	return removed
 }
 
 func (m *Manager) generateID() string {
@@ -313,6 +1234,10 @@ func (m *Manager) generateID() string {
 	return "S-" + hex.EncodeToString(buffer)
 }
 
+func (s *Session) dialogKey() dialogKey {
+	return dialogKey{callID: s.CallID, fromTag: s.FromTag, toTag: s.ToTag}
+}
+
 func cloneUDPAddr(addr *net.UDPAddr) *net.UDPAddr {
 	if addr == nil {
 		return nil
@@ -347,6 +1272,8 @@ func (m *Manager) Cleanup(now time.Time) {
 	m.removeIdleSessions(now)
 }
 
+// reapIdleSessions removes idle and expired sessions. Lifetimes are checked
+// at the idle sweep interval, or every second when idle reaping is off.
 func (m *Manager) reapIdleSessions() {
 	defer m.wg.Done()
 	interval := m.idleTimeout / 2
@@ -365,26 +1292,45 @@ func (m *Manager) reapIdleSessions() {
 	}
 }
 
+// removeIdleSessions removes sessions without packets for the idle timeout
+// and sessions past their ExpiresAt, whatever their activity.
 func (m *Manager) removeIdleSessions(now time.Time) {
-	if m.idleTimeout <= 0 {
-		return
-	}
-	var expired []*Session
+	var idle, expired []*Session
 	m.mu.Lock()
-	for id, session := range m.sessions {
+	for _, session := range m.sessions {
+		if !session.ExpiresAt.IsZero() && !now.Before(session.ExpiresAt) {
+			session.setState(stateClosing)
+			m.removeLocked(session)
+			expired = append(expired, session)
+			continue
+		}
+		if m.idleTimeout <= 0 {
+			continue
+		}
 		last := session.lastActivity()
 		if last.IsZero() {
 			last = now
 		}
 		if now.Sub(last) >= m.idleTimeout {
 			session.setState(stateClosing)
-			delete(m.sessions, id)
-			expired = append(expired, session)
+			m.removeLocked(session)
+			idle = append(idle, session)
 		}
 	}
 	m.mu.Unlock()
+	m.stats.sessionsIdleReaped.Add(uint64(len(idle)))
+	for _, session := range idle {
+		m.stopSession(session)
+		session.logFinalSnapshot("idle_timeout", now)
+		m.publish(EventSessionIdleReaped, session)
+		m.observers.notify(notification{kind: observeDeleted, session: session, reason: "idle"})
+	}
+	m.stats.sessionsDeleted.Add(uint64(len(expired)))
 	for _, session := range expired {
 		m.stopSession(session)
+		session.logFinalSnapshot("max_lifetime", now)
+		m.publish(EventSessionDeleted, session)
+		m.observers.notify(notification{kind: observeDeleted, session: session, reason: "max_lifetime"})
 	}
 }
 
@@ -392,13 +1338,28 @@ func (m *Manager) stopSession(session *Session) {
 	if session == nil {
 		return
 	}
-	if session.audioProxy != nil {
-		session.audioProxy.stop()
+	for _, proxy := range session.proxies() {
+		proxy.stop()
+	}
+	if err := session.stopCapture(); err != nil {
+		session.Logger().Warn("session.capture close failed", "error", err)
 	}
-	if session.videoProxy != nil {
-		session.videoProxy.stop()
+	aToB, bToA := session.relayedBytes()
+	m.stats.bytesAToB.Add(aToB)
+	m.stats.bytesBToA.Add(bToA)
+	m.allocator.Release(session.ports())
+}
+
+// ports lists the port pairs allocated to the session, each RTP port followed
+// by its reserved RTCP port; media that was not requested holds none.
+func (s *Session) ports() []int {
+	var ports []int
+	for _, port := range []int{s.Audio.APort, s.Audio.BPort, s.Video.APort, s.Video.BPort} {
+		if port != 0 {
+			ports = append(ports, port, port+1)
+		}
 	}
-	m.allocator.Release([]int{session.Audio.APort, session.Audio.BPort, session.Video.APort, session.Video.BPort})
+	return ports
 }
 
 type sessionState int32
@@ -444,5 +1405,7 @@ func (s *Session) lastActivity() time.Time {
 
 func (s *Session) markActivity(now time.Time) {
 	s.lastActivityNsec.Store(now.UnixNano())
-	s.state.CompareAndSwap(int32(stateCreated), int32(stateActive))
+	if s.state.CompareAndSwap(int32(stateCreated), int32(stateActive)) && s.onActive != nil {
+		s.onActive(s)
+	}
 }
@@ -1,9 +1,17 @@
 package session
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"net"
+	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"rtp-stream-cleaner/internal/logging"
 )
 
 type noopProxy struct{}
@@ -13,7 +21,7 @@ func (p *noopProxy) stop()  {}
 
 func newTestManager(t *testing.T, idleTimeout time.Duration) *Manager {
 	t.Helper()
-	allocator, err := NewPortAllocator(14000, 14010)
+	allocator, err := NewPortAllocator(14000, 14031)
 	if err != nil {
 		t.Fatalf("unexpected allocator error: %v", err)
 	}
@@ -23,7 +31,9 @@ func newTestManager(t *testing.T, idleTimeout time.Duration) *Manager {
 		0,
 		idleTimeout,
 		false,
+		VideoFixConfig{},
 		ProxyLogConfig{},
+		"",
 		managerDeps{
 			startReaper: false,
 			now:         func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) },
@@ -31,13 +41,60 @@ func newTestManager(t *testing.T, idleTimeout time.Duration) *Manager {
 			newAudioProxy: func(*Session, *net.UDPConn, *net.UDPConn, time.Duration, ProxyLogConfig) sessionProxy {
 				return &noopProxy{}
 			},
-			newVideoProxy: func(*Session, *net.UDPConn, *net.UDPConn, time.Duration, time.Duration, bool, bool, ProxyLogConfig) sessionProxy {
+			newVideoProxy: func(*Session, *net.UDPConn, *net.UDPConn, time.Duration, time.Duration, bool, bool, VideoFixConfig, ProxyLogConfig) sessionProxy {
+				return &noopProxy{}
+			},
+			newRTCPProxy: func(*Session, string, *net.UDPConn, *net.UDPConn, time.Duration) sessionProxy {
 				return &noopProxy{}
 			},
 		},
 	)
 }
 
+// captureLogs routes the process logger to a temporary file for the rest of
+// the test. The returned function decodes the entries logged so far with the
+// given message.
+func captureLogs(t *testing.T) func(msg string) []map[string]any {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "log")
+	if err != nil {
+		t.Fatalf("unexpected log file error: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = file
+	logging.Configure(logging.Config{Level: "info"})
+	os.Stdout = stdout
+	t.Cleanup(func() {
+		logging.Configure(logging.Config{Level: os.Getenv("LOG_LEVEL"), Format: os.Getenv("LOG_FORMAT")})
+		file.Close()
+	})
+	return func(msg string) []map[string]any {
+		data, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatalf("unexpected log read error: %v", err)
+		}
+		var entries []map[string]any
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			var entry map[string]any
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry["msg"] == msg {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+}
+
+// loggedReasons returns the reason of each entry by session_id.
+func loggedReasons(entries []map[string]any) map[string]string {
+	reasons := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		id, _ := entry["session_id"].(string)
+		reasons[id], _ = entry["reason"].(string)
+	}
+	return reasons
+}
+
 // TestManager_CreateStoresSessionAndReturnsID verifies that Create generates a
 // non-empty session ID and that the created session is stored in the manager
 // map. This matters because the API relies on stable IDs to address sessions
@@ -52,7 +109,7 @@ func newTestManager(t *testing.T, idleTimeout time.Duration) *Manager {
 // a mismatch between created and stored data.
 func TestManager_CreateStoresSessionAndReturnsID(t *testing.T) {
 	manager := newTestManager(t, 0)
-	created, err := manager.Create("call-1", "from-1", "to-1", true)
+	created, err := manager.Create("call-1", "from-1", "to-1", true, true, true)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
@@ -80,7 +137,7 @@ func TestManager_CreateStoresSessionAndReturnsID(t *testing.T) {
 // pointer due to reintroduced cloning.
 func TestManager_Get_ReturnsStoredPointer(t *testing.T) {
 	manager := newTestManager(t, 0)
-	created, err := manager.Create("call-get", "from-get", "to-get", false)
+	created, err := manager.Create("call-get", "from-get", "to-get", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
@@ -106,16 +163,16 @@ func TestManager_Get_ReturnsStoredPointer(t *testing.T) {
 // would show a nil audio destination after a video update or vice versa.
 func TestManager_UpdateSetsDestIndependentlyAudioVideo(t *testing.T) {
 	manager := newTestManager(t, 0)
-	created, err := manager.Create("call-2", "from-2", "to-2", false)
+	created, err := manager.Create("call-2", "from-2", "to-2", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
 	audioDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 9000}
-	if _, ok := manager.UpdateRTPDest(created.ID, audioDest, nil); !ok {
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, audioDest, nil); !ok {
 		t.Fatalf("expected update to succeed")
 	}
 	videoDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 9002}
-	if _, ok := manager.UpdateRTPDest(created.ID, nil, videoDest); !ok {
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, nil, videoDest); !ok {
 		t.Fatalf("expected update to succeed")
 	}
 	updated, ok := manager.Get(created.ID)
@@ -144,12 +201,12 @@ func TestManager_UpdateSetsDestIndependentlyAudioVideo(t *testing.T) {
 // enabled/disabled flags.
 func TestManager_UpdateRTPDest_DisablesMediaOnPortZero(t *testing.T) {
 	manager := newTestManager(t, 0)
-	created, err := manager.Create("call-6", "from-6", "to-6", false)
+	created, err := manager.Create("call-6", "from-6", "to-6", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
 	videoDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 9002}
-	if _, ok := manager.UpdateRTPDest(created.ID, nil, videoDest); !ok {
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, nil, videoDest); !ok {
 		t.Fatalf("expected update to succeed")
 	}
 	enabledSession, ok := manager.Get(created.ID)
@@ -167,7 +224,7 @@ func TestManager_UpdateRTPDest_DisablesMediaOnPortZero(t *testing.T) {
 	}
 
 	disableDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 0}
-	if _, ok := manager.UpdateRTPDest(created.ID, nil, disableDest); !ok {
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, nil, disableDest); !ok {
 		t.Fatalf("expected update to succeed")
 	}
 	disabledSession, ok := manager.Get(created.ID)
@@ -185,6 +242,72 @@ func TestManager_UpdateRTPDest_DisablesMediaOnPortZero(t *testing.T) {
 	}
 }
 
+// TestManager_Create_RecordsSettings verifies that a session records the
+// settings it was created with, so GET can report them. Inputs: a manager with
+// SPS/PPS injection on, a 2s peer-learning window and 300ms max frame wait;
+// one create with video fix and one without. The expected output is injection
+// reported only together with video fix, the manager timings on both, and
+// H264 and rewrite as the default video codec and timestamp mode.
+func TestManager_Create_RecordsSettings(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.videoInjectCachedSPSPPS = true
+	manager.peerLearningWindow = 2 * time.Second
+	manager.maxFrameWait = 300 * time.Millisecond
+
+	fixed, err := manager.Create("call-settings", "from", "to", true, true, true)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	want := Settings{VideoFix: true, VideoInjectSPSPPS: true, PeerLearningWindow: 2 * time.Second, MaxFrameWait: 300 * time.Millisecond, VideoCodec: VideoCodecH264, VideoTimestampMode: VideoTimestampRewrite}
+	if fixed.Settings != want {
+		t.Fatalf("expected settings %+v, got %+v", want, fixed.Settings)
+	}
+	raw, err := manager.Create("call-settings-raw", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if raw.Settings.VideoFix || raw.Settings.VideoInjectSPSPPS {
+		t.Fatalf("expected fix and injection off without video fix, got %+v", raw.Settings)
+	}
+}
+
+// TestManager_UpdateDest_ClearReturnsToUnknownDest verifies that clearing a
+// destination, including one disabled by port 0, leaves the media enabled with
+// no destination, and that a nil address without Clear changes nothing. This
+// matters because the proxies hold packets and warn about the missing dest
+// only in that state. Inputs: a video dest, a port 0 disable, then ClearVideo
+// with an audio-only no-op. The expected output is video enabled with an empty
+// reason and nil destination in both the Media fields and the live state, and
+// audio untouched.
+func TestManager_UpdateDest_ClearReturnsToUnknownDest(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-clear", "from-clear", "to-clear", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	audioDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 9000}
+	if _, ok, _ := manager.UpdateDest(created.ID, DestUpdate{Audio: audioDest, Video: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 9002}}); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+	if _, ok, _ := manager.UpdateDest(created.ID, DestUpdate{Video: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 0}}); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+
+	updated, ok, _ := manager.UpdateDest(created.ID, DestUpdate{ClearVideo: true})
+	if !ok {
+		t.Fatalf("expected update to succeed")
+	}
+	if !updated.Video.Enabled || updated.Video.DisabledReason != "" || updated.Video.RTPEngineDest != nil {
+		t.Fatalf("expected enabled video without dest, got %+v", updated.Video)
+	}
+	if state := updated.VideoState(); !state.Enabled || state.DisabledReason != "" || state.RTPEngineDest != nil {
+		t.Fatalf("expected live video state enabled without dest, got %+v", state)
+	}
+	if updated.Audio.RTPEngineDest == nil || updated.Audio.RTPEngineDest.String() != audioDest.String() {
+		t.Fatalf("expected audio dest to stay %s, got %v", audioDest, updated.Audio.RTPEngineDest)
+	}
+}
+
 // TestManager_CreateWithInitialDest_AppliesDestinations verifies that initial
 // RTP destinations are applied at creation time, including disabling media when
 // port 0 is provided. This matters because callers must be able to set initial
@@ -202,7 +325,7 @@ func TestManager_CreateWithInitialDest_AppliesDestinations(t *testing.T) {
 	audioDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 10), Port: 40100}
 	videoDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 20), Port: 0}
 
-	created, err := manager.CreateWithInitialDest("call-7", "from-7", "to-7", false, audioDest, videoDest)
+	created, err := manager.CreateWithInitialDest("call-7", "from-7", "to-7", true, true, false, audioDest, videoDest)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
@@ -224,6 +347,54 @@ func TestManager_CreateWithInitialDest_AppliesDestinations(t *testing.T) {
 	}
 }
 
+// TestManager_Create_VideoNotRequested_AllocatesAudioOnly verifies that media
+// disabled at create time gets no ports, no proxy and a not_requested reason,
+// and that a later destination cannot enable it. This matters because
+// audio-only calls would otherwise hold eight ports and report video as
+// enabled. Inputs: create with video disabled, then update the video dest. The
+// expected output is two port pairs in use, zero video ports, video still
+// disabled after the update, and an empty pool after delete.
+func TestManager_Create_VideoNotRequested_AllocatesAudioOnly(t *testing.T) {
+	manager := newTestManager(t, 0)
+	videoProxies := 0
+	manager.newVideoProxy = func(*Session, *net.UDPConn, *net.UDPConn, time.Duration, time.Duration, bool, bool, VideoFixConfig, ProxyLogConfig) sessionProxy {
+		videoProxies++
+		return &noopProxy{}
+	}
+	created, err := manager.Create("call-audio-only", "from", "to", true, false, true)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if created.Audio.APort == 0 || created.Audio.BPort == 0 {
+		t.Fatalf("expected audio ports, got %d/%d", created.Audio.APort, created.Audio.BPort)
+	}
+	if created.Video.APort != 0 || created.Video.BPort != 0 {
+		t.Fatalf("expected zero video ports, got %d/%d", created.Video.APort, created.Video.BPort)
+	}
+	if videoProxies != 0 {
+		t.Fatalf("expected no video proxy, got %d", videoProxies)
+	}
+	if stats := manager.PoolStats(); stats.InUse != 4 {
+		t.Fatalf("expected 4 ports in use, got %d", stats.InUse)
+	}
+
+	videoDest := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 9002}
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, nil, videoDest); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+	video := created.VideoState()
+	if video.Enabled || video.DisabledReason != DisabledReasonNotRequested || video.RTPEngineDest != nil {
+		t.Fatalf("expected video to stay disabled as %q, got %+v", DisabledReasonNotRequested, video)
+	}
+
+	if !manager.Delete(created.ID) {
+		t.Fatalf("expected delete to succeed")
+	}
+	if stats := manager.PoolStats(); stats.InUse != 0 {
+		t.Fatalf("expected all ports released, got %d in use", stats.InUse)
+	}
+}
+
 // TestManager_DeleteRemovesSession ensures that Delete removes a session from
 // the manager and returns true for existing IDs. This matters because cleanup
 // must release resources and prevent future lookups. Preconditions: a manager
@@ -236,7 +407,7 @@ func TestManager_CreateWithInitialDest_AppliesDestinations(t *testing.T) {
 // session still being returned by Get after deletion.
 func TestManager_DeleteRemovesSession(t *testing.T) {
 	manager := newTestManager(t, 0)
-	created, err := manager.Create("call-3", "from-3", "to-3", false)
+	created, err := manager.Create("call-3", "from-3", "to-3", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
@@ -248,6 +419,167 @@ func TestManager_DeleteRemovesSession(t *testing.T) {
 	}
 }
 
+// TestManager_MaxSessions_RejectsBeyondLimit verifies MAX_SESSIONS: once the
+// limit is reached a create fails with ErrSessionLimitReached without taking
+// ports, and deleting a session frees a slot again. Inputs: a limit of 2 and
+// three creates, then a delete and another create. The expected output is the
+// third create rejected with the pool untouched and the last create accepted.
+func TestManager_MaxSessions_RejectsBeyondLimit(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.SetMaxSessions(2)
+	first, err := manager.Create("call-1", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, err := manager.Create("call-2", "from", "to", true, false, false); err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	inUse := manager.PoolStats().InUse
+
+	if _, err := manager.Create("call-3", "from", "to", true, false, false); !errors.Is(err, ErrSessionLimitReached) {
+		t.Fatalf("expected ErrSessionLimitReached, got %v", err)
+	}
+	if got := manager.PoolStats().InUse; got != inUse {
+		t.Fatalf("expected rejected create to leave the pool at %d ports in use, got %d", inUse, got)
+	}
+
+	if !manager.Delete(first.ID) {
+		t.Fatalf("expected delete to succeed")
+	}
+	if _, err := manager.Create("call-3", "from", "to", true, false, false); err != nil {
+		t.Fatalf("expected create after delete to succeed, got %v", err)
+	}
+	if got := len(manager.List()); got != 2 {
+		t.Fatalf("expected 2 sessions, got %d", got)
+	}
+}
+
+// TestManager_MaxSessionsPerCall verifies MAX_SESSIONS_PER_CALL: once a
+// call_id has the limit of sessions, another create for it fails with a
+// CallSessionLimitError listing them without taking ports, other calls are
+// unaffected, and both delete and idle reap free a slot. Inputs: a limit of 3,
+// three creates for one call, a fourth for it, a create for another call,
+// then a delete, an idle reap and creates after each. The expected output is
+// the fourth create rejected with the three IDs and the pool untouched, the
+// other call accepted, and the creates after delete and reap accepted.
+func TestManager_MaxSessionsPerCall(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+	manager.SetMaxSessionsPerCall(3)
+	var ids []string
+	for _, toTag := range []string{"to-1", "to-2", "to-3"} {
+		created, err := manager.Create("call-retry", "from", toTag, true, false, false)
+		if err != nil {
+			t.Fatalf("unexpected create error: %v", err)
+		}
+		ids = append(ids, created.ID)
+	}
+	slices.Sort(ids)
+	inUse := manager.PoolStats().InUse
+
+	_, err := manager.Create("call-retry", "from", "to-4", true, false, false)
+	var limit *CallSessionLimitError
+	if !errors.As(err, &limit) || !errors.Is(err, ErrCallSessionLimitReached) || !slices.Equal(limit.SessionIDs, ids) {
+		t.Fatalf("expected a call session limit error listing %v, got %v", ids, err)
+	}
+	if got := manager.PoolStats().InUse; got != inUse {
+		t.Fatalf("expected rejected create to leave the pool at %d ports in use, got %d", inUse, got)
+	}
+	if _, err := manager.Create("call-other", "from", "to", true, false, false); err != nil {
+		t.Fatalf("expected another call to be accepted, got %v", err)
+	}
+
+	if !manager.Delete(ids[0]) {
+		t.Fatalf("expected delete to succeed")
+	}
+	if _, err := manager.Create("call-retry", "from", "to-5", true, false, false); err != nil {
+		t.Fatalf("expected create after delete to succeed, got %v", err)
+	}
+	manager.removeIdleSessions(manager.now().Add(2 * time.Minute))
+	if _, err := manager.Create("call-retry", "from", "to-6", true, false, false); err != nil {
+		t.Fatalf("expected create after idle reap to succeed, got %v", err)
+	}
+}
+
+// TestManager_DeleteWithReason_ReturnsRemovedSession verifies that
+// DeleteWithReason hands back the removed session, whose counters the API
+// reports as the final snapshot, and that unknown IDs return false. Inputs:
+// delete of a created session, then of the same ID again. The expected output
+// is the created session, a Get miss and a second delete miss.
+func TestManager_DeleteWithReason_ReturnsRemovedSession(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-final", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	deleted, ok := manager.DeleteWithReason(created.ID, "api")
+	if !ok || deleted != created {
+		t.Fatalf("expected the created session back, got %v %v", deleted, ok)
+	}
+	if _, ok := manager.Get(created.ID); ok {
+		t.Fatalf("expected session to be removed")
+	}
+	if _, ok := manager.DeleteWithReason(created.ID, "api"); ok {
+		t.Fatalf("expected second delete to miss")
+	}
+}
+
+// TestManager_DeleteByCallID_RemovesOnlyMatchingSessions verifies the call_id
+// filter of the bulk delete and that DeleteAll drains the rest. This matters
+// because a maintenance drain scoped to one call must not touch other calls,
+// and ports of every removed session must return to the pool. Inputs: two
+// sessions for call-a and one for call-b. The expected output is two removals
+// for call-a, then one for DeleteAll, an empty manager, a fully free pool, and
+// a session.delete and a final snapshot logged for each session with reason
+// bulk_api, whoever calls the manager.
+func TestManager_DeleteByCallID_RemovesOnlyMatchingSessions(t *testing.T) {
+	logged := captureLogs(t)
+	manager := newTestManager(t, 0)
+	allocator, err := NewPortAllocator(14000, 14023)
+	if err != nil {
+		t.Fatalf("unexpected allocator error: %v", err)
+	}
+	manager.allocator = allocator
+	for _, callID := range []string{"call-a", "call-a", "call-b"} {
+		if _, err := manager.Create(callID, "from", "to", true, true, false); err != nil {
+			t.Fatalf("unexpected create error: %v", err)
+		}
+	}
+
+	removed := manager.DeleteByCallID("call-a")
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 sessions removed for call-a, got %d", len(removed))
+	}
+	for _, session := range removed {
+		if session.CallID != "call-a" {
+			t.Fatalf("unexpected session removed: %s", session.CallID)
+		}
+	}
+	if remaining := manager.List(); len(remaining) != 1 || remaining[0].CallID != "call-b" {
+		t.Fatalf("expected only call-b to remain, got %d sessions", len(remaining))
+	}
+
+	if removed := manager.DeleteAll(); len(removed) != 1 {
+		t.Fatalf("expected DeleteAll to remove 1 session, got %d", len(removed))
+	}
+	if remaining := manager.List(); len(remaining) != 0 {
+		t.Fatalf("expected no sessions left, got %d", len(remaining))
+	}
+	if _, err := allocator.Allocate(24); err != nil {
+		t.Fatalf("expected released ports to be reusable: %v", err)
+	}
+	for _, msg := range []string{"session.delete", "session.final_snapshot"} {
+		reasons := loggedReasons(logged(msg))
+		if len(reasons) != 3 {
+			t.Fatalf("expected %s logged for 3 sessions, got %v", msg, reasons)
+		}
+		for id, reason := range reasons {
+			if reason != "bulk_api" {
+				t.Fatalf("expected %s of %s with reason bulk_api, got %q", msg, id, reason)
+			}
+		}
+	}
+}
+
 // TestManager_IdleCleanup_RemovesOnlyIdleSessions validates deterministic idle
 // cleanup by invoking Cleanup with a controlled timestamp and verifying that
 // only sessions exceeding the idle timeout are removed. This matters because
@@ -257,18 +589,19 @@ func TestManager_DeleteRemovesSession(t *testing.T) {
 // timeout and one newer, then Cleanup with a fixed "now". Edge case: last
 // activity exactly on the threshold is treated as idle when now-sub-last >=
 // timeout. The expected output is that only the idle session is removed from the
-// manager map. Assertions are stable because Cleanup uses explicit timestamps
+// manager map, its final snapshot logged with reason idle_timeout. Assertions are stable because Cleanup uses explicit timestamps
 // without sleeps. Flakiness is avoided by controlling time and avoiding UDP or
 // goroutines. A regression would remove the active session or fail to remove the
 // idle session.
 func TestManager_IdleCleanup_RemovesOnlyIdleSessions(t *testing.T) {
+	logged := captureLogs(t)
 	idleTimeout := 5 * time.Minute
 	manager := newTestManager(t, idleTimeout)
-	createdIdle, err := manager.Create("call-4", "from-4", "to-4", false)
+	createdIdle, err := manager.Create("call-4", "from-4", "to-4", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
-	createdActive, err := manager.Create("call-5", "from-5", "to-5", false)
+	createdActive, err := manager.Create("call-5", "from-5", "to-5", true, true, false)
 	if err != nil {
 		t.Fatalf("unexpected create error: %v", err)
 	}
@@ -286,4 +619,319 @@ func TestManager_IdleCleanup_RemovesOnlyIdleSessions(t *testing.T) {
 	if _, ok := manager.Get(createdActive.ID); !ok {
 		t.Fatalf("expected active session to remain")
 	}
+	if reasons := loggedReasons(logged("session.final_snapshot")); len(reasons) != 1 || reasons[createdIdle.ID] != "idle_timeout" {
+		t.Fatalf("expected one final snapshot for the idle session with reason idle_timeout, got %v", reasons)
+	}
+}
+
+// TestManager_IdleCleanup_IgnoresInvalidPackets verifies that only RTP keeps a
+// session from being reaped. This matters because a scanner hammering a port
+// used to keep a session without media alive forever. Inputs: two audio-only
+// sessions under lock_ssrc with real proxies and the non-RTP gate off; one
+// receives a short datagram, a STUN binding request, a version 1 packet and
+// an RTP packet of another SSRC than the locked one, the other a single RTP
+// packet; then Cleanup well after both were created. The expected output is
+// four activity_suppressed packets and the first session reaped while the
+// second remains.
+func TestManager_IdleCleanup_IgnoresInvalidPackets(t *testing.T) {
+	manager := newTestManager(t, time.Minute)
+	manager.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1))
+	manager.SetDropNonRTP(false)
+	manager.listenUDP = net.ListenUDP
+	manager.newAudioProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy {
+		return newAudioProxy(session, aConn, bConn, peerLearningWindow, logConfig)
+	}
+	t.Cleanup(func() { manager.StopAllSessions() })
+	junk, err := manager.CreateWithOptions("call-junk", "from", "to", false, CreateOptions{DisableVideo: true, LockSSRC: true})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	media, err := manager.CreateWithOptions("call-media", "from", "to", false, CreateOptions{DisableVideo: true, LockSSRC: true})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	junk.audioSSRCLock.admit(0x55667788)
+
+	scanner, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+	defer scanner.Close()
+	versionOne := makeRTPPacket(1, 160, []byte{0xd5})
+	versionOne[0] = 0x40
+	junkAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: junk.Audio.APort}
+	for _, packet := range [][]byte{{0x80}, makeSTUNBindingRequest(), versionOne, makeRTPPacket(2, 320, []byte{0xd5})} {
+		if _, err := scanner.WriteToUDP(packet, junkAddr); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+	}
+	mediaAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: media.Audio.APort}
+	if _, err := scanner.WriteToUDP(makeRTPPacket(1, 160, []byte{0xd5}), mediaAddr); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for junk.audioCounters.activitySuppressed.Load() < 4 || media.audioCounters.aInPkts.Load() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 4 suppressed and 1 received packet, got %d and %d", junk.audioCounters.activitySuppressed.Load(), media.audioCounters.aInPkts.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := media.AudioCountersSnapshot().ActivitySuppressed; got != 0 {
+		t.Fatalf("expected no suppressed packet on the media session, got %d", got)
+	}
+
+	manager.Cleanup(time.Now())
+
+	if _, ok := manager.Get(junk.ID); ok {
+		t.Fatalf("expected the session receiving only invalid packets to be reaped")
+	}
+	if _, ok := manager.Get(media.ID); !ok {
+		t.Fatalf("expected the session receiving RTP to remain")
+	}
+}
+
+// TestManager_CreateWithOptions_PerMediaPeerLearningWindow verifies that
+// per-media learning windows override the manager default independently and
+// are handed to the matching proxy factory. This matters because video peers
+// often appear seconds after audio and need a longer window. Inputs: an audio
+// override only. The expected output is the override on audio and the manager
+// default on video, both in the session media and in the factory arguments.
+func TestManager_CreateWithOptions_PerMediaPeerLearningWindow(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.peerLearningWindow = 10 * time.Second
+	var audioWindow, videoWindow time.Duration
+	manager.newAudioProxy = func(_ *Session, _, _ *net.UDPConn, window time.Duration, _ ProxyLogConfig) sessionProxy {
+		audioWindow = window
+		return &noopProxy{}
+	}
+	manager.newVideoProxy = func(_ *Session, _, _ *net.UDPConn, window, _ time.Duration, _ bool, _ bool, _ VideoFixConfig, _ ProxyLogConfig) sessionProxy {
+		videoWindow = window
+		return &noopProxy{}
+	}
+	override := 2 * time.Second
+
+	created, err := manager.CreateWithOptions("call-8", "from-8", "to-8", false, CreateOptions{AudioPeerLearningWindow: &override})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+
+	if audioWindow != 2*time.Second || created.Audio.PeerLearningWindow != 2*time.Second {
+		t.Fatalf("expected audio window 2s, got factory=%s media=%s", audioWindow, created.Audio.PeerLearningWindow)
+	}
+	if videoWindow != 10*time.Second || created.Video.PeerLearningWindow != 10*time.Second {
+		t.Fatalf("expected video window 10s, got factory=%s media=%s", videoWindow, created.Video.PeerLearningWindow)
+	}
+}
+
+// TestManager_CreateWithOptions_SessionTimingOverrides verifies that the
+// session-wide peer learning window and max frame wait replace the manager
+// values in the proxy constructors and in the reported settings, while a
+// per-media window still wins. Doorphone models differ enough that one global
+// value cannot fit all of them. Inputs: manager values 10s/120ms, a session
+// window of 3s, a frame wait of 300ms and a 5s video window. The expected
+// output is 3s for audio, 5s for video, 300ms passed to the video proxy and
+// 3s/300ms in Settings.
+func TestManager_CreateWithOptions_SessionTimingOverrides(t *testing.T) {
+	manager := newTestManager(t, 0)
+	manager.peerLearningWindow = 10 * time.Second
+	manager.maxFrameWait = 120 * time.Millisecond
+	var audioWindow, videoWindow, frameWait time.Duration
+	manager.newAudioProxy = func(_ *Session, _, _ *net.UDPConn, window time.Duration, _ ProxyLogConfig) sessionProxy {
+		audioWindow = window
+		return &noopProxy{}
+	}
+	manager.newVideoProxy = func(_ *Session, _, _ *net.UDPConn, window, wait time.Duration, _ bool, _ bool, _ VideoFixConfig, _ ProxyLogConfig) sessionProxy {
+		videoWindow, frameWait = window, wait
+		return &noopProxy{}
+	}
+	window, wait, videoOverride := 3*time.Second, 300*time.Millisecond, 5*time.Second
+
+	created, err := manager.CreateWithOptions("call-timing", "from", "to", true, CreateOptions{
+		PeerLearningWindow:      &window,
+		VideoPeerLearningWindow: &videoOverride,
+		MaxFrameWait:            &wait,
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+
+	if audioWindow != 3*time.Second || videoWindow != 5*time.Second || frameWait != 300*time.Millisecond {
+		t.Fatalf("unexpected factory arguments audio=%s video=%s frame_wait=%s", audioWindow, videoWindow, frameWait)
+	}
+	if created.Settings.PeerLearningWindow != 3*time.Second || created.Settings.MaxFrameWait != 300*time.Millisecond {
+		t.Fatalf("unexpected settings %+v", created.Settings)
+	}
+}
+
+// TestSession_PeerLearningWindowsIndependent latches the audio peer early with
+// a short window and moves the video peer late within its longer window. The
+// expected output is that audio rejects the new address and reports zero
+// remaining learning time while video accepts it and still reports time left.
+func TestSession_PeerLearningWindowsIndependent(t *testing.T) {
+	session := &Session{
+		ID:    "S-windows",
+		Audio: Media{PeerLearningWindow: 20 * time.Millisecond},
+		Video: Media{PeerLearningWindow: 5 * time.Second},
+	}
+	audio := newAudioProxy(session, nil, nil, session.Audio.PeerLearningWindow, ProxyLogConfig{})
+	video := newVideoProxy(session, nil, nil, session.Video.PeerLearningWindow, 0, false, false, VideoFixConfig{}, ProxyLogConfig{})
+	session.audioProxy = audio
+	session.videoProxy = video
+	first := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 5000}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 6000}
+
+	if !audio.updateDoorphonePeer(first) || !video.updateDoorphonePeer(first) {
+		t.Fatalf("expected first peer to be learned")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if audio.updateDoorphonePeer(moved) {
+		t.Fatalf("expected audio peer to be locked after its window")
+	}
+	if !video.updateDoorphonePeer(moved) {
+		t.Fatalf("expected video peer to move within its window")
+	}
+	if remaining := session.AudioState().PeerLearningRemaining; remaining != 0 {
+		t.Fatalf("expected no audio learning time left, got %s", remaining)
+	}
+	if remaining := session.VideoState().PeerLearningRemaining; remaining <= 0 {
+		t.Fatalf("expected video learning time left, got %s", remaining)
+	}
+}
+
+// TestSession_ReportsDoorphonePeer verifies that the media state exposes the
+// latched A-leg peer. Inputs: a session whose audio proxy has seen no packet
+// and whose video proxy learned one address and then moved within its window.
+// The expected output is no audio peer, and a video peer equal to the moved
+// address, flagged as re-learned with a learned-at time after the first latch.
+func TestSession_ReportsDoorphonePeer(t *testing.T) {
+	session := &Session{
+		ID:    "S-peer",
+		Audio: Media{PeerLearningWindow: 5 * time.Second},
+		Video: Media{PeerLearningWindow: 5 * time.Second},
+	}
+	session.audioProxy = newAudioProxy(session, nil, nil, session.Audio.PeerLearningWindow, ProxyLogConfig{})
+	video := newVideoProxy(session, nil, nil, session.Video.PeerLearningWindow, 0, false, false, VideoFixConfig{}, ProxyLogConfig{})
+	session.videoProxy = video
+
+	if media := session.AudioState(); media.DoorphonePeer != nil || !media.DoorphonePeerLearnedAt.IsZero() || media.DoorphonePeerRelearned {
+		t.Fatalf("expected no audio peer before the first packet, got %+v", media)
+	}
+	first := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 5000}
+	video.updateDoorphonePeer(first)
+	learned := session.VideoState()
+	if learned.DoorphonePeer.String() != first.String() || learned.DoorphonePeerLearnedAt.IsZero() || learned.DoorphonePeerRelearned {
+		t.Fatalf("unexpected video peer after first packet: %+v", learned)
+	}
+	time.Sleep(time.Millisecond)
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 11), Port: 5002}
+	video.updateDoorphonePeer(moved)
+	relearned := session.VideoState()
+	if relearned.DoorphonePeer.String() != moved.String() || !relearned.DoorphonePeerRelearned {
+		t.Fatalf("expected moved video peer flagged as relearned, got %+v", relearned)
+	}
+	if !relearned.DoorphonePeerLearnedAt.After(learned.DoorphonePeerLearnedAt) {
+		t.Fatalf("expected learned-at to follow the move, got %s then %s", learned.DoorphonePeerLearnedAt, relearned.DoorphonePeerLearnedAt)
+	}
+}
+
+// TestManager_RejectDuplicate verifies that a create with RejectDuplicate for
+// a dialog that already has a live session returns the existing ID without
+// allocating ports, that the default still allows duplicates, and that the
+// dialog index is released on delete and idle reap. This matters because
+// controller retries after a timeout otherwise leak a second set of four
+// ports per call.
+func TestManager_RejectDuplicate(t *testing.T) {
+	idleTimeout := time.Minute
+	manager := newTestManager(t, idleTimeout)
+	reject := CreateOptions{RejectDuplicate: true}
+	first, err := manager.CreateWithOptions("call-dup", "from", "to", false, reject)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	free := manager.PoolStats().Free
+
+	_, err = manager.CreateWithOptions("call-dup", "from", "to", false, reject)
+	var duplicate *DuplicateSessionError
+	if !errors.As(err, &duplicate) || duplicate.SessionID != first.ID || !errors.Is(err, ErrDuplicateSession) {
+		t.Fatalf("expected duplicate error for %s, got %v", first.ID, err)
+	}
+	if got := manager.PoolStats().Free; got != free {
+		t.Fatalf("expected no ports allocated for rejected create, free %d -> %d", free, got)
+	}
+	other, err := manager.CreateWithOptions("call-dup", "from", "other-to", false, reject)
+	if err != nil {
+		t.Fatalf("expected different to_tag to be accepted, got %v", err)
+	}
+	manager.Delete(other.ID)
+
+	second, err := manager.Create("call-dup", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("expected default create to allow duplicates, got %v", err)
+	}
+	manager.Delete(first.ID)
+	_, err = manager.CreateWithOptions("call-dup", "from", "to", false, reject)
+	if !errors.As(err, &duplicate) || duplicate.SessionID != second.ID {
+		t.Fatalf("expected duplicate error for remaining %s, got %v", second.ID, err)
+	}
+
+	manager.Cleanup(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(idleTimeout))
+	if _, err := manager.CreateWithOptions("call-dup", "from", "to", false, reject); err != nil {
+		t.Fatalf("expected create after idle reap to succeed, got %v", err)
+	}
+}
+
+// TestManager_MaxLifetime_RemovesExpiredSessions verifies that Cleanup removes
+// sessions past their lifetime even while packets flow, that a per-session
+// lifetime overrides the manager default and that 0 means unlimited. This
+// matters because a looping media source otherwise keeps a forgotten session
+// alive forever. Inputs: a one hour default, sessions with the default, a 10
+// minute override and an unlimited override, all active a minute before the
+// sweep at creation plus one hour. The expected output is the first two
+// removed with deleted events and the unlimited one kept without ExpiresAt.
+func TestManager_MaxLifetime_RemovesExpiredSessions(t *testing.T) {
+	manager := newTestManager(t, 5*time.Minute)
+	manager.SetMaxSessionLifetime(time.Hour)
+	short, unlimited := 10*time.Minute, time.Duration(0)
+	byDefault, err := manager.Create("call-life", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	overridden, err := manager.CreateWithOptions("call-life", "from", "to", false, CreateOptions{DisableVideo: true, MaxLifetime: &short})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	forever, err := manager.CreateWithOptions("call-life", "from", "to", false, CreateOptions{DisableVideo: true, MaxLifetime: &unlimited})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	t0 := byDefault.CreatedAt
+	if !byDefault.ExpiresAt.Equal(t0.Add(time.Hour)) || !overridden.ExpiresAt.Equal(t0.Add(short)) || !forever.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected expiry %v / %v / %v", byDefault.ExpiresAt, overridden.ExpiresAt, forever.ExpiresAt)
+	}
+	for _, created := range []*Session{byDefault, overridden, forever} {
+		created.markActivity(t0.Add(59 * time.Minute))
+	}
+	events, unsubscribe := manager.Subscribe(4)
+	defer unsubscribe()
+
+	manager.Cleanup(t0.Add(time.Hour))
+
+	for _, expired := range []*Session{byDefault, overridden} {
+		if _, ok := manager.Get(expired.ID); ok {
+			t.Fatalf("expected session %s to expire", expired.ID)
+		}
+	}
+	if _, ok := manager.Get(forever.ID); !ok {
+		t.Fatalf("expected the unlimited session to stay")
+	}
+	for i := 0; i < 2; i++ {
+		if event := <-events; event.Type != EventSessionDeleted {
+			t.Fatalf("expected deleted event, got %v", event.Type)
+		}
+	}
+	if stats := manager.Stats(); stats.SessionsDeleted != 2 || stats.SessionsIdleReaped != 0 {
+		t.Fatalf("unexpected totals %+v", stats)
+	}
 }
@@ -0,0 +1,145 @@
+package session
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpparse"
+)
+
+// DisabledReasonMediaIdle marks media that stopped receiving packets for
+// MEDIA_IDLE_TIMEOUT_SEC while the session lives on. It is cleared by the next
+// packet on either leg of that media.
+const DisabledReasonMediaIdle = "media_idle"
+
+// mediaActivity tracks the last packet of one media and whether the media was
+// disabled for inactivity. Proxies update it from the packet path.
+type mediaActivity struct {
+	lastNsec atomic.Int64
+	idle     atomic.Bool
+}
+
+func (a *mediaActivity) last() time.Time {
+	nsec := a.lastNsec.Load()
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec).UTC()
+}
+
+// idleReleaser is implemented by proxies that hold buffers worth freeing
+// while their media is idle.
+type idleReleaser interface {
+	releaseIdle()
+}
+
+// markMediaActivity records a packet on one media and re-enables the media
+// if it was disabled for inactivity.
+func (s *Session) markMediaActivity(activity *mediaActivity, media string, now time.Time) {
+	s.markActivity(now)
+	activity.lastNsec.Store(now.UnixNano())
+	if activity.idle.CompareAndSwap(true, false) {
+		s.recordHistory(now, HistoryMediaResumed, media, "")
+		s.Logger().Info("session.media resumed", "media", media)
+	}
+}
+
+// refreshesActivity reports whether packet may keep its session and media
+// alive: at least an RTP header of version 2 and, for an A-leg packet under
+// the SSRC lock, the locked SSRC. latch is nil on the B leg. Port scans and
+// STUN probes thus leave a session without media to the idle reaper even
+// when the non-RTP gate is off.
+func (s *Session) refreshesActivity(latch *ssrcLatch, packet []byte) bool {
+	if len(packet) < 12 || packet[0]>>6 != 2 {
+		return false
+	}
+	if latch == nil || !s.Settings.LockSSRC || rtpparse.IsRTCP(packet) {
+		return true
+	}
+	locked, ok := latch.get()
+	return !ok || binary.BigEndian.Uint32(packet[8:12]) == locked
+}
+
+// applyMediaIdle reports idle media as disabled. Media disabled for another
+// reason keeps that reason.
+func applyMediaIdle(media *Media, activity *mediaActivity) {
+	media.LastActivity = activity.last()
+	if media.Enabled && activity.idle.Load() {
+		media.Enabled = false
+		media.DisabledReason = DisabledReasonMediaIdle
+	}
+}
+
+// StartMediaIdleCheck disables forwarding for media of live sessions that
+// received no packet for timeout, keeping the sockets so that the media comes
+// back with its next packet. A non-positive timeout disables it. The loop
+// stops on Close.
+func (m *Manager) StartMediaIdleCheck(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	interval := timeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.disableIdleMedia(m.now(), timeout)
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Manager) disableIdleMedia(now time.Time, timeout time.Duration) {
+	for _, session := range m.List() {
+		changed := false
+		for _, ref := range []struct {
+			media    string
+			activity *mediaActivity
+			enabled  *atomic.Bool
+			proxy    sessionProxy
+		}{
+			{"audio", &session.audioActivity, &session.audioEnabled, session.audioProxy},
+			{"video", &session.videoActivity, &session.videoEnabled, session.videoProxy},
+		} {
+			if !ref.enabled.Load() || ref.activity.idle.Load() {
+				continue
+			}
+			last := ref.activity.last()
+			if last.IsZero() {
+				last = session.CreatedAt
+			}
+			if now.Sub(last) < timeout || !ref.activity.idle.CompareAndSwap(false, true) {
+				continue
+			}
+			if releaser, ok := ref.proxy.(idleReleaser); ok {
+				releaser.releaseIdle()
+			}
+			session.recordHistory(now, HistoryMediaDisabled, ref.media, DisabledReasonMediaIdle)
+			session.Logger().Info("session.media idle", "media", ref.media, "idle", now.Sub(last))
+			changed = true
+		}
+		if changed {
+			m.publish(EventSessionUpdated, session)
+		}
+	}
+}
+
+// releaseIdle drops the frame buffer capacity of an idle video stream. A
+// frame still being assembled is left to the regular flush.
+func (p *videoProxy) releaseIdle() {
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	if !p.frameBufferActive {
+		p.frameBuffer = nil
+	}
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestManager_DisableIdleMedia verifies per-media idle detection: media
+// without packets for the timeout is reported disabled as media_idle while
+// the session and its other media live on, media disabled for another reason
+// keeps it, and the next packet re-enables the media. Inputs: an audio+video
+// session created at t0 with audio active at t0+30s, checked at t0+40s with a
+// 20s timeout, then a video packet. The expected output is video idle with a
+// history entry and an update event, audio enabled with its last activity,
+// and video enabled again with a media_resumed entry.
+func TestManager_DisableIdleMedia(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-idle", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	events, unsubscribe := manager.Subscribe(4)
+	defer unsubscribe()
+	t0 := created.CreatedAt
+	created.markMediaActivity(&created.audioActivity, "audio", t0.Add(30*time.Second))
+
+	manager.disableIdleMedia(t0.Add(40*time.Second), 20*time.Second)
+
+	video := created.VideoState()
+	if video.Enabled || video.DisabledReason != DisabledReasonMediaIdle {
+		t.Fatalf("expected video idle, got enabled=%v reason=%q", video.Enabled, video.DisabledReason)
+	}
+	audio := created.AudioState()
+	if !audio.Enabled || audio.DisabledReason != "" || !audio.LastActivity.Equal(t0.Add(30*time.Second)) {
+		t.Fatalf("expected audio enabled with last activity, got %+v", audio)
+	}
+	if _, ok := manager.Get(created.ID); !ok {
+		t.Fatalf("expected session to survive")
+	}
+	select {
+	case event := <-events:
+		if event.Type != EventSessionUpdated {
+			t.Fatalf("expected update event, got %v", event.Type)
+		}
+	default:
+		t.Fatalf("expected an update event")
+	}
+	if !hasHistory(created, HistoryMediaDisabled, "video", DisabledReasonMediaIdle) {
+		t.Fatalf("expected media_idle history entry, got %+v", created.History())
+	}
+
+	manager.disableIdleMedia(t0.Add(45*time.Second), 20*time.Second)
+	select {
+	case event := <-events:
+		t.Fatalf("expected no event for media already idle, got %v", event.Type)
+	default:
+	}
+
+	created.markMediaActivity(&created.videoActivity, "video", t0.Add(50*time.Second))
+	if video := created.VideoState(); !video.Enabled || video.DisabledReason != "" {
+		t.Fatalf("expected video re-enabled, got enabled=%v reason=%q", video.Enabled, video.DisabledReason)
+	}
+	if !hasHistory(created, HistoryMediaResumed, "video", "") {
+		t.Fatalf("expected media_resumed history entry, got %+v", created.History())
+	}
+}
+
+// TestManager_DisableIdleMedia_KeepsOtherReasons verifies that media already
+// disabled by the API or not requested is left alone. Inputs: an audio-only
+// session whose audio was disabled with port 0, checked long after creation.
+// The expected output is the original reasons on both media.
+func TestManager_DisableIdleMedia_KeepsOtherReasons(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.Create("call-idle", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, ok, _ := manager.UpdateRTPDest(created.ID, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 0}, nil); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+
+	manager.disableIdleMedia(created.CreatedAt.Add(time.Hour), 20*time.Second)
+
+	if reason := created.AudioState().DisabledReason; reason != "rtpengine_port_0" {
+		t.Fatalf("expected audio reason rtpengine_port_0, got %q", reason)
+	}
+	if reason := created.VideoState().DisabledReason; reason != DisabledReasonNotRequested {
+		t.Fatalf("expected video reason %q, got %q", DisabledReasonNotRequested, reason)
+	}
+}
+
+func hasHistory(session *Session, entryType, media, detail string) bool {
+	for _, entry := range session.History() {
+		if entry.Type == entryType && entry.Media == media && entry.Detail == detail {
+			return true
+		}
+	}
+	return false
+}
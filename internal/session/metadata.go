@@ -0,0 +1,101 @@
+package session
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+const (
+	MaxMetadataEntries    = 32
+	MaxMetadataValueBytes = 256
+)
+
+// ValidateMetadata checks the bounds applied to caller supplied labels.
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataEntries {
+		return fmt.Errorf("metadata has %d entries, at most %d allowed", len(metadata), MaxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if key == "" {
+			return fmt.Errorf("metadata keys must not be empty")
+		}
+		if len(value) > MaxMetadataValueBytes {
+			return fmt.Errorf("metadata value for %q exceeds %d bytes", key, MaxMetadataValueBytes)
+		}
+	}
+	return nil
+}
+
+// Metadata returns a copy of the session labels.
+func (s *Session) Metadata() map[string]string {
+	if s == nil {
+		return nil
+	}
+	current := s.metadata.Load()
+	if current == nil {
+		return nil
+	}
+	return maps.Clone(*current)
+}
+
+func (s *Session) setMetadata(metadata map[string]string) {
+	if len(metadata) == 0 {
+		s.metadata.Store(nil)
+		return
+	}
+	cloned := maps.Clone(metadata)
+	s.metadata.Store(&cloned)
+}
+
+// Logger returns the session-scoped logger. Metadata keys selected through
+// ProxyLogConfig.MetadataKeys are attached under the metadata group, and the
+// per-session level override applies to it.
+func (s *Session) Logger() *slog.Logger {
+	logger := logging.WithLevelOverride(logging.WithSessionID(s.ID), &s.logLevel)
+	metadata := s.metadata.Load()
+	if metadata == nil || len(s.logMetadataKeys) == 0 {
+		return logger
+	}
+	var attrs []any
+	for _, key := range s.logMetadataKeys {
+		if value, ok := (*metadata)[key]; ok {
+			attrs = append(attrs, slog.String(key, value))
+		}
+	}
+	if len(attrs) == 0 {
+		return logger
+	}
+	return logger.With(slog.Group("metadata", attrs...))
+}
+
+// UpdateMetadata merges patch into the session labels. A nil value removes
+// the key; replace discards the existing labels first. The result must pass
+// ValidateMetadata, otherwise the session is left unchanged.
+func (m *Manager) UpdateMetadata(id string, patch map[string]*string, replace bool) (*Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	merged := session.Metadata()
+	if replace || merged == nil {
+		merged = make(map[string]string, len(patch))
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = *value
+	}
+	if err := ValidateMetadata(merged); err != nil {
+		return session, true, err
+	}
+	session.setMetadata(merged)
+	m.publish(EventSessionUpdated, session)
+	return session, true, nil
+}
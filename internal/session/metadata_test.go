@@ -0,0 +1,77 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestManager_UpdateMetadata_MergeDeleteReplace verifies the three update
+// modes of session labels. This matters because callers tag sessions in
+// several steps and must be able to drop a stale key without resending the
+// rest. Inputs: a session created with two labels, then a merge that adds one
+// key and deletes another via nil, then a replace. The expected output is the
+// merged map after the first update and only the replacement afterwards.
+func TestManager_UpdateMetadata_MergeDeleteReplace(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.CreateWithOptions("call-m", "from", "to", false, CreateOptions{
+		Metadata: map[string]string{"tenant": "acme", "building": "b1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+
+	device := "door-7"
+	if _, ok, err := manager.UpdateMetadata(created.ID, map[string]*string{"device_id": &device, "building": nil}, false); !ok || err != nil {
+		t.Fatalf("unexpected merge result ok=%v err=%v", ok, err)
+	}
+	if got := created.Metadata(); len(got) != 2 || got["tenant"] != "acme" || got["device_id"] != "door-7" {
+		t.Fatalf("unexpected metadata after merge: %v", got)
+	}
+
+	tenant := "globex"
+	if _, ok, err := manager.UpdateMetadata(created.ID, map[string]*string{"tenant": &tenant}, true); !ok || err != nil {
+		t.Fatalf("unexpected replace result ok=%v err=%v", ok, err)
+	}
+	if got := created.Metadata(); len(got) != 1 || got["tenant"] != "globex" {
+		t.Fatalf("unexpected metadata after replace: %v", got)
+	}
+}
+
+// TestManager_UpdateMetadata_RejectsOversizedResult verifies that a merge
+// which would exceed the entry limit is rejected and leaves the labels as
+// they were.
+func TestManager_UpdateMetadata_RejectsOversizedResult(t *testing.T) {
+	manager := newTestManager(t, 0)
+	initial := make(map[string]string, MaxMetadataEntries)
+	for i := 0; i < MaxMetadataEntries; i++ {
+		initial[fmt.Sprintf("key-%d", i)] = "v"
+	}
+	created, err := manager.CreateWithOptions("call-m", "from", "to", false, CreateOptions{Metadata: initial})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+
+	extra := "v"
+	if _, ok, err := manager.UpdateMetadata(created.ID, map[string]*string{"one-more": &extra}, false); !ok || err == nil {
+		t.Fatalf("expected validation error, got ok=%v err=%v", ok, err)
+	}
+	if got := created.Metadata(); len(got) != MaxMetadataEntries {
+		t.Fatalf("expected metadata to stay unchanged, got %d entries", len(got))
+	}
+	if _, ok, _ := manager.UpdateMetadata("missing", nil, true); ok {
+		t.Fatalf("expected unknown session to report not found")
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := ValidateMetadata(map[string]string{"tenant": strings.Repeat("x", MaxMetadataValueBytes)}); err != nil {
+		t.Fatalf("expected value at the limit to pass, got %v", err)
+	}
+	if err := ValidateMetadata(map[string]string{"tenant": strings.Repeat("x", MaxMetadataValueBytes+1)}); err == nil {
+		t.Fatalf("expected value over the limit to fail")
+	}
+	if err := ValidateMetadata(map[string]string{"": "v"}); err == nil {
+		t.Fatalf("expected empty key to fail")
+	}
+}
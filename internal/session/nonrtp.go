@@ -0,0 +1,58 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// stunMagicCookie is the fixed word at bytes 4-7 of every STUN message (RFC
+// 5389).
+const stunMagicCookie = 0x2112A442
+
+// nonRTPLogEvery samples the debug log of non-RTP drops: the first drop on a
+// leg is logged, then every nonRTPLogEvery-th.
+const nonRTPLogEvery = 1000
+
+// nonRTPKind classifies a datagram that can be neither RTP nor muxed RTCP, or
+// returns "" for one that can. Only the fixed header is checked, so the gate
+// costs a few byte comparisons per packet.
+func nonRTPKind(packet []byte) string {
+	switch {
+	case len(packet) < 12:
+		return "short"
+	case packet[0]>>6 == 0 && binary.BigEndian.Uint32(packet[4:8]) == stunMagicCookie:
+		return "stun"
+	case packet[0]>>6 != 2:
+		return "bad_version"
+	}
+	return ""
+}
+
+// dropNonRTP reports whether packet, read on the given leg of media, is
+// dropped by the non-RTP gate, counting the drop when it is. Dropped packets
+// are counted nowhere else: they are not media activity, are not captured and
+// never teach the proxy a peer.
+func (s *Session) dropNonRTP(media, leg string, counters *dropCounters, packet []byte, addr *net.UDPAddr) bool {
+	if !s.nonRTPGate {
+		return false
+	}
+	kind := nonRTPKind(packet)
+	if kind == "" {
+		return false
+	}
+	reason := dropNonRTPA
+	if leg == "b" {
+		reason = dropNonRTPB
+	}
+	if count := counters.drop(reason); count%nonRTPLogEvery == 1 {
+		s.Logger().Debug("media.non_rtp_dropped",
+			"media", media,
+			"leg", leg,
+			"kind", kind,
+			"remote_addr", addr.String(),
+			"size", len(packet),
+			"count", count,
+		)
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func makeSTUNBindingRequest() []byte {
+	packet := make([]byte, 20)
+	binary.BigEndian.PutUint16(packet[0:2], 0x0001)
+	binary.BigEndian.PutUint32(packet[4:8], stunMagicCookie)
+	copy(packet[8:], "transaction1")
+	return packet
+}
+
+// TestNonRTPKind verifies the cheap validity gate in front of the proxies.
+// This matters because STUN and scanner junk used to be forwarded to
+// rtpengine and parsed as H264. Inputs: a STUN binding request, a 4-byte
+// datagram, a DTLS record, RTP and muxed RTCP. The expected output is a kind
+// for each non-RTP datagram and "" for RTP and RTCP.
+func TestNonRTPKind(t *testing.T) {
+	rtcp := makeRTPPacket(1, 0, make([]byte, 16))
+	rtcp[1] = 200
+	dtls := append([]byte{22, 0xfe, 0xfd}, make([]byte, 20)...)
+	tests := []struct {
+		name   string
+		packet []byte
+		want   string
+	}{
+		{"stun", makeSTUNBindingRequest(), "stun"},
+		{"short", []byte{0x80, 0x00, 0x00, 0x01}, "short"},
+		{"dtls", dtls, "bad_version"},
+		{"rtp", makeRTPPacket(1, 160, make([]byte, 160)), ""},
+		{"rtcp", rtcp, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nonRTPKind(tt.packet); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestAudioProxyDropsNonRTP verifies the gate on both legs of a running
+// proxy. Inputs: STUN and a 4-byte datagram ahead of one RTP packet on the A
+// leg, and STUN ahead of one RTP packet on the B leg. The expected output is
+// only the RTP packets forwarded, unchanged, with two A-leg and one B-leg
+// non-RTP drops that count neither as received packets nor in another drop
+// reason.
+func TestAudioProxyDropsNonRTP(t *testing.T) {
+	session := &Session{ID: "S-non-rtp", nonRTPGate: true}
+	session.audioEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	session.audioDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newAudioProxy(session, aConn, bConn, time.Second, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	rtp := makeRTPPacket(1, 160, make([]byte, 160))
+	for _, packet := range [][]byte{makeSTUNBindingRequest(), {1, 2, 3, 4}, rtp} {
+		if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+			t.Fatalf("send to a-leg failed: %v", err)
+		}
+	}
+	expectOnlyPacket(t, rtpEngineConn, rtp)
+
+	for _, packet := range [][]byte{makeSTUNBindingRequest(), rtp} {
+		if _, err := rtpEngineConn.WriteToUDP(packet, localUDPAddr(bConn)); err != nil {
+			t.Fatalf("send to b-leg failed: %v", err)
+		}
+	}
+	expectOnlyPacket(t, doorphoneConn, rtp)
+
+	counters := snapshotAudioCounters(&session.audioCounters)
+	if counters.DropsNonRTPA != 2 || counters.DropsNonRTPB != 1 || counters.Drops != 3 {
+		t.Fatalf("unexpected non-rtp drops: %+v", counters.DropCounters)
+	}
+	if counters.AInPkts != 1 || counters.BInPkts != 1 {
+		t.Fatalf("expected only rtp to count as received, got a=%d b=%d", counters.AInPkts, counters.BInPkts)
+	}
+}
+
+// TestAudioProxyForwardsNonRTPWithoutGate verifies that DROP_NON_RTP=false
+// keeps the transparent forwarding some deployments rely on. Input: a
+// 4-byte datagram on the A leg with the gate off. The expected output is the
+// datagram forwarded as is and no drop.
+func TestAudioProxyForwardsNonRTPWithoutGate(t *testing.T) {
+	session := &Session{ID: "S-non-rtp-off"}
+	session.audioEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	session.audioDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newAudioProxy(session, aConn, bConn, time.Second, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	junk := []byte{1, 2, 3, 4}
+	if _, err := doorphoneConn.WriteToUDP(junk, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	expectOnlyPacket(t, rtpEngineConn, junk)
+	if counters := snapshotAudioCounters(&session.audioCounters); counters.Drops != 0 {
+		t.Fatalf("expected no drops without the gate, got %+v", counters.DropCounters)
+	}
+}
+
+// expectOnlyPacket reads want from conn and fails if anything else arrives.
+func expectOnlyPacket(t *testing.T, conn *net.UDPConn, want []byte) {
+	t.Helper()
+	buffer := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(buffer[:n], want) {
+		t.Fatalf("expected %x, got %x", want, buffer[:n])
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(buffer); err == nil {
+		t.Fatalf("expected nothing else, got %x", buffer[:n])
+	}
+}
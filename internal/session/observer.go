@@ -0,0 +1,105 @@
+package session
+
+import "sync"
+
+// Observer is notified of session lifecycle transitions by programs that
+// embed the manager. Callbacks run one at a time on a dedicated goroutine,
+// outside the manager lock and in the order the transitions happened, so a
+// session's OnCreated comes before its OnActive and OnDeleted. A slow
+// observer delays later notifications but never session operations, and a
+// panic is logged and swallowed.
+type Observer interface {
+	OnCreated(session *Session)
+	// OnActive fires once, on the first packet the session relays.
+	OnActive(session *Session)
+	// OnDeleted reports why the session was removed: api, bulk_api, idle,
+	// max_lifetime or shutdown.
+	OnDeleted(session *Session, reason string)
+}
+
+type observation int
+
+const (
+	observeCreated observation = iota
+	observeActive
+	observeDeleted
+)
+
+type notification struct {
+	kind    observation
+	session *Session
+	reason  string
+}
+
+// observers queues notifications without bound so that proxies can report
+// the active transition from the packet path without blocking.
+type observers struct {
+	mu        sync.Mutex
+	observers []Observer
+	queue     []notification
+	wake      chan struct{}
+}
+
+// AddObserver registers an observer for the transitions that happen from
+// now on. The dispatch goroutine starts with the first observer and lives as
+// long as the process, so the deletions of StopAllSessions are reported too.
+func (m *Manager) AddObserver(observer Observer) {
+	o := &m.observers
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.wake == nil {
+		o.wake = make(chan struct{}, 1)
+		go o.dispatch(o.wake)
+	}
+	o.observers = append(o.observers, observer)
+}
+
+func (o *observers) notify(n notification) {
+	o.mu.Lock()
+	if len(o.observers) == 0 {
+		o.mu.Unlock()
+		return
+	}
+	o.queue = append(o.queue, n)
+	wake := o.wake
+	o.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *observers) dispatch(wake <-chan struct{}) {
+	for range wake {
+		for {
+			o.mu.Lock()
+			queue, targets := o.queue, o.observers
+			o.queue = nil
+			o.mu.Unlock()
+			if len(queue) == 0 {
+				break
+			}
+			for _, n := range queue {
+				for _, observer := range targets {
+					deliver(observer, n)
+				}
+			}
+		}
+	}
+}
+
+func deliver(observer Observer, n notification) {
+	defer func() {
+		if r := recover(); r != nil {
+			n.session.Logger().Error("session.observer panic", "panic", r)
+		}
+	}()
+	switch n.kind {
+	case observeCreated:
+		observer.OnCreated(n.session)
+	case observeActive:
+		observer.OnActive(n.session)
+	case observeDeleted:
+		observer.OnDeleted(n.session, n.reason)
+	}
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	events chan string
+}
+
+func (o *recordingObserver) OnCreated(session *Session) { o.events <- "created " + session.CallID }
+func (o *recordingObserver) OnActive(session *Session)  { o.events <- "active " + session.CallID }
+func (o *recordingObserver) OnDeleted(session *Session, reason string) {
+	o.events <- "deleted " + session.CallID + " " + reason
+}
+
+type panickingObserver struct{}
+
+func (panickingObserver) OnCreated(*Session)         { panic("observer failed") }
+func (panickingObserver) OnActive(*Session)          { panic("observer failed") }
+func (panickingObserver) OnDeleted(*Session, string) { panic("observer failed") }
+
+// TestManager_Observer verifies that observers see each session's created,
+// active and deleted transitions in order with the delete reason, that the
+// active transition fires only once, and that a panicking observer neither
+// stops the notifications of the others nor breaks the manager. Inputs: a
+// panicking observer registered before a recording one, a session that
+// relays two packets and is deleted through the API path, and a second
+// session removed on shutdown. The expected output is the five transitions
+// in order on the recording observer.
+func TestManager_Observer(t *testing.T) {
+	manager := newTestManager(t, 0)
+	recorder := &recordingObserver{events: make(chan string, 16)}
+	manager.AddObserver(panickingObserver{})
+	manager.AddObserver(recorder)
+
+	first, err := manager.Create("call-1", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	first.markActivity(manager.now())
+	first.markActivity(manager.now())
+	if _, ok := manager.DeleteWithReason(first.ID, "api"); !ok {
+		t.Fatalf("expected delete to succeed")
+	}
+	if _, err := manager.Create("call-2", "from", "to", true, false, false); err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	manager.StopAllSessions()
+
+	for _, want := range []string{
+		"created call-1",
+		"active call-1",
+		"deleted call-1 api",
+		"created call-2",
+		"deleted call-2 shutdown",
+	} {
+		select {
+		case got := <-recorder.events:
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	select {
+	case got := <-recorder.events:
+		t.Fatalf("unexpected notification %q", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
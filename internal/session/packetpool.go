@@ -0,0 +1,70 @@
+package session
+
+import "sync"
+
+// packetBuffer is a receive buffer of the video A leg. The frame buffer holds
+// packets in these buffers, keeping the one a packet was read into instead of
+// copying it, and returns them to packetPool once the frame is sent or
+// dropped.
+type packetBuffer []byte
+
+var packetPool sync.Pool
+
+// getPacketBuffer returns a buffer of size bytes, from the pool when it holds
+// one large enough.
+func getPacketBuffer(size int) *packetBuffer {
+	if buf, ok := packetPool.Get().(*packetBuffer); ok && cap(*buf) >= size {
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make(packetBuffer, size)
+	return &buf
+}
+
+func putPacketBuffer(buf *packetBuffer) {
+	if buf != nil {
+		packetPool.Put(buf)
+	}
+}
+
+// framePacket is a packet of the frame being assembled. buf is the pooled
+// buffer holding data, nil for a packet allocated elsewhere such as a pending
+// parameter set.
+type framePacket struct {
+	data []byte
+	buf  *packetBuffer
+}
+
+// release returns the buffer of the packet to the pool. The packet must not
+// be used afterwards.
+func (f framePacket) release() {
+	putPacketBuffer(f.buf)
+}
+
+// holdFramePacket returns packet as held by the frame buffer. The receive
+// buffer of the packet being handled is taken over when packet lies in it;
+// otherwise packet is copied into a pooled buffer, or allocated when it does
+// not fit one.
+func (p *videoProxy) holdFramePacket(packet []byte) framePacket {
+	if received := p.receiveBuffer; received != nil && len(packet) > 0 && &packet[0] == &(*received)[0] {
+		p.receiveBuffer = nil
+		return framePacket{data: packet, buf: received}
+	}
+	size := p.session.readBufferSize()
+	if len(packet) > size {
+		return framePacket{data: append([]byte(nil), packet...)}
+	}
+	buf := getPacketBuffer(size)
+	return framePacket{data: (*buf)[:copy(*buf, packet)], buf: buf}
+}
+
+// clearFrameBuffer empties the frame buffer, returning its buffers to the
+// pool.
+func (p *videoProxy) clearFrameBuffer() {
+	for _, packet := range p.frameBuffer {
+		packet.release()
+	}
+	clear(p.frameBuffer)
+	p.frameBuffer = p.frameBuffer[:0]
+	p.frameBufferBytes = 0
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// makeTaggedFragment builds a 62-byte FU-A fragment of an IDR frame whose
+// payload after the FU header is filled with tag.
+func makeTaggedFragment(seq uint16, ts uint32, fuHeader, tag byte) []byte {
+	payload := bytes.Repeat([]byte{tag}, 50)
+	payload[0], payload[1] = 0x7c, fuHeader
+	return makeRTPPacket(seq, ts, payload)
+}
+
+// TestVideoProxyKeepsReceiveBuffer verifies that a buffered packet stays in
+// the buffer it was read into. This matters because copying every buffered
+// packet was the largest allocation source with many video sessions. Inputs:
+// the start fragment of a frame handled from a receive buffer, as the A-leg
+// loop does, then its end fragment from a plain slice. The expected output is
+// the receive buffer taken over by the frame buffer without a copy, the plain
+// slice copied, and the frame buffer empty after the end fragment flushed it.
+func TestVideoProxyKeepsReceiveBuffer(t *testing.T) {
+	session := &Session{ID: "S-pool"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	sent := 0
+	proxy.writeToDest = func([]byte, *net.UDPAddr) error {
+		sent++
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	received := getPacketBuffer(session.readBufferSize())
+	n := copy(*received, makeTaggedFragment(1, 9000, 0x85, 1))
+
+	proxy.receiveBuffer = received
+	proxy.handleVideoPacket((*received)[:n], dest)
+	if proxy.receiveBuffer != nil || len(proxy.frameBuffer) != 1 || &proxy.frameBuffer[0].data[0] != &(*received)[0] {
+		t.Fatalf("expected the frame buffer to keep the receive buffer, got %d packets", len(proxy.frameBuffer))
+	}
+	end := makeTaggedFragment(2, 9000, 0x45, 2)
+	proxy.bufferFramePacket(time.Now(), dest, end)
+	if held := proxy.frameBuffer[1]; held.buf == nil || &held.data[0] == &end[0] {
+		t.Fatalf("expected a plain slice copied into a pooled buffer")
+	}
+	proxy.flushFrameBuffer(time.Now(), dest, false)
+	if sent != 2 || len(proxy.frameBuffer) != 0 {
+		t.Fatalf("expected 2 packets sent and an empty frame buffer, got %d and %d", sent, len(proxy.frameBuffer))
+	}
+}
+
+// TestVideoProxyPooledBufferLifecycle exercises the pooled receive buffers
+// across the A-leg loop and the frame flush timer; run it with -race. This
+// matters because a buffer returned to the pool while a frame still holds it
+// would send one packet's bytes as another's. Inputs: a started fix-mode
+// proxy with a 5ms frame wait and 30 frames of three fragments from a
+// doorphone socket, every third frame missing its end fragment and left for
+// the timer. The expected output is every fragment arriving once at rtpengine
+// with its payload intact.
+func TestVideoProxyPooledBufferLifecycle(t *testing.T) {
+	session := &Session{ID: "S-pool-race"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+	proxy := newVideoProxy(session, aConn, bConn, time.Second, 5*time.Millisecond, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	want := map[byte]bool{}
+	seq := uint16(0)
+	for frame := 0; frame < 30; frame++ {
+		headers := []byte{0x85, 0x05, 0x45}
+		if frame%3 == 2 {
+			headers = headers[:2]
+		}
+		for _, header := range headers {
+			seq++
+			tag := byte(seq)
+			want[tag] = true
+			if _, err := doorphoneConn.WriteToUDP(makeTaggedFragment(seq, uint32(frame)*3000, header, tag), localUDPAddr(aConn)); err != nil {
+				t.Fatalf("send to a-leg failed: %v", err)
+			}
+		}
+		if len(headers) == 2 {
+			time.Sleep(15 * time.Millisecond)
+		}
+	}
+
+	buffer := make([]byte, 2048)
+	for len(want) > 0 {
+		_ = rtpEngineConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := rtpEngineConn.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("expected %d more fragments: %v", len(want), err)
+		}
+		tag := buffer[14]
+		if n != 62 || !bytes.Equal(buffer[14:n], bytes.Repeat([]byte{tag}, n-14)) {
+			t.Fatalf("expected an intact fragment, got %x", buffer[:n])
+		}
+		if !want[tag] {
+			t.Fatalf("unexpected or repeated fragment %d", tag)
+		}
+		delete(want, tag)
+	}
+}
+
+// BenchmarkVideoProxyFixFrame measures the allocations of buffering and
+// flushing a 16-fragment frame in fix mode, each fragment handled from a
+// receive buffer as the A-leg loop does.
+func BenchmarkVideoProxyFixFrame(b *testing.B) {
+	session := &Session{ID: "S-bench"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.writeToDest = func([]byte, *net.UDPAddr) error { return nil }
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	const fragments = 16
+	packets := make([][]byte, fragments)
+	for i := range packets {
+		header := byte(0x05)
+		switch i {
+		case 0:
+			header = 0x85
+		case fragments - 1:
+			header = 0x45
+		}
+		packets[i] = makeTaggedFragment(uint16(i), 9000, header, byte(i))
+	}
+	size := proxy.session.readBufferSize()
+	received := getPacketBuffer(size)
+	b.ReportAllocs()
+	for frame := 0; b.Loop(); frame++ {
+		for i, packet := range packets {
+			buffer := *received
+			n := copy(buffer, packet)
+			binary.BigEndian.PutUint16(buffer[2:4], uint16(frame*fragments+i))
+			proxy.receiveBuffer = received
+			proxy.handleVideoPacket(buffer[:n], dest)
+			if proxy.receiveBuffer == nil {
+				received = getPacketBuffer(size)
+			}
+			proxy.receiveBuffer = nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package session
+
+import "net"
+
+// DefaultMaxPacketSize is the largest datagram relayed by default. It fits the
+// RTP packets of doorphones on jumbo-frame LANs, which reach about 9000 bytes.
+const DefaultMaxPacketSize = 9216
+
+// MaxPacketSizeLimit is the largest maximum packet size accepted, the largest
+// UDP payload.
+const MaxPacketSizeLimit = 65535
+
+// truncatedLogEvery samples the warning of truncated drops: the first drop on
+// a leg is logged, then every truncatedLogEvery-th.
+const truncatedLogEvery = 1000
+
+// SetMaxPacketSize sets the largest datagram the proxies relay; longer ones
+// are dropped rather than forwarded truncated. 0 keeps DefaultMaxPacketSize.
+// It must be called before sessions are created.
+func (m *Manager) SetMaxPacketSize(size int) {
+	if size <= 0 {
+		size = DefaultMaxPacketSize
+	}
+	m.maxPacketSize = size
+}
+
+// readBufferSize is the size of the receive buffers of the session's sockets:
+// one byte over the maximum packet size, so that a datagram filling it is
+// known to have been truncated by the read.
+func (s *Session) readBufferSize() int {
+	size := s.maxPacketSize
+	if size <= 0 {
+		size = DefaultMaxPacketSize
+	}
+	return size + 1
+}
+
+// dropTruncated reports whether a datagram of n bytes, read on the given leg
+// of media, was longer than the maximum packet size, counting the drop when it
+// was. Its tail was cut off by the read, and a damaged H264 payload would
+// corrupt decoding downstream, so it is dropped before anything else looks at
+// it.
+func (s *Session) dropTruncated(media, leg string, counters *dropCounters, n int, addr *net.UDPAddr) bool {
+	if n < s.readBufferSize() {
+		return false
+	}
+	if count := counters.drop(dropTruncated); count%truncatedLogEvery == 1 {
+		s.Logger().Warn("media.truncated_dropped",
+			"media", media,
+			"leg", leg,
+			"remote_addr", addr.String(),
+			"max_packet_size", s.readBufferSize()-1,
+			"count", count,
+		)
+	}
+	return true
+}
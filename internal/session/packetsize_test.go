@@ -0,0 +1,108 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// newPacketSizeSession creates an audio-only session with a real proxy on
+// loopback, relaying to rtpengine, with the given maximum packet size.
+func newPacketSizeSession(t *testing.T, maxPacketSize int, rtpengine *net.UDPConn) *Session {
+	t.Helper()
+	manager := newTestManager(t, 0)
+	manager.SetBindIPs(net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1))
+	manager.SetMaxPacketSize(maxPacketSize)
+	manager.listenUDP = net.ListenUDP
+	manager.newAudioProxy = func(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration, logConfig ProxyLogConfig) sessionProxy {
+		return newAudioProxy(session, aConn, bConn, peerLearningWindow, logConfig)
+	}
+	created, err := manager.CreateWithOptions("call-packet-size", "from", "to", false, CreateOptions{
+		DisableVideo:     true,
+		InitialAudioDest: localUDPAddr(rtpengine),
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	t.Cleanup(func() { manager.StopAllSessions() })
+	return created
+}
+
+// makeSizedRTPPacket returns an RTP packet of size bytes whose payload bytes
+// all differ from their neighbours, so that a cut or shifted payload shows.
+func makeSizedRTPPacket(seq uint16, size int) []byte {
+	payload := make([]byte, size-12)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	return makeRTPPacket(seq, uint32(seq)*160, payload)
+}
+
+// TestAudioProxyForwardsJumboPacket verifies that a packet longer than a
+// standard Ethernet frame is relayed whole under the default maximum packet
+// size. This matters because doorphones on jumbo-frame LANs send RTP packets
+// of several kilobytes, which a 2048-byte read buffer used to cut silently.
+// Inputs: an audio session with the default maximum packet size and a
+// 3000-byte RTP packet from the doorphone. The expected output is the same
+// 3000 bytes arriving at rtpengine and no drops.
+func TestAudioProxyForwardsJumboPacket(t *testing.T) {
+	rtpengine := mustListenUDP(t)
+	defer rtpengine.Close()
+	created := newPacketSizeSession(t, 0, rtpengine)
+	doorphone := mustListenUDP(t)
+	defer doorphone.Close()
+
+	packet := makeSizedRTPPacket(1, 3000)
+	if _, err := doorphone.WriteToUDP(packet, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: created.Audio.APort}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	buffer := make([]byte, MaxPacketSizeLimit)
+	_ = rtpengine.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := rtpengine.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("expected the packet at rtpengine, got %v", err)
+	}
+	if !bytes.Equal(buffer[:n], packet) {
+		t.Fatalf("expected the 3000-byte packet forwarded unchanged, got %d bytes", n)
+	}
+	if counters := created.AudioCountersSnapshot(); counters.Drops != 0 {
+		t.Fatalf("expected no drops, got %+v", counters.DropCounters)
+	}
+}
+
+// TestAudioProxyDropsTruncatedPacket verifies that a datagram longer than the
+// maximum packet size is dropped instead of being forwarded cut. This matters
+// because a truncated payload corrupts decoding downstream with no trace.
+// Inputs: an audio session with a 1500-byte maximum packet size, then a
+// 3000-byte and a 1500-byte RTP packet from the doorphone. The expected output
+// is only the 1500-byte packet at rtpengine and one truncated drop counted in
+// the drops total.
+func TestAudioProxyDropsTruncatedPacket(t *testing.T) {
+	rtpengine := mustListenUDP(t)
+	defer rtpengine.Close()
+	created := newPacketSizeSession(t, 1500, rtpengine)
+	doorphone := mustListenUDP(t)
+	defer doorphone.Close()
+
+	aAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: created.Audio.APort}
+	fitting := makeSizedRTPPacket(2, 1500)
+	for _, packet := range [][]byte{makeSizedRTPPacket(1, 3000), fitting} {
+		if _, err := doorphone.WriteToUDP(packet, aAddr); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	buffer := make([]byte, MaxPacketSizeLimit)
+	_ = rtpengine.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := rtpengine.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("expected the fitting packet at rtpengine, got %v", err)
+	}
+	if !bytes.Equal(buffer[:n], fitting) {
+		t.Fatalf("expected only the 1500-byte packet forwarded, got %d bytes", n)
+	}
+	counters := created.AudioCountersSnapshot()
+	if counters.DropsTruncated != 1 || counters.Drops != 1 || counters.AInPkts != 1 {
+		t.Fatalf("expected one truncated drop and one packet in, got %+v and %d in", counters.DropCounters, counters.AInPkts)
+	}
+}
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultPeerRelearnAfter is how long a doorphone peer must be silent before
+// a new source replaces it, unless configured otherwise.
+const DefaultPeerRelearnAfter = 5 * time.Second
+
+// DefaultPeerSwitchPackets is how many packets in a row a new source must
+// send before it replaces a learned doorphone peer, unless configured
+// otherwise.
+const DefaultPeerSwitchPackets = 3
+
+// peerSwitchInterval is how long a new source has, from its first packet, to
+// send the packets that let it replace the peer.
+const peerSwitchInterval = time.Second
+
+// peerDecision is what a proxy does with the source of an A-leg packet.
+type peerDecision int
+
+const (
+	// peerReject: the source is not the doorphone peer and may not replace it.
+	peerReject peerDecision = iota
+	// peerAccept: the source is the doorphone peer.
+	peerAccept
+	// peerLearn: no peer is known yet, so the source becomes the peer.
+	peerLearn
+	// peerChange: the peer moved within the learning window.
+	peerChange
+	// peerRelearn: the peer moved after the learning window, but the old one
+	// had been silent long enough to be taken for gone, e.g. a doorphone that
+	// rebooted mid-call onto a new source port.
+	peerRelearn
+)
+
+// decidePeer applies the peer learning rules to a packet from addr arriving
+// at now. peer was learned at learnedAt and last accepted at lastSeen; it may
+// move freely for window after it was learned and, when relearnAfter is not
+// zero, again once it has been silent for relearnAfter.
+func decidePeer(peer, addr *net.UDPAddr, learnedAt, lastSeen, now time.Time, window, relearnAfter time.Duration) peerDecision {
+	switch {
+	case peer == nil:
+		return peerLearn
+	case peer.IP.Equal(addr.IP) && peer.Port == addr.Port:
+		return peerAccept
+	case now.Sub(learnedAt) <= window:
+		return peerChange
+	case relearnAfter > 0 && now.Sub(lastSeen) >= relearnAfter:
+		return peerRelearn
+	}
+	return peerReject
+}
+
+// peerCandidate is a source that wants to replace the learned doorphone peer.
+// It must send the required packets in a row, with nothing accepted from the
+// peer or sent by another candidate in between, within peerSwitchInterval of its
+// first one, so that a single spoofed packet cannot take over the return
+// path.
+type peerCandidate struct {
+	addr      *net.UDPAddr
+	firstSeen time.Time
+	packets   int
+}
+
+// observe counts a packet from addr arriving at now and reports whether addr
+// has sent required packets in a row and may replace the peer. A required
+// count of 1 or less promotes addr at once.
+func (c *peerCandidate) observe(addr *net.UDPAddr, now time.Time, required int) bool {
+	if c.addr == nil || !c.addr.IP.Equal(addr.IP) || c.addr.Port != addr.Port || now.Sub(c.firstSeen) > peerSwitchInterval {
+		*c = peerCandidate{addr: cloneUDPAddr(addr), firstSeen: now}
+	}
+	c.packets++
+	if c.packets < required {
+		return false
+	}
+	c.reset()
+	return true
+}
+
+// reset forgets the candidate, e.g. once the peer is heard from again.
+func (c *peerCandidate) reset() {
+	*c = peerCandidate{}
+}
+
+// fromRTPEngine reports whether a B-leg packet from addr comes from rtpengine
+// at ip and port. Only the IP is compared when the strict port check is off.
+func (s *Session) fromRTPEngine(addr *net.UDPAddr, ip net.IP, port int) bool {
+	return ip.Equal(addr.IP) && (!s.bStrictPort || addr.Port == port)
+}
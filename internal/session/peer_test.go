@@ -0,0 +1,168 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDecidePeer verifies the peer learning rules against a fake clock. This
+// matters because a doorphone that reboots mid-call comes back from a new
+// source port, and once the learning window had closed it used to be ignored
+// for good, leaving the call one-way. Inputs: the first packet, the peer
+// itself, a new source inside the window, and a new source after the window
+// with the peer silent for less than, exactly and more than the re-learn
+// delay, or with re-learning off. The expected output is learn, accept,
+// change, reject until the peer has been silent for 5s, then relearn.
+func TestDecidePeer(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40002}
+	learnedAt := time.Unix(1_700_000_000, 0)
+	window := 10 * time.Second
+	tests := []struct {
+		name         string
+		peer         *net.UDPAddr
+		addr         *net.UDPAddr
+		lastSeen     time.Duration
+		now          time.Duration
+		relearnAfter time.Duration
+		want         peerDecision
+	}{
+		{"first packet", nil, peer, 0, 0, 5 * time.Second, peerLearn},
+		{"same peer", peer, peer, 0, time.Minute, 5 * time.Second, peerAccept},
+		{"moved in window", peer, moved, 9 * time.Second, 10 * time.Second, 5 * time.Second, peerChange},
+		{"moved while peer talks", peer, moved, 59 * time.Second, time.Minute, 5 * time.Second, peerReject},
+		{"moved after silence", peer, moved, 55 * time.Second, time.Minute, 5 * time.Second, peerRelearn},
+		{"moved after long silence", peer, moved, 20 * time.Second, time.Minute, 5 * time.Second, peerRelearn},
+		{"relearn off", peer, moved, 0, time.Minute, 0, peerReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decidePeer(tt.peer, tt.addr, learnedAt, learnedAt.Add(tt.lastSeen), learnedAt.Add(tt.now), window, tt.relearnAfter)
+			if got != tt.want {
+				t.Fatalf("expected decision %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestAudioProxyRelearnsSilentPeer verifies what a proxy does on a re-learn.
+// Inputs: a peer learned outside the window that has been silent for longer
+// than the re-learn delay, then packets from a new source and the old one.
+// The expected output is the new source accepted and flagged as relearned,
+// one re-learn counted and recorded in the history, and the old source
+// rejected afterwards.
+func TestAudioProxyRelearnsSilentPeer(t *testing.T) {
+	session := &Session{ID: "S-relearn", relearnAfter: 5 * time.Second}
+	proxy := newAudioProxy(session, nil, nil, time.Second, ProxyLogConfig{})
+	old := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40002}
+	proxy.doorphonePeer = old
+	proxy.doorphoneLearnedAt = time.Now().Add(-time.Minute)
+	proxy.doorphoneLastSeen = time.Now().Add(-6 * time.Second)
+
+	if !proxy.updateDoorphonePeer(moved) {
+		t.Fatalf("expected the new source to be accepted after the peer went silent")
+	}
+	if proxy.updateDoorphonePeer(old) {
+		t.Fatalf("expected the old source to be rejected once the new one is talking")
+	}
+	state := proxy.doorphonePeerState()
+	if state.peer.String() != moved.String() || state.relearnedAt.IsZero() {
+		t.Fatalf("expected the moved peer flagged as relearned, got %+v", state)
+	}
+	if counters := snapshotAudioCounters(&session.audioCounters); counters.PeerRelearns != 1 {
+		t.Fatalf("expected one re-learn, got %d", counters.PeerRelearns)
+	}
+	history := session.History()
+	if len(history) != 1 || history[0].Type != HistoryPeerRelearned || history[0].Detail != moved.String() {
+		t.Fatalf("expected a peer_relearned history entry, got %+v", history)
+	}
+}
+
+// TestPeerCandidate verifies the switch rules against a fake clock. This
+// matters because a single spoofed packet inside the learning window used to
+// re-point the doorphone peer and hijack the return path. Inputs: runs of
+// packets from a candidate, broken by another candidate, by the peer, or by
+// a gap longer than peerSwitchInterval, with 3 packets required, and one
+// packet with 1 required. The expected output is promotion on the third
+// packet of an unbroken run only, and at once when 1 is required.
+func TestPeerCandidate(t *testing.T) {
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40002}
+	other := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 40000}
+	start := time.Unix(1_700_000_000, 0)
+	type packet struct {
+		addr *net.UDPAddr
+		at   time.Duration
+	}
+	tests := []struct {
+		name      string
+		packets   []packet
+		peerAfter int
+		required  int
+		want      []bool
+	}{
+		{"run", []packet{{moved, 0}, {moved, 20 * time.Millisecond}, {moved, 40 * time.Millisecond}}, -1, 3, []bool{false, false, true}},
+		{"other candidate", []packet{{moved, 0}, {other, 10 * time.Millisecond}, {moved, 20 * time.Millisecond}, {moved, 40 * time.Millisecond}}, -1, 3, []bool{false, false, false, false}},
+		{"peer in between", []packet{{moved, 0}, {moved, 20 * time.Millisecond}, {moved, 40 * time.Millisecond}}, 1, 3, []bool{false, false, false}},
+		{"too slow", []packet{{moved, 0}, {moved, 600 * time.Millisecond}, {moved, 1200 * time.Millisecond}}, -1, 3, []bool{false, false, false}},
+		{"one required", []packet{{moved, 0}}, -1, 1, []bool{true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var candidate peerCandidate
+			for i, p := range tt.packets {
+				if got := candidate.observe(p.addr, start.Add(p.at), tt.required); got != tt.want[i] {
+					t.Fatalf("packet %d: expected promoted %t, got %t", i, tt.want[i], got)
+				}
+				if i == tt.peerAfter {
+					candidate.reset()
+				}
+			}
+		})
+	}
+}
+
+// TestAudioProxyRequiresPacketsToSwitchPeer verifies that a proxy holds a new
+// source back until it has sent enough packets. Inputs: a learned peer inside
+// its learning window on a session requiring 3 switch packets, then one
+// packet from a spoofed source, the peer again, and three packets from a
+// moved source. The expected output is the spoofed packet and the first two
+// moved ones rejected, the peer kept until the third moved packet, three
+// rejected switches counted, and only peer_learned and one peer_changed
+// recorded in the history.
+func TestAudioProxyRequiresPacketsToSwitchPeer(t *testing.T) {
+	session := &Session{ID: "S-switch", peerSwitchPackets: 3}
+	proxy := newAudioProxy(session, nil, nil, time.Hour, ProxyLogConfig{})
+	peer := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}
+	spoofed := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 40000}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40002}
+
+	if !proxy.updateDoorphonePeer(peer) {
+		t.Fatalf("expected the first source to be learned at once")
+	}
+	if proxy.updateDoorphonePeer(spoofed) {
+		t.Fatalf("expected a single packet from a new source to be rejected")
+	}
+	if !proxy.updateDoorphonePeer(peer) {
+		t.Fatalf("expected the peer to be kept after a single foreign packet")
+	}
+	for i := 0; i < 2; i++ {
+		if proxy.updateDoorphonePeer(moved) {
+			t.Fatalf("expected moved packet %d to be held back", i)
+		}
+	}
+	if !proxy.updateDoorphonePeer(moved) {
+		t.Fatalf("expected the third moved packet to switch the peer")
+	}
+	if state := proxy.doorphonePeerState(); state.peer.String() != moved.String() {
+		t.Fatalf("expected the moved peer, got %s", state.peer)
+	}
+	if counters := snapshotAudioCounters(&session.audioCounters); counters.PeerSwitchesRejected != 3 {
+		t.Fatalf("expected 3 rejected switches, got %d", counters.PeerSwitchesRejected)
+	}
+	history := session.History()
+	if len(history) != 2 || history[0].Type != HistoryPeerLearned || history[1].Type != HistoryPeerChanged {
+		t.Fatalf("expected peer_learned and one peer_changed, got %+v", history)
+	}
+}
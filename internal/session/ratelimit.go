@@ -0,0 +1,82 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitRefill is how often, at most, the bucket is refilled. Refilling
+// coarsely keeps the common case to a single CAS on the token count.
+const rateLimitRefill = 10 * time.Millisecond
+
+// rateLimitBurst is how much of the budget the bucket holds, enough for a
+// keyframe that the fixer flushes at once.
+const rateLimitBurst = time.Second
+
+// rateLimiter is a token bucket over bytes that bounds what a media sends
+// toward rtpengine. Packets over the budget are dropped, never delayed, so
+// that a misbehaving doorphone cannot make the proxy buffer without bound.
+type rateLimiter struct {
+	bytesPerSec int64
+	burst       int64
+	tokens      atomic.Int64
+	// refilledNsec is when the tokens were last topped up.
+	refilledNsec atomic.Int64
+}
+
+// newRateLimiter returns a limiter of kbps kilobits per second starting with
+// a full bucket at now, or nil when kbps is 0, which disables limiting.
+func newRateLimiter(kbps int, now time.Time) *rateLimiter {
+	if kbps <= 0 {
+		return nil
+	}
+	bytesPerSec := int64(kbps) * 1000 / 8
+	limiter := &rateLimiter{
+		bytesPerSec: bytesPerSec,
+		burst:       bytesPerSec * int64(rateLimitBurst) / int64(time.Second),
+	}
+	limiter.tokens.Store(limiter.burst)
+	limiter.refilledNsec.Store(now.UnixNano())
+	return limiter
+}
+
+// allow takes size bytes from the bucket at now and reports whether the
+// packet fits the budget. A nil limiter allows everything.
+func (l *rateLimiter) allow(size int, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.refill(now)
+	for {
+		tokens := l.tokens.Load()
+		if tokens < int64(size) {
+			return false
+		}
+		if l.tokens.CompareAndSwap(tokens, tokens-int64(size)) {
+			return true
+		}
+	}
+}
+
+// refill adds the budget of the time since the last refill, once at least
+// rateLimitRefill has passed. Only the caller that moves the refill time
+// forward adds tokens.
+func (l *rateLimiter) refill(now time.Time) {
+	nowNsec := now.UnixNano()
+	last := l.refilledNsec.Load()
+	elapsed := nowNsec - last
+	if elapsed < int64(rateLimitRefill) || !l.refilledNsec.CompareAndSwap(last, nowNsec) {
+		return
+	}
+	if elapsed > int64(rateLimitBurst) {
+		elapsed = int64(rateLimitBurst)
+	}
+	add := l.bytesPerSec * elapsed / int64(time.Second)
+	for {
+		tokens := l.tokens.Load()
+		next := min(tokens+add, l.burst)
+		if l.tokens.CompareAndSwap(tokens, next) {
+			return
+		}
+	}
+}
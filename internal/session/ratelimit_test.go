@@ -0,0 +1,78 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter verifies the token bucket against a fake clock. This
+// matters because the bucket decides which packets of a misbehaving
+// doorphone reach rtpengine, and must neither starve a compliant stream nor
+// let a burst through after a long pause. Inputs: an 80 kbps limiter, so
+// 10000 bytes per second and per bucket, fed 1000-byte packets from full, 5ms
+// later, 100ms later and 10s later, and a limiter of 0 kbps. The expected
+// output is ten packets from full, none after 5ms as refills are coarse, one
+// after 100ms, ten again after 10s as the bucket is capped, and no limiter
+// for 0 kbps.
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	limiter := newRateLimiter(80, start)
+	allowed := func(at time.Duration) int {
+		count := 0
+		for limiter.allow(1000, start.Add(at)) {
+			count++
+		}
+		return count
+	}
+
+	if got := allowed(0); got != 10 {
+		t.Fatalf("expected 10 packets from a full bucket, got %d", got)
+	}
+	if got := allowed(5 * time.Millisecond); got != 0 {
+		t.Fatalf("expected no refill after 5ms, got %d packets", got)
+	}
+	if got := allowed(100 * time.Millisecond); got != 1 {
+		t.Fatalf("expected one packet after 100ms, got %d", got)
+	}
+	if got := allowed(10 * time.Second); got != 10 {
+		t.Fatalf("expected the bucket capped at 10 packets, got %d", got)
+	}
+	if disabled := newRateLimiter(0, start); disabled != nil || !disabled.allow(1<<20, start) {
+		t.Fatalf("expected 0 kbps to disable the limiter, got %+v", disabled)
+	}
+}
+
+// TestVideoProxyRateLimit verifies that a fixed video stream over its budget
+// is cut without breaking the outbound sequence. Inputs: a session limited to
+// 8 kbps, so a 1000-byte bucket, and three frames of two 500-byte FU-A
+// packets, with the bucket refilled before the third. The expected output is
+// the first and third frames sent with sequence numbers 1 to 4, and the two
+// packets of the second frame counted as rate limited drops.
+func TestVideoProxyRateLimit(t *testing.T) {
+	session := &Session{ID: "S-ratelimit", Settings: Settings{VideoMaxKbps: 8}}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var seqs []uint16
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		seqs = append(seqs, binary.BigEndian.Uint16(packet[2:4]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	frame := func(seq uint16, ts uint32) {
+		proxy.handleVideoPacket(makeRTPPacket(seq, ts, append([]byte{0x7c, 0x81}, make([]byte, 486)...)), dest)
+		proxy.handleVideoPacket(makeRTPPacket(seq+1, ts, append([]byte{0x7c, 0x41}, make([]byte, 486)...)), dest)
+	}
+
+	frame(1, 3000)
+	frame(3, 6000)
+	proxy.rateLimit.tokens.Store(proxy.rateLimit.burst)
+	frame(5, 9000)
+
+	if want := []uint16{1, 2, 3, 4}; len(seqs) != len(want) || seqs[0] != 1 || seqs[3] != 4 {
+		t.Fatalf("expected sequence numbers %v sent, got %v", want, seqs)
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.DropsRateLimited != 2 || counters.Drops != 2 {
+		t.Fatalf("expected 2 rate limited drops, got %d of %d", counters.DropsRateLimited, counters.Drops)
+	}
+}
@@ -0,0 +1,15 @@
+package session
+
+import "net"
+
+// readBatchSize is how many datagrams a batched read takes from a socket per
+// system call.
+const readBatchSize = 32
+
+// packetReader reads the datagrams of a media socket one at a time, like
+// ReadFromUDP, whether or not they come off the socket in batches. The read
+// deadline of the socket applies, and a datagram longer than the buffer is
+// truncated to it.
+type packetReader interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+}
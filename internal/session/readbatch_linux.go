@@ -0,0 +1,45 @@
+//go:build linux
+
+package session
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// batchReader takes up to readBatchSize datagrams per recvmmsg call and
+// hands them out one by one with their own source address.
+type batchReader struct {
+	conn     *ipv4.PacketConn
+	messages []ipv4.Message
+	// next and count delimit the datagrams of the last batch not yet read.
+	next, count int
+}
+
+// newPacketReader returns a batched reader of conn taking datagrams of up to
+// size bytes.
+func newPacketReader(conn *net.UDPConn, size int) packetReader {
+	messages := make([]ipv4.Message, readBatchSize)
+	for i := range messages {
+		messages[i].Buffers = [][]byte{make([]byte, size)}
+	}
+	return &batchReader{conn: ipv4.NewPacketConn(conn), messages: messages}
+}
+
+// ReadFromUDP copies the next datagram into b, reading a new batch once the
+// last one is used up. A datagram longer than b is truncated, as with a
+// single read.
+func (r *batchReader) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	if r.next == r.count {
+		count, err := r.conn.ReadBatch(r.messages, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+		r.next, r.count = 0, count
+	}
+	message := &r.messages[r.next]
+	r.next++
+	addr, _ := message.Addr.(*net.UDPAddr)
+	return copy(b, message.Buffers[0][:message.N]), addr, nil
+}
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// TestBatchReader verifies that batched reads behave like ReadFromUDP. This
+// matters because the proxies learn peers and check the rtpengine source per
+// packet, whatever batch the packet came in. Inputs: a dual-stack socket on
+// the wildcard address, as the media sockets are bound, receiving 40
+// datagrams alternately from two loopback senders, then an expired deadline
+// and a closed socket. The expected output is every datagram in order with
+// the address of its sender, then a timeout error and net.ErrClosed.
+func TestBatchReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("listen udp failed: %v", err)
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: localUDPAddr(conn).Port}
+	senders := []*net.UDPConn{mustListenUDP(t), mustListenUDP(t)}
+	for _, sender := range senders {
+		defer sender.Close()
+	}
+	const count = readBatchSize + 8
+	for i := 0; i < count; i++ {
+		if _, err := senders[i%2].WriteToUDP([]byte{byte(i), 0xaa}, dest); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+
+	buffer := make([]byte, DefaultMaxPacketSize+1)
+	reader := newPacketReader(conn, len(buffer))
+	for i := 0; i < count; i++ {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := reader.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("read %d failed: %v", i, err)
+		}
+		from := localUDPAddr(senders[i%2])
+		if n != 2 || buffer[0] != byte(i) || addr.Port != from.Port || !addr.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Fatalf("expected datagram %d from %s, got %x from %s", i, from, buffer[:n], addr)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	var netErr net.Error
+	if _, _, err := reader.ReadFromUDP(buffer); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	_ = conn.Close()
+	if _, _, err := reader.ReadFromUDP(buffer); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+// BenchmarkPacketRead compares the packets per second of single reads with
+// batched reads on a socket kept full by a loopback sender writing batches.
+func BenchmarkPacketRead(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		newReader func(*net.UDPConn, int) packetReader
+	}{
+		{"single", func(conn *net.UDPConn, _ int) packetReader { return conn }},
+		{"batch", newPacketReader},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatalf("listen udp failed: %v", err)
+			}
+			defer conn.Close()
+			sender, err := net.DialUDP("udp", nil, localUDPAddr(conn))
+			if err != nil {
+				b.Fatalf("dial udp failed: %v", err)
+			}
+			defer sender.Close()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				messages := make([]ipv4.Message, readBatchSize)
+				for i := range messages {
+					messages[i].Buffers = [][]byte{make([]byte, 172)}
+				}
+				batch := ipv4.NewPacketConn(sender)
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					_, _ = batch.WriteBatch(messages, 0)
+				}
+			}()
+
+			buffer := make([]byte, DefaultMaxPacketSize+1)
+			reader := bench.newReader(conn, len(buffer))
+			start := time.Now()
+			for b.Loop() {
+				if _, _, err := reader.ReadFromUDP(buffer); err != nil {
+					b.Fatalf("read failed: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "pkts/s")
+		})
+	}
+}
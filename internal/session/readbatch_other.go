@@ -0,0 +1,11 @@
+//go:build !linux
+
+package session
+
+import "net"
+
+// newPacketReader returns conn itself, which reads into the caller's buffer:
+// batched reads are implemented on Linux only.
+func newPacketReader(conn *net.UDPConn, _ int) packetReader {
+	return conn
+}
@@ -0,0 +1,212 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rtcpCounters count the RTCP of one media apart from its RTP. RTCP is
+// relayed as is, so only what came in and what was dropped is tracked.
+// muxPkts counts the RTCP multiplexed on the RTP ports instead, which the RTP
+// counters include.
+type rtcpCounters struct {
+	aInPkts  atomic.Uint64
+	aInBytes atomic.Uint64
+	bInPkts  atomic.Uint64
+	bInBytes atomic.Uint64
+	drops    atomic.Uint64
+	muxPkts  atomic.Uint64
+}
+
+type RTCPCounters struct {
+	AInPkts  uint64
+	AInBytes uint64
+	BInPkts  uint64
+	BInBytes uint64
+	Drops    uint64
+	MuxPkts  uint64
+}
+
+func (c *rtcpCounters) snapshot() RTCPCounters {
+	return RTCPCounters{
+		AInPkts:  c.aInPkts.Load(),
+		AInBytes: c.aInBytes.Load(),
+		BInPkts:  c.bInPkts.Load(),
+		BInBytes: c.bInBytes.Load(),
+		Drops:    c.drops.Load(),
+		MuxPkts:  c.muxPkts.Load(),
+	}
+}
+
+// rtcpProxy relays the RTCP of one media between the odd ports next to its
+// RTP legs. The doorphone's RTCP source is learned on leg A the way the RTP
+// peer is, and rtpengine is reached on the port after its RTP destination.
+// RTCP does not count as media activity, so it neither keeps a session alive
+// nor is captured.
+type rtcpProxy struct {
+	media              string
+	session            *Session
+	aConn              *net.UDPConn
+	bConn              *net.UDPConn
+	dest               *atomic.Pointer[net.UDPAddr]
+	enabled            *atomic.Bool
+	counters           *rtcpCounters
+	peerLearningWindow time.Duration
+	logger             *slog.Logger
+	ctx                context.Context
+	cancel             context.CancelFunc
+	wg                 sync.WaitGroup
+	peerMu             sync.Mutex
+	peer               *net.UDPAddr
+	peerLearnedAt      time.Time
+	peerLastSeen       time.Time
+}
+
+func newRTCPProxy(session *Session, media string, aConn, bConn *net.UDPConn, peerLearningWindow time.Duration) *rtcpProxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &rtcpProxy{
+		media:              media,
+		session:            session,
+		aConn:              aConn,
+		bConn:              bConn,
+		peerLearningWindow: peerLearningWindow,
+		logger:             session.Logger(),
+		ctx:                ctx,
+		cancel:             cancel,
+	}
+	if media == "video" {
+		p.dest, p.enabled, p.counters = &session.videoDest, &session.videoEnabled, &session.videoCounters.rtcp
+	} else {
+		p.dest, p.enabled, p.counters = &session.audioDest, &session.audioEnabled, &session.audioCounters.rtcp
+	}
+	return p
+}
+
+func (p *rtcpProxy) start() {
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		p.loop(p.aConn, p.forwardToB)
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.loop(p.bConn, p.forwardToA)
+	}()
+}
+
+func (p *rtcpProxy) stop() {
+	p.cancel()
+	_ = p.aConn.SetReadDeadline(time.Now())
+	_ = p.bConn.SetReadDeadline(time.Now())
+	p.wg.Wait()
+	_ = p.aConn.Close()
+	_ = p.bConn.Close()
+}
+
+func (p *rtcpProxy) loop(conn *net.UDPConn, forward func(packet []byte, addr *net.UDPAddr)) {
+	buffer := make([]byte, p.session.readBufferSize())
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			p.logger.Error(p.media+" rtcp read failed", "error", err)
+			continue
+		}
+		// A truncated compound packet would be relayed with a broken length.
+		if n == len(buffer) {
+			p.counters.drops.Add(1)
+			continue
+		}
+		forward(buffer[:n], addr)
+	}
+}
+
+// forwardToB relays doorphone RTCP to rtpengine.
+func (p *rtcpProxy) forwardToB(packet []byte, addr *net.UDPAddr) {
+	p.counters.aInPkts.Add(1)
+	p.counters.aInBytes.Add(uint64(len(packet)))
+	if !p.enabled.Load() || !p.updatePeer(addr) {
+		p.counters.drops.Add(1)
+		return
+	}
+	dest := p.dest.Load()
+	if dest == nil || dest.Port == 0 {
+		p.counters.drops.Add(1)
+		return
+	}
+	if _, err := p.bConn.WriteToUDP(packet, &net.UDPAddr{IP: dest.IP, Port: dest.Port + 1, Zone: dest.Zone}); err != nil {
+		p.logger.Error(p.media+" rtcp b leg write failed", "error", err)
+		p.counters.drops.Add(1)
+	}
+}
+
+// forwardToA relays rtpengine RTCP to the doorphone. Like RTP, it must come
+// from the rtpengine destination address, on the port after the RTP one.
+func (p *rtcpProxy) forwardToA(packet []byte, addr *net.UDPAddr) {
+	dest := p.dest.Load()
+	if !p.enabled.Load() || dest == nil || !p.session.fromRTPEngine(addr, dest.IP, dest.Port+1) {
+		p.counters.drops.Add(1)
+		return
+	}
+	p.counters.bInPkts.Add(1)
+	p.counters.bInBytes.Add(uint64(len(packet)))
+	p.peerMu.Lock()
+	peer := cloneUDPAddr(p.peer)
+	p.peerMu.Unlock()
+	if peer == nil {
+		p.counters.drops.Add(1)
+		return
+	}
+	if _, err := p.aConn.WriteToUDP(packet, peer); err != nil {
+		p.logger.Error(p.media+" rtcp a leg write failed", "error", err)
+		p.counters.drops.Add(1)
+	}
+}
+
+// updatePeer learns the doorphone RTCP source from its first packet and
+// follows it by the same rules as the RTP peer.
+func (p *rtcpProxy) updatePeer(addr *net.UDPAddr) bool {
+	p.peerMu.Lock()
+	defer p.peerMu.Unlock()
+	now := time.Now()
+	if source := p.session.pinnedSource(p.media); source != nil {
+		// RTCP comes from another port than the pinned RTP source, so only
+		// the IP is compared.
+		if !source.IP.Equal(addr.IP) {
+			return false
+		}
+		if p.peer == nil {
+			p.peerLearnedAt = now
+		}
+		p.peer = cloneUDPAddr(addr)
+		p.peerLastSeen = now
+		return true
+	}
+	switch decidePeer(p.peer, addr, p.peerLearnedAt, p.peerLastSeen, now, p.peerLearningWindow, p.session.relearnAfter) {
+	case peerReject:
+		return false
+	case peerLearn:
+		p.peerLearnedAt = now
+		p.peer = cloneUDPAddr(addr)
+	case peerChange, peerRelearn:
+		p.peer = cloneUDPAddr(addr)
+	}
+	p.peerLastSeen = now
+	return true
+}
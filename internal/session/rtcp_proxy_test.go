@@ -0,0 +1,80 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestRTCPProxy_RelaysBothWays verifies that RTCP is relayed between the
+// doorphone and the port after the rtpengine RTP destination, that replies
+// reach the learned doorphone RTCP source, and that a second source is
+// rejected once the learning window has passed. Inputs: a minimal sender
+// report from the doorphone, a receiver report from rtpengine and a report
+// from another doorphone socket with a zero learning window. The expected
+// output is each report delivered unchanged, counters of one packet per
+// direction and one drop, and no media activity on the session.
+func TestRTCPProxy_RelaysBothWays(t *testing.T) {
+	session := &Session{ID: "S-rtcp"}
+	session.audioEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	rtpEngineAddr := localUDPAddr(rtpEngineConn)
+	session.audioDest.Store(&net.UDPAddr{IP: rtpEngineAddr.IP, Port: rtpEngineAddr.Port - 1})
+
+	proxy := newRTCPProxy(session, "audio", aConn, bConn, 0)
+	proxy.start()
+	defer proxy.stop()
+
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	senderReport := []byte{0x80, 200, 0x00, 0x06, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	receiverReport := []byte{0x80, 201, 0x00, 0x01, 0x05, 0x06, 0x07, 0x08}
+
+	if _, err := doorphoneConn.WriteToUDP(senderReport, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	readRTCP(t, rtpEngineConn, senderReport)
+	if _, err := rtpEngineConn.WriteToUDP(receiverReport, localUDPAddr(bConn)); err != nil {
+		t.Fatalf("send to b-leg failed: %v", err)
+	}
+	readRTCP(t, doorphoneConn, receiverReport)
+
+	otherConn := mustListenUDP(t)
+	defer otherConn.Close()
+	if _, err := otherConn.WriteToUDP(senderReport, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for session.audioCounters.rtcp.drops.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second source to be dropped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	counters := snapshotAudioCounters(&session.audioCounters)
+	want := RTCPCounters{AInPkts: 2, AInBytes: 2 * uint64(len(senderReport)), BInPkts: 1, BInBytes: uint64(len(receiverReport)), Drops: 1}
+	if counters.RTCP != want {
+		t.Fatalf("unexpected rtcp counters: got=%+v want=%+v", counters.RTCP, want)
+	}
+	if counters.AInPkts != 0 || !session.LastActivityTime().IsZero() {
+		t.Fatalf("expected rtcp to leave the rtp counters and activity alone, got %+v", counters)
+	}
+}
+
+func readRTCP(t *testing.T, conn *net.UDPConn, want []byte) {
+	t.Helper()
+	buffer := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("read rtcp failed: %v", err)
+	}
+	if !bytes.Equal(buffer[:n], want) {
+		t.Fatalf("rtcp mismatch: got=%v want=%v", buffer[:n], want)
+	}
+}
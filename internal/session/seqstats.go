@@ -0,0 +1,107 @@
+package session
+
+import (
+	"sync/atomic"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// seqCounters count sequence anomalies of the RTP received on one leg. A
+// packet that arrives late was counted as a gap when the packets after it
+// arrived, so gaps minus reordered approximates the packets really lost.
+type seqCounters struct {
+	gaps       atomic.Uint64
+	reordered  atomic.Uint64
+	duplicates atomic.Uint64
+}
+
+type SeqCounters struct {
+	Gaps       uint64
+	Reordered  uint64
+	Duplicates uint64
+}
+
+func (c *seqCounters) snapshot() SeqCounters {
+	return SeqCounters{
+		Gaps:       c.gaps.Load(),
+		Reordered:  c.reordered.Load(),
+		Duplicates: c.duplicates.Load(),
+	}
+}
+
+const (
+	// seqWindow is how far behind the highest sequence number a packet can
+	// still be told apart as reordered or duplicated.
+	seqWindow = 64
+	// seqMaxDropout is the largest forward jump counted as loss; a larger
+	// one, or a backward jump beyond seqWindow, is taken as a sender restart
+	// (RFC 3550 A.1).
+	seqMaxDropout = 3000
+	// seqMaxStreams bounds the SSRCs tracked at once. A sender cycling
+	// through more SSRCs starts over rather than growing the map.
+	seqMaxStreams = 8
+)
+
+// seqTracker follows the sequence numbers of each SSRC received on one leg.
+// It is only used from that leg's read loop.
+type seqTracker struct {
+	counters *seqCounters
+	streams  map[uint32]*seqStream
+}
+
+type seqStream struct {
+	highest uint16
+	// seen has bit i set when highest-i was received.
+	seen uint64
+}
+
+func newSeqTracker(counters *seqCounters) *seqTracker {
+	return &seqTracker{counters: counters, streams: make(map[uint32]*seqStream)}
+}
+
+// track counts the sequence anomaly of packet, if any. Packets that do not
+// parse as RTP are ignored.
+func (t *seqTracker) track(packet []byte) {
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok {
+		return
+	}
+	t.observe(header.SSRC, header.Seq)
+}
+
+func (t *seqTracker) observe(ssrc uint32, seq uint16) {
+	stream, ok := t.streams[ssrc]
+	if !ok {
+		if len(t.streams) >= seqMaxStreams {
+			clear(t.streams)
+		}
+		t.streams[ssrc] = &seqStream{highest: seq, seen: 1}
+		return
+	}
+	delta := rtpfix.SeqDiff(seq, stream.highest)
+	switch {
+	case delta > 0 && delta <= seqMaxDropout:
+		if delta > 1 {
+			t.counters.gaps.Add(uint64(delta - 1))
+		}
+		if delta >= seqWindow {
+			stream.seen = 0
+		} else {
+			stream.seen <<= uint(delta)
+		}
+		stream.seen |= 1
+		stream.highest = seq
+	case delta == 0:
+		t.counters.duplicates.Add(1)
+	case delta < 0 && delta > -seqWindow:
+		bit := uint64(1) << uint(-delta)
+		if stream.seen&bit != 0 {
+			t.counters.duplicates.Add(1)
+			return
+		}
+		stream.seen |= bit
+		t.counters.reordered.Add(1)
+	default:
+		*stream = seqStream{highest: seq, seen: 1}
+	}
+}
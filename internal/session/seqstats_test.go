@@ -0,0 +1,61 @@
+package session
+
+import "testing"
+
+// TestSeqTracker_Counts verifies the sequence anomaly counting of one leg.
+// This matters because these counters are how operators tell network loss
+// and reordering apart from a misbehaving doorphone. Each case feeds crafted
+// sequence numbers for one SSRC and checks the resulting gaps, reordered and
+// duplicate counts. Edge cases: the wrap from 65535 to 0, a late packet
+// already counted as a gap, a duplicate of a late packet, a second SSRC with
+// its own numbering and a sender restart that jumps far ahead.
+func TestSeqTracker_Counts(t *testing.T) {
+	tests := []struct {
+		name string
+		ssrc []uint32
+		seqs []uint16
+		want SeqCounters
+	}{
+		{name: "in order", seqs: []uint16{10, 11, 12, 13}},
+		{name: "gap", seqs: []uint16{10, 11, 14, 15}, want: SeqCounters{Gaps: 2}},
+		{name: "swap", seqs: []uint16{10, 12, 11, 13}, want: SeqCounters{Gaps: 1, Reordered: 1}},
+		{name: "duplicate", seqs: []uint16{10, 11, 11, 12}, want: SeqCounters{Duplicates: 1}},
+		{name: "duplicate of late packet", seqs: []uint16{10, 12, 11, 11, 13}, want: SeqCounters{Gaps: 1, Reordered: 1, Duplicates: 1}},
+		{name: "wrap", seqs: []uint16{65534, 65535, 0, 1}},
+		{name: "gap across wrap", seqs: []uint16{65534, 1}, want: SeqCounters{Gaps: 2}},
+		{name: "swap across wrap", seqs: []uint16{65535, 1, 0}, want: SeqCounters{Gaps: 1, Reordered: 1}},
+		{name: "sender restart", seqs: []uint16{10, 11, 30000, 30001}},
+		{name: "ssrcs tracked apart", ssrc: []uint32{1, 2, 1, 2}, seqs: []uint16{10, 500, 11, 501}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counters seqCounters
+			tracker := newSeqTracker(&counters)
+			for i, seq := range tt.seqs {
+				ssrc := uint32(1)
+				if tt.ssrc != nil {
+					ssrc = tt.ssrc[i]
+				}
+				tracker.observe(ssrc, seq)
+			}
+			if got := counters.snapshot(); got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSeqTracker_TrackParsesRTP verifies that track reads the SSRC and
+// sequence number from the RTP header and ignores what does not parse.
+// Inputs: two RTP packets with a one-packet gap and a truncated packet. The
+// expected output is one gap.
+func TestSeqTracker_TrackParsesRTP(t *testing.T) {
+	var counters seqCounters
+	tracker := newSeqTracker(&counters)
+	tracker.track(makeRTPPacket(1, 9000, []byte{1}))
+	tracker.track([]byte{0x80, 96})
+	tracker.track(makeRTPPacket(3, 9000, []byte{1}))
+	if got := counters.snapshot(); got != (SeqCounters{Gaps: 1}) {
+		t.Fatalf("got %+v, want one gap", got)
+	}
+}
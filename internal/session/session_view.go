@@ -1,31 +1,99 @@
 package session
 
-import "time"
+import (
+	"net"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
 
 func (s *Session) AudioState() Media {
 	if s == nil {
 		return Media{}
 	}
-	return Media{
-		APort:          s.Audio.APort,
-		BPort:          s.Audio.BPort,
-		RTPEngineDest:  cloneUDPAddr(s.audioDest.Load()),
-		Enabled:        s.audioEnabled.Load(),
-		DisabledReason: loadAtomicString(&s.audioDisabledReason),
+	peer := proxyDoorphonePeer(s.audioProxy)
+	media := Media{
+		APort:                 s.Audio.APort,
+		BPort:                 s.Audio.BPort,
+		RTPEngineDest:         cloneUDPAddr(s.audioDest.Load()),
+		RTPEngineDestHost:     loadAtomicString(&s.audioDestHost),
+		Enabled:               s.audioEnabled.Load(),
+		DisabledReason:        loadAtomicString(&s.audioDisabledReason),
+		PeerLearningWindow:    s.Audio.PeerLearningWindow,
+		PeerLearningRemaining: peerLearningRemaining(peer, s.Audio.PeerLearningWindow, time.Now()),
 	}
+	applyDoorphonePeer(&media, peer)
+	applyMediaIdle(&media, &s.audioActivity)
+	media.LockedSSRC, media.SSRCLocked = s.audioSSRCLock.get()
+	media.OutputSSRC, media.RewriteSSRC = s.audioSSRCRewrite.outputSSRC()
+	applyPinnedSource(&media, s.audioSource.Load(), peer)
+	return media
 }
 
 func (s *Session) VideoState() Media {
 	if s == nil {
 		return Media{}
 	}
-	return Media{
-		APort:          s.Video.APort,
-		BPort:          s.Video.BPort,
-		RTPEngineDest:  cloneUDPAddr(s.videoDest.Load()),
-		Enabled:        s.videoEnabled.Load(),
-		DisabledReason: loadAtomicString(&s.videoDisabledReason),
+	peer := proxyDoorphonePeer(s.videoProxy)
+	media := Media{
+		APort:                 s.Video.APort,
+		BPort:                 s.Video.BPort,
+		RTPEngineDest:         cloneUDPAddr(s.videoDest.Load()),
+		RTPEngineDestHost:     loadAtomicString(&s.videoDestHost),
+		Enabled:               s.videoEnabled.Load(),
+		DisabledReason:        loadAtomicString(&s.videoDisabledReason),
+		PeerLearningWindow:    s.Video.PeerLearningWindow,
+		PeerLearningRemaining: peerLearningRemaining(peer, s.Video.PeerLearningWindow, time.Now()),
+	}
+	applyDoorphonePeer(&media, peer)
+	applyMediaIdle(&media, &s.videoActivity)
+	media.LockedSSRC, media.SSRCLocked = s.videoSSRCLock.get()
+	media.OutputSSRC, media.RewriteSSRC = s.videoSSRCRewrite.outputSSRC()
+	applyPinnedSource(&media, s.videoSource.Load(), peer)
+	return media
+}
+
+func proxyDoorphonePeer(proxy sessionProxy) doorphonePeerState {
+	learner, ok := proxy.(peerLearner)
+	if !ok {
+		return doorphonePeerState{}
+	}
+	return learner.doorphonePeerState()
+}
+
+func applyDoorphonePeer(media *Media, state doorphonePeerState) {
+	if state.peer == nil {
+		return
+	}
+	media.DoorphonePeer = state.peer
+	media.DoorphonePeerLearnedAt = state.learnedAt
+	if !state.relearnedAt.IsZero() {
+		media.DoorphonePeerLearnedAt = state.relearnedAt
+		media.DoorphonePeerRelearned = true
+	}
+}
+
+// applyPinnedSource reports the pinned source and whether the last A-leg
+// sender matched it.
+func applyPinnedSource(media *Media, source *net.UDPAddr, state doorphonePeerState) {
+	if source == nil {
+		return
+	}
+	media.Source = cloneUDPAddr(source)
+	media.SourceMatches = state.lastSender != nil && sourceMatches(source, state.lastSender)
+}
+
+// peerLearningRemaining reports how long the doorphone peer may still move.
+// Before the first packet the whole window is still available.
+func peerLearningRemaining(state doorphonePeerState, window time.Duration, now time.Time) time.Duration {
+	if state.peer == nil {
+		return window
+	}
+	remaining := window - now.Sub(state.learnedAt)
+	if remaining < 0 {
+		return 0
 	}
+	return remaining
 }
 
 func (s *Session) AudioCountersSnapshot() AudioCounters {
@@ -42,6 +110,43 @@ func (s *Session) VideoCountersSnapshot() VideoCounters {
 	return snapshotVideoCounters(&s.videoCounters)
 }
 
+// VideoBufferState reports the video frame buffer and parameter set state.
+func (s *Session) VideoBufferState() VideoBufferState {
+	if s == nil {
+		return VideoBufferState{}
+	}
+	reporter, ok := s.videoProxy.(frameBufferReporter)
+	if !ok {
+		return VideoBufferState{}
+	}
+	return reporter.frameBufferState(time.Now())
+}
+
+// VideoSPS reports the picture size, profile and level of the last H264 SPS
+// cached by the video fixer; false until one has been parsed.
+func (s *Session) VideoSPS() (rtpfix.SPSInfo, bool) {
+	if s == nil {
+		return rtpfix.SPSInfo{}, false
+	}
+	sps := s.videoSPS.Load()
+	if sps == nil {
+		return rtpfix.SPSInfo{}, false
+	}
+	return *sps, true
+}
+
+// VideoFixBypass reports whether the video proxy has fallen back from fix mode
+// to raw forwarding, together with the reason that triggered it.
+func (s *Session) VideoFixBypass() (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+	if !s.videoFixBypassed.Load() {
+		return false, ""
+	}
+	return true, loadAtomicString(&s.videoFixBypassReason)
+}
+
 func (s *Session) LastActivityTime() time.Time {
 	if s == nil {
 		return time.Time{}
@@ -0,0 +1,159 @@
+package session
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SocketBuffers are the kernel buffer sizes in bytes requested for every media
+// socket, so that the bursts of an IDR frame fit. Zero keeps the system
+// default.
+type SocketBuffers struct {
+	Read  int
+	Write int
+}
+
+// SetSocketBuffers sets the buffer sizes of every media socket. It must be
+// called before sessions are created.
+func (m *Manager) SetSocketBuffers(buffers SocketBuffers) {
+	m.socketBuffers = buffers
+}
+
+// sizeSocket applies buffers to conn and returns the sizes the kernel
+// granted, which may differ from those requested. Effective sizes are zero
+// where they cannot be read.
+func sizeSocket(conn *net.UDPConn, buffers SocketBuffers) (SocketBuffers, error) {
+	if conn == nil || buffers == (SocketBuffers{}) {
+		return SocketBuffers{}, nil
+	}
+	if buffers.Read != 0 {
+		if err := conn.SetReadBuffer(buffers.Read); err != nil {
+			return SocketBuffers{}, err
+		}
+	}
+	if buffers.Write != 0 {
+		if err := conn.SetWriteBuffer(buffers.Write); err != nil {
+			return SocketBuffers{}, err
+		}
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return SocketBuffers{}, nil
+	}
+	var effective SocketBuffers
+	_ = raw.Control(func(fd uintptr) {
+		effective, _ = socketBufferSizes(fd)
+	})
+	return effective, nil
+}
+
+// connInode returns the inode of conn in /proc/net/udp, or 0 where there is
+// none.
+func connInode(conn *net.UDPConn) uint64 {
+	if conn == nil {
+		return 0
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var inode uint64
+	_ = raw.Control(func(fd uintptr) {
+		inode, _ = socketInode(fd)
+	})
+	return inode
+}
+
+// kernelDropsInterval is how often the kernel receive drops of the media
+// sockets are read.
+const kernelDropsInterval = 10 * time.Second
+
+// procNetUDPFiles list the UDP sockets of the network namespace with their
+// receive drops. Media sockets bound to the wildcard address are IPv6 sockets
+// and show in udp6.
+var procNetUDPFiles = []string{"/proc/net/udp", "/proc/net/udp6"}
+
+// KernelRcvDrops estimates the datagrams the kernel dropped because the
+// receive buffer of a media socket of the session was full, as of the last
+// read of /proc/net/udp. It stays 0 outside of Linux.
+func (s *Session) KernelRcvDrops() uint64 {
+	return s.kernelRcvDrops.Load()
+}
+
+// StartKernelDropCheck reads the receive drops of the media sockets from
+// /proc/net/udp every kernelDropsInterval, best effort. It does nothing where
+// the file does not exist. The loop stops on Close.
+func (m *Manager) StartKernelDropCheck() {
+	if _, err := os.Stat(procNetUDPFiles[0]); err != nil {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(kernelDropsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.updateKernelDrops(readKernelDrops(procNetUDPFiles))
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// updateKernelDrops sums the drops of the sockets of each live session.
+func (m *Manager) updateKernelDrops(drops map[uint64]uint64) {
+	for _, session := range m.List() {
+		var total uint64
+		for _, inode := range session.socketInodes {
+			total += drops[inode]
+		}
+		session.kernelRcvDrops.Store(total)
+	}
+}
+
+// readKernelDrops returns the drops of every socket in files keyed by inode.
+// Files that cannot be read are skipped.
+func readKernelDrops(files []string) map[uint64]uint64 {
+	drops := make(map[uint64]uint64)
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		parseProcNetUDP(file, drops)
+		_ = file.Close()
+	}
+	return drops
+}
+
+// parseProcNetUDP adds the drops column of a /proc/net/udp or udp6 table to
+// drops, keyed by the inode column. Lines that do not parse are skipped.
+func parseProcNetUDP(r io.Reader, drops map[uint64]uint64) {
+	scanner := bufio.NewScanner(r)
+	// The first line is the header:
+	// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+		count, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			continue
+		}
+		drops[inode] += count
+	}
+}
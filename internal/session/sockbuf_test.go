@@ -0,0 +1,72 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseProcNetUDP verifies that the drops of each socket are read from
+// /proc/net/udp and udp6 by inode. This matters because kernel_rcv_drops is
+// the only sign that bursts overflow the receive buffer before the proxy reads
+// them. Inputs: an IPv4 table and an IPv6 table with their headers, a line
+// without a drops column and a line with inode 0. The expected output is the
+// drops of the three well-formed sockets and nothing for the others.
+func TestParseProcNetUDP(t *testing.T) {
+	udp := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  105: 00000000:36B0 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41201 2 0000000000000000 17
+  106: 00000000:36B1 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41202 2 0000000000000000 0
+  107: 00000000:36B2 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41203 2
+  108: 00000000:36B3 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 0 2 0000000000000000 9
+`
+	udp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  200: 00000000000000000000000000000000:36B4 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41204 2 0000000000000000 3
+`
+	drops := make(map[uint64]uint64)
+	parseProcNetUDP(strings.NewReader(udp), drops)
+	parseProcNetUDP(strings.NewReader(udp6), drops)
+
+	want := map[uint64]uint64{41201: 17, 41202: 0, 41204: 3}
+	if len(drops) != len(want) {
+		t.Fatalf("expected drops %v, got %v", want, drops)
+	}
+	for inode, count := range want {
+		if got, ok := drops[inode]; !ok || got != count {
+			t.Fatalf("expected %d drops for inode %d, got %d (present %v)", count, inode, got, ok)
+		}
+	}
+}
+
+// TestManagerUpdateKernelDrops verifies that the drops of a session's sockets
+// are summed into its kernel_rcv_drops. Inputs: two sessions owning two
+// sockets each and a drops table that also lists a socket of neither. The
+// expected output is the sum over each session's own sockets, replaced rather
+// than added to on the next read.
+func TestManagerUpdateKernelDrops(t *testing.T) {
+	manager := newTestManager(t, 0)
+	first, err := manager.Create("call-drops-1", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	second, err := manager.Create("call-drops-2", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	first.socketInodes = []uint64{11, 12}
+	second.socketInodes = []uint64{21, 22}
+
+	manager.updateKernelDrops(map[uint64]uint64{11: 5, 12: 7, 21: 1, 99: 100})
+	if got := first.KernelRcvDrops(); got != 12 {
+		t.Fatalf("expected 12 drops for the first session, got %d", got)
+	}
+	if got := second.KernelRcvDrops(); got != 1 {
+		t.Fatalf("expected 1 drop for the second session, got %d", got)
+	}
+
+	manager.updateKernelDrops(map[uint64]uint64{11: 6, 12: 7, 21: 1, 22: 2})
+	if got := first.KernelRcvDrops(); got != 13 {
+		t.Fatalf("expected 13 drops for the first session, got %d", got)
+	}
+	if got := second.KernelRcvDrops(); got != 3 {
+		t.Fatalf("expected 3 drops for the second session, got %d", got)
+	}
+}
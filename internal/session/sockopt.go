@@ -0,0 +1,34 @@
+package session
+
+import "net"
+
+// Bounds of the socket marking a session accepts.
+const (
+	MaxDSCP = 63
+	MaxTTL  = 255
+)
+
+// SocketMarking is what is set on the media sockets of a session so that the
+// network prioritises relayed media. Zero keeps the system default.
+type SocketMarking struct {
+	DSCP int
+	TTL  int
+}
+
+// markSocket applies marking to conn; a zero marking leaves it untouched.
+func markSocket(conn *net.UDPConn, marking SocketMarking) error {
+	if conn == nil || marking == (SocketMarking{}) {
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var markErr error
+	if err := raw.Control(func(fd uintptr) {
+		markErr = setSocketMarking(fd, marking)
+	}); err != nil {
+		return err
+	}
+	return markErr
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+func setSocketMarking(fd uintptr, marking SocketMarking) error {
+	if marking.DSCP != 0 {
+		if err := setIPOption(fd, syscall.IP_TOS, syscall.IPV6_TCLASS, marking.DSCP<<2); err != nil {
+			return fmt.Errorf("set dscp: %w", err)
+		}
+	}
+	if marking.TTL != 0 {
+		if err := setIPOption(fd, syscall.IP_TTL, syscall.IPV6_UNICAST_HOPS, marking.TTL); err != nil {
+			return fmt.Errorf("set ttl: %w", err)
+		}
+	}
+	return nil
+}
+
+// setIPOption sets an IPv4 option and its IPv6 counterpart. IPv6 sockets
+// need both as they carry IPv4 through mapped addresses; IPv4 sockets reject
+// the IPv6 one with ENOPROTOOPT.
+func setIPOption(fd uintptr, v4, v6, value int) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, v4, value); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, v6, value); err != nil && !errors.Is(err, syscall.ENOPROTOOPT) {
+		return err
+	}
+	return nil
+}
+
+// socketBufferSizes returns the SO_RCVBUF and SO_SNDBUF of fd, which Linux
+// reports doubled for its own bookkeeping and clamps to net.core.rmem_max and
+// wmem_max.
+func socketBufferSizes(fd uintptr) (SocketBuffers, error) {
+	read, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	if err != nil {
+		return SocketBuffers{}, err
+	}
+	write, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	if err != nil {
+		return SocketBuffers{}, err
+	}
+	return SocketBuffers{Read: read, Write: write}, nil
+}
+
+// socketInode returns the inode that identifies fd in /proc/net/udp.
+func socketInode(fd uintptr) (uint64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(fd), &stat); err != nil {
+		return 0, err
+	}
+	return stat.Ino, nil
+}
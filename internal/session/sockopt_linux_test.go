@@ -0,0 +1,132 @@
+package session
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestManagerMarksSockets verifies that the DSCP and TTL reach every media
+// socket of a session and that a socket that cannot be marked does not fail
+// the create. This matters because networks prioritise EF-marked media, while
+// some containers forbid the socket options. Inputs: a manager marking with
+// DSCP 46, binding real loopback sockets through its listenUDP, a create
+// overriding the TTL with 32, then a create on a manager whose marking fails.
+// The expected output is IP_TOS 184 and IP_TTL 32 on all eight sockets and the
+// marking reported in Settings, then a created session despite the failure.
+func TestManagerMarksSockets(t *testing.T) {
+	manager := newTestManager(t, 0)
+	var conns []*net.UDPConn
+	manager.listenUDP = func(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	}
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	manager.SetSocketMarking(SocketMarking{DSCP: 46})
+	ttl := 32
+
+	session, err := manager.CreateWithOptions("call-mark", "from", "to", false, CreateOptions{TTL: &ttl})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if want := (SocketMarking{DSCP: 46, TTL: 32}); session.Settings.Marking != want {
+		t.Fatalf("expected marking %+v, got %+v", want, session.Settings.Marking)
+	}
+	if len(conns) != 8 {
+		t.Fatalf("expected 8 sockets, got %d", len(conns))
+	}
+	for _, conn := range conns {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			t.Fatalf("unexpected syscall conn error: %v", err)
+		}
+		var tos, ttl int
+		var tosErr, ttlErr error
+		_ = raw.Control(func(fd uintptr) {
+			tos, tosErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+			ttl, ttlErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL)
+		})
+		if tosErr != nil || ttlErr != nil || tos != 46<<2 || ttl != 32 {
+			t.Fatalf("expected tos 184 and ttl 32 on %s, got %d (%v) and %d (%v)", conn.LocalAddr(), tos, tosErr, ttl, ttlErr)
+		}
+	}
+
+	manager.markSocket = func(*net.UDPConn, SocketMarking) error { return syscall.EPERM }
+	if _, err := manager.CreateWithOptions("call-unmarked", "from", "to", false, CreateOptions{}); err != nil {
+		t.Fatalf("expected the create to survive a marking failure, got %v", err)
+	}
+}
+
+// TestManagerSizesSockets verifies that the buffer sizes reach every media
+// socket and that drops on a full receive buffer show in kernel_rcv_drops.
+// This matters because an IDR frame arrives as a burst that overflows the
+// default buffer of a busy host. Inputs: a manager asking for a 64 KiB send
+// buffer and the smallest receive buffer, binding real loopback sockets that
+// no proxy reads, and 200 packets sent to the audio A port. The expected
+// output is the send buffer on all eight sockets, eight inodes recorded, and
+// drops counted after /proc/net/udp is read.
+func TestManagerSizesSockets(t *testing.T) {
+	if _, err := os.Stat(procNetUDPFiles[0]); err != nil {
+		t.Skipf("no %s: %v", procNetUDPFiles[0], err)
+	}
+	manager := newTestManager(t, 0)
+	var conns []*net.UDPConn
+	manager.listenUDP = func(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	}
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	manager.SetSocketBuffers(SocketBuffers{Read: 1, Write: 64 << 10})
+
+	session, err := manager.Create("call-sized", "from", "to", true, true, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if len(conns) != 8 || len(session.socketInodes) != 8 {
+		t.Fatalf("expected 8 sockets and inodes, got %d and %d", len(conns), len(session.socketInodes))
+	}
+	for _, conn := range conns {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			t.Fatalf("unexpected syscall conn error: %v", err)
+		}
+		var sndbuf int
+		var sndErr error
+		_ = raw.Control(func(fd uintptr) {
+			sndbuf, sndErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		})
+		// Linux reports the size doubled.
+		if sndErr != nil || sndbuf != 2*(64<<10) {
+			t.Fatalf("expected sndbuf %d on %s, got %d (%v)", 2*(64<<10), conn.LocalAddr(), sndbuf, sndErr)
+		}
+	}
+
+	sender := mustListenUDP(t)
+	defer sender.Close()
+	dest := localUDPAddr(conns[0])
+	packet := makeRTPPacket(1, 160, make([]byte, 1200))
+	for i := 0; i < 200; i++ {
+		if _, err := sender.WriteToUDP(packet, dest); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	manager.updateKernelDrops(readKernelDrops(procNetUDPFiles))
+	if session.KernelRcvDrops() == 0 {
+		t.Fatalf("expected kernel receive drops on the unread socket")
+	}
+}
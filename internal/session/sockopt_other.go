@@ -0,0 +1,21 @@
+//go:build !linux
+
+package session
+
+import "errors"
+
+// setSocketMarking is only implemented on Linux.
+func setSocketMarking(fd uintptr, marking SocketMarking) error {
+	return errors.ErrUnsupported
+}
+
+// socketBufferSizes is only implemented on Linux.
+func socketBufferSizes(fd uintptr) (SocketBuffers, error) {
+	return SocketBuffers{}, errors.ErrUnsupported
+}
+
+// socketInode is only implemented on Linux, the only system with
+// /proc/net/udp.
+func socketInode(fd uintptr) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,100 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// errInvalidSource is returned for a source that is neither an IP nor an
+// IP and port.
+var errInvalidSource = errors.New("must be ip or ip:port")
+
+// ParseSource parses a pinned A-leg source given as "ip" or "ip:port". The
+// returned address has port 0 when only the IP was given, which accepts any
+// port from that IP.
+func ParseSource(value string) (*net.UDPAddr, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), 0)), nil
+	}
+	addrPort, err := netip.ParseAddrPort(value)
+	if err != nil || addrPort.Port() == 0 {
+		return nil, errInvalidSource
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())), nil
+}
+
+// FormatSource is the inverse of ParseSource: the IP alone when any port is
+// accepted. It returns "" for a nil source.
+func FormatSource(source *net.UDPAddr) string {
+	if source == nil {
+		return ""
+	}
+	if source.Port == 0 {
+		return source.IP.String()
+	}
+	return source.String()
+}
+
+// sourceMatches reports whether a packet from addr comes from the pinned
+// source, on any port when the source has port 0.
+func sourceMatches(source, addr *net.UDPAddr) bool {
+	return source.IP.Equal(addr.IP) && (source.Port == 0 || source.Port == addr.Port)
+}
+
+// sameUDPAddr reports whether a and b are the same address; nil only equals
+// nil.
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// pinnedSource returns the pinned A-leg source of media, "audio" or "video",
+// nil while the media learns its peer.
+func (s *Session) pinnedSource(media string) *net.UDPAddr {
+	if media == "video" {
+		return s.videoSource.Load()
+	}
+	return s.audioSource.Load()
+}
+
+// SourceUpdate describes a change of the pinned A-leg sources. A nil address
+// leaves that media untouched, and ClearAudio/ClearVideo return it to peer
+// learning.
+type SourceUpdate struct {
+	Audio      *net.UDPAddr
+	Video      *net.UDPAddr
+	ClearAudio bool
+	ClearVideo bool
+}
+
+// UpdateSource pins or unpins the A-leg sources of the session. Media that
+// was not requested at create time is left alone.
+func (m *Manager) UpdateSource(id string, update SourceUpdate) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if loadAtomicString(&session.audioDisabledReason) != DisabledReasonNotRequested {
+		storeSource(&session.audioSource, update.Audio, update.ClearAudio)
+	}
+	if loadAtomicString(&session.videoDisabledReason) != DisabledReasonNotRequested {
+		storeSource(&session.videoSource, update.Video, update.ClearVideo)
+	}
+	m.publish(EventSessionUpdated, session)
+	return session, true
+}
+
+func storeSource(target *atomic.Pointer[net.UDPAddr], source *net.UDPAddr, clear bool) {
+	switch {
+	case clear:
+		target.Store(nil)
+	case source != nil:
+		target.Store(cloneUDPAddr(source))
+	}
+}
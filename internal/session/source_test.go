@@ -0,0 +1,122 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestParseSource verifies the forms a pinned source may take. Inputs: an
+// IPv4 and an IPv6 address with and without port, an IPv4-mapped IPv6
+// address, port 0, a hostname and an empty string. The expected output is
+// port 0 for a bare IP, the mapped address unmapped, FormatSource giving the
+// input back, and an error for port 0, the hostname and the empty string.
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"192.0.2.10", "192.0.2.10", false},
+		{"192.0.2.10:40000", "192.0.2.10:40000", false},
+		{"2001:db8::10", "2001:db8::10", false},
+		{"[2001:db8::10]:40000", "[2001:db8::10]:40000", false},
+		{"::ffff:192.0.2.10", "192.0.2.10", false},
+		{"192.0.2.10:0", "", true},
+		{"doorphone.local:40000", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			source, err := ParseSource(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := FormatSource(source); got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestAudioProxyPinnedSource verifies that a pinned source replaces peer
+// learning. This matters because installations that know the doorphone
+// address must not let any other sender become the peer, not even first.
+// Inputs: a session with the audio source pinned to an IP without port, then
+// packets from another IP, from the pinned IP on one port and on another,
+// and finally the pin cleared and a packet from another IP after the window.
+// The expected output is the foreign packet rejected and counted with the
+// source reported as not matching, both pinned ports accepted with the peer
+// following the sender and the source matching, no peer learning recorded,
+// and learning rules applying again once the pin is cleared.
+func TestAudioProxyPinnedSource(t *testing.T) {
+	session := &Session{ID: "S-source", peerSwitchPackets: 3}
+	session.audioSource.Store(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 10)})
+	session.audioProxy = newAudioProxy(session, nil, nil, time.Hour, ProxyLogConfig{})
+	proxy := session.audioProxy.(*audioProxy)
+	foreign := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7), Port: 40000}
+	first := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}
+	moved := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40002}
+
+	if proxy.updateDoorphonePeer(foreign) {
+		t.Fatalf("expected the first packet from another IP to be rejected")
+	}
+	if media := session.AudioState(); media.Source.String() != "192.0.2.10:0" || media.SourceMatches || media.DoorphonePeer != nil {
+		t.Fatalf("expected an unmatched source and no peer, got %+v", media)
+	}
+	if !proxy.updateDoorphonePeer(first) || !proxy.updateDoorphonePeer(moved) {
+		t.Fatalf("expected packets from the pinned IP to be accepted on any port")
+	}
+	if media := session.AudioState(); !media.SourceMatches || media.DoorphonePeer.String() != moved.String() {
+		t.Fatalf("expected the peer to follow the pinned sender, got %+v", media)
+	}
+	if counters := snapshotAudioCounters(&session.audioCounters); counters.SourceRejected != 1 || counters.PeerSwitchesRejected != 0 {
+		t.Fatalf("expected one source rejection and no switch rejection, got %d and %d", counters.SourceRejected, counters.PeerSwitchesRejected)
+	}
+	if history := session.History(); len(history) != 0 {
+		t.Fatalf("expected no peer learning history, got %+v", history)
+	}
+
+	session.audioSource.Store(nil)
+	if proxy.updateDoorphonePeer(foreign) {
+		t.Fatalf("expected learning to hold the peer back from a single foreign packet")
+	}
+	if media := session.AudioState(); media.Source != nil {
+		t.Fatalf("expected no source once the pin is cleared, got %+v", media)
+	}
+}
+
+// TestManager_UpdateSource verifies pinning and unpinning on a live session.
+// Inputs: a session without video, an update pinning audio and video, and an
+// update clearing audio. The expected output is the audio pin set and then
+// cleared, and video, which was not requested, never pinned.
+func TestManager_UpdateSource(t *testing.T) {
+	manager := newTestManager(t, 0)
+	created, err := manager.CreateWithOptions("call-source", "from", "to", false, CreateOptions{DisableVideo: true})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	source := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 40000}
+	if _, ok := manager.UpdateSource(created.ID, SourceUpdate{Audio: source, Video: source}); !ok {
+		t.Fatalf("expected the update to find the session")
+	}
+	if got := created.AudioState().Source; got.String() != source.String() {
+		t.Fatalf("expected audio pinned to %s, got %v", source, got)
+	}
+	if got := created.VideoState().Source; got != nil {
+		t.Fatalf("expected video left unpinned, got %v", got)
+	}
+	manager.UpdateSource(created.ID, SourceUpdate{ClearAudio: true})
+	if got := created.AudioState().Source; got != nil {
+		t.Fatalf("expected the audio pin cleared, got %v", got)
+	}
+	if _, ok := manager.UpdateSource("missing", SourceUpdate{ClearAudio: true}); ok {
+		t.Fatalf("expected a missing session to be reported")
+	}
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"sync/atomic"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// ssrcLatch holds the SSRC a media locked onto when the session was created
+// with LockSSRC. It is empty until the first RTP packet on the A leg and
+// after a reset.
+type ssrcLatch struct {
+	// locked is the SSRC with bit 32 set, so that zero means unlocked.
+	locked atomic.Uint64
+}
+
+const ssrcLatchSet = 1 << 32
+
+// admit latches onto ssrc if the latch is empty and reports whether ssrc is
+// the latched one.
+func (l *ssrcLatch) admit(ssrc uint32) bool {
+	want := uint64(ssrc) | ssrcLatchSet
+	for {
+		current := l.locked.Load()
+		if current != 0 {
+			return current == want
+		}
+		if l.locked.CompareAndSwap(0, want) {
+			return true
+		}
+	}
+}
+
+func (l *ssrcLatch) get() (uint32, bool) {
+	current := l.locked.Load()
+	return uint32(current), current != 0
+}
+
+// admitSSRC reports whether an A-leg packet may be forwarded under the
+// session's SSRC lock. Packets that do not parse as RTP are left to the
+// proxy, which handles them as before.
+func (s *Session) admitSSRC(latch *ssrcLatch, packet []byte) bool {
+	if !s.Settings.LockSSRC {
+		return true
+	}
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok {
+		return true
+	}
+	return latch.admit(header.SSRC)
+}
+
+// ssrcLockResetter is implemented by proxies that hold per-stream state that
+// must not survive an SSRC change.
+type ssrcLockResetter interface {
+	resetSSRCLock()
+}
+
+// ResetSSRCLock empties the SSRC latch of the selected media so that the next
+// A-leg packet locks it again, for when the doorphone is expected to switch
+// streams. The video frame buffer is dropped along with the latch, so no
+// frame mixes the old and the new stream.
+func (m *Manager) ResetSSRCLock(id string, audio, video bool) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if audio {
+		session.audioSSRCLock.locked.Store(0)
+	}
+	if video {
+		if resetter, ok := session.videoProxy.(ssrcLockResetter); ok {
+			resetter.resetSSRCLock()
+		} else {
+			session.videoSSRCLock.locked.Store(0)
+		}
+	}
+	m.publish(EventSessionUpdated, session)
+	return session, true
+}
+
+// resetSSRCLock empties the latch and drops the frame being assembled.
+func (p *videoProxy) resetSSRCLock() {
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	p.session.videoSSRCLock.locked.Store(0)
+	p.resetFrameBuffer()
+}
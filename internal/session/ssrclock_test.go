@@ -0,0 +1,110 @@
+package session
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// TestVideoProxySSRCLock verifies that a session created with LockSSRC
+// forwards only the first SSRC seen on the video A leg, and that resetting
+// the lock drops the frame being assembled and lets the next stream lock the
+// media again. This matters because a second stream from the doorphone would
+// otherwise be merged into the frames of the first. Inputs: a fix-mode proxy,
+// the start of a fragmented frame on one SSRC, a packet on another SSRC, a
+// reset and the other SSRC again. The expected output is one foreign SSRC
+// drop that leaves the frame buffer alone, an empty buffer after the reset
+// and the latch on the second SSRC afterwards.
+func TestVideoProxySSRCLock(t *testing.T) {
+	session := &Session{ID: "S-lock", Settings: Settings{LockSSRC: true}}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, time.Second, true, true, VideoFixConfig{}, ProxyLogConfig{})
+	session.videoProxy = proxy
+	proxy.start()
+	defer proxy.stop()
+
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	send := func(packet []byte) {
+		t.Helper()
+		if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+			t.Fatalf("send to a-leg failed: %v", err)
+		}
+	}
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	send(makeRTPPacket(1, 9000, []byte{28, 0x85, 0x00}))
+	waitFor("the frame to start", func() bool { return session.VideoBufferState().FramePackets == 1 })
+	send(withSSRC(makeRTPPacket(2, 9000, []byte{28, 0x45, 0x01}), 0x55667788))
+	waitFor("the foreign ssrc drop", func() bool { return session.videoCounters.dropsForeignSSRC.Load() == 1 })
+	if ssrc, ok := session.videoSSRCLock.get(); !ok || ssrc != 0x11223344 {
+		t.Fatalf("expected the first ssrc to stay locked, got 0x%08x locked=%v", ssrc, ok)
+	}
+	if state := session.VideoBufferState(); state.FramePackets != 1 {
+		t.Fatalf("expected the foreign packet to stay out of the frame, got %+v", state)
+	}
+
+	proxy.resetSSRCLock()
+	if state := session.VideoBufferState(); state.FramePackets != 0 {
+		t.Fatalf("expected the reset to drop the frame, got %+v", state)
+	}
+	send(withSSRC(makeRTPPacket(3, 9100, []byte{28, 0x85, 0x02}), 0x55667788))
+	waitFor("the second ssrc to lock", func() bool {
+		ssrc, ok := session.videoSSRCLock.get()
+		return ok && ssrc == 0x55667788
+	})
+	if drops := session.videoCounters.dropsForeignSSRC.Load(); drops != 1 {
+		t.Fatalf("expected no new foreign ssrc drop, got %d", drops)
+	}
+}
+
+// TestManager_ResetSSRCLock verifies that the manager clears only the latch
+// of the requested media and reports unknown sessions. Inputs: a session with
+// LockSSRC whose audio and video are both locked, a reset of the audio only
+// and a reset of a missing session. The expected output is an empty audio
+// latch, an unchanged video latch and false for the missing session.
+func TestManager_ResetSSRCLock(t *testing.T) {
+	manager := newTestManager(t, 0)
+	session, err := manager.CreateWithOptions("call-lock", "from", "to", false, CreateOptions{LockSSRC: true})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	session.audioSSRCLock.admit(1)
+	session.videoSSRCLock.admit(2)
+
+	if _, ok := manager.ResetSSRCLock(session.ID, true, false); !ok {
+		t.Fatalf("expected reset to find the session")
+	}
+	if _, ok := session.audioSSRCLock.get(); ok {
+		t.Fatalf("expected the audio latch to be empty")
+	}
+	if ssrc, ok := session.videoSSRCLock.get(); !ok || ssrc != 2 {
+		t.Fatalf("expected the video latch to stay on 2, got %d locked=%v", ssrc, ok)
+	}
+	if state := session.AudioState(); state.SSRCLocked {
+		t.Fatalf("expected audio state to report no lock, got %+v", state)
+	}
+	if _, ok := manager.ResetSSRCLock("missing", true, true); ok {
+		t.Fatalf("expected reset of a missing session to fail")
+	}
+}
+
+func withSSRC(packet []byte, ssrc uint32) []byte {
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	return packet
+}
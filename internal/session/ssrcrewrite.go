@@ -0,0 +1,86 @@
+package session
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"rtp-stream-cleaner/internal/rtpparse"
+)
+
+// ssrcRewriter gives a media one SSRC toward the B leg whatever the doorphone
+// sends. The first input stream keeps its sequence numbers and timestamps;
+// when the input SSRC changes, later streams are shifted so that they carry on
+// from the last packet sent instead of starting a new stream downstream. It
+// is only used from the media's A-leg loop.
+type ssrcRewriter struct {
+	ssrc    uint32
+	changes *atomic.Uint64
+
+	inSSRC    uint32
+	hasIn     bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+	tsStep    uint32
+}
+
+// newSSRCRewriter picks a random output SSRC unless ssrc is given. changes
+// counts the input SSRC changes.
+func newSSRCRewriter(ssrc *uint32, changes *atomic.Uint64) *ssrcRewriter {
+	r := &ssrcRewriter{changes: changes}
+	if ssrc != nil {
+		r.ssrc = *ssrc
+	} else {
+		r.ssrc = rand.Uint32()
+	}
+	return r
+}
+
+// rewrite replaces the SSRC of an outgoing RTP packet in place. Muxed RTCP
+// and packets too short for an RTP header are left alone.
+func (r *ssrcRewriter) rewrite(packet []byte) {
+	if r == nil || len(packet) < 12 || packet[0]>>6 != 2 || rtpparse.IsRTCP(packet) {
+		return
+	}
+	inSSRC := binary.BigEndian.Uint32(packet[8:12])
+	seq := binary.BigEndian.Uint16(packet[2:4])
+	ts := binary.BigEndian.Uint32(packet[4:8])
+	first := !r.hasIn
+	switch {
+	case first:
+		r.inSSRC, r.hasIn = inSSRC, true
+	case inSSRC != r.inSSRC:
+		// Without a step seen on the previous stream the new one still
+		// gets a later timestamp than the last packet sent.
+		step := r.tsStep
+		if step == 0 {
+			step = 1
+		}
+		r.inSSRC = inSSRC
+		r.seqOffset = r.lastSeq + 1 - seq
+		r.tsOffset = r.lastTS + step - ts
+		r.changes.Add(1)
+	}
+	outSeq := seq + r.seqOffset
+	outTS := ts + r.tsOffset
+	// Packets of one video frame share a timestamp, so only a change counts
+	// as a step.
+	if step := outTS - r.lastTS; !first && outTS != r.lastTS && step < 1<<31 {
+		r.tsStep = step
+	}
+	r.lastSeq, r.lastTS = outSeq, outTS
+	binary.BigEndian.PutUint16(packet[2:4], outSeq)
+	binary.BigEndian.PutUint32(packet[4:8], outTS)
+	binary.BigEndian.PutUint32(packet[8:12], r.ssrc)
+}
+
+// outputSSRC reports the SSRC sent toward the B leg, if the media rewrites
+// it.
+func (r *ssrcRewriter) outputSSRC() (uint32, bool) {
+	if r == nil {
+		return 0, false
+	}
+	return r.ssrc, true
+}
@@ -0,0 +1,89 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// TestSSRCRewriter_ContinuityAcrossSSRCChange verifies that the rewriter sends
+// every packet under the output SSRC and shifts a new input stream so that
+// its sequence numbers and timestamps carry on from the last packet sent.
+// This matters because rtpengine resets decoding when it sees a new stream,
+// which is what a doorphone regenerating its SSRC looks like. Inputs: two
+// packets of one stream 160 timestamp units apart, then two packets of a
+// stream with another SSRC and unrelated numbering, and a muxed RTCP sender
+// report. The expected output is sequence numbers 100-103, timestamps
+// 1000-1480 in steps of 160, one SSRC change and the RTCP left untouched.
+func TestSSRCRewriter_ContinuityAcrossSSRCChange(t *testing.T) {
+	var changes atomic.Uint64
+	ssrc := uint32(0xcafef00d)
+	rewriter := newSSRCRewriter(&ssrc, &changes)
+
+	inputs := [][]byte{
+		makeRTPPacket(100, 1000, []byte{1}),
+		makeRTPPacket(101, 1160, []byte{2}),
+		withSSRC(makeRTPPacket(7, 500000, []byte{3}), 0x55667788),
+		withSSRC(makeRTPPacket(8, 500160, []byte{4}), 0x55667788),
+	}
+	for i, packet := range inputs {
+		rewriter.rewrite(packet)
+		seq := binary.BigEndian.Uint16(packet[2:4])
+		ts := binary.BigEndian.Uint32(packet[4:8])
+		if got := binary.BigEndian.Uint32(packet[8:12]); got != ssrc {
+			t.Fatalf("packet %d: expected ssrc 0x%08x, got 0x%08x", i, ssrc, got)
+		}
+		if seq != uint16(100+i) || ts != uint32(1000+160*i) {
+			t.Fatalf("packet %d: expected seq=%d ts=%d, got seq=%d ts=%d", i, 100+i, 1000+160*i, seq, ts)
+		}
+	}
+	if changes.Load() != 1 {
+		t.Fatalf("expected one ssrc change, got %d", changes.Load())
+	}
+
+	senderReport := []byte{0x80, 200, 0x00, 0x06, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	rewriter.rewrite(senderReport)
+	if got := binary.BigEndian.Uint32(senderReport[4:8]); got != 0x01020304 {
+		t.Fatalf("expected rtcp to stay untouched, got sender ssrc 0x%08x", got)
+	}
+}
+
+// TestVideoProxyInjectedParameterSetsUseOutputSSRC verifies that cached
+// SPS/PPS injected before an IDR go out under the rewritten SSRC along with
+// the IDR. This matters because parameter sets under the doorphone's SSRC
+// would reach rtpengine as a separate stream and never reach the decoder.
+// Inputs: a fix-mode proxy with injection and SSRC rewriting, cached SPS/PPS
+// and one IDR packet. The expected output is three packets under the output
+// SSRC with contiguous sequence numbers.
+func TestVideoProxyInjectedParameterSetsUseOutputSSRC(t *testing.T) {
+	ssrc := uint32(0x0badcafe)
+	session := &Session{ID: "S-rewrite"}
+	session.videoSSRCRewrite = newSSRCRewriter(&ssrc, &session.videoCounters.ssrcChanges)
+	proxy := &videoProxy{session: session, fixEnabled: true, injectCachedSPSPPS: true}
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		output = append(output, append([]byte(nil), packet...))
+		return nil
+	}
+	proxy.cacheParameterSet([]byte{0x67})
+	proxy.cacheParameterSet([]byte{0x68})
+
+	proxy.handleVideoPacket(makeRTPPacket(12, 9000, []byte{0x65}), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000})
+
+	if len(output) != 3 {
+		t.Fatalf("expected 3 output packets, got %d", len(output))
+	}
+	firstSeq := binary.BigEndian.Uint16(output[0][2:4])
+	for i, packet := range output {
+		if got := binary.BigEndian.Uint32(packet[8:12]); got != ssrc {
+			t.Fatalf("packet %d: expected ssrc 0x%08x, got 0x%08x", i, ssrc, got)
+		}
+		if seq := binary.BigEndian.Uint16(packet[2:4]); seq != firstSeq+uint16(i) {
+			t.Fatalf("packet %d: expected seq %d, got %d", i, firstSeq+uint16(i), seq)
+		}
+	}
+	if state := session.VideoState(); !state.RewriteSSRC || state.OutputSSRC != ssrc {
+		t.Fatalf("expected video state to report output ssrc, got %+v", state)
+	}
+}
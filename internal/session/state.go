@@ -0,0 +1,351 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/logging"
+)
+
+// stateFileName is the file in STATE_DIR that holds the live sessions.
+const stateFileName = "sessions.json"
+
+// savedState is the content of the state file.
+type savedState struct {
+	Sessions []savedSession `json:"sessions"`
+}
+
+// savedSession is what a session needs to be re-created after a restart.
+// Counters, learned peers and history are not kept.
+type savedSession struct {
+	ID          string            `json:"id"`
+	CallID      string            `json:"call_id"`
+	FromTag     string            `json:"from_tag"`
+	ToTag       string            `json:"to_tag"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	AdvertiseIP string            `json:"advertise_ip,omitempty"`
+	VideoFix    bool              `json:"video_fix,omitempty"`
+	LockSSRC    bool              `json:"lock_ssrc,omitempty"`
+	LogLevel    string            `json:"log_level,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Audio       savedMedia        `json:"audio"`
+	Video       savedMedia        `json:"video"`
+	// PeerLearningWindowMS and MaxFrameWaitMS keep the session overrides; a
+	// zero MaxFrameWaitMS falls back to MAX_FRAME_WAIT_MS.
+	PeerLearningWindowMS int64  `json:"peer_learning_window_ms"`
+	MaxFrameWaitMS       int64  `json:"max_frame_wait_ms,omitempty"`
+	DropIncompleteFrames bool   `json:"drop_incomplete_frames,omitempty"`
+	VideoCodec           string `json:"video_codec,omitempty"`
+	DropSEI              bool   `json:"drop_sei,omitempty"`
+	StripAnnexB          bool   `json:"strip_annexb,omitempty"`
+	VideoTimestampMode   string `json:"video_timestamp_mode,omitempty"`
+	VideoMaxKbps         int    `json:"video_max_kbps,omitempty"`
+	// DSCP and TTL keep the socket marking; files without them fall back to
+	// RTP_DSCP and RTP_TTL.
+	DSCP *int `json:"dscp,omitempty"`
+	TTL  *int `json:"ttl,omitempty"`
+}
+
+// savedMedia is one media of a saved session. Media without ports was not
+// requested.
+type savedMedia struct {
+	APort                int    `json:"a_port,omitempty"`
+	BPort                int    `json:"b_port,omitempty"`
+	Dest                 string `json:"dest,omitempty"`
+	DestHost             string `json:"dest_host,omitempty"`
+	DisabledReason       string `json:"disabled_reason,omitempty"`
+	PeerLearningWindowMS int64  `json:"peer_learning_window_ms"`
+	// OutputSSRC is kept so that rtpengine sees the same stream after the
+	// restart.
+	OutputSSRC *uint32 `json:"output_ssrc,omitempty"`
+	// Source is the pinned A-leg source in the form ParseSource takes.
+	Source string `json:"source,omitempty"`
+}
+
+// EnableStatePersistence re-creates the sessions saved in dir by a previous
+// process and from then on rewrites the state file after every session
+// change. A session whose ports cannot be bound again is dropped with a
+// logged reason. It returns the number of restored sessions and fails only
+// when dir or the state file cannot be used. It must be called before the
+// API starts serving.
+func (m *Manager) EnableStatePersistence(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return 0, fmt.Errorf("create state dir: %w", err)
+	}
+	path := filepath.Join(dir, stateFileName)
+	var state savedState
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return 0, fmt.Errorf("read session state: %w", err)
+	default:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0, fmt.Errorf("parse session state %s: %w", path, err)
+		}
+	}
+	restored := 0
+	for _, saved := range state.Sessions {
+		if err := m.restoreSession(saved); err != nil {
+			logging.WithSessionID(saved.ID).Error("session.restore failed", "call_id", saved.CallID, "error", err)
+			continue
+		}
+		restored++
+	}
+	m.stateFile = path
+	// Rewriting right away drops the sessions that failed to restore and
+	// proves that the file is writable.
+	if err := m.saveState(); err != nil {
+		return restored, err
+	}
+	m.wg.Add(1)
+	go m.persistState()
+	return restored, nil
+}
+
+// restoreSession re-creates a saved session on its previous ports and starts
+// its proxies. Session limits do not apply since the session already existed.
+func (m *Manager) restoreSession(saved savedSession) error {
+	audio, video := saved.Audio.APort != 0, saved.Video.APort != 0
+	audioWindow := time.Duration(saved.Audio.PeerLearningWindowMS) * time.Millisecond
+	videoWindow := time.Duration(saved.Video.PeerLearningWindowMS) * time.Millisecond
+	// The saved expiry is kept as is below.
+	var unlimited time.Duration
+	window := time.Duration(saved.PeerLearningWindowMS) * time.Millisecond
+	opts := CreateOptions{
+		MaxLifetime:             &unlimited,
+		PeerLearningWindow:      &window,
+		DisableAudio:            !audio,
+		DisableVideo:            !video,
+		AudioPeerLearningWindow: &audioWindow,
+		VideoPeerLearningWindow: &videoWindow,
+		Metadata:                saved.Metadata,
+		AdvertiseIP:             saved.AdvertiseIP,
+		LockSSRC:                saved.LockSSRC,
+		DropIncompleteFrames:    saved.DropIncompleteFrames,
+		VideoCodec:              saved.VideoCodec,
+		DropSEI:                 saved.DropSEI,
+		StripAnnexB:             saved.StripAnnexB,
+		VideoTimestampMode:      saved.VideoTimestampMode,
+		VideoMaxKbps:            saved.VideoMaxKbps,
+		DSCP:                    saved.DSCP,
+		TTL:                     saved.TTL,
+		RewriteAudioSSRC:        saved.Audio.OutputSSRC != nil,
+		RewriteVideoSSRC:        saved.Video.OutputSSRC != nil,
+		AudioOutputSSRC:         saved.Audio.OutputSSRC,
+		VideoOutputSSRC:         saved.Video.OutputSSRC,
+	}
+	var err error
+	if opts.InitialAudioDest, err = saved.Audio.dest(); err != nil {
+		return fmt.Errorf("audio dest: %w", err)
+	}
+	if opts.InitialVideoDest, err = saved.Video.dest(); err != nil {
+		return fmt.Errorf("video dest: %w", err)
+	}
+	opts.InitialAudioDestHost, opts.InitialVideoDestHost = saved.Audio.DestHost, saved.Video.DestHost
+	if opts.AudioSource, err = saved.Audio.source(); err != nil {
+		return fmt.Errorf("audio source: %w", err)
+	}
+	if opts.VideoSource, err = saved.Video.source(); err != nil {
+		return fmt.Errorf("video source: %w", err)
+	}
+	if saved.MaxFrameWaitMS > 0 {
+		frameWait := time.Duration(saved.MaxFrameWaitMS) * time.Millisecond
+		opts.MaxFrameWait = &frameWait
+	}
+	if saved.LogLevel != "" {
+		if level, err := logging.ParseLevel(saved.LogLevel); err == nil {
+			opts.LogLevel = &level
+		}
+	}
+
+	session := m.newSession(saved.CallID, saved.FromTag, saved.ToTag, saved.VideoFix, opts, saved.CreatedAt)
+	session.ID = saved.ID
+	session.Restored = true
+	session.ExpiresAt = saved.ExpiresAt
+	session.Audio.APort, session.Audio.BPort = saved.Audio.APort, saved.Audio.BPort
+	session.Video.APort, session.Video.BPort = saved.Video.APort, saved.Video.BPort
+	now := m.now()
+	// The idle timeout counts from the restart, not from the last packet
+	// before it.
+	session.setLastActivity(now)
+	session.recordHistory(now, HistoryRestored, "", "")
+
+	ports := session.ports()
+	if err := m.allocator.Claim(ports); err != nil {
+		return err
+	}
+	sockets, _, err := m.listenLegs(session, audio, video)
+	if err != nil {
+		m.allocator.Release(ports)
+		return err
+	}
+	m.attachProxies(session, sockets, saved.VideoFix)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[session.ID]; exists {
+		m.stopSession(session)
+		return fmt.Errorf("duplicate session id %s", session.ID)
+	}
+	m.sessions[session.ID] = session
+	m.callSessions[session.CallID]++
+	if _, exists := m.dialogs[session.dialogKey()]; !exists {
+		m.dialogs[session.dialogKey()] = session.ID
+	}
+	m.observers.notify(notification{kind: observeCreated, session: session})
+	session.startProxies()
+	session.Logger().Info("session.restored", "call_id", session.CallID, "ports", ports)
+	return nil
+}
+
+// dest returns the destination to apply on restore: nil when none was known
+// and port 0 when rtpengine had disabled the media.
+func (m savedMedia) dest() (*net.UDPAddr, error) {
+	if m.DisabledReason == "rtpengine_port_0" {
+		return &net.UDPAddr{}, nil
+	}
+	if m.Dest == "" {
+		return nil, nil
+	}
+	addr, err := netip.ParseAddrPort(m.Dest)
+	if err != nil {
+		return nil, err
+	}
+	return net.UDPAddrFromAddrPort(addr), nil
+}
+
+// source returns the pinned A-leg source, nil when the media learned its
+// peer.
+func (m savedMedia) source() (*net.UDPAddr, error) {
+	if m.Source == "" {
+		return nil, nil
+	}
+	return ParseSource(m.Source)
+}
+
+// markStateDirty asks the persister to rewrite the state file. It never
+// blocks, so it is safe under m.mu.
+func (m *Manager) markStateDirty() {
+	select {
+	case m.stateDirty <- struct{}{}:
+	default:
+	}
+}
+
+// persistState rewrites the state file whenever sessions changed, coalescing
+// bursts of changes. On Close it saves once more and stops, so that the
+// sessions stopped on shutdown stay in the file for the next process.
+func (m *Manager) persistState() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.stateDirty:
+			m.logStateSave()
+		case <-m.stopCh:
+			m.logStateSave()
+			return
+		}
+	}
+}
+
+func (m *Manager) logStateSave() {
+	if err := m.saveState(); err != nil {
+		logging.L().Error("session state save failed", "path", m.stateFile, "error", err)
+	}
+}
+
+// saveState writes the live sessions to a temporary file and renames it over
+// the state file, so a crash never leaves a truncated file behind.
+func (m *Manager) saveState() error {
+	sessions := m.List()
+	slices.SortFunc(sessions, func(a, b *Session) int { return strings.Compare(a.ID, b.ID) })
+	state := savedState{Sessions: make([]savedSession, 0, len(sessions))}
+	for _, session := range sessions {
+		state.Sessions = append(state.Sessions, session.saved())
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := m.stateFile + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("write session state: %w", err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write session state: %w", err)
+	}
+	if err := os.Rename(tmp, m.stateFile); err != nil {
+		return fmt.Errorf("write session state: %w", err)
+	}
+	return nil
+}
+
+func (s *Session) saved() savedSession {
+	return savedSession{
+		ID:          s.ID,
+		CallID:      s.CallID,
+		FromTag:     s.FromTag,
+		ToTag:       s.ToTag,
+		CreatedAt:   s.CreatedAt,
+		ExpiresAt:   s.ExpiresAt,
+		AdvertiseIP: s.AdvertiseIP,
+		VideoFix:    s.Settings.VideoFix,
+		LockSSRC:    s.Settings.LockSSRC,
+		LogLevel:    s.LogLevel(),
+		Metadata:    s.Metadata(),
+		Audio:       savedMediaState(s.Audio.APort, s.Audio.BPort, s.Audio.PeerLearningWindow, s.audioDest.Load(), &s.audioDestHost, &s.audioDisabledReason, s.audioSSRCRewrite, s.audioSource.Load()),
+		Video:       savedMediaState(s.Video.APort, s.Video.BPort, s.Video.PeerLearningWindow, s.videoDest.Load(), &s.videoDestHost, &s.videoDisabledReason, s.videoSSRCRewrite, s.videoSource.Load()),
+
+		PeerLearningWindowMS: s.Settings.PeerLearningWindow.Milliseconds(),
+		MaxFrameWaitMS:       s.Settings.MaxFrameWait.Milliseconds(),
+		DropIncompleteFrames: s.Settings.DropIncompleteFrames,
+		VideoCodec:           s.Settings.VideoCodec,
+		DropSEI:              s.Settings.DropSEI,
+		StripAnnexB:          s.Settings.StripAnnexB,
+		VideoTimestampMode:   s.Settings.VideoTimestampMode,
+		VideoMaxKbps:         s.Settings.VideoMaxKbps,
+		DSCP:                 &s.Settings.Marking.DSCP,
+		TTL:                  &s.Settings.Marking.TTL,
+	}
+}
+
+// savedMediaState reads the live destination and source from the atomics;
+// the ports and learning window never change after create.
+func savedMediaState(aPort, bPort int, window time.Duration, dest *net.UDPAddr, host, disabledReason *atomic.Value, rewrite *ssrcRewriter, source *net.UDPAddr) savedMedia {
+	saved := savedMedia{
+		APort:                aPort,
+		BPort:                bPort,
+		DestHost:             loadAtomicString(host),
+		DisabledReason:       loadAtomicString(disabledReason),
+		PeerLearningWindowMS: window.Milliseconds(),
+		Source:               FormatSource(source),
+	}
+	if dest != nil {
+		saved.Dest = dest.String()
+	}
+	if ssrc, ok := rewrite.outputSSRC(); ok {
+		saved.OutputSSRC = &ssrc
+	}
+	return saved
+}
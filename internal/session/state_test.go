@@ -0,0 +1,171 @@
+package session
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readSavedState(t *testing.T, dir string) savedState {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if err != nil {
+		t.Fatalf("read state file: %v", err)
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("parse state file: %v", err)
+	}
+	return state
+}
+
+// TestManager_StatePersistence_RestoresSessions verifies that a session
+// survives a restart: every change rewrites STATE_DIR/sessions.json, stopping
+// sessions on shutdown keeps them in the file, and a new manager re-creates
+// them on the same ports with their destinations, fix mode, frame handling and
+// labels. Inputs: an audio+video session with video fix, a 300ms frame wait,
+// incomplete frames dropped, the h265 codec, SEI dropped, Annex B start codes
+// stripped, metadata, an audio destination given by hostname, an audio source
+// pinned to an IP, DSCP 46 and video disabled with port 0, saved by one
+// manager and loaded by another marking with DSCP 10 and TTL 64. The expected
+// output is one restored session flagged Restored with the same ID, ports,
+// state and marking, and its ports taken in the new pool.
+func TestManager_StatePersistence_RestoresSessions(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestManager(t, 0)
+	frameWait := 300 * time.Millisecond
+	dscp := 46
+	if restored, err := first.EnableStatePersistence(dir); err != nil || restored != 0 {
+		t.Fatalf("expected empty state, got restored=%d err=%v", restored, err)
+	}
+	created, err := first.CreateWithOptions("call-state", "from", "to", true, CreateOptions{
+		InitialAudioDest:     &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 4000},
+		InitialAudioDestHost: "rtpengine.local",
+		Metadata:             map[string]string{"tenant": "acme"},
+		MaxFrameWait:         &frameWait,
+		DropIncompleteFrames: true,
+		VideoCodec:           VideoCodecH265,
+		DropSEI:              true,
+		StripAnnexB:          true,
+		AudioSource:          &net.UDPAddr{IP: net.IPv4(192, 0, 2, 30)},
+		DSCP:                 &dscp,
+	})
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, ok, _ := first.UpdateRTPDest(created.ID, nil, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 0}); !ok {
+		t.Fatalf("expected update to succeed")
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(readSavedState(t, dir).Sessions) != 1 || readSavedState(t, dir).Sessions[0].Video.DisabledReason == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the update to be saved, got %+v", readSavedState(t, dir))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	first.Close()
+	first.StopAllSessions()
+	if saved := readSavedState(t, dir).Sessions; len(saved) != 1 || saved[0].ID != created.ID {
+		t.Fatalf("expected shutdown to keep the session in the state file, got %+v", saved)
+	}
+
+	second := newTestManager(t, 0)
+	second.SetSocketMarking(SocketMarking{DSCP: 10, TTL: 64})
+	restored, err := second.EnableStatePersistence(dir)
+	if err != nil || restored != 1 {
+		t.Fatalf("expected one restored session, got restored=%d err=%v", restored, err)
+	}
+	got, ok := second.Get(created.ID)
+	if !ok || !got.Restored {
+		t.Fatalf("expected restored session %s, got %+v", created.ID, got)
+	}
+	if got.Audio.APort != created.Audio.APort || got.Audio.BPort != created.Audio.BPort || got.Video.APort != created.Video.APort || got.Video.BPort != created.Video.BPort {
+		t.Fatalf("expected the same ports, got audio %d/%d video %d/%d", got.Audio.APort, got.Audio.BPort, got.Video.APort, got.Video.BPort)
+	}
+	audio := got.AudioState()
+	if !audio.Enabled || audio.RTPEngineDest.String() != "192.0.2.10:4000" || audio.RTPEngineDestHost != "rtpengine.local" || audio.Source.String() != "192.0.2.30:0" {
+		t.Fatalf("unexpected audio state %+v", audio)
+	}
+	if video := got.VideoState(); video.Enabled || video.DisabledReason != "rtpengine_port_0" {
+		t.Fatalf("unexpected video state %+v", video)
+	}
+	if !got.Settings.VideoFix || got.Settings.MaxFrameWait != frameWait || !got.Settings.DropIncompleteFrames || got.Settings.VideoCodec != VideoCodecH265 || !got.Settings.DropSEI || !got.Settings.StripAnnexB || got.Settings.Marking != (SocketMarking{DSCP: 46}) || got.Metadata()["tenant"] != "acme" || !got.CreatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("unexpected settings %+v metadata %v created_at %v", got.Settings, got.Metadata(), got.CreatedAt)
+	}
+	if !hasHistory(got, HistoryRestored, "", "") {
+		t.Fatalf("expected restored history entry, got %+v", got.History())
+	}
+	if stats := second.PoolStats(); stats.InUse != 8 {
+		t.Fatalf("expected 8 ports in use, got %d", stats.InUse)
+	}
+}
+
+// TestManager_StatePersistence_SkipsUnbindableSession verifies that a session
+// whose ports are held by another process after the restart is dropped
+// without failing startup. Inputs: two saved sessions and a listenUDP that
+// reports EADDRINUSE for the first session's audio A port. The expected
+// output is only the second session restored, the first one's ports back in
+// the pool and the state file rewritten without it.
+func TestManager_StatePersistence_SkipsUnbindableSession(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestManager(t, 0)
+	if _, err := first.EnableStatePersistence(dir); err != nil {
+		t.Fatalf("unexpected state error: %v", err)
+	}
+	lost, err := first.Create("call-lost", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	kept, err := first.Create("call-kept", "from", "to", true, false, false)
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	first.Close()
+
+	second := newTestManager(t, 0)
+	second.listenUDP = func(_ string, addr *net.UDPAddr) (*net.UDPConn, error) {
+		if addr.Port == lost.Audio.APort {
+			return nil, addrInUse()
+		}
+		return nil, nil
+	}
+	restored, err := second.EnableStatePersistence(dir)
+	if err != nil || restored != 1 {
+		t.Fatalf("expected one restored session, got restored=%d err=%v", restored, err)
+	}
+	if _, ok := second.Get(lost.ID); ok {
+		t.Fatalf("expected session %s to be dropped", lost.ID)
+	}
+	if _, ok := second.Get(kept.ID); !ok {
+		t.Fatalf("expected session %s to be restored", kept.ID)
+	}
+	if stats := second.PoolStats(); stats.InUse != 4 {
+		t.Fatalf("expected 4 ports in use, got %d", stats.InUse)
+	}
+	if saved := readSavedState(t, dir).Sessions; len(saved) != 1 || saved[0].ID != kept.ID {
+		t.Fatalf("expected only %s in the state file, got %+v", kept.ID, saved)
+	}
+}
+
+// TestManager_StatePersistence_CorruptFile verifies that an unreadable state
+// file fails startup instead of being overwritten with an empty state.
+// Inputs: a sessions.json that is not JSON. The expected output is an error
+// and the file left untouched.
+func TestManager_StatePersistence_CorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, stateFileName)
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("write state file: %v", err)
+	}
+	manager := newTestManager(t, 0)
+
+	if _, err := manager.EnableStatePersistence(dir); err == nil {
+		t.Fatalf("expected an error for a corrupt state file")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{not json" {
+		t.Fatalf("expected the state file to be left alone, got %q", data)
+	}
+}
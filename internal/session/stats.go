@@ -0,0 +1,60 @@
+package session
+
+import "sync/atomic"
+
+// Stats are manager-wide totals since the process started. Deleted sessions
+// and idle-reaped sessions are counted apart, so SessionsCreated is their sum
+// plus the active sessions, less those restored from STATE_DIR. Bytes count
+// what was relayed: A to B is what left on the B legs and B to A what left on
+// the A legs, including sessions that are still live.
+type Stats struct {
+	SessionsCreated        uint64
+	SessionsDeleted        uint64
+	SessionsIdleReaped     uint64
+	ActiveSessions         int
+	CreatesRejectedNoPorts uint64
+	BytesAToB              uint64
+	BytesBToA              uint64
+	PortPool               PoolStats
+}
+
+// managerStats holds the totals that outlive sessions. Relayed bytes of a
+// session are folded in when it stops.
+type managerStats struct {
+	sessionsCreated        atomic.Uint64
+	sessionsDeleted        atomic.Uint64
+	sessionsIdleReaped     atomic.Uint64
+	createsRejectedNoPorts atomic.Uint64
+	bytesAToB              atomic.Uint64
+	bytesBToA              atomic.Uint64
+}
+
+// relayedBytes returns the bytes the session sent on its B legs and on its A
+// legs.
+func (s *Session) relayedBytes() (aToB, bToA uint64) {
+	audio := s.AudioCountersSnapshot()
+	video := s.VideoCountersSnapshot()
+	return audio.BOutBytes + video.BOutBytes, audio.AOutBytes + video.AOutBytes
+}
+
+// Stats returns the manager-wide totals, with the relayed bytes of live
+// sessions added to those of stopped ones.
+func (m *Manager) Stats() Stats {
+	sessions := m.List()
+	stats := Stats{
+		SessionsCreated:        m.stats.sessionsCreated.Load(),
+		SessionsDeleted:        m.stats.sessionsDeleted.Load(),
+		SessionsIdleReaped:     m.stats.sessionsIdleReaped.Load(),
+		ActiveSessions:         len(sessions),
+		CreatesRejectedNoPorts: m.stats.createsRejectedNoPorts.Load(),
+		BytesAToB:              m.stats.bytesAToB.Load(),
+		BytesBToA:              m.stats.bytesBToA.Load(),
+		PortPool:               m.PoolStats(),
+	}
+	for _, session := range sessions {
+		aToB, bToA := session.relayedBytes()
+		stats.BytesAToB += aToB
+		stats.BytesBToA += bToA
+	}
+	return stats
+}
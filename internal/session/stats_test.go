@@ -0,0 +1,106 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestManager_Stats_TotalsAcrossLifecycle verifies the manager-wide totals:
+// creates, API deletes and idle reaps are counted apart, a create that finds
+// no free port pairs is counted as rejected, and relayed bytes of stopped
+// sessions are kept while those of live sessions are added on top. Inputs: a
+// pool for four audio+video sessions, a fifth create, one delete, an idle
+// sweep that reaps two sessions and byte counters set on three sessions. The
+// expected output is 4 created, 1 deleted, 2 reaped, 1 active, 1 rejected,
+// 105 bytes A to B and 47 bytes B to A.
+func TestManager_Stats_TotalsAcrossLifecycle(t *testing.T) {
+	manager := newTestManager(t, 10*time.Second)
+	var sessions []*Session
+	for i := 0; i < 4; i++ {
+		created, err := manager.Create("call-stats", "from", "to", true, true, false)
+		if err != nil {
+			t.Fatalf("unexpected create error: %v", err)
+		}
+		sessions = append(sessions, created)
+	}
+	if _, err := manager.Create("call-stats", "from", "to", true, true, false); !errors.Is(err, ErrNoPortsAvailable) {
+		t.Fatalf("expected ErrNoPortsAvailable, got %v", err)
+	}
+	t0 := sessions[0].CreatedAt
+	sessions[0].audioCounters.bOutBytes.Add(100)
+	sessions[0].videoCounters.aOutBytes.Add(40)
+	sessions[1].audioCounters.aOutBytes.Add(7)
+	sessions[2].audioCounters.bOutBytes.Add(5)
+	sessions[2].markActivity(t0.Add(5 * time.Second))
+
+	manager.Delete(sessions[0].ID)
+	manager.Cleanup(t0.Add(10 * time.Second))
+
+	stats := manager.Stats()
+	if stats.SessionsCreated != 4 || stats.SessionsDeleted != 1 || stats.SessionsIdleReaped != 2 || stats.ActiveSessions != 1 || stats.CreatesRejectedNoPorts != 1 {
+		t.Fatalf("unexpected session totals %+v", stats)
+	}
+	if stats.BytesAToB != 105 || stats.BytesBToA != 47 {
+		t.Fatalf("expected 105 bytes A to B and 47 B to A, got %d and %d", stats.BytesAToB, stats.BytesBToA)
+	}
+	if stats.PortPool.InUse != 8 {
+		t.Fatalf("expected 8 ports in use, got %d", stats.PortPool.InUse)
+	}
+}
+
+// TestManager_PortPoolAlerts verifies that pool exhaustion is visible to
+// operators: the first create that leaves fewer free ports than the low-water
+// mark logs one warning, and a create that finds no ports logs an error with
+// the pool stats and bumps the exhausted counter. Inputs: a 12-port pool, a
+// low-water mark of 8, an audio+video create, an audio-only create and a
+// third create. The expected output is a single port_pool.low warning, one
+// port_pool.exhausted error with free 0 and exhausted_total 1, and Exhausted
+// 1 in the pool stats.
+func TestManager_PortPoolAlerts(t *testing.T) {
+	manager := newTestManager(t, 0)
+	allocator, err := NewPortAllocator(14000, 14011)
+	if err != nil {
+		t.Fatalf("unexpected allocator error: %v", err)
+	}
+	manager.allocator = allocator
+	manager.SetPortPoolLowWater(8)
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	manager.logger = func() *slog.Logger { return logger }
+
+	if _, err := manager.Create("call-1", "from", "to", true, true, false); err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, err := manager.Create("call-2", "from", "to", true, false, false); err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, err := manager.Create("call-3", "from", "to", true, false, false); !errors.Is(err, ErrNoPortsAvailable) {
+		t.Fatalf("expected ErrNoPortsAvailable, got %v", err)
+	}
+
+	var records []map[string]any
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("unexpected log decode error: %v", err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected two log records, got %v", records)
+	}
+	if records[0]["msg"] != "port_pool.low" || records[0]["level"] != "WARN" || records[0]["free"] != float64(4) {
+		t.Fatalf("unexpected low-water record %v", records[0])
+	}
+	if records[1]["msg"] != "port_pool.exhausted" || records[1]["level"] != "ERROR" || records[1]["free"] != float64(0) || records[1]["exhausted_total"] != float64(1) {
+		t.Fatalf("unexpected exhausted record %v", records[1])
+	}
+	if stats := manager.PoolStats(); stats.Exhausted != 1 {
+		t.Fatalf("expected one exhaustion, got %+v", stats)
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"sync/atomic"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// DefaultTSDiscontinuityThreshold is how far the RTP timestamps of two
+// consecutive audio packets may stray from the time between their arrivals
+// before the jump counts as a discontinuity.
+const DefaultTSDiscontinuityThreshold = 2 * time.Second
+
+// SetTSDiscontinuityThreshold sets how far the RTP timestamp of the audio A
+// leg may jump against the arrival time before it is counted in
+// audio_ts_discontinuities; 0 turns the detection off. It must be called
+// before sessions are created.
+func (m *Manager) SetTSDiscontinuityThreshold(threshold time.Duration) {
+	m.tsJumpThreshold = threshold
+}
+
+// tsDiscontinuityDetector spots RTP timestamp resets within one SSRC, which
+// downstream jitter buffers take for a gap of minutes and mute on. The
+// timestamp advance between consecutive packets is compared with the time
+// between their arrivals, so that silence suppression, which pauses both
+// alike, never counts. It only measures and is only used from the A-leg read
+// loop.
+type tsDiscontinuityDetector struct {
+	count      *atomic.Uint64
+	clockRates map[uint8]uint32
+	threshold  time.Duration
+
+	started bool
+	ssrc    uint32
+	rate    uint32
+	ts      uint32
+	arrival time.Time
+}
+
+// newTSDiscontinuityDetector counts into count; packets whose payload type
+// has no clock rate in clockRates are skipped. A zero threshold detects
+// nothing.
+func newTSDiscontinuityDetector(count *atomic.Uint64, clockRates map[uint8]uint32, threshold time.Duration) *tsDiscontinuityDetector {
+	return &tsDiscontinuityDetector{count: count, clockRates: clockRates, threshold: threshold}
+}
+
+// track feeds packet, received at arrival, to the detector. It reports
+// whether the timestamp jumped since the previous packet, counting the jump,
+// and returns the previous timestamp.
+func (d *tsDiscontinuityDetector) track(packet []byte, arrival time.Time) (bool, uint32) {
+	if d.threshold <= 0 {
+		return false, 0
+	}
+	header, ok := rtpfix.ParseRTPHeader(packet)
+	if !ok {
+		return false, 0
+	}
+	return d.observe(header.SSRC, header.PT, header.TS, arrival)
+}
+
+func (d *tsDiscontinuityDetector) observe(ssrc uint32, pt uint8, ts uint32, arrival time.Time) (bool, uint32) {
+	rate := d.clockRates[pt]
+	if rate == 0 {
+		return false, 0
+	}
+	previous, previousArrival := d.ts, d.arrival
+	restart := !d.started || ssrc != d.ssrc || rate != d.rate
+	d.started, d.ssrc, d.rate, d.ts, d.arrival = true, ssrc, rate, ts, arrival
+	if restart {
+		return false, 0
+	}
+	// The signed difference handles the wrap of the timestamp and packets
+	// arriving out of order.
+	advance := rtpfix.TSToDuration(rtpfix.TSDiff(ts, previous), int(rate))
+	skew := advance - arrival.Sub(previousArrival)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= d.threshold {
+		return false, 0
+	}
+	d.count.Add(1)
+	return true, previous
+}
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makePCMUPacket returns a PCMU RTP packet of the given SSRC.
+func makePCMUPacket(seq uint16, ts, ssrc uint32) []byte {
+	packet := makeRTPPacket(seq, ts, make([]byte, 160))
+	packet[1] = 0
+	binary.BigEndian.PutUint32(packet[8:12], ssrc)
+	return packet
+}
+
+// tsStep is one packet fed to the detector: its timestamp and SSRC, and its
+// arrival after the first packet.
+type tsStep struct {
+	ts      uint32
+	ssrc    uint32
+	arrival time.Duration
+}
+
+// runTSDetector feeds steps to a detector with the given threshold and returns
+// the indexes of the packets reported as jumps and the count.
+func runTSDetector(threshold time.Duration, steps []tsStep) ([]int, uint64) {
+	var count atomic.Uint64
+	detector := newTSDiscontinuityDetector(&count, defaultClockRates, threshold)
+	start := time.Unix(1_700_000_000, 0)
+	var jumps []int
+	for i, step := range steps {
+		if jumped, _ := detector.track(makePCMUPacket(uint16(i), step.ts, step.ssrc), start.Add(step.arrival)); jumped {
+			jumps = append(jumps, i)
+		}
+	}
+	return jumps, count.Load()
+}
+
+// TestTSDiscontinuityDetector_IgnoresSilenceSuppression verifies that pauses
+// in the RTP stream do not count as timestamp discontinuities. This matters
+// because doorphones with VAD stop sending for seconds between words, and
+// reporting every pause would bury the real resets. Inputs: PCMU packets 20ms
+// apart, a 5s silence-suppression gap in which the timestamp advances with the
+// arrival time, a packet 300ms late through network jitter, and a stream whose
+// timestamp wraps around. The expected output is no jump and a zero count.
+func TestTSDiscontinuityDetector_IgnoresSilenceSuppression(t *testing.T) {
+	steps := []tsStep{
+		{ts: 1000, ssrc: 1, arrival: 0},
+		{ts: 1160, ssrc: 1, arrival: 20 * time.Millisecond},
+		{ts: 1320, ssrc: 1, arrival: 40 * time.Millisecond},
+		{ts: 41320, ssrc: 1, arrival: 5040 * time.Millisecond},
+		{ts: 41480, ssrc: 1, arrival: 5360 * time.Millisecond},
+		{ts: 41640, ssrc: 1, arrival: 5361 * time.Millisecond},
+		{ts: 0xFFFFFF00, ssrc: 2, arrival: 6 * time.Second},
+		{ts: 0x00000060, ssrc: 2, arrival: 6020 * time.Millisecond},
+		{ts: 0x00000100, ssrc: 2, arrival: 6040 * time.Millisecond},
+	}
+	jumps, count := runTSDetector(DefaultTSDiscontinuityThreshold, steps)
+	if len(jumps) != 0 || count != 0 {
+		t.Fatalf("expected no discontinuity, got jumps at %v and count %d", jumps, count)
+	}
+}
+
+// TestTSDiscontinuityDetector_CountsJumps verifies that timestamp jumps within
+// one SSRC are counted and returned with the timestamp before them. This
+// matters because a doorphone resetting its timestamp mid-call makes jitter
+// buffers downstream mute, with no other trace in the counters. Inputs: PCMU
+// packets 20ms apart, then a reset to a random base, a jump 3s back and a
+// jump 3s ahead in packets 20ms apart. The expected output is three jumps
+// counted, the first reported with the timestamp before the reset.
+func TestTSDiscontinuityDetector_CountsJumps(t *testing.T) {
+	var count atomic.Uint64
+	detector := newTSDiscontinuityDetector(&count, defaultClockRates, DefaultTSDiscontinuityThreshold)
+	start := time.Unix(1_700_000_000, 0)
+	steps := []uint32{1000, 1160, 1320, 0x8A3F1200, 0x8A3F1200 - 24000, 0x8A3F1200 + 24000}
+	var jumps []int
+	for i, ts := range steps {
+		jumped, previous := detector.track(makePCMUPacket(uint16(i), ts, 1), start.Add(time.Duration(i)*20*time.Millisecond))
+		if jumped {
+			jumps = append(jumps, i)
+		}
+		if i == 3 && (!jumped || previous != 1320) {
+			t.Fatalf("expected the reset reported after timestamp 1320, got %v and %d", jumped, previous)
+		}
+	}
+	if len(jumps) != 3 || count.Load() != 3 {
+		t.Fatalf("expected jumps at packets 3 to 5, got %v and count %d", jumps, count.Load())
+	}
+}
+
+// TestTSDiscontinuityDetector_Resets verifies the cases in which a timestamp
+// jump is not counted. Inputs: a jump together with a change of SSRC, which
+// starts a new stream; a jump with a zero threshold; and a jump carried by a
+// payload type with no known clock rate. The expected output is no jump for
+// each.
+func TestTSDiscontinuityDetector_Resets(t *testing.T) {
+	jumps, count := runTSDetector(DefaultTSDiscontinuityThreshold, []tsStep{
+		{ts: 1000, ssrc: 1, arrival: 0},
+		{ts: 0x8A3F1200, ssrc: 2, arrival: 20 * time.Millisecond},
+		{ts: 0x8A3F12A0, ssrc: 2, arrival: 40 * time.Millisecond},
+	})
+	if len(jumps) != 0 || count != 0 {
+		t.Fatalf("expected no discontinuity across an SSRC change, got jumps at %v", jumps)
+	}
+
+	jumps, count = runTSDetector(0, []tsStep{
+		{ts: 1000, ssrc: 1, arrival: 0},
+		{ts: 0x8A3F1200, ssrc: 1, arrival: 20 * time.Millisecond},
+	})
+	if len(jumps) != 0 || count != 0 {
+		t.Fatalf("expected no discontinuity with detection off, got jumps at %v", jumps)
+	}
+
+	var unknown atomic.Uint64
+	detector := newTSDiscontinuityDetector(&unknown, defaultClockRates, DefaultTSDiscontinuityThreshold)
+	start := time.Unix(1_700_000_000, 0)
+	for i, ts := range []uint32{1000, 0x8A3F1200} {
+		packet := makePCMUPacket(uint16(i), ts, 1)
+		packet[1] = 96
+		if jumped, _ := detector.track(packet, start.Add(time.Duration(i)*20*time.Millisecond)); jumped {
+			t.Fatalf("expected payload type 96 without a clock rate to be skipped")
+		}
+	}
+	if unknown.Load() != 0 {
+		t.Fatalf("expected no discontinuity counted, got %d", unknown.Load())
+	}
+}
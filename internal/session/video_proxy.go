@@ -6,55 +6,145 @@ import (
 	"errors"
 	"log/slog"
 	"net"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"rtp-stream-cleaner/internal/logging"
 	"rtp-stream-cleaner/internal/rtpfix"
+	"rtp-stream-cleaner/internal/rtpparse"
 )
 
+// maxInjectAttempts bounds how many times cached SPS/PPS injection is tried
+// for a single frame before it is deferred to the next IDR.
+const maxInjectAttempts = 3
+
 type videoCounters struct {
-	aInPkts             atomic.Uint64
-	aInBytes            atomic.Uint64
-	bOutPkts            atomic.Uint64
-	bOutBytes           atomic.Uint64
-	bInPkts             atomic.Uint64
-	bInBytes            atomic.Uint64
-	aOutPkts            atomic.Uint64
-	aOutBytes           atomic.Uint64
-	videoFramesStarted  atomic.Uint64
-	videoFramesEnded    atomic.Uint64
-	videoFramesFlushed  atomic.Uint64
-	videoForcedFlushes  atomic.Uint64
-	videoInjectedSPS    atomic.Uint64
-	videoInjectedPPS    atomic.Uint64
-	videoSeqDelta       atomic.Uint64
-	videoKeyframes      atomic.Uint64
-	videoNalParseErrors atomic.Uint64
-	videoSeqGaps        atomic.Uint64
-	drops               atomic.Uint64
-	ignoredDisabled     atomic.Uint64
+	aInPkts                atomic.Uint64
+	aInBytes               atomic.Uint64
+	bOutPkts               atomic.Uint64
+	bOutBytes              atomic.Uint64
+	bInPkts                atomic.Uint64
+	bInBytes               atomic.Uint64
+	aOutPkts               atomic.Uint64
+	aOutBytes              atomic.Uint64
+	videoFramesStarted     atomic.Uint64
+	videoFramesEnded       atomic.Uint64
+	videoFramesFlushed     atomic.Uint64
+	videoFramesReordered   atomic.Uint64
+	videoFramesDropped     atomic.Uint64
+	videoPktsDropped       atomic.Uint64
+	videoFramesWithLoss    atomic.Uint64
+	videoSEIDropped        atomic.Uint64
+	videoForcedFlushes     atomic.Uint64
+	videoInjectedVPS       atomic.Uint64
+	videoInjectedSPS       atomic.Uint64
+	videoInjectedPPS       atomic.Uint64
+	videoSPSChanges        atomic.Uint64
+	videoPPSChanges        atomic.Uint64
+	videoSeqDelta          atomic.Uint64
+	videoKeyframes         atomic.Uint64
+	videoNalParseErrors    atomic.Uint64
+	videoSeqGaps           atomic.Uint64
+	videoInjectionRetries  atomic.Uint64
+	videoInjectionFailures atomic.Uint64
+	videoKeyframeRequests  atomic.Uint64
+	videoPLISent           atomic.Uint64
+	videoFIRSent           atomic.Uint64
+	videoBufferOverflows   atomic.Uint64
+	ignoredDisabled        atomic.Uint64
+	keepalivesSent         atomic.Uint64
+	activitySuppressed     atomic.Uint64
+	ssrcChanges            atomic.Uint64
+	peerRelearns           atomic.Uint64
+	peerSwitchesRejected   atomic.Uint64
+	sourceRejected         atomic.Uint64
+	aSeq                   seqCounters
+	aJitter                jitterStats
+	rate                   videoRateStats
+	rtcp                   rtcpCounters
+	dropCounters
 }
 
 type VideoCounters struct {
-	AInPkts            uint64
-	AInBytes           uint64
-	BOutPkts           uint64
-	BOutBytes          uint64
-	BInPkts            uint64
-	BInBytes           uint64
-	AOutPkts           uint64
-	AOutBytes          uint64
-	VideoFramesStarted uint64
-	VideoFramesEnded   uint64
-	VideoFramesFlushed uint64
-	VideoForcedFlushes uint64
-	VideoInjectedSPS   uint64
-	VideoInjectedPPS   uint64
-	VideoSeqDelta      uint64
+	AInPkts                uint64
+	AInBytes               uint64
+	BOutPkts               uint64
+	BOutBytes              uint64
+	BInPkts                uint64
+	BInBytes               uint64
+	AOutPkts               uint64
+	AOutBytes              uint64
+	VideoFramesStarted     uint64
+	VideoFramesEnded       uint64
+	VideoFramesFlushed     uint64
+	VideoFramesReordered   uint64
+	VideoFramesDropped     uint64
+	VideoPktsDropped       uint64
+	VideoFramesWithLoss    uint64
+	VideoSEIDropped        uint64
+	VideoForcedFlushes     uint64
+	VideoInjectedVPS       uint64
+	VideoInjectedSPS       uint64
+	VideoInjectedPPS       uint64
+	VideoSPSChanges        uint64
+	VideoPPSChanges        uint64
+	VideoSeqDelta          uint64
+	VideoInjectionRetries  uint64
+	VideoInjectionFailures uint64
+	VideoKeyframeRequests  uint64
+	VideoPLISent           uint64
+	VideoFIRSent           uint64
+	VideoBufferOverflows   uint64
+	IgnoredDisabled        uint64
+	KeepalivesSent         uint64
+	ActivitySuppressed     uint64
+	SSRCChanges            uint64
+	PeerRelearns           uint64
+	PeerSwitchesRejected   uint64
+	SourceRejected         uint64
+	ASeq                   SeqCounters
+	AJitter                JitterStats
+	Rate                   VideoRateStats
+	RTCP                   RTCPCounters
+	DropCounters
+}
+
+// VideoBufferState is a snapshot of the video fix pipeline: the frame being
+// assembled and which parameter sets are cached for injection or waiting to
+// be prepended to the next frame. It is zero in raw mode.
+type VideoBufferState struct {
+	FramePackets  int
+	FrameAge      time.Duration
+	HasCachedSPS  bool
+	HasCachedPPS  bool
+	HasPendingSPS bool
+	HasPendingPPS bool
+}
+
+// VideoFixConfig tunes the video fix pipeline. A zero BypassErrorThreshold
+// disables the automatic fallback to raw forwarding; a zero BypassCooldown
+// keeps a bypassed session in raw mode until it is re-armed through the API.
+// MaxFrameBufferPackets and MaxFrameBufferBytes cap the frame being
+// assembled, and the bytes cap also the pending SPS/PPS; zero means no cap.
+type VideoFixConfig struct {
+	BypassErrorThreshold  int
+	BypassWindow          time.Duration
+	BypassCooldown        time.Duration
+	MaxFrameBufferPackets int
+	MaxFrameBufferBytes   int
+	// KeyframeRequestRTCPPort sends keyframe requests to the port after the
+	// doorphone's RTP port; KeyframeRequestFIR adds a FIR to the PLI.
+	KeyframeRequestRTCPPort bool
+	KeyframeRequestFIR      bool
 }
 
+const (
+	fixErrorNALParse         = "nal_parse_errors"
+	fixErrorWrite            = "write_errors"
+	fixErrorInjectionFailure = "injection_failures"
+)
+
 type videoProxy struct {
 	session             *Session
 	aConn               *net.UDPConn
@@ -72,28 +162,59 @@ type videoProxy struct {
 	peerMu              sync.RWMutex
 	doorphonePeer       *net.UDPAddr
 	doorphoneLearnedAt  time.Time
+	doorphoneRelearnAt  time.Time
+	doorphoneLastSeen   time.Time
+	peerCandidate       peerCandidate
+	lastSender          *net.UDPAddr
 	lastMissingDestNsec atomic.Int64
-	frameBuffer         [][]byte
+	lastPeerSSRC        atomic.Uint64
+	bufferMu            sync.Mutex
+	frameBuffer         []framePacket
+	receiveBuffer       *packetBuffer
+	frameBufferBytes    int
 	frameBufferStart    time.Time
 	frameBufferActive   bool
+	frameSeqSet         bool
+	frameFirstSeq       uint16
+	frameLastSeq        uint16
+	frameInlineSeqs     []uint16
+	frameStarted        chan struct{}
 	lastFrameSentTime   time.Time
 	frameTS             uint32
 	frameTSInitialized  bool
 	currentFrameTS      uint32
 	currentFrameTSSet   bool
 	fixEnabled          bool
+	fixConfig           VideoFixConfig
+	fixBypassed         bool
+	fixBypassedAt       time.Time
+	fixErrors           map[string][]time.Time
+	pendingVPS          []byte
 	pendingSPS          []byte
 	pendingPPS          []byte
+	cachedVPS           []byte
 	cachedSPS           []byte
 	cachedPPS           []byte
 	injectCachedSPSPPS  bool
+	injectHeader        rtpfix.RTPHeader
+	injectExt           []byte
+	injectVPSPending    bool
+	injectSPSPending    bool
+	injectPPSPending    bool
+	injectAttempts      int
+	forceInjectOnIDR    bool
 	seqDelta            uint16
+	keyframeMu          sync.Mutex
+	lastPLISent         time.Time
+	firSeq              uint8
 	lastOutSeq          uint16
 	hasLastOutSeq       bool
+	rateLimit           *rateLimiter
+	hairpin             hairpinGuard
 	writeToDest         func([]byte, *net.UDPAddr) error
 }
 
-func newVideoProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, fixEnabled, injectCachedSPSPPS bool, logConfig ProxyLogConfig) *videoProxy {
+func newVideoProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWindow, maxFrameWait time.Duration, fixEnabled, injectCachedSPSPPS bool, fixConfig VideoFixConfig, logConfig ProxyLogConfig) *videoProxy {
 	ctx, cancel := context.WithCancel(context.Background())
 	if !fixEnabled {
 		injectCachedSPSPPS = false
@@ -111,8 +232,13 @@ func newVideoProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWind
 		ctx:                ctx,
 		cancel:             cancel,
 		fixEnabled:         fixEnabled,
+		fixConfig:          fixConfig,
 		injectCachedSPSPPS: injectCachedSPSPPS,
-		logger:             logging.WithSessionID(session.ID),
+		rateLimit:          newRateLimiter(session.Settings.VideoMaxKbps, time.Now()),
+		logger:             session.Logger(),
+	}
+	if fixEnabled && maxFrameWait > 0 {
+		proxy.frameStarted = make(chan struct{}, 1)
 	}
 	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
 		if bConn == nil {
@@ -125,7 +251,7 @@ func newVideoProxy(session *Session, aConn, bConn *net.UDPConn, peerLearningWind
 }
 
 func (p *videoProxy) start() {
-	p.wg.Add(2)
+	p.wg.Add(3)
 	go func() {
 		defer p.wg.Done()
 		p.loopAIn()
@@ -134,6 +260,10 @@ func (p *videoProxy) start() {
 		defer p.wg.Done()
 		p.loopBIn()
 	}()
+	go func() {
+		defer p.wg.Done()
+		p.rateLoop()
+	}()
 	if p.statsInterval > 0 {
 		p.wg.Add(1)
 		go func() {
@@ -141,6 +271,20 @@ func (p *videoProxy) start() {
 			p.logStatsLoop()
 		}()
 	}
+	if p.session.keepalive.Interval > 0 {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.session.keepaliveLoop(p.ctx, "video", p.bConn, &p.session.videoCounters.bOutPkts, &p.session.videoCounters.keepalivesSent)
+		}()
+	}
+	if p.frameStarted != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.frameFlushLoop()
+		}()
+	}
 }
 
 func (p *videoProxy) stop() {
@@ -148,15 +292,25 @@ func (p *videoProxy) stop() {
 	_ = p.aConn.SetReadDeadline(time.Now())
 	_ = p.bConn.SetReadDeadline(time.Now())
 	p.wg.Wait()
+	p.flushOnStop()
 	_ = p.aConn.Close()
 	_ = p.bConn.Close()
+	p.logStats(true)
 }
 
 func (p *videoProxy) loopAIn() {
-	buffer := make([]byte, udpReadBufferSize)
+	size := p.session.readBufferSize()
+	received := getPacketBuffer(size)
+	defer func() { putPacketBuffer(received) }()
+	buffer := *received
 	var packetCount uint64
 	var lastSeq uint16
 	var hasLastSeq bool
+	var fuOpen bool
+	var disabled bool
+	seqTracker := newSeqTracker(&p.session.videoCounters.aSeq)
+	jitterTracker := newJitterTracker(&p.session.videoCounters.aJitter, p.session.clockRates, videoClockRate)
+	reader := newPacketReader(p.aConn, size)
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -164,7 +318,7 @@ func (p *videoProxy) loopAIn() {
 		default:
 		}
 		_ = p.aConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		n, addr, err := p.aConn.ReadFromUDP(buffer)
+		n, addr, err := reader.ReadFromUDP(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
@@ -175,33 +329,93 @@ func (p *videoProxy) loopAIn() {
 			p.logger.Error("video a leg read failed", "error", err)
 			continue
 		}
-		p.session.markActivity(time.Now())
-		p.session.videoCounters.aInPkts.Add(1)
+		if p.session.dropTruncated("video", "a", &p.session.videoCounters.dropCounters, n, addr) {
+			continue
+		}
+		if p.session.dropNonRTP("video", "a", &p.session.videoCounters.dropCounters, buffer[:n], addr) {
+			continue
+		}
+		arrival := time.Now()
+		if p.session.refreshesActivity(&p.session.videoSSRCLock, buffer[:n]) {
+			p.session.markMediaActivity(&p.session.videoActivity, "video", arrival)
+		} else {
+			p.session.videoCounters.activitySuppressed.Add(1)
+		}
+		if p.session.videoCounters.aInPkts.Add(1) == 1 {
+			p.session.recordHistory(time.Now(), HistoryFirstPacket, "video", addr.String())
+		}
 		p.session.videoCounters.aInBytes.Add(uint64(n))
+		p.session.capturePacket(CaptureLegAIn, p.aConn, addr, buffer[:n])
 		if !p.session.videoEnabled.Load() {
 			p.session.videoCounters.ignoredDisabled.Add(1)
+			// A frame buffered before the disable must not be flushed into
+			// the stream once video comes back.
+			if !disabled && p.fixEnabled {
+				p.bufferMu.Lock()
+				p.resetFrameBuffer()
+				p.bufferMu.Unlock()
+			}
+			disabled = true
+			continue
+		}
+		disabled = false
+		if p.session.looped(&p.hairpin, buffer[:n], addr, arrival) {
+			p.session.videoCounters.ignoredDisabled.Add(1)
+			p.session.disableHairpin("video", &p.session.videoEnabled, &p.session.videoDisabledReason, addr, arrival)
 			continue
 		}
-		header, headerOK, seqGap := p.trackSeqGap(buffer[:n], &lastSeq, &hasLastSeq)
-		p.logPacketIfNeeded("a->b", header, headerOK, seqGap, n, &packetCount)
-		if p.fixEnabled {
-			p.analyzeFrameBoundaries(buffer[:n])
+		if rtpparse.IsRTCP(buffer[:n]) {
+			p.session.videoCounters.rtcp.muxPkts.Add(1)
+			p.forwardMuxedRTCP(buffer[:n], addr)
+			continue
+		}
+		seqTracker.track(buffer[:n])
+		jitterTracker.track(buffer[:n], arrival)
+		// A foreign stream must not reach the fixer's gap tracking or the
+		// frame buffer.
+		if !p.session.admitSSRC(&p.session.videoSSRCLock, buffer[:n]) {
+			p.session.videoCounters.drop(dropForeignSSRC)
+			continue
 		}
+		header, headerOK, seqGap := p.trackSeqGap(buffer[:n], &lastSeq, &hasLastSeq)
+		p.logPacketIfNeeded("a->b", buffer[:n], header, headerOK, seqGap, &packetCount, &fuOpen)
+		fixActive := p.fixActive(time.Now())
+		p.analyzeFrameBoundaries(buffer[:n], arrival, fixActive)
 		if !p.updateDoorphonePeer(addr) {
-			p.session.videoCounters.drops.Add(1)
+			p.session.videoCounters.drop(dropPeerRejected)
 			continue
 		}
+		if headerOK {
+			p.recordPeerSSRC(header.SSRC)
+		}
 		dest := p.session.videoDest.Load()
 		if dest == nil {
 			if p.fixEnabled {
+				p.bufferMu.Lock()
 				p.resetFrameBuffer()
+				p.bufferMu.Unlock()
 			}
 			p.logMissingDest()
-			p.session.videoCounters.drops.Add(1)
+			p.session.videoCounters.drop(dropNoDest)
 			continue
 		}
-		if p.fixEnabled {
+		if fixActive {
+			p.bufferMu.Lock()
+			p.receiveBuffer = received
 			p.handleVideoPacket(buffer[:n], dest)
+			if p.receiveBuffer == nil {
+				// The frame buffer kept the packet in place.
+				received = getPacketBuffer(size)
+				buffer = *received
+			}
+			p.receiveBuffer = nil
+			p.bufferMu.Unlock()
+			continue
+		}
+		if p.fixEnabled {
+			p.bufferMu.Lock()
+			p.forwardBypassedPacket(buffer[:n], dest)
+			p.bufferMu.Unlock()
 			continue
 		}
 		p.forwardRawPacket(buffer[:n], dest)
@@ -209,10 +423,12 @@ func (p *videoProxy) loopAIn() {
 }
 
 func (p *videoProxy) loopBIn() {
-	buffer := make([]byte, udpReadBufferSize)
+	buffer := make([]byte, p.session.readBufferSize())
 	var packetCount uint64
 	var lastSeq uint16
 	var hasLastSeq bool
+	var fuOpen bool
+	reader := newPacketReader(p.bConn, len(buffer))
 	for {
 		select {
 		case <-p.ctx.Done():
@@ -220,7 +436,7 @@ func (p *videoProxy) loopBIn() {
 		default:
 		}
 		_ = p.bConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-		n, addr, err := p.bConn.ReadFromUDP(buffer)
+		n, addr, err := reader.ReadFromUDP(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
@@ -231,35 +447,72 @@ func (p *videoProxy) loopBIn() {
 			p.logger.Error("video b leg read failed", "error", err)
 			continue
 		}
-		p.session.markActivity(time.Now())
+		if p.session.dropTruncated("video", "b", &p.session.videoCounters.dropCounters, n, addr) {
+			continue
+		}
+		if p.session.dropNonRTP("video", "b", &p.session.videoCounters.dropCounters, buffer[:n], addr) {
+			continue
+		}
+		if p.session.refreshesActivity(nil, buffer[:n]) {
+			p.session.markMediaActivity(&p.session.videoActivity, "video", time.Now())
+		} else {
+			p.session.videoCounters.activitySuppressed.Add(1)
+		}
 		if !p.session.videoEnabled.Load() {
 			p.session.videoCounters.ignoredDisabled.Add(1)
 			continue
 		}
 		dest := p.session.videoDest.Load()
-		if dest == nil || !dest.IP.Equal(addr.IP) {
-			p.session.videoCounters.drops.Add(1)
+		if dest == nil {
+			p.session.videoCounters.drop(dropNoDest)
+			continue
+		}
+		if !p.session.fromRTPEngine(addr, dest.IP, dest.Port) {
+			p.session.videoCounters.drop(dropBSourceMismatch)
 			continue
 		}
 		p.session.videoCounters.bInPkts.Add(1)
 		p.session.videoCounters.bInBytes.Add(uint64(n))
-		header, headerOK, seqGap := p.trackSeqGap(buffer[:n], &lastSeq, &hasLastSeq)
-		p.logPacketIfNeeded("b->a", header, headerOK, seqGap, n, &packetCount)
+		p.session.capturePacket(CaptureLegBIn, p.bConn, addr, buffer[:n])
+		if rtpparse.IsRTCP(buffer[:n]) {
+			p.session.videoCounters.rtcp.muxPkts.Add(1)
+		} else {
+			header, headerOK, seqGap := p.trackSeqGap(buffer[:n], &lastSeq, &hasLastSeq)
+			p.logPacketIfNeeded("b->a", buffer[:n], header, headerOK, seqGap, &packetCount, &fuOpen)
+		}
 		peer := p.getDoorphonePeer()
 		if peer == nil {
-			p.session.videoCounters.drops.Add(1)
+			p.session.videoCounters.drop(dropNoPeer)
 			continue
 		}
 		if _, err := p.aConn.WriteToUDP(buffer[:n], peer); err != nil {
 			p.logger.Error("video a leg write failed", "error", err)
-			p.session.videoCounters.drops.Add(1)
+			p.session.videoCounters.drop(dropWriteError)
 			continue
 		}
 		p.session.videoCounters.aOutPkts.Add(1)
 		p.session.videoCounters.aOutBytes.Add(uint64(n))
+		p.session.capturePacket(CaptureLegAOut, p.aConn, peer, buffer[:n])
 	}
 }
 
+// forwardMuxedRTCP relays RTCP that the doorphone multiplexed on the RTP
+// port untouched, bypassing the frame buffer and the sequence rewriting of
+// fix mode.
+func (p *videoProxy) forwardMuxedRTCP(packet []byte, addr *net.UDPAddr) {
+	if !p.updateDoorphonePeer(addr) {
+		p.session.videoCounters.drop(dropPeerRejected)
+		return
+	}
+	dest := p.session.videoDest.Load()
+	if dest == nil {
+		p.logMissingDest()
+		p.session.videoCounters.drop(dropNoDest)
+		return
+	}
+	p.forwardRawPacket(packet, dest)
+}
+
 func (p *videoProxy) updateDoorphonePeer(addr *net.UDPAddr) bool {
 	if addr == nil {
 		return false
@@ -267,19 +520,64 @@ func (p *videoProxy) updateDoorphonePeer(addr *net.UDPAddr) bool {
 	p.peerMu.Lock()
 	defer p.peerMu.Unlock()
 	now := time.Now()
-	if p.doorphonePeer == nil {
-		p.doorphonePeer = cloneUDPAddr(addr)
+	if !sameUDPAddr(p.lastSender, addr) {
+		p.lastSender = cloneUDPAddr(addr)
+	}
+	if source := p.session.videoSource.Load(); source != nil {
+		return p.admitPinnedSource(source, addr, now)
+	}
+	decision := decidePeer(p.doorphonePeer, addr, p.doorphoneLearnedAt, p.doorphoneLastSeen, now, p.peerLearningWindow, p.session.relearnAfter)
+	switch decision {
+	case peerAccept:
+		p.peerCandidate.reset()
+	case peerChange, peerRelearn:
+		if !p.peerCandidate.observe(addr, now, p.session.peerSwitchPackets) {
+			p.session.videoCounters.peerSwitchesRejected.Add(1)
+			return false
+		}
+	}
+	switch decision {
+	case peerReject:
+		return false
+	case peerLearn:
 		p.doorphoneLearnedAt = now
-		return true
+		p.session.recordHistory(now, HistoryPeerLearned, "video", addr.String())
+	case peerChange:
+		p.doorphoneRelearnAt = now
+		p.session.recordHistory(now, HistoryPeerChanged, "video", addr.String())
+	case peerRelearn:
+		p.doorphoneRelearnAt = now
+		p.session.videoCounters.peerRelearns.Add(1)
+		p.session.recordHistory(now, HistoryPeerRelearned, "video", addr.String())
+		p.logger.Info("video.peer.relearned",
+			"old_peer", p.doorphonePeer.String(),
+			"new_peer", addr.String(),
+			"silent_ms", now.Sub(p.doorphoneLastSeen).Milliseconds(),
+		)
 	}
-	if p.doorphonePeer.IP.Equal(addr.IP) && p.doorphonePeer.Port == addr.Port {
-		return true
+	if decision != peerAccept {
+		p.doorphonePeer = cloneUDPAddr(addr)
+	}
+	p.doorphoneLastSeen = now
+	return true
+}
+
+// admitPinnedSource takes the place of peer learning while the media has a
+// pinned source. Packets from elsewhere are rejected, and the peer follows
+// the sender so that B-leg packets return to the port it sends from.
+func (p *videoProxy) admitPinnedSource(source, addr *net.UDPAddr, now time.Time) bool {
+	if !sourceMatches(source, addr) {
+		p.session.videoCounters.sourceRejected.Add(1)
+		return false
 	}
-	if now.Sub(p.doorphoneLearnedAt) <= p.peerLearningWindow {
+	if p.doorphonePeer == nil {
+		p.doorphoneLearnedAt = now
+	}
+	if !sameUDPAddr(p.doorphonePeer, addr) {
 		p.doorphonePeer = cloneUDPAddr(addr)
-		return true
 	}
-	return false
+	p.doorphoneLastSeen = now
+	return true
 }
 
 func (p *videoProxy) getDoorphonePeer() *net.UDPAddr {
@@ -288,6 +586,17 @@ func (p *videoProxy) getDoorphonePeer() *net.UDPAddr {
 	return cloneUDPAddr(p.doorphonePeer)
 }
 
+func (p *videoProxy) doorphonePeerState() doorphonePeerState {
+	p.peerMu.RLock()
+	defer p.peerMu.RUnlock()
+	return doorphonePeerState{
+		peer:        cloneUDPAddr(p.doorphonePeer),
+		learnedAt:   p.doorphoneLearnedAt,
+		relearnedAt: p.doorphoneRelearnAt,
+		lastSender:  cloneUDPAddr(p.lastSender),
+	}
+}
+
 func (p *videoProxy) logMissingDest() {
 	now := time.Now().UnixNano()
 	last := p.lastMissingDestNsec.Load()
@@ -307,7 +616,6 @@ func (p *videoProxy) logStatsLoop() {
 		case <-ticker.C:
 			p.logStats(false)
 		case <-p.ctx.Done():
-			p.logStats(true)
 			return
 		}
 	}
@@ -315,18 +623,33 @@ func (p *videoProxy) logStatsLoop() {
 
 func (p *videoProxy) logStats(final bool) {
 	counters := &p.session.videoCounters
-	pktsIn := counters.aInPkts.Load() + counters.bInPkts.Load()
-	pktsOut := counters.aOutPkts.Load() + counters.bOutPkts.Load()
-	bytesIn := counters.aInBytes.Load() + counters.bInBytes.Load()
-	bytesOut := counters.aOutBytes.Load() + counters.bOutBytes.Load()
+	aInPkts, aInBytes := counters.aInPkts.Load(), counters.aInBytes.Load()
+	bOutPkts, bOutBytes := counters.bOutPkts.Load(), counters.bOutBytes.Load()
+	bInPkts, bInBytes := counters.bInPkts.Load(), counters.bInBytes.Load()
+	aOutPkts, aOutBytes := counters.aOutPkts.Load(), counters.aOutBytes.Load()
 	drops := counters.drops.Load()
 	ignoredDisabled := counters.ignoredDisabled.Load()
+	activitySuppressed := counters.activitySuppressed.Load()
 	frames := counters.videoFramesStarted.Load()
+	framesEnded := counters.videoFramesEnded.Load()
+	framesFlushed := counters.videoFramesFlushed.Load()
 	keyframes := counters.videoKeyframes.Load()
-	spsPpsInjected := counters.videoInjectedSPS.Load() + counters.videoInjectedPPS.Load()
+	injectedVPS := counters.videoInjectedVPS.Load()
+	injectedSPS := counters.videoInjectedSPS.Load()
+	injectedPPS := counters.videoInjectedPPS.Load()
+	spsChanges := counters.videoSPSChanges.Load()
+	ppsChanges := counters.videoPPSChanges.Load()
 	forcedFlushes := counters.videoForcedFlushes.Load()
 	nalParseErrors := counters.videoNalParseErrors.Load()
 	seqGaps := counters.videoSeqGaps.Load()
+	seqDelta := counters.videoSeqDelta.Load()
+	aSeq := counters.aSeq.snapshot()
+	aJitter := counters.aJitter.snapshot()
+	rate := counters.rate.snapshot()
+	lastIDRAge := -1.0
+	if !rate.LastIDR.IsZero() {
+		lastIDRAge = roundRate(time.Since(rate.LastIDR).Seconds())
+	}
 	enabled := p.session.videoEnabled.Load()
 	disabledReason := loadAtomicString(&p.session.videoDisabledReason)
 	if enabled {
@@ -334,39 +657,97 @@ func (p *videoProxy) logStats(final bool) {
 	}
 	if final {
 		p.logger.Info("video.proxy.stats",
-			"pkts_in", pktsIn,
-			"pkts_out", pktsOut,
-			"bytes_in", bytesIn,
-			"bytes_out", bytesOut,
+			"pkts_in", aInPkts+bInPkts,
+			"pkts_out", aOutPkts+bOutPkts,
+			"bytes_in", aInBytes+bInBytes,
+			"bytes_out", aOutBytes+bOutBytes,
+			"a_in_pkts", aInPkts,
+			"a_in_bytes", aInBytes,
+			"b_out_pkts", bOutPkts,
+			"b_out_bytes", bOutBytes,
+			"b_in_pkts", bInPkts,
+			"b_in_bytes", bInBytes,
+			"a_out_pkts", aOutPkts,
+			"a_out_bytes", aOutBytes,
 			"drops", drops,
 			"ignored_disabled", ignoredDisabled,
+			"activity_suppressed", activitySuppressed,
 			"enabled", enabled,
 			"disabled_reason", disabledReason,
+			"fix_enabled", p.fixEnabled,
+			"fix_bypassed", p.session.videoFixBypassed.Load(),
 			"frames", frames,
+			"frames_ended", framesEnded,
+			"frames_flushed", framesFlushed,
 			"keyframes", keyframes,
-			"sps_pps_injected", spsPpsInjected,
+			"sps_pps_injected", injectedSPS+injectedPPS,
+			"injected_vps", injectedVPS,
+			"injected_sps", injectedSPS,
+			"injected_pps", injectedPPS,
+			"sps_changes", spsChanges,
+			"pps_changes", ppsChanges,
 			"forced_flushes", forcedFlushes,
 			"nal_parse_errors", nalParseErrors,
 			"seq_gaps", seqGaps,
+			"seq_delta", seqDelta,
+			"a_seq_gaps", aSeq.Gaps,
+			"a_reordered", aSeq.Reordered,
+			"a_duplicates", aSeq.Duplicates,
+			"a_jitter_ms", aJitter.CurrentMS,
+			"a_jitter_max_ms", aJitter.MaxMS,
+			"bitrate_bps", rate.BitrateBps,
+			"fps", rate.FPS,
+			"avg_frame_pkts", rate.AvgFramePackets,
+			"avg_frame_bytes", rate.AvgFrameBytes,
+			"last_idr_age_sec", lastIDRAge,
 			"final", true,
 		)
 		return
 	}
 	p.logger.Info("video.proxy.stats",
-		"pkts_in", pktsIn,
-		"pkts_out", pktsOut,
-		"bytes_in", bytesIn,
-		"bytes_out", bytesOut,
+		"pkts_in", aInPkts+bInPkts,
+		"pkts_out", aOutPkts+bOutPkts,
+		"bytes_in", aInBytes+bInBytes,
+		"bytes_out", aOutBytes+bOutBytes,
+		"a_in_pkts", aInPkts,
+		"a_in_bytes", aInBytes,
+		"b_out_pkts", bOutPkts,
+		"b_out_bytes", bOutBytes,
+		"b_in_pkts", bInPkts,
+		"b_in_bytes", bInBytes,
+		"a_out_pkts", aOutPkts,
+		"a_out_bytes", aOutBytes,
 		"drops", drops,
 		"ignored_disabled", ignoredDisabled,
+		"activity_suppressed", activitySuppressed,
 		"enabled", enabled,
 		"disabled_reason", disabledReason,
+		"fix_enabled", p.fixEnabled,
+		"fix_bypassed", p.session.videoFixBypassed.Load(),
 		"frames", frames,
+		"frames_ended", framesEnded,
+		"frames_flushed", framesFlushed,
 		"keyframes", keyframes,
-		"sps_pps_injected", spsPpsInjected,
+		"sps_pps_injected", injectedSPS+injectedPPS,
+		"injected_vps", injectedVPS,
+		"injected_sps", injectedSPS,
+		"injected_pps", injectedPPS,
+		"sps_changes", spsChanges,
+		"pps_changes", ppsChanges,
 		"forced_flushes", forcedFlushes,
 		"nal_parse_errors", nalParseErrors,
 		"seq_gaps", seqGaps,
+		"seq_delta", seqDelta,
+		"a_seq_gaps", aSeq.Gaps,
+		"a_reordered", aSeq.Reordered,
+		"a_duplicates", aSeq.Duplicates,
+		"a_jitter_ms", aJitter.CurrentMS,
+		"a_jitter_max_ms", aJitter.MaxMS,
+		"bitrate_bps", rate.BitrateBps,
+		"fps", rate.FPS,
+		"avg_frame_pkts", rate.AvgFramePackets,
+		"avg_frame_bytes", rate.AvgFrameBytes,
+		"last_idr_age_sec", lastIDRAge,
 	)
 }
 
@@ -375,38 +756,86 @@ func snapshotVideoCounters(counters *videoCounters) VideoCounters {
 		return VideoCounters{}
 	}
 	return VideoCounters{
-		AInPkts:            counters.aInPkts.Load(),
-		AInBytes:           counters.aInBytes.Load(),
-		BOutPkts:           counters.bOutPkts.Load(),
-		BOutBytes:          counters.bOutBytes.Load(),
-		BInPkts:            counters.bInPkts.Load(),
-		BInBytes:           counters.bInBytes.Load(),
-		AOutPkts:           counters.aOutPkts.Load(),
-		AOutBytes:          counters.aOutBytes.Load(),
-		VideoFramesStarted: counters.videoFramesStarted.Load(),
-		VideoFramesEnded:   counters.videoFramesEnded.Load(),
-		VideoFramesFlushed: counters.videoFramesFlushed.Load(),
-		VideoForcedFlushes: counters.videoForcedFlushes.Load(),
-		VideoInjectedSPS:   counters.videoInjectedSPS.Load(),
-		VideoInjectedPPS:   counters.videoInjectedPPS.Load(),
-		VideoSeqDelta:      counters.videoSeqDelta.Load(),
-	}
-}
-
-func (p *videoProxy) analyzeFrameBoundaries(packet []byte) {
+		AInPkts:                counters.aInPkts.Load(),
+		AInBytes:               counters.aInBytes.Load(),
+		BOutPkts:               counters.bOutPkts.Load(),
+		BOutBytes:              counters.bOutBytes.Load(),
+		BInPkts:                counters.bInPkts.Load(),
+		BInBytes:               counters.bInBytes.Load(),
+		AOutPkts:               counters.aOutPkts.Load(),
+		AOutBytes:              counters.aOutBytes.Load(),
+		VideoFramesStarted:     counters.videoFramesStarted.Load(),
+		VideoFramesEnded:       counters.videoFramesEnded.Load(),
+		VideoFramesFlushed:     counters.videoFramesFlushed.Load(),
+		VideoFramesReordered:   counters.videoFramesReordered.Load(),
+		VideoFramesDropped:     counters.videoFramesDropped.Load(),
+		VideoPktsDropped:       counters.videoPktsDropped.Load(),
+		VideoFramesWithLoss:    counters.videoFramesWithLoss.Load(),
+		VideoSEIDropped:        counters.videoSEIDropped.Load(),
+		VideoForcedFlushes:     counters.videoForcedFlushes.Load(),
+		VideoInjectedVPS:       counters.videoInjectedVPS.Load(),
+		VideoInjectedSPS:       counters.videoInjectedSPS.Load(),
+		VideoInjectedPPS:       counters.videoInjectedPPS.Load(),
+		VideoSPSChanges:        counters.videoSPSChanges.Load(),
+		VideoPPSChanges:        counters.videoPPSChanges.Load(),
+		VideoSeqDelta:          counters.videoSeqDelta.Load(),
+		VideoInjectionRetries:  counters.videoInjectionRetries.Load(),
+		VideoInjectionFailures: counters.videoInjectionFailures.Load(),
+		VideoKeyframeRequests:  counters.videoKeyframeRequests.Load(),
+		VideoPLISent:           counters.videoPLISent.Load(),
+		VideoFIRSent:           counters.videoFIRSent.Load(),
+		VideoBufferOverflows:   counters.videoBufferOverflows.Load(),
+		IgnoredDisabled:        counters.ignoredDisabled.Load(),
+		KeepalivesSent:         counters.keepalivesSent.Load(),
+		ActivitySuppressed:     counters.activitySuppressed.Load(),
+		SSRCChanges:            counters.ssrcChanges.Load(),
+		PeerRelearns:           counters.peerRelearns.Load(),
+		PeerSwitchesRejected:   counters.peerSwitchesRejected.Load(),
+		SourceRejected:         counters.sourceRejected.Load(),
+		ASeq:                   counters.aSeq.snapshot(),
+		AJitter:                counters.aJitter.snapshot(),
+		Rate:                   counters.rate.snapshot(),
+		RTCP:                   counters.rtcp.snapshot(),
+		DropCounters:           counters.dropCounters.snapshot(),
+	}
+}
+
+// frameBufferState reports what the fix pipeline is holding. loopAIn mutates
+// this state under bufferMu so that the API can read it while packets flow.
+func (p *videoProxy) frameBufferState(now time.Time) VideoBufferState {
+	if !p.fixEnabled {
+		return VideoBufferState{}
+	}
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	state := VideoBufferState{
+		HasCachedSPS:  p.cachedSPS != nil,
+		HasCachedPPS:  p.cachedPPS != nil,
+		HasPendingSPS: p.pendingSPS != nil,
+		HasPendingPPS: p.pendingPPS != nil,
+	}
+	if p.frameBufferActive {
+		state.FramePackets = len(p.frameBuffer)
+		state.FrameAge = now.Sub(p.frameBufferStart)
+	}
+	return state
+}
+
+// analyzeFrameBoundaries is the lightweight pass over every A-leg packet that
+// feeds the rate stats, in raw mode too. The frame counters of the fix
+// pipeline only move with fixActive.
+func (p *videoProxy) analyzeFrameBoundaries(packet []byte, now time.Time, fixActive bool) {
 	header, ok := rtpfix.ParseRTPHeader(packet)
 	if !ok {
 		return
 	}
-	if header.HeaderLen >= len(packet) {
+	info, ok := p.parseNAL(header.Payload(packet))
+	frameStart := ok && rtpfix.IsFrameStart(info)
+	p.session.videoCounters.rate.add(len(packet), frameStart, frameStart && info.IsIDR, now)
+	if !ok || !fixActive {
 		return
 	}
-	payload := packet[header.HeaderLen:]
-	info, ok := rtpfix.ParseH264(payload)
-	if !ok {
-		return
-	}
-	if rtpfix.IsFrameStart(info) {
+	if frameStart {
 		p.session.videoCounters.videoFramesStarted.Add(1)
 		if info.IsIDR {
 			p.session.videoCounters.videoKeyframes.Add(1)
@@ -418,69 +847,142 @@ func (p *videoProxy) analyzeFrameBoundaries(packet []byte) {
 }
 
 func (p *videoProxy) handleVideoPacket(packet []byte, dest *net.UDPAddr) {
-	packetInfo, ok, headerOK := parseH264PacketDetailed(packet)
+	packetInfo, ok, headerOK := p.parseH264PacketDetailed(packet)
+	if ok && packetInfo.info.HasStartCode && p.session.Settings.StripAnnexB {
+		packet = stripStartCode(packet, packetInfo)
+		packetInfo, ok, headerOK = p.parseH264PacketDetailed(packet)
+	}
+	if ok && packetInfo.info.IsSEI && p.session.Settings.DropSEI {
+		if packet, ok = p.stripSEI(packet, packetInfo); !ok {
+			p.trackInlineFrameSeq(packetInfo.header.Seq)
+			return
+		}
+		packetInfo, ok, headerOK = p.parseH264PacketDetailed(packet)
+	}
 	if ok {
 		now := time.Now()
 		if packetInfo.info.IsSlice {
 			p.flushOnTimeout(now, dest)
 			if rtpfix.IsFrameStart(packetInfo.info) {
 				if p.frameBufferActive && len(p.frameBuffer) > 0 {
-					p.flushFrameBuffer(now, dest, false)
+					p.endIncompleteFrame(now, dest, false)
 				}
 				p.startFrameBuffer(now, packet)
-				if packetInfo.info.IsIDR {
-					p.injectCachedParameterSets(packetInfo.header, dest)
+				if isParameterSet(packetInfo.info) {
+					// A STAP-A carrying its own parameter sets ahead of the
+					// slice needs no injection.
+					p.cacheParameterSet(packetInfo.payload)
+				} else if packetInfo.info.IsIDR {
+					p.injectCachedParameterSets(packet, packetInfo.header, dest)
 				}
 				p.appendPendingToFrameBuffer()
 			}
 			if p.frameBufferActive {
-				p.bufferFramePacket(packet)
+				p.trackFrameSeq(packetInfo.header.Seq)
+				p.bufferFramePacket(now, dest, packet)
 				if rtpfix.IsFrameEnd(packetInfo.info) {
 					p.flushFrameBuffer(now, dest, false)
 				}
 				return
 			}
 		}
-		if packetInfo.info.IsSPS || packetInfo.info.IsPPS {
-			p.cacheParameterSet(packetInfo.payload, packetInfo.info.IsSPS)
+		if isParameterSet(packetInfo.info) {
+			p.cacheParameterSet(packetInfo.payload)
 			p.flushOnTimeout(now, dest)
 			if p.frameBufferActive {
-				p.bufferFramePacket(packet)
+				p.trackFrameSeq(packetInfo.header.Seq)
+				p.bufferFramePacket(now, dest, packet)
 			} else {
-				p.storePendingParameterSet(packet, packetInfo.info.IsSPS)
+				p.storePendingParameterSet(packet)
+				p.capPendingParameterSets(dest)
 			}
 			return
 		}
 	}
 	if headerOK {
 		p.session.videoCounters.videoNalParseErrors.Add(1)
-		p.logPacketAnomaly("a->b", packet)
+		p.recordFixError(fixErrorNALParse)
 	}
 	p.flushOnTimeout(time.Now(), dest)
+	if headerOK {
+		p.trackInlineFrameSeq(packetInfo.header.Seq)
+	}
 	p.sendPacket(packet, dest)
 }
 
+// stripSEI removes the SEI NAL units of a packet under DropSEI and counts
+// them. A single SEI is dropped whole; a STAP-A is rebuilt from its other
+// units, without the padding of the original. It returns the packet to
+// forward, or false when nothing is left of it. The outbound sequence
+// rewriting closes the gap.
+func (p *videoProxy) stripSEI(packet []byte, packetInfo h264Packet) ([]byte, bool) {
+	if !packetInfo.info.IsAggregate {
+		p.session.videoCounters.videoSEIDropped.Add(1)
+		return nil, false
+	}
+	units, _ := rtpfix.SplitSTAPA(packetInfo.payload)
+	kept := units[:0]
+	for _, unit := range units {
+		if info, ok := rtpfix.ParseH264(unit); ok && info.IsSEI {
+			continue
+		}
+		kept = append(kept, unit)
+	}
+	p.session.videoCounters.videoSEIDropped.Add(uint64(len(units) - len(kept)))
+	if len(kept) == 0 {
+		return nil, false
+	}
+	stripped := make([]byte, packetInfo.header.HeaderLen, packetInfo.header.HeaderLen+len(packetInfo.payload))
+	copy(stripped, packet[:packetInfo.header.HeaderLen])
+	stripped[0] &^= 0x20
+	return append(stripped, rtpfix.BuildSTAPA(packetInfo.payload[0], kept)...), true
+}
+
+// stripStartCode returns packet without the Annex B start code ahead of its
+// NAL unit under StripAnnexB, and without the padding of the original.
+func stripStartCode(packet []byte, packetInfo h264Packet) []byte {
+	nal := packetInfo.payload[packetInfo.info.NALOffset:]
+	stripped := make([]byte, packetInfo.header.HeaderLen, packetInfo.header.HeaderLen+len(nal))
+	copy(stripped, packet[:packetInfo.header.HeaderLen])
+	stripped[0] &^= 0x20
+	return append(stripped, nal...)
+}
+
 type h264Packet struct {
 	header  rtpfix.RTPHeader
 	payload []byte
 	info    rtpfix.H264Info
 }
 
-func parseH264Packet(packet []byte) (h264Packet, bool) {
-	packetInfo, ok, _ := parseH264PacketDetailed(packet)
+// parseNAL classifies a video payload with the parser of the session's codec.
+func (p *videoProxy) parseNAL(payload []byte) (rtpfix.H264Info, bool) {
+	if p.session.Settings.VideoCodec == VideoCodecH265 {
+		return rtpfix.ParseH265(payload)
+	}
+	return rtpfix.ParseH264(payload)
+}
+
+// isParameterSet reports whether info is a VPS, SPS or PPS, which the fixer
+// caches and keeps ahead of the next frame.
+func isParameterSet(info rtpfix.H264Info) bool {
+	return info.IsVPS || info.IsSPS || info.IsPPS
+}
+
+func (p *videoProxy) parseH264Packet(packet []byte) (h264Packet, bool) {
+	packetInfo, ok, _ := p.parseH264PacketDetailed(packet)
 	return packetInfo, ok
 }
 
-func parseH264PacketDetailed(packet []byte) (h264Packet, bool, bool) {
+func (p *videoProxy) parseH264PacketDetailed(packet []byte) (h264Packet, bool, bool) {
 	header, ok := rtpfix.ParseRTPHeader(packet)
 	if !ok {
 		return h264Packet{}, false, false
 	}
-	if header.HeaderLen >= len(packet) {
+	payload := header.Payload(packet)
+	if len(payload) == 0 {
 		return h264Packet{}, false, false
 	}
-	payload := packet[header.HeaderLen:]
-	info, ok := rtpfix.ParseH264(payload)
+	info, ok := p.parseNAL(payload)
 	if !ok {
 		return h264Packet{
 			header:  header,
@@ -495,46 +997,231 @@ func parseH264PacketDetailed(packet []byte) (h264Packet, bool, bool) {
 }
 
 func (p *videoProxy) startFrameBuffer(now time.Time, seedPacket []byte) {
-	p.frameBuffer = p.frameBuffer[:0]
+	p.clearFrameBuffer()
 	p.frameBufferStart = now
 	p.frameBufferActive = true
+	p.frameSeqSet = false
+	p.frameInlineSeqs = p.frameInlineSeqs[:0]
 	p.currentFrameTS = p.nextFrameTimestamp(now, seedPacket)
 	p.currentFrameTSSet = true
+	select {
+	case p.frameStarted <- struct{}{}:
+	default:
+	}
 }
 
-func (p *videoProxy) bufferFramePacket(packet []byte) {
-	clone := make([]byte, len(packet))
-	copy(clone, packet)
-	p.frameBuffer = append(p.frameBuffer, clone)
+// trackFrameSeq records the sequence number of a packet of the frame being
+// assembled, keeping the first and last one seen. A packet further
+// ahead than the next expected one leaves a gap, which a late packet may
+// still fill before the frame ends.
+func (p *videoProxy) trackFrameSeq(seq uint16) {
+	if !p.frameSeqSet {
+		p.frameFirstSeq, p.frameLastSeq = seq, seq
+		p.frameSeqSet = true
+		return
+	}
+	switch {
+	case rtpfix.SeqDiff(seq, p.frameLastSeq) > 1:
+		if p.packetLog {
+			p.logger.Debug("video.proxy.frame.seq_gap", "seq", seq, "expected", p.frameLastSeq+1, "gap", seq-p.frameLastSeq-1)
+		}
+		p.frameLastSeq = seq
+	case rtpfix.SeqLess(p.frameLastSeq, seq):
+		p.frameLastSeq = seq
+	case rtpfix.SeqLess(seq, p.frameFirstSeq):
+		p.frameFirstSeq = seq
+	}
 }
 
-func (p *videoProxy) storePendingParameterSet(packet []byte, isSPS bool) {
+// trackInlineFrameSeq records the sequence number of a packet that arrives
+// while a frame is being assembled but is not buffered with it, such as an
+// SEI or AUD forwarded at once or an SEI dropped under DropSEI, so that the
+// frame is not taken for lossy.
+func (p *videoProxy) trackInlineFrameSeq(seq uint16) {
+	if !p.frameBufferActive {
+		return
+	}
+	p.trackFrameSeq(seq)
+	p.frameInlineSeqs = append(p.frameInlineSeqs, seq)
+}
+
+// frameLoss returns how many packets of the frame being assembled are
+// missing between its first and last one, and counts a frame with loss.
+func (p *videoProxy) frameLoss() int {
+	if !p.frameSeqSet {
+		return 0
+	}
+	p.frameBuffer, _ = orderFramePackets(p.frameBuffer)
+	inFrame := func(seq uint16) bool {
+		return !rtpfix.SeqLess(seq, p.frameFirstSeq) && !rtpfix.SeqLess(p.frameLastSeq, seq)
+	}
+	received := make(map[uint16]struct{}, len(p.frameBuffer)+len(p.frameInlineSeqs))
+	for _, packet := range p.frameBuffer {
+		if seq := binary.BigEndian.Uint16(packet.data[2:4]); inFrame(seq) {
+			received[seq] = struct{}{}
+		}
+	}
+	for _, seq := range p.frameInlineSeqs {
+		if inFrame(seq) {
+			received[seq] = struct{}{}
+		}
+	}
+	missing := int(p.frameLastSeq-p.frameFirstSeq) + 1 - len(received)
+	if missing <= 0 {
+		return 0
+	}
+	p.session.videoCounters.videoFramesWithLoss.Add(1)
+	return missing
+}
+
+// bufferFramePacket adds packet to the frame being assembled. A frame that
+// grows past MaxFrameBufferPackets or MaxFrameBufferBytes, e.g. because the
+// doorphone never sets the FU end bit, is flushed at once.
+func (p *videoProxy) bufferFramePacket(now time.Time, dest *net.UDPAddr, packet []byte) {
+	p.frameBuffer = append(p.frameBuffer, p.holdFramePacket(packet))
+	p.frameBufferBytes += len(packet)
+	maxPackets, maxBytes := p.fixConfig.MaxFrameBufferPackets, p.fixConfig.MaxFrameBufferBytes
+	if (maxPackets > 0 && len(p.frameBuffer) > maxPackets) || (maxBytes > 0 && p.frameBufferBytes > maxBytes) {
+		p.session.videoCounters.videoBufferOverflows.Add(1)
+		p.flushFrameBuffer(now, dest, true)
+	}
+}
+
+// storePendingParameterSet holds the VPS, SPS or PPS packet for the next
+// frame, replacing an earlier one of the same kind.
+func (p *videoProxy) storePendingParameterSet(packet []byte) {
+	packetInfo, ok := p.parseH264Packet(packet)
+	if !ok {
+		return
+	}
 	clone := make([]byte, len(packet))
 	copy(clone, packet)
-	if isSPS {
+	switch {
+	case packetInfo.info.IsVPS:
+		p.pendingVPS = clone
+	case packetInfo.info.IsSPS:
 		p.pendingSPS = clone
+	case packetInfo.info.IsPPS:
+		p.pendingPPS = clone
+	}
+}
+
+// capPendingParameterSets sends the pending parameter sets at once when
+// together they exceed MaxFrameBufferBytes.
+func (p *videoProxy) capPendingParameterSets(dest *net.UDPAddr) {
+	maxBytes := p.fixConfig.MaxFrameBufferBytes
+	if maxBytes <= 0 || len(p.pendingVPS)+len(p.pendingSPS)+len(p.pendingPPS) <= maxBytes {
 		return
 	}
-	p.pendingPPS = clone
+	p.session.videoCounters.videoBufferOverflows.Add(1)
+	p.releasePendingParameterSets(dest)
+}
+
+// releasePendingParameterSets sends the pending VPS, SPS and PPS, if any,
+// outside of a frame.
+func (p *videoProxy) releasePendingParameterSets(dest *net.UDPAddr) {
+	if p.pendingVPS != nil {
+		p.sendPacket(p.pendingVPS, dest)
+		p.pendingVPS = nil
+	}
+	if p.pendingSPS != nil {
+		p.sendPacket(p.pendingSPS, dest)
+		p.pendingSPS = nil
+	}
+	if p.pendingPPS != nil {
+		p.sendPacket(p.pendingPPS, dest)
+		p.pendingPPS = nil
+	}
 }
 
-func (p *videoProxy) cacheParameterSet(payload []byte, isSPS bool) {
+// cacheParameterSet keeps the latest VPS, SPS or PPS payload for injection,
+// or those inside a STAP-A.
+func (p *videoProxy) cacheParameterSet(payload []byte) {
+	info, ok := p.parseNAL(payload)
+	if !ok {
+		return
+	}
+	if info.IsAggregate {
+		units, _ := rtpfix.SplitSTAPA(payload)
+		for _, unit := range units {
+			p.cacheParameterSet(unit)
+		}
+		return
+	}
 	clone := make([]byte, len(payload))
 	copy(clone, payload)
-	if isSPS {
+	if info.IsVPS {
+		p.cachedVPS = clone
+		return
+	}
+	if info.IsSPS {
+		if !slices.Equal(p.cachedSPS, payload) {
+			p.spsChanged(payload)
+		}
 		p.cachedSPS = clone
 		return
 	}
+	if !info.IsPPS {
+		return
+	}
+	if p.cachedPPS == nil {
+		p.session.recordHistory(time.Now(), HistoryPPSCached, "video", "")
+	} else if !slices.Equal(p.cachedPPS, payload) {
+		p.session.videoCounters.videoPPSChanges.Add(1)
+		p.forceInjectOnIDR = true
+		p.logger.Info("video.pps.changed",
+			"old_size", len(p.cachedPPS),
+			"new_size", len(payload),
+		)
+	}
 	p.cachedPPS = clone
 }
 
+// spsChanged handles an SPS that differs from the cached one, about to
+// replace it. A new SPS mid-stream means the doorphone changed resolution or
+// encoder settings: it is counted, logged with the sizes before and after,
+// and the next IDR gets the new parameter sets injected even if pending ones
+// went out recently, so that decoders never keep the stale ones.
+func (p *videoProxy) spsChanged(payload []byte) {
+	sps, parsed := rtpfix.SPSInfo{}, false
+	if p.session.Settings.VideoCodec != VideoCodecH265 {
+		sps, parsed = rtpfix.ParseSPS(payload)
+	}
+	if p.cachedSPS == nil {
+		p.session.recordHistory(time.Now(), HistorySPSCached, "video", "")
+	} else {
+		p.session.videoCounters.videoSPSChanges.Add(1)
+		p.forceInjectOnIDR = true
+		attrs := []any{"old_size", len(p.cachedSPS), "new_size", len(payload)}
+		if old, ok := p.session.VideoSPS(); ok && parsed {
+			attrs = append(attrs,
+				"old_width", old.Width,
+				"old_height", old.Height,
+				"new_width", sps.Width,
+				"new_height", sps.Height,
+			)
+		}
+		p.logger.Info("video.sps.changed", attrs...)
+	}
+	if parsed {
+		p.session.videoSPS.Store(&sps)
+	}
+}
+
 func (p *videoProxy) appendPendingToFrameBuffer() {
+	if p.pendingVPS != nil {
+		p.frameBuffer = append(p.frameBuffer, framePacket{data: p.pendingVPS})
+		p.frameBufferBytes += len(p.pendingVPS)
+		p.pendingVPS = nil
+	}
 	if p.pendingSPS != nil {
-		p.frameBuffer = append(p.frameBuffer, p.pendingSPS)
+		p.frameBuffer = append(p.frameBuffer, framePacket{data: p.pendingSPS})
+		p.frameBufferBytes += len(p.pendingSPS)
 		p.pendingSPS = nil
 	}
 	if p.pendingPPS != nil {
-		p.frameBuffer = append(p.frameBuffer, p.pendingPPS)
+		p.frameBuffer = append(p.frameBuffer, framePacket{data: p.pendingPPS})
+		p.frameBufferBytes += len(p.pendingPPS)
 		p.pendingPPS = nil
 	}
 }
@@ -546,7 +1233,94 @@ func (p *videoProxy) flushOnTimeout(now time.Time, dest *net.UDPAddr) {
 	if now.Sub(p.frameBufferStart) <= p.maxFrameWait {
 		return
 	}
-	p.flushFrameBuffer(now, dest, true)
+	p.endIncompleteFrame(now, dest, true)
+}
+
+// frameFlushLoop force-flushes a frame that is still incomplete maxFrameWait
+// after it started, so that a doorphone pausing mid-frame does not hold the
+// buffered packets back until its next packet arrives.
+func (p *videoProxy) frameFlushLoop() {
+	timer := time.NewTimer(p.maxFrameWait)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.frameStarted:
+			timer.Reset(p.maxFrameWait)
+		case <-timer.C:
+			p.flushExpiredFrame()
+		}
+	}
+}
+
+// flushExpiredFrame is the timer side of flushOnTimeout. A frame started
+// since the timer was armed is left alone; its own start re-armed the timer.
+func (p *videoProxy) flushExpiredFrame() {
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	if !p.frameBufferActive || len(p.frameBuffer) == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(p.frameBufferStart) < p.maxFrameWait {
+		return
+	}
+	dest := p.session.videoDest.Load()
+	if dest == nil {
+		return
+	}
+	p.endIncompleteFrame(now, dest, true)
+}
+
+// flushOnStop ends the frame still being assembled when the session stops,
+// so that the last frame of a call is not lost. stop calls it once loopAIn has
+// exited, before the B socket closes.
+func (p *videoProxy) flushOnStop() {
+	if !p.fixEnabled {
+		return
+	}
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	if !p.frameBufferActive || len(p.frameBuffer) == 0 {
+		return
+	}
+	dest := p.session.videoDest.Load()
+	if dest == nil {
+		return
+	}
+	p.endIncompleteFrame(time.Now(), dest, true)
+}
+
+// endIncompleteFrame ends a frame whose last packet has not arrived, either
+// on timeout (forced) or because the next frame started. The frame is sent as
+// is, or discarded under DropIncompleteFrames.
+func (p *videoProxy) endIncompleteFrame(now time.Time, dest *net.UDPAddr, forced bool) {
+	if p.session.Settings.DropIncompleteFrames {
+		p.frameLoss()
+		p.dropFrameBuffer()
+		return
+	}
+	p.flushFrameBuffer(now, dest, forced)
+}
+
+// dropFrameBuffer discards the frame being assembled. The parameter sets in
+// it go back to pending so that they still precede the next frame, unless a
+// STAP-A carries them along with a slice of the frame.
+func (p *videoProxy) dropFrameBuffer() {
+	var dropped uint64
+	for _, packet := range p.frameBuffer {
+		if packetInfo, ok := p.parseH264Packet(packet.data); ok && isParameterSet(packetInfo.info) && !packetInfo.info.IsSlice {
+			p.storePendingParameterSet(packet.data)
+			continue
+		}
+		dropped++
+	}
+	p.session.videoCounters.videoFramesDropped.Add(1)
+	p.session.videoCounters.videoPktsDropped.Add(dropped)
+	p.logPacketAnomaly("a->b", "incomplete_frame_dropped", p.frameBuffer[0].data)
+	p.resetFrameBuffer()
 }
 
 func (p *videoProxy) flushFrameBuffer(now time.Time, dest *net.UDPAddr, forced bool) {
@@ -554,100 +1328,309 @@ func (p *videoProxy) flushFrameBuffer(now time.Time, dest *net.UDPAddr, forced b
 		p.frameBufferActive = false
 		return
 	}
+	frame, reordered := orderFramePackets(p.frameBuffer)
+	if reordered {
+		p.session.videoCounters.videoFramesReordered.Add(1)
+	}
+	p.frameBuffer = frame
+	if p.frameLoss() > 0 && p.session.Settings.DropIncompleteFrames {
+		p.dropFrameBuffer()
+		return
+	}
 	frameTS := p.currentFrameTS
 	if !p.currentFrameTSSet {
-		frameTS = p.nextFrameTimestamp(now, p.frameBuffer[0])
+		frameTS = p.nextFrameTimestamp(now, p.frameBuffer[0].data)
 	}
 	last := len(p.frameBuffer) - 1
 	for i, packet := range p.frameBuffer {
-		setMarker(packet, i == last)
-		setTimestamp(packet, frameTS)
-		p.sendPacket(packet, dest)
+		p.retryParameterSetInjection(dest)
+		setMarker(packet.data, i == last)
+		setTimestamp(packet.data, frameTS)
+		p.sendPacket(packet.data, dest)
 	}
+	p.abandonParameterSetInjection()
 	p.session.videoCounters.videoFramesFlushed.Add(1)
 	if forced {
-		p.session.videoCounters.videoForcedFlushes.Add(1)
-		p.logPacketAnomaly("a->b", p.frameBuffer[0])
+		if p.session.videoCounters.videoForcedFlushes.Add(1) == 1 {
+			p.session.recordHistory(now, HistoryFirstForcedFlush, "video", "")
+		}
+		p.logPacketAnomaly("a->b", "forced_flush", p.frameBuffer[0].data)
 	}
 	p.frameBufferActive = false
+	p.frameSeqSet = false
+	p.frameInlineSeqs = p.frameInlineSeqs[:0]
 	p.currentFrameTSSet = false
-	p.frameBuffer = p.frameBuffer[:0]
+	p.clearFrameBuffer()
+}
+
+// orderFramePackets sorts the packets of a buffered frame by RTP sequence
+// number, so that FU-A fragments reordered by the network leave in decoding
+// order, and drops duplicates. Sequence numbers are compared modulo 2^16,
+// which holds as long as a frame spans fewer than 32768 of them. It reports
+// whether the order changed.
+func orderFramePackets(packets []framePacket) ([]framePacket, bool) {
+	compare := func(a, b framePacket) int {
+		return rtpfix.SeqDiff(binary.BigEndian.Uint16(a.data[2:4]), binary.BigEndian.Uint16(b.data[2:4]))
+	}
+	reordered := !slices.IsSortedFunc(packets, compare)
+	if reordered {
+		slices.SortStableFunc(packets, compare)
+	}
+	kept := packets[:0]
+	for _, packet := range packets {
+		if len(kept) > 0 && compare(kept[len(kept)-1], packet) == 0 {
+			packet.release()
+			continue
+		}
+		kept = append(kept, packet)
+	}
+	clear(packets[len(kept):])
+	return kept, reordered
 }
 
 func (p *videoProxy) sendPacket(packet []byte, dest *net.UDPAddr) {
-	if p.injectCachedSPSPPS {
-		p.rewriteSeqForOutput(packet)
+	// The limit is checked before numbering, so that dropped packets leave
+	// no gap in the outbound sequence.
+	if !p.rateLimit.allow(len(packet), time.Now()) {
+		p.session.videoCounters.drop(dropRateLimited)
+		return
 	}
+	numbered := p.rewriteSeqForOutput(packet)
+	p.session.videoSSRCRewrite.rewrite(packet)
 	if err := p.writeToDest(packet, dest); err != nil {
 		p.logger.Error("video b leg write failed", "error", err)
-		p.session.videoCounters.drops.Add(1)
+		p.session.videoCounters.drop(dropWriteError)
+		p.recordFixError(fixErrorWrite)
 		return
 	}
+	if numbered {
+		p.commitOutputSeq(packet)
+	}
 	p.session.videoCounters.bOutPkts.Add(1)
 	p.session.videoCounters.bOutBytes.Add(uint64(len(packet)))
+	p.session.capturePacket(CaptureLegBOut, p.bConn, dest, packet)
+	p.hairpin.sent(packet, time.Now())
 }
 
 func (p *videoProxy) forwardRawPacket(packet []byte, dest *net.UDPAddr) {
+	if !p.rateLimit.allow(len(packet), time.Now()) {
+		p.session.videoCounters.drop(dropRateLimited)
+		return
+	}
+	p.session.videoSSRCRewrite.rewrite(packet)
 	if err := p.writeToDest(packet, dest); err != nil {
 		p.logger.Error("video b leg write failed", "error", err)
-		p.session.videoCounters.drops.Add(1)
+		p.session.videoCounters.drop(dropWriteError)
 		return
 	}
 	p.session.videoCounters.bOutPkts.Add(1)
 	p.session.videoCounters.bOutBytes.Add(uint64(len(packet)))
+	p.session.capturePacket(CaptureLegBOut, p.bConn, dest, packet)
+	p.hairpin.sent(packet, time.Now())
 }
 
 func (p *videoProxy) resetFrameBuffer() {
+	p.injectVPSPending = false
+	p.injectSPSPending = false
+	p.injectPPSPending = false
 	p.frameBufferActive = false
-	p.frameBuffer = p.frameBuffer[:0]
+	p.frameSeqSet = false
+	p.frameInlineSeqs = p.frameInlineSeqs[:0]
+	p.clearFrameBuffer()
 	p.frameBufferStart = time.Time{}
 	p.currentFrameTSSet = false
 }
 
-func (p *videoProxy) injectCachedParameterSets(header rtpfix.RTPHeader, dest *net.UDPAddr) {
+// injectCachedParameterSets sends the cached parameter sets, the VPS first
+// for H265, ahead of the IDR packet starting a frame. They carry the same header as the IDR, CSRCs and
+// header extension included, and the timestamp the IDR goes out with: that of
+// the frame being assembled, or the IDR's own outside of one.
+func (p *videoProxy) injectCachedParameterSets(idrPacket []byte, header rtpfix.RTPHeader, dest *net.UDPAddr) {
 	if !p.injectCachedSPSPPS {
 		return
 	}
-	if p.pendingSPS != nil || p.pendingPPS != nil {
+	if !p.forceInjectOnIDR && (p.pendingVPS != nil || p.pendingSPS != nil || p.pendingPPS != nil) {
 		return
 	}
-	if p.cachedSPS == nil && p.cachedPPS == nil {
+	if p.cachedVPS == nil && p.cachedSPS == nil && p.cachedPPS == nil {
 		return
 	}
+	p.forceInjectOnIDR = false
 	p.ensureSeqBaseline(header.Seq)
-	if p.cachedSPS != nil {
-		p.sendInjectedPacket(p.cachedSPS, header, dest, true)
+	if p.currentFrameTSSet {
+		header.TS = p.currentFrameTS
+	}
+	p.injectHeader = header
+	p.injectExt = append(p.injectExt[:0], idrPacket[12:header.HeaderLen]...)
+	p.injectVPSPending = p.cachedVPS != nil
+	p.injectSPSPending = p.cachedSPS != nil
+	p.injectPPSPending = p.cachedPPS != nil
+	p.injectAttempts = 1
+	p.sendPendingParameterSets(dest)
+}
+
+// sendPendingParameterSets sends the injected parameter sets that have not
+// gone out yet for the current frame. They go out as VPS, SPS, then PPS, so a
+// failed write stops the attempt. It reports whether nothing remains
+// outstanding.
+func (p *videoProxy) sendPendingParameterSets(dest *net.UDPAddr) bool {
+	counters := &p.session.videoCounters
+	if p.injectVPSPending {
+		if !p.sendInjectedPacket(p.cachedVPS, p.injectHeader, dest, &counters.videoInjectedVPS) {
+			return false
+		}
+		p.injectVPSPending = false
+	}
+	if p.injectSPSPending {
+		if !p.sendInjectedPacket(p.cachedSPS, p.injectHeader, dest, &counters.videoInjectedSPS) {
+			return false
+		}
+		p.injectSPSPending = false
+	}
+	if p.injectPPSPending {
+		if !p.sendInjectedPacket(p.cachedPPS, p.injectHeader, dest, &counters.videoInjectedPPS) {
+			return false
+		}
+		p.injectPPSPending = false
+	}
+	return true
+}
+
+// retryParameterSetInjection re-attempts a failed injection before the next
+// buffered packet of the same frame is sent, up to maxInjectAttempts in total.
+func (p *videoProxy) retryParameterSetInjection(dest *net.UDPAddr) {
+	if !p.injectVPSPending && !p.injectSPSPending && !p.injectPPSPending {
+		return
+	}
+	if p.injectAttempts >= maxInjectAttempts {
+		p.abandonParameterSetInjection()
+		return
+	}
+	p.injectAttempts++
+	p.session.videoCounters.videoInjectionRetries.Add(1)
+	if p.sendPendingParameterSets(dest) {
+		return
+	}
+	if p.injectAttempts >= maxInjectAttempts {
+		p.abandonParameterSetInjection()
+	}
+}
+
+// abandonParameterSetInjection gives up on the current frame's injection and
+// arms injection for the next IDR regardless of pending parameter sets.
+func (p *videoProxy) abandonParameterSetInjection() {
+	if !p.injectVPSPending && !p.injectSPSPending && !p.injectPPSPending {
+		return
+	}
+	p.injectVPSPending = false
+	p.injectSPSPending = false
+	p.injectPPSPending = false
+	p.forceInjectOnIDR = true
+	p.session.videoCounters.videoInjectionFailures.Add(1)
+	p.logger.Warn("video sps/pps injection failed", "attempts", p.injectAttempts)
+	p.recordFixError(fixErrorInjectionFailure)
+}
+
+// fixActive reports whether packets should go through the fix pipeline. It
+// also applies a pending re-arm, either from the cooldown or from the API.
+// The frame flush timer trips the bypass through recordFixError, so the
+// bypass state is only touched under bufferMu.
+func (p *videoProxy) fixActive(now time.Time) bool {
+	if !p.fixEnabled {
+		return false
+	}
+	p.bufferMu.Lock()
+	defer p.bufferMu.Unlock()
+	if p.session.videoFixBypassed.Load() {
+		if p.fixConfig.BypassCooldown <= 0 || now.Sub(p.fixBypassedAt) < p.fixConfig.BypassCooldown {
+			return false
+		}
+		p.session.videoFixBypassed.Store(false)
+		p.rearmFix("cooldown")
+		return true
+	}
+	if p.fixBypassed {
+		p.rearmFix("api")
+	}
+	return true
+}
+
+func (p *videoProxy) rearmFix(trigger string) {
+	p.fixBypassed = false
+	p.fixErrors = nil
+	p.logger.Info("video.fix.rearmed", "trigger", trigger)
+}
+
+// recordFixError counts a fix pipeline error of the given kind and switches
+// the session to raw forwarding once the kind exceeds the configured rate.
+func (p *videoProxy) recordFixError(kind string) {
+	if p.fixConfig.BypassErrorThreshold <= 0 || p.fixBypassed {
+		return
+	}
+	now := time.Now()
+	if p.fixErrors == nil {
+		p.fixErrors = make(map[string][]time.Time)
 	}
-	if p.cachedPPS != nil {
-		p.sendInjectedPacket(p.cachedPPS, header, dest, false)
+	events := p.fixErrors[kind]
+	cutoff := now.Add(-p.fixConfig.BypassWindow)
+	kept := events[:0]
+	for _, at := range events {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
 	}
+	kept = append(kept, now)
+	p.fixErrors[kind] = kept
+	if len(kept) < p.fixConfig.BypassErrorThreshold {
+		return
+	}
+	p.fixBypassed = true
+	p.fixBypassedAt = now
+	p.fixErrors = nil
+	p.session.videoFixBypassReason.Store(kind)
+	p.session.videoFixBypassed.Store(true)
+	p.logger.Error("video.fix.bypass",
+		"reason", kind,
+		"threshold", p.fixConfig.BypassErrorThreshold,
+		"window", p.fixConfig.BypassWindow,
+		"cooldown", p.fixConfig.BypassCooldown,
+	)
 }
 
-func (p *videoProxy) sendInjectedPacket(payload []byte, header rtpfix.RTPHeader, dest *net.UDPAddr, isSPS bool) {
+// forwardBypassedPacket forwards a packet unmodified while fix mode is
+// bypassed. Anything still held by the fix pipeline is released first, and
+// sequence numbers stay continuous with what was already sent.
+func (p *videoProxy) forwardBypassedPacket(packet []byte, dest *net.UDPAddr) {
+	if p.frameBufferActive {
+		p.flushFrameBuffer(time.Now(), dest, false)
+	}
+	p.releasePendingParameterSets(dest)
+	p.sendPacket(packet, dest)
+}
+
+// sendInjectedPacket sends a cached parameter set and counts it in injected.
+func (p *videoProxy) sendInjectedPacket(payload []byte, header rtpfix.RTPHeader, dest *net.UDPAddr, injected *atomic.Uint64) bool {
 	seq := p.lastOutSeq + 1
-	packet := make([]byte, 12+len(payload))
-	packet[0] = 0x80
-	packet[1] = header.PT & 0x7f
-	binary.BigEndian.PutUint16(packet[2:4], seq)
-	binary.BigEndian.PutUint32(packet[4:8], p.currentFrameTS)
-	binary.BigEndian.PutUint32(packet[8:12], header.SSRC)
-	copy(packet[12:], payload)
+	header.Seq = seq
+	header.Marker = false
+	packet := rtpfix.BuildPacket(header, p.injectExt, payload)
+	p.session.videoSSRCRewrite.rewrite(packet)
 	if err := p.writeToDest(packet, dest); err != nil {
 		p.logger.Error("video b leg write failed", "error", err)
-		p.session.videoCounters.drops.Add(1)
-		return
+		p.session.videoCounters.drop(dropWriteError)
+		p.recordFixError(fixErrorWrite)
+		return false
 	}
 	p.session.videoCounters.bOutPkts.Add(1)
 	p.session.videoCounters.bOutBytes.Add(uint64(len(packet)))
+	p.session.capturePacket(CaptureLegBOut, p.bConn, dest, packet)
+	p.hairpin.sent(packet, time.Now())
 	p.lastOutSeq = seq
 	p.hasLastOutSeq = true
 	p.seqDelta++
 	p.session.videoCounters.videoSeqDelta.Store(uint64(p.seqDelta))
-	if isSPS {
-		p.session.videoCounters.videoInjectedSPS.Add(1)
-	} else {
-		p.session.videoCounters.videoInjectedPPS.Add(1)
-	}
+	injected.Add(1)
+	return true
 }
 
 func (p *videoProxy) ensureSeqBaseline(seq uint16) {
@@ -658,18 +1641,40 @@ func (p *videoProxy) ensureSeqBaseline(seq uint16) {
 	p.hasLastOutSeq = true
 }
 
-func (p *videoProxy) rewriteSeqForOutput(packet []byte) {
-	if len(packet) < 4 {
-		return
+// rewriteSeqForOutput numbers a packet sent in fix mode right after the last
+// one sent, so that the outbound sequence stays continuous whatever the fixer
+// injected, dropped or merged. The first packet keeps its own number.
+// Datagrams that are not RTP are left alone. The number is only taken once
+// the packet is written, by commitOutputSeq, so that a failed write leaves no
+// gap.
+func (p *videoProxy) rewriteSeqForOutput(packet []byte) bool {
+	if len(packet) < 12 || packet[0]>>6 != 2 {
+		return false
+	}
+	if p.hasLastOutSeq {
+		binary.BigEndian.PutUint16(packet[2:4], p.lastOutSeq+1)
 	}
-	seqIn := binary.BigEndian.Uint16(packet[2:4])
-	seqOut := seqIn + p.seqDelta
-	binary.BigEndian.PutUint16(packet[2:4], seqOut)
-	p.lastOutSeq = seqOut
+	return true
+}
+
+// commitOutputSeq records the number of a packet rewritten by
+// rewriteSeqForOutput as the last one sent.
+func (p *videoProxy) commitOutputSeq(packet []byte) {
+	p.lastOutSeq = binary.BigEndian.Uint16(packet[2:4])
 	p.hasLastOutSeq = true
 }
 
+// nextFrameTimestamp returns the RTP timestamp of the frame seedPacket starts.
+// In passthrough mode it is the timestamp of seedPacket itself, keeping the
+// capture timing of the doorphone. Otherwise the first frame keeps its own and
+// each next one is stamped from the time elapsed since the previous one,
+// clamped to 10-100ms.
 func (p *videoProxy) nextFrameTimestamp(now time.Time, seedPacket []byte) uint32 {
+	if p.session.Settings.VideoTimestampMode == VideoTimestampPassthrough {
+		if header, ok := rtpfix.ParseRTPHeader(seedPacket); ok {
+			return header.TS
+		}
+	}
 	if !p.frameTSInitialized {
 		header, ok := rtpfix.ParseRTPHeader(seedPacket)
 		if ok {
@@ -686,8 +1691,7 @@ func (p *videoProxy) nextFrameTimestamp(now time.Time, seedPacket []byte) uint32
 	if dt > 100*time.Millisecond {
 		dt = 100 * time.Millisecond
 	}
-	increment := uint32((dt.Seconds() * 90000) + 0.5)
-	p.frameTS += increment
+	p.frameTS = rtpfix.TSAdd(p.frameTS, dt, videoClockRate)
 	p.lastFrameSentTime = now
 	return p.frameTS
 }
@@ -710,43 +1714,75 @@ func (p *videoProxy) trackSeqGap(packet []byte, lastSeq *uint16, hasLastSeq *boo
 	return header, true, seqGap
 }
 
-func (p *videoProxy) logPacketIfNeeded(direction string, header rtpfix.RTPHeader, headerOK bool, seqGap bool, size int, packetCount *uint64) {
+// logPacketIfNeeded logs every packetLogSampleN-th packet of a direction and,
+// when anomaly logging is on, every packet that fails RTP or H264 parsing,
+// follows a sequence gap, or starts a fragmented NAL while the previous one
+// never ended. fuOpen tracks the fragmentation state of the direction.
+func (p *videoProxy) logPacketIfNeeded(direction string, packet []byte, header rtpfix.RTPHeader, headerOK bool, seqGap bool, packetCount *uint64, fuOpen *bool) {
 	if !p.packetLog {
 		return
 	}
 	*packetCount++
 	logSample := p.packetLogSampleN > 0 && *packetCount%p.packetLogSampleN == 0
-	anomaly := seqGap || !headerOK
-	if anomaly && p.packetLogOnAnomaly {
-		p.logPacket("video.proxy.packet.anomaly", direction, header, size)
+	if !logSample && !p.packetLogOnAnomaly {
+		return
+	}
+	packetInfo, h264OK, _ := p.parseH264PacketDetailed(packet)
+	info := packetInfo.info
+	anomaly := ""
+	switch {
+	case !headerOK:
+		anomaly = "rtp_parse"
+	case !h264OK:
+		anomaly = "h264_parse"
+	case seqGap:
+		anomaly = "seq_gap"
+	case info.IsFU && info.FUStart && *fuOpen:
+		anomaly = "fu_start_without_end"
+	}
+	if info.IsFU {
+		*fuOpen = !info.FUEnd && (info.FUStart || *fuOpen)
+	}
+	if anomaly != "" && p.packetLogOnAnomaly {
+		p.logPacket("video.proxy.packet.anomaly", direction, anomaly, header, info, len(packet))
 		return
 	}
 	if logSample {
-		p.logPacket("video.proxy.packet", direction, header, size)
+		p.logPacket("video.proxy.packet", direction, "", header, info, len(packet))
 	}
 }
 
-func (p *videoProxy) logPacketAnomaly(direction string, packet []byte) {
+func (p *videoProxy) logPacketAnomaly(direction, anomaly string, packet []byte) {
 	if !p.packetLog || !p.packetLogOnAnomaly {
 		return
 	}
-	header, ok := rtpfix.ParseRTPHeader(packet)
-	if !ok {
-		header = rtpfix.RTPHeader{}
-	}
-	p.logPacket("video.proxy.packet.anomaly", direction, header, len(packet))
+	packetInfo, _, _ := p.parseH264PacketDetailed(packet)
+	p.logPacket("video.proxy.packet.anomaly", direction, anomaly, packetInfo.header, packetInfo.info, len(packet))
 }
 
-func (p *videoProxy) logPacket(msg, direction string, header rtpfix.RTPHeader, size int) {
-	p.logger.Debug(msg,
+func (p *videoProxy) logPacket(msg, direction, anomaly string, header rtpfix.RTPHeader, info rtpfix.H264Info, size int) {
+	args := []any{
 		"direction", direction,
 		"seq", header.Seq,
 		"ts", header.TS,
 		"marker", header.Marker,
 		"pt", header.PT,
 		"ssrc", header.SSRC,
+		"nal_type", info.NALType,
+		"fu", info.IsFU,
+		"fu_start", info.FUStart,
+		"fu_end", info.FUEnd,
 		"size", size,
-	)
+	}
+	// H264 types are named for reading; a packet that failed parsing has
+	// no type to name.
+	if p.session.Settings.VideoCodec != VideoCodecH265 && anomaly != "rtp_parse" && anomaly != "h264_parse" {
+		args = append(args, "nal_name", rtpfix.NALTypeName(info.NALType))
+	}
+	if anomaly != "" {
+		args = append(args, "anomaly", anomaly)
+	}
+	p.logger.Debug(msg, args...)
 }
 
 func setMarker(packet []byte, marker bool) {
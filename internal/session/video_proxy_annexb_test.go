@@ -0,0 +1,60 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyStripAnnexB verifies the removal of Annex B start codes under
+// StripAnnexB. This matters because a doorphone prefixing every RTP payload
+// with 0x00000001 sends a stream no RFC 6184 depacketizer decodes, and the
+// fixer used to pass it on as opaque packets. Inputs: the 640x360 doorphone
+// SPS after a 4-byte start code, a PPS after a 3-byte one and an IDR after a
+// 4-byte one, with stripping on or off. The expected output is the SPS, PPS
+// and IDR in that order under continuous sequence numbers either way, the
+// NAL units sent without their prefix and the SPS decoded for GET when
+// stripping is on, and sent untouched when it is off.
+func TestVideoProxyStripAnnexB(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84}
+	payloads := [][]byte{
+		append([]byte{0, 0, 0, 1}, sps...),
+		append([]byte{0, 0, 1}, pps...),
+		append([]byte{0, 0, 0, 1}, idr...),
+	}
+	for _, strip := range []bool{true, false} {
+		session := &Session{ID: "S-annexb", Settings: Settings{StripAnnexB: strip}}
+		proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+		var output [][]byte
+		proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+			output = append(output, append([]byte(nil), packet...))
+			return nil
+		}
+		dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+		for i, payload := range payloads {
+			proxy.handleVideoPacket(makeRTPPacket(uint16(1+i), 9000, payload), dest)
+		}
+
+		if len(output) != len(payloads) {
+			t.Fatalf("strip=%t: expected %d packets sent, got %d", strip, len(payloads), len(output))
+		}
+		for i, packet := range output {
+			if seq := binary.BigEndian.Uint16(packet[2:4]); seq != uint16(1+i) {
+				t.Fatalf("strip=%t: expected seq %d at position %d, got %d", strip, 1+i, i, seq)
+			}
+			want := payloads[i]
+			if strip {
+				want = [][]byte{sps, pps, idr}[i]
+			}
+			if string(packet[12:]) != string(want) {
+				t.Fatalf("strip=%t: expected payload % x at position %d, got % x", strip, want, i, packet[12:])
+			}
+		}
+		if info, ok := session.VideoSPS(); strip && (!ok || info.Width != 640 || info.Height != 360) {
+			t.Fatalf("expected the stripped SPS decoded as 640x360, got %+v", info)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyFixBypassOnNALParseErrors verifies that a burst of unparseable
+// H.264 payloads switches a fix-mode session to raw forwarding. This matters
+// because a misbehaving doorphone must not stall video behind a pipeline that
+// keeps failing. Preconditions: fix mode enabled with a bypass threshold of 3
+// in a 10s window and no cooldown. Inputs: three RTP packets whose payload is a
+// truncated FU-A indicator, followed by a valid IDR slice. The expected output
+// is that all four packets reach rtpengine and the session reports the bypass
+// with reason nal_parse_errors. A regression would either drop traffic or keep
+// the session in fix mode indefinitely.
+func TestVideoProxyFixBypassOnNALParseErrors(t *testing.T) {
+	session := &Session{ID: "S-bypass"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+
+	fixConfig := VideoFixConfig{BypassErrorThreshold: 3, BypassWindow: 10 * time.Second}
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, 50*time.Millisecond, true, false, fixConfig, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+
+	inputs := [][]byte{
+		makeRTPPacket(1, 9000, []byte{28}),
+		makeRTPPacket(2, 9000, []byte{28}),
+		makeRTPPacket(3, 9000, []byte{28}),
+		makeRTPPacket(4, 12000, []byte{0x65, 0x00}),
+	}
+	for _, packet := range inputs {
+		if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+			t.Fatalf("send to a-leg failed: %v", err)
+		}
+	}
+
+	buffer := make([]byte, 2048)
+	for i := range inputs {
+		_ = rtpEngineConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, _, err := rtpEngineConn.ReadFromUDP(buffer); err != nil {
+			t.Fatalf("read %d from rtpengine failed: %v", i, err)
+		}
+	}
+
+	bypassed, reason := session.VideoFixBypass()
+	if !bypassed {
+		t.Fatalf("expected fix mode to be bypassed")
+	}
+	if reason != fixErrorNALParse {
+		t.Fatalf("unexpected bypass reason: got=%q want=%q", reason, fixErrorNALParse)
+	}
+}
+
+// TestVideoProxyFixBypassRearm verifies both ways out of a bypass: the
+// configured cooldown and an explicit re-arm through the session flag, which
+// is what Manager.RearmVideoFix clears. Inputs: a proxy tripped with two write
+// errors at threshold 2 and a 50ms cooldown. The expected output is that fix
+// mode stays inactive inside the cooldown, becomes active after it, and is
+// active again right after a manual re-arm of a second trip.
+func TestVideoProxyFixBypassRearm(t *testing.T) {
+	session := &Session{ID: "S-rearm"}
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	defer aConn.Close()
+	defer bConn.Close()
+
+	fixConfig := VideoFixConfig{BypassErrorThreshold: 2, BypassWindow: time.Second, BypassCooldown: 50 * time.Millisecond}
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, 50*time.Millisecond, true, false, fixConfig, ProxyLogConfig{})
+
+	proxy.recordFixError(fixErrorWrite)
+	proxy.recordFixError(fixErrorWrite)
+	trippedAt := proxy.fixBypassedAt
+	if proxy.fixActive(trippedAt.Add(10 * time.Millisecond)) {
+		t.Fatalf("expected fix mode to stay bypassed within the cooldown")
+	}
+	if !proxy.fixActive(trippedAt.Add(60 * time.Millisecond)) {
+		t.Fatalf("expected fix mode to re-arm after the cooldown")
+	}
+	if bypassed, _ := session.VideoFixBypass(); bypassed {
+		t.Fatalf("expected session bypass flag to be cleared after the cooldown")
+	}
+
+	proxy.recordFixError(fixErrorWrite)
+	proxy.recordFixError(fixErrorWrite)
+	if bypassed, reason := session.VideoFixBypass(); !bypassed || reason != fixErrorWrite {
+		t.Fatalf("expected second bypass with reason %q, got bypassed=%v reason=%q", fixErrorWrite, bypassed, reason)
+	}
+	session.videoFixBypassed.Store(false)
+	if !proxy.fixActive(proxy.fixBypassedAt) {
+		t.Fatalf("expected fix mode to re-arm immediately after manual re-arm")
+	}
+	if proxy.fixBypassed {
+		t.Fatalf("expected proxy bypass state to be cleared after manual re-arm")
+	}
+}
+
+// TestVideoProxyFixBypassFromFlushTimer verifies that the frame flush timer
+// can trip the bypass while the A loop re-arms it. This matters because the
+// timer goroutine records write errors, and the bypass state it writes was
+// read and cleared by the A loop without a lock. Inputs, under -race: a proxy
+// whose B leg writes always fail, a bypass threshold of 1 with a 1ns
+// cooldown, one goroutine buffering 20 stalled frames 1ms apart and flushing
+// them the way the timer does, and another calling fixActive the way the A
+// loop does. The expected output is no race, every flush counted as a write
+// error, and the fixer active again once the last bypass is re-armed.
+func TestVideoProxyFixBypassFromFlushTimer(t *testing.T) {
+	session := &Session{ID: "S-bypass-timer"}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	session.videoDest.Store(dest)
+	fixConfig := VideoFixConfig{BypassErrorThreshold: 1, BypassWindow: time.Second, BypassCooldown: time.Nanosecond}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, fixConfig, ProxyLogConfig{})
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		return errors.New("no buffer space available")
+	}
+
+	const frames = 20
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < frames; i++ {
+			proxy.bufferMu.Lock()
+			proxy.handleVideoPacket(makeRTPPacket(uint16(1+i), uint32(9000*(1+i)), []byte{0x7c, 0x85, 0x00}), dest)
+			proxy.frameBufferStart = time.Now().Add(-time.Hour)
+			proxy.bufferMu.Unlock()
+			proxy.flushExpiredFrame()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			proxy.fixActive(time.Now())
+			runtime.Gosched()
+		}
+	}
+
+	if drops := session.videoCounters.dropsWriteError.Load(); drops != frames {
+		t.Fatalf("expected %d write errors, got %d", frames, drops)
+	}
+	if !proxy.fixActive(time.Now()) {
+		t.Fatalf("expected the fixer to re-arm after the cooldown")
+	}
+	if bypassed, _ := session.VideoFixBypass(); bypassed {
+		t.Fatalf("expected the session bypass flag to be cleared")
+	}
+}
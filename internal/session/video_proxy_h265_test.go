@@ -0,0 +1,52 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyH265 verifies the fix pipeline on an HEVC session. This
+// matters because H265 doorphones fragment with type-49 FUs and need the VPS
+// ahead of the SPS and PPS, none of which the H264 parser recognises, so every
+// packet used to count as a NAL parse error. Inputs: a VPS, SPS and PPS, an
+// IDR_W_RADL frame in an FU start and end, a TRAIL_R slice, then a second IDR
+// frame with no parameter sets before it, on a session with codec h265 and
+// injection on. The expected output is the parameter sets leaving with the
+// first IDR frame, the cached VPS, SPS and PPS injected in that order ahead of
+// the second, one injection of each counted, three frames flushed and no
+// parse errors.
+func TestVideoProxyH265(t *testing.T) {
+	session := &Session{ID: "S-h265", Settings: Settings{VideoCodec: VideoCodecH265}}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, true, VideoFixConfig{}, ProxyLogConfig{})
+	var sent []byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		sent = append(sent, packet[12]>>1&0x3f)
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	nal := func(seq uint16, ts uint32, unitType byte, rest ...byte) {
+		proxy.handleVideoPacket(makeRTPPacket(seq, ts, append([]byte{unitType << 1, 0x01}, rest...)), dest)
+	}
+
+	nal(1, 9000, 32, 0x0c)
+	nal(2, 9000, 33, 0x01)
+	nal(3, 9000, 34, 0xc1)
+	nal(4, 9000, 49, 0x80|19, 0xaa)
+	nal(5, 9000, 49, 0x40|19, 0xbb)
+	nal(6, 12000, 1, 0xcc)
+	nal(7, 15000, 49, 0x80|19, 0xdd)
+	nal(8, 15000, 49, 0x40|19, 0xee)
+
+	want := []byte{32, 33, 34, 49, 49, 1, 32, 33, 34, 49, 49}
+	if string(sent) != string(want) {
+		t.Fatalf("expected NAL types %v sent, got %v", want, sent)
+	}
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoInjectedVPS != 1 || counters.VideoInjectedSPS != 1 || counters.VideoInjectedPPS != 1 {
+		t.Fatalf("expected one VPS, SPS and PPS injected, got %d, %d and %d", counters.VideoInjectedVPS, counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+	}
+	if parseErrors := session.videoCounters.videoNalParseErrors.Load(); counters.VideoFramesFlushed != 3 || parseErrors != 0 {
+		t.Fatalf("expected 3 frames flushed and no parse errors, got %d and %d", counters.VideoFramesFlushed, parseErrors)
+	}
+}
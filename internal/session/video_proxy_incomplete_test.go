@@ -0,0 +1,173 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyIncompleteFrames verifies both ways of ending a frame whose
+// last fragment never arrives. This matters because some decoders render a
+// truncated IDR as a smeared picture until the next IDR, and operators of
+// those endpoints would rather lose the frame. Inputs: an SPS and PPS, the
+// start of an IDR frame and one middle fragment, then either the frame
+// timing out or the start of the next frame, with incomplete frames flushed
+// or dropped. The expected output in flush mode is the incomplete frame sent
+// with one forced flush on timeout. In drop mode nothing of the frame is
+// sent, one frame and two packets are counted as dropped, and the SPS and PPS
+// are sent ahead of the next frame.
+func TestVideoProxyIncompleteFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		drop       bool
+		timeout    bool
+		wantSent   []byte
+		wantForced uint64
+	}{
+		{"flush on timeout", false, true, []byte{7, 8, 28, 28, 28, 28}, 1},
+		{"flush on next frame", false, false, []byte{7, 8, 28, 28, 28, 28}, 0},
+		{"drop on timeout", true, true, []byte{7, 8, 28, 28}, 0},
+		{"drop on next frame", true, false, []byte{7, 8, 28, 28}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-incomplete", Settings: Settings{DropIncompleteFrames: tt.drop}}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+			var sent []byte
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				sent = append(sent, packet[12]&0x1f)
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+			proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{0x67, 0x42}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(2, 9000, []byte{0x68, 0xce}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(3, 9000, []byte{0x7c, 0x85, 0x00}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(4, 9000, []byte{0x7c, 0x05, 0x01}), dest)
+			if tt.timeout {
+				proxy.frameBufferStart = time.Now().Add(-2 * time.Minute)
+				proxy.flushOnTimeout(time.Now(), dest)
+			}
+			proxy.handleVideoPacket(makeRTPPacket(5, 18000, []byte{0x7c, 0x85, 0x00}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(6, 18000, []byte{0x7c, 0x45, 0x01}), dest)
+
+			if string(sent) != string(tt.wantSent) {
+				t.Fatalf("expected NAL types %v sent, got %v", tt.wantSent, sent)
+			}
+			counters := snapshotVideoCounters(&session.videoCounters)
+			if counters.VideoForcedFlushes != tt.wantForced {
+				t.Fatalf("expected %d forced flushes, got %d", tt.wantForced, counters.VideoForcedFlushes)
+			}
+			wantFrames, wantPkts := uint64(0), uint64(0)
+			if tt.drop {
+				wantFrames, wantPkts = 1, 2
+			}
+			if counters.VideoFramesDropped != wantFrames || counters.VideoPktsDropped != wantPkts {
+				t.Fatalf("expected %d frames and %d packets dropped, got %d and %d", wantFrames, wantPkts, counters.VideoFramesDropped, counters.VideoPktsDropped)
+			}
+		})
+	}
+}
+
+// TestVideoProxyFramesWithLoss verifies that a frame missing packets between
+// its first and last one is flagged. This matters because such a frame
+// decodes with corruption, yet it used to be flushed exactly like a complete
+// one. Inputs: FU-A fragments 100, 101 and 103 of one IDR frame, 103 being
+// the end, with incomplete frames flushed or dropped, and fragments 100, 102,
+// 101 and 103 of a frame reordered but complete. The expected output is one frame
+// with loss in both modes, the three fragments sent in flush mode and nothing
+// sent with one frame and three packets dropped in drop mode, and the reordered
+// frame sent whole without loss.
+func TestVideoProxyFramesWithLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     bool
+		seqs     []uint16
+		wantSent int
+		wantLoss uint64
+	}{
+		{"flush", false, []uint16{100, 101, 103}, 3, 1},
+		{"drop", true, []uint16{100, 101, 103}, 0, 1},
+		{"reordered", true, []uint16{100, 102, 101, 103}, 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-loss", Settings: Settings{DropIncompleteFrames: tt.drop}}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+			var sent int
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				sent++
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+			fuHeaders := map[uint16]byte{100: 0x85, 101: 0x05, 102: 0x05, 103: 0x45}
+
+			for _, seq := range tt.seqs {
+				proxy.handleVideoPacket(makeRTPPacket(seq, 9000, []byte{0x7c, fuHeaders[seq], 0x00}), dest)
+			}
+
+			counters := snapshotVideoCounters(&session.videoCounters)
+			if sent != tt.wantSent || counters.VideoFramesWithLoss != tt.wantLoss {
+				t.Fatalf("expected %d sent and %d frames with loss, got %d and %d", tt.wantSent, tt.wantLoss, sent, counters.VideoFramesWithLoss)
+			}
+			if tt.drop && tt.wantLoss == 1 && (counters.VideoFramesDropped != 1 || counters.VideoPktsDropped != 3) {
+				t.Fatalf("expected one frame of 3 packets dropped, got %d and %d", counters.VideoFramesDropped, counters.VideoPktsDropped)
+			}
+		})
+	}
+}
+
+// TestVideoProxyFramesWithLossInBandNALs verifies that packets other than
+// slices inside a frame do not count as lost. This matters because an SPS,
+// PPS, SEI or AUD between the fragments of a frame used to flag it with loss,
+// and drop it under DropIncompleteFrames. Inputs, with incomplete frames
+// dropped: FU-A fragments 100 and 104 of an IDR frame with an SPS, PPS and
+// SEI at 101 to 103, with SEI kept or dropped, and the same frame missing its
+// PPS. The expected output is no frame with loss and all packets sent, the
+// SEI forwarded at once ahead of the buffered frame or not at all when it is
+// dropped, and the frame missing its PPS flagged and dropped, its SPS kept
+// pending for the next frame.
+func TestVideoProxyFramesWithLossInBandNALs(t *testing.T) {
+	tests := []struct {
+		name     string
+		dropSEI  bool
+		seqs     []uint16
+		wantSent []byte
+		wantLoss uint64
+	}{
+		{"sei kept", false, []uint16{100, 101, 102, 103, 104}, []byte{6, 28, 7, 8, 28}, 0},
+		{"sei dropped", true, []uint16{100, 101, 102, 103, 104}, []byte{28, 7, 8, 28}, 0},
+		{"pps lost", false, []uint16{100, 101, 103, 104}, []byte{6}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-inband", Settings: Settings{DropIncompleteFrames: true, DropSEI: tt.dropSEI}}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+			var sent []byte
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				sent = append(sent, packet[12]&0x1f)
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+			payloads := map[uint16][]byte{
+				100: {0x7c, 0x85, 0x00},
+				101: {0x67, 0x42},
+				102: {0x68, 0xce},
+				103: {0x06, 0x05, 0x01},
+				104: {0x7c, 0x45, 0x01},
+			}
+
+			for _, seq := range tt.seqs {
+				proxy.handleVideoPacket(makeRTPPacket(seq, 9000, payloads[seq]), dest)
+			}
+
+			if string(sent) != string(tt.wantSent) {
+				t.Fatalf("expected NAL types %v sent, got %v", tt.wantSent, sent)
+			}
+			counters := snapshotVideoCounters(&session.videoCounters)
+			if counters.VideoFramesWithLoss != tt.wantLoss || counters.VideoFramesDropped != tt.wantLoss {
+				t.Fatalf("expected %d frames with loss and dropped, got %d and %d", tt.wantLoss, counters.VideoFramesWithLoss, counters.VideoFramesDropped)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ package session
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"net"
 	"testing"
+	"time"
 
+	"rtp-stream-cleaner/internal/logging"
 	"rtp-stream-cleaner/internal/rtpfix"
 )
 
@@ -43,16 +46,16 @@ func TestVideoProxyInjectCachedSPSPPSOnIDR(t *testing.T) {
 	ppsPacket := makeRTPPacket(11, 9000, []byte{0x68})
 	idrPacket := makeRTPPacket(12, 9000, []byte{0x65})
 
-	spsInfo, ok := parseH264Packet(spsPacket)
+	spsInfo, ok := proxy.parseH264Packet(spsPacket)
 	if !ok || !spsInfo.info.IsSPS {
 		t.Fatalf("expected SPS packet to parse")
 	}
-	ppsInfo, ok := parseH264Packet(ppsPacket)
+	ppsInfo, ok := proxy.parseH264Packet(ppsPacket)
 	if !ok || !ppsInfo.info.IsPPS {
 		t.Fatalf("expected PPS packet to parse")
 	}
-	proxy.cacheParameterSet(spsInfo.payload, true)
-	proxy.cacheParameterSet(ppsInfo.payload, false)
+	proxy.cacheParameterSet(spsInfo.payload)
+	proxy.cacheParameterSet(ppsInfo.payload)
 
 	proxy.handleVideoPacket(idrPacket, dest)
 
@@ -89,3 +92,306 @@ func TestVideoProxyInjectCachedSPSPPSOnIDR(t *testing.T) {
 		t.Fatalf("unexpected seq delta: got=%d want=2", counters.VideoSeqDelta)
 	}
 }
+
+// TestVideoProxyInjectionRetriedWithinFrame verifies that a failed write of an
+// injected SPS is retried before the IDR packet of the same frame goes out, so
+// a single transient send error does not leave the IDR without parameter
+// sets. writeToDest is stubbed to fail only the first injected packet. The
+// expected output is SPS, PPS, IDR in order with contiguous sequence numbers,
+// one retry and no injection failure.
+func TestVideoProxyInjectionRetriedWithinFrame(t *testing.T) {
+	session := &Session{ID: "S-inject-retry"}
+	proxy := &videoProxy{
+		session:            session,
+		fixEnabled:         true,
+		injectCachedSPSPPS: true,
+		logger:             logging.WithSessionID(session.ID),
+	}
+	var output [][]byte
+	writes := 0
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		writes++
+		if writes == 1 {
+			return errors.New("no buffer space available")
+		}
+		clone := make([]byte, len(packet))
+		copy(clone, packet)
+		output = append(output, clone)
+		return nil
+	}
+
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	proxy.cacheParameterSet([]byte{0x67})
+	proxy.cacheParameterSet([]byte{0x68})
+
+	proxy.handleVideoPacket(makeRTPPacket(12, 9000, []byte{0x65}), dest)
+
+	if len(output) != 3 {
+		t.Fatalf("expected 3 output packets, got %d", len(output))
+	}
+	if output[0][12] != 0x67 || output[1][12] != 0x68 || output[2][12] != 0x65 {
+		t.Fatalf("unexpected output order: %x %x %x", output[0][12], output[1][12], output[2][12])
+	}
+	firstSeq := binary.BigEndian.Uint16(output[0][2:4])
+	secondSeq := binary.BigEndian.Uint16(output[1][2:4])
+	thirdSeq := binary.BigEndian.Uint16(output[2][2:4])
+	if firstSeq+1 != secondSeq || secondSeq+1 != thirdSeq {
+		t.Fatalf("unexpected seq order: got=%d,%d,%d", firstSeq, secondSeq, thirdSeq)
+	}
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoInjectionRetries != 1 {
+		t.Fatalf("unexpected injection retries: got=%d want=1", counters.VideoInjectionRetries)
+	}
+	if counters.VideoInjectionFailures != 0 {
+		t.Fatalf("unexpected injection failures: got=%d want=0", counters.VideoInjectionFailures)
+	}
+	if counters.VideoInjectedSPS != 1 || counters.VideoInjectedPPS != 1 {
+		t.Fatalf("unexpected injected counts: sps=%d pps=%d", counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+	}
+}
+
+// TestVideoProxyInjectionFailureArmsNextIDR verifies that when every injection
+// attempt for a frame fails, the failure is counted and the next IDR injects
+// cached parameter sets even though a pending SPS would normally suppress it.
+func TestVideoProxyInjectionFailureArmsNextIDR(t *testing.T) {
+	session := &Session{ID: "S-inject-fail"}
+	proxy := &videoProxy{
+		session:            session,
+		fixEnabled:         true,
+		injectCachedSPSPPS: true,
+		logger:             logging.WithSessionID(session.ID),
+	}
+	failInjected := true
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		if failInjected && packet[12] != 0x65 {
+			return errors.New("no buffer space available")
+		}
+		clone := make([]byte, len(packet))
+		copy(clone, packet)
+		output = append(output, clone)
+		return nil
+	}
+
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	proxy.cacheParameterSet([]byte{0x67})
+	proxy.cacheParameterSet([]byte{0x68})
+
+	proxy.handleVideoPacket(makeRTPPacket(12, 9000, []byte{0x65}), dest)
+
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoInjectionFailures != 1 {
+		t.Fatalf("unexpected injection failures: got=%d want=1", counters.VideoInjectionFailures)
+	}
+	if !proxy.forceInjectOnIDR {
+		t.Fatalf("expected next IDR to be armed for injection")
+	}
+
+	failInjected = false
+	output = nil
+	proxy.storePendingParameterSet(makeRTPPacket(13, 9000, []byte{0x67}))
+	proxy.handleVideoPacket(makeRTPPacket(14, 12000, []byte{0x65}), dest)
+
+	counters = snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoInjectedSPS != 1 || counters.VideoInjectedPPS != 1 {
+		t.Fatalf("unexpected injected counts: sps=%d pps=%d", counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+	}
+	if proxy.forceInjectOnIDR {
+		t.Fatalf("expected forced injection to be consumed")
+	}
+}
+
+// TestVideoProxyOutputSeqContinuous verifies that fix mode numbers its output
+// continuously. This matters because output numbers used to follow the input
+// plus the injected count only with injection configured, so input gaps went
+// through as they were, and injection switched on mid-call made the sequence
+// jump. Inputs: with injection off, an IDR, a slice, an SPS and PPS ahead of
+// an IDR and a slice after a gap of 5; with injection then on, an IDR; and
+// with it off again, a slice. The expected output is 10 packets numbered 100
+// to 109 in a row, the second IDR preceded by the injected SPS and PPS.
+func TestVideoProxyOutputSeqContinuous(t *testing.T) {
+	session := &Session{ID: "S-seq"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var seqs []uint16
+	var types []byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		seqs = append(seqs, binary.BigEndian.Uint16(packet[2:4]))
+		types = append(types, packet[12]&0x1f)
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	proxy.handleVideoPacket(makeRTPPacket(100, 9000, []byte{0x65}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(101, 18000, []byte{0x41}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(102, 27000, []byte{0x67}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(103, 27000, []byte{0x68}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(104, 27000, []byte{0x65}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(110, 36000, []byte{0x41}), dest)
+	proxy.injectCachedSPSPPS = true
+	proxy.handleVideoPacket(makeRTPPacket(111, 45000, []byte{0x65}), dest)
+	proxy.injectCachedSPSPPS = false
+	proxy.handleVideoPacket(makeRTPPacket(112, 54000, []byte{0x41}), dest)
+
+	if string(types) != string([]byte{5, 1, 7, 8, 5, 1, 7, 8, 5, 1}) {
+		t.Fatalf("unexpected NAL types sent: %v", types)
+	}
+	for i, seq := range seqs {
+		if seq != uint16(100+i) {
+			t.Fatalf("expected seq %d at position %d, got %v", 100+i, i, seqs)
+		}
+	}
+}
+
+// TestVideoProxyOutputSeqContinuousAfterWriteError verifies that a packet the
+// B leg write fails on leaves no gap in the output numbering. This matters
+// because its number used to be taken before the write, so the B leg saw a
+// gap as if the packet were lost on the way. Inputs: an IDR and three slices
+// numbered 100 to 103, the write of 101 failing. The expected output is the
+// IDR and the two other slices numbered 100 to 102, and one write error
+// counted.
+func TestVideoProxyOutputSeqContinuousAfterWriteError(t *testing.T) {
+	session := &Session{ID: "S-seq-write-error"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var seqs []uint16
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		if packet[13] == 101 {
+			return errors.New("no buffer space available")
+		}
+		seqs = append(seqs, binary.BigEndian.Uint16(packet[2:4]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	proxy.handleVideoPacket(makeRTPPacket(100, 9000, []byte{0x65, 100}), dest)
+	for seq := uint16(101); seq <= 103; seq++ {
+		proxy.handleVideoPacket(makeRTPPacket(seq, 9000*uint32(seq-99), []byte{0x41, byte(seq)}), dest)
+	}
+
+	if len(seqs) != 3 || seqs[0] != 100 || seqs[1] != 101 || seqs[2] != 102 {
+		t.Fatalf("expected seqs [100 101 102], got %v", seqs)
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.DropsWriteError != 1 {
+		t.Fatalf("expected one write error, got %d", counters.DropsWriteError)
+	}
+}
+
+// TestVideoProxyInjectionKeepsHeaderExtension verifies that injected SPS and
+// PPS carry the header of the IDR they precede. This matters because they
+// used to go out with a bare 12-byte header, and downstream elements pacing
+// on abs-send-time choke when the header extension comes and goes. Input: an
+// IDR with one CSRC and a header extension, with SPS and PPS cached. The
+// expected output is the injected SPS and PPS with the IDR's first byte, CSRC
+// and extension bytes, and their own payload.
+func TestVideoProxyInjectionKeepsHeaderExtension(t *testing.T) {
+	session := &Session{ID: "S-inject-ext"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, true, VideoFixConfig{}, ProxyLogConfig{})
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		output = append(output, append([]byte{}, packet...))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	ext := []byte{0x00, 0x00, 0x00, 0x0a, 0xbe, 0xde, 0x00, 0x01, 0x32, 0x01, 0x02, 0x03}
+	idr := append(makeRTPPacket(12, 9000, nil), ext...)
+	idr = append(idr, 0x65)
+	idr[0] |= 0x10 | 0x01
+	proxy.cacheParameterSet([]byte{0x67, 0x42})
+	proxy.cacheParameterSet([]byte{0x68, 0xce})
+
+	proxy.handleVideoPacket(idr, dest)
+
+	if len(output) != 3 {
+		t.Fatalf("expected SPS, PPS and IDR, got %d packets", len(output))
+	}
+	for i, want := range [][]byte{{0x67, 0x42}, {0x68, 0xce}} {
+		packet := output[i]
+		if packet[0] != idr[0] || !bytes.Equal(packet[12:12+len(ext)], ext) || !bytes.Equal(packet[12+len(ext):], want) {
+			t.Fatalf("expected injected packet %d with the IDR header and payload %x, got %x", i, want, packet)
+		}
+	}
+}
+
+// TestVideoProxyInjectionTimestamps verifies that injected SPS and PPS carry
+// the timestamp of the IDR they precede, as it goes out. This matters because
+// some decoders discard parameter sets stamped apart from the IDR as stale.
+// Inputs: the first IDR of a session at timestamp 123456, then a second IDR
+// at 999999, which the fixer re-stamps on its own frame clock, with SPS and
+// PPS cached. The expected output for each IDR is the SPS, PPS and IDR sent
+// with one timestamp, 123456 for the first.
+func TestVideoProxyInjectionTimestamps(t *testing.T) {
+	session := &Session{ID: "S-inject-ts"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, true, VideoFixConfig{}, ProxyLogConfig{})
+	var stamps []uint32
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		stamps = append(stamps, binary.BigEndian.Uint32(packet[4:8]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	proxy.cacheParameterSet([]byte{0x67, 0x42})
+	proxy.cacheParameterSet([]byte{0x68, 0xce})
+
+	for i, ts := range []uint32{123456, 999999} {
+		stamps = stamps[:0]
+		proxy.handleVideoPacket(makeRTPPacket(uint16(10+i), ts, []byte{0x65}), dest)
+		if len(stamps) != 3 || stamps[0] != stamps[2] || stamps[1] != stamps[2] {
+			t.Fatalf("expected SPS, PPS and IDR %d with one timestamp, got %v", i, stamps)
+		}
+		if i == 0 && stamps[2] != ts {
+			t.Fatalf("expected the first IDR to keep timestamp %d, got %d", ts, stamps[2])
+		}
+	}
+}
+
+// TestVideoProxySPSChangeRefreshesInjection verifies that an SPS differing
+// from the cached one replaces it and is injected before the next IDR. This
+// matters because a doorphone changing resolution mid-call would otherwise
+// leave decoders on stale parameter sets, and a pending SPS sent just before
+// the IDR used to suppress the injection. Inputs: SPS A and a PPS cached and
+// an IDR, then SPS B ahead of the next IDR. The expected output is one SPS
+// change counted and, ahead of the second IDR, SPS B and the PPS injected,
+// then the pending SPS B: no SPS A.
+func TestVideoProxySPSChangeRefreshesInjection(t *testing.T) {
+	session := &Session{ID: "S-sps-change"}
+	proxy := &videoProxy{
+		session:            session,
+		fixEnabled:         true,
+		injectCachedSPSPPS: true,
+		logger:             logging.WithSessionID(session.ID),
+	}
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		output = append(output, bytes.Clone(packet[12:]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	spsA := []byte{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40}
+	spsB := []byte{0x67, 0x42, 0xc0, 0x20, 0x8c, 0x68, 0x05, 0x00, 0x5b, 0xa0, 0x1e, 0x11, 0x08, 0xd4}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	proxy.cacheParameterSet(spsA)
+	proxy.cacheParameterSet(pps)
+	proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{0x65}), dest)
+
+	output = nil
+	proxy.handleVideoPacket(makeRTPPacket(2, 18000, spsB), dest)
+	proxy.handleVideoPacket(makeRTPPacket(3, 18000, []byte{0x65}), dest)
+
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoSPSChanges != 1 || counters.VideoPPSChanges != 0 {
+		t.Fatalf("expected one SPS change, got sps=%d pps=%d", counters.VideoSPSChanges, counters.VideoPPSChanges)
+	}
+	if counters.VideoInjectedSPS != 2 || counters.VideoInjectedPPS != 2 {
+		t.Fatalf("expected injection before both IDRs, got sps=%d pps=%d", counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+	}
+	want := [][]byte{spsB, pps, spsB, {0x65}}
+	if len(output) != len(want) {
+		t.Fatalf("expected %d packets, got % x", len(want), output)
+	}
+	for i := range want {
+		if !bytes.Equal(output[i], want[i]) {
+			t.Fatalf("expected packet %d % x, got % x", i, want[i], output[i])
+		}
+	}
+	if sps, ok := session.VideoSPS(); !ok || sps.Width != 1280 || sps.Height != 720 {
+		t.Fatalf("expected SPS B to be reported as 1280x720, got %+v (%v)", sps, ok)
+	}
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyFrameBufferOverflow verifies the hard caps on the frame being
+// assembled. This matters because a doorphone in the field sets the FU-A start
+// bit but never the end bit, and the proxy then buffered every slice packet
+// until MAX_FRAME_WAIT_MS, megabytes per session at high bitrates. Inputs: an
+// FU-A start followed by FU-A middles, with a cap of 4 packets or of 4000
+// bytes (1000-byte packets), and a frame wait long enough never to expire.
+// The expected output is the first five packets flushed as soon as the fifth
+// is buffered, before any frame end, with one overflow and one forced flush
+// counted, and the buffer empty afterwards.
+func TestVideoProxyFrameBufferOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		config VideoFixConfig
+	}{
+		{"packets", VideoFixConfig{MaxFrameBufferPackets: 4}},
+		{"bytes", VideoFixConfig{MaxFrameBufferBytes: 4000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-overflow"}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, tt.config, ProxyLogConfig{})
+			var sent int
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				sent++
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+			fragment := func(seq uint16, fuHeader byte) []byte {
+				payload := make([]byte, 1000-12)
+				payload[0] = 0x7c
+				payload[1] = fuHeader
+				return makeRTPPacket(seq, 9000, payload)
+			}
+
+			proxy.handleVideoPacket(fragment(1, 0x85), dest)
+			for seq := uint16(2); seq <= 4; seq++ {
+				proxy.handleVideoPacket(fragment(seq, 0x05), dest)
+			}
+			if sent != 0 {
+				t.Fatalf("expected the frame held at the cap, got %d packets sent", sent)
+			}
+			proxy.handleVideoPacket(fragment(5, 0x05), dest)
+
+			counters := snapshotVideoCounters(&session.videoCounters)
+			if sent != 5 || counters.VideoBufferOverflows != 1 || counters.VideoForcedFlushes != 1 {
+				t.Fatalf("expected one overflow flushing 5 packets, got sent=%d overflows=%d forced=%d", sent, counters.VideoBufferOverflows, counters.VideoForcedFlushes)
+			}
+			if state := proxy.frameBufferState(time.Now()); state.FramePackets != 0 || proxy.frameBufferBytes != 0 {
+				t.Fatalf("expected an empty frame buffer, got %+v and %d bytes", state, proxy.frameBufferBytes)
+			}
+		})
+	}
+}
+
+// TestVideoProxyPendingParameterSetsOverflow verifies that the bytes cap also
+// bounds the SPS/PPS held for the next frame. Inputs: a 1000-byte SPS and a
+// 1000-byte PPS outside a frame with a cap of 1500 bytes. The expected output
+// is the SPS held, then both sent in order with one overflow counted once the
+// PPS brings the total past the cap.
+func TestVideoProxyPendingParameterSetsOverflow(t *testing.T) {
+	session := &Session{ID: "S-pending-overflow"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{MaxFrameBufferBytes: 1500}, ProxyLogConfig{})
+	var sent []byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		sent = append(sent, packet[12]&0x1f)
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	parameterSet := func(seq uint16, header byte) []byte {
+		payload := make([]byte, 1000-12)
+		payload[0] = header
+		return makeRTPPacket(seq, 9000, payload)
+	}
+
+	proxy.handleVideoPacket(parameterSet(1, 0x67), dest)
+	if len(sent) != 0 || proxy.pendingSPS == nil {
+		t.Fatalf("expected the SPS held, got %v sent", sent)
+	}
+	proxy.handleVideoPacket(parameterSet(2, 0x68), dest)
+	if string(sent) != string([]byte{7, 8}) || proxy.pendingSPS != nil || proxy.pendingPPS != nil {
+		t.Fatalf("expected SPS then PPS sent, got %v", sent)
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoBufferOverflows != 1 {
+		t.Fatalf("expected one overflow, got %d", counters.VideoBufferOverflows)
+	}
+}
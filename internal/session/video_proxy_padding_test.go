@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// makePaddedRTPPacket builds a packet with the P bit set and padding ending
+// in its own length.
+func makePaddedRTPPacket(seq uint16, ts uint32, payload []byte, paddingLen int) []byte {
+	padding := make([]byte, paddingLen)
+	padding[paddingLen-1] = byte(paddingLen)
+	packet := makeRTPPacket(seq, ts, append(append([]byte{}, payload...), padding...))
+	packet[0] |= 0x20
+	return packet
+}
+
+// TestVideoProxyPaddedPackets verifies that the fixer looks past RTP padding.
+// This matters because padding bytes were taken for H264 payload, so a padded
+// SPS was cached and later injected with the padding glued to it. Inputs: a
+// padded single-NAL SPS and PPS, then a padded FU-A start and end of an IDR
+// frame. The expected
+// output is the SPS and PPS cached without padding, one frame started and
+// ended, and the four packets sent with their padding untouched.
+func TestVideoProxyPaddedPackets(t *testing.T) {
+	session := &Session{ID: "S-padding"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		output = append(output, append([]byte{}, packet...))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	packets := [][]byte{
+		makePaddedRTPPacket(1, 9000, []byte{0x67, 0x42}, 4),
+		makePaddedRTPPacket(2, 9000, []byte{0x68, 0xce}, 2),
+		makePaddedRTPPacket(3, 9000, []byte{0x7c, 0x85, 0x01}, 5),
+		makePaddedRTPPacket(4, 9000, []byte{0x7c, 0x45, 0x02}, 3),
+	}
+
+	for _, packet := range packets {
+		proxy.analyzeFrameBoundaries(packet, time.Now(), true)
+		proxy.handleVideoPacket(append([]byte{}, packet...), dest)
+	}
+
+	if !bytes.Equal(proxy.cachedSPS, []byte{0x67, 0x42}) || !bytes.Equal(proxy.cachedPPS, []byte{0x68, 0xce}) {
+		t.Fatalf("expected parameter sets cached without padding, got sps=%x pps=%x", proxy.cachedSPS, proxy.cachedPPS)
+	}
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.VideoFramesStarted != 1 || counters.VideoFramesEnded != 1 {
+		t.Fatalf("expected one frame started and ended, got %d and %d", counters.VideoFramesStarted, counters.VideoFramesEnded)
+	}
+	if len(output) != len(packets) {
+		t.Fatalf("expected %d packets sent, got %d", len(packets), len(output))
+	}
+	for i, packet := range output {
+		if packet[0]&0x20 == 0 || !bytes.Equal(packet[12:], packets[i][12:]) {
+			t.Fatalf("expected packet %d sent with payload and padding intact, got %x", i, packet)
+		}
+	}
+}
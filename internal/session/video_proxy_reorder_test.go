@@ -0,0 +1,76 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestVideoProxyReordersFrameFragments verifies that a buffered frame leaves
+// in sequence order. This matters because reordering networks such as LTE
+// backhaul deliver FU-A fragments out of order, and decoders reject the frame
+// even though every fragment arrived. Inputs: the start, end and middle
+// fragments of one IDR frame in that order, with the end fragment repeated,
+// and sequence numbers across the 16-bit wrap. The expected output is the
+// start, middle and end fragments once each, the marker on the end only, and
+// one reordered frame counted.
+func TestVideoProxyReordersFrameFragments(t *testing.T) {
+	session := &Session{ID: "S-reorder"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		clone := make([]byte, len(packet))
+		copy(clone, packet)
+		output = append(output, clone)
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	start := makeRTPPacket(65535, 9000, []byte{0x7c, 0x85, 0x00})
+	middle := makeRTPPacket(0, 9000, []byte{0x7c, 0x05, 0x01})
+	end := makeRTPPacket(1, 9000, []byte{0x7c, 0x45, 0x02})
+
+	proxy.handleVideoPacket(start, dest)
+	proxy.bufferFramePacket(time.Now(), dest, end)
+	proxy.bufferFramePacket(time.Now(), dest, end)
+	proxy.bufferFramePacket(time.Now(), dest, middle)
+	proxy.flushFrameBuffer(time.Now(), dest, false)
+
+	wantSeqs := []uint16{65535, 0, 1}
+	if len(output) != len(wantSeqs) {
+		t.Fatalf("expected %d packets, got %d", len(wantSeqs), len(output))
+	}
+	for i, packet := range output {
+		if seq := binary.BigEndian.Uint16(packet[2:4]); seq != wantSeqs[i] {
+			t.Fatalf("expected seq %d at position %d, got %d", wantSeqs[i], i, seq)
+		}
+		if marker := packet[1]&0x80 != 0; marker != (i == len(output)-1) {
+			t.Fatalf("unexpected marker %t on packet %d", marker, i)
+		}
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoFramesReordered != 1 {
+		t.Fatalf("expected one reordered frame, got %d", counters.VideoFramesReordered)
+	}
+}
+
+// TestOrderFramePackets_InOrder verifies that a frame already in order is
+// neither reported as reordered nor changed, duplicates aside. Input: three
+// packets in sequence with the last one repeated. The expected output is the
+// three packets in the same order and no reordering reported.
+func TestOrderFramePackets_InOrder(t *testing.T) {
+	packets := []framePacket{
+		{data: makeRTPPacket(10, 9000, []byte{0x7c, 0x85})},
+		{data: makeRTPPacket(11, 9000, []byte{0x7c, 0x05})},
+		{data: makeRTPPacket(12, 9000, []byte{0x7c, 0x45})},
+		{data: makeRTPPacket(12, 9000, []byte{0x7c, 0x45})},
+	}
+	ordered, reordered := orderFramePackets(packets)
+	if reordered || len(ordered) != 3 {
+		t.Fatalf("expected 3 packets and no reordering, got %d and %t", len(ordered), reordered)
+	}
+	for i, packet := range ordered {
+		if seq := binary.BigEndian.Uint16(packet.data[2:4]); seq != uint16(10+i) {
+			t.Fatalf("expected seq %d at position %d, got %d", 10+i, i, seq)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"rtp-stream-cleaner/internal/rtpfix"
+)
+
+// TestVideoProxyDropSEI verifies the removal of SEI NAL units under DropSEI.
+// This matters because a doorphone stuffing large proprietary SEI into every
+// frame triples the bandwidth toward rtpengine and has crashed a downstream
+// recorder. Inputs: an IDR, a lone SEI, a STAP-A of SEI, SPS and PPS, an IDR,
+// and a STAP-A of two SEI, with SEI dropped or kept. The expected output with
+// SEI dropped is the IDR, the STAP-A rebuilt with only the SPS and PPS, and
+// the second IDR under continuous sequence numbers, with four SEI units
+// counted. With SEI kept every packet is sent and none is counted.
+func TestVideoProxyDropSEI(t *testing.T) {
+	sei, sps, pps := []byte{0x06, 0x05, 0xff}, []byte{0x67, 0x42}, []byte{0x68, 0xce}
+	tests := []struct {
+		name        string
+		drop        bool
+		wantSent    []byte
+		wantDropped uint64
+	}{
+		{"drop", true, []byte{5, 24, 5}, 4},
+		{"keep", false, []byte{5, 6, 24, 5, 24}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-sei", Settings: Settings{DropSEI: tt.drop}}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+			var output [][]byte
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				output = append(output, append([]byte(nil), packet...))
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+			proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{0x65, 0x88}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(2, 12000, sei), dest)
+			proxy.handleVideoPacket(makeRTPPacket(3, 12000, makeSTAPA(sei, sps, pps)), dest)
+			proxy.handleVideoPacket(makeRTPPacket(4, 12000, []byte{0x65, 0x88}), dest)
+			proxy.handleVideoPacket(makeRTPPacket(5, 15000, makeSTAPA(sei, sei)), dest)
+
+			var sent []byte
+			for i, packet := range output {
+				sent = append(sent, packet[12]&0x1f)
+				if seq := binary.BigEndian.Uint16(packet[2:4]); seq != uint16(1+i) {
+					t.Fatalf("expected seq %d at position %d, got %d", 1+i, i, seq)
+				}
+			}
+			if string(sent) != string(tt.wantSent) {
+				t.Fatalf("expected NAL types %v sent, got %v", tt.wantSent, sent)
+			}
+			if tt.drop {
+				units, ok := rtpfix.SplitSTAPA(output[1][12:])
+				if !ok || len(units) != 2 || string(units[0]) != string(sps) || string(units[1]) != string(pps) {
+					t.Fatalf("expected the STAP-A rebuilt with SPS and PPS, got %x", output[1][12:])
+				}
+			}
+			if dropped := snapshotVideoCounters(&session.videoCounters).VideoSEIDropped; dropped != tt.wantDropped {
+				t.Fatalf("expected %d SEI units dropped, got %d", tt.wantDropped, dropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// makeSTAPA builds a STAP-A payload carrying units, each behind its 16-bit
+// size.
+func makeSTAPA(units ...[]byte) []byte {
+	payload := []byte{0x78}
+	for _, unit := range units {
+		payload = append(payload, byte(len(unit)>>8), byte(len(unit)))
+		payload = append(payload, unit...)
+	}
+	return payload
+}
+
+// TestVideoProxySTAPA verifies that the fixer looks inside STAP-A packets.
+// This matters because encoders that aggregate the SPS and PPS, often with
+// the start of the IDR, left the fixer with nothing cached to inject and no
+// frame start to assemble from. Inputs, with injection on: a STAP-A of SPS,
+// PPS and IDR followed by a lone IDR, and a STAP-A of SPS and PPS followed by
+// a lone IDR. The expected output is the SPS and PPS cached from the
+// aggregate in both cases. The first aggregate goes out as a frame of its own
+// without injection, and the cached SPS and PPS are then injected ahead of the
+// lone IDR. The second aggregate is held and sent with the IDR frame, which
+// needs no injection.
+func TestVideoProxySTAPA(t *testing.T) {
+	sps, pps := []byte{0x67, 0x42}, []byte{0x68, 0xce}
+	tests := []struct {
+		name         string
+		aggregate    []byte
+		wantSent     []byte
+		wantInjected uint64
+	}{
+		{"with idr", makeSTAPA(sps, pps, []byte{0x65, 0x88}), []byte{24, 7, 8, 5}, 1},
+		{"parameter sets only", makeSTAPA(sps, pps), []byte{24, 5}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{ID: "S-stapa"}
+			proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, true, VideoFixConfig{}, ProxyLogConfig{})
+			var sent []byte
+			proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+				sent = append(sent, packet[12]&0x1f)
+				return nil
+			}
+			dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+			proxy.handleVideoPacket(makeRTPPacket(1, 9000, tt.aggregate), dest)
+			if !bytes.Equal(proxy.cachedSPS, sps) || !bytes.Equal(proxy.cachedPPS, pps) {
+				t.Fatalf("expected SPS and PPS cached from the aggregate, got sps=%x pps=%x", proxy.cachedSPS, proxy.cachedPPS)
+			}
+			proxy.handleVideoPacket(makeRTPPacket(2, 12000, []byte{0x65, 0x88}), dest)
+
+			if string(sent) != string(tt.wantSent) {
+				t.Fatalf("expected NAL types %v sent, got %v", tt.wantSent, sent)
+			}
+			counters := snapshotVideoCounters(&session.videoCounters)
+			if counters.VideoInjectedSPS != tt.wantInjected || counters.VideoInjectedPPS != tt.wantInjected {
+				t.Fatalf("expected %d SPS and PPS injected, got %d and %d", tt.wantInjected, counters.VideoInjectedSPS, counters.VideoInjectedPPS)
+			}
+		})
+	}
+}
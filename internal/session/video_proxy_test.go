@@ -3,7 +3,11 @@ package session
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -19,7 +23,7 @@ func TestVideoProxyRawModeForwardsPackets(t *testing.T) {
 	dest := localUDPAddr(rtpEngineConn)
 	session.videoDest.Store(dest)
 
-	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, 50*time.Millisecond, false, true, ProxyLogConfig{})
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, 50*time.Millisecond, false, true, VideoFixConfig{}, ProxyLogConfig{})
 	proxy.start()
 	defer proxy.stop()
 
@@ -80,7 +84,7 @@ func TestVideoProxyFixModeForcedFlush(t *testing.T) {
 
 	dest := localUDPAddr(rtpEngineConn)
 
-	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, time.Millisecond, true, true, ProxyLogConfig{})
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, time.Millisecond, true, true, VideoFixConfig{}, ProxyLogConfig{})
 
 	fuStart := makeRTPPacket(1, 9000, []byte{28, 0x85})
 	proxy.handleVideoPacket(fuStart, dest)
@@ -123,3 +127,416 @@ func localUDPAddr(conn *net.UDPConn) *net.UDPAddr {
 	addr := conn.LocalAddr().(*net.UDPAddr)
 	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port}
 }
+
+// TestVideoProxyFrameBufferState verifies the buffer snapshot exposed through
+// GET: an SPS seen between frames is both cached and pending, and once a
+// frame starts the pending SPS moves into the frame buffer. This matters
+// because these fields are how operators tell a stuck half-assembled frame
+// from a stream that never sent an SPS. Raw mode must report the zero value.
+func TestVideoProxyFrameBufferState(t *testing.T) {
+	session := &Session{ID: "S-buffer"}
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	defer aConn.Close()
+	defer bConn.Close()
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	dest := localUDPAddr(rtpEngineConn)
+
+	proxy := newVideoProxy(session, aConn, bConn, 0, time.Second, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	session.videoProxy = proxy
+
+	proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{7, 0x42}), dest)
+	state := session.VideoBufferState()
+	if !state.HasCachedSPS || !state.HasPendingSPS || state.HasCachedPPS || state.FramePackets != 0 {
+		t.Fatalf("unexpected state after sps: %+v", state)
+	}
+
+	proxy.handleVideoPacket(makeRTPPacket(2, 9000, []byte{28, 0x81, 0x00}), dest)
+	state = session.VideoBufferState()
+	if state.HasPendingSPS || !state.HasCachedSPS || state.FramePackets != 2 {
+		t.Fatalf("unexpected state with frame in progress: %+v", state)
+	}
+
+	raw := newVideoProxy(session, aConn, bConn, 0, time.Second, false, false, VideoFixConfig{}, ProxyLogConfig{})
+	raw.cachedSPS = []byte{7}
+	session.videoProxy = raw
+	if state := session.VideoBufferState(); state != (VideoBufferState{}) {
+		t.Fatalf("expected zero state in raw mode, got %+v", state)
+	}
+}
+
+// TestVideoProxyMuxedRTCPBypassesFix verifies that RTCP the doorphone
+// multiplexes on the video RTP port (RFC 5761) is relayed untouched in fix
+// mode instead of entering the frame buffer. This matters because a sender
+// report parsed as RTP would feed a bogus SSRC to keyframe requests and
+// corrupt the sequence and NAL tracking. Inputs: a fix-mode proxy and a
+// muxed sender report from the doorphone. The expected output is the same
+// bytes at rtpengine, one muxed RTCP packet counted, no frame, sequence or
+// NAL counters touched and no peer SSRC recorded.
+func TestVideoProxyMuxedRTCPBypassesFix(t *testing.T) {
+	session := &Session{ID: "S-mux"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newVideoProxy(session, aConn, bConn, 200*time.Millisecond, 50*time.Millisecond, true, true, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	senderReport := []byte{0x80, 200, 0x00, 0x06, 0x01, 0x02, 0x03, 0x04, 0xe9, 0x00, 0x00, 0x00, 0, 0, 0, 0, 0, 0, 0x23, 0x28, 0, 0, 0, 1, 0, 0, 0, 2}
+	if _, err := doorphoneConn.WriteToUDP(senderReport, localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	readRTCP(t, rtpEngineConn, senderReport)
+
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.RTCP.MuxPkts != 1 || counters.BOutPkts != 1 {
+		t.Fatalf("expected one muxed rtcp packet relayed, got %+v", counters)
+	}
+	if counters.VideoFramesStarted != 0 || counters.VideoSeqDelta != 0 || session.videoCounters.videoSeqGaps.Load() != 0 || session.videoCounters.videoNalParseErrors.Load() != 0 {
+		t.Fatalf("expected the fix pipeline to be bypassed, got %+v", counters)
+	}
+	if ssrc, ok := proxy.peerSSRC(); ok {
+		t.Fatalf("expected no peer ssrc from rtcp, got 0x%08x", ssrc)
+	}
+}
+
+// syncBuffer lets a proxy goroutine and the test share a log buffer.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestVideoProxyLogsStats verifies the periodic and final video.proxy.stats
+// entries. This matters because video was otherwise only observable by polling
+// the API. Inputs: a fix-mode proxy with a 10ms stats interval and preset
+// counters, stopped after a few intervals. The expected output is at least one
+// periodic entry and one final entry, both carrying the per-leg counters, the
+// frame and injection counters, the sequence delta and fix_enabled.
+func TestVideoProxyLogsStats(t *testing.T) {
+	session := &Session{ID: "S-stats"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	proxy := newVideoProxy(session, aConn, bConn, time.Second, time.Second, true, false, VideoFixConfig{}, ProxyLogConfig{StatsInterval: 10 * time.Millisecond})
+	var out syncBuffer
+	proxy.logger = slog.New(slog.NewJSONHandler(&out, nil))
+	counters := &session.videoCounters
+	counters.aInPkts.Store(10)
+	counters.bOutPkts.Store(9)
+	counters.bInPkts.Store(3)
+	counters.videoFramesStarted.Store(4)
+	counters.videoFramesEnded.Store(3)
+	counters.videoFramesFlushed.Store(2)
+	counters.videoInjectedSPS.Store(1)
+	counters.videoSeqDelta.Store(5)
+
+	proxy.start()
+	time.Sleep(50 * time.Millisecond)
+	proxy.stop()
+
+	var periodic, final int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if entry["msg"] != "video.proxy.stats" {
+			continue
+		}
+		if entry["final"] == true {
+			final++
+		} else {
+			periodic++
+		}
+		want := map[string]any{
+			"a_in_pkts": 10.0, "b_out_pkts": 9.0, "b_in_pkts": 3.0, "pkts_in": 13.0,
+			"frames": 4.0, "frames_ended": 3.0, "frames_flushed": 2.0,
+			"injected_sps": 1.0, "injected_pps": 0.0, "seq_delta": 5.0, "fix_enabled": true,
+		}
+		for key, value := range want {
+			if entry[key] != value {
+				t.Fatalf("expected %s=%v, got %v in %s", key, value, entry[key], line)
+			}
+		}
+	}
+	if periodic == 0 || final != 1 {
+		t.Fatalf("expected periodic entries and one final entry, got %d and %d", periodic, final)
+	}
+}
+
+// TestVideoProxyLogsPacketAnomalies verifies the video packet log. This
+// matters because a stream that decodes badly on the far end is diagnosed
+// from these entries, and seq/ts alone do not show which NAL unit or FU-A
+// fragment went missing. Inputs: an anomaly-only proxy fed an FU-A start, a
+// middle fragment, a second start before any end, an end after a sequence
+// gap, a header-only packet and 4 bytes of junk; then a sampling-only proxy
+// fed four single NAL units with PACKET_LOG_SAMPLE_N=2. The expected output
+// is one anomaly entry per broken packet with its reason, NAL type, the name
+// of the type when the payload parsed, and FU flags, and two sampled entries
+// without an anomaly reason.
+func TestVideoProxyLogsPacketAnomalies(t *testing.T) {
+	fuA := func(seq uint16, fuHeader byte) []byte {
+		return makeRTPPacket(seq, 3000, []byte{0x7c, fuHeader, 0xaa})
+	}
+	feed := func(proxy *videoProxy, packets ...[]byte) []map[string]any {
+		var out syncBuffer
+		proxy.logger = slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		var packetCount uint64
+		var lastSeq uint16
+		var hasLastSeq, fuOpen bool
+		for _, packet := range packets {
+			header, headerOK, seqGap := proxy.trackSeqGap(packet, &lastSeq, &hasLastSeq)
+			proxy.logPacketIfNeeded("a->b", packet, header, headerOK, seqGap, &packetCount, &fuOpen)
+		}
+		var entries []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("invalid log line %q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	anomalies := newVideoProxy(&Session{ID: "S-packet-log"}, nil, nil, time.Second, time.Second, false, false, VideoFixConfig{}, ProxyLogConfig{PacketLog: true, PacketLogOnAnomaly: true})
+	entries := feed(anomalies,
+		fuA(1, 0x85),
+		fuA(2, 0x05),
+		fuA(3, 0x85),
+		fuA(5, 0x45),
+		makeRTPPacket(6, 3000, nil),
+		[]byte{1, 2, 3, 4},
+	)
+	want := []map[string]any{
+		{"anomaly": "fu_start_without_end", "seq": 3.0, "nal_type": 5.0, "nal_name": "idr_slice", "fu": true, "fu_start": true, "fu_end": false},
+		{"anomaly": "seq_gap", "seq": 5.0, "nal_type": 5.0, "nal_name": "idr_slice", "fu": true, "fu_start": false, "fu_end": true},
+		{"anomaly": "h264_parse", "seq": 6.0, "fu": false, "nal_name": nil},
+		{"anomaly": "rtp_parse", "size": 4.0},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d anomaly entries, got %+v", len(want), entries)
+	}
+	for i, fields := range want {
+		if entries[i]["msg"] != "video.proxy.packet.anomaly" {
+			t.Fatalf("expected entry %d to be an anomaly, got %+v", i, entries[i])
+		}
+		for key, value := range fields {
+			if entries[i][key] != value {
+				t.Fatalf("expected %s=%v in entry %d, got %+v", key, value, i, entries[i])
+			}
+		}
+	}
+
+	sampled := newVideoProxy(&Session{ID: "S-packet-sample"}, nil, nil, time.Second, time.Second, false, false, VideoFixConfig{}, ProxyLogConfig{PacketLog: true, PacketLogSampleN: 2})
+	entries = feed(sampled,
+		makeRTPPacket(1, 3000, []byte{0x65, 0xaa}),
+		makeRTPPacket(2, 6000, []byte{0x41, 0xaa}),
+		makeRTPPacket(4, 9000, []byte{0x41, 0xaa}),
+		makeRTPPacket(5, 12000, []byte{0x41, 0xaa}),
+	)
+	if len(entries) != 2 {
+		t.Fatalf("expected two sampled entries, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry["msg"] != "video.proxy.packet" || entry["nal_type"] != 1.0 || entry["nal_name"] != "non_idr_slice" || entry["fu"] != false || entry["anomaly"] != nil {
+			t.Fatalf("unexpected sampled entry %+v", entry)
+		}
+	}
+}
+
+// TestVideoProxyIgnoresPacketsWhileDisabled verifies that a port-0 disable
+// stops the video proxy mid-stream. This matters because a doorphone keeps
+// sending after rtpengine disables video, and the fixer used to keep
+// buffering frames for a stream nobody receives. Inputs: a fix-mode proxy
+// buffering the first fragment of an IDR frame, then video disabled and the
+// rest of the frame sent. The expected output is the buffered frame dropped,
+// nothing forwarded, and the packets counted as ignored_disabled only.
+func TestVideoProxyIgnoresPacketsWhileDisabled(t *testing.T) {
+	session := &Session{ID: "S-video-disabled"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+
+	proxy := newVideoProxy(session, aConn, bConn, time.Second, time.Second, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	buffered := func() bool {
+		proxy.bufferMu.Lock()
+		defer proxy.bufferMu.Unlock()
+		return proxy.frameBufferActive && len(proxy.frameBuffer) > 0
+	}
+	send := func(packet []byte) {
+		if _, err := doorphoneConn.WriteToUDP(packet, localUDPAddr(aConn)); err != nil {
+			t.Fatalf("send to a-leg failed: %v", err)
+		}
+	}
+	waitFor := func(what string, done func() bool) {
+		deadline := time.Now().Add(time.Second)
+		for !done() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	send(makeRTPPacket(1, 9000, []byte{0x7c, 0x85, 0x00}))
+	waitFor("the fragment to be buffered", buffered)
+
+	session.videoEnabled.Store(false)
+	send(makeRTPPacket(2, 9000, []byte{0x7c, 0x05, 0x01}))
+	send(makeRTPPacket(3, 9000, []byte{0x7c, 0x45, 0x02}))
+	waitFor("the packets to be ignored", func() bool {
+		return session.videoCounters.ignoredDisabled.Load() == 2
+	})
+	if buffered() {
+		t.Fatalf("expected the frame buffer to be reset on disable")
+	}
+	_ = rtpEngineConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := rtpEngineConn.ReadFromUDP(make([]byte, 2048)); err == nil {
+		t.Fatalf("expected nothing forwarded while disabled, got %d bytes", n)
+	}
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.BOutPkts != 0 || counters.Drops != 0 || counters.VideoFramesStarted != 1 {
+		t.Fatalf("expected only ignored packets, got %+v", counters)
+	}
+}
+
+// TestVideoProxyFlushesStalledFrameOnTimer verifies that an incomplete frame
+// is flushed once maxFrameWait expires even when nothing else arrives. This
+// matters because the flush used to wait for the next packet, so a doorphone
+// pausing mid-frame stalled the viewer for as long as the pause. Input: the
+// first fragment of an IDR frame and then silence, with a 50ms frame wait.
+// The expected output is the fragment at rtpengine with the marker set, no
+// earlier than the frame wait and well before a second, and one forced flush.
+func TestVideoProxyFlushesStalledFrameOnTimer(t *testing.T) {
+	session := &Session{ID: "S-flush-timer"}
+	session.videoEnabled.Store(true)
+	aConn := mustListenUDP(t)
+	bConn := mustListenUDP(t)
+	rtpEngineConn := mustListenUDP(t)
+	defer rtpEngineConn.Close()
+	doorphoneConn := mustListenUDP(t)
+	defer doorphoneConn.Close()
+	session.videoDest.Store(localUDPAddr(rtpEngineConn))
+
+	maxFrameWait := 50 * time.Millisecond
+	proxy := newVideoProxy(session, aConn, bConn, time.Second, maxFrameWait, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.start()
+	defer proxy.stop()
+
+	sentAt := time.Now()
+	if _, err := doorphoneConn.WriteToUDP(makeRTPPacket(1, 9000, []byte{0x7c, 0x85, 0x00}), localUDPAddr(aConn)); err != nil {
+		t.Fatalf("send to a-leg failed: %v", err)
+	}
+	buffer := make([]byte, 2048)
+	_ = rtpEngineConn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := rtpEngineConn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("expected the stalled frame flushed without further traffic: %v", err)
+	}
+	if elapsed := time.Since(sentAt); elapsed < maxFrameWait {
+		t.Fatalf("expected the frame held for %s, flushed after %s", maxFrameWait, elapsed)
+	}
+	if n < 2 || buffer[1]&0x80 == 0 {
+		t.Fatalf("expected the flushed packet to carry the marker, got %x", buffer[:n])
+	}
+	// The flush is counted after its packets are sent.
+	deadline := time.Now().Add(time.Second)
+	for session.videoCounters.videoForcedFlushes.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoForcedFlushes != 1 {
+		t.Fatalf("expected one forced flush, got %d", counters.VideoForcedFlushes)
+	}
+}
+
+// TestVideoProxyFlushesFrameOnStop verifies that the frame still being
+// assembled when a session stops is sent rather than discarded. This matters
+// because the last frame of every call used to be lost, showing as a
+// truncated last image in recordings. Input: the first two fragments of an
+// IDR frame buffered by a started proxy, then stop. The expected output is
+// both fragments written, the last one with the marker, and one forced flush
+// counted.
+func TestVideoProxyFlushesFrameOnStop(t *testing.T) {
+	session := &Session{ID: "S-flush-stop"}
+	session.videoEnabled.Store(true)
+	session.videoDest.Store(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000})
+	proxy := newVideoProxy(session, mustListenUDP(t), mustListenUDP(t), time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var output [][]byte
+	proxy.writeToDest = func(packet []byte, dest *net.UDPAddr) error {
+		output = append(output, append([]byte(nil), packet...))
+		return nil
+	}
+	proxy.start()
+
+	dest := session.videoDest.Load()
+	proxy.bufferMu.Lock()
+	proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{0x7c, 0x85, 0x00}), dest)
+	proxy.handleVideoPacket(makeRTPPacket(2, 9000, []byte{0x7c, 0x05, 0x01}), dest)
+	proxy.bufferMu.Unlock()
+	proxy.stop()
+
+	if len(output) != 2 || output[0][1]&0x80 != 0 || output[1][1]&0x80 == 0 {
+		t.Fatalf("expected both fragments with the marker on the last, got %x", output)
+	}
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoForcedFlushes != 1 {
+		t.Fatalf("expected one forced flush, got %d", counters.VideoForcedFlushes)
+	}
+}
+
+// TestVideoProxyParsesCachedSPS verifies that the fixer decodes the H264 SPS
+// it caches into the session. This matters because GET reports the picture
+// size, profile and level from it. Inputs: a fix-mode proxy, the 640x360
+// constrained baseline SPS of a doorphone, then a 1280x720 one. The expected
+// output is no SPS information before the first, then that of each SPS in
+// turn.
+func TestVideoProxyParsesCachedSPS(t *testing.T) {
+	session := &Session{ID: "S-sps", Settings: Settings{VideoFix: true}}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Second, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	proxy.writeToDest = func([]byte, *net.UDPAddr) error { return nil }
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	if _, ok := session.VideoSPS(); ok {
+		t.Fatalf("expected no SPS before one is cached")
+	}
+
+	proxy.handleVideoPacket(makeRTPPacket(1, 9000, []byte{0x67, 0x42, 0xc0, 0x1f, 0x8c, 0x68, 0x0a, 0x02, 0xff, 0x96, 0x01, 0xe1, 0x10, 0x8d, 0x40}), dest)
+	sps, ok := session.VideoSPS()
+	if !ok || sps.Width != 640 || sps.Height != 360 || sps.Profile() != "constrained baseline" || sps.Level() != "3.1" {
+		t.Fatalf("expected 640x360 constrained baseline 3.1, got %+v (%v)", sps, ok)
+	}
+
+	proxy.handleVideoPacket(makeRTPPacket(2, 18000, []byte{0x67, 0x42, 0xc0, 0x20, 0x8c, 0x68, 0x05, 0x00, 0x5b, 0xa0, 0x1e, 0x11, 0x08, 0xd4}), dest)
+	if sps, ok = session.VideoSPS(); !ok || sps.Width != 1280 || sps.Height != 720 || sps.Level() != "3.2" {
+		t.Fatalf("expected 1280x720 level 3.2, got %+v (%v)", sps, ok)
+	}
+}
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// sendVideoFrames hands frames of a start and an end FU-A fragment, stamped
+// with timestamps, to a fix-mode proxy of the given timestamp mode and returns
+// the RTP timestamp of every packet sent.
+func sendVideoFrames(t *testing.T, mode string, timestamps []uint32) []uint32 {
+	t.Helper()
+	session := &Session{ID: "S-timestamp", Settings: Settings{VideoFix: true, VideoTimestampMode: mode}}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var sent []uint32
+	proxy.writeToDest = func(packet []byte, _ *net.UDPAddr) error {
+		sent = append(sent, binary.BigEndian.Uint32(packet[4:8]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	seq := uint16(1)
+	for _, ts := range timestamps {
+		for _, fuHeader := range []byte{0x85, 0x45} {
+			proxy.handleVideoPacket(makeTaggedFragment(seq, ts, fuHeader, byte(seq)), dest)
+			seq++
+		}
+	}
+	return sent
+}
+
+// TestVideoProxyTimestampModes verifies the RTP timestamps of the frames the
+// fixer sends in both timestamp modes. This matters because rewrite re-stamps
+// frames from the server clock, which drifts against the untouched audio,
+// while passthrough must keep the doorphone's capture timing. Inputs: three
+// complete frames 200ms apart in RTP time (18000 ticks at 90kHz), handed to
+// the fixer back to back. The expected output is, in passthrough, every
+// packet carrying the timestamp of its frame; in rewrite, the first frame
+// keeping its own and the next ones advancing by the clamped 10-100ms of
+// server time, the same for both packets of a frame.
+func TestVideoProxyTimestampModes(t *testing.T) {
+	input := []uint32{9000, 27000, 45000}
+
+	sent := sendVideoFrames(t, VideoTimestampPassthrough, input)
+	if len(sent) != 6 {
+		t.Fatalf("expected 6 packets sent, got %d", len(sent))
+	}
+	for i, ts := range sent {
+		if ts != input[i/2] {
+			t.Fatalf("expected passthrough packet %d stamped %d, got %d", i, input[i/2], ts)
+		}
+	}
+
+	for _, mode := range []string{VideoTimestampRewrite, ""} {
+		sent = sendVideoFrames(t, mode, input)
+		if len(sent) != 6 {
+			t.Fatalf("expected 6 packets sent in mode %q, got %d", mode, len(sent))
+		}
+		if sent[0] != input[0] {
+			t.Fatalf("expected the first frame to keep %d in mode %q, got %d", input[0], mode, sent[0])
+		}
+		for i := 2; i < len(sent); i += 2 {
+			step := sent[i] - sent[i-2]
+			if sent[i+1] != sent[i] || step < 900 || step > 9000 {
+				t.Fatalf("expected frame %d re-stamped 900-9000 ticks after the previous one in mode %q, got %v", i/2, mode, sent)
+			}
+		}
+	}
+}
+
+// TestVideoProxyPassthroughForcedFlush verifies that a frame flushed early
+// keeps the doorphone's timestamp in passthrough mode, for the part flushed
+// and for the rest forwarded after it. This matters because the rest of the
+// frame belongs to the same picture, and the forwarded rest always keeps its
+// own timestamp. Inputs:
+// a passthrough proxy waiting 1ms for frames, the start fragment of a frame
+// stamped 9000, then after the wait its end fragment and the start and end of
+// the next frame stamped 27000. The expected output is a forced flush, both
+// fragments of the first frame sent with 9000 and those of the second with
+// 27000.
+func TestVideoProxyPassthroughForcedFlush(t *testing.T) {
+	session := &Session{ID: "S-timestamp-forced", Settings: Settings{VideoFix: true, VideoTimestampMode: VideoTimestampPassthrough}}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Millisecond, true, false, VideoFixConfig{}, ProxyLogConfig{})
+	var sent []uint32
+	proxy.writeToDest = func(packet []byte, _ *net.UDPAddr) error {
+		sent = append(sent, binary.BigEndian.Uint32(packet[4:8]))
+		return nil
+	}
+	dest := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	proxy.handleVideoPacket(makeTaggedFragment(1, 9000, 0x85, 1), dest)
+	time.Sleep(2 * time.Millisecond)
+	proxy.handleVideoPacket(makeTaggedFragment(2, 9000, 0x45, 2), dest)
+	proxy.handleVideoPacket(makeTaggedFragment(3, 27000, 0x85, 3), dest)
+	proxy.handleVideoPacket(makeTaggedFragment(4, 27000, 0x45, 4), dest)
+
+	if counters := snapshotVideoCounters(&session.videoCounters); counters.VideoForcedFlushes == 0 {
+		t.Fatalf("expected a forced flush")
+	}
+	want := []uint32{9000, 9000, 27000, 27000}
+	if len(sent) != len(want) {
+		t.Fatalf("expected timestamps %v, got %v", want, sent)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Fatalf("expected timestamps %v, got %v", want, sent)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package session
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// videoRateWindow is how long the video rate counters accumulate before the
+// window is published.
+const videoRateWindow = time.Second
+
+// videoRateStats measure what the doorphone sends on the video A leg, for
+// capacity planning. The read loop adds every packet to the counters of the
+// current window and a ticker swaps them out once per videoRateWindow,
+// publishing the rates of the window that ended. Averages are float64 bits.
+type videoRateStats struct {
+	bytes   atomic.Uint64
+	packets atomic.Uint64
+	frames  atomic.Uint64
+
+	bitrate     atomic.Uint64
+	fps         atomic.Uint64
+	framePkts   atomic.Uint64
+	frameBytes  atomic.Uint64
+	lastIDRNsec atomic.Int64
+}
+
+// VideoRateStats are the rates of the last complete window. Frames are
+// counted by their first packet, so the averages spread the window's packets
+// and bytes over the frames that started in it. LastIDR is zero until the
+// first IDR.
+type VideoRateStats struct {
+	BitrateBps      uint64
+	FPS             float64
+	AvgFramePackets float64
+	AvgFrameBytes   float64
+	LastIDR         time.Time
+}
+
+// add counts a packet of size bytes received at now, which starts a frame
+// with frameStart and an IDR frame with idr as well.
+func (s *videoRateStats) add(size int, frameStart, idr bool, now time.Time) {
+	s.bytes.Add(uint64(size))
+	s.packets.Add(1)
+	if frameStart {
+		s.frames.Add(1)
+	}
+	if idr {
+		s.lastIDRNsec.Store(now.UnixNano())
+	}
+}
+
+// roll ends a window that lasted elapsed and publishes its rates.
+func (s *videoRateStats) roll(elapsed time.Duration) {
+	bytes, packets, frames := s.bytes.Swap(0), s.packets.Swap(0), s.frames.Swap(0)
+	if elapsed <= 0 {
+		return
+	}
+	s.bitrate.Store(uint64(float64(bytes*8) / elapsed.Seconds()))
+	s.fps.Store(math.Float64bits(float64(frames) / elapsed.Seconds()))
+	var framePkts, frameBytes float64
+	if frames > 0 {
+		framePkts = float64(packets) / float64(frames)
+		frameBytes = float64(bytes) / float64(frames)
+	}
+	s.framePkts.Store(math.Float64bits(framePkts))
+	s.frameBytes.Store(math.Float64bits(frameBytes))
+}
+
+func (s *videoRateStats) snapshot() VideoRateStats {
+	stats := VideoRateStats{
+		BitrateBps:      s.bitrate.Load(),
+		FPS:             roundRate(math.Float64frombits(s.fps.Load())),
+		AvgFramePackets: roundRate(math.Float64frombits(s.framePkts.Load())),
+		AvgFrameBytes:   roundRate(math.Float64frombits(s.frameBytes.Load())),
+	}
+	if nsec := s.lastIDRNsec.Load(); nsec != 0 {
+		stats.LastIDR = time.Unix(0, nsec)
+	}
+	return stats
+}
+
+func roundRate(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// rateLoop publishes the video rate window once per videoRateWindow.
+func (p *videoProxy) rateLoop() {
+	ticker := time.NewTicker(videoRateWindow)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.session.videoCounters.rate.roll(now.Sub(last))
+			last = now
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+// TestVideoRateStats verifies the rates published for a window. Inputs: one
+// second with 10 frames of 3 packets of 1000 bytes, the first frame an IDR,
+// then a half-second window with a single packet. The expected output is
+// 240000 bps, 10 fps, 3 packets and 3000 bytes per frame with the IDR time
+// kept for the first window, then 16000 bps and no frames for the second.
+func TestVideoRateStats(t *testing.T) {
+	var stats videoRateStats
+	idrAt := time.Unix(1_700_000_000, 0)
+	for frame := 0; frame < 10; frame++ {
+		for packet := 0; packet < 3; packet++ {
+			stats.add(1000, packet == 0, frame == 0 && packet == 0, idrAt)
+		}
+	}
+	if got := stats.snapshot(); got.BitrateBps != 0 || !got.LastIDR.Equal(idrAt) {
+		t.Fatalf("expected nothing published before the window ends, got %+v", got)
+	}
+
+	stats.roll(time.Second)
+	want := VideoRateStats{BitrateBps: 240000, FPS: 10, AvgFramePackets: 3, AvgFrameBytes: 3000}
+	if got := stats.snapshot(); got.BitrateBps != want.BitrateBps || got.FPS != want.FPS || got.AvgFramePackets != want.AvgFramePackets || got.AvgFrameBytes != want.AvgFrameBytes || !got.LastIDR.Equal(idrAt) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	stats.add(1000, false, false, idrAt)
+	stats.roll(500 * time.Millisecond)
+	if got := stats.snapshot(); got.BitrateBps != 16000 || got.FPS != 0 || got.AvgFramePackets != 0 {
+		t.Fatalf("expected 16000 bps and no frames, got %+v", got)
+	}
+}
+
+// TestVideoProxyRateStatsInRawMode verifies that the rate pass runs without
+// fix mode while the fix pipeline counters stay put. Inputs: an IDR and a
+// non-IDR slice through analyzeFrameBoundaries with fix inactive, then a
+// window roll. The expected output is 2 fps and an IDR time, and no frames
+// started in the fix counters.
+func TestVideoProxyRateStatsInRawMode(t *testing.T) {
+	session := &Session{ID: "S-rate-raw"}
+	proxy := newVideoProxy(session, nil, nil, time.Second, time.Minute, false, false, VideoFixConfig{}, ProxyLogConfig{})
+	now := time.Now()
+
+	proxy.analyzeFrameBoundaries(makeRTPPacket(1, 9000, []byte{0x65}), now, false)
+	proxy.analyzeFrameBoundaries(makeRTPPacket(2, 12000, []byte{0x41}), now, false)
+	session.videoCounters.rate.roll(time.Second)
+
+	counters := snapshotVideoCounters(&session.videoCounters)
+	if counters.Rate.FPS != 2 || !counters.Rate.LastIDR.Equal(now) {
+		t.Fatalf("expected 2 fps and the IDR time, got %+v", counters.Rate)
+	}
+	if counters.VideoFramesStarted != 0 || session.videoCounters.videoKeyframes.Load() != 0 {
+		t.Fatalf("expected fix counters untouched, got frames=%d keyframes=%d", counters.VideoFramesStarted, session.videoCounters.videoKeyframes.Load())
+	}
+}